@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// artifacts.json is written to final-prerel / final-rel directory after every build
+// and describes every file we produced. Commands that run after the build
+// (upload, packaging, website update) read it instead of re-computing file names
+
+const artifactsManifestName = "artifacts.json"
+
+const (
+	kArtifactInstaller   = "installer"
+	kArtifactPortableExe = "portable-exe"
+	kArtifactPortableZip = "portable-zip"
+	kArtifactPortable7z  = "portable-7z"
+	kArtifactPdbZip      = "pdb-zip"
+	kArtifactPdbLzsa     = "pdb-lzsa"
+	kArtifactMsi         = "msi"
+	kArtifactMsix        = "msix"
+	kArtifactDeltaUpdate = "delta-update"
+	kArtifactDeltaIndex  = "delta-index"
+	kArtifactMinisig     = "minisig"
+	kArtifactGpgSig      = "gpg-sig"
+	kArtifactSbom        = "sbom"
+	kArtifactProvenance  = "provenance"
+	kArtifactTorrent     = "torrent"
+)
+
+// ArtifactInfo describes a single file produced by the build
+type ArtifactInfo struct {
+	// name of the file in final directory, also used as remote name when uploading
+	Name string `json:"name"`
+	// path relative to the top of the repo
+	Path    string `json:"path"`
+	Kind    string `json:"kind"`
+	Arch    string `json:"arch"` // "32", "64", "arm64" or "" if not arch-specific
+	Version string `json:"version"`
+	Size    int64  `json:"size"`
+	Sha256  string `json:"sha256"`
+	Signed  bool   `json:"signed"`
+	// only for torrents, to make magnet links
+	InfoHash string `json:"infoHash,omitempty"`
+}
+
+// ArtifactsManifest is content of artifacts.json
+type ArtifactsManifest struct {
+	BuildType BuildType       `json:"buildType"`
+	Version   string          `json:"version"`
+	GitSha1   string          `json:"gitSha1"`
+	BuiltOn   string          `json:"builtOn"`
+	Artifacts []*ArtifactInfo `json:"artifacts"`
+	// only in daily pre-release builds: previous pre-release and commits
+	// since then ("${sha1} ${subject}", newest first), see prerel_commits.go
+	PrevVersion string   `json:"prevVersion,omitempty"`
+	PrevGitSha1 string   `json:"prevGitSha1,omitempty"`
+	Commits     []string `json:"commits,omitempty"`
+}
+
+func fileSha256HexMust(path string) string {
+	f, err := os.Open(path)
+	must(err)
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	must(err)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sha256HexOfData(d []byte) string {
+	h := sha256.Sum256(d)
+	return hex.EncodeToString(h[:])
+}
+
+// SumatraPDF-prerel-64-install.exe => kArtifactInstaller
+func artifactKindFromName(name string) string {
+	switch {
+	case name == deltaUpdatesIndexName:
+		return kArtifactDeltaIndex
+	case strings.HasSuffix(name, minisignSigExt):
+		return kArtifactMinisig
+	case strings.HasSuffix(name, gpgSigExt):
+		return kArtifactGpgSig
+	case strings.HasSuffix(name, sbomSuffix):
+		return kArtifactSbom
+	case strings.HasSuffix(name, provenanceSuffix):
+		return kArtifactProvenance
+	case strings.HasSuffix(name, torrentSuffix):
+		return kArtifactTorrent
+	case strings.HasSuffix(name, ".bsdiff"):
+		return kArtifactDeltaUpdate
+	case strings.HasSuffix(name, "-install.exe"):
+		return kArtifactInstaller
+	case strings.HasSuffix(name, ".msi"):
+		return kArtifactMsi
+	case strings.HasSuffix(name, ".msix"):
+		return kArtifactMsix
+	case strings.HasSuffix(name, ".pdb.zip"):
+		return kArtifactPdbZip
+	case strings.HasSuffix(name, ".pdb.lzsa"):
+		return kArtifactPdbLzsa
+	case strings.HasSuffix(name, ".7z"):
+		return kArtifactPortable7z
+	case strings.HasSuffix(name, ".zip"):
+		return kArtifactPortableZip
+	case strings.HasSuffix(name, ".exe"):
+		return kArtifactPortableExe
+	}
+	panicIf(true, "unknown kind of artifact '%s'", name)
+	return ""
+}
+
+func artifactIsSignable(kind string) bool {
+	return kind == kArtifactInstaller || kind == kArtifactPortableExe || kind == kArtifactPortableZip || kind == kArtifactPortable7z || kind == kArtifactMsi || kind == kArtifactMsix
+}
+
+func getArtifactsManifestPath(dir string) string {
+	return filepath.Join(dir, artifactsManifestName)
+}
+
+// returns nil if the manifest doesn't exist
+func readArtifactsManifest(dir string) *ArtifactsManifest {
+	path := getArtifactsManifestPath(dir)
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var res ArtifactsManifest
+	err = json.Unmarshal(d, &res)
+	must(err)
+	return &res
+}
+
+func readArtifactsManifestMust(dir string) *ArtifactsManifest {
+	res := readArtifactsManifest(dir)
+	panicIf(res == nil, "'%s' doesn't exist, was the build done?", getArtifactsManifestPath(dir))
+	return res
+}
+
+func writeArtifactsManifestMust(dir string, am *ArtifactsManifest) {
+	sort.Slice(am.Artifacts, func(i, j int) bool {
+		return am.Artifacts[i].Name < am.Artifacts[j].Name
+	})
+	d, err := json.MarshalIndent(am, "", "  ")
+	must(err)
+	path := getArtifactsManifestPath(dir)
+	writeFileMust(path, d)
+	logf("wrote '%s' with %d artifacts\n", path, len(am.Artifacts))
+	writeSha256SumsMust(dir, am)
+}
+
+// pre-release builds are done one platform at a time so we add to
+// the existing manifest, if it's for the same version
+func addArtifactsToManifestMust(buildType BuildType, dir string, platform string, names []string) {
+	ver := getVerForBuildType(buildType)
+	am := readArtifactsManifest(dir)
+	if am == nil || am.Version != ver || am.BuildType != buildType {
+		am = &ArtifactsManifest{
+			BuildType: buildType,
+			Version:   ver,
+		}
+	}
+	am.GitSha1 = getGitSha1()
+	am.BuiltOn = time.Now().Format("2006-01-02")
+
+	// platform is "" for artifacts that cover all platforms
+	arch := ""
+	if platform != "" {
+		arch = getSuffixForPlatform(platform)
+	}
+	signed := canSign()
+	names = append(names, signDetachedMust(dir, names)...)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		kind := artifactKindFromName(name)
+		a := &ArtifactInfo{
+			Name:    name,
+			Path:    filepath.ToSlash(path),
+			Kind:    kind,
+			Arch:    arch,
+			Version: ver,
+			Size:    fileSizeMust(path),
+			Sha256:  fileSha256HexMust(path),
+			Signed:  signed && artifactIsSignable(kind),
+		}
+		am.Artifacts = removeArtifactWithName(am.Artifacts, name)
+		am.Artifacts = append(am.Artifacts, a)
+	}
+	writeArtifactsManifestMust(dir, am)
+}
+
+func removeArtifactWithName(a []*ArtifactInfo, name string) []*ArtifactInfo {
+	var res []*ArtifactInfo
+	for _, el := range a {
+		if el.Name != name {
+			res = append(res, el)
+		}
+	}
+	return res
+}
+
+func (am *ArtifactsManifest) find(kind string, arch string) *ArtifactInfo {
+	for _, a := range am.Artifacts {
+		if a.Kind == kind && a.Arch == arch {
+			return a
+		}
+	}
+	return nil
+}
+
+func getDownloadUrlsFromManifest(am *ArtifactsManifest, prefix string) *DownloadUrls {
+	url := func(kind string, arch string) string {
+		a := am.find(kind, arch)
+		if a == nil {
+			logf("getDownloadUrlsFromManifest: no artifact of kind '%s' for arch '%s'\n", kind, arch)
+			return ""
+		}
+		return prefix + a.Name
+	}
+	return &DownloadUrls{
+		installer64:      url(kArtifactInstaller, "64"),
+		portableExe64:    url(kArtifactPortableExe, "64"),
+		portableZip64:    url(kArtifactPortableZip, "64"),
+		installerArm64:   url(kArtifactInstaller, "arm64"),
+		portableExeArm64: url(kArtifactPortableExe, "arm64"),
+		portableZipArm64: url(kArtifactPortableZip, "arm64"),
+		installer32:      url(kArtifactInstaller, "32"),
+		portableExe32:    url(kArtifactPortableExe, "32"),
+		portableZip32:    url(kArtifactPortableZip, "32"),
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Go port of bsdiff 4.3 by Colin Percival (http://www.daemonology.net/bsdiff/)
+// used for delta updates.
+//
+// Patch format is gzip-compressed ENDSLEY/BSDIFF43 stream
+// (https://github.com/mendsley/bsdiff):
+//   - "ENDSLEY/BSDIFF43"
+//   - int64 size of new file
+//   - sequence of control blocks, each:
+//     x, y, z int64: add x bytes from diff block to x bytes from old file,
+//     copy y bytes from extra block, seek forward z bytes in old file
+//     followed by x bytes of diff block and y bytes of extra block
+//
+// int64 are encoded as 8 bytes little-endian magnitude with sign in
+// the top bit (offtout() in bsdiff.c), not two's complement.
+//
+// Unlike the original we compress with gzip instead of bzip2 because
+// Go's standard library can't write bzip2 and SumatraPDF already has zlib.
+
+const bsdiffMagic = "ENDSLEY/BSDIFF43"
+
+// suffix array construction from bsdiff.c, based on
+// "Faster Suffix Sorting" by N. Jesper Larsson and Kunihiko Sadakane
+func bsdiffSplit(I, V []int, start, n, h int) {
+	if n < 16 {
+		var j int
+		for k := start; k < start+n; k += j {
+			j = 1
+			x := V[I[k]+h]
+			for i := 1; k+i < start+n; i++ {
+				if V[I[k+i]+h] < x {
+					x = V[I[k+i]+h]
+					j = 0
+				}
+				if V[I[k+i]+h] == x {
+					I[k+j], I[k+i] = I[k+i], I[k+j]
+					j++
+				}
+			}
+			for i := 0; i < j; i++ {
+				V[I[k+i]] = k + j - 1
+			}
+			if j == 1 {
+				I[k] = -1
+			}
+		}
+		return
+	}
+
+	x := V[I[start+n/2]+h]
+	jj, kk := 0, 0
+	for i := start; i < start+n; i++ {
+		if V[I[i]+h] < x {
+			jj++
+		}
+		if V[I[i]+h] == x {
+			kk++
+		}
+	}
+	jj += start
+	kk += jj
+
+	i, j, k := start, 0, 0
+	for i < jj {
+		if V[I[i]+h] < x {
+			i++
+		} else if V[I[i]+h] == x {
+			I[i], I[jj+j] = I[jj+j], I[i]
+			j++
+		} else {
+			I[i], I[kk+k] = I[kk+k], I[i]
+			k++
+		}
+	}
+	for jj+j < kk {
+		if V[I[jj+j]+h] == x {
+			j++
+		} else {
+			I[jj+j], I[kk+k] = I[kk+k], I[jj+j]
+			k++
+		}
+	}
+
+	if jj > start {
+		bsdiffSplit(I, V, start, jj-start, h)
+	}
+	for i := 0; i < kk-jj; i++ {
+		V[I[jj+i]] = kk - 1
+	}
+	if jj == kk-1 {
+		I[jj] = -1
+	}
+	if start+n > kk {
+		bsdiffSplit(I, V, kk, start+n-kk, h)
+	}
+}
+
+// returns suffix array of old, with I[0] being the empty suffix
+func bsdiffQsufsort(old []byte) []int {
+	oldSize := len(old)
+	I := make([]int, oldSize+1)
+	V := make([]int, oldSize+1)
+
+	var buckets [256]int
+	for _, c := range old {
+		buckets[c]++
+	}
+	for i := 1; i < 256; i++ {
+		buckets[i] += buckets[i-1]
+	}
+	for i := 255; i > 0; i-- {
+		buckets[i] = buckets[i-1]
+	}
+	buckets[0] = 0
+
+	for i, c := range old {
+		buckets[c]++
+		I[buckets[c]] = i
+	}
+	I[0] = oldSize
+	for i, c := range old {
+		V[i] = buckets[c]
+	}
+	V[oldSize] = 0
+	for i := 1; i < 256; i++ {
+		if buckets[i] == buckets[i-1]+1 {
+			I[buckets[i]] = -1
+		}
+	}
+	I[0] = -1
+
+	for h := 1; I[0] != -(oldSize + 1); h += h {
+		n := 0
+		i := 0
+		for i < oldSize+1 {
+			if I[i] < 0 {
+				n -= I[i]
+				i -= I[i]
+			} else {
+				if n != 0 {
+					I[i-n] = -n
+				}
+				n = V[I[i]] + 1 - i
+				bsdiffSplit(I, V, i, n, h)
+				i += n
+				n = 0
+			}
+		}
+		if n != 0 {
+			I[i-n] = -n
+		}
+	}
+
+	for i := 0; i < oldSize+1; i++ {
+		I[V[i]] = i
+	}
+	return I
+}
+
+func bsdiffMatchLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// finds the longest prefix of new that matches a suffix of old, returns
+// its position in old and length
+func bsdiffSearch(I []int, old, new []byte, st, en int) (int, int) {
+	for en-st >= 2 {
+		x := st + (en-st)/2
+		a := old[I[x]:]
+		n := min(len(a), len(new))
+		if bytes.Compare(a[:n], new[:n]) < 0 {
+			st = x
+		} else {
+			en = x
+		}
+	}
+	x := bsdiffMatchLen(old[I[st]:], new)
+	y := bsdiffMatchLen(old[I[en]:], new)
+	if x > y {
+		return I[st], x
+	}
+	return I[en], y
+}
+
+func bsdiffWriteInt(w io.Writer, x int) error {
+	var buf [8]byte
+	y := uint64(x)
+	if x < 0 {
+		y = uint64(-x) | (1 << 63)
+	}
+	binary.LittleEndian.PutUint64(buf[:], y)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func bsdiffReadInt(r io.Reader) (int, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	y := binary.LittleEndian.Uint64(buf[:])
+	x := int(y &^ (1 << 63))
+	if y&(1<<63) != 0 {
+		x = -x
+	}
+	return x, nil
+}
+
+// returns gzip-compressed patch that transforms old into new
+func bsdiff(old, new []byte) ([]byte, error) {
+	I := bsdiffQsufsort(old)
+	oldSize, newSize := len(old), len(new)
+
+	var res bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&res, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	w := &errWriter{w: zw}
+	w.Write([]byte(bsdiffMagic))
+	w.writeInt(newSize)
+
+	var db []byte
+	scan, pos, n := 0, 0, 0
+	lastScan, lastPos, lastOffset := 0, 0, 0
+	for scan < newSize {
+		oldScore := 0
+		scan += n
+		scsc := scan
+		for ; scan < newSize; scan++ {
+			pos, n = bsdiffSearch(I, old, new[scan:], 0, oldSize)
+			for ; scsc < scan+n; scsc++ {
+				if scsc+lastOffset < oldSize && old[scsc+lastOffset] == new[scsc] {
+					oldScore++
+				}
+			}
+			if (n == oldScore && n != 0) || n > oldScore+8 {
+				break
+			}
+			if scan+lastOffset < oldSize && old[scan+lastOffset] == new[scan] {
+				oldScore--
+			}
+		}
+
+		if n == oldScore && scan != newSize {
+			continue
+		}
+
+		s, sf, lenf := 0, 0, 0
+		for i := 0; lastScan+i < scan && lastPos+i < oldSize; {
+			if old[lastPos+i] == new[lastScan+i] {
+				s++
+			}
+			i++
+			if s*2-i > sf*2-lenf {
+				sf = s
+				lenf = i
+			}
+		}
+
+		lenb := 0
+		if scan < newSize {
+			s, sb := 0, 0
+			for i := 1; scan >= lastScan+i && pos >= i; i++ {
+				if old[pos-i] == new[scan-i] {
+					s++
+				}
+				if s*2-i > sb*2-lenb {
+					sb = s
+					lenb = i
+				}
+			}
+		}
+
+		if lastScan+lenf > scan-lenb {
+			overlap := (lastScan + lenf) - (scan - lenb)
+			s, ss, lens := 0, 0, 0
+			for i := 0; i < overlap; i++ {
+				if new[lastScan+lenf-overlap+i] == old[lastPos+lenf-overlap+i] {
+					s++
+				}
+				if new[scan-lenb+i] == old[pos-lenb+i] {
+					s--
+				}
+				if s > ss {
+					ss = s
+					lens = i + 1
+				}
+			}
+			lenf += lens - overlap
+			lenb -= lens
+		}
+
+		extraLen := (scan - lenb) - (lastScan + lenf)
+		w.writeInt(lenf)
+		w.writeInt(extraLen)
+		w.writeInt((pos - lenb) - (lastPos + lenf))
+		db = db[:0]
+		for i := 0; i < lenf; i++ {
+			db = append(db, new[lastScan+i]-old[lastPos+i])
+		}
+		w.Write(db)
+		w.Write(new[lastScan+lenf : lastScan+lenf+extraLen])
+
+		lastScan = scan - lenb
+		lastPos = pos - lenb
+		lastOffset = pos - scan
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return res.Bytes(), nil
+}
+
+var errCorruptPatch = errors.New("corrupt patch")
+
+// applies patch created by bsdiff() to old
+func bspatch(old []byte, patch []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(patch))
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(bsdiffMagic))
+	if _, err = io.ReadFull(zr, magic); err != nil || string(magic) != bsdiffMagic {
+		return nil, errCorruptPatch
+	}
+	newSize, err := bsdiffReadInt(zr)
+	if err != nil || newSize < 0 {
+		return nil, errCorruptPatch
+	}
+	new := make([]byte, newSize)
+	oldPos, newPos := 0, 0
+	for newPos < newSize {
+		var ctrl [3]int
+		for i := range ctrl {
+			if ctrl[i], err = bsdiffReadInt(zr); err != nil {
+				return nil, errCorruptPatch
+			}
+		}
+		if ctrl[0] < 0 || ctrl[1] < 0 || newPos+ctrl[0] > newSize {
+			return nil, errCorruptPatch
+		}
+		if _, err = io.ReadFull(zr, new[newPos:newPos+ctrl[0]]); err != nil {
+			return nil, errCorruptPatch
+		}
+		for i := 0; i < ctrl[0]; i++ {
+			if oldPos+i >= 0 && oldPos+i < len(old) {
+				new[newPos+i] += old[oldPos+i]
+			}
+		}
+		newPos += ctrl[0]
+		oldPos += ctrl[0]
+		if newPos+ctrl[1] > newSize {
+			return nil, errCorruptPatch
+		}
+		if _, err = io.ReadFull(zr, new[newPos:newPos+ctrl[1]]); err != nil {
+			return nil, errCorruptPatch
+		}
+		newPos += ctrl[1]
+		oldPos += ctrl[2]
+	}
+	return new, nil
+}
+
+// remembers the first error so that we don't have to check every write
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(d []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(d)
+	w.err = err
+	return n, err
+}
+
+func (w *errWriter) writeInt(x int) {
+	if w.err == nil {
+		w.err = bsdiffWriteInt(w.w, x)
+	}
+}
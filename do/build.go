@@ -49,6 +49,7 @@ func getFileNamesWithPrefix(prefix string) [][]string {
 	files := [][]string{
 		{"SumatraPDF.exe", fmt.Sprintf("%s.exe", prefix)},
 		{"SumatraPDF.zip", fmt.Sprintf("%s.zip", prefix)},
+		{"SumatraPDF.7z", fmt.Sprintf("%s.7z", prefix)},
 		{"SumatraPDF-dll.exe", fmt.Sprintf("%s-install.exe", prefix)},
 		{"SumatraPDF.pdb.zip", fmt.Sprintf("%s.pdb.zip", prefix)},
 		{"SumatraPDF.pdb.lzsa", fmt.Sprintf("%s.pdb.lzsa", prefix)},
@@ -56,7 +57,9 @@ func getFileNamesWithPrefix(prefix string) [][]string {
 	return files
 }
 
-func copyBuiltFiles(dstDir string, srcDir string, prefix string) {
+// returns names of files copied to dstDir
+func copyBuiltFiles(dstDir string, srcDir string, prefix string) []string {
+	var res []string
 	files := getFileNamesWithPrefix(prefix)
 	for _, f := range files {
 		srcName := f[0]
@@ -66,10 +69,12 @@ func copyBuiltFiles(dstDir string, srcDir string, prefix string) {
 		must(createDirForFile(dstPath))
 		if fileExists(srcPath) {
 			must(copyFile(dstPath, srcPath))
+			res = append(res, dstName)
 		} else {
 			logf("Skipping copying '%s'\n", srcPath)
 		}
 	}
+	return res
 }
 
 func copyBuiltManifest(dstDir string, prefix string) {
@@ -126,7 +131,7 @@ func cleanPreserveSettings() {
 			name := e2.Name()
 			path2 := filepath.Join(path, name)
 			// delete everything except those files
-			excluded := (name == "sumatrapdfcache") || (name == "SumatraPDF-settings.txt") || strings.Contains(name, "asan_dynamic")
+			excluded := (name == "sumatrapdfcache") || (name == "SumatraPDF-settings.txt") || strings.Contains(name, "asan_dynamic") || (name == warningsCountFileName)
 			if excluded {
 				nSkipped++
 				continue
@@ -144,16 +149,16 @@ func cleanPreserveSettings() {
 }
 
 func cleanReleaseBuilds() {
-	os.RemoveAll(rel32Dir)
-	os.RemoveAll(rel64Dir)
-	os.RemoveAll(relArm64Dir)
-	os.RemoveAll(finalPreRelDir)
+	for _, dir := range []string{rel32Dir, rel64Dir, relArm64Dir, finalPreRelDir} {
+		if !dirExists(dir) || dryRunSkip("delete '%s'", dir) {
+			continue
+		}
+		os.RemoveAll(dir)
+	}
 }
 
 func runTestUtilMust(dir string) {
-	cmd := exec.Command(`.\test_util.exe`)
-	cmd.Dir = dir
-	runCmdLoggedMust(cmd)
+	runTestUtilWithResultsMust(dir)
 }
 
 func buildLzsa() {
@@ -188,6 +193,8 @@ func setBuildConfigPreRelease() {
 	s := getBuildConfigCommon()
 	preRelVer := getPreReleaseVer()
 	s += fmt.Sprintf("#define PRE_RELEASE_VER %s\n", preRelVer)
+	// release builds don't send minidumps
+	s += getCrashSubmitBuildConfig()
 	writeFileMust(buildConfigPath(), []byte(s))
 }
 
@@ -224,19 +231,6 @@ func addZipFileMust(w *zip.Writer, path string) {
 	addZipFileWithNameMust(w, path, nameInZip)
 }
 
-func createExeZipWithGoWithNameMust(dir, nameInZip string) {
-	zipPath := filepath.Join(dir, "SumatraPDF.zip")
-	os.Remove(zipPath) // called multiple times during upload
-	f, err := os.Create(zipPath)
-	must(err)
-	defer f.Close()
-	zw := zip.NewWriter(f)
-	path := filepath.Join(dir, "SumatraPDF.exe")
-	addZipFileWithNameMust(zw, path, nameInZip)
-	err = zw.Close()
-	must(err)
-}
-
 // func createExeZipWithPigz(dir string) {
 // 	srcFile := "SumatraPDF.exe"
 // 	srcPath := filepath.Join(dir, srcFile)
@@ -275,8 +269,9 @@ func createPdbZipMust(dir string) {
 	defer f.Close()
 	w := zip.NewWriter(f)
 
+	pdbDir := getPdbDirForDistribution(dir)
 	for _, file := range pdbFiles {
-		addZipFileMust(w, filepath.Join(dir, file))
+		addZipFileMust(w, filepath.Join(pdbDir, file))
 	}
 
 	err = w.Close()
@@ -285,7 +280,14 @@ func createPdbZipMust(dir string) {
 
 func createPdbLzsaMust(dir string) {
 	args := []string{"SumatraPDF.pdb.lzsa"}
-	args = append(args, pdbFiles...)
+	if flgStripPdbs {
+		// "pdb-stripped\libmupdf.pdb:libmupdf.pdb" : name in archive after ':'
+		for _, file := range pdbFiles {
+			args = append(args, filepath.Join(strippedPdbDirName, file)+":"+file)
+		}
+	} else {
+		args = append(args, pdbFiles...)
+	}
 	curDir, err := os.Getwd()
 	must(err)
 	makeLzsaPath := filepath.Join(curDir, "bin", "MakeLZSA.exe")
@@ -355,7 +357,7 @@ func signFilesMust(dir string) {
 }
 
 func signFilesOptional(dir string) {
-	if !hasCertPwd() {
+	if !canSign() {
 		return
 	}
 	signFilesMust(dir)
@@ -401,12 +403,18 @@ func build(config, platform string, sign bool) {
 		runTestUtilMust(dir)
 	}
 
-	runExeLoggedMust(msbuildPath, slnPath, `/t:SumatraPDF:Rebuild;SumatraPDF-dll:Rebuild;PdfFilter:Rebuild;PdfPreview:Rebuild`, p, `/m`)
+	warnLog := getMsbuildWarningsLogArg(platform)
+	runExeLoggedMust(msbuildPath, slnPath, `/t:SumatraPDF:Rebuild;SumatraPDF-dll:Rebuild;PdfFilter:Rebuild;PdfPreview:Rebuild`, p, `/m`, warnLog)
+	checkMinOsMust(dir, platform)
 	if sign {
 		signFilesMust(dir)
 	}
+	sourceIndexPdbsInDirMust(dir)
 	createPdbZipMust(dir)
 	createPdbLzsaMust(dir)
+	if flgStripPdbs {
+		printPdbSizes(dir)
+	}
 }
 
 // builds more targets, even those not used, to prevent code rot
@@ -423,12 +431,18 @@ func buildAll(config, platform string, sign bool) {
 		runTestUtilMust(dir)
 	}
 
-	runExeLoggedMust(msbuildPath, slnPath, `/t:signfile:Rebuild;sizer:Rebuild;PdfFilter:Rebuild;plugin-test:Rebuild;PdfPreview:Rebuild;PdfPreviewTest:Rebuild;SumatraPDF:Rebuild;SumatraPDF-dll:Rebuild`, p, `/m`)
+	warnLog := getMsbuildWarningsLogArg(platform)
+	runExeLoggedMust(msbuildPath, slnPath, `/t:signfile:Rebuild;sizer:Rebuild;PdfFilter:Rebuild;plugin-test:Rebuild;PdfPreview:Rebuild;PdfPreviewTest:Rebuild;SumatraPDF:Rebuild;SumatraPDF-dll:Rebuild`, p, `/m`, warnLog)
+	checkMinOsMust(dir, platform)
 	if sign {
 		signFilesMust(dir)
 	}
+	sourceIndexPdbsInDirMust(dir)
 	createPdbZipMust(dir)
 	createPdbLzsaMust(dir)
+	if flgStripPdbs {
+		printPdbSizes(dir)
+	}
 }
 
 func getSuffixForPlatform(platform string) string {
@@ -446,17 +460,25 @@ func getSuffixForPlatform(platform string) string {
 
 func buildCiDaily(opts *BuildOptions) {
 	if opts.upload {
-		isUploaded := isBuildAlreadyUploaded(newMinioBackblazeClient(), buildTypePreRel)
+		isUploaded := isBuildAlreadyUploaded(newBackblazeStorage(), buildTypePreRel)
 		if isUploaded {
 			logf("buildCiDaily: skipping build because already built and uploaded")
 			return
 		}
 	}
+	prev := findLastUploadedPreRel()
+	if prev != nil && prev.GitSha1 == getGitSha1() {
+		logf("buildCiDaily: skipping build because no new commits since pre-release %s\n", prev.Version)
+		return
+	}
 
 	cleanReleaseBuilds()
 	buildPreRelease(kPlatformArm64, false)
 	buildPreRelease(kPlatformIntel32, false)
 	buildPreRelease(kPlatformIntel64, false)
+	scanWithVirusTotalMust(buildTypePreRel)
+	fuzzRegressMust()
+	addPreRelCommitsMust(prev)
 }
 
 func buildCi() {
@@ -468,6 +490,7 @@ func buildCi() {
 		// and build all projects, to find regressions in code
 		// I'm not regularly building while developing
 		buildPreRelease(kPlatformIntel32, true)
+		scanWithVirusTotalMust(buildTypePreRel)
 	case githubEventTypeCodeQL:
 		// code ql is just a regular build, I assume intercepted by
 		// by their tooling
@@ -497,16 +520,19 @@ func buildPreRelease(platform string, all bool) {
 	suffix := getSuffixForPlatform(platform)
 	outDir := getOutDirForPlatform(platform)
 	nameInZip := fmt.Sprintf("SumatraPDF-prerel-%s-%s.exe", ver, suffix)
-	createExeZipWithGoWithNameMust(outDir, nameInZip)
+	createPortableArchivesMust(outDir, nameInZip, buildTypePreRel, platform)
 
 	createManifestMust()
 
 	dstDir := getFinalDirForBuildType(buildTypePreRel)
 	prefix := "SumatraPDF-prerel"
-	copyBuiltFiles(dstDir, outDir, prefix+"-"+suffix)
+	names := copyBuiltFiles(dstDir, outDir, prefix+"-"+suffix)
+	addArtifactsToManifestMust(buildTypePreRel, dstDir, platform, names)
 	copyBuiltManifest(dstDir, prefix)
+	genProvenanceMust(buildTypePreRel)
 }
 
+// only builds, the rest of the release is done in steps, see getReleaseSteps()
 func buildRelease() {
 	// make sure we can sign the executables, early exit if missing
 	detectSigntoolPath()
@@ -515,8 +541,9 @@ func buildRelease() {
 	s := fmt.Sprintf("buidling release version %s", ver)
 	defer makePrintDuration(s)()
 
-	verifyBuildNotInStorageMust(newMinioR2Client(), buildTypeRel)
-	verifyBuildNotInStorageMust(newMinioBackblazeClient(), buildTypeRel)
+	for _, storage := range getStorageBackends() {
+		verifyBuildNotInStorageMust(storage, buildTypeRel)
+	}
 
 	cleanReleaseBuilds()
 	setBuildConfigRelease()
@@ -524,23 +551,26 @@ func buildRelease() {
 
 	build("Release", kPlatformIntel32, true)
 	nameInZip := fmt.Sprintf("SumatraPDF-%s-32.exe", ver)
-	createExeZipWithGoWithNameMust(rel32Dir, nameInZip)
+	createPortableArchivesMust(rel32Dir, nameInZip, buildTypeRel, kPlatformIntel32)
 
 	build("Release", kPlatformIntel64, true)
 	nameInZip = fmt.Sprintf("SumatraPDF-%s-64.exe", ver)
-	createExeZipWithGoWithNameMust(rel64Dir, nameInZip)
+	createPortableArchivesMust(rel64Dir, nameInZip, buildTypeRel, kPlatformIntel64)
 
 	build("Release", kPlatformArm64, true)
 	nameInZip = fmt.Sprintf("SumatraPDF-%s-arm64.exe", ver)
-	createExeZipWithGoWithNameMust(relArm64Dir, nameInZip)
+	createPortableArchivesMust(relArm64Dir, nameInZip, buildTypeRel, kPlatformArm64)
 
 	createManifestMust()
 
 	dstDir := getFinalDirForBuildType(buildTypeRel)
 	prefix := fmt.Sprintf("SumatraPDF-%s", ver)
-	copyBuiltFiles(dstDir, rel32Dir, prefix)
-	copyBuiltFiles(dstDir, rel64Dir, prefix+"-64")
-	copyBuiltFiles(dstDir, relArm64Dir, prefix+"-arm64")
+	names := copyBuiltFiles(dstDir, rel32Dir, prefix)
+	addArtifactsToManifestMust(buildTypeRel, dstDir, kPlatformIntel32, names)
+	names = copyBuiltFiles(dstDir, rel64Dir, prefix+"-64")
+	addArtifactsToManifestMust(buildTypeRel, dstDir, kPlatformIntel64, names)
+	names = copyBuiltFiles(dstDir, relArm64Dir, prefix+"-arm64")
+	addArtifactsToManifestMust(buildTypeRel, dstDir, kPlatformArm64, names)
 	copyBuiltManifest(dstDir, prefix)
 }
 
@@ -577,6 +607,15 @@ func buildJustPortableExe(dir, config, platform string) {
 	signFilesOptional(dir)
 }
 
+func buildEngineDump() {
+	msbuildPath := detectMsbuildPath()
+	slnPath := filepath.Join("vs2022", "SumatraPDF.sln")
+
+	config := "Release"
+	p := fmt.Sprintf(`/p:Configuration=%s;Platform=%s`, config, kPlatformIntel64)
+	runExeLoggedMust(msbuildPath, slnPath, `/t:enginedump`, p, `/m`)
+}
+
 func buildTestUtil() {
 	msbuildPath := detectMsbuildPath()
 	slnPath := filepath.Join("vs2022", "SumatraPDF.sln")
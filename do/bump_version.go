@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// -bump-version ${ver} changes version of the program. Everything else
+// (installer, msi, msix, winget etc.) gets the version from src/Version.h
+// at build time so we only update:
+//   - CURR_VERSION and CURR_VERSION_COMMA in src/Version.h
+//   - version we're working on in docs/releasenotes.txt
+//
+// All files are changed or none are. After the change we check that
+// no file in the tree (except our dependencies and history of changes)
+// still has the old version in a version definition.
+
+// "3.6" => "3,6,0"
+func versionToComma(ver string) string {
+	parts := strings.Split(ver, ".")
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	return strings.Join(parts, ",")
+}
+
+// VersionEdit is an edit of a file done by -bump-version
+type VersionEdit struct {
+	Path string
+	// changes f, returns error if it can't find the version
+	Update func(f *TextFile, oldVer, newVer string) error
+}
+
+var versionEdits = []*VersionEdit{
+	{filepath.Join("src", "Version.h"), updateVersionH},
+	{releaseNotesTxtPath, updateReleaseNotesTxtVersion},
+}
+
+// replaces the value of #define, which must be present
+func setDefine(f *TextFile, name string, val string) error {
+	prefix := "#define " + name + " "
+	for i, l := range f.Lines {
+		if strings.HasPrefix(l, prefix) {
+			f.Lines[i] = prefix + val
+			return nil
+		}
+	}
+	return fmt.Errorf("didn't find '%s'", strings.TrimSpace(prefix))
+}
+
+func updateVersionH(f *TextFile, oldVer, newVer string) error {
+	if err := setDefine(f, "CURR_VERSION", newVer); err != nil {
+		return err
+	}
+	return setDefine(f, "CURR_VERSION_COMMA", versionToComma(newVer))
+}
+
+// version we're working on is the first, undated, version after "Next version:"
+// If the old version is already released (has a date) we start a new section
+func updateReleaseNotesTxtVersion(f *TextFile, oldVer, newVer string) error {
+	for i, l := range f.Lines {
+		if !rxReleaseNotesTxtVer.MatchString(l) {
+			continue
+		}
+		if strings.TrimSpace(l) == newVer {
+			return nil
+		}
+		if strings.TrimSpace(l) == oldVer {
+			f.Lines[i] = newVer
+			return nil
+		}
+		f.Lines = replaceLines(f.Lines, i, i, []string{newVer, ""})
+		return nil
+	}
+	return fmt.Errorf("didn't find any version")
+}
+
+// files that can mention old versions: dependencies and history of changes
+func isExcludedFromStaleVersionCheck(path string) bool {
+	for _, prefix := range []string{"ext/", "mupdf/", "translations/", "tools/logview-win/frontend/", "docs/md/Version-history.md", "docs/releasenotes.txt"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns "path:line: text" for lines in files tracked by git that define
+// oldVer e.g. "#define CURR_VERSION 3.5", "version: 3.5", "Version="3.5""
+// or have its comma form "3,5,0". "since 3.5" etc. is fine
+func findStaleVersionsMust(oldVer string) []string {
+	rxDef := regexp.MustCompile(`(?i)(CURR_VERSION|\bversion)["']?\s*[:=]?\s*["']?` + regexp.QuoteMeta(oldVer) + `(["'\s,;<]|$)`)
+	comma := []byte(versionToComma(oldVer))
+	var res []string
+	out := runExeMust("git", "ls-files")
+	for _, path := range toTrimmedLines(out) {
+		if isExcludedFromStaleVersionCheck(path) {
+			continue
+		}
+		d, err := os.ReadFile(filepath.FromSlash(path))
+		if err != nil || bytes.IndexByte(d, 0) != -1 {
+			// deleted or binary
+			continue
+		}
+		if !bytes.Contains(d, []byte(oldVer)) && !bytes.Contains(d, comma) {
+			continue
+		}
+		for i, l := range strings.Split(normalizeNewlines(string(d)), "\n") {
+			if rxDef.MatchString(l) || strings.Contains(l, string(comma)) {
+				res = append(res, fmt.Sprintf("%s:%d: %s", path, i+1, strings.TrimSpace(l)))
+			}
+		}
+	}
+	return res
+}
+
+func bumpVersionMust(newVer string) {
+	newVer = strings.TrimPrefix(newVer, "v")
+	panicIf(newVer == "", "usage: -bump-version ${ver} e.g. -bump-version 3.6")
+	verifyCorrectVersionMust(newVer)
+	oldVer := extractSumatraVersionMust()
+	panicIf(compareVersions(newVer, oldVer) <= 0, "new version %s must be higher than current version %s", newVer, oldVer)
+
+	// prepare all changes before writing anything
+	var files []*TextFile
+	var origs [][]byte
+	for _, e := range versionEdits {
+		origs = append(origs, readFileMust(e.Path))
+		f := readTextFileMust(e.Path)
+		err := e.Update(f, oldVer, newVer)
+		panicIf(err != nil, "-bump-version: '%s': %s", e.Path, err)
+		files = append(files, f)
+	}
+	nWritten := 0
+	defer func() {
+		if nWritten == len(versionEdits) {
+			return
+		}
+		// undo changes to files we already wrote
+		for i := 0; i < nWritten; i++ {
+			writeFileMust(versionEdits[i].Path, origs[i])
+		}
+	}()
+	for i, e := range versionEdits {
+		writeTextFileMust(e.Path, files[i])
+		nWritten++
+		logf("updated '%s'\n", e.Path)
+	}
+
+	got := extractSumatraVersionMust()
+	panicIf(got != newVer, "version in src/Version.h is '%s' after update, expected '%s'", got, newVer)
+	stale := findStaleVersionsMust(oldVer)
+	panicIf(len(stale) > 0, "-bump-version: old version %s is still in:\n%s", oldVer, strings.Join(stale, "\n"))
+	logf("bumped version from %s to %s\n", oldVer, newVer)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// www.sumatrapdfreader.org is behind Cloudflare which caches files for hours.
+// After we change what's "latest" (upload, promote, rollback) we purge
+// urls that changed so that users and auto-update don't get a stale version:
+//   - pages with download links and update check files on the website
+//   - "latest" files in storage, if served from our domain
+//
+// Needs CLOUDFLARE_ZONE_ID and CLOUDFLARE_API_TOKEN (with Cache Purge permission).
+// Run by hand with: -purge-cdn [rel|prerel]
+
+var (
+	cloudflareZoneID   string
+	cloudflareAPIToken string
+)
+
+const (
+	cdnZoneDomain = "sumatrapdfreader.org"
+	cdnWebsiteURL = "https://www.sumatrapdfreader.org/"
+	// max number of urls in one purge request
+	cdnPurgeBatchSize = 30
+)
+
+// website urls that change when a new build of buildType becomes latest
+func getCdnWebsitePaths(buildType BuildType) []string {
+	if buildType == buildTypePreRel {
+		return []string{
+			"prerelease",
+			"prerelease.html",
+			"updatecheck-pre-release.txt",
+		}
+	}
+	return []string{
+		"",
+		"download-free-pdf-viewer",
+		"download-free-pdf-viewer.html",
+		"update-check-rel.txt",
+		"sha256sums-rel.js",
+		"releases.xml",
+		"versions.json",
+	}
+}
+
+// storage files that change when a new build of buildType becomes latest
+func getCdnStoragePaths(buildType BuildType) []string {
+	res := append([]string{}, getRemotePaths(buildType)...)
+	res = append(res, updateManifestRemotePath, updateManifestRemotePath+".sig")
+	if buildType == buildTypeRel {
+		res = append(res, "sumatrapdf/sumpdf-update.txt", "sumatrapdf/sumpdf-latest.txt")
+	}
+	return res
+}
+
+func isURLInCdnZone(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return u.Hostname() == cdnZoneDomain || strings.HasSuffix(u.Hostname(), "."+cdnZoneDomain)
+}
+
+func getCdnURLsToPurge(buildType BuildType) []string {
+	var res []string
+	for _, p := range getCdnWebsitePaths(buildType) {
+		res = append(res, cdnWebsiteURL+p)
+	}
+	seen := map[string]bool{}
+	for _, storage := range getStorageBackends() {
+		for _, p := range getCdnStoragePaths(buildType) {
+			uri := storage.URLForPath(p)
+			if !seen[uri] && isURLInCdnZone(uri) {
+				seen[uri] = true
+				res = append(res, uri)
+			}
+		}
+	}
+	return res
+}
+
+// https://developers.cloudflare.com/api/operations/zone-purge
+func cloudflarePurgeURLs(urls []string) error {
+	if dryRunSkip("purge %d urls from Cloudflare cache", len(urls)) {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{"files": urls})
+	must(err)
+	uri := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/purge_cache", cloudflareZoneID)
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(body))
+	must(err)
+	req.Header.Set("Authorization", "Bearer "+cloudflareAPIToken)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: time.Minute}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	d, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	var res struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err = json.Unmarshal(d, &res); err != nil || !res.Success {
+		return fmt.Errorf("purge failed with status code %d, body: '%s'", rsp.StatusCode, string(d))
+	}
+	return nil
+}
+
+func purgeCdnURLsMust(urls []string) {
+	for len(urls) > 0 {
+		n := min(len(urls), cdnPurgeBatchSize)
+		must(cloudflarePurgeURLs(urls[:n]))
+		for _, uri := range urls[:n] {
+			logf("purged '%s'\n", uri)
+		}
+		urls = urls[n:]
+	}
+}
+
+// stale files are annoying but not worth failing the upload over
+func purgeCdnCache(buildType BuildType) {
+	if cloudflareZoneID == "" || cloudflareAPIToken == "" {
+		logf("Not purging CDN cache because CLOUDFLARE_ZONE_ID or CLOUDFLARE_API_TOKEN env variable not set\n")
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			logf("purgeCdnCache: failed with '%v'\n", r)
+		}
+	}()
+	purgeCdnURLsMust(getCdnURLsToPurge(buildType))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// -update-changelog ${ver} [path] takes finalized release notes (by default
+// the draft from -gen-release-notes, after editing) and puts them in:
+//   - docs/md/Version-history.md : source of the manual and the website
+//     (see gen_docs.go), winget and chocolatey read notes from there
+//   - docs/releasenotes.txt : notes we keep while working on the next version
+//   - out/release-notes-${ver}-github.md : body of GitHub release
+//
+// Re-running replaces the section for ${ver} so that the copies stay in sync
+// after fixing the notes.
+
+var (
+	versionHistoryPath  = filepath.Join("docs", "md", "Version-history.md")
+	releaseNotesTxtPath = filepath.Join("docs", "releasenotes.txt")
+)
+
+// ReleaseNotes is a parsed release notes file
+type ReleaseNotes struct {
+	Version string
+	// date of release e.g. "2023-10-25"
+	Date string
+	// markdown without the "### ${ver}" header
+	Body string
+}
+
+var rxNotesComment = regexp.MustCompile(`(?s)<!--.*?-->\s*`)
+
+func readReleaseNotesMust(ver string, path string) *ReleaseNotes {
+	d, err := os.ReadFile(path)
+	must(err)
+	s := normalizeNewlines(string(d))
+	s = rxNotesComment.ReplaceAllString(s, "")
+	res := &ReleaseNotes{
+		Version: ver,
+		Date:    time.Now().Format("2006-01-02"),
+	}
+	rxHdr := regexp.MustCompile(`^### ` + regexp.QuoteMeta(ver) + `(?:\s+\((\d{4}-\d{2}-\d{2})\))?\s*$`)
+	lines := strings.Split(s, "\n")
+	if len(lines) > 0 {
+		if m := rxHdr.FindStringSubmatch(lines[0]); m != nil {
+			if m[1] != "" {
+				res.Date = m[1]
+			}
+			lines = lines[1:]
+		}
+	}
+	res.Body = strings.TrimSpace(strings.Join(lines, "\n"))
+	panicIf(res.Body == "", "release notes in '%s' are empty", path)
+	return res
+}
+
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+// TextFile is a text file split into lines. We remember line ending, BOM
+// and if there's a newline at the end to write it back without changing them
+type TextFile struct {
+	Lines    []string
+	EOL      string
+	BOM      string
+	FinalEOL bool
+}
+
+func readTextFileMust(path string) *TextFile {
+	d := readFileMust(path)
+	res := &TextFile{EOL: "\n"}
+	if bytes.HasPrefix(d, []byte("\xef\xbb\xbf")) {
+		res.BOM = "\xef\xbb\xbf"
+		d = d[3:]
+	}
+	if bytes.Contains(d, []byte("\r\n")) {
+		res.EOL = "\r\n"
+	}
+	s := normalizeNewlines(string(d))
+	res.FinalEOL = strings.HasSuffix(s, "\n")
+	res.Lines = strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	return res
+}
+
+func writeTextFileMust(path string, f *TextFile) {
+	s := f.BOM + strings.Join(f.Lines, f.EOL)
+	if f.FinalEOL {
+		s += f.EOL
+	}
+	writeFileMust(path, []byte(s))
+}
+
+// replaces lines [start, end) with repl
+func replaceLines(lines []string, start int, end int, repl []string) []string {
+	var res []string
+	res = append(res, lines[:start]...)
+	res = append(res, repl...)
+	return append(res, lines[end:]...)
+}
+
+// returns [start, end) of section whose header matches rx, end is the start
+// of the next section (line for which isNextSection returns true).
+// start is -1 if not found
+func findSection(lines []string, rx *regexp.Regexp, isNextSection func(string) bool) (int, int) {
+	start := -1
+	for i, l := range lines {
+		if start == -1 {
+			if rx.MatchString(l) {
+				start = i
+			}
+			continue
+		}
+		if isNextSection(l) {
+			return start, i
+		}
+	}
+	if start == -1 {
+		return -1, -1
+	}
+	return start, len(lines)
+}
+
+func isVersionHistorySection(l string) bool {
+	return strings.HasPrefix(l, "### ")
+}
+
+var rxVersionHistoryVer = regexp.MustCompile(`^### \d+(\.\d+)*\b`)
+
+func updateVersionHistoryMust(notes *ReleaseNotes) {
+	f := readTextFileMust(versionHistoryPath)
+	lines := f.Lines
+	section := []string{fmt.Sprintf("### %s (%s)", notes.Version, notes.Date), ""}
+	section = append(section, strings.Split(notes.Body, "\n")...)
+	section = append(section, "")
+
+	rxVer := regexp.MustCompile(`^### ` + regexp.QuoteMeta(notes.Version) + `(\s|$)`)
+	start, end := findSection(lines, rxVer, isVersionHistorySection)
+	if start != -1 {
+		lines = replaceLines(lines, start, end, section)
+	} else {
+		// released changes are no longer "next" so we only keep
+		// the intro text of that section
+		start, end = findSection(lines, regexp.MustCompile(`^### next\s*$`), isVersionHistorySection)
+		if start != -1 {
+			var keep []string
+			for _, l := range lines[start:end] {
+				if !strings.HasPrefix(strings.TrimSpace(l), "* ") && !strings.HasPrefix(strings.TrimSpace(l), "- ") {
+					keep = append(keep, l)
+				}
+			}
+			for len(keep) > 1 && keep[len(keep)-1] == "" && keep[len(keep)-2] == "" {
+				keep = keep[:len(keep)-1]
+			}
+			lines = replaceLines(lines, start, end, keep)
+		}
+		// newest version is first
+		pos, _ := findSection(lines, rxVersionHistoryVer, isVersionHistorySection)
+		panicIf(pos == -1, "didn't find any version section in '%s'", versionHistoryPath)
+		lines = replaceLines(lines, pos, pos, section)
+	}
+	f.Lines = lines
+	writeTextFileMust(versionHistoryPath, f)
+	logf("updated '%s'\n", versionHistoryPath)
+}
+
+var rxReleaseNotesTxtVer = regexp.MustCompile(`^\d+(\.\d+)+(\s|$)`)
+
+// releasenotes.txt is plain text with "* " bullets and no sub-headers
+func releaseNotesToTxt(notes *ReleaseNotes) []string {
+	res := []string{fmt.Sprintf("%s (%s)", notes.Version, notes.Date)}
+	rxLink := regexp.MustCompile(`\[([^\]]+)\]\([^)]+\)`)
+	for _, l := range strings.Split(notes.Body, "\n") {
+		l = strings.TrimRight(l, " \t")
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		l = rxLink.ReplaceAllString(l, "$1")
+		if strings.HasPrefix(l, "- ") {
+			l = "* " + l[2:]
+		}
+		res = append(res, l)
+	}
+	return append(res, "")
+}
+
+func updateReleaseNotesTxtMust(notes *ReleaseNotes) {
+	f := readTextFileMust(releaseNotesTxtPath)
+	lines := f.Lines
+	section := releaseNotesToTxt(notes)
+	isNext := func(l string) bool {
+		return rxReleaseNotesTxtVer.MatchString(l)
+	}
+	rxVer := regexp.MustCompile(`^` + regexp.QuoteMeta(notes.Version) + `(\s|$)`)
+	start, end := findSection(lines, rxVer, isNext)
+	if start == -1 {
+		start, _ = findSection(lines, rxReleaseNotesTxtVer, isNext)
+		panicIf(start == -1, "didn't find any version in '%s'", releaseNotesTxtPath)
+		end = start
+	}
+	lines = replaceLines(lines, start, end, section)
+	f.Lines = lines
+	writeTextFileMust(releaseNotesTxtPath, f)
+	logf("updated '%s'\n", releaseNotesTxtPath)
+}
+
+func getGithubReleaseNotesPath(ver string) string {
+	return filepath.Join("out", "release-notes-"+ver+"-github.md")
+}
+
+func updateChangelogMust(ver string, path string) {
+	ver = strings.TrimPrefix(ver, "v")
+	panicIf(ver == "", "usage: -update-changelog ${ver} [path] e.g. -update-changelog 3.6")
+	if path == "" {
+		path = getReleaseNotesDraftPath(ver)
+	}
+	notes := readReleaseNotesMust(ver, path)
+	updateVersionHistoryMust(notes)
+	updateReleaseNotesTxtMust(notes)
+	ghPath := getGithubReleaseNotesPath(ver)
+	must(os.MkdirAll(filepath.Dir(ghPath), 0755))
+	writeFileMust(ghPath, []byte(notes.Body+"\n"))
+	logf("wrote '%s'\n", ghPath)
+
+	// make sure that what we wrote is what others read
+	got, _ := getReleaseNotesFromVersionHistory(ver)
+	panicIf(normalizeNewlines(got) != notes.Body, "release notes for %s in '%s' don't match '%s'", ver, versionHistoryPath, path)
+}
@@ -2,9 +2,31 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"unicode"
 )
 
+// Menu items and dialog controls that are shown together are marked as
+// a group so that we can check that their & (menu accelerators, access
+// keys) are unique in every language:
+//
+//	//[ ACCESSKEY_GROUP File Menu
+//	... _TRN("&Open...") ... _TRN("&Close") ...
+//	//] ACCESSKEY_GROUP File Menu
+//
+// Only one alternative of ACCESSKEY_ALTERNATIVE is shown at a time, so they
+// can use the same key:
+//
+//	//[ ACCESSKEY_ALTERNATIVE
+//	... _TRN("&Print...") ...
+//	//| ACCESSKEY_ALTERNATIVE
+//	... _TRN("&Properties") ...
+//	//] ACCESSKEY_ALTERNATIVE
+//
+// -check-access-keys [${lang}] shows conflicts in all (or one) languages.
+// -trans-download shows how many conflicts each language has.
+
 func isGroupStartOrEnd(s string) bool {
 	if strings.HasPrefix(s, "//[ ACCESSKEY_GROUP ") {
 		return true
@@ -28,216 +50,226 @@ func isAltGroupStartOrEnd(s string) bool {
 	return false
 }
 
+type accessItem struct {
+	text string
+	// "path:line" of ACCESSKEY_ALTERNATIVE start, "" if not in one
+	altBlock string
+	// index of alternative in altBlock
+	alt int
+}
+
 type accessGroup struct {
-	group      []string
-	inAltGroup bool
-	altGroup   []string
+	name  string
+	items []*accessItem
+}
+
+// items in different alternatives of the same block are never shown together
+func (a *accessItem) isShownWith(b *accessItem) bool {
+	return a.altBlock == "" || a.altBlock != b.altBlock || a.alt == b.alt
+}
+
+func (g *accessGroup) hasText(s string) bool {
+	for _, it := range g.items {
+		if it.text == s {
+			return true
+		}
+	}
+	return false
 }
 
-func extractAccesskeyGroups(path string) map[string]*accessGroup {
+func extractAccesskeyGroups(path string, groups map[string]*accessGroup) {
 	lines, err := readLinesFromFile(path)
 	must(err)
 
-	groups := map[string]*accessGroup{}
 	groupName := ""
 	var group *accessGroup
+	altBlock, alt := "", 0
 
-	for _, line := range lines {
+	for i, line := range lines {
 		line = strings.TrimSpace(line)
+		loc := fmt.Sprintf("%s:%d", path, i+1)
 		if isGroupStartOrEnd(line) {
-			newName := line[20:]
+			newName := strings.TrimSpace(line[20:])
 			if line[2] == '[' {
 				// start of new group
-				panicIf(group != nil, "Group '%s' doesn't end before group '%s' starts", groupName, newName)
+				panicIf(group != nil, "%s: group '%s' doesn't end before group '%s' starts", loc, groupName, newName)
 				groupName = newName
 				group = groups[groupName]
 				if group == nil {
-					group = &accessGroup{}
+					group = &accessGroup{name: groupName}
 					groups[groupName] = group
 				}
 			} else {
 				// end of group
-				panicIf(group == nil, "Unexpected group end ('%s')", newName)
-				panicIf(groupName != newName, "Group end mismatch: '%s' != '%s'", newName, groupName)
+				panicIf(group == nil, "%s: unexpected group end ('%s')", loc, newName)
+				panicIf(groupName != newName, "%s: group end mismatch: '%s' != '%s'", loc, newName, groupName)
+				panicIf(altBlock != "", "%s: ACCESSKEY_ALTERNATIVE doesn't end before end of group", loc)
 				group = nil
 			}
 		} else if isAltGroupStartOrEnd(line) {
-			panicIf(group == nil, "Can't use ACCESSKEY_ALTERNATIVE outside of group")
-			if line[2] == '[' {
-				panicIf(line[25] != ' ', "Typo?")
-				panicIf(group.inAltGroup, "Nested ACCESSKEY_ALTERNATIVE isn't supported")
-				group.inAltGroup = true
-			} else if line[2] == '|' {
-				panicIf(!group.inAltGroup, "Unexpected ACCESSKEY_ALTERNATIVE alternative")
-			} else {
-				panicIf(!group.inAltGroup, "Unexpected ACCESSKEY_ALTERNATIVE end")
-				group.inAltGroup = false
+			panicIf(group == nil, "%s: can't use ACCESSKEY_ALTERNATIVE outside of group", loc)
+			switch line[2] {
+			case '[':
+				panicIf(altBlock != "", "%s: nested ACCESSKEY_ALTERNATIVE isn't supported", loc)
+				altBlock, alt = loc, 0
+			case '|':
+				panicIf(altBlock == "", "%s: unexpected ACCESSKEY_ALTERNATIVE alternative", loc)
+				alt++
+			default:
+				panicIf(altBlock == "", "%s: unexpected ACCESSKEY_ALTERNATIVE end", loc)
+				altBlock = ""
 			}
-		} else if group != nil {
-			strs := extractTranslations(line)
-			for _, str := range strs {
-				exists := stringInSlice(group.group, str)
-				n := strings.Count(str, "&")
-				panicIf(n > 1, "TODO: handle multiple '&' in strings")
-				if exists {
-					group.group = append(group.group, str)
-				}
-				if group.inAltGroup {
-					group.altGroup = append(group.altGroup, str)
+		} else if group != nil && !strings.HasPrefix(line, "//") {
+			for _, str := range extractTranslations(line) {
+				if group.hasText(str) {
+					continue
 				}
+				group.items = append(group.items, &accessItem{text: str, altBlock: altBlock, alt: alt})
 			}
 		}
 	}
-	return groups
+	panicIf(group != nil, "%s: group '%s' doesn't end", path, groupName)
 }
 
-func strMult(s string, n int) string {
-	res := ""
-	for i := 0; i < n; i++ {
-		res += s
+// returns upper-cased character after & or 0 if there's no &
+func getAccessKey(s string) rune {
+	s = strings.ReplaceAll(s, "&&", "")
+	idx := strings.IndexByte(s, '&')
+	if idx < 0 {
+		return 0
 	}
-	return res
+	for _, c := range s[idx+1:] {
+		return unicode.ToUpper(c)
+	}
+	return 0
 }
 
-func isAlnum(s string) bool {
-	panicIf(len(s) != 1)
-	c := s[0]
-	if c >= 'a' && c <= 'z' {
-		return true
-	}
-	if c >= 'A' && c <= 'Z' {
-		return true
-	}
-	if c >= '0' && c <= '9' {
-		return true
-	}
-	return false
+// AccessKeyClash is 2 strings shown together that use the same access key
+type AccessKeyClash struct {
+	Lang  string
+	Group string
+	Key   rune
+	// translations, English text if not translated
+	Trans1 string
+	Trans2 string
+	// letters of Trans2 that are not used as keys in the group
+	Available string
 }
 
-func detectAccesskeyClashes(groups map[string]*accessGroup, translations map[string][]*Translation) {
-	for _, lang := range gLangs {
-		fmt.Printf("Accesskey issues for '%s'", lang[1])
-		fmt.Printf("%s'\n", strMult("=", 23+len(lang[1])))
-		warnings := []string{}
-		for name, group := range groups {
-			usedKeys := map[string]string{}
-			duplicates := map[string]bool{}
-			alternates := map[string][]string{}
-
-			_ = name
-			_ = duplicates
-			_ = alternates
-
-			strs := group.group
-			for _, str := range strs[1:] {
-				trans := str
-				for _, item := range translations[str] {
-					if item.Lang == lang[0] {
-						trans = item.Translation
-						break
-					}
-				}
-				ix := strings.Index(trans, "&")
-				if ix == -1 {
-					if strings.Contains(str, "&") {
-						s := "WARNING: Translation has no accesskey where original does:"
-						warnings = append(warnings, s)
-						s = fmt.Sprintf("         \"%s\", \"%s\"", strs, trans)
-						warnings = append(warnings, s)
-						continue
-					}
-				}
-				if ix == len(trans)-1 {
-					s := fmt.Sprintf("ERROR: '&' must be followed by a letter (\"%s\")", trans)
-					warnings = append(warnings, s)
+func findAccessKeyClashes(groups map[string]*accessGroup, translations map[string][]*Translation, lang string) []*AccessKeyClash {
+	getTrans := func(s string) string {
+		for _, tr := range translations[s] {
+			if tr.Lang == lang {
+				return tr.Translation
+			}
+		}
+		// the app shows English text
+		return s
+	}
+	var names []string
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var res []*AccessKeyClash
+	for _, name := range names {
+		items := groups[name].items
+		trans := make([]string, len(items))
+		keys := make([]rune, len(items))
+		used := map[rune]bool{}
+		for i, it := range items {
+			trans[i] = getTrans(it.text)
+			keys[i] = getAccessKey(trans[i])
+			used[keys[i]] = true
+		}
+		for i := range items {
+			for j := 0; j < i; j++ {
+				if keys[i] == 0 || keys[i] != keys[j] || !items[i].isShownWith(items[j]) {
 					continue
 				}
-				if !strings.Contains(str, "&") {
-					s := "WARNING: Translation has accesskey where original doesn't:"
-					warnings = append(warnings, s)
-					s = fmt.Sprintf("         \"%s\", \"%s\"", str, trans)
-					warnings = append(warnings, s)
-				}
-				key := strings.ToUpper(string(trans[ix+1]))
-				if _, ok := usedKeys[key]; ok {
-					/*
-						if None in alternates[key] or get_alternate_ix(strings[0], string) in alternates[key]:
-							duplicates.append((key, trans))
-						else:
-							alternates[key].append(get_alternate_ix(strings[0], string))
-					*/
-				} else {
-					if !isAlnum(key) {
-						s := fmt.Sprintf("WARNING: Access key '%s' might not work on all keyboards (\"%s\")", key, trans)
-						warnings = append(warnings, s)
+				var avail []rune
+				for _, c := range strings.ToUpper(trans[i]) {
+					// CJK translations use Latin letters e.g. "開啟(&O)"
+					isCJK := unicode.In(c, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+					if unicode.IsLetter(c) && !isCJK && !used[c] && !strings.ContainsRune(string(avail), c) {
+						avail = append(avail, c)
 					}
-					usedKeys[key] = trans
-					//alternates[key].append(get_alternate_ix(strings[0], string))
 				}
+				c := &AccessKeyClash{
+					Lang:      lang,
+					Group:     name,
+					Key:       keys[i],
+					Trans1:    trans[j],
+					Trans2:    trans[i],
+					Available: string(avail),
+				}
+				res = append(res, c)
+				break
 			}
 		}
-		/*
-				if duplicates:
-					print "Clashes in accesskey group '%s':" % name
-					for item in duplicates:
-						print " * %s: \"%s\" and \"%s\"" % (item[0].upper(), item[1], used_keys[item[0].upper()])
-					available = [chr(i) for i in range(ord("A"), ord("Z") + 1) if chr(i) not in used_keys.keys()]
-					print "   (available keys: %s)" % "".join(available)
-					print
-
-			print "\n".join(warnings)
-		*/
-		fmt.Printf("\n")
 	}
+	return res
 }
 
-func printGroups(file string, groups map[string]*accessGroup) {
-	if len(groups) == 0 {
-		return
+func getAccessKeyGroupsMust() map[string]*accessGroup {
+	groups := map[string]*accessGroup{}
+	for _, file := range getFilesToProcess() {
+		extractAccesskeyGroups(file, groups)
 	}
-	fmt.Printf("%s\n", file)
-	for k, g := range groups {
-		fmt.Printf("  %s\n", k)
-		if len(g.group) > 0 {
-			for _, s := range g.group {
-				fmt.Printf("    %s\n", s)
-			}
-		}
-		if len(g.altGroup) > 0 {
-			fmt.Printf("    alt groups:\n")
-			for _, s := range g.altGroup {
-				fmt.Printf("    %s\n", s)
-			}
+	return groups
+}
+
+// lang => clashes, for languages that have them
+func findAllAccessKeyClashes(translations map[string][]*Translation) map[string][]*AccessKeyClash {
+	groups := getAccessKeyGroupsMust()
+	res := map[string][]*AccessKeyClash{}
+	for _, l := range gLangs {
+		if clashes := findAccessKeyClashes(groups, translations, l[0]); len(clashes) > 0 {
+			res[l[0]] = clashes
 		}
 	}
+	return res
 }
 
-func updateGroups(m1 map[string]*accessGroup, m2 map[string]*accessGroup) map[string]*accessGroup {
-	if m1 == nil {
-		return m2
+// shown by -trans-download
+func printAccessKeyClashesSummary(translations map[string][]*Translation) {
+	byLang := findAllAccessKeyClashes(translations)
+	if len(byLang) == 0 {
+		return
+	}
+	var langs []string
+	for lang := range byLang {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		logf("%s: %d menu accelerator conflicts\n", lang, len(byLang[lang]))
 	}
+	logf("see details with -check-access-keys ${lang}\n")
+}
 
-	for k, g2 := range m2 {
-		g1 := m1[k]
-		if g1 == nil {
-			m1[k] = g2
+// -check-access-keys [${lang}]
+func checkAccessKeys(lang string) {
+	translations := parseTranslations(string(readFileMust(translationsTxtPath)))
+	byLang := findAllAccessKeyClashes(translations)
+	n := 0
+	for _, l := range gLangs {
+		if lang != "" && l[0] != lang {
+			continue
+		}
+		clashes := byLang[l[0]]
+		if len(clashes) == 0 {
 			continue
 		}
-		g1.group = append(g1.group, g2.group...)
-		g1.altGroup = append(g1.altGroup, g2.altGroup...)
+		logf("\n%s (%s):\n", l[1], l[0])
+		for _, c := range clashes {
+			logf("  %s: '%c' used by '%s' and '%s'", c.Group, c.Key, c.Trans1, c.Trans2)
+			if c.Available != "" {
+				logf(", can use one of: %s", c.Available)
+			}
+			logf("\n")
+			n++
+		}
 	}
-	return m1
-}
-
-func checkAccessKeys() {
-	cFiles := getFilesToProcess()
-	var allGroups map[string]*accessGroup
-	for _, file := range cFiles {
-		group := extractAccesskeyGroups(file)
-		printGroups(file, group)
-		allGroups = updateGroups(allGroups, group)
-	}
-	d := readFileMust(translationsTxtPath)
-	translations := parseTranslations(string(d))
-	detectAccesskeyClashes(allGroups, translations)
+	logf("\n%d menu accelerator conflicts\n", n)
 }
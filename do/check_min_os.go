@@ -0,0 +1,188 @@
+package main
+
+import (
+	"debug/pe"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// We support Windows 7 and later (Windows 10 for arm64).
+// After building we inspect PE headers and imports of every binary we ship
+// to make sure a change in code (or in a compiler / SDK default) didn't add
+// a dependency that would prevent us from running on Windows 7 / 8.
+
+// 6.1 is Windows 7
+const (
+	minOsMajorVersion = 6
+	minOsMinorVersion = 1
+)
+
+var binariesToCheckMinOs = []string{
+	"SumatraPDF.exe",
+	"SumatraPDF-dll.exe",
+	"libmupdf.dll",
+	"PdfFilter.dll",
+	"PdfPreview.dll",
+}
+
+// dlls that don't exist on Windows 7. Must be loaded dynamically with LoadLibrary()
+var dllsNotInWin7 = []string{
+	"shcore.dll",          // 8.1
+	"d3d11_1.dll",         // 8
+	"dcomp.dll",           // 8
+	"windows.storage.dll", // 8
+	"api-ms-win-core-path-l1-1-0.dll",
+	"api-ms-win-core-synch-l1-2-0.dll",
+	"api-ms-win-core-winrt-l1-1-0.dll",
+	"api-ms-win-core-winrt-string-l1-1-0.dll",
+	"api-ms-win-shcore-scaling-l1-1-1.dll",
+}
+
+// functions that don't exist on Windows 7. Must be called via GetProcAddress()
+// format is "${func}:${dll}", as returned by pe.File.ImportedSymbols()
+var funcsNotInWin7 = []string{
+	"GetSystemTimePreciseAsFileTime:kernel32.dll",    // 8
+	"CreateFile2:kernel32.dll",                       // 8
+	"SetThreadDescription:kernel32.dll",              // 10
+	"GetThreadDescription:kernel32.dll",              // 10
+	"GetCurrentPackageFullName:kernel32.dll",         // 8
+	"GetCurrentPackageId:kernel32.dll",               // 8
+	"GetDpiForWindow:user32.dll",                     // 10
+	"GetDpiForSystem:user32.dll",                     // 10
+	"GetSystemMetricsForDpi:user32.dll",              // 10
+	"AdjustWindowRectExForDpi:user32.dll",            // 10
+	"SystemParametersInfoForDpi:user32.dll",          // 10
+	"SetProcessDpiAwarenessContext:user32.dll",       // 10
+	"SetThreadDpiAwarenessContext:user32.dll",        // 10
+	"EnableNonClientDpiScaling:user32.dll",           // 10
+	"GetPointerType:user32.dll",                      // 8
+	"GetPointerInfo:user32.dll",                      // 8
+	"EnableMouseInPointer:user32.dll",                // 8
+	"RegisterPointerInputTarget:user32.dll",          // 8
+	"SetProcessDpiAwareness:shcore.dll",              // 8.1
+	"GetDpiForMonitor:shcore.dll",                    // 8.1
+	"PathCchCombine:api-ms-win-core-path-l1-1-0.dll", // 8
+}
+
+type minOsProblem struct {
+	path string
+	msg  string
+}
+
+func checkBinaryMinOs(path string, isArm64 bool) []minOsProblem {
+	var res []minOsProblem
+	addProblem := func(format string, args ...interface{}) {
+		res = append(res, minOsProblem{path: path, msg: fmt.Sprintf(format, args...)})
+	}
+
+	f, err := pe.Open(path)
+	if err != nil {
+		addProblem("failed to open as PE file: %s", err)
+		return res
+	}
+	defer f.Close()
+
+	var osMajor, osMinor, subsysMajor, subsysMinor uint16
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		osMajor, osMinor = oh.MajorOperatingSystemVersion, oh.MinorOperatingSystemVersion
+		subsysMajor, subsysMinor = oh.MajorSubsystemVersion, oh.MinorSubsystemVersion
+	case *pe.OptionalHeader64:
+		osMajor, osMinor = oh.MajorOperatingSystemVersion, oh.MinorOperatingSystemVersion
+		subsysMajor, subsysMinor = oh.MajorSubsystemVersion, oh.MinorSubsystemVersion
+	default:
+		addProblem("missing optional header")
+		return res
+	}
+
+	// Windows refuses to load exe if subsystem version is higher than OS version
+	// arm64 is only supported on Windows 10
+	maxMajor, maxMinor := uint16(minOsMajorVersion), uint16(minOsMinorVersion)
+	if isArm64 {
+		maxMajor, maxMinor = 10, 0
+	}
+	isNewer := func(major, minor uint16) bool {
+		return major > maxMajor || (major == maxMajor && minor > maxMinor)
+	}
+	if isNewer(subsysMajor, subsysMinor) {
+		addProblem("subsystem version is %d.%d, must be <= %d.%d", subsysMajor, subsysMinor, maxMajor, maxMinor)
+	}
+	if isNewer(osMajor, osMinor) {
+		addProblem("os version is %d.%d, must be <= %d.%d", osMajor, osMinor, maxMajor, maxMinor)
+	}
+	if isArm64 {
+		// everything we import exists on Windows 10
+		return res
+	}
+
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		addProblem("ImportedLibraries() failed with '%s'", err)
+		return res
+	}
+	for _, lib := range libs {
+		if stringInSlice(dllsNotInWin7, strings.ToLower(lib)) {
+			addProblem("imports '%s' which doesn't exist on Windows 7", lib)
+		}
+	}
+
+	syms, err := f.ImportedSymbols()
+	if err != nil {
+		addProblem("ImportedSymbols() failed with '%s'", err)
+		return res
+	}
+	for _, sym := range syms {
+		// "GetDpiForWindow:USER32.dll"
+		parts := strings.SplitN(sym, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		s := parts[0] + ":" + strings.ToLower(parts[1])
+		if stringInSlice(funcsNotInWin7, s) {
+			addProblem("imports '%s' which doesn't exist on Windows 7", sym)
+		}
+	}
+	return res
+}
+
+func checkMinOsInDir(dir string, platform string) []minOsProblem {
+	var res []minOsProblem
+	isArm64 := platform == kPlatformArm64
+	for _, name := range binariesToCheckMinOs {
+		path := filepath.Join(dir, name)
+		if !fileExists(path) {
+			continue
+		}
+		problems := checkBinaryMinOs(path, isArm64)
+		res = append(res, problems...)
+	}
+	return res
+}
+
+func checkMinOsMust(dir string, platform string) {
+	problems := checkMinOsInDir(dir, platform)
+	if len(problems) == 0 {
+		logf("checkMinOs: binaries in '%s' are compatible with minimum supported Windows version\n", dir)
+		return
+	}
+	for _, p := range problems {
+		logf("%s: %s\n", p.path, p.msg)
+	}
+	panicIf(true, "checkMinOs: found %d problems in '%s'", len(problems), dir)
+}
+
+// checks all builds that exist in out/ directory
+func checkMinOsAll() {
+	platforms := []string{kPlatformIntel32, kPlatformIntel64, kPlatformArm64}
+	nChecked := 0
+	for _, platform := range platforms {
+		dir := getOutDirForPlatform(platform)
+		if !dirExists(dir) {
+			continue
+		}
+		checkMinOsMust(dir, platform)
+		nChecked++
+	}
+	panicIf(nChecked == 0, "checkMinOsAll: didn't find any builds in out/ directory")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// -chocolatey [push] creates Chocolatey package sumatrapdf.install for
+// release build in out/ (from artifacts.json) and, with push, pushes it
+// to community feed (needs CHOCOLATEY_API_KEY).
+//
+// The package doesn't contain the installer, only install script that
+// downloads it and verifies embedded sha256 checksum.
+// Package sources and .nupkg are written to out/chocolatey/${ver}
+
+var chocolateyAPIKey string
+
+const (
+	chocolateyPackageID = "sumatrapdf.install"
+	chocolateyPushURL   = "https://push.chocolatey.org/"
+)
+
+const chocolateyNuspecTmpl = `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://schemas.microsoft.com/packaging/2015/06/nuspec.xsd">
+  <metadata>
+    <id>{{.ID}}</id>
+    <version>{{.Version}}</version>
+    <title>SumatraPDF (Install)</title>
+    <authors>Krzysztof Kowalczyk</authors>
+    <owners>Krzysztof Kowalczyk</owners>
+    <projectUrl>https://www.sumatrapdfreader.org</projectUrl>
+    <iconUrl>https://raw.githubusercontent.com/sumatrapdfreader/sumatrapdf/master/gfx/SumatraPDF-256x256x32.png</iconUrl>
+    <licenseUrl>https://github.com/sumatrapdfreader/sumatrapdf/blob/master/COPYING</licenseUrl>
+    <requireLicenseAcceptance>false</requireLicenseAcceptance>
+    <projectSourceUrl>https://github.com/sumatrapdfreader/sumatrapdf</projectSourceUrl>
+    <docsUrl>https://www.sumatrapdfreader.org/docs/SumatraPDF-documentation</docsUrl>
+    <bugTrackerUrl>https://github.com/sumatrapdfreader/sumatrapdf/issues</bugTrackerUrl>
+    <tags>sumatrapdf pdf epub mobi djvu xps chm cbz cbr reader viewer foss</tags>
+    <summary>PDF, eBook (epub, mobi), comic book (cbz/cbr), DjVu, XPS, CHM, image viewer for Windows</summary>
+    <description>SumatraPDF is a free PDF, eBook (epub, mobi), comic book (cbz/cbr), DjVu, XPS, CHM, image viewer for Windows. It's small, fast and portable.</description>
+    <releaseNotes>{{.ReleaseNotes}}</releaseNotes>
+  </metadata>
+  <files>
+    <file src="tools\**" target="tools" />
+  </files>
+</package>
+`
+
+const chocolateyInstallTmpl = `$ErrorActionPreference = 'Stop'
+
+$packageArgs = @{
+  packageName    = $env:ChocolateyPackageName
+  fileType       = 'exe'
+  url            = '{{.URL32}}'
+  checksum       = '{{.Checksum32}}'
+  checksumType   = 'sha256'
+  url64bit       = '{{.URL64}}'
+  checksum64     = '{{.Checksum64}}'
+  checksumType64 = 'sha256'
+  silentArgs     = '-install -s -all-users'
+  validExitCodes = @(0)
+  softwareName   = 'SumatraPDF*'
+}
+
+Install-ChocolateyPackage @packageArgs
+`
+
+const chocolateyUninstallTmpl = `$ErrorActionPreference = 'Stop'
+
+[array]$key = Get-UninstallRegistryKey -SoftwareName 'SumatraPDF*'
+if ($key.Count -eq 0) {
+  Write-Warning "SumatraPDF is not installed, nothing to uninstall"
+  return
+}
+foreach ($k in $key) {
+  $file = $k.UninstallString -replace '^"([^"]+)".*$', '$1'
+  Uninstall-ChocolateyPackage -PackageName $env:ChocolateyPackageName -FileType 'exe' -SilentArgs '-uninstall -s' -File $file
+}
+`
+
+func detectChocoMust() string {
+	path, err := exec.LookPath("choco")
+	panicIf(err != nil, "didn't find choco.exe. Install from https://chocolatey.org/install")
+	return path
+}
+
+// returns path of .nupkg
+func chocolateyPackMust(am *ArtifactsManifest) string {
+	panicIf(am.BuildType != buildTypeRel, "chocolatey package can only be created for release builds, not '%s'", am.BuildType)
+	inst32 := am.find(kArtifactInstaller, "32")
+	inst64 := am.find(kArtifactInstaller, "64")
+	panicIf(inst32 == nil || inst64 == nil, "need 32-bit and 64-bit installers in %s", artifactsManifestName)
+
+	prefix := getDownloadPrefixViaWebsite(buildTypeRel, am.Version)
+	notes, _ := getReleaseNotesFromVersionHistory(am.Version)
+	v := struct {
+		ID           string
+		Version      string
+		ReleaseNotes string
+		URL32        string
+		Checksum32   string
+		URL64        string
+		Checksum64   string
+	}{
+		ID:           chocolateyPackageID,
+		Version:      am.Version,
+		ReleaseNotes: xmlEscape(notes),
+		URL32:        prefix + inst32.Name,
+		Checksum32:   inst32.Sha256,
+		URL64:        prefix + inst64.Name,
+		Checksum64:   inst64.Sha256,
+	}
+
+	dir := filepath.Join("out", "chocolatey", am.Version)
+	must(os.RemoveAll(dir))
+	toolsDir := filepath.Join(dir, "tools")
+	must(os.MkdirAll(toolsDir, 0755))
+	nuspecPath := filepath.Join(dir, chocolateyPackageID+".nuspec")
+	writeFileMust(nuspecPath, []byte(execTextTemplate(chocolateyNuspecTmpl, v)))
+	writeFileMust(filepath.Join(toolsDir, "chocolateyinstall.ps1"), []byte(execTextTemplate(chocolateyInstallTmpl, v)))
+	writeFileMust(filepath.Join(toolsDir, "chocolateyuninstall.ps1"), []byte(chocolateyUninstallTmpl))
+
+	choco := detectChocoMust()
+	cmd := exec.Command(choco, "pack", filepath.Base(nuspecPath), "--out", ".")
+	cmd.Dir = dir
+	runCmdLoggedMust(cmd)
+	nupkgPath := filepath.Join(dir, chocolateyPackageID+"."+am.Version+".nupkg")
+	panicIf(!fileExists(nupkgPath), "choco pack didn't create '%s'", nupkgPath)
+	logf("created '%s'\n", nupkgPath)
+	return nupkgPath
+}
+
+func chocolateyMust(push bool) {
+	am := readArtifactsManifestMust(getFinalDirForBuildType(buildTypeRel))
+	nupkgPath := chocolateyPackMust(am)
+	if !push {
+		return
+	}
+	panicIf(chocolateyAPIKey == "", "need CHOCOLATEY_API_KEY env variable to push")
+	// not dryRunSkipCmd() because it would log api key
+	if dryRunSkip("push '%s' to %s", nupkgPath, chocolateyPushURL) {
+		return
+	}
+	choco := detectChocoMust()
+	cmd := exec.Command(choco, "push", nupkgPath, "--source", chocolateyPushURL, "--api-key", chocolateyAPIKey)
+	// don't log the command, it contains api key
+	out, err := cmd.CombinedOutput()
+	logf("%s\n", strings.TrimSpace(string(out)))
+	must(err)
+	logf("pushed '%s' to %s\n", nupkgPath, chocolateyPushURL)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// -clean [out|docs|translations|all]
+// each scope knows which generated files and directories belong to it
+// so that it's safe to run without thinking
+
+const (
+	cleanScopeOut          = "out"
+	cleanScopeDocs         = "docs"
+	cleanScopeTranslations = "translations"
+	cleanScopeAll          = "all"
+)
+
+var cleanScopes = []string{cleanScopeOut, cleanScopeDocs, cleanScopeTranslations, cleanScopeAll}
+
+// log files created in top directory by -cppcheck, -clang-tidy
+var toolLogFiles = []string{cppcheckLogFile, clangTidyLogFile}
+
+func removeLogged(path string) {
+	if !pathExists(path) {
+		return
+	}
+	must(os.RemoveAll(path))
+	logf("removed '%s'\n", path)
+}
+
+// out/rel32, out/rel64, out/arm64, out/final-*, out/artifacts etc.
+// preserves settings and caches used when running SumatraPDF from out/
+func cleanOut() {
+	cleanPreserveSettings()
+	for _, path := range toolLogFiles {
+		removeLogged(path)
+	}
+}
+
+// docs/www/*.html and docs/www/img are generated from docs/md by -gen-docs
+// docs/www/*.css and favicon.ico are checked in
+func cleanDocs() {
+	wwwDir := filepath.Join("docs", "www")
+	if dirExists(wwwDir) {
+		removeHTMLFilesInDir(wwwDir)
+		logf("removed .html files in '%s'\n", wwwDir)
+	}
+	removeLogged(filepath.Join(wwwDir, "img"))
+	// in-memory cache of generated html, in case we re-generate docs
+	// in the same process
+	mdProcessed = map[string]*MdProcessedInfo{}
+	mdToProcess = nil
+}
+
+// per-language ${lang}.txt files written by previous versions of
+// -trans-download. We delete only them because everything else in
+// translations/ is checked in
+func cleanTranslations() {
+	for _, l := range gLangs {
+		removeLogged(filepath.Join(translationsDir, l[0]+".txt"))
+	}
+}
+
+func cleanScope(scope string) {
+	scope = strings.ToLower(strings.TrimSpace(scope))
+	if scope == "" {
+		// for backwards compatibility -clean without args only cleans out/
+		scope = cleanScopeOut
+	}
+	panicIf(!stringInSlice(cleanScopes, scope), "invalid scope '%s' for -clean, must be one of: %s", scope, strings.Join(cleanScopes, ", "))
+	logf("clean: scope '%s'\n", scope)
+	switch scope {
+	case cleanScopeOut:
+		cleanOut()
+	case cleanScopeDocs:
+		cleanDocs()
+	case cleanScopeTranslations:
+		cleanTranslations()
+	case cleanScopeAll:
+		cleanOut()
+		cleanDocs()
+		cleanTranslations()
+	}
+}
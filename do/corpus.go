@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Test document corpus shared by benchmarks, fuzzing and regression tests,
+// so that they all use the same documents instead of ad-hoc folders.
+//
+// do/corpus.json lists documents with their size and sha256. Documents are
+// stored by their hash in software/sumatrapdf/corpus/${sha256}${ext} and
+// served from corpusURL. Known-bad documents (e.g. crashers from past
+// issues) are marked with "bad" and the issue number.
+//
+// Documents are cached in ${CORPUS_DIR} (default: user cache dir, not out/
+// because -clean would delete them) as:
+//
+//	good/${category}/${name}
+//	bad/${category}/${name}
+//
+// Commands:
+//   - -corpus-fetch [${category}|bad] downloads missing documents
+//   - -corpus-verify checks hashes of cached documents
+//   - -corpus-add ${file} [${category}] [bad] [issue=${n}] adds a document
+//
+// Commands that take a directory of documents (-mem-bench, -render-bench)
+// also accept "corpus" (all good documents), "corpus:${category}" and
+// "corpus:bad", which fetches the corpus first.
+
+const (
+	corpusManifestPath = "do/corpus.json"
+	corpusRemoteDir    = "software/sumatrapdf/corpus/"
+	corpusURL          = "https://www.sumatrapdfreader.org/corpus/"
+	corpusBadDir       = "bad"
+	corpusGoodDir      = "good"
+)
+
+var corpusCategories = []string{"pdf", "xps", "djvu", "epub", "mobi", "fb2", "comic", "chm", "ps", "image", "other"}
+
+// CorpusDoc is a document in the corpus
+type CorpusDoc struct {
+	// file name, unique within category
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+	// known-bad file e.g. one that crashed or hanged
+	Bad bool `json:"bad,omitempty"`
+	// GitHub issue the file comes from
+	Issue int `json:"issue,omitempty"`
+	// where it came from, used if it's not in storage
+	SourceURL string `json:"sourceUrl,omitempty"`
+	Added     string `json:"added"`
+}
+
+// CorpusManifest is do/corpus.json
+type CorpusManifest struct {
+	Docs []*CorpusDoc `json:"docs"`
+}
+
+func getCorpusCacheDir() string {
+	if dir := os.Getenv("CORPUS_DIR"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	must(err)
+	return filepath.Join(dir, "sumatrapdf-corpus")
+}
+
+// ".cbz" => "comic"
+func getCorpusCategory(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".pdf":
+		return "pdf"
+	case ".xps", ".oxps":
+		return "xps"
+	case ".djvu", ".djv":
+		return "djvu"
+	case ".epub":
+		return "epub"
+	case ".mobi", ".azw", ".azw3", ".prc":
+		return "mobi"
+	case ".fb2", ".fb2z", ".zfb2":
+		return "fb2"
+	case ".cbz", ".cbr", ".cb7", ".cbt":
+		return "comic"
+	case ".chm":
+		return "chm"
+	case ".ps", ".eps":
+		return "ps"
+	case ".png", ".jpg", ".jpeg", ".gif", ".tif", ".tiff", ".bmp", ".webp", ".jxr", ".tga", ".heic", ".avif":
+		return "image"
+	}
+	return "other"
+}
+
+func (d *CorpusDoc) localPath() string {
+	dir := corpusGoodDir
+	if d.Bad {
+		dir = corpusBadDir
+	}
+	return filepath.Join(getCorpusCacheDir(), dir, d.Category, d.Name)
+}
+
+func (d *CorpusDoc) remotePath() string {
+	return corpusRemoteDir + d.Sha256 + strings.ToLower(filepath.Ext(d.Name))
+}
+
+func readCorpusManifestMust() *CorpusManifest {
+	var res CorpusManifest
+	must(json.Unmarshal(readFileMust(corpusManifestPath), &res))
+	return &res
+}
+
+func writeCorpusManifestMust(m *CorpusManifest) {
+	sort.Slice(m.Docs, func(i, j int) bool {
+		a, b := m.Docs[i], m.Docs[j]
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		return a.Name < b.Name
+	})
+	d, err := json.MarshalIndent(m, "", "  ")
+	must(err)
+	writeFileMust(corpusManifestPath, append(d, '\n'))
+}
+
+// returns "" if the file is ok
+func verifyCorpusDoc(d *CorpusDoc) string {
+	path := d.localPath()
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "missing"
+	}
+	if fi.Size() != d.Size {
+		return fmt.Sprintf("size is %d, expected %d", fi.Size(), d.Size)
+	}
+	if sha := fileSha256HexMust(path); sha != d.Sha256 {
+		return fmt.Sprintf("sha256 is %s, expected %s", sha, d.Sha256)
+	}
+	return ""
+}
+
+func fetchCorpusDoc(d *CorpusDoc) error {
+	path := d.localPath()
+	tmpPath := path + ".tmp"
+	defer os.Remove(tmpPath)
+	uris := []string{corpusURL + strings.TrimPrefix(d.remotePath(), corpusRemoteDir)}
+	if d.SourceURL != "" {
+		uris = append(uris, d.SourceURL)
+	}
+	var err error
+	for _, uri := range uris {
+		if err = httpDownloadToFile(uri, tmpPath); err != nil {
+			continue
+		}
+		if sha := fileSha256HexMust(tmpPath); sha != d.Sha256 {
+			err = fmt.Errorf("'%s' has sha256 %s, expected %s", uri, sha, d.Sha256)
+			continue
+		}
+		return os.Rename(tmpPath, path)
+	}
+	return err
+}
+
+// filter is "" (all good documents), category or "bad"
+func corpusDocMatches(d *CorpusDoc, filter string) bool {
+	if filter == corpusBadDir {
+		return d.Bad
+	}
+	if d.Bad {
+		return false
+	}
+	return filter == "" || d.Category == filter
+}
+
+// -corpus-fetch [${category}|bad]
+func corpusFetchMust(filter string) {
+	panicIf(filter != "" && filter != corpusBadDir && !stringInSlice(corpusCategories, filter), "unknown category '%s', known: %s, bad", filter, strings.Join(corpusCategories, ", "))
+	m := readCorpusManifestMust()
+	nFetched, nOk := 0, 0
+	var failed []string
+	for _, d := range m.Docs {
+		if !corpusDocMatches(d, filter) {
+			continue
+		}
+		if verifyCorpusDoc(d) == "" {
+			nOk++
+			continue
+		}
+		logf("fetching %s/%s\n", d.Category, d.Name)
+		if err := fetchCorpusDoc(d); err != nil {
+			failed = append(failed, fmt.Sprintf("%s/%s: %s", d.Category, d.Name, err))
+			continue
+		}
+		nFetched++
+	}
+	logf("corpus in '%s': %d documents up to date, fetched %d\n", getCorpusCacheDir(), nOk, nFetched)
+	panicIf(len(failed) > 0, "failed to fetch %d documents:\n%s", len(failed), strings.Join(failed, "\n"))
+}
+
+// -corpus-verify
+func corpusVerifyMust() {
+	m := readCorpusManifestMust()
+	known := map[string]bool{}
+	byCategory := map[string]int{}
+	nBad := 0
+	var problems []string
+	for _, d := range m.Docs {
+		known[d.localPath()] = true
+		byCategory[d.Category]++
+		if d.Bad {
+			nBad++
+		}
+		if s := verifyCorpusDoc(d); s != "" {
+			problems = append(problems, fmt.Sprintf("%s/%s: %s", d.Category, d.Name, s))
+		}
+	}
+	// files that aren't in the manifest were probably added by hand
+	dir := getCorpusCacheDir()
+	if dirExists(dir) {
+		err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+			if err != nil || e.IsDir() {
+				return err
+			}
+			if !known[path] {
+				problems = append(problems, fmt.Sprintf("'%s' is not in %s", path, corpusManifestPath))
+			}
+			return nil
+		})
+		must(err)
+	}
+	logf("%d documents (%d known-bad):\n", len(m.Docs), nBad)
+	for _, c := range corpusCategories {
+		if byCategory[c] > 0 {
+			logf("  %-6s %d\n", c, byCategory[c])
+		}
+	}
+	panicIf(len(problems) > 0, "%d problems in corpus (-corpus-fetch fixes missing and modified documents):\n%s", len(problems), strings.Join(problems, "\n"))
+	logf("all documents in '%s' verified\n", dir)
+}
+
+// -corpus-add ${file} [${category}] [bad] [issue=${n}]
+func corpusAddMust(args []string) {
+	panicIf(len(args) < 1, "usage: -corpus-add ${file} [${category}] [bad] [issue=${n}]")
+	path := args[0]
+	panicIf(!fileExists(path), "'%s' doesn't exist", path)
+	d := &CorpusDoc{
+		Name:     filepath.Base(path),
+		Category: getCorpusCategory(path),
+		Size:     fileSizeMust(path),
+		Sha256:   fileSha256HexMust(path),
+		Added:    time.Now().Format("2006-01-02"),
+	}
+	for _, arg := range args[1:] {
+		if arg == "bad" {
+			d.Bad = true
+		} else if s, ok := strings.CutPrefix(arg, "issue="); ok {
+			n, err := strconv.Atoi(strings.TrimPrefix(s, "#"))
+			panicIf(err != nil, "invalid issue number in '%s'", arg)
+			d.Issue = n
+		} else {
+			panicIf(!stringInSlice(corpusCategories, arg), "unknown argument '%s', categories: %s", arg, strings.Join(corpusCategories, ", "))
+			d.Category = arg
+		}
+	}
+
+	m := readCorpusManifestMust()
+	for _, d2 := range m.Docs {
+		panicIf(d2.Sha256 == d.Sha256, "'%s' is already in corpus as %s/%s", path, d2.Category, d2.Name)
+		panicIf(d2.Category == d.Category && d2.Name == d.Name && d2.Bad == d.Bad, "%s/%s is already in corpus, rename the file", d.Category, d.Name)
+	}
+
+	ensureAllUploadCreds()
+	forEachStorageParallelMust(getStorageBackends(), func(storage StorageBackend) {
+		// files are addressed by hash so can't change
+		if storage.Exists(d.remotePath()) {
+			return
+		}
+		must(storage.UploadFile(d.remotePath(), path))
+		logf("uploaded '%s' => %s\n", path, storage.URLForPath(d.remotePath()))
+	})
+
+	dst := d.localPath()
+	must(createDirForFile(dst))
+	writeFileMust(dst, readFileMust(path))
+	push(&m.Docs, d)
+	writeCorpusManifestMust(m)
+	logf("added %s/%s to %s\n", d.Category, d.Name, corpusManifestPath)
+}
+
+// returns directory of documents for commands that take one, arg can be
+// "corpus", "corpus:${category}" or "corpus:bad" (which fetches them) or a
+// directory
+func getCorpusDirMust(arg string) string {
+	if arg != "corpus" && !strings.HasPrefix(arg, "corpus:") {
+		return arg
+	}
+	filter := strings.TrimPrefix(strings.TrimPrefix(arg, "corpus"), ":")
+	corpusFetchMust(filter)
+	dir := filepath.Join(getCorpusCacheDir(), corpusGoodDir, filter)
+	if filter == corpusBadDir {
+		dir = filepath.Join(getCorpusCacheDir(), corpusBadDir)
+	}
+	must(os.MkdirAll(dir, 0755))
+	return dir
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -crash-aggregate ${dir} groups crash reports stored in ${dir} (as
+// uploaded by src/CrashHandler.cpp) by signature and shows how many times
+// every signature happened in every build, most frequent first. If there's
+// ${name}.symbolicated.txt (see -symbolicate) we use it instead of ${name}.
+// Minidumps (.dmp) are included if they were analyzed with -analyze-dumps.
+//
+// Signature is crashSignatureFrames top frames of the crashed thread
+// without addresses e.g.
+//
+//	sumatrapdf.exe!DisplayModel::GoToPage | sumatrapdf.exe!OnMenuGoToPage | ...
+//
+// Frames without symbols are module+0x${rva}, which is stable for a build.
+// The same report uploaded more than once is counted once.
+//
+// Writes out/crash-aggregate.json and out/crash-dashboard.html (see
+// crash_dashboard.go).
+
+const crashSignatureFrames = 3
+
+var crashAggregatePath = filepath.Join("out", "crash-aggregate.json")
+
+// frames of the crash handler, exception dispatching and CRT that are the
+// same in many crashes, not part of signature
+var crashSignatureSkipFuncs = []string{
+	"RaiseException",
+	"RtlRaiseException",
+	"KiUserExceptionDispatcher",
+	"_CxxThrowException",
+	"_invoke_watson",
+	"abort",
+	"raise",
+}
+
+// CrashReport is a crash report we got from the app
+type CrashReport struct {
+	Path string `json:"path"`
+	// of content, to find duplicates
+	Sha1      string    `json:"sha1"`
+	BuildType BuildType `json:"buildType"`
+	// as in CrashStats i.e. "16234" for pre-release, "3.5.2" for release
+	Version string `json:"version"`
+	// "EXCEPTION_ACCESS_VIOLATION", "debug report"
+	Exception string `json:"exception"`
+	// crashed thread, without addresses
+	Frames    []string  `json:"frames"`
+	Signature string    `json:"signature"`
+	Time      time.Time `json:"time"`
+}
+
+// CrashSignatureStats is how often a signature happened
+type CrashSignatureStats struct {
+	Signature string `json:"signature"`
+	Count     int    `json:"count"`
+	// version => count
+	ByVersion map[string]int `json:"byVersion"`
+	FirstSeen time.Time      `json:"firstSeen"`
+	LastSeen  time.Time      `json:"lastSeen"`
+	// path, exception and crashed thread of the most recent report
+	Sample          string   `json:"sample"`
+	SampleException string   `json:"sampleException"`
+	SampleFrames    []string `json:"sampleFrames"`
+}
+
+// CrashAggregate is crash reports grouped by signature
+type CrashAggregate struct {
+	Reports    int `json:"reports"`
+	Duplicates int `json:"duplicates"`
+	// most frequent first
+	Signatures []*CrashSignatureStats `json:"signatures"`
+}
+
+func isCrashSignatureSkipFrame(frame string) bool {
+	_, fn, ok := strings.Cut(frame, "!")
+	return ok && stringInSlice(crashSignatureSkipFuncs, fn)
+}
+
+// "00007FF6A1B2C3D4 01:00012345 sumatrapdf.exe!Foo+0x12 C:\src\Foo.cpp+12"
+// => "sumatrapdf.exe!Foo"
+// "00007FF6A1B2C3D4 01:00012345 sumatrapdf.exe"
+// => "sumatrapdf.exe+0x12c3d4"
+func normalizeCrashFrame(line string, info *CrashReportInfo) string {
+	m := rxCrashFrame.FindStringSubmatch(line)
+	if m == nil {
+		return ""
+	}
+	module := strings.ToLower(m[3])
+	rest := line[len(m[0]):]
+	if fn, ok := strings.CutPrefix(rest, "!"); ok {
+		fn, _, _ = strings.Cut(fn, " ")
+		if idx := strings.Index(fn, "+0x"); idx > 0 {
+			fn = fn[:idx]
+		}
+		return module + "!" + fn
+	}
+	addr, err := strconv.ParseUint(m[1], 16, 64)
+	if mod := info.Modules[module]; mod != nil && err == nil && addr >= mod.Base {
+		return fmt.Sprintf("%s+0x%x", module, addr-mod.Base)
+	}
+	return module
+}
+
+func parseCrashReport(s string) *CrashReport {
+	s = normalizeNewlines(s)
+	info := parseCrashReportInfo(s)
+	res := &CrashReport{
+		BuildType: info.BuildType,
+		Version:   info.Ver,
+	}
+	inCrashed := false
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Exception: "):
+			// "Exception: C0000005 EXCEPTION_ACCESS_VIOLATION"
+			f := strings.Fields(line)
+			res.Exception = f[len(f)-1]
+		case strings.HasPrefix(line, "Type: debug report"):
+			res.Exception = "debug report"
+		case line == "Crashed thread:":
+			inCrashed = true
+		case inCrashed && (line == "" || strings.HasPrefix(line, "Thread:")):
+			inCrashed = false
+		case inCrashed:
+			if frame := normalizeCrashFrame(line, info); frame != "" {
+				res.Frames = append(res.Frames, frame)
+			}
+		}
+	}
+	res.Signature = getCrashSignature(res.Frames)
+	return res
+}
+
+func getCrashSignature(frames []string) string {
+	var sig []string
+	for _, frame := range frames {
+		if len(sig) == crashSignatureFrames {
+			break
+		}
+		if !isCrashSignatureSkipFrame(frame) {
+			sig = append(sig, frame)
+		}
+	}
+	if len(sig) == 0 {
+		return "(no callstack)"
+	}
+	return strings.Join(sig, " | ")
+}
+
+// reads crash reports in dir, returns them without duplicates and number of
+// duplicates
+func readCrashReportsMust(dir string) ([]*CrashReport, int) {
+	entries, err := os.ReadDir(dir)
+	must(err)
+	seen := map[string]bool{}
+	nDups := 0
+	var res []*CrashReport
+	for _, e := range entries {
+		name := e.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if e.IsDir() || (ext != ".txt" && ext != ".dmp") || strings.HasSuffix(name, symbolicatedSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		analysisPath := path + dumpAnalysisSuffix
+		if ext == ".dmp" && !fileExists(analysisPath) {
+			logf("skipping '%s', not analyzed with -analyze-dumps\n", path)
+			continue
+		}
+		fi, err := e.Info()
+		must(err)
+		// hash of the original so that re-symbolicating doesn't matter
+		d := readFileMust(path)
+		sum := sha1.Sum(d)
+		if seen[string(sum[:])] {
+			nDups++
+			continue
+		}
+		seen[string(sum[:])] = true
+		var r *CrashReport
+		if ext == ".dmp" {
+			r = readDumpAnalysisMust(analysisPath).toCrashReport()
+		} else {
+			if p := path + symbolicatedSuffix; fileExists(p) {
+				d = readFileMust(p)
+			}
+			r = parseCrashReport(string(d))
+		}
+		r.Path = path
+		r.Sha1 = hex.EncodeToString(sum[:])
+		r.Time = fi.ModTime().UTC()
+		res = append(res, r)
+	}
+	return res, nDups
+}
+
+func aggregateCrashReports(reports []*CrashReport) *CrashAggregate {
+	res := &CrashAggregate{Reports: len(reports)}
+	bySig := map[string]*CrashSignatureStats{}
+	for _, r := range reports {
+		s := bySig[r.Signature]
+		if s == nil {
+			s = &CrashSignatureStats{
+				Signature: r.Signature,
+				ByVersion: map[string]int{},
+				FirstSeen: r.Time,
+				LastSeen:  r.Time,
+			}
+			bySig[r.Signature] = s
+			res.Signatures = append(res.Signatures, s)
+		}
+		s.Count++
+		ver := r.Version
+		if ver == "" {
+			ver = "unknown"
+		}
+		s.ByVersion[ver]++
+		if r.Time.Before(s.FirstSeen) {
+			s.FirstSeen = r.Time
+		}
+		if !r.Time.Before(s.LastSeen) {
+			s.LastSeen = r.Time
+			s.Sample = r.Path
+			s.SampleException = r.Exception
+			s.SampleFrames = r.Frames
+		}
+	}
+	sort.Slice(res.Signatures, func(i, j int) bool {
+		a, b := res.Signatures[i], res.Signatures[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Signature < b.Signature
+	})
+	return res
+}
+
+// versions of s, most crashes first
+func (s *CrashSignatureStats) sortedVersions() []string {
+	var res []string
+	for ver := range s.ByVersion {
+		res = append(res, ver)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := s.ByVersion[res[i]], s.ByVersion[res[j]]
+		if a != b {
+			return a > b
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// -crash-aggregate ${dir}
+func crashAggregateMust(dir string) *CrashAggregate {
+	panicIf(dir == "", "usage: -crash-aggregate ${dir with crash reports}")
+	reports, nDups := readCrashReportsMust(dir)
+	agg := aggregateCrashReports(reports)
+	agg.Duplicates = nDups
+
+	logf("%d crash reports (%d duplicates skipped), %d signatures\n", agg.Reports, agg.Duplicates, len(agg.Signatures))
+	for i, s := range agg.Signatures {
+		if i == nTopCrashSignatures {
+			logf("... and %d more, see '%s'\n", len(agg.Signatures)-i, crashAggregatePath)
+			break
+		}
+		logf("%5d %s\n", s.Count, s.Signature)
+		var vers []string
+		for _, ver := range s.sortedVersions() {
+			vers = append(vers, fmt.Sprintf("%s: %d", ver, s.ByVersion[ver]))
+		}
+		logf("      %s\n", strings.Join(vers, ", "))
+	}
+
+	d, err := json.MarshalIndent(agg, "", "  ")
+	must(err)
+	must(createDirForFile(crashAggregatePath))
+	writeFileMust(crashAggregatePath, d)
+	logf("wrote '%s'\n", crashAggregatePath)
+	writeCrashDashboardMust(crashDashboardPath, agg)
+	return agg
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Crash dashboard is a static page with crash reports aggregated by
+// -crash-aggregate: top signatures, how many times they happened in recent
+// versions, when they were first and last seen and the crashed thread of
+// a sample report. It only has crashed thread frames, not whole reports,
+// because they have paths, logs and settings of users.
+//
+// -crash-aggregate writes it to out/crash-dashboard.html,
+// -crash-dashboard ${dir} also to crashes.html on the website.
+
+const (
+	crashDashboardName = "crashes.html"
+	// number of most recent versions shown in trends
+	crashDashboardVersions = 8
+	// number of signatures shown
+	crashDashboardSignatures = 50
+)
+
+var crashDashboardPath = filepath.Join("out", "crash-dashboard.html")
+
+// CrashDashboardSignature is a row in crash dashboard
+type CrashDashboardSignature struct {
+	ID        string
+	Signature string
+	Count     int
+	Exception string
+	FirstSeen string
+	LastSeen  string
+	// counts in the same order as versions of the dashboard, 0 if none
+	ByVersion []int
+	Frames    []string
+}
+
+// newest first
+func getCrashDashboardVersions(agg *CrashAggregate) []string {
+	seen := map[string]bool{}
+	var res []string
+	for _, s := range agg.Signatures {
+		for ver := range s.ByVersion {
+			if !seen[ver] && ver != "unknown" {
+				seen[ver] = true
+				res = append(res, ver)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return compareVersions(res[i], res[j]) > 0
+	})
+	if len(res) > crashDashboardVersions {
+		res = res[:crashDashboardVersions]
+	}
+	return res
+}
+
+const crashDashboardTmpl = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="generator" content="generated from crash reports by .\doit.bat -crash-dashboard, don't edit">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>SumatraPDF crashes</title>
+<link rel="stylesheet" href="/sumatra.css">
+<style>
+table.crashes { border-collapse: collapse; }
+table.crashes td, table.crashes th { padding: 2px 8px; border-bottom: 1px solid #ddd; text-align: right; vertical-align: top; }
+table.crashes td.sig, table.crashes th.sig { text-align: left; font-family: monospace; }
+.zero { color: #bbb; }
+.stack { font-family: monospace; white-space: pre; background: #f6f6f6; padding: 4px 8px; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h2>SumatraPDF crashes</h2>
+<p>{{.Reports}} crash reports ({{.Duplicates}} duplicates skipped), {{.NSignatures}} signatures. Updated {{.Updated}}.</p>
+
+<table class="crashes">
+<tr><th>#</th><th>count</th>{{range .Versions}}<th>{{.}}</th>{{end}}<th>first seen</th><th>last seen</th><th class="sig">signature</th></tr>
+{{range $i, $s := .Signatures}}<tr><td>{{inc $i}}</td><td>{{$s.Count}}</td>{{range $s.ByVersion}}<td{{if not .}} class="zero"{{end}}>{{.}}</td>{{end}}<td>{{$s.FirstSeen}}</td><td>{{$s.LastSeen}}</td><td class="sig"><a href="#{{$s.ID}}">{{$s.Signature}}</a></td></tr>
+{{end}}</table>
+
+{{range $i, $s := .Signatures}}
+<h3 id="{{$s.ID}}">{{inc $i}}. {{$s.Signature}}</h3>
+<p>{{$s.Count}} reports{{if $s.Exception}}, {{$s.Exception}}{{end}}. Crashed thread of the most recent report:</p>
+<div class="stack">{{range $s.Frames}}{{.}}
+{{end}}</div>
+{{end}}
+</body>
+</html>
+`
+
+func genCrashDashboard(agg *CrashAggregate, now time.Time) string {
+	vers := getCrashDashboardVersions(agg)
+	var sigs []*CrashDashboardSignature
+	for i, s := range agg.Signatures {
+		if i == crashDashboardSignatures {
+			break
+		}
+		ds := &CrashDashboardSignature{
+			ID:        fmt.Sprintf("sig%d", i+1),
+			Signature: s.Signature,
+			Count:     s.Count,
+			Exception: s.SampleException,
+			FirstSeen: s.FirstSeen.Format("2006-01-02"),
+			LastSeen:  s.LastSeen.Format("2006-01-02"),
+			Frames:    s.SampleFrames,
+		}
+		for _, ver := range vers {
+			ds.ByVersion = append(ds.ByVersion, s.ByVersion[ver])
+		}
+		sigs = append(sigs, ds)
+	}
+	funcs := template.FuncMap{
+		"inc": func(i int) int { return i + 1 },
+	}
+	tmpl := template.Must(template.New("").Funcs(funcs).Parse(crashDashboardTmpl))
+	var buf bytes.Buffer
+	v := map[string]interface{}{
+		"Reports":     agg.Reports,
+		"Duplicates":  agg.Duplicates,
+		"NSignatures": len(agg.Signatures),
+		"Updated":     now.UTC().Format("2006-01-02 15:04 UTC"),
+		"Versions":    vers,
+		"Signatures":  sigs,
+	}
+	must(tmpl.Execute(&buf, v))
+	return buf.String()
+}
+
+func writeCrashDashboardMust(path string, agg *CrashAggregate) {
+	must(createDirForFile(path))
+	writeFileMust(path, []byte(genCrashDashboard(agg, time.Now())))
+	logf("wrote '%s'\n", path)
+}
+
+// -crash-dashboard ${dir}
+func genCrashDashboardMust(dir string) {
+	agg := crashAggregateMust(dir)
+	path := filepath.Join(updateSumatraWebsite(), "server", "www", crashDashboardName)
+	writeCrashDashboardMust(path, agg)
+	logf("Don't forget to checkin the file and deploy website\n")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -crash-file-issues ${dir} aggregates crash reports in ${dir} (see
+// crash_aggregate.go) and for every signature with at least
+// crashIssueMinCount reports opens a GitHub issue with label crashIssueLabel,
+// with the stack, affected versions and counts.
+//
+// To not file the same crash twice, issues have a hidden marker with hash of
+// the signature. If there already is an issue for the signature we add a
+// comment when number of reports at least doubled since the last time we
+// reported it or if there are new reports after the issue was closed.
+//
+// Needs GITHUB_PUBLISH_TOKEN. Respects -dry-run.
+
+const (
+	crashIssueLabel    = "crash"
+	crashIssueMinCount = 20
+	// comment again when count grew that many times
+	crashIssueCommentGrowth = 2
+)
+
+var (
+	// "<!-- crash-signature: 0123456789ab -->"
+	rxCrashIssueSig = regexp.MustCompile(`<!-- crash-signature: ([0-9a-f]+) -->`)
+	// "<!-- crash-count: 123 -->"
+	rxCrashIssueCount = regexp.MustCompile(`<!-- crash-count: (\d+) -->`)
+)
+
+// CrashIssue is a GitHub issue about a crash signature
+type CrashIssue struct {
+	Number   int       `json:"number"`
+	State    string    `json:"state"`
+	Body     string    `json:"body"`
+	HTMLURL  string    `json:"html_url"`
+	ClosedAt time.Time `json:"closed_at"`
+	// set for pull requests, which are also returned by issues API
+	PullRequest interface{} `json:"pull_request"`
+}
+
+func getCrashSignatureHash(sig string) string {
+	sum := sha1.Sum([]byte(sig))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// returns issues with crashIssueLabel, open and closed, by signature hash
+func getCrashIssuesMust(repo string) map[string]*CrashIssue {
+	res := map[string]*CrashIssue{}
+	for page := 1; ; page++ {
+		var issues []*CrashIssue
+		uri := fmt.Sprintf("/repos/%s/issues?labels=%s&state=all&per_page=100&page=%d", repo, crashIssueLabel, page)
+		githubAPIMust(http.MethodGet, uri, nil, &issues)
+		for _, issue := range issues {
+			if issue.PullRequest != nil {
+				continue
+			}
+			if m := rxCrashIssueSig.FindStringSubmatch(issue.Body); m != nil {
+				res[m[1]] = issue
+			}
+		}
+		if len(issues) < 100 {
+			return res
+		}
+	}
+}
+
+// biggest count we reported in the issue or its comments
+func getCrashIssueReportedCountMust(repo string, issue *CrashIssue) int {
+	texts := []string{issue.Body}
+	var comments []struct {
+		Body string `json:"body"`
+	}
+	githubAPIMust(http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=100", repo, issue.Number), nil, &comments)
+	for _, c := range comments {
+		texts = append(texts, c.Body)
+	}
+	res := 0
+	for _, s := range texts {
+		for _, m := range rxCrashIssueCount.FindAllStringSubmatch(s, -1) {
+			if n, _ := strconv.Atoi(m[1]); n > res {
+				res = n
+			}
+		}
+	}
+	return res
+}
+
+// crashed thread of sample report, symbolicated if possible
+func getCrashIssueStack(s *CrashSignatureStats) []string {
+	path := s.Sample + symbolicatedSuffix
+	if !fileExists(path) {
+		return s.SampleFrames
+	}
+	var res []string
+	inCrashed := false
+	for _, line := range strings.Split(normalizeNewlines(string(readFileMust(path))), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "Crashed thread:" {
+			inCrashed = true
+			continue
+		}
+		if inCrashed && (line == "" || strings.HasPrefix(line, "Thread:")) {
+			break
+		}
+		if inCrashed {
+			res = append(res, line)
+		}
+	}
+	if len(res) == 0 {
+		return s.SampleFrames
+	}
+	return res
+}
+
+// versions and counts, markdown table
+func genCrashIssueCounts(s *CrashSignatureStats) string {
+	res := fmt.Sprintf("%d reports, first seen %s, last seen %s.\n\n", s.Count, s.FirstSeen.Format("2006-01-02"), s.LastSeen.Format("2006-01-02"))
+	res += "| version | reports |\n|---|---|\n"
+	for _, ver := range s.sortedVersions() {
+		res += fmt.Sprintf("| %s | %d |\n", ver, s.ByVersion[ver])
+	}
+	res += fmt.Sprintf("\n<!-- crash-count: %d -->\n", s.Count)
+	return res
+}
+
+func genCrashIssueBody(s *CrashSignatureStats) string {
+	res := fmt.Sprintf("Crash reported by -crash-file-issues.\n\nSignature: `%s`\n", s.Signature)
+	if s.SampleException != "" {
+		res += fmt.Sprintf("Exception: %s\n", s.SampleException)
+	}
+	res += "\n" + genCrashIssueCounts(s)
+	res += "\nCrashed thread of the most recent report:\n\n```\n" + strings.Join(getCrashIssueStack(s), "\n") + "\n```\n"
+	res += fmt.Sprintf("\n<!-- crash-signature: %s -->\n", getCrashSignatureHash(s.Signature))
+	return res
+}
+
+// "sumatrapdf.exe!DisplayModel::GoToPage | ..." => "Crash in DisplayModel::GoToPage"
+func genCrashIssueTitle(s *CrashSignatureStats) string {
+	top, _, _ := strings.Cut(s.Signature, " | ")
+	if _, fn, ok := strings.Cut(top, "!"); ok {
+		top = fn
+	}
+	return "Crash in " + top
+}
+
+func fileCrashIssueMust(repo string, s *CrashSignatureStats) {
+	body := map[string]interface{}{
+		"title":  genCrashIssueTitle(s),
+		"body":   genCrashIssueBody(s),
+		"labels": []string{crashIssueLabel},
+	}
+	var res CrashIssue
+	githubAPIMust(http.MethodPost, "/repos/"+repo+"/issues", body, &res)
+	logf("filed %s for %s\n", res.HTMLURL, s.Signature)
+}
+
+func commentCrashIssueMust(repo string, issue *CrashIssue, s *CrashSignatureStats, why string) {
+	body := map[string]string{
+		"body": why + "\n\n" + genCrashIssueCounts(s),
+	}
+	githubAPIMust(http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", repo, issue.Number), body, nil)
+	logf("commented on %s: %s\n", issue.HTMLURL, why)
+}
+
+// -crash-file-issues ${dir}
+func fileCrashIssuesMust(dir string) {
+	panicIf(dir == "", "usage: -crash-file-issues ${dir with crash reports}")
+	agg := crashAggregateMust(dir)
+	repo := githubReleaseRepo
+	issues := getCrashIssuesMust(repo)
+	nFiled, nCommented := 0, 0
+	for _, s := range agg.Signatures {
+		if s.Count < crashIssueMinCount {
+			// sorted by count, the rest have even fewer
+			break
+		}
+		if s.Signature == "(no callstack)" {
+			continue
+		}
+		issue := issues[getCrashSignatureHash(s.Signature)]
+		if issue == nil {
+			fileCrashIssueMust(repo, s)
+			nFiled++
+			continue
+		}
+		reported := getCrashIssueReportedCountMust(repo, issue)
+		if issue.State == "closed" && s.LastSeen.After(issue.ClosedAt) && s.Count > reported {
+			commentCrashIssueMust(repo, issue, s, "Still happens after this issue was closed.")
+			nCommented++
+			continue
+		}
+		if s.Count >= reported*crashIssueCommentGrowth {
+			commentCrashIssueMust(repo, issue, s, fmt.Sprintf("Number of reports grew from %d to %d.", reported, s.Count))
+			nCommented++
+		}
+	}
+	logf("filed %d and commented on %d crash issues in %s\n", nFiled, nCommented, repo)
+}
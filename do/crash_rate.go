@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Before -promote-prerel makes a release out of a pre-release we ask the
+// crash report store (where the app uploads crash reports, see
+// src/CrashHandler.cpp) how often the pre-release crashes and compare it
+// with the current stable release. Promotion is blocked if the crash rate
+// regresses by more than crashRateMaxRegression, if not enough people used
+// the pre-release to tell or if it has new or regressed crash signatures
+// (see crash_trend.go). -ignore-crash-rate overrides after a human looked
+// at the report.
+//
+// Crash rate is number of crash reports per 1000 users (unique installs that
+// checked for updates) in the last crashRateDays days.
+// -crash-rate ${build} shows the report without promoting.
+//
+// Needs CRASH_STATS_TOKEN.
+
+var (
+	crashStatsToken string
+	ignoreCrashRate bool
+)
+
+const (
+	crashStatsAPIURL = "https://www.sumatrapdfreader.org/api/crash-stats"
+	crashRateDays    = 14
+	// we need that many users of pre-release for crash rate to mean anything
+	crashRateMinUsers = 200
+	// crash rate of pre-release can be at most this much of stable
+	crashRateMaxRegression = 1.25
+	// per 1000 users, so that we don't block when stable has ~0 crashes
+	crashRateMinAllowed = 1.0
+	nTopCrashSignatures = 10
+)
+
+// CrashSignature is a group of crash reports with the same crashing function
+type CrashSignature struct {
+	Signature string `json:"signature"`
+	Count     int    `json:"count"`
+}
+
+// CrashStats is crash stats of a version from the crash report store
+type CrashStats struct {
+	// as reported by the app: build number for pre-release e.g. "16234",
+	// "3.5.2" for release
+	Version string `json:"version"`
+	Reports int    `json:"reports"`
+	Users   int    `json:"users"`
+	// most frequent first
+	Signatures []*CrashSignature `json:"signatures"`
+}
+
+// crash reports per 1000 users
+func (s *CrashStats) rate() float64 {
+	if s.Users == 0 {
+		return 0
+	}
+	return float64(s.Reports) * 1000 / float64(s.Users)
+}
+
+func getCrashStatsMust(ver string) *CrashStats {
+	v := url.Values{}
+	v.Set("ver", ver)
+	v.Set("days", fmt.Sprintf("%d", crashRateDays))
+	uri := crashStatsAPIURL + "?" + v.Encode()
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	must(err)
+	req.Header.Set("Authorization", "Bearer "+crashStatsToken)
+	req.Header.Set("Accept", "application/json")
+	client := &http.Client{Timeout: time.Minute}
+	rsp, err := client.Do(req)
+	must(err)
+	defer rsp.Body.Close()
+	d, err := io.ReadAll(rsp.Body)
+	must(err)
+	panicIf(rsp.StatusCode != http.StatusOK, "GET %s failed with status code %d, body: '%s'", uri, rsp.StatusCode, string(d))
+	var res CrashStats
+	must(json.Unmarshal(d, &res))
+	return &res
+}
+
+func logCrashStats(title string, s *CrashStats) {
+	logf("%s %s: %d crash reports from %d users in last %d days, %.2f per 1000 users\n", title, s.Version, s.Reports, s.Users, crashRateDays, s.rate())
+}
+
+// returns "" if crash rate of candidate is ok, reason why not otherwise
+func checkCrashRate(candidate *CrashStats, stable *CrashStats) string {
+	if candidate.Users < crashRateMinUsers {
+		return fmt.Sprintf("only %d users of %s, need at least %d to compare crash rate", candidate.Users, candidate.Version, crashRateMinUsers)
+	}
+	allowed := stable.rate() * crashRateMaxRegression
+	if allowed < crashRateMinAllowed {
+		allowed = crashRateMinAllowed
+	}
+	if candidate.rate() > allowed {
+		return fmt.Sprintf("crash rate of %s is %.2f per 1000 users, %s is %.2f (allowed up to %.2f)", candidate.Version, candidate.rate(), stable.Version, stable.rate(), allowed)
+	}
+	return ""
+}
+
+// compares crash rate of pre-release build with stable release stableVer,
+// panics if it regressed unless -ignore-crash-rate
+func verifyCrashRateMust(build string, stableVer string) {
+	if crashStatsToken == "" {
+		panicIf(!ignoreCrashRate, "can't check crash rate of %s because CRASH_STATS_TOKEN env variable not set, use -ignore-crash-rate to promote anyway", build)
+		logf("Not checking crash rate because CRASH_STATS_TOKEN env variable not set\n")
+		return
+	}
+	stable := getCrashStatsMust(stableVer)
+	candidate := getCrashStatsMust(build)
+	logCrashStats("stable", stable)
+	logCrashStats("pre-release", candidate)
+	trends := compareCrashStats(stable, candidate)
+	logCrashTrends(stable, candidate, trends)
+	var reasons []string
+	for _, r := range []string{checkCrashRate(candidate, stable), checkCrashTrends(trends)} {
+		if r != "" {
+			reasons = append(reasons, r)
+		}
+	}
+	reason := strings.Join(reasons, "\n")
+	if reason == "" {
+		logf("crash rate of %s is ok\n", build)
+		return
+	}
+	panicIf(!ignoreCrashRate, "%s\nuse -ignore-crash-rate to promote anyway", reason)
+	logf("ignoring because -ignore-crash-rate: %s\n", reason)
+}
+
+// returns version of the latest release in storage
+func getLatestReleaseVersionMust(storage StorageBackend) string {
+	vers := listRemoteVersionsMust(storage, buildTypeRel)
+	panicIf(len(vers) == 0, "no releases in %s", storage.URLBase())
+	return vers[0]
+}
+
+// -crash-rate ${build}
+func crashRateMust(build string) {
+	panicIf(build == "", "usage: -crash-rate ${build} e.g. -crash-rate 16234")
+	panicIf(b2Access == "" || b2Secret == "", "need BB_ACCESS and BB_SECRET env variables to find latest release")
+	verifyCrashRateMust(build, getLatestReleaseVersionMust(newBackblazeStorage()))
+}
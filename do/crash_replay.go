@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// -crash-replay [${dir}|corpus[:${category}]] replays documents that crashed
+// in the past (by default known-bad documents of the corpus) against release
+// 64-bit SumatraPDF.exe running under cdb.exe, in every scenario of
+// crashReplayScenarios.
+//
+// If it still crashes, we save a full dump and its analysis in
+// out/crash-replay/${name}-${scenario}.dmp, the same way as -analyze-dumps
+// does, so -crash-aggregate out/crash-replay groups them by signature.
+// Fails if any document crashes or hangs.
+
+const crashReplayTimeout = 2 * time.Minute
+
+var crashReplayDir = filepath.Join("out", "crash-replay")
+
+// CrashReplayScenario is how we exercise a document
+type CrashReplayScenario struct {
+	Name string
+	Args func(path string) []string
+}
+
+var crashReplayScenarios = []*CrashReplayScenario{
+	// open and close, without running message loop
+	{"open", func(path string) []string { return []string{"-exit-on-startup", path} }},
+	// open, render all pages, close
+	{"render", func(path string) []string { return []string{"-bench", path} }},
+}
+
+// CrashReplayResult is a document that crashed or hang in a scenario
+type CrashReplayResult struct {
+	Doc      string
+	Scenario string
+	Hang     bool
+	Analysis *DumpAnalysis
+}
+
+// runs exe under cdb.exe, on crash writes full dump to dumpPath and
+// returns its analysis, returns error on hang
+func runUnderCdbWithDump(exe string, args []string, dumpPath string) (*DumpAnalysis, error) {
+	cdbPath := filepath.Join(debuggersDir, "cdb.exe")
+	panicIf(!fileExists(cdbPath), "didn't find '%s'. Install Debugging Tools for Windows", cdbPath)
+	c, cancel := context.WithTimeout(context.Background(), crashReplayTimeout)
+	defer cancel()
+	symPath := filepath.Dir(exe) + ";" + getCdbSymPath()
+	// -g: don't break at start, -G: exit when process exits, -x: break on
+	// access violations only when not handled. Commands only run if it breaks
+	cmds := fmt.Sprintf(".dump /ma /o %s;!analyze -v;lmvm SumatraPDF;q", dumpPath)
+	cdbArgs := append([]string{"-g", "-G", "-x", "-lines", "-y", symPath, "-c", cmds, exe}, args...)
+	out, _ := exec.CommandContext(c, cdbPath, cdbArgs...).CombinedOutput()
+	if c.Err() != nil {
+		return nil, fmt.Errorf("didn't finish in %s", crashReplayTimeout)
+	}
+	if !fileExists(dumpPath) {
+		return nil, nil
+	}
+	res := parseDumpAnalysis(string(out))
+	res.Path = dumpPath
+	return res, nil
+}
+
+func collectCrashReplayDocsMust(dir string) []string {
+	var res []string
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err == nil && !e.IsDir() {
+			res = append(res, path)
+		}
+		return err
+	})
+	must(err)
+	return res
+}
+
+// -crash-replay [${dir}|corpus[:${category}]]
+func crashReplayMust(arg string) {
+	panicIf(runtime.GOOS != "windows", "-crash-replay only works on Windows")
+	if arg == "" {
+		arg = "corpus:" + corpusBadDir
+	}
+	dir := getCorpusDirMust(arg)
+	panicIf(!dirExists(dir), "usage: -crash-replay [${dir}|corpus[:${category}]]")
+	paths := collectCrashReplayDocsMust(dir)
+	if len(paths) == 0 {
+		logf("no documents in '%s'\n", dir)
+		return
+	}
+	exe := getRel64ExeMust()
+
+	must(os.RemoveAll(crashReplayDir))
+	must(os.MkdirAll(crashReplayDir, 0755))
+	appDataDir := filepath.Join(crashReplayDir, "appdata")
+	var results []*CrashReplayResult
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		must(err)
+		rel = filepath.ToSlash(rel)
+		for _, sc := range crashReplayScenarios {
+			// fresh settings so that one run doesn't affect another
+			must(os.RemoveAll(appDataDir))
+			must(os.MkdirAll(appDataDir, 0755))
+			name := strings.ReplaceAll(rel, "/", "_") + "-" + sc.Name
+			dumpPath := absPathMust(filepath.Join(crashReplayDir, name+".dmp"))
+			args := append([]string{"-appdata", appDataDir}, sc.Args(path)...)
+			a, err := runUnderCdbWithDump(exe, args, dumpPath)
+			if err != nil {
+				logf("%s %s: %s\n", rel, sc.Name, err)
+				push(&results, &CrashReplayResult{Doc: rel, Scenario: sc.Name, Hang: true})
+				continue
+			}
+			if a == nil {
+				logf("%s %s: ok\n", rel, sc.Name)
+				continue
+			}
+			writeDumpAnalysisMust(a)
+			push(&results, &CrashReplayResult{Doc: rel, Scenario: sc.Name, Analysis: a})
+		}
+	}
+	must(os.RemoveAll(appDataDir))
+
+	var failed []string
+	for _, r := range results {
+		if r.Hang {
+			failed = append(failed, fmt.Sprintf("%s %s: hang", r.Doc, r.Scenario))
+			continue
+		}
+		failed = append(failed, fmt.Sprintf("%s %s: %s %s (%s)", r.Doc, r.Scenario, r.Analysis.ExceptionCode, getCrashSignature(r.Analysis.Frames), r.Analysis.Path))
+	}
+	panicIf(len(failed) > 0, "%d of %d replays crashed or hang, run -crash-aggregate %s to group them:\n%s", len(failed), len(paths)*len(crashReplayScenarios), crashReplayDir, strings.Join(failed, "\n"))
+	logf("none of %d documents crashed or hang in %d scenarios\n", len(paths), len(crashReplayScenarios))
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Crash reporting services symbolicate and group minidumps on their side.
+// Selected with CRASH_SERVICE (in secrets file or env variable):
+//   - "sentry" : SENTRY_ORG, SENTRY_PROJECT and SENTRY_AUTH_TOKEN (with
+//     project:write scope) to upload symbols, SENTRY_URL if self-hosted,
+//     SENTRY_DSN for the app to submit minidumps
+//     https://docs.sentry.io/platforms/native/guides/minidumps/
+//   - "backtrace" : BACKTRACE_UNIVERSE, BACKTRACE_SYMBOL_TOKEN to upload
+//     symbols, BACKTRACE_SUBMIT_TOKEN for the app to submit minidumps
+//     https://docs.saucelabs.com/error-reporting/platform-integrations/minidump/
+//
+// buildAndUploadSymbols() uploads symbols package of every build (which has
+// build-ids.json, see createSymbolsPackageMust) to configured service.
+// If minidump submit url is known, setBuildConfigPreRelease() writes it to
+// src/utils/BuildConfig.h as CRASH_SUBMIT_SERVER, CRASH_SUBMIT_PORT and
+// CRASH_SUBMIT_PATH and src/CrashHandler.cpp of pre-release builds uploads
+// minidump there, in addition to crash report sent to our server.
+const (
+	crashServiceSentry    = "sentry"
+	crashServiceBacktrace = "backtrace"
+)
+
+var (
+	crashServiceName string
+
+	sentryURL       string
+	sentryOrg       string
+	sentryProject   string
+	sentryAuthToken string
+	sentryDSN       string
+
+	backtraceUniverse    string
+	backtraceSymbolToken string
+	backtraceSubmitToken string
+)
+
+// CrashService is a crash reporting service that accepts minidumps
+type CrashService interface {
+	Name() string
+	// returns description of missing configuration or "" if can upload symbols
+	Missing() string
+	// uploads a .zip with .pdb and binaries
+	UploadSymbols(zipPath string) error
+	// url to which the app posts minidumps, "" if not configured
+	MinidumpSubmitURL() string
+}
+
+// returns nil if CRASH_SERVICE is not set
+func getCrashService() CrashService {
+	switch strings.ToLower(strings.TrimSpace(crashServiceName)) {
+	case "":
+		return nil
+	case crashServiceSentry:
+		return &sentryService{}
+	case crashServiceBacktrace:
+		return &backtraceService{}
+	}
+	panicIf(true, "unknown CRASH_SERVICE '%s', must be '%s' or '%s'", crashServiceName, crashServiceSentry, crashServiceBacktrace)
+	return nil
+}
+
+// sends request, returns response body
+func crashServiceDo(svc CrashService, req *http.Request) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Minute}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	d, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s: %s %s failed with status code %d, body: '%s'", svc.Name(), req.Method, req.URL.Redacted(), rsp.StatusCode, string(d))
+	}
+	return d, nil
+}
+
+// sentryService uploads to sentry.io or self-hosted Sentry
+type sentryService struct{}
+
+func (s *sentryService) Name() string {
+	return crashServiceSentry
+}
+
+func (s *sentryService) Missing() string {
+	if sentryOrg == "" || sentryProject == "" || sentryAuthToken == "" {
+		return "SENTRY_ORG, SENTRY_PROJECT or SENTRY_AUTH_TOKEN env variable not set"
+	}
+	return ""
+}
+
+func (s *sentryService) apiURL() string {
+	if sentryURL == "" {
+		return "https://sentry.io"
+	}
+	return strings.TrimSuffix(sentryURL, "/")
+}
+
+// https://docs.sentry.io/api/projects/upload-a-new-file/
+func (s *sentryService) UploadSymbols(zipPath string) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile("file", filepath.Base(zipPath))
+	if err != nil {
+		return err
+	}
+	d, err := os.ReadFile(zipPath)
+	if err != nil {
+		return err
+	}
+	if _, err = fw.Write(d); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	uri := fmt.Sprintf("%s/api/0/projects/%s/%s/files/dsyms/", s.apiURL(), sentryOrg, sentryProject)
+	req, err := http.NewRequest(http.MethodPost, uri, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+sentryAuthToken)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	d, err = crashServiceDo(s, req)
+	if err != nil {
+		return err
+	}
+	// a list of debug files it found in the .zip
+	var files []struct {
+		DebugID    string `json:"debugId"`
+		ObjectName string `json:"objectName"`
+	}
+	if err = json.Unmarshal(d, &files); err != nil {
+		return err
+	}
+	for _, f := range files {
+		logf("sentry: uploaded %s %s\n", f.ObjectName, f.DebugID)
+	}
+	return nil
+}
+
+// DSN is https://${key}@${host}/${projectID}, minidump endpoint is
+// https://${host}/api/${projectID}/minidump/?sentry_key=${key}
+func (s *sentryService) MinidumpSubmitURL() string {
+	if sentryDSN == "" {
+		return ""
+	}
+	u, err := url.Parse(sentryDSN)
+	panicIf(err != nil || u.User == nil, "invalid SENTRY_DSN '%s'", sentryDSN)
+	projectID := strings.Trim(u.Path, "/")
+	return fmt.Sprintf("%s://%s/api/%s/minidump/?sentry_key=%s", u.Scheme, u.Host, projectID, u.User.Username())
+}
+
+// backtraceService uploads to Backtrace (now Sauce Labs Error Reporting)
+type backtraceService struct{}
+
+func (s *backtraceService) Name() string {
+	return crashServiceBacktrace
+}
+
+func (s *backtraceService) Missing() string {
+	if backtraceUniverse == "" || backtraceSymbolToken == "" {
+		return "BACKTRACE_UNIVERSE or BACKTRACE_SYMBOL_TOKEN env variable not set"
+	}
+	return ""
+}
+
+func (s *backtraceService) UploadSymbols(zipPath string) error {
+	d, err := os.ReadFile(zipPath)
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Set("format", "symbols")
+	v.Set("token", backtraceSymbolToken)
+	v.Set("tag", strings.TrimSuffix(filepath.Base(zipPath), ".zip"))
+	uri := fmt.Sprintf("https://%s.sp.backtrace.io:6098/post?%s", backtraceUniverse, v.Encode())
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/zip")
+	_, err = crashServiceDo(s, req)
+	return err
+}
+
+func (s *backtraceService) MinidumpSubmitURL() string {
+	if backtraceUniverse == "" || backtraceSubmitToken == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://submit.backtrace.io/%s/%s/minidump", backtraceUniverse, backtraceSubmitToken)
+}
+
+// BuildIDFile is a binary or .pdb in build-ids.json
+type BuildIDFile struct {
+	Arch string `json:"arch"`
+	Name string `json:"name"`
+	// ${GUID}${Age} for .pdb, ${TimeDateStamp}${SizeOfImage} for binaries,
+	// as in symbol store
+	Key string `json:"key"`
+}
+
+// BuildIDs maps a build to identifiers crash reporting services use to
+// find symbols for a minidump
+type BuildIDs struct {
+	BuildType BuildType      `json:"buildType"`
+	Version   string         `json:"version"`
+	GitSha1   string         `json:"gitSha1"`
+	Files     []*BuildIDFile `json:"files"`
+}
+
+func genBuildIDs(buildType BuildType, files []*SymbolFile) []byte {
+	res := &BuildIDs{
+		BuildType: buildType,
+		Version:   getVerForBuildType(buildType),
+		GitSha1:   getGitSha1(),
+	}
+	for _, sf := range files {
+		// ${name}/${key}/${name}
+		parts := strings.Split(sf.StorePath, "/")
+		push(&res.Files, &BuildIDFile{
+			Arch: sf.Arch,
+			Name: parts[0],
+			Key:  parts[1],
+		})
+	}
+	d, err := json.MarshalIndent(res, "", "  ")
+	must(err)
+	return d
+}
+
+func uploadSymbolsToCrashServiceMust(pkgPath string) {
+	svc := getCrashService()
+	if svc == nil {
+		return
+	}
+	if missing := svc.Missing(); missing != "" {
+		logf("skipping upload of symbols to %s: %s\n", svc.Name(), missing)
+		return
+	}
+	timeStart := time.Now()
+	must(svc.UploadSymbols(pkgPath))
+	logf("uploaded '%s' to %s in %s\n", pkgPath, svc.Name(), time.Since(timeStart))
+}
+
+// #define lines for src/utils/BuildConfig.h, "" if crash service
+// doesn't accept minidumps from the app
+func getCrashSubmitBuildConfig() string {
+	svc := getCrashService()
+	if svc == nil {
+		return ""
+	}
+	uri := svc.MinidumpSubmitURL()
+	if uri == "" {
+		return ""
+	}
+	u, err := url.Parse(uri)
+	must(err)
+	panicIf(u.Scheme != "https", "minidump submit url '%s' must be https", uri)
+	// HttpPost() in src/utils/HttpUtil.cpp only uses TLS for port 443
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	panicIf(port != "443", "minidump submit url '%s' must use port 443", uri)
+	s := fmt.Sprintf("#define CRASH_SUBMIT_SERVER \"%s\"\n", u.Hostname())
+	s += fmt.Sprintf("#define CRASH_SUBMIT_PORT %s\n", port)
+	s += fmt.Sprintf("#define CRASH_SUBMIT_PATH \"%s\"\n", u.RequestURI())
+	return s
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Crash trend compares how often every crash signature happens in two
+// versions and finds signatures that are new in the candidate or happen
+// much more often than in the base version.
+//
+// Frequency is per 1000 users if we know the number of users of both
+// versions (crash report store), per 100 crash reports otherwise (crash
+// reports aggregated locally with -crash-aggregate).
+//
+// -promote-prerel is blocked if a new or regressed signature has at least
+// crashTrendBlockCount reports (see verifyCrashRateMust).
+// -crash-trend ${base} ${candidate} [${dir}] shows the report, from crash
+// report store or from crash reports in ${dir}.
+
+const (
+	// signatures with fewer reports in candidate are noise
+	crashTrendMinCount = 5
+	// frequency in candidate can be at most this much of base
+	crashTrendMaxRegression = 1.5
+	// new or regressed signature with that many reports blocks promotion
+	crashTrendBlockCount = 10
+)
+
+const (
+	crashTrendNew       = "new"
+	crashTrendRegressed = "regressed"
+	crashTrendFixed     = "fixed"
+	crashTrendImproved  = "improved"
+)
+
+// CrashSignatureTrend is how a signature changed between versions
+type CrashSignatureTrend struct {
+	Signature string
+	BaseCount int
+	Count     int
+	BaseFreq  float64
+	Freq      float64
+	// crashTrendNew etc., "" if no significant change
+	Status string
+}
+
+func (t *CrashSignatureTrend) isRegression() bool {
+	return t.Status == crashTrendNew || t.Status == crashTrendRegressed
+}
+
+func crashSignatureCounts(s *CrashStats) map[string]int {
+	res := map[string]int{}
+	for _, sig := range s.Signatures {
+		res[sig.Signature] += sig.Count
+	}
+	return res
+}
+
+// per 1000 users if byUsers, per 100 reports otherwise
+func crashFreq(s *CrashStats, count int, byUsers bool) float64 {
+	if byUsers {
+		return float64(count) * 1000 / float64(s.Users)
+	}
+	if s.Reports == 0 {
+		return 0
+	}
+	return float64(count) * 100 / float64(s.Reports)
+}
+
+// returns trends of all signatures of both versions, regressions first,
+// then by number of reports in candidate
+func compareCrashStats(base *CrashStats, candidate *CrashStats) []*CrashSignatureTrend {
+	byUsers := base.Users > 0 && candidate.Users > 0
+	baseCounts := crashSignatureCounts(base)
+	counts := crashSignatureCounts(candidate)
+	var res []*CrashSignatureTrend
+	addTrend := func(sig string) {
+		t := &CrashSignatureTrend{
+			Signature: sig,
+			BaseCount: baseCounts[sig],
+			Count:     counts[sig],
+		}
+		t.BaseFreq = crashFreq(base, t.BaseCount, byUsers)
+		t.Freq = crashFreq(candidate, t.Count, byUsers)
+		switch {
+		case t.Count == 0:
+			t.Status = crashTrendFixed
+		case t.Count < crashTrendMinCount:
+			// not enough reports to tell
+		case t.BaseCount == 0:
+			t.Status = crashTrendNew
+		case t.Freq > t.BaseFreq*crashTrendMaxRegression:
+			t.Status = crashTrendRegressed
+		case t.Freq*crashTrendMaxRegression < t.BaseFreq:
+			t.Status = crashTrendImproved
+		}
+		res = append(res, t)
+	}
+	for sig := range counts {
+		addTrend(sig)
+	}
+	for sig := range baseCounts {
+		if _, ok := counts[sig]; !ok {
+			addTrend(sig)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.isRegression() != b.isRegression() {
+			return a.isRegression()
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.BaseCount != b.BaseCount {
+			return a.BaseCount > b.BaseCount
+		}
+		return a.Signature < b.Signature
+	})
+	return res
+}
+
+func logCrashTrends(base *CrashStats, candidate *CrashStats, trends []*CrashSignatureTrend) {
+	unit := "per 100 reports"
+	if base.Users > 0 && candidate.Users > 0 {
+		unit = "per 1000 users"
+	}
+	logf("crash signatures of %s compared with %s (%s):\n", candidate.Version, base.Version, unit)
+	n := 0
+	for _, t := range trends {
+		// all regressions and the most frequent of the rest
+		if !t.isRegression() && n >= nTopCrashSignatures {
+			continue
+		}
+		n++
+		status := ""
+		if t.Status != "" {
+			status = " " + strings.ToUpper(t.Status)
+		}
+		logf("  %5d (%6.2f), was %5d (%6.2f)%s %s\n", t.Count, t.Freq, t.BaseCount, t.BaseFreq, status, t.Signature)
+	}
+}
+
+// returns "" if there are no significant regressions, reason why
+// promotion should be blocked otherwise
+func checkCrashTrends(trends []*CrashSignatureTrend) string {
+	var bad []string
+	for _, t := range trends {
+		if t.isRegression() && t.Count >= crashTrendBlockCount {
+			bad = append(bad, fmt.Sprintf("%s (%s, %d reports)", t.Signature, t.Status, t.Count))
+		}
+	}
+	if len(bad) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d new or regressed crash signatures:\n  %s", len(bad), strings.Join(bad, "\n  "))
+}
+
+// crash stats of version ver from crash reports aggregated locally
+func crashStatsFromAggregate(agg *CrashAggregate, ver string) *CrashStats {
+	res := &CrashStats{Version: ver}
+	for _, s := range agg.Signatures {
+		n := s.ByVersion[ver]
+		if n == 0 {
+			continue
+		}
+		res.Reports += n
+		res.Signatures = append(res.Signatures, &CrashSignature{Signature: s.Signature, Count: n})
+	}
+	sort.SliceStable(res.Signatures, func(i, j int) bool {
+		return res.Signatures[i].Count > res.Signatures[j].Count
+	})
+	return res
+}
+
+// -crash-trend ${base} ${candidate} [${dir}]
+func crashTrendMust(args []string) {
+	panicIf(len(args) < 2 || len(args) > 3, "usage: -crash-trend ${base version} ${candidate version} [${dir with crash reports}]")
+	var base, candidate *CrashStats
+	if len(args) == 3 {
+		agg := crashAggregateMust(args[2])
+		base = crashStatsFromAggregate(agg, args[0])
+		candidate = crashStatsFromAggregate(agg, args[1])
+	} else {
+		panicIf(crashStatsToken == "", "need CRASH_STATS_TOKEN env variable or ${dir} with crash reports")
+		base = getCrashStatsMust(args[0])
+		candidate = getCrashStatsMust(args[1])
+	}
+	trends := compareCrashStats(base, candidate)
+	logCrashTrends(base, candidate, trends)
+	if reason := checkCrashTrends(trends); reason != "" {
+		logf("%s\n", reason)
+	}
+}
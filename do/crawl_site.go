@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -crawl-site ${url} crawls the website (e.g. after -deploy-website) starting
+// at ${url} and checks that:
+//   - every link, image, script and stylesheet on our host returns 200
+//     (after redirects)
+//   - https pages don't load resources (images, scripts, stylesheets) over
+//     http i.e. there's no mixed content
+//
+// Only pages on the same host as ${url} are crawled. Links to other sites
+// are not checked.
+//
+// Results are saved in out/crawl-site/${host}-${time}.json. We compare with
+// the previous results for the same host and fail if there are new problems,
+// which were most likely introduced by the last deploy. Report with all
+// problems is next to results, in .txt file.
+
+const (
+	crawlSiteMaxPages = 5000
+	crawlSiteWorkers  = 8
+	crawlSiteTimeout  = 30 * time.Second
+)
+
+var crawlSiteDir = filepath.Join("out", "crawl-site")
+
+// `<a class="x" href="/docs">` => "a", "href", "/docs"
+var rxCrawlSiteRef = regexp.MustCompile(`(?is)<(\w+)\b[^>]*?\b(href|src)\s*=\s*["']([^"']*)["']`)
+
+// CrawlSiteProblem is a broken url or mixed content
+type CrawlSiteProblem struct {
+	URL string `json:"url"`
+	// "404", "error: ..." or "mixed content"
+	Problem string `json:"problem"`
+	// pages that refer to URL, at most a few
+	Pages []string `json:"pages"`
+}
+
+// CrawlSiteResults is the result of -crawl-site
+type CrawlSiteResults struct {
+	Time     time.Time           `json:"time"`
+	URL      string              `json:"url"`
+	NPages   int                 `json:"nPages"`
+	NChecked int                 `json:"nChecked"`
+	Problems []*CrawlSiteProblem `json:"problems"`
+}
+
+func (p *CrawlSiteProblem) key() string {
+	return p.Problem + " " + p.URL
+}
+
+// CrawlSiteRef is a url referenced from a page
+type CrawlSiteRef struct {
+	Tag  string
+	Attr string
+	URL  *url.URL
+}
+
+// returns urls referenced from page, resolved against pageURL and without
+// fragments. Skips mailto:, javascript: etc.
+func extractCrawlSiteRefs(pageURL *url.URL, body string) []*CrawlSiteRef {
+	var res []*CrawlSiteRef
+	for _, m := range rxCrawlSiteRef.FindAllStringSubmatch(body, -1) {
+		s := strings.TrimSpace(m[3])
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		u, err := pageURL.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		u.Fragment = ""
+		res = append(res, &CrawlSiteRef{
+			Tag:  strings.ToLower(m[1]),
+			Attr: strings.ToLower(m[2]),
+			URL:  u,
+		})
+	}
+	return res
+}
+
+// <a href> is navigation, everything else is loaded by the browser with
+// the page
+func isCrawlSiteResource(r *CrawlSiteRef) bool {
+	return r.Attr == "src" || r.Tag == "link"
+}
+
+// CrawlSite is the state of a crawl
+type CrawlSite struct {
+	host   string
+	client *http.Client
+
+	mu sync.Mutex
+	// url => pages that refer to it
+	referrers map[string][]string
+	// url => problem, "" if ok
+	checked  map[string]string
+	mixed    map[string][]string
+	nPages   int
+	queue    chan string
+	inFlight sync.WaitGroup
+}
+
+// fetches uri, returns problem ("" if ok) and body if it's html on our host
+func (c *CrawlSite) fetch(uri string) (string, string) {
+	rsp, err := c.client.Get(uri)
+	if err != nil {
+		return "error: " + err.Error(), ""
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("%d", rsp.StatusCode), ""
+	}
+	isHTML := strings.HasPrefix(rsp.Header.Get("Content-Type"), "text/html")
+	if !isHTML || rsp.Request.URL.Host != c.host {
+		io.Copy(io.Discard, rsp.Body)
+		return "", ""
+	}
+	d, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return "error: " + err.Error(), ""
+	}
+	return "", string(d)
+}
+
+// adds uri to the queue if we didn't see it yet
+func (c *CrawlSite) add(uri string, fromPage string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fromPage != "" && len(c.referrers[uri]) < 5 {
+		c.referrers[uri] = append(c.referrers[uri], fromPage)
+	}
+	if _, ok := c.checked[uri]; ok {
+		return
+	}
+	if len(c.checked) >= crawlSiteMaxPages {
+		return
+	}
+	c.checked[uri] = ""
+	c.inFlight.Add(1)
+	go func() {
+		c.queue <- uri
+	}()
+}
+
+func (c *CrawlSite) visit(uri string) {
+	defer c.inFlight.Done()
+	problem, body := c.fetch(uri)
+	c.mu.Lock()
+	c.checked[uri] = problem
+	if body != "" {
+		c.nPages++
+	}
+	c.mu.Unlock()
+	if problem != "" {
+		logf("%s: %s\n", uri, problem)
+	}
+	if body == "" {
+		return
+	}
+	pageURL, err := url.Parse(uri)
+	must(err)
+	for _, r := range extractCrawlSiteRefs(pageURL, body) {
+		if pageURL.Scheme == "https" && r.URL.Scheme == "http" && isCrawlSiteResource(r) {
+			c.mu.Lock()
+			c.mixed[r.URL.String()] = append(c.mixed[r.URL.String()], uri)
+			c.mu.Unlock()
+		}
+		if r.URL.Host != c.host {
+			continue
+		}
+		c.add(r.URL.String(), uri)
+	}
+}
+
+func (c *CrawlSite) getProblems() []*CrawlSiteProblem {
+	var res []*CrawlSiteProblem
+	for uri, problem := range c.checked {
+		if problem == "" {
+			continue
+		}
+		res = append(res, &CrawlSiteProblem{URL: uri, Problem: problem, Pages: c.referrers[uri]})
+	}
+	for uri, pages := range c.mixed {
+		if len(pages) > 5 {
+			pages = pages[:5]
+		}
+		res = append(res, &CrawlSiteProblem{URL: uri, Problem: "mixed content", Pages: pages})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].key() < res[j].key()
+	})
+	return res
+}
+
+// "127.0.0.1:8080" => "127.0.0.1_8080", ':' isn't valid in Windows file names
+func getCrawlSiteFileNamePrefix(host string) string {
+	return strings.ReplaceAll(host, ":", "_") + "-"
+}
+
+// returns results of the previous crawl of host, nil if there are none
+func readPrevCrawlSiteResults(host string) *CrawlSiteResults {
+	paths, _ := filepath.Glob(filepath.Join(crawlSiteDir, getCrawlSiteFileNamePrefix(host)+"*.json"))
+	if len(paths) == 0 {
+		return nil
+	}
+	// names end with time so the last is the most recent
+	sort.Strings(paths)
+	var res CrawlSiteResults
+	must(json.Unmarshal(readFileMust(paths[len(paths)-1]), &res))
+	return &res
+}
+
+func genCrawlSiteReport(res *CrawlSiteResults, newProblems []*CrawlSiteProblem) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "crawled %s on %s: %d pages, %d urls, %d problems\n", res.URL, res.Time.Format("2006-01-02 15:04"), res.NPages, res.NChecked, len(res.Problems))
+	writeProblems := func(title string, problems []*CrawlSiteProblem) {
+		if len(problems) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		for _, p := range problems {
+			fmt.Fprintf(&b, "  %s: %s\n", p.URL, p.Problem)
+			for _, page := range p.Pages {
+				fmt.Fprintf(&b, "    from %s\n", page)
+			}
+		}
+	}
+	writeProblems("new problems (compared to the previous crawl)", newProblems)
+	writeProblems("all problems", res.Problems)
+	return b.String()
+}
+
+// -crawl-site ${url}
+func crawlSiteMust(startURL string) {
+	panicIf(startURL == "", "usage: -crawl-site ${url}")
+	u, err := url.Parse(startURL)
+	panicIf(err != nil || u.Host == "", "'%s' is not a valid url", startURL)
+	c := &CrawlSite{
+		host:      u.Host,
+		client:    &http.Client{Timeout: crawlSiteTimeout},
+		referrers: map[string][]string{},
+		checked:   map[string]string{},
+		mixed:     map[string][]string{},
+		queue:     make(chan string),
+	}
+	for i := 0; i < crawlSiteWorkers; i++ {
+		go func() {
+			for uri := range c.queue {
+				c.visit(uri)
+			}
+		}()
+	}
+	logf("crawling %s\n", startURL)
+	timeStart := time.Now()
+	c.add(startURL, "")
+	c.inFlight.Wait()
+	close(c.queue)
+
+	res := &CrawlSiteResults{
+		Time:     timeStart,
+		URL:      startURL,
+		NPages:   c.nPages,
+		NChecked: len(c.checked),
+		Problems: c.getProblems(),
+	}
+	if res.NChecked >= crawlSiteMaxPages {
+		logf("stopped after checking %d urls\n", crawlSiteMaxPages)
+	}
+	var newProblems []*CrawlSiteProblem
+	prev := readPrevCrawlSiteResults(u.Host)
+	if prev != nil {
+		seen := map[string]bool{}
+		for _, p := range prev.Problems {
+			seen[p.key()] = true
+		}
+		for _, p := range res.Problems {
+			if !seen[p.key()] {
+				newProblems = append(newProblems, p)
+			}
+		}
+	}
+
+	must(os.MkdirAll(crawlSiteDir, 0755))
+	name := getCrawlSiteFileNamePrefix(u.Host) + timeStart.Format("2006-01-02_15-04-05")
+	d, err := json.MarshalIndent(res, "", "  ")
+	must(err)
+	writeFileMust(filepath.Join(crawlSiteDir, name+".json"), d)
+	report := genCrawlSiteReport(res, newProblems)
+	reportPath := filepath.Join(crawlSiteDir, name+".txt")
+	writeFileMust(reportPath, []byte(report))
+	logf("\n%s\nwrote '%s' in %s\n", report, reportPath, time.Since(timeStart))
+
+	if prev == nil {
+		logf("no previous crawl of %s to compare with\n", u.Host)
+		return
+	}
+	panicIf(len(newProblems) > 0, "%d new problems compared to crawl from %s, see '%s'", len(newProblems), prev.Time.Format("2006-01-02 15:04"), reportPath)
+}
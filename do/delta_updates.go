@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// -gen-delta-updates [rel] creates binary patches (bsdiff, see bsdiff.go)
+// from the previous nDeltaUpdateVersions pre-release (or release) builds
+// to the build in out/ and delta-updates.json index, which auto-updater
+// uses to find a patch for the version it's running.
+//
+// Previous builds are downloaded from storage to out/delta-updates/cache.
+// Patches and the index are added to artifacts.json so they're uploaded
+// with the build.
+//
+// We create patches for portable executable and the installer. A patch is
+// only worth it if it's much smaller than the file. Installer is mostly
+// compressed data so usually doesn't qualify.
+
+const (
+	nDeltaUpdateVersions  = 3
+	deltaUpdatesIndexName = "delta-updates.json"
+	// patch must be smaller than this percentage of the file it creates
+	deltaMaxSizePercent = 60
+)
+
+var deltaUpdatesCacheDir = filepath.Join("out", "delta-updates", "cache")
+
+// DeltaUpdate describes a patch from a previous version of a file
+type DeltaUpdate struct {
+	Kind        string `json:"kind"` // kArtifactPortableExe or kArtifactInstaller
+	Arch        string `json:"arch"`
+	FromVersion string `json:"fromVersion"`
+	FromSha256  string `json:"fromSha256"`
+	// the patch
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// file created by applying the patch
+	TargetName   string `json:"targetName"`
+	TargetSize   int64  `json:"targetSize"`
+	TargetSha256 string `json:"targetSha256"`
+}
+
+// DeltaUpdatesIndex is content of delta-updates.json
+type DeltaUpdatesIndex struct {
+	BuildType BuildType      `json:"buildType"`
+	Version   string         `json:"version"`
+	Deltas    []*DeltaUpdate `json:"deltas"`
+}
+
+// name of artifact in builds that didn't have artifacts.json yet
+// see buildPreRelease() and buildRelease()
+func guessArtifactName(buildType BuildType, ver string, kind string, arch string) string {
+	prefix := "SumatraPDF-prerel-" + arch
+	if buildType == buildTypeRel {
+		prefix = "SumatraPDF-" + ver
+		if arch != "32" {
+			prefix += "-" + arch
+		}
+	}
+	switch kind {
+	case kArtifactPortableExe:
+		return prefix + ".exe"
+	case kArtifactInstaller:
+		return prefix + "-install.exe"
+	}
+	panicIf(true, "unsupported kind '%s'", kind)
+	return ""
+}
+
+// returns versions of builds in storage, newest first
+func listRemoteVersionsMust(storage StorageBackend, buildType BuildType) []string {
+	remoteDir := "software/sumatrapdf/" + string(buildType) + "/"
+	seen := map[string]bool{}
+	var res []string
+	objects, err := storage.List(remoteDir)
+	must(err)
+	for _, obj := range objects {
+		// software/sumatrapdf/rel/3.5.2/SumatraPDF-3.5.2-64.exe
+		rest := strings.TrimPrefix(obj.Key, remoteDir)
+		idx := strings.Index(rest, "/")
+		if idx <= 0 {
+			continue
+		}
+		ver := rest[:idx]
+		if !seen[ver] {
+			seen[ver] = true
+			res = append(res, ver)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return compareVersions(res[i], res[j]) > 0
+	})
+	return res
+}
+
+// returns nil if build doesn't have artifacts.json
+func downloadArtifactsManifest(storage StorageBackend, buildType BuildType, ver string) *ArtifactsManifest {
+	dir := filepath.Join(deltaUpdatesCacheDir, string(buildType), ver)
+	remotePath := path.Join("software/sumatrapdf", string(buildType), ver, artifactsManifestName)
+	if !storage.Exists(remotePath) {
+		return nil
+	}
+	must(storage.DownloadFileAtomically(filepath.Join(dir, artifactsManifestName), remotePath))
+	return readArtifactsManifest(dir)
+}
+
+// returns local path of a file from a previous build or "" if it doesn't exist
+func downloadPrevArtifact(storage StorageBackend, buildType BuildType, ver string, name string, sha256 string) string {
+	dst := filepath.Join(deltaUpdatesCacheDir, string(buildType), ver, name)
+	if fileExists(dst) && (sha256 == "" || fileSha256HexMust(dst) == sha256) {
+		return dst
+	}
+	remotePath := path.Join("software/sumatrapdf", string(buildType), ver, name)
+	if !storage.Exists(remotePath) {
+		return ""
+	}
+	logf("downloading '%s'\n", storage.URLForPath(remotePath))
+	must(storage.DownloadFileAtomically(dst, remotePath))
+	if sha256 != "" {
+		got := fileSha256HexMust(dst)
+		panicIf(got != sha256, "sha256 of '%s' is %s, expected %s", dst, got, sha256)
+	}
+	return dst
+}
+
+// returns nil if patch isn't worth it
+func genDeltaUpdateMust(oldPath string, a *ArtifactInfo, fromVer string, dir string) *DeltaUpdate {
+	old := readFileMust(oldPath)
+	newPath := filepath.FromSlash(a.Path)
+	new := readFileMust(newPath)
+	timeStart := makePrintDuration(fmt.Sprintf("bsdiff %s => %s", oldPath, newPath))
+	patch, err := bsdiff(old, new)
+	must(err)
+	timeStart()
+
+	// paranoid: make sure that the updater will get the right file
+	got, err := bspatch(old, patch)
+	must(err)
+	panicIf(sha256HexOfData(got) != a.Sha256, "applying patch for '%s' from %s didn't re-create the file", a.Name, fromVer)
+
+	percent := len(patch) * 100 / len(new)
+	logf("patch for '%s' from %s: %s (%d%% of %s)\n", a.Name, fromVer, formatSize(int64(len(patch))), percent, formatSize(int64(len(new))))
+	if percent > deltaMaxSizePercent {
+		logf("  not worth it, skipping\n")
+		return nil
+	}
+	name := fmt.Sprintf("%s.from-%s.bsdiff", a.Name, fromVer)
+	writeFileMust(filepath.Join(dir, name), patch)
+	return &DeltaUpdate{
+		Kind:         a.Kind,
+		Arch:         a.Arch,
+		FromVersion:  fromVer,
+		FromSha256:   sha256HexOfData(old),
+		Name:         name,
+		Size:         int64(len(patch)),
+		Sha256:       sha256HexOfData(patch),
+		TargetName:   a.Name,
+		TargetSize:   a.Size,
+		TargetSha256: a.Sha256,
+	}
+}
+
+func genDeltaUpdatesMust(buildType BuildType) {
+	defer makePrintDuration("genDeltaUpdatesMust")()
+	panicIf(r2Access == "" || r2Secret == "", "need R2_ACCESS and R2_SECRET to download previous builds")
+	dir := getFinalDirForBuildType(buildType)
+	am := readArtifactsManifestMust(dir)
+	storage := maybeDryRunStorage(newR2Storage())
+
+	var prevVers []string
+	for _, ver := range listRemoteVersionsMust(storage, buildType) {
+		if compareVersions(ver, am.Version) < 0 {
+			prevVers = append(prevVers, ver)
+		}
+		if len(prevVers) == nDeltaUpdateVersions {
+			break
+		}
+	}
+	logf("creating delta updates to %s from: %s\n", am.Version, strings.Join(prevVers, ", "))
+
+	prefix := getDownloadPrefixViaWebsite(buildType, am.Version)
+	index := &DeltaUpdatesIndex{
+		BuildType: buildType,
+		Version:   am.Version,
+	}
+	for _, fromVer := range prevVers {
+		prevAm := downloadArtifactsManifest(storage, buildType, fromVer)
+		for _, a := range am.Artifacts {
+			if a.Kind != kArtifactPortableExe && a.Kind != kArtifactInstaller {
+				continue
+			}
+			name := guessArtifactName(buildType, fromVer, a.Kind, a.Arch)
+			sha256 := ""
+			if prevAm != nil {
+				prev := prevAm.find(a.Kind, a.Arch)
+				if prev == nil {
+					continue
+				}
+				name, sha256 = prev.Name, prev.Sha256
+			}
+			oldPath := downloadPrevArtifact(storage, buildType, fromVer, name, sha256)
+			if oldPath == "" {
+				logf("%s build %s doesn't have '%s', skipping\n", buildType, fromVer, name)
+				continue
+			}
+			if d := genDeltaUpdateMust(oldPath, a, fromVer, dir); d != nil {
+				d.URL = prefix + d.Name
+				index.Deltas = append(index.Deltas, d)
+			}
+		}
+	}
+
+	d, err := json.MarshalIndent(index, "", "  ")
+	must(err)
+	writeFileMust(filepath.Join(dir, deltaUpdatesIndexName), d)
+
+	// patches are per-arch artifacts, the index covers all of them
+	byArch := map[string][]string{}
+	for _, d := range index.Deltas {
+		byArch[d.Arch] = append(byArch[d.Arch], d.Name)
+	}
+	for arch, names := range byArch {
+		addArtifactsToManifestMust(buildType, dir, getPlatformForArch(arch), names)
+	}
+	addArtifactsToManifestMust(buildType, dir, "", []string{deltaUpdatesIndexName})
+	logf("created %d delta updates, index in '%s'\n", len(index.Deltas), filepath.Join(dir, deltaUpdatesIndexName))
+}
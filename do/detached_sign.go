@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Installers and portable archives get detached signatures next to them,
+// so that users can verify downloads independently of Authenticode:
+//   - ${name}.minisig made with minisign key from MINISIGN_SECRET_KEY
+//     verify with: minisign -Vm ${name} -p minisign.pub
+//   - ${name}.asc made with gpg if GPG_KEY_ID is set and gpg is installed
+//     verify with: gpg --verify ${name}.asc ${name}
+//
+// Signatures are added to artifacts.json when the signed file is added so
+// they're uploaded, mirrored and listed in SHA256SUMS.txt like other files.
+//
+// Public keys go to docs/ (see minisignPublicKeyPath, -gen-minisign-key
+// writes minisign.pub). Once they are checked in, -gen-website and
+// -deploy-website copy them to the website. Until then we don't tell users
+// about the signatures in docs/md/Verify-downloads.md.
+
+var (
+	minisignSecretKey string
+	gpgKeyID          string
+)
+
+const (
+	minisignSigExt = ".minisig"
+	gpgSigExt      = ".asc"
+)
+
+var (
+	minisignPublicKeyPath = filepath.Join("docs", "minisign.pub")
+	gpgPublicKeyPath      = filepath.Join("docs", "sumatrapdf-gpg.asc")
+)
+
+// MinisignKey is a minisign key. MINISIGN_SECRET_KEY is base64 of
+// key id (8 bytes) followed by ed25519 seed (32 bytes)
+type MinisignKey struct {
+	KeyID   []byte
+	PrivKey ed25519.PrivateKey
+}
+
+// returns nil if MINISIGN_SECRET_KEY is not set
+func getMinisignKeyMust() *MinisignKey {
+	if minisignSecretKey == "" {
+		return nil
+	}
+	d, err := base64.StdEncoding.DecodeString(minisignSecretKey)
+	must(err)
+	panicIf(len(d) != 8+ed25519.SeedSize, "MINISIGN_SECRET_KEY must be %d bytes, is %d", 8+ed25519.SeedSize, len(d))
+	return &MinisignKey{
+		KeyID:   d[:8],
+		PrivKey: ed25519.NewKeyFromSeed(d[8:]),
+	}
+}
+
+// minisign shows key id as little-endian number
+func (k *MinisignKey) keyIDHex() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.KeyID))
+}
+
+// content of minisign.pub
+func (k *MinisignKey) publicKeyFile() string {
+	pub := k.PrivKey.Public().(ed25519.PublicKey)
+	d := append([]byte("Ed"), k.KeyID...)
+	d = append(d, pub...)
+	return fmt.Sprintf("untrusted comment: minisign public key %s\n%s\n", k.keyIDHex(), base64.StdEncoding.EncodeToString(d))
+}
+
+// returns content of .minisig file. We use pre-hashed (blake2b-512)
+// signatures, the default since minisign 0.8
+func minisignSign(k *MinisignKey, name string, data []byte) string {
+	hash := blake2b.Sum512(data)
+	sig := ed25519.Sign(k.PrivKey, hash[:])
+	sigLine := append([]byte("ED"), k.KeyID...)
+	sigLine = append(sigLine, sig...)
+
+	trustedComment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), name)
+	globalSig := ed25519.Sign(k.PrivKey, append(append([]byte{}, sig...), []byte(trustedComment)...))
+
+	var sb strings.Builder
+	sb.WriteString("untrusted comment: signature from minisign secret key\n")
+	sb.WriteString(base64.StdEncoding.EncodeToString(sigLine) + "\n")
+	sb.WriteString("trusted comment: " + trustedComment + "\n")
+	sb.WriteString(base64.StdEncoding.EncodeToString(globalSig) + "\n")
+	return sb.String()
+}
+
+// checks .minisig the way minisign -V does
+func minisignVerify(pubKey ed25519.PublicKey, data []byte, sigFile string) error {
+	lines := strings.Split(strings.TrimSpace(sigFile), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("invalid signature file")
+	}
+	sigLine, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sigLine) != 2+8+ed25519.SignatureSize || string(sigLine[:2]) != "ED" {
+		return fmt.Errorf("invalid signature")
+	}
+	sig := sigLine[10:]
+	hash := blake2b.Sum512(data)
+	if !ed25519.Verify(pubKey, hash[:], sig) {
+		return fmt.Errorf("signature verification failed")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		return err
+	}
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(pubKey, append(append([]byte{}, sig...), []byte(trustedComment)...), globalSig) {
+		return fmt.Errorf("trusted comment verification failed")
+	}
+	return nil
+}
+
+func canGpgSign() bool {
+	if gpgKeyID == "" {
+		return false
+	}
+	_, err := exec.LookPath("gpg")
+	return err == nil
+}
+
+// signs files in dir that should have detached signatures and returns
+// names of created signature files
+func signDetachedMust(dir string, names []string) []string {
+	key := getMinisignKeyMust()
+	useGpg := canGpgSign()
+	if key == nil && !useGpg {
+		return nil
+	}
+	var res []string
+	for _, name := range names {
+		if !artifactIsSignable(artifactKindFromName(name)) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if key != nil {
+			d := readFileMust(path)
+			sig := minisignSign(key, name, d)
+			pub := key.PrivKey.Public().(ed25519.PublicKey)
+			must(minisignVerify(pub, d, sig))
+			writeFileMust(path+minisignSigExt, []byte(sig))
+			res = append(res, name+minisignSigExt)
+		}
+		if useGpg {
+			sigPath := path + gpgSigExt
+			os.Remove(sigPath)
+			cmd := exec.Command("gpg", "--batch", "--yes", "--local-user", gpgKeyID, "--armor", "--detach-sign", "--output", sigPath, path)
+			runCmdLoggedMust(cmd)
+			runExeMust("gpg", "--batch", "--verify", sigPath, path)
+			res = append(res, name+gpgSigExt)
+		}
+		logf("created detached signatures for '%s'\n", path)
+	}
+	return res
+}
+
+// -gen-minisign-key prints a new key for MINISIGN_SECRET_KEY and writes
+// the public key to docs/minisign.pub, which must be checked in
+func genMinisignKey() {
+	var keyID [8]byte
+	_, err := rand.Read(keyID[:])
+	must(err)
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	must(err)
+	d := append(keyID[:], privKey.Seed()...)
+	logf("MINISIGN_SECRET_KEY=%s\n", base64.StdEncoding.EncodeToString(d))
+	k := &MinisignKey{KeyID: keyID[:], PrivKey: privKey}
+	writeFileMust(minisignPublicKeyPath, []byte(k.publicKeyFile()))
+	logf("wrote public key to '%s', check it in\n", minisignPublicKeyPath)
+}
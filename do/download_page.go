@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+)
+
+// download-free-pdf-viewer.html in website repo is generated from artifacts.json
+// of the latest release so that links, sizes and hashes are always right.
+// Regenerated together with sha256sums-rel.js (-update-website-sha256sums,
+// -promote-prerel, -rollback-release) or by hand with -gen-download-page.
+//
+// It uses the same layout as the rest of the website (see website.go).
+// Every file has data-arch, data-kind and data-sha256 attributes. A script
+// in the page detects the user's architecture in the browser and uses them
+// to point the download button to the installer for it. All files are
+// listed below the button.
+
+const downloadPageName = "download-free-pdf-viewer.html"
+
+// DownloadPageFile is a file in download page
+type DownloadPageFile struct {
+	// "64", "arm64", "32"
+	Arch string
+	// kArtifactInstaller etc.
+	Kind   string
+	Label  string
+	URL    string
+	Size   string
+	Sha256 string
+	// "" if not signed
+	MinisigURL string
+	// "" if there's no torrent
+	TorrentURL string
+	MagnetURL  string
+}
+
+// DownloadPageArch is a section of download page for one architecture
+type DownloadPageArch struct {
+	Arch  string // "64", "arm64", "32"
+	Title string
+	MinOs string
+	Files []*DownloadPageFile
+}
+
+// in the order we show them
+var downloadPageArchs = []struct {
+	arch  string
+	title string
+}{
+	{"64", "64-bit (x64)"},
+	{"arm64", "ARM64"},
+	{"32", "32-bit (x86)"},
+}
+
+// in the order we show them
+var downloadPageKinds = []struct {
+	kind  string
+	label string
+}{
+	{kArtifactInstaller, "Installer"},
+	{kArtifactPortableExe, "Portable version"},
+	{kArtifactPortableZip, "Portable version (zip)"},
+	{kArtifactPortable7z, "Portable version (7z)"},
+	{kArtifactMsi, "MSI installer (for IT admins)"},
+	{kArtifactMsix, "MSIX package"},
+}
+
+// see check_min_os.go
+func getMinOsForArch(arch string) string {
+	if arch == "arm64" {
+		return "Windows 10 or later"
+	}
+	names := map[string]string{
+		"6.1":  "Windows 7",
+		"6.2":  "Windows 8",
+		"6.3":  "Windows 8.1",
+		"10.0": "Windows 10",
+	}
+	name := names[fmt.Sprintf("%d.%d", minOsMajorVersion, minOsMinorVersion)]
+	panicIf(name == "", "unknown Windows version %d.%d", minOsMajorVersion, minOsMinorVersion)
+	return name + " or later"
+}
+
+func getDownloadPageArchs(am *ArtifactsManifest, prefix string) []*DownloadPageArch {
+	byName := map[string]*ArtifactInfo{}
+	for _, a := range am.Artifacts {
+		byName[a.Name] = a
+	}
+	var res []*DownloadPageArch
+	for _, pa := range downloadPageArchs {
+		arch := &DownloadPageArch{
+			Arch:  pa.arch,
+			Title: pa.title,
+			MinOs: getMinOsForArch(pa.arch),
+		}
+		for _, k := range downloadPageKinds {
+			a := am.find(k.kind, pa.arch)
+			if a == nil {
+				continue
+			}
+			f := &DownloadPageFile{
+				Arch:   pa.arch,
+				Kind:   k.kind,
+				Label:  k.label,
+				URL:    prefix + a.Name,
+				Size:   formatSize(a.Size),
+				Sha256: a.Sha256,
+			}
+			if byName[a.Name+minisignSigExt] != nil {
+				f.MinisigURL = prefix + a.Name + minisignSigExt
+			}
+			if t := byName[a.Name+torrentSuffix]; t != nil && t.InfoHash != "" {
+				f.TorrentURL = prefix + t.Name
+				f.MagnetURL = getMagnetURL(a, t, prefix+a.Name)
+			}
+			arch.Files = append(arch.Files, f)
+		}
+		if len(arch.Files) > 0 {
+			res = append(res, arch)
+		}
+	}
+	return res
+}
+
+// what we suggest when we can't detect the architecture: 64-bit installer,
+// nil if there are no files
+func getDefaultDownloadPageFile(archs []*DownloadPageArch) *DownloadPageFile {
+	for _, arch := range archs {
+		for _, f := range arch.Files {
+			if f.Kind == kArtifactInstaller {
+				return f
+			}
+		}
+	}
+	if len(archs) > 0 {
+		return archs[0].Files[0]
+	}
+	return nil
+}
+
+const downloadPageHead = `<style>
+.arch { margin-bottom: 1.5em; }
+.arch.recommended { background-color: #fffbe6; border-left: 4px solid #f0c000; padding-left: 8px; }
+.recommended-label { display: none; font-weight: bold; color: #a07800; }
+.arch.recommended .recommended-label { display: inline; }
+.sha256 { font-family: monospace; font-size: 0.8em; color: #666; word-break: break-all; }
+.download-btn { display: inline-block; padding: 8px 16px; background-color: #0078d4; color: white; font-weight: bold; text-decoration: none; border-radius: 4px; }
+td { padding: 2px 8px 2px 0; vertical-align: top; }
+</style>`
+
+// content of download page, rendered with website layout
+const downloadPageTmpl = `<div class="notion-page">
+<h2>Download SumatraPDF {{.Version}}</h2>
+<p>Released on {{.BuiltOn}}. <a href="/docs/Version-history">What's new</a>.</p>
+
+<p id="suggestion">Most people should get the installer for 64-bit Windows.</p>
+{{with .Default}}
+<p><a id="download-btn" class="download-btn" href="{{.URL}}">Download {{.Label}}</a></p>
+<p id="download-btn-sha256" class="sha256">SHA256: {{.Sha256}}</p>
+{{end}}
+
+{{range .Archs}}
+<div class="arch" id="arch-{{.Arch}}">
+<h3>{{.Title}} <span class="recommended-label">recommended for your computer</span></h3>
+<p>Requires {{.MinOs}}.</p>
+<table>
+{{range .Files}}<tr data-arch="{{.Arch}}" data-kind="{{.Kind}}" data-sha256="{{.Sha256}}">
+<td><a class="file" href="{{.URL}}">{{.Label}}</a></td>
+<td>{{.Size}}</td>
+<td>{{if .MinisigURL}}<a href="{{.MinisigURL}}">signature</a>{{end}}</td>
+<td>{{if .TorrentURL}}<a href="{{.TorrentURL}}">torrent</a> <a href="{{.MagnetURL}}">magnet</a>{{end}}</td>
+</tr>
+<tr><td colspan="4" class="sha256">SHA256: {{.Sha256}}</td></tr>
+{{end}}
+</table>
+</div>
+{{end}}
+
+<h3>Verifying downloads</h3>
+<p>Hashes of all files: <a href="{{.Sha256SumsURL}}">SHA256SUMS.txt</a>.
+See <a href="/docs/Verify-downloads">how to verify downloads</a>.</p>
+
+<h3>System requirements</h3>
+<ul>
+{{range .Archs}}<li>{{.Title}}: {{.MinOs}}</li>
+{{end}}</ul>
+<p>Not sure which one you need? <a href="https://support.microsoft.com/en-us/windows/which-version-of-windows-operating-system-am-i-running-628bec99-476a-2c13-5296-9dd081cdd808">Check your version of Windows</a>.</p>
+
+<script>
+// suggest build for this computer, best effort
+async function detectArch() {
+	var ua = navigator.userAgent;
+	if (ua.indexOf("Windows") < 0) {
+		return "";
+	}
+	var uad = navigator.userAgentData;
+	if (uad && uad.getHighEntropyValues) {
+		try {
+			var v = await uad.getHighEntropyValues(["architecture", "bitness"]);
+			if (v.architecture === "arm") {
+				return "arm64";
+			}
+			return v.bitness === "32" ? "32" : "64";
+		} catch (e) {
+		}
+	}
+	// 32-bit browser on 64-bit Windows says WOW64
+	if (/Win64|WOW64|x64/.test(ua)) {
+		return "64";
+	}
+	return "32";
+}
+detectArch().then(function(arch) {
+	var el = document.getElementById("arch-" + arch);
+	if (!el) {
+		return;
+	}
+	el.classList.add("recommended");
+	var title = el.querySelector("h3").firstChild.textContent.trim();
+	document.getElementById("suggestion").textContent = "Your computer runs " + title + " Windows.";
+	var row = el.querySelector('tr[data-kind="{{.DefaultKind}}"]');
+	var btn = document.getElementById("download-btn");
+	if (!row || !btn) {
+		return;
+	}
+	var a = row.querySelector("a.file");
+	btn.href = a.href;
+	btn.textContent = "Download " + a.textContent + " for " + title;
+	document.getElementById("download-btn-sha256").textContent = "SHA256: " + row.dataset.sha256;
+});
+</script>
+</div>
+`
+
+func genDownloadPage(am *ArtifactsManifest) string {
+	prefix := getDownloadPrefixViaWebsite(buildTypeRel, am.Version)
+	tmpl := template.Must(template.New("").Parse(downloadPageTmpl))
+	archs := getDownloadPageArchs(am, prefix)
+	var buf bytes.Buffer
+	v := map[string]interface{}{
+		"Version":       am.Version,
+		"BuiltOn":       am.BuiltOn,
+		"Archs":         archs,
+		"Default":       getDefaultDownloadPageFile(archs),
+		"DefaultKind":   kArtifactInstaller,
+		"Sha256SumsURL": prefix + sha256SumsName,
+	}
+	must(tmpl.Execute(&buf, v))
+	p := &WebsitePage{
+		Title:   fmt.Sprintf("Download SumatraPDF %s, a free PDF reader for Windows", am.Version),
+		Nav:     "download",
+		Head:    template.HTML(downloadPageHead),
+		Content: template.HTML(buf.String()),
+	}
+	return string(renderWebsitePageMust(p))
+}
+
+// dir is www directory of website repo
+func writeDownloadPageMust(dir string, am *ArtifactsManifest) {
+	path := filepath.Join(dir, downloadPageName)
+	writeFileMust(path, []byte(genDownloadPage(am)))
+	logf("wrote '%s'\n", path)
+}
+
+func genDownloadPageMust() {
+	dir := getFinalDirForBuildType(buildTypeRel)
+	am := readArtifactsManifestMust(dir)
+	panicIf(am.BuildType != buildTypeRel, "expected release build in '%s', got '%s'", dir, am.BuildType)
+	writeDownloadPageMust(updateSumatraWebsite(), am)
+	logf("Don't forget to checkin the file and deploy website\n")
+}
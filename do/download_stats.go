@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -download-stats [logs-dir] shows how many times builds were downloaded:
+//   - from GitHub releases (download counts of release assets)
+//   - from our storage / CDN, from access logs in logs-dir (combined log
+//     format, optionally .gz, e.g. exported from Cloudflare)
+//
+// GitHub only gives us totals so we save them every time in
+// out/download-stats/github-history.json and show downloads between runs.
+// Writes out/download-stats/index.html
+
+var downloadStatsDir = filepath.Join("out", "download-stats")
+
+// DownloadCount is number of downloads of a file on a given day
+type DownloadCount struct {
+	// "2024-05-20", "" for GitHub totals
+	Date    string `json:"date"`
+	Source  string `json:"source"` // "github" or "cdn"
+	Channel string `json:"channel"`
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Count   int64  `json:"count"`
+}
+
+// GithubDownloadsSnapshot is total GitHub downloads of each file at a given time
+type GithubDownloadsSnapshot struct {
+	Date string `json:"date"`
+	// file name => total downloads
+	Counts map[string]int64 `json:"counts"`
+}
+
+// kinds of files that users download, as opposed to symbols or delta updates
+var downloadKinds = []string{kArtifactInstaller, kArtifactPortableExe, kArtifactPortableZip, kArtifactPortable7z, kArtifactMsi, kArtifactMsix}
+
+// returns "" if name is not a file users download
+func getDownloadKind(name string) string {
+	kind := ""
+	func() {
+		// artifactKindFromName panics for unknown files, old releases had different files
+		defer func() {
+			recover()
+		}()
+		kind = artifactKindFromName(name)
+	}()
+	for _, k := range downloadKinds {
+		if k == kind {
+			return kind
+		}
+	}
+	return ""
+}
+
+// 32-bit files in release builds don't have arch in the name
+func getArchFromName(name string) string {
+	switch {
+	case strings.Contains(name, "-arm64"):
+		return "arm64"
+	case strings.Contains(name, "-64"):
+		return "64"
+	}
+	return "32"
+}
+
+func getGithubDownloadCountsMust() []*DownloadCount {
+	var res []*DownloadCount
+	for page := 1; ; page++ {
+		var releases []*struct {
+			TagName string `json:"tag_name"`
+			Assets  []*struct {
+				Name          string `json:"name"`
+				DownloadCount int64  `json:"download_count"`
+			} `json:"assets"`
+		}
+		githubAPIMust(http.MethodGet, fmt.Sprintf("/repos/%s/releases?per_page=100&page=%d", githubReleaseRepo, page), nil, &releases)
+		if len(releases) == 0 {
+			return res
+		}
+		for _, rel := range releases {
+			m := rxReleaseTag.FindStringSubmatch(rel.TagName)
+			if m == nil {
+				continue
+			}
+			for _, a := range rel.Assets {
+				kind := getDownloadKind(a.Name)
+				if kind == "" {
+					continue
+				}
+				res = append(res, &DownloadCount{
+					Source:  "github",
+					Channel: string(buildTypeRel),
+					Version: m[1],
+					Arch:    getArchFromName(a.Name),
+					Kind:    kind,
+					Name:    a.Name,
+					Count:   a.DownloadCount,
+				})
+			}
+		}
+	}
+}
+
+// adds current totals to history and returns downloads since the previous
+// snapshot, with the date of this snapshot
+func updateGithubDownloadsHistoryMust(counts []*DownloadCount) []*DownloadCount {
+	path := filepath.Join(downloadStatsDir, "github-history.json")
+	var history []*GithubDownloadsSnapshot
+	if d, err := os.ReadFile(path); err == nil {
+		must(json.Unmarshal(d, &history))
+	}
+	snap := &GithubDownloadsSnapshot{
+		Date:   time.Now().Format("2006-01-02"),
+		Counts: map[string]int64{},
+	}
+	for _, c := range counts {
+		snap.Counts[c.Name] += c.Count
+	}
+	var prev *GithubDownloadsSnapshot
+	if n := len(history); n > 0 {
+		prev = history[n-1]
+		if prev.Date == snap.Date {
+			// re-run on the same day replaces the snapshot
+			history = history[:n-1]
+			prev = nil
+			if n > 1 {
+				prev = history[n-2]
+			}
+		}
+	}
+	history = append(history, snap)
+	d, err := json.MarshalIndent(history, "", "  ")
+	must(err)
+	writeFileMust(path, d)
+
+	var res []*DownloadCount
+	for _, c := range counts {
+		dc := *c
+		dc.Date = snap.Date
+		if prev != nil {
+			dc.Count -= prev.Counts[c.Name]
+		}
+		if dc.Count > 0 {
+			res = append(res, &dc)
+		}
+	}
+	return res
+}
+
+// matches /dl/rel/3.5.2/SumatraPDF-3.5.2-64-install.exe and
+// /software/sumatrapdf/prerel/16234/SumatraPDF-prerel-64.zip
+var rxDownloadPath = regexp.MustCompile(`/(rel|prerel)/([^/]+)/([^/?]+)(?:\?.*)?$`)
+
+// 1.2.3.4 - - [20/May/2024:10:00:00 +0000] "GET /dl/rel/3.5.2/x.exe HTTP/1.1" 200 1234 ...
+var rxAccessLogLine = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "GET (\S+) [^"]*" (\d{3}) `)
+
+func parseAccessLog(r io.Reader, counts map[string]*DownloadCount) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		m := rxAccessLogLine.FindStringSubmatch(sc.Text())
+		// 206 is a part of a download we already counted or a resumed download
+		if m == nil || m[3] != "200" {
+			continue
+		}
+		pm := rxDownloadPath.FindStringSubmatch(m[2])
+		if pm == nil {
+			continue
+		}
+		name := pm[3]
+		kind := getDownloadKind(name)
+		if kind == "" {
+			continue
+		}
+		t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[1])
+		if err != nil {
+			continue
+		}
+		date := t.UTC().Format("2006-01-02")
+		key := date + "/" + pm[1] + "/" + pm[2] + "/" + name
+		c := counts[key]
+		if c == nil {
+			c = &DownloadCount{
+				Date:    date,
+				Source:  "cdn",
+				Channel: pm[1],
+				Version: pm[2],
+				Arch:    getArchFromName(name),
+				Kind:    kind,
+				Name:    name,
+			}
+			counts[key] = c
+		}
+		c.Count++
+	}
+}
+
+func readAccessLogsMust(dir string) []*DownloadCount {
+	counts := map[string]*DownloadCount{}
+	nFiles := 0
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		d := readFileMust(path)
+		var r io.Reader = bytes.NewReader(d)
+		if strings.HasSuffix(path, ".gz") {
+			gr, err := gzip.NewReader(r)
+			must(err)
+			defer gr.Close()
+			r = gr
+		}
+		parseAccessLog(r, counts)
+		nFiles++
+		return nil
+	})
+	must(err)
+	var res []*DownloadCount
+	for _, c := range counts {
+		res = append(res, c)
+	}
+	logf("read %d access log files from '%s'\n", nFiles, dir)
+	return res
+}
+
+// DownloadStatsRow is a row in a table in the dashboard
+type DownloadStatsRow struct {
+	Label string
+	// by column
+	Counts []int64
+	Total  int64
+	// width of the bar, in percent of the biggest total
+	BarPercent int64
+}
+
+// DownloadStatsTable is a table in the dashboard
+type DownloadStatsTable struct {
+	Title   string
+	Columns []string
+	Rows    []*DownloadStatsRow
+}
+
+// rows are labels returned by rowFn, columns by colFn
+func genDownloadStatsTable(title string, counts []*DownloadCount, rowFn, colFn func(*DownloadCount) string, lessRow func(a, b string) bool) *DownloadStatsTable {
+	res := &DownloadStatsTable{Title: title}
+	colIdx := map[string]int{}
+	rows := map[string]*DownloadStatsRow{}
+	for _, c := range counts {
+		col := colFn(c)
+		if _, ok := colIdx[col]; !ok {
+			colIdx[col] = len(res.Columns)
+			res.Columns = append(res.Columns, col)
+		}
+	}
+	sort.Strings(res.Columns)
+	for i, col := range res.Columns {
+		colIdx[col] = i
+	}
+	for _, c := range counts {
+		label := rowFn(c)
+		row := rows[label]
+		if row == nil {
+			row = &DownloadStatsRow{Label: label, Counts: make([]int64, len(res.Columns))}
+			rows[label] = row
+			res.Rows = append(res.Rows, row)
+		}
+		row.Counts[colIdx[colFn(c)]] += c.Count
+		row.Total += c.Count
+	}
+	sort.Slice(res.Rows, func(i, j int) bool {
+		return lessRow(res.Rows[i].Label, res.Rows[j].Label)
+	})
+	var maxTotal int64
+	for _, row := range res.Rows {
+		maxTotal = max(maxTotal, row.Total)
+	}
+	for _, row := range res.Rows {
+		if maxTotal > 0 {
+			row.BarPercent = row.Total * 100 / maxTotal
+		}
+	}
+	return res
+}
+
+const downloadStatsTmpl = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>SumatraPDF downloads</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { padding: 2px 8px; text-align: right; }
+th { background-color: #eee; }
+td:first-child { text-align: left; }
+.bar { background-color: #4a90d9; height: 10px; }
+</style>
+</head>
+<body>
+<h2>SumatraPDF downloads</h2>
+<p>Generated on {{.Generated}}. {{.Sources}}</p>
+{{range .Tables}}
+<h3>{{.Title}}</h3>
+<table>
+<tr><th></th>{{range .Columns}}<th>{{.}}</th>{{end}}<th>total</th><th></th></tr>
+{{range .Rows}}<tr><td>{{.Label}}</td>{{range .Counts}}<td>{{.}}</td>{{end}}<td>{{.Total}}</td><td style="width:200px"><div class="bar" style="width:{{.BarPercent}}%"></div></td></tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`
+
+func genDownloadStatsHTML(totals []*DownloadCount, daily []*DownloadCount, sources string) string {
+	// "rel 3.5.2" before "prerel 16234", newest version first
+	byVersion := func(a, b string) bool {
+		chA, verA, _ := strings.Cut(a, " ")
+		chB, verB, _ := strings.Cut(b, " ")
+		if chA != chB {
+			return chA > chB
+		}
+		return compareVersions(verA, verB) > 0
+	}
+	newestFirst := func(a, b string) bool {
+		return a > b
+	}
+	chanVer := func(c *DownloadCount) string {
+		return c.Channel + " " + c.Version
+	}
+	tables := []*DownloadStatsTable{
+		genDownloadStatsTable("Total by version and arch", totals, chanVer, func(c *DownloadCount) string { return c.Arch }, byVersion),
+		genDownloadStatsTable("Total by version and kind", totals, chanVer, func(c *DownloadCount) string { return c.Kind }, byVersion),
+		genDownloadStatsTable("Daily by channel", daily, func(c *DownloadCount) string { return c.Date }, func(c *DownloadCount) string { return c.Channel }, newestFirst),
+		genDownloadStatsTable("Daily by source", daily, func(c *DownloadCount) string { return c.Date }, func(c *DownloadCount) string { return c.Source }, newestFirst),
+	}
+	tmpl := template.Must(template.New("").Parse(downloadStatsTmpl))
+	var buf bytes.Buffer
+	v := map[string]interface{}{
+		"Generated": time.Now().Format("2006-01-02 15:04"),
+		"Sources":   sources,
+		"Tables":    tables,
+	}
+	must(tmpl.Execute(&buf, v))
+	return buf.String()
+}
+
+func downloadStatsMust(logsDir string) {
+	must(os.MkdirAll(downloadStatsDir, 0755))
+	var totals, daily []*DownloadCount
+	var sources []string
+	if githubPublishToken != "" {
+		gh := getGithubDownloadCountsMust()
+		totals = append(totals, gh...)
+		daily = append(daily, updateGithubDownloadsHistoryMust(gh)...)
+		sources = append(sources, "GitHub releases")
+	} else {
+		logf("Skipping GitHub downloads because GITHUB_PUBLISH_TOKEN env variable not set\n")
+	}
+	if logsDir != "" {
+		cdn := readAccessLogsMust(logsDir)
+		daily = append(daily, cdn...)
+		for _, c := range cdn {
+			t := *c
+			t.Date = ""
+			totals = append(totals, &t)
+		}
+		sources = append(sources, fmt.Sprintf("access logs in '%s'", logsDir))
+	}
+	panicIf(len(sources) == 0, "no download stats: need GITHUB_PUBLISH_TOKEN and/or access logs directory")
+
+	d, err := json.MarshalIndent(daily, "", "  ")
+	must(err)
+	writeFileMust(filepath.Join(downloadStatsDir, "daily.json"), d)
+	s := genDownloadStatsHTML(totals, daily, "Sources: "+strings.Join(sources, ", ")+".")
+	path := filepath.Join(downloadStatsDir, "index.html")
+	writeFileMust(path, []byte(s))
+	var total int64
+	for _, c := range totals {
+		total += c.Count
+	}
+	logf("%d downloads in total, wrote '%s'\n", total, path)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -drmem [${file}...] checks release 64-bit build for memory leaks with
+// Dr.Memory (drmemory.exe must be in PATH).
+//
+// We run under Dr.Memory:
+//   - test_util.exe
+//   - SumatraPDF.exe -exit-on-startup, which shows start page and exits
+//   - SumatraPDF.exe -bench ${file} for every ${file}
+//
+// Leaks matching suppressions in drmem-sup.txt are ignored. Remaining leaks
+// of all runs are grouped by allocation site (first frame of our code
+// that isn't an allocator) and written to out/drmem/${time}/report.txt,
+// with suppressions to add to drmem-sup.txt for those that are false
+// positives. We fail if there are any.
+//
+// Dr.Memory logs of every run are in out/drmem/${time}/${run}.
+
+const (
+	drmemSupPath = "drmem-sup.txt"
+	// Dr.Memory makes things 10-50x slower
+	drmemRunTimeout    = 30 * time.Minute
+	drmemStackFrames   = 8
+	drmemReportMaxLeak = 50
+)
+
+var drmemDir = filepath.Join("out", "drmem")
+
+// frames of allocators and Dr.Memory replacements, not interesting as
+// allocation site
+var drmemAllocFrames = []string{"replace_", "malloc", "calloc", "realloc", "operator new", "AllocArray", "AllocStruct", "Allocator::", "str::Dup", "HeapAlloc", "RtlAllocateHeap", "fz_malloc", "fz_calloc", "fz_new_"}
+
+// DrMemLeak is a leak reported by Dr.Memory
+type DrMemLeak struct {
+	Run      string
+	Possible bool
+	Bytes    int64
+	Frames   []string
+}
+
+// DrMemLeakSite is leaks of the same allocation site
+type DrMemLeakSite struct {
+	Site  string
+	Leaks []*DrMemLeak
+	Bytes int64
+}
+
+// "Error #3: LEAK 48 direct bytes 0x... + 16 indirect bytes"
+// "Error #4: POSSIBLE LEAK 64 direct bytes 0x... + 0 indirect bytes"
+var rxDrMemLeak = regexp.MustCompile(`^Error #\d+: (POSSIBLE )?LEAK (\d+) direct bytes .* \+ (\d+) indirect bytes`)
+
+// "# 2 SumatraPDF.exe!str::Dup   [C:\...\StrUtil.cpp:123]" => "SumatraPDF.exe!str::Dup"
+var rxDrMemFrame = regexp.MustCompile(`^#\s*\d+\s+(\S+)`)
+
+// parses results.txt written by Dr.Memory
+func parseDrMemResults(s string) []*DrMemLeak {
+	var res []*DrMemLeak
+	var curr *DrMemLeak
+	for _, line := range strings.Split(normalizeNewlines(s), "\n") {
+		line = strings.TrimSpace(line)
+		if m := rxDrMemLeak.FindStringSubmatch(line); m != nil {
+			curr = &DrMemLeak{
+				Possible: m[1] != "",
+			}
+			direct, _ := strconv.ParseInt(m[2], 10, 64)
+			indirect, _ := strconv.ParseInt(m[3], 10, 64)
+			curr.Bytes = direct + indirect
+			push(&res, curr)
+			continue
+		}
+		if curr == nil {
+			continue
+		}
+		if m := rxDrMemFrame.FindStringSubmatch(line); m != nil {
+			push(&curr.Frames, m[1])
+			continue
+		}
+		if line == "" {
+			curr = nil
+		}
+	}
+	return res
+}
+
+func isDrMemAllocFrame(frame string) bool {
+	for _, s := range drmemAllocFrames {
+		if strings.Contains(frame, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// first frame of our code that isn't an allocator
+func getDrMemLeakSite(l *DrMemLeak) string {
+	for _, frame := range l.Frames {
+		mod, _, _ := strings.Cut(frame, "!")
+		mod = strings.ToLower(mod)
+		if mod != "sumatrapdf.exe" && mod != "test_util.exe" {
+			continue
+		}
+		if !isDrMemAllocFrame(frame) {
+			return frame
+		}
+	}
+	if len(l.Frames) > 0 {
+		return l.Frames[len(l.Frames)-1]
+	}
+	return "(no callstack)"
+}
+
+func groupDrMemLeaks(leaks []*DrMemLeak) []*DrMemLeakSite {
+	bySite := map[string]*DrMemLeakSite{}
+	var res []*DrMemLeakSite
+	for _, l := range leaks {
+		site := getDrMemLeakSite(l)
+		s := bySite[site]
+		if s == nil {
+			s = &DrMemLeakSite{Site: site}
+			bySite[site] = s
+			push(&res, s)
+		}
+		push(&s.Leaks, l)
+		s.Bytes += l.Bytes
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Bytes > res[j].Bytes
+	})
+	return res
+}
+
+// suppression in drmem-sup.txt format, matching the stack up to leak site
+func genDrMemSuppression(s *DrMemLeakSite) string {
+	l := s.Leaks[0]
+	kind := "LEAK"
+	if l.Possible {
+		kind = "POSSIBLE LEAK"
+	}
+	lines := []string{kind, "name=" + s.Site}
+	if l.Frames[0] != s.Site {
+		push(&lines, "...")
+	}
+	push(&lines, s.Site)
+	return strings.Join(lines, "\n")
+}
+
+func genDrMemReport(title string, sites []*DrMemLeakSite) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", title)
+	if len(sites) == 0 {
+		fmt.Fprintf(&b, "no leaks\n")
+		return b.String()
+	}
+	nLeaks := 0
+	for _, s := range sites {
+		nLeaks += len(s.Leaks)
+	}
+	fmt.Fprintf(&b, "%d leaks from %d allocation sites\n", nLeaks, len(sites))
+	for i, s := range sites {
+		if i == drmemReportMaxLeak {
+			fmt.Fprintf(&b, "\n... and %d more allocation sites\n", len(sites)-i)
+			break
+		}
+		runs := map[string]bool{}
+		for _, l := range s.Leaks {
+			runs[l.Run] = true
+		}
+		var runNames []string
+		for run := range runs {
+			push(&runNames, run)
+		}
+		sort.Strings(runNames)
+		fmt.Fprintf(&b, "\n%s: %d leaks, %s (%s)\n", s.Site, len(s.Leaks), formatSize(s.Bytes), strings.Join(runNames, ", "))
+		frames := s.Leaks[0].Frames
+		if len(frames) > drmemStackFrames {
+			frames = frames[:drmemStackFrames]
+		}
+		for _, frame := range frames {
+			fmt.Fprintf(&b, "    %s\n", frame)
+		}
+	}
+	fmt.Fprintf(&b, "\nsuppressions for %s, for false positives:\n", drmemSupPath)
+	for _, s := range sites {
+		if len(s.Leaks[0].Frames) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s\n", genDrMemSuppression(s))
+	}
+	return b.String()
+}
+
+// runs exe with args under Dr.Memory, returns leaks it found
+func runUnderDrMem(name string, logDir string, exe string, args ...string) []*DrMemLeak {
+	must(os.MkdirAll(logDir, 0755))
+	drmemArgs := []string{"-leaks_only", "-batch", "-suppress", absPathMust(drmemSupPath), "-logdir", absPathMust(logDir), "--", exe}
+	drmemArgs = append(drmemArgs, args...)
+	c, cancel := context.WithTimeout(context.Background(), drmemRunTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(c, "drmemory.exe", drmemArgs...)
+	// test_util.exe expects to run from its directory
+	cmd.Dir = filepath.Dir(exe)
+	logf("> %s\n", fmtCmdShort(*cmd))
+	if err := cmd.Run(); err != nil {
+		// leaks don't change exit code so it's a crash, test failure
+		// or a timeout. We still want to see leaks
+		logf("%s\n", err)
+	}
+	// Dr.Memory creates ${logDir}/DrMemory-${exe}.${pid}.000/results.txt
+	paths, _ := filepath.Glob(filepath.Join(logDir, "DrMemory-*", "results.txt"))
+	panicIf(len(paths) == 0, "Dr.Memory didn't write results.txt in '%s'", logDir)
+	var res []*DrMemLeak
+	for _, path := range paths {
+		for _, l := range parseDrMemResults(string(readFileMust(path))) {
+			l.Run = name
+			push(&res, l)
+		}
+	}
+	logf("%s: %d leaks\n", name, len(res))
+	return res
+}
+
+// -drmem [${file}...]
+func drMemMust(files []string) {
+	panicIf(runtime.GOOS != "windows", "-drmem only works on Windows")
+	_, err := exec.LookPath("drmemory.exe")
+	panicIf(err != nil, "drmemory.exe not found, install Dr.Memory from https://drmemory.org")
+	for _, file := range files {
+		panicIf(!fileExists(file), "'%s' doesn't exist", file)
+	}
+
+	buildJustPortableExe(rel64Dir, "Release", kPlatformIntel64)
+	buildTestUtil()
+	exe := absPathMust(filepath.Join(rel64Dir, "SumatraPDF.exe"))
+	testUtilExe := absPathMust(filepath.Join(rel64Dir, "test_util.exe"))
+
+	dir := filepath.Join(drmemDir, time.Now().Format("2006-01-02_15-04-05"))
+	appDataDir := filepath.Join(dir, "appdata")
+	must(os.MkdirAll(appDataDir, 0755))
+	appDataDir = absPathMust(appDataDir)
+
+	var leaks []*DrMemLeak
+	leaks = append(leaks, runUnderDrMem("test_util", filepath.Join(dir, "test_util"), testUtilExe)...)
+	leaks = append(leaks, runUnderDrMem("start page", filepath.Join(dir, "start-page"), exe, "-appdata", appDataDir, "-exit-on-startup")...)
+	for i, file := range files {
+		name := "bench " + filepath.Base(file)
+		logDir := filepath.Join(dir, fmt.Sprintf("bench-%d", i+1))
+		leaks = append(leaks, runUnderDrMem(name, logDir, exe, "-appdata", appDataDir, "-bench", absPathMust(file))...)
+	}
+
+	sites := groupDrMemLeaks(leaks)
+	title := fmt.Sprintf("Dr.Memory leak check of %s", getGitSha1())
+	report := genDrMemReport(title, sites)
+	reportPath := filepath.Join(dir, "report.txt")
+	writeFileMust(reportPath, []byte(report))
+	logf("%s\nwrote '%s'\n", report, reportPath)
+	panicIf(len(sites) > 0, "found leaks from %d allocation sites, see '%s'", len(sites), reportPath)
+}
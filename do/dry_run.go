@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kjk/common/u"
+)
+
+// -dry-run can be added to any command to rehearse it: everything that changes
+// something outside of this process is logged as "dry-run: would ..." instead
+// of being done:
+//   - running programs (msbuild, signtool, git push etc.). Commands that
+//     only read (git log, git show etc.) still run because we need their output
+//   - writing, copying and deleting files
+//   - uploading to and deleting from storage
+//   - GitHub API calls other than GET, VirusTotal uploads, CDN purges,
+//     webhook notifications
+//
+// Because nothing is built or written, steps that read outputs of previous
+// steps use what is already in out/ e.g. after a real -build-release,
+// -upload -dry-run shows what would be uploaded.
+
+var dryRun bool
+
+// returns true if we're in dry run mode, in which case the caller should
+// skip the action described by format and args
+func dryRunSkip(format string, args ...interface{}) bool {
+	if !dryRun {
+		return false
+	}
+	logf("dry-run: would "+format+"\n", args...)
+	return true
+}
+
+// git commands that don't change anything
+var readOnlyGitCommands = []string{"log", "show", "rev-parse", "rev-list", "status", "diff", "describe", "ls-files", "cat-file", "for-each-ref"}
+
+func isReadOnlyCmd(cmd *exec.Cmd) bool {
+	exe := strings.ToLower(strings.TrimSuffix(filepath.Base(cmd.Path), ".exe"))
+	if len(cmd.Args) < 2 {
+		return false
+	}
+	args := cmd.Args[1:]
+	switch exe {
+	case "git":
+		if stringInSlice(readOnlyGitCommands, args[0]) {
+			return true
+		}
+		// git tag --list, git tag -v
+		return args[0] == "tag" && len(args) > 1 && (args[1] == "--list" || args[1] == "-l" || args[1] == "-v")
+	case "reg":
+		return args[0] == "query"
+	case "gpg":
+		return args[0] == "--batch" && len(args) > 1 && args[1] == "--verify"
+	}
+	return false
+}
+
+// returns true if cmd should not be run
+func dryRunSkipCmd(cmd *exec.Cmd) bool {
+	if !dryRun || isReadOnlyCmd(cmd) {
+		return false
+	}
+	return dryRunSkip("run '%s' in '%s'", fmdCmdShort(cmd), cmd.Dir)
+}
+
+func copyFile(dst string, src string) error {
+	if dryRunSkip("copy '%s' => '%s'", src, dst) {
+		return nil
+	}
+	return u.CopyFile(dst, src)
+}
+
+// DryRunStorage is StorageBackend that only logs changes
+type DryRunStorage struct {
+	StorageBackend
+}
+
+func (s *DryRunStorage) UploadFile(remotePath string, localPath string) error {
+	dryRunSkip("upload '%s' (%s) to '%s'", localPath, formatSize(fileSizeMust(localPath)), s.URLForPath(remotePath))
+	return nil
+}
+
+func (s *DryRunStorage) UploadData(remotePath string, d []byte) error {
+	dryRunSkip("upload %s to '%s'", formatSize(int64(len(d))), s.URLForPath(remotePath))
+	return nil
+}
+
+func (s *DryRunStorage) Remove(remotePath string) error {
+	dryRunSkip("delete '%s'", s.URLForPath(remotePath))
+	return nil
+}
+
+func maybeDryRunStorage(s StorageBackend) StorageBackend {
+	if dryRun {
+		return &DryRunStorage{s}
+	}
+	return s
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// -analyze-dumps ${dir} runs `!analyze -v` of cdb.exe on every minidump
+// (.dmp) in ${dir} and writes what we need from its output to
+// ${name}.analysis.json next to it. Dumps that already have it are skipped.
+// Then it runs -crash-aggregate on ${dir}, which groups analyzed dumps
+// together with crash reports, using the same signature.
+//
+// Version of the build is "File version" of SumatraPDF.exe (lmvm), 3rd part
+// bigger than preRelVerMin means it's a pre-release.
+
+const (
+	dumpAnalysisSuffix = ".analysis.json"
+	preRelVerMin       = 1000
+)
+
+var (
+	// "FAILURE_BUCKET_ID:  NULL_POINTER_READ_c0000005_SumatraPDF.exe!Foo"
+	rxAnalyzeKey = regexp.MustCompile(`^([A-Z][A-Z0-9_]+):\s*(.*)$`)
+	// "ExceptionCode: c0000005 (Access violation)"
+	rxAnalyzeExceptionCode = regexp.MustCompile(`(?m)^\s*ExceptionCode: ([0-9a-fA-F]{8})`)
+	// "    File version:     3.6.16123.0"
+	rxLmvFileVersion = regexp.MustCompile(`(?m)^\s*File version:\s*(\d+)\.(\d+)\.(\d+)\.(\d+)`)
+)
+
+// names used in crash reports, by exception code
+var exceptionCodeNames = map[string]string{
+	"80000003": "EXCEPTION_BREAKPOINT",
+	"c0000005": "EXCEPTION_ACCESS_VIOLATION",
+	"c0000094": "EXCEPTION_INT_DIVIDE_BY_ZERO",
+	"c00000fd": "EXCEPTION_STACK_OVERFLOW",
+	"c0000374": "STATUS_HEAP_CORRUPTION",
+	"c0000409": "STATUS_STACK_BUFFER_OVERRUN",
+	"e06d7363": "C++ exception",
+}
+
+// DumpAnalysis is result of `!analyze -v` of a minidump
+type DumpAnalysis struct {
+	Path      string    `json:"path"`
+	BuildType BuildType `json:"buildType"`
+	// as in CrashReport i.e. "16234" for pre-release, "3.5.2" for release
+	Version string `json:"version"`
+	// "c0000005"
+	ExceptionCode  string `json:"exceptionCode"`
+	FaultingModule string `json:"faultingModule"`
+	FailureBucket  string `json:"failureBucket"`
+	// FAILURE_ID_HASH, same for the same crash in all builds
+	StackHash string `json:"stackHash"`
+	// as in CrashReport
+	Frames []string `json:"frames"`
+}
+
+// "SumatraPDF" => "sumatrapdf.exe", "ntdll" => "ntdll.dll"
+func cdbModuleToFileName(module string) string {
+	module = strings.ToLower(module)
+	for _, exeName := range pdbToBinary {
+		name := strings.ToLower(exeName)
+		if strings.TrimSuffix(name, filepath.Ext(name)) == module {
+			return name
+		}
+	}
+	return module + ".dll"
+}
+
+// call site from STACK_TEXT of cdb:
+// "SumatraPDF!DisplayModel::GoToPage+0x12 [C:\src\DisplayModel.cpp @ 123]"
+// => "sumatrapdf.exe!DisplayModel::GoToPage"
+// "SumatraPDF+0x1234" => "sumatrapdf.exe+0x1234"
+func normalizeCdbFrame(callSite string) string {
+	callSite, _, _ = strings.Cut(strings.TrimSpace(callSite), " ")
+	if module, fn, ok := strings.Cut(callSite, "!"); ok {
+		if idx := strings.Index(fn, "+0x"); idx > 0 {
+			fn = fn[:idx]
+		}
+		return cdbModuleToFileName(module) + "!" + fn
+	}
+	if module, off, ok := strings.Cut(callSite, "+"); ok {
+		return cdbModuleToFileName(module) + "+" + off
+	}
+	return ""
+}
+
+// "3", "6", "16123" => pre-release "16123", "3", "5", "2" => "3.5.2"
+func dumpVersion(parts []string) (BuildType, string) {
+	build, _ := strconv.Atoi(parts[2])
+	if build > preRelVerMin {
+		return buildTypePreRel, parts[2]
+	}
+	ver := parts[0] + "." + parts[1]
+	if build > 0 {
+		ver += "." + parts[2]
+	}
+	return buildTypeRel, ver
+}
+
+func parseDumpAnalysis(s string) *DumpAnalysis {
+	s = normalizeNewlines(s)
+	res := &DumpAnalysis{}
+	if m := rxAnalyzeExceptionCode.FindStringSubmatch(s); m != nil {
+		res.ExceptionCode = strings.ToLower(m[1])
+	}
+	if m := rxLmvFileVersion.FindStringSubmatch(s); m != nil {
+		res.BuildType, res.Version = dumpVersion(m[1:4])
+	}
+	inStack := false
+	for _, line := range strings.Split(s, "\n") {
+		if inStack {
+			if strings.TrimSpace(line) == "" {
+				inStack = false
+				continue
+			}
+			// "RetAddr : Args to Child : Call Site"
+			idx := strings.LastIndex(line, " : ")
+			if idx < 0 {
+				continue
+			}
+			if frame := normalizeCdbFrame(line[idx+3:]); frame != "" {
+				res.Frames = append(res.Frames, frame)
+			}
+			continue
+		}
+		m := rxAnalyzeKey.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		v := strings.TrimSpace(m[2])
+		switch m[1] {
+		case "STACK_TEXT":
+			inStack = true
+		case "EXCEPTION_CODE_STR":
+			res.ExceptionCode = strings.ToLower(v)
+		case "IMAGE_NAME", "FAULTING_MODULE":
+			if res.FaultingModule == "" {
+				res.FaultingModule = v
+			}
+		case "FAILURE_BUCKET_ID":
+			res.FailureBucket = v
+		case "FAILURE_ID_HASH":
+			res.StackHash = strings.Trim(v, "{}")
+		}
+	}
+	return res
+}
+
+func (a *DumpAnalysis) toCrashReport() *CrashReport {
+	exception := exceptionCodeNames[a.ExceptionCode]
+	if exception == "" {
+		exception = a.ExceptionCode
+	}
+	return &CrashReport{
+		BuildType: a.BuildType,
+		Version:   a.Version,
+		Exception: exception,
+		Frames:    a.Frames,
+		Signature: getCrashSignature(a.Frames),
+	}
+}
+
+func readDumpAnalysisMust(path string) *DumpAnalysis {
+	var res DumpAnalysis
+	must(json.Unmarshal(readFileMust(path), &res))
+	return &res
+}
+
+// writes ${dump}.analysis.json
+func writeDumpAnalysisMust(a *DumpAnalysis) {
+	d, err := json.MarshalIndent(a, "", "  ")
+	must(err)
+	dst := a.Path + dumpAnalysisSuffix
+	writeFileMust(dst, d)
+	logf("wrote '%s': %s %s\n", dst, a.ExceptionCode, getCrashSignature(a.Frames))
+}
+
+func analyzeDumpMust(path string) *DumpAnalysis {
+	out := runCdbOnDumpMust(path, "!analyze -v;lmvm SumatraPDF;q")
+	res := parseDumpAnalysis(out)
+	res.Path = path
+	writeDumpAnalysisMust(res)
+	return res
+}
+
+// -analyze-dumps ${dir}
+func analyzeDumpsMust(dir string) {
+	panicIf(dir == "", "usage: -analyze-dumps ${dir with minidumps}")
+	entries, err := os.ReadDir(dir)
+	must(err)
+	n := 0
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(name), ".dmp") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if fileExists(path + dumpAnalysisSuffix) {
+			continue
+		}
+		analyzeDumpMust(path)
+		n++
+	}
+	logf("analyzed %d minidumps in '%s'\n", n, dir)
+	crashAggregateMust(dir)
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"path"
 	"path/filepath"
-	"sync"
 	"time"
 
 	"github.com/kjk/minioutil"
@@ -23,31 +22,16 @@ func fileUpload(fpath string) {
 
 	timeStart := time.Now()
 
-	upload := func(mc *minioutil.Client) {
-		uri := mc.URLForPath(remotePath)
-		if mc.Exists(remotePath) {
+	forEachStorageParallelMust(getStorageBackends(), func(storage StorageBackend) {
+		uri := storage.URLForPath(remotePath)
+		if storage.Exists(remotePath) {
 			logf("Skipping upload, '%s' already exists\n", uri)
 		} else {
-			_, err := mc.UploadFile(remotePath, fpath, true)
+			err := storage.UploadFile(remotePath, fpath)
 			must(err)
 			logf("Uploaded in %s\n%s\n", time.Since(timeStart), uri)
 		}
-	}
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		upload(newMinioR2Client())
-		wg.Done()
-	}()
-
-	wg.Add(1)
-	go func() {
-		upload(newMinioBackblazeClient())
-		wg.Done()
-	}()
-
-	wg.Wait()
+	})
 }
 
 func minioFilesList(mc *minioutil.Client) {
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -fuzz-triage ${dir} triages crashing inputs found by fuzzing:
+//   - reproduces every input in ${dir} with enginedump.exe built from
+//     current sources (inputs that don't crash or hang are probably fixed)
+//   - gets symbolicated signature of the crash by running it under cdb.exe
+//   - minimizes the input by removing chunks of it as long as it crashes
+//     the same way
+//   - buckets inputs by signature in out/fuzz-triage/${signature hash}
+//
+// Inputs worth keeping should be added to regression corpus with
+// -corpus-add ${file} bad issue=${n} (see corpus.go).
+//
+// -fuzz-regress runs all known-bad documents of the corpus with enginedump.exe
+// built from current sources and fails if any of them crashes or hangs.
+// It runs as part of every daily pre-release build.
+
+const (
+	fuzzRunTimeout = 30 * time.Second
+	// minimization runs the input that many times at most
+	fuzzMinimizeMaxRuns = 1000
+	fuzzSignatureFile   = "signature.txt"
+)
+
+var fuzzTriageDir = filepath.Join("out", "fuzz-triage")
+
+// FuzzRun is the result of running an input
+type FuzzRun struct {
+	Crashed bool
+	Hang    bool
+	// NTSTATUS of the crash e.g. "c0000005"
+	ExceptionCode string
+}
+
+// FuzzBucket is inputs that crash with the same signature
+type FuzzBucket struct {
+	Signature string
+	Frames    []string
+	// minimized inputs, smallest first
+	Inputs []string
+}
+
+// dumps and renders all pages at low zoom, which runs most of parsing
+// code, without writing anything
+func getFuzzEngineDumpArgs(path string) []string {
+	return []string{"-quick", "-silent", "-render", "25%", "page-%d.tga", path}
+}
+
+func runFuzzInput(engineDump string, path string) *FuzzRun {
+	c, cancel := context.WithTimeout(context.Background(), fuzzRunTimeout)
+	defer cancel()
+	err := exec.CommandContext(c, engineDump, getFuzzEngineDumpArgs(path)...).Run()
+	if c.Err() != nil {
+		return &FuzzRun{Hang: true}
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return &FuzzRun{}
+	}
+	// exit code of a process that crashed is NTSTATUS of the exception,
+	// errors are 0xc0000000 and up, 0x80000003 is a breakpoint (CrashIf)
+	code := uint32(exitErr.ExitCode())
+	if code >= 0xc0000000 || code == 0x80000003 {
+		return &FuzzRun{Crashed: true, ExceptionCode: fmt.Sprintf("%08x", code)}
+	}
+	return &FuzzRun{}
+}
+
+// runs the input under cdb.exe and returns !analyze -v of the crash
+func analyzeFuzzCrash(engineDump string, path string) *DumpAnalysis {
+	cdbPath := filepath.Join(debuggersDir, "cdb.exe")
+	if !fileExists(cdbPath) {
+		return nil
+	}
+	c, cancel := context.WithTimeout(context.Background(), 10*fuzzRunTimeout)
+	defer cancel()
+	symPath := filepath.Dir(engineDump) + ";" + getCdbSymPath()
+	// -g: don't break at start, -G: exit when process exits, -x: break on
+	// access violations only when not handled
+	args := append([]string{"-g", "-G", "-x", "-lines", "-y", symPath, "-c", "!analyze -v;q", engineDump}, getFuzzEngineDumpArgs(path)...)
+	out, _ := exec.CommandContext(c, cdbPath, args...).CombinedOutput()
+	return parseDumpAnalysis(string(out))
+}
+
+// signature of a crash, as in crash reports
+func getFuzzSignature(engineDump string, path string, r *FuzzRun) (string, []string) {
+	if r.Hang {
+		return "(hang)", nil
+	}
+	a := analyzeFuzzCrash(engineDump, path)
+	if a == nil || len(a.Frames) == 0 {
+		// no cdb.exe, or symbols
+		return "exception " + r.ExceptionCode, nil
+	}
+	return getCrashSignature(a.Frames), a.Frames
+}
+
+// removes chunks of data, from big to small, as long as it still crashes
+// with the same exception
+func minimizeFuzzInput(engineDump string, ext string, data []byte, code string) []byte {
+	path := filepath.Join(fuzzTriageDir, "minimize"+ext)
+	defer os.Remove(path)
+	nRuns := 0
+	crashes := func(d []byte) bool {
+		nRuns++
+		writeFileMust(path, d)
+		r := runFuzzInput(engineDump, path)
+		return r.Crashed && r.ExceptionCode == code
+	}
+	for chunk := len(data) / 2; chunk >= 1 && nRuns < fuzzMinimizeMaxRuns; chunk /= 2 {
+		for off := 0; off+chunk <= len(data) && nRuns < fuzzMinimizeMaxRuns; {
+			d := append(append([]byte{}, data[:off]...), data[off+chunk:]...)
+			if crashes(d) {
+				data = d
+			} else {
+				off += chunk
+			}
+		}
+	}
+	return data
+}
+
+// returns signature and path of minimized input, "" if it doesn't reproduce
+func triageFuzzInput(engineDump string, path string) (string, []string, string) {
+	r := runFuzzInput(engineDump, path)
+	if !r.Crashed && !r.Hang {
+		return "", nil, ""
+	}
+	sig, frames := getFuzzSignature(engineDump, path, r)
+	data := readFileMust(path)
+	ext := strings.ToLower(filepath.Ext(path))
+	dir := filepath.Join(fuzzTriageDir, getCrashSignatureHash(sig))
+	must(os.MkdirAll(dir, 0755))
+	name := sha256HexOfData(data)[:12]
+	if r.Crashed {
+		minData := minimizeFuzzInput(engineDump, ext, data, r.ExceptionCode)
+		minPath := filepath.Join(dir, name+"-min"+ext)
+		writeFileMust(minPath, minData)
+		// minimizing can turn it into a different crash with the same
+		// exception code
+		if len(minData) < len(data) {
+			if sig2, _ := getFuzzSignature(engineDump, minPath, runFuzzInput(engineDump, minPath)); sig2 == sig {
+				logf("minimized '%s' from %d to %d bytes\n", path, len(data), len(minData))
+				return sig, frames, minPath
+			}
+			os.Remove(minPath)
+		}
+	}
+	dst := filepath.Join(dir, name+ext)
+	writeFileMust(dst, data)
+	return sig, frames, dst
+}
+
+func genFuzzTriageReport(buckets []*FuzzBucket, nInputs int, notReproduced []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d inputs, %d crash signatures, %d didn't reproduce\n\n", nInputs, len(buckets), len(notReproduced))
+	for _, bucket := range buckets {
+		fmt.Fprintf(&b, "%d inputs: %s\n", len(bucket.Inputs), bucket.Signature)
+		for _, frame := range bucket.Frames {
+			fmt.Fprintf(&b, "    %s\n", frame)
+		}
+		fmt.Fprintf(&b, "  smallest: %s\n", bucket.Inputs[0])
+		fmt.Fprintf(&b, "  add to regression corpus: do -corpus-add %s bad issue=${n}\n\n", bucket.Inputs[0])
+	}
+	if len(notReproduced) > 0 {
+		fmt.Fprintf(&b, "didn't reproduce:\n")
+		for _, path := range notReproduced {
+			fmt.Fprintf(&b, "  %s\n", path)
+		}
+	}
+	return b.String()
+}
+
+// -fuzz-triage ${dir}
+func fuzzTriageMust(dir string) {
+	panicIf(runtime.GOOS != "windows", "-fuzz-triage only works on Windows")
+	panicIf(dir == "" || !dirExists(dir), "usage: -fuzz-triage ${dir with crashing inputs}")
+	var paths []string
+	entries, err := os.ReadDir(dir)
+	must(err)
+	for _, e := range entries {
+		if !e.IsDir() {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	panicIf(len(paths) == 0, "no inputs in '%s'", dir)
+	buildEngineDump()
+	engineDump := absPathMust(filepath.Join(rel64Dir, "enginedump.exe"))
+
+	must(os.RemoveAll(fuzzTriageDir))
+	bySig := map[string]*FuzzBucket{}
+	var notReproduced []string
+	for _, path := range paths {
+		sig, frames, minPath := triageFuzzInput(engineDump, path)
+		if sig == "" {
+			notReproduced = append(notReproduced, path)
+			logf("%s: didn't reproduce\n", path)
+			continue
+		}
+		logf("%s: %s\n", path, sig)
+		bucket := bySig[sig]
+		if bucket == nil {
+			bucket = &FuzzBucket{Signature: sig, Frames: frames}
+			bySig[sig] = bucket
+			d := sig + "\n\n" + strings.Join(frames, "\n") + "\n"
+			writeFileMust(filepath.Join(filepath.Dir(minPath), fuzzSignatureFile), []byte(d))
+		}
+		bucket.Inputs = append(bucket.Inputs, minPath)
+	}
+
+	var buckets []*FuzzBucket
+	for _, bucket := range bySig {
+		sort.Slice(bucket.Inputs, func(i, j int) bool {
+			return fileSizeMust(bucket.Inputs[i]) < fileSizeMust(bucket.Inputs[j])
+		})
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if len(buckets[i].Inputs) != len(buckets[j].Inputs) {
+			return len(buckets[i].Inputs) > len(buckets[j].Inputs)
+		}
+		return buckets[i].Signature < buckets[j].Signature
+	})
+	report := genFuzzTriageReport(buckets, len(paths), notReproduced)
+	path := filepath.Join(fuzzTriageDir, "report.txt")
+	writeFileMust(path, []byte(report))
+	logf("%s\nwrote '%s'\n", report, path)
+}
+
+// -fuzz-regress
+func fuzzRegressMust() {
+	panicIf(runtime.GOOS != "windows", "-fuzz-regress only works on Windows")
+	dir := getCorpusDirMust("corpus:" + corpusBadDir)
+	paths := collectCrashReplayDocsMust(dir)
+	if len(paths) == 0 {
+		logf("no known-bad documents in corpus\n")
+		return
+	}
+	buildEngineDump()
+	engineDump := absPathMust(filepath.Join(rel64Dir, "enginedump.exe"))
+	var failed []string
+	for _, path := range paths {
+		r := runFuzzInput(engineDump, path)
+		if !r.Crashed && !r.Hang {
+			continue
+		}
+		sig, _ := getFuzzSignature(engineDump, path, r)
+		rel, err := filepath.Rel(dir, path)
+		must(err)
+		failed = append(failed, fmt.Sprintf("%s: %s", filepath.ToSlash(rel), sig))
+	}
+	panicIf(len(failed) > 0, "%d of %d known-bad documents crash or hang again:\n%s", len(failed), len(paths), strings.Join(failed, "\n"))
+	logf("none of %d known-bad documents crash or hang\n", len(paths))
+}
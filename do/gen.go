@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// -gen [name...|all] runs code generators whose inputs changed since last run
+// (or whose outputs were modified or are missing). With names, runs only
+// those generators, unconditionally.
+// -gen-check re-runs all generators and fails if files checked into git
+// differ from re-generated output (i.e. someone forgot to run -gen or edited
+// generated file by hand).
+//
+// Hashes of inputs and outputs of last run are remembered in out/gen-stamps.json
+//
+// Note: src/utils/BuildConfig.h (git sha1, pre-release version) is written
+// during the build and reverted afterwards so it's not a generator
+
+// Generator describes a code generation step
+type Generator struct {
+	Name string
+	// files or directories (all files inside, recursively)
+	Inputs  []string
+	Outputs []string
+	Gen     func()
+}
+
+var generators = []*Generator{
+	{
+		Name:    "settings",
+		Inputs:  []string{"do/settings_def.go", "do/settings_gen_code.go", "src/Version.h"},
+		Outputs: []string{"src/Settings.h"},
+		Gen:     genAndSaveSettingsHeader,
+	},
+	{
+		Name:    "translation-langs",
+		Inputs:  []string{"do/trans_langs.go", "do/trans_gen.go", "do/trans_plural.go"},
+		Outputs: []string{"src/TranslationLangs.cpp"},
+		Gen:     genTranslationInfoCpp,
+	},
+	{
+		Name:    "trans-strings",
+		Inputs:  []string{"src", "do/trans_extract.go"},
+		Outputs: []string{"translations/strings.txt"},
+		Gen:     genTransStringsMust,
+	},
+	{
+		Name:    "trans-pseudo",
+		Inputs:  []string{"translations/strings.txt", "do/trans_pseudo.go"},
+		Outputs: []string{"translations/translations-pseudo.txt"},
+		Gen:     genPseudoTranslationsMust,
+	},
+	{
+		Name:    "translations-good",
+		Inputs:  []string{"translations/translations.txt", "translations/translations-mt.txt", "do/trans_download.go"},
+		Outputs: []string{"translations/translations-good.txt"},
+		Gen: func() {
+			generateGoodSubset(readFileMust(translationsTxtPath))
+		},
+	},
+	{
+		Name:    "docs",
+		Inputs:  []string{"docs/md", "docs/manual.tmpl.html", "do/gen_docs.go", "do/gen_docs.search.html", "do/gen_docs.search.js"},
+		Outputs: []string{"docs/manual.dat"},
+		Gen:     genHTMLDocsForApp,
+	},
+}
+
+var genStampsPath = filepath.Join("out", "gen-stamps.json")
+
+// GenStamp is hash of inputs and outputs after a generator was run
+type GenStamp struct {
+	InputsHash  string `json:"inputs_hash"`
+	OutputsHash string `json:"outputs_hash"`
+}
+
+func findGeneratorMust(name string) *Generator {
+	var names []string
+	for _, g := range generators {
+		if g.Name == name {
+			return g
+		}
+		names = append(names, g.Name)
+	}
+	panicIf(true, "unknown generator '%s', must be one of: %s", name, strings.Join(names, ", "))
+	return nil
+}
+
+// expands directories to list of files, sorted for stable hashing
+func expandGenPathsMust(paths []string) []string {
+	var res []string
+	for _, path := range paths {
+		path = filepath.FromSlash(path)
+		if !dirExists(path) {
+			res = append(res, path)
+			continue
+		}
+		err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			must(err)
+			if d.Type().IsRegular() {
+				res = append(res, path)
+			}
+			return nil
+		})
+		must(err)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// returns "" if any of the files is missing
+func hashGenFiles(paths []string) string {
+	h := sha256.New()
+	for _, path := range expandGenPathsMust(paths) {
+		d, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		fmt.Fprintf(h, "%s:%d\n", filepath.ToSlash(path), len(d))
+		h.Write(d)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func readGenStamps() map[string]*GenStamp {
+	res := map[string]*GenStamp{}
+	d, err := os.ReadFile(genStampsPath)
+	if err != nil {
+		return res
+	}
+	if err = json.Unmarshal(d, &res); err != nil {
+		logf("readGenStamps: failed to parse '%s', error: %s\n", genStampsPath, err)
+		return map[string]*GenStamp{}
+	}
+	return res
+}
+
+func writeGenStampsMust(stamps map[string]*GenStamp) {
+	d, err := json.MarshalIndent(stamps, "", "  ")
+	must(err)
+	must(createDirForFile(genStampsPath))
+	writeFileMust(genStampsPath, d)
+}
+
+// returns reason for re-generating or "" if up to date
+func (g *Generator) staleReason(stamp *GenStamp) string {
+	if stamp == nil {
+		return "never generated"
+	}
+	if hashGenFiles(g.Inputs) != stamp.InputsHash {
+		return "inputs changed"
+	}
+	outputsHash := hashGenFiles(g.Outputs)
+	if outputsHash == "" {
+		return "outputs missing"
+	}
+	if outputsHash != stamp.OutputsHash {
+		return "outputs modified"
+	}
+	return ""
+}
+
+func (g *Generator) runMust(stamps map[string]*GenStamp) {
+	printDur := makePrintDuration("gen: " + g.Name)
+	g.Gen()
+	printDur()
+	for _, path := range g.Outputs {
+		panicIf(!fileExists(filepath.FromSlash(path)), "generator '%s' didn't create '%s'", g.Name, path)
+	}
+	stamps[g.Name] = &GenStamp{
+		InputsHash:  hashGenFiles(g.Inputs),
+		OutputsHash: hashGenFiles(g.Outputs),
+	}
+}
+
+func runGenerators(names []string) {
+	stamps := readGenStamps()
+	defer writeGenStampsMust(stamps)
+
+	if len(names) == 1 && names[0] == "all" {
+		names = nil
+		for _, g := range generators {
+			names = append(names, g.Name)
+		}
+	}
+	if len(names) > 0 {
+		for _, name := range names {
+			findGeneratorMust(name).runMust(stamps)
+		}
+		return
+	}
+
+	nRun := 0
+	for _, g := range generators {
+		reason := g.staleReason(stamps[g.Name])
+		if reason == "" {
+			logf("gen: %s is up to date\n", g.Name)
+			continue
+		}
+		logf("gen: %s is stale (%s)\n", g.Name, reason)
+		g.runMust(stamps)
+		nRun++
+	}
+	logf("gen: ran %d out of %d generators\n", nRun, len(generators))
+}
+
+// re-generates all files and checks that those checked into git didn't change
+func checkGeneratedFiles() {
+	runGenerators([]string{"all"})
+
+	var outputs []string
+	for _, g := range generators {
+		outputs = append(outputs, g.Outputs...)
+	}
+	args := append([]string{"status", "--porcelain", "--"}, outputs...)
+	out := runExeMust("git", args...)
+	changed := toTrimmedLines(out)
+	if len(changed) == 0 {
+		logf("gen-check: all generated files are up to date\n")
+		return
+	}
+	logf("gen-check: generated files differ from those checked in:\n")
+	for _, l := range changed {
+		logf("  %s\n", l)
+	}
+	logf("run: doit.bat -gen and check in the changes\n")
+	panicIf(true, "gen-check: %d generated files are out of date", len(changed))
+}
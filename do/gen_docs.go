@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/csv"
 	"fmt"
+	"html/template"
 	"io"
 	"io/fs"
 	"os"
@@ -375,17 +376,23 @@ func mdToHTML(name string, force bool) ([]byte, error) {
 	editLink := `<center><a href="https://github.com/sumatrapdfreader/sumatrapdf/blob/master/docs/md/{name}" target="_blank" class="suggest-change">edit</a></center>`
 	editLink = strings.Replace(editLink, "{name}", name, -1)
 	innerHTML += editLink
-	filePath = "manual.tmpl.html"
-	if docsForWebsite {
-		filePath = "manual.website.tmpl.html"
-	}
-	tmplManual, err := fs.ReadFile(fsys, filePath)
-	must(err)
-	s := strings.Replace(string(tmplManual), "{{InnerHTML}}", innerHTML, -1)
 	title := getHTMLFileName(name)
 	title = strings.Replace(title, ".html", "", -1)
 	title = strings.Replace(title, "-", " ", -1)
-	s = strings.Replace(s, "{{Title}}", title, -1)
+	var s string
+	if docsForWebsite {
+		p := &WebsitePage{
+			Title:   title,
+			Nav:     "docs",
+			Content: template.HTML(innerHTML),
+		}
+		s = string(renderWebsitePageMust(p))
+	} else {
+		tmplManual, err := fs.ReadFile(fsys, "manual.tmpl.html")
+		must(err)
+		s = strings.Replace(string(tmplManual), "{{InnerHTML}}", innerHTML, -1)
+		s = strings.Replace(s, "{{Title}}", title, -1)
+	}
 
 	panicIf(searchJS == "")
 	if name == "Commands.md" {
@@ -437,8 +444,7 @@ func removeHTMLFilesInDir(dir string) {
 
 func getWWWOutDir() string {
 	if docsForWebsite {
-		dir := getWebsiteDir()
-		return filepath.Join(dir, "server", "www", "docs")
+		return filepath.Join(websiteOutDir, "docs")
 	}
 	return filepath.Join("docs", "www")
 }
@@ -582,53 +588,6 @@ func checkComandsAreDocumented() {
 	}
 }
 
-func copyDocsToWebsite() {
-	logf("copyDocsToWebsite()\n")
-	updateSumatraWebsite()
-	srcDir := filepath.Join("docs", "md")
-	websiteDir := getWebsiteDir()
-	dstDir := filepath.Join(websiteDir, "server", "www", "docs-md")
-	must(os.RemoveAll(dstDir))
-
-	copyFilesExtsToNormalizeNL = []string{".md", ".css"}
-	copyFileMustOverwrite = true
-	copyFilesRecurMust(dstDir, srcDir)
-	files := []string{"notion.css", "sumatra.css"}
-	for _, name := range files {
-		srcPath := filepath.Join("docs", "www", name)
-		dstPath := filepath.Join(websiteDir, "server", "www", name)
-		copyFileMust(dstPath, srcPath)
-	}
-
-	d := runExeInDirMust(websiteDir, "git", "status")
-	logf("\n%s\n", string(d))
-}
-
-func genHTMLDocsForWebsite() {
-	if false {
-		genHTMLDocsForWebsite2()
-	} else {
-		copyDocsToWebsite()
-	}
-}
-
-// TODO: for now we just copy .md files to sumatra-website repo and use
-// the existing md => html generation, which is duplicate of what we do here
-// if we improve html generation here a lot, we'll switch to generating
-// html files for sumatra-website here
-func genHTMLDocsForWebsite2() {
-	logf("genHTMLDocsForWebsite2 starting\n")
-	docsForWebsite = true
-	dir := updateSumatraWebsite()
-	currBranch := getCurrentBranchMust(dir)
-	panicIf(currBranch != "master")
-	// don't use .html extension in links to generated .html files
-	// for docs we need them because they are shown from file system
-	// for website we prefer "clean" links because they are served via web server
-	mdHTMLExt = false
-	genHTMLDocsFromMarkdown()
-}
-
 func genHTMLDocsForApp() {
 	logf("genHTMLDocsFromMarkdown starting\n")
 	timeStart := time.Now()
@@ -2,7 +2,10 @@ package main
 
 import (
 	"os"
+	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func getGitLinearVersionMust() int {
@@ -22,6 +25,22 @@ func getGitSha1Must() string {
 	return s
 }
 
+// time of HEAD commit, used as timestamp of files in archives so that
+// they're reproducible
+func getGitCommitTimeMust() time.Time {
+	out := runExeMust("git", "log", "-1", "--format=%ct")
+	n, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	must(err)
+	return time.Unix(n, 0).UTC()
+}
+
+// false if sha1 is not in history of HEAD e.g. after force push
+func isGitAncestorOfHead(sha1 string) bool {
+	cmd := exec.Command("git", "merge-base", "--is-ancestor", sha1, "HEAD")
+	logf("> %s\n", cmd)
+	return cmd.Run() == nil
+}
+
 func isGitClean(dir string) bool {
 	out := runExeInDirMust(dir, "git", "status", "--porcelain")
 	s := strings.TrimSpace(string(out))
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Minimal client for GitHub REST API, used to create GitHub releases and
+// to submit new releases to package manager repositories (winget-pkgs etc.)
+// as pull requests from a fork, without having to clone those (big)
+// repositories.
+//
+// Needs GITHUB_PUBLISH_TOKEN (in secrets file or env): a personal access
+// token with public_repo scope of the account that owns the forks and
+// can create releases in sumatrapdfreader/sumatrapdf.
+// GITHUB_TOKEN provided by GitHub Actions can't create forks or PRs in
+// other repositories.
+
+var githubPublishToken string
+
+const githubAPIURL = "https://api.github.com"
+
+// GithubAPIError is returned for responses with status code >= 400
+type GithubAPIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *GithubAPIError) Error() string {
+	return fmt.Sprintf("github api: status code %d, body: '%s'", e.StatusCode, e.Body)
+}
+
+func isGithubNotFound(err error) bool {
+	if e, ok := err.(*GithubAPIError); ok {
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// sends req with auth headers, returns body of the response
+func githubDo(req *http.Request, timeout time.Duration) ([]byte, error) {
+	panicIf(githubPublishToken == "", "need GITHUB_PUBLISH_TOKEN env variable")
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+	req.Header.Set("Authorization", "Bearer "+githubPublishToken)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if req.Method != http.MethodGet && dryRunSkip("%s %s", req.Method, req.URL) {
+		return []byte("{}"), nil
+	}
+	client := &http.Client{Timeout: timeout}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	d, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode >= 400 {
+		return nil, &GithubAPIError{StatusCode: rsp.StatusCode, Body: string(d)}
+	}
+	return d, nil
+}
+
+// body and res are JSON-serialized, either can be nil
+func githubAPI(method string, path string, body interface{}, res interface{}) error {
+	var r io.Reader
+	if body != nil {
+		d, err := json.Marshal(body)
+		must(err)
+		r = bytes.NewReader(d)
+	}
+	req, err := http.NewRequest(method, githubAPIURL+path, r)
+	must(err)
+	d, err := githubDo(req, time.Minute)
+	if err != nil {
+		return err
+	}
+	if res == nil || len(d) == 0 {
+		return nil
+	}
+	return json.Unmarshal(d, res)
+}
+
+func githubAPIMust(method string, path string, body interface{}, res interface{}) {
+	err := githubAPI(method, path, body, res)
+	panicIf(err != nil, "%s %s failed with '%s'", method, path, err)
+}
+
+func githubGetLoginMust() string {
+	var res struct {
+		Login string `json:"login"`
+	}
+	githubAPIMust(http.MethodGet, "/user", nil, &res)
+	return res.Login
+}
+
+// creates a fork of upstream (e.g. "microsoft/winget-pkgs") if it doesn't
+// exist yet and syncs its base branch with upstream. Returns "owner/repo" of
+// the fork
+func githubForkAndSyncMust(upstream string, base string) string {
+	login := githubGetLoginMust()
+	repoName := upstream[strings.Index(upstream, "/")+1:]
+	fork := login + "/" + repoName
+	err := githubAPI(http.MethodGet, "/repos/"+fork, nil, nil)
+	if isGithubNotFound(err) {
+		logf("creating fork '%s' of '%s'\n", fork, upstream)
+		githubAPIMust(http.MethodPost, "/repos/"+upstream+"/forks", nil, nil)
+		// forking is asynchronous
+		for i := 0; i < 30; i++ {
+			time.Sleep(time.Second * 10)
+			err = githubAPI(http.MethodGet, "/repos/"+fork, nil, nil)
+			if err == nil {
+				break
+			}
+		}
+	}
+	must(err)
+	body := map[string]string{"branch": base}
+	githubAPIMust(http.MethodPost, "/repos/"+fork+"/merge-upstream", body, nil)
+	return fork
+}
+
+func githubGetBranchShaMust(repo string, branch string) string {
+	var res struct {
+		Object struct {
+			Sha string `json:"sha"`
+		} `json:"object"`
+	}
+	githubAPIMust(http.MethodGet, "/repos/"+repo+"/git/ref/heads/"+branch, nil, &res)
+	return res.Object.Sha
+}
+
+// returns "" if file doesn't exist
+func githubGetFileShaMust(repo string, path string, branch string) string {
+	var res struct {
+		Sha string `json:"sha"`
+	}
+	err := githubAPI(http.MethodGet, "/repos/"+repo+"/contents/"+path+"?ref="+branch, nil, &res)
+	if isGithubNotFound(err) {
+		return ""
+	}
+	must(err)
+	return res.Sha
+}
+
+// returns content of the file in a given branch of the repo
+func githubGetFileContentMust(repo string, path string, branch string) []byte {
+	var res struct {
+		Content string `json:"content"`
+	}
+	githubAPIMust(http.MethodGet, "/repos/"+repo+"/contents/"+path+"?ref="+branch, nil, &res)
+	d, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(res.Content, "\n", ""))
+	must(err)
+	return d
+}
+
+// GithubPR describes a pull request to create from a branch in a fork
+type GithubPR struct {
+	Upstream string // e.g. "microsoft/winget-pkgs"
+	Base     string // e.g. "master"
+	Branch   string // created in the fork
+	Title    string
+	Body     string
+	// path in repo => content
+	Files map[string][]byte
+}
+
+// commits files to a new branch in our fork of pr.Upstream and opens a pull
+// request. Returns url of the pull request
+func githubCreatePRMust(pr *GithubPR) string {
+	fork := githubForkAndSyncMust(pr.Upstream, pr.Base)
+	sha := githubGetBranchShaMust(fork, pr.Base)
+	ref := map[string]string{
+		"ref": "refs/heads/" + pr.Branch,
+		"sha": sha,
+	}
+	githubAPIMust(http.MethodPost, "/repos/"+fork+"/git/refs", ref, nil)
+
+	var paths []string
+	for path := range pr.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		body := map[string]string{
+			"message": pr.Title,
+			"content": base64.StdEncoding.EncodeToString(pr.Files[path]),
+			"branch":  pr.Branch,
+		}
+		// updating existing file requires its sha
+		if fileSha := githubGetFileShaMust(fork, path, pr.Branch); fileSha != "" {
+			body["sha"] = fileSha
+		}
+		githubAPIMust(http.MethodPut, "/repos/"+fork+"/contents/"+path, body, nil)
+		logf("committed '%s' to %s:%s\n", path, fork, pr.Branch)
+	}
+
+	owner := fork[:strings.Index(fork, "/")]
+	body := map[string]string{
+		"title": pr.Title,
+		"head":  owner + ":" + pr.Branch,
+		"base":  pr.Base,
+		"body":  pr.Body,
+	}
+	var res struct {
+		HTMLURL string `json:"html_url"`
+	}
+	githubAPIMust(http.MethodPost, "/repos/"+pr.Upstream+"/pulls", body, &res)
+	logf("created pull request %s\n", res.HTMLURL)
+	return res.HTMLURL
+}
@@ -16,6 +16,9 @@ func triggerBuildWebHook(typ string) {
 	panicIf(ghtoken == "", "need GITHUB_TOKEN env variable")
 	data := fmt.Sprintf(`{"event_type": "%s"}`, typ)
 	uri := "https://api.github.com/repos/sumatrapdfreader/sumatrapdf/dispatches"
+	if dryRunSkip("trigger build '%s' with POST %s", typ, uri) {
+		return
+	}
 	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(data))
 	must(err)
 	req.Header.Set("Accept", "application/vnd.github.everest-preview+json")
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// -github-release creates GitHub release for tag ${ver}rel of release build
+// in out/, uploads the files (installers, portable, symbols, SHA256SUMS.txt)
+// and sets the body from release notes (see -update-changelog).
+//
+// Release is created as a draft and only published after all files are
+// uploaded and verified, so nobody sees a release with missing files.
+// Re-running is safe: files that are already uploaded are skipped.
+
+const (
+	githubReleaseRepo       = "sumatrapdfreader/sumatrapdf"
+	githubUploadsURL        = "https://uploads.github.com"
+	githubUploadMaxAttempts = 3
+)
+
+// GithubRelease is a release in GitHub API
+type GithubRelease struct {
+	ID      int64                 `json:"id"`
+	TagName string                `json:"tag_name"`
+	Name    string                `json:"name"`
+	Body    string                `json:"body"`
+	Draft   bool                  `json:"draft"`
+	HTMLURL string                `json:"html_url"`
+	Assets  []*GithubReleaseAsset `json:"assets"`
+}
+
+// GithubReleaseAsset is a file in a release
+type GithubReleaseAsset struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	State string `json:"state"`
+	// e.g. "sha256:..."
+	Digest string `json:"digest"`
+}
+
+func getGithubReleaseTag(ver string) string {
+	return ver + "rel"
+}
+
+// notes written by -update-changelog or, if not present, from Version-history.md
+// followed by links to VirusTotal scans
+func getGithubReleaseBody(ver string) string {
+	vt := genVirusTotalNotes(readVirusTotalResults(getFinalDirForBuildType(buildTypeRel)))
+	path := getGithubReleaseNotesPath(ver)
+	if fileExists(path) {
+		return strings.TrimSpace(string(readFileMust(path))) + vt
+	}
+	notes, _ := getReleaseNotesFromVersionHistory(ver)
+	return strings.TrimSpace(notes) + vt
+}
+
+// returns nil if there's no release for the tag
+func githubGetReleaseByTagMust(repo string, tag string) *GithubRelease {
+	// drafts are not returned by /releases/tags/${tag}
+	var releases []*GithubRelease
+	githubAPIMust(http.MethodGet, "/repos/"+repo+"/releases?per_page=100", nil, &releases)
+	for _, r := range releases {
+		if r.TagName == tag {
+			return r
+		}
+	}
+	return nil
+}
+
+func githubCreateOrUpdateReleaseMust(repo string, am *ArtifactsManifest) *GithubRelease {
+	tag := getGithubReleaseTag(am.Version)
+	body := map[string]interface{}{
+		"name": "SumatraPDF " + am.Version,
+		"body": getGithubReleaseBody(am.Version),
+	}
+	rel := githubGetReleaseByTagMust(repo, tag)
+	if rel == nil {
+		body["tag_name"] = tag
+		// tag is created if it doesn't exist yet
+		body["target_commitish"] = am.GitSha1
+		body["draft"] = true
+		rel = &GithubRelease{}
+		githubAPIMust(http.MethodPost, "/repos/"+repo+"/releases", body, rel)
+		logf("created draft release %s\n", rel.HTMLURL)
+		return rel
+	}
+	githubAPIMust(http.MethodPatch, fmt.Sprintf("/repos/%s/releases/%d", repo, rel.ID), body, rel)
+	logf("updated release %s\n", rel.HTMLURL)
+	return rel
+}
+
+func githubDeleteReleaseAssetMust(repo string, id int64) {
+	githubAPIMust(http.MethodDelete, fmt.Sprintf("/repos/%s/releases/assets/%d", repo, id), nil, nil)
+}
+
+func githubDownloadReleaseAsset(repo string, id int64) ([]byte, error) {
+	uri := fmt.Sprintf("%s/repos/%s/releases/assets/%d", githubAPIURL, repo, id)
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	must(err)
+	req.Header.Set("Accept", "application/octet-stream")
+	return githubDo(req, time.Minute*10)
+}
+
+func githubUploadReleaseAsset(repo string, rel *GithubRelease, a *ArtifactInfo) (*GithubReleaseAsset, error) {
+	d := readFileMust(filepath.FromSlash(a.Path))
+	uri := fmt.Sprintf("%s/repos/%s/releases/%d/assets?name=%s", githubUploadsURL, repo, rel.ID, url.QueryEscape(a.Name))
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(d))
+	must(err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.ContentLength = int64(len(d))
+	rsp, err := githubDo(req, time.Minute*10)
+	if err != nil {
+		return nil, err
+	}
+	var res GithubReleaseAsset
+	if err = json.Unmarshal(rsp, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// compares uploaded file with sha256 from artifacts.json
+func verifyGithubReleaseAsset(repo string, asset *GithubReleaseAsset, a *ArtifactInfo) error {
+	if asset.Size != a.Size {
+		return fmt.Errorf("size of uploaded '%s' is %d, expected %d", a.Name, asset.Size, a.Size)
+	}
+	// GitHub computes sha256 of newer uploads, for older we download the file back
+	if asset.Digest != "" {
+		if asset.Digest != "sha256:"+a.Sha256 {
+			return fmt.Errorf("digest of uploaded '%s' is %s, expected sha256:%s", a.Name, asset.Digest, a.Sha256)
+		}
+		return nil
+	}
+	d, err := githubDownloadReleaseAsset(repo, asset.ID)
+	if err != nil {
+		return err
+	}
+	if got := sha256HexOfData(d); got != a.Sha256 {
+		return fmt.Errorf("sha256 of uploaded '%s' is %s, expected %s", a.Name, got, a.Sha256)
+	}
+	return nil
+}
+
+// uploads a with re-tries. If upload fails mid-way GitHub keeps the asset
+// in "starter" state and we must delete it before trying again
+func githubUploadReleaseAssetMust(repo string, rel *GithubRelease, a *ArtifactInfo) {
+	var err error
+	for i := 1; i <= githubUploadMaxAttempts; i++ {
+		timeStart := time.Now()
+		var asset *GithubReleaseAsset
+		asset, err = githubUploadReleaseAsset(repo, rel, a)
+		if err == nil {
+			err = verifyGithubReleaseAsset(repo, asset, a)
+			if err == nil {
+				logf("uploaded '%s' (%s) to GitHub release in %s\n", a.Name, formatSize(a.Size), time.Since(timeStart))
+				return
+			}
+			githubDeleteReleaseAssetMust(repo, asset.ID)
+		}
+		logf("upload of '%s' failed (attempt %d of %d) with '%s'\n", a.Name, i, githubUploadMaxAttempts, err)
+		// asset from failed upload
+		if r := githubGetReleaseByTagMust(repo, rel.TagName); r != nil {
+			for _, asset := range r.Assets {
+				if asset.Name == a.Name {
+					githubDeleteReleaseAssetMust(repo, asset.ID)
+				}
+			}
+		}
+		time.Sleep(time.Second * time.Duration(i*10))
+	}
+	must(err)
+}
+
+// we don't upload patches for the updater and symbols in lzsa format
+// used by crash reporting
+func isGithubReleaseAsset(kind string) bool {
+	switch kind {
+	case kArtifactDeltaUpdate, kArtifactDeltaIndex, kArtifactPdbLzsa:
+		return false
+	}
+	return true
+}
+
+func githubReleaseMust() {
+	repo := githubReleaseRepo
+	am := readArtifactsManifestMust(getFinalDirForBuildType(buildTypeRel))
+	panicIf(am.BuildType != buildTypeRel, "GitHub release can only be created for release builds, not '%s'", am.BuildType)
+	rel := githubCreateOrUpdateReleaseMust(repo, am)
+
+	existing := map[string]*GithubReleaseAsset{}
+	for _, asset := range rel.Assets {
+		existing[asset.Name] = asset
+	}
+	for _, a := range am.Artifacts {
+		if !isGithubReleaseAsset(a.Kind) {
+			continue
+		}
+		if asset := existing[a.Name]; asset != nil {
+			if asset.State == "uploaded" && verifyGithubReleaseAsset(repo, asset, a) == nil {
+				logf("'%s' already uploaded\n", a.Name)
+				continue
+			}
+			githubDeleteReleaseAssetMust(repo, asset.ID)
+		}
+		githubUploadReleaseAssetMust(repo, rel, a)
+	}
+	sums := getSha256SumsArtifactMust(getFinalDirForBuildType(buildTypeRel))
+	if asset := existing[sums.Name]; asset != nil {
+		// SHA256SUMS.txt is small so we always re-upload it
+		githubDeleteReleaseAssetMust(repo, asset.ID)
+	}
+	githubUploadReleaseAssetMust(repo, rel, sums)
+
+	if rel.Draft {
+		body := map[string]interface{}{
+			"draft":       false,
+			"make_latest": "true",
+		}
+		githubAPIMust(http.MethodPatch, fmt.Sprintf("/repos/%s/releases/%d", repo, rel.ID), body, rel)
+	}
+	logf("published GitHub release %s\n", rel.HTMLURL)
+}
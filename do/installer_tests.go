@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// -installer-tests [rel|prerel] [ver] [prev-ver] runs installer of an uploaded
+// build through scenarios and checks what it did to the machine:
+//   - per-user install and uninstall
+//   - per-machine install (-all-users) and uninstall, needs admin
+//   - install of previous release (prev-ver, by default the current
+//     release), upgrade to the build and uninstall
+//
+// After install we check uninstall registry entry, file associations, Start
+// menu and desktop shortcuts and that the app starts (-exit-on-startup).
+// After uninstall we check that all of it is gone.
+//
+// This messes with the machine so it only runs in a throwaway VM: GitHub
+// Actions (.github/workflows/installer_tests.yml) or when
+// INSTALLER_TESTS_THROWAWAY_VM=1 is set.
+// Results are in out/installer-tests/results.json
+
+const (
+	installerTestsStatusPass = "pass"
+	installerTestsStatusFail = "fail"
+	installerTestsStatusSkip = "skip"
+	// uninstaller re-launches itself from temp directory so we have to
+	// wait for the results
+	installerTestsWaitTimeout = 2 * time.Minute
+	installerTestsUninstKey   = `Software\Microsoft\Windows\CurrentVersion\Uninstall\SumatraPDF`
+	installerTestsUpdateURL   = "https://www.sumatrapdfreader.org/update-check-rel.txt"
+)
+
+// InstallerTestResult is result of one scenario
+type InstallerTestResult struct {
+	Scenario string   `json:"scenario"`
+	Status   string   `json:"status"`
+	Problems []string `json:"problems,omitempty"`
+	Note     string   `json:"note,omitempty"`
+}
+
+// InstallerTestCtx is what we check for installation of a given type
+type InstallerTestCtx struct {
+	allUsers   bool
+	installDir string
+	problems   []string
+}
+
+func (c *InstallerTestCtx) addProblem(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	logf("  problem: %s\n", s)
+	c.problems = append(c.problems, s)
+}
+
+func (c *InstallerTestCtx) hive() string {
+	if c.allUsers {
+		return "HKLM"
+	}
+	return "HKCU"
+}
+
+func (c *InstallerTestCtx) shortcutPaths() []string {
+	if c.allUsers {
+		return []string{
+			filepath.Join(os.Getenv("ProgramData"), "Microsoft", "Windows", "Start Menu", "SumatraPDF.lnk"),
+			filepath.Join(os.Getenv("PUBLIC"), "Desktop", "SumatraPDF.lnk"),
+		}
+	}
+	return []string{
+		filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Windows", "Start Menu", "SumatraPDF.lnk"),
+		filepath.Join(os.Getenv("USERPROFILE"), "Desktop", "SumatraPDF.lnk"),
+	}
+}
+
+// returns "" if value doesn't exist. name "" is the default value
+func regQueryValue(key string, name string) string {
+	args := []string{"query", key, "/v", name}
+	if name == "" {
+		args = []string{"query", key, "/ve"}
+	}
+	out, err := exec.Command("reg", args...).Output()
+	if err != nil {
+		return ""
+	}
+	// "    DisplayVersion    REG_SZ    3.5.2"
+	rx := regexp.MustCompile(`^\s*(.*?)\s+REG_\w+\s*(.*)$`)
+	for _, l := range toTrimmedLines(out) {
+		m := rx.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		if name == "" || strings.EqualFold(m[1], name) {
+			return m[2]
+		}
+	}
+	return ""
+}
+
+func regKeyExists(key string) bool {
+	return exec.Command("reg", "query", key).Run() == nil
+}
+
+func waitUntil(timeout time.Duration, fn func() bool) bool {
+	timeStart := time.Now()
+	for !fn() {
+		if time.Since(timeStart) > timeout {
+			return false
+		}
+		time.Sleep(time.Second)
+	}
+	return true
+}
+
+// DisplayVersion is CURR_VERSION, for pre-release e.g. "3.6.16234" so we
+// only check it contains ver.
+// Versions before -exit-on-startup would just start so we don't launch them
+func (c *InstallerTestCtx) checkInstalled(ver string, launch bool) {
+	uninstKey := c.hive() + `\` + installerTestsUninstKey
+	ok := waitUntil(installerTestsWaitTimeout, func() bool {
+		return strings.Contains(regQueryValue(uninstKey, "DisplayVersion"), ver)
+	})
+	if !ok {
+		c.addProblem("%s DisplayVersion is '%s', expected %s", uninstKey, regQueryValue(uninstKey, "DisplayVersion"), ver)
+	}
+	loc := regQueryValue(uninstKey, "InstallLocation")
+	if !strings.EqualFold(filepath.Clean(loc), filepath.Clean(c.installDir)) {
+		c.addProblem("%s InstallLocation is '%s', expected '%s'", uninstKey, loc, c.installDir)
+	}
+	if regQueryValue(uninstKey, "UninstallString") == "" {
+		c.addProblem("%s has no UninstallString", uninstKey)
+	}
+	exePath := filepath.Join(c.installDir, "SumatraPDF.exe")
+	if !fileExists(exePath) {
+		c.addProblem("'%s' doesn't exist", exePath)
+		return
+	}
+
+	progIdsKey := c.hive() + `\Software\Classes\.pdf\OpenWithProgids`
+	if exec.Command("reg", "query", progIdsKey, "/v", "SumatraPDF.pdf").Run() != nil {
+		c.addProblem("%s doesn't have SumatraPDF.pdf", progIdsKey)
+	}
+	cmdKey := c.hive() + `\Software\Classes\SumatraPDF.pdf\shell\open\command`
+	if cmd := regQueryValue(cmdKey, ""); !strings.Contains(strings.ToLower(cmd), strings.ToLower(exePath)) {
+		c.addProblem("%s is '%s', expected to open '%s'", cmdKey, cmd, exePath)
+	}
+	for _, p := range c.shortcutPaths() {
+		if !fileExists(p) {
+			c.addProblem("shortcut '%s' doesn't exist", p)
+		}
+	}
+	if !launch {
+		return
+	}
+	appDataDir := filepath.Join(getInstallerTestsDir(), "appdata")
+	if err := launchExitOnStartup(exePath, appDataDir); err != nil {
+		c.addProblem("%s", err)
+	}
+	os.RemoveAll(appDataDir)
+}
+
+func (c *InstallerTestCtx) uninstallAndCheck() {
+	exePath := filepath.Join(c.installDir, "SumatraPDF.exe")
+	if !fileExists(exePath) {
+		c.addProblem("can't uninstall, '%s' doesn't exist", exePath)
+		return
+	}
+	if err := runWithTimeout(exePath, "-uninstall", "-s"); err != nil {
+		c.addProblem("%s", err)
+	}
+	uninstKey := c.hive() + `\` + installerTestsUninstKey
+	if !waitUntil(installerTestsWaitTimeout, func() bool { return !regKeyExists(uninstKey) }) {
+		c.addProblem("%s still exists after uninstall", uninstKey)
+	}
+	if !waitUntil(installerTestsWaitTimeout, func() bool { return !fileExists(exePath) }) {
+		c.addProblem("'%s' still exists after uninstall", exePath)
+	}
+	if exec.Command("reg", "query", c.hive()+`\Software\Classes\.pdf\OpenWithProgids`, "/v", "SumatraPDF.pdf").Run() == nil {
+		c.addProblem("SumatraPDF.pdf still in %s\\Software\\Classes\\.pdf\\OpenWithProgids after uninstall", c.hive())
+	}
+	for _, p := range c.shortcutPaths() {
+		if fileExists(p) {
+			c.addProblem("shortcut '%s' still exists after uninstall", p)
+		}
+	}
+}
+
+func installForTest(installerPath string, c *InstallerTestCtx) error {
+	args := []string{"-install", "-s", "-d", c.installDir}
+	if c.allUsers {
+		args = append(args, "-all-users")
+	}
+	return runWithTimeout(installerPath, args...)
+}
+
+func getInstallerTestsDir() string {
+	return filepath.Join("out", "installer-tests")
+}
+
+// installer for the arch of this machine
+func getInstallerURLForThisMachine(buildType BuildType, ver string) string {
+	urls := getDownloadUrlsViaWebsite(buildType, ver)
+	if runtime.GOARCH == "arm64" {
+		return urls.installerArm64
+	}
+	return urls.installer64
+}
+
+func downloadInstallerForTestMust(buildType BuildType, ver string) string {
+	uri := getInstallerURLForThisMachine(buildType, ver)
+	path := filepath.Join(getInstallerTestsDir(), "installers", string(buildType)+"-"+ver+"-"+filepath.Base(uri))
+	if !fileExists(path) {
+		logf("downloading '%s'\n", uri)
+		must(httpDownloadToFile(uri, path))
+	}
+	return path
+}
+
+// version of the current release, from update check file of the website
+func getCurrentReleaseVersionFromWebsiteMust() string {
+	path := filepath.Join(getInstallerTestsDir(), "update-check-rel.txt")
+	must(httpDownloadToFile(installerTestsUpdateURL, path))
+	m := regexp.MustCompile(`(?m)^Latest:?\s*(\S+)`).FindSubmatch(readFileMust(path))
+	panicIf(m == nil, "no version in '%s'", installerTestsUpdateURL)
+	return string(m[1])
+}
+
+func isRunningAsAdmin() bool {
+	// only succeeds when elevated
+	return exec.Command("net", "session").Run() == nil
+}
+
+func runInstallerTestScenario(name string, fn func(c *InstallerTestCtx) string) *InstallerTestResult {
+	logf("\ninstaller test: %s\n", name)
+	res := &InstallerTestResult{Scenario: name, Status: installerTestsStatusPass}
+	c := &InstallerTestCtx{}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.addProblem("%v", r)
+			}
+		}()
+		if note := fn(c); note != "" {
+			res.Status = installerTestsStatusSkip
+			res.Note = note
+		}
+	}()
+	if len(c.problems) > 0 {
+		res.Status = installerTestsStatusFail
+		res.Problems = c.problems
+	}
+	logf("installer test: %s: %s %s\n", name, res.Status, res.Note)
+	return res
+}
+
+// true if we can install SumatraPDF on this machine: it's a GitHub Actions
+// runner or a VM marked with INSTALLER_TESTS_THROWAWAY_VM=1
+func isThrowawayVM() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true" || os.Getenv("INSTALLER_TESTS_THROWAWAY_VM") == "1"
+}
+
+func installerTestsMust(buildType BuildType, ver string, prevVer string) {
+	defer makePrintDuration("installerTestsMust")()
+	panicIf(!isThrowawayVM(), "installer tests change the machine, only run them in a VM with INSTALLER_TESTS_THROWAWAY_VM=1")
+	panicIf(runtime.GOOS != "windows", "installer tests only run on Windows")
+	if ver == "" {
+		ver = getVerForBuildType(buildType)
+	}
+	dir := getInstallerTestsDir()
+	must(os.MkdirAll(dir, 0755))
+	installerPath := downloadInstallerForTestMust(buildType, ver)
+	tmpDir, err := filepath.Abs(filepath.Join(dir, "install"))
+	must(err)
+
+	var results []*InstallerTestResult
+	results = append(results, runInstallerTestScenario("per-user install and uninstall", func(c *InstallerTestCtx) string {
+		c.installDir = filepath.Join(tmpDir, "per-user")
+		if err := installForTest(installerPath, c); err != nil {
+			c.addProblem("%s", err)
+			return ""
+		}
+		c.checkInstalled(ver, true)
+		c.uninstallAndCheck()
+		return ""
+	}))
+
+	results = append(results, runInstallerTestScenario("per-machine install and uninstall", func(c *InstallerTestCtx) string {
+		if !isRunningAsAdmin() {
+			return "needs admin"
+		}
+		c.allUsers = true
+		c.installDir = filepath.Join(tmpDir, "per-machine")
+		if err := installForTest(installerPath, c); err != nil {
+			c.addProblem("%s", err)
+			return ""
+		}
+		c.checkInstalled(ver, true)
+		c.uninstallAndCheck()
+		return ""
+	}))
+
+	results = append(results, runInstallerTestScenario("upgrade from previous release", func(c *InstallerTestCtx) string {
+		if prevVer == "" {
+			prevVer = getCurrentReleaseVersionFromWebsiteMust()
+		}
+		if prevVer == ver {
+			return fmt.Sprintf("%s is the current release, give previous version as argument", ver)
+		}
+		prevInstallerPath := downloadInstallerForTestMust(buildTypeRel, prevVer)
+		c.installDir = filepath.Join(tmpDir, "upgrade")
+		if err := installForTest(prevInstallerPath, c); err != nil {
+			c.addProblem("install of %s: %s", prevVer, err)
+			return ""
+		}
+		c.checkInstalled(prevVer, false)
+		if err := installForTest(installerPath, c); err != nil {
+			c.addProblem("upgrade to %s: %s", ver, err)
+		}
+		c.checkInstalled(ver, true)
+		c.uninstallAndCheck()
+		return ""
+	}))
+
+	d, err := json.MarshalIndent(results, "", "  ")
+	must(err)
+	writeFileMust(filepath.Join(dir, "results.json"), d)
+
+	logf("\ninstaller tests of %s %s:\n", buildType, ver)
+	var failed []string
+	for _, r := range results {
+		logf("  %s: %s %s\n", r.Status, r.Scenario, r.Note)
+		for _, p := range r.Problems {
+			logf("    %s\n", p)
+		}
+		if r.Status == installerTestsStatusFail {
+			failed = append(failed, r.Scenario)
+		}
+	}
+	panicIf(len(failed) > 0, "installer tests failed: %s", strings.Join(failed, ", "))
+}
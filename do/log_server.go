@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -log-server [tcp=${addr}] collects logs from many SumatraPDF instances at
+// once, which helps debugging multi-window and IPC issues.
+//
+// It accepts the same messages as logview (see docs/md/Logview.md) on the
+// logview named pipe (on Windows, don't run logview at the same time) and on
+// TCP (default logServerTCPAddr), where every line is a message.
+//
+// Every connection is an instance, tagged with app name and pid from
+// "app: ${name}\npid: ${pid}\n" it sends after connecting. Logs of all
+// instances are printed together with their tag and recorded to
+// out/log-sessions/${time}.jsonl.
+//
+// -log-replay ${file} [${tag}] prints a recorded session, optionally only
+// logs of one instance.
+
+const (
+	logServerTCPAddr = "127.0.0.1:9123"
+	logPipeName      = `\\.\pipe\LOCAL\ArsLexis-Logger`
+	logPipeBufSize   = 1024 * 16
+)
+
+var logSessionsDir = filepath.Join("out", "log-sessions")
+
+// LogRecord is a log message from an instance, as recorded in a session
+type LogRecord struct {
+	Instance  string `json:"instance"`
+	Level     string `json:"level"`
+	Subsystem string `json:"subsystem,omitempty"`
+	// milliseconds since unix epoch, as sent by the app or when received
+	Time int64  `json:"time"`
+	Text string `json:"text"`
+}
+
+// message is either a structured record:
+// "L\t${level}\t${timestamp ms}\t${subsystem}\t${text}"
+// or raw text, which is logged at info level
+func parseLogRecord(s string) *LogRecord {
+	res := &LogRecord{
+		Level: "info",
+		Time:  time.Now().UnixMilli(),
+		Text:  s,
+	}
+	if !strings.HasPrefix(s, "L\t") {
+		return res
+	}
+	parts := strings.SplitN(s, "\t", 5)
+	if len(parts) != 5 {
+		return res
+	}
+	ms, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return res
+	}
+	res.Level, res.Time, res.Subsystem, res.Text = parts[1], ms, parts[3], parts[4]
+	return res
+}
+
+// "app: SumatraPDF\npid: 1234\n" => "SumatraPDF:1234", "" if s isn't
+// an announcement
+func parseLogAnnounce(s string) string {
+	rest, ok := strings.CutPrefix(s, "app: ")
+	if !ok {
+		return ""
+	}
+	app, rest, _ := strings.Cut(rest, "\n")
+	tag := strings.TrimSpace(app)
+	if pid, ok := strings.CutPrefix(rest, "pid: "); ok {
+		pid, _, _ = strings.Cut(pid, "\n")
+		tag += ":" + strings.TrimSpace(pid)
+	}
+	return tag
+}
+
+func formatLogRecord(r *LogRecord) string {
+	t := time.UnixMilli(r.Time).Format("15:04:05.000")
+	text := strings.TrimSuffix(r.Text, "\n")
+	return fmt.Sprintf("[%s] %s %-5s %-10s %s", r.Instance, t, r.Level, r.Subsystem, text)
+}
+
+// LogServer multiplexes logs of instances and records them
+type LogServer struct {
+	mu      sync.Mutex
+	session *os.File
+	nConns  int
+}
+
+func (s *LogServer) record(r *LogRecord) {
+	d, err := json.Marshal(r)
+	must(err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Println(formatLogRecord(r))
+	_, err = s.session.Write(append(d, '\n'))
+	must(err)
+}
+
+// reads messages with next() until it fails
+func (s *LogServer) handleConn(kind string, next func() (string, error)) {
+	s.mu.Lock()
+	s.nConns++
+	tag := fmt.Sprintf("#%d", s.nConns)
+	s.mu.Unlock()
+	logf("%s: %s connected\n", tag, kind)
+	for {
+		msg, err := next()
+		if err != nil {
+			break
+		}
+		if t := parseLogAnnounce(msg); t != "" {
+			logf("%s: is %s\n", tag, t)
+			tag = t
+		} else if pid, ok := strings.CutPrefix(msg, "pid: "); ok && !strings.HasPrefix(tag, "#") && !strings.Contains(tag, ":") {
+			// over tcp "pid: " is a separate line
+			tag += ":" + strings.TrimSpace(pid)
+		}
+		r := parseLogRecord(msg)
+		r.Instance = tag
+		s.record(r)
+	}
+	logf("%s: disconnected\n", tag)
+}
+
+func (s *LogServer) listenTCP(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logf("listening on tcp %s\n", addr)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewScanner(conn)
+			r.Buffer(make([]byte, logPipeBufSize), 1024*1024)
+			s.handleConn("tcp "+conn.RemoteAddr().String(), func() (string, error) {
+				if !r.Scan() {
+					if r.Err() != nil {
+						return "", r.Err()
+					}
+					return "", net.ErrClosed
+				}
+				return r.Text() + "\n", nil
+			})
+		}()
+	}
+}
+
+// -log-server [tcp=${addr}]
+func logServerMust(args []string) {
+	addr := logServerTCPAddr
+	for _, arg := range args {
+		v, ok := strings.CutPrefix(arg, "tcp=")
+		panicIf(!ok, "unknown argument '%s', usage: -log-server [tcp=${addr}]", arg)
+		addr = v
+	}
+	path := filepath.Join(logSessionsDir, time.Now().Format("2006-01-02_15-04-05")+".jsonl")
+	must(createDirForFile(path))
+	f, err := os.Create(path)
+	must(err)
+	defer f.Close()
+	logf("recording session to '%s'\n", path)
+
+	srv := &LogServer{session: f}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- srv.listenTCP(addr)
+	}()
+	go func() {
+		errs <- listenLogPipe(srv)
+	}()
+	// pipe is only available on Windows, keep going as long as one works
+	for i := 0; i < 2; i++ {
+		logf("log server: %s\n", <-errs)
+	}
+}
+
+// -log-replay ${file} [${tag}]
+func logReplayMust(args []string) {
+	panicIf(len(args) < 1 || len(args) > 2, "usage: -log-replay ${session.jsonl} [${instance tag}]")
+	f, err := os.Open(args[0])
+	must(err)
+	defer f.Close()
+	r := bufio.NewScanner(f)
+	r.Buffer(make([]byte, logPipeBufSize), 1024*1024)
+	instances := map[string]int{}
+	for r.Scan() {
+		var rec LogRecord
+		must(json.Unmarshal(r.Bytes(), &rec))
+		instances[rec.Instance]++
+		if len(args) == 2 && rec.Instance != args[1] {
+			continue
+		}
+		fmt.Println(formatLogRecord(&rec))
+	}
+	must(r.Err())
+	for tag, n := range instances {
+		logf("%s: %d logs\n", tag, n)
+	}
+}
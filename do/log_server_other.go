@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// named pipes are only on Windows, other platforms only get tcp
+func listenLogPipe(srv *LogServer) error {
+	return errors.New("logview pipe is only available on Windows")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modKernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = modKernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = modKernel32.NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe = modKernel32.NewProc("DisconnectNamedPipe")
+)
+
+const (
+	pipeAccessInbound      = 0x1
+	pipeTypeMessage        = 0x4
+	pipeReadmodeMessage    = 0x2
+	pipeUnlimitedInstances = 255
+	errorPipeConnected     = syscall.Errno(535)
+	errorMoreData          = syscall.Errno(234)
+)
+
+// accepts connections on logview pipe, like tools/logview
+func listenLogPipe(srv *LogServer) error {
+	name, err := syscall.UTF16PtrFromString(logPipeName)
+	if err != nil {
+		return err
+	}
+	logf("listening on pipe %s\n", logPipeName)
+	for {
+		r, _, e := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(name)), pipeAccessInbound, pipeTypeMessage|pipeReadmodeMessage, pipeUnlimitedInstances, logPipeBufSize, logPipeBufSize, 0, 0)
+		h := syscall.Handle(r)
+		if h == syscall.InvalidHandle {
+			return e
+		}
+		// blocks until a client connects
+		ok, _, e := procConnectNamedPipe.Call(uintptr(h), 0)
+		if ok == 0 && e != errorPipeConnected {
+			syscall.CloseHandle(h)
+			continue
+		}
+		go func() {
+			defer func() {
+				procDisconnectNamedPipe.Call(uintptr(h))
+				syscall.CloseHandle(h)
+			}()
+			buf := make([]byte, logPipeBufSize)
+			srv.handleConn("pipe", func() (string, error) {
+				var n uint32
+				err := syscall.ReadFile(h, buf, &n, nil)
+				// message bigger than buf, the rest comes in next read
+				if err != nil && err != errorMoreData {
+					return "", err
+				}
+				return string(buf[:n]), nil
+			})
+		}()
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 var (
 	flgSkipSign       bool
+	flgStripPdbs      bool
 	r2Access          string
 	r2Secret          string
 	b2Access          string
@@ -44,12 +45,64 @@ func loadSecrets() bool {
 		// logf("Got %s, '%s'\n", key, v)
 		logf("Got %s\n", key)
 	}
+	// secrets for optional features. Code that needs them says when they
+	// are missing so we only complain about bad values
+	getOptionalEnv := func(key string, val *string, minLen int) {
+		v := strings.TrimSpace(m[key])
+		if v == "" {
+			return
+		}
+		if len(v) < minLen {
+			logf("Invalid %s, len: %d, wanted: %d\n", key, len(v), minLen)
+			return
+		}
+		*val = v
+	}
 	getEnv("R2_ACCESS", &r2Access, 8)
 	getEnv("R2_SECRET", &r2Secret, 8)
 	getEnv("BB_ACCESS", &b2Access, 8)
 	getEnv("BB_SECRET", &b2Secret, 8)
 	getEnv("TRANS_UPLOAD_SECRET", &transUploadSecret, 4)
 	getEnv("CERT_PWD", &certPwd, 4)
+	getOptionalEnv("BUILD_NOTIFY_WEBHOOK", &buildNotifyWebhook, 8)
+	getOptionalEnv("SIGN_BACKEND", &signBackend, 4)
+	getOptionalEnv("SIGN_TIMESTAMP_SERVERS", &signTimestampServers, 8)
+	getOptionalEnv("SIGN_EXPECTED_SIGNER", &expectedSignerName, 2)
+	getOptionalEnv("AZURE_SIGN_ENDPOINT", &azureSignEndpoint, 8)
+	getOptionalEnv("AZURE_SIGN_ACCOUNT", &azureSignAccount, 2)
+	getOptionalEnv("AZURE_SIGN_PROFILE", &azureSignProfile, 2)
+	getOptionalEnv("AZURE_SIGN_DLIB", &azureSignDlib, 4)
+	getOptionalEnv("AZURE_TENANT_ID", &azureTenantID, 8)
+	getOptionalEnv("AZURE_CLIENT_ID", &azureClientID, 8)
+	getOptionalEnv("AZURE_CLIENT_SECRET", &azureClientSecret, 8)
+	getOptionalEnv("MSIX_IDENTITY_NAME", &msixIdentityName, 4)
+	getOptionalEnv("MSIX_PUBLISHER", &msixPublisher, 4)
+	getOptionalEnv("GITHUB_PUBLISH_TOKEN", &githubPublishToken, 8)
+	getOptionalEnv("CHOCOLATEY_API_KEY", &chocolateyAPIKey, 8)
+	getOptionalEnv("UPDATE_SIGNING_KEY", &updateSigningKey, 8)
+	getOptionalEnv("MINISIGN_SECRET_KEY", &minisignSecretKey, 8)
+	getOptionalEnv("GPG_KEY_ID", &gpgKeyID, 8)
+	getOptionalEnv("VIRUSTOTAL_API_KEY", &virusTotalAPIKey, 8)
+	getOptionalEnv("UPLOAD_MAX_KBPS", &uploadMaxKbps, 1)
+	getOptionalEnv("UPLOAD_WINDOW", &uploadWindow, 9)
+	getOptionalEnv("CLOUDFLARE_ZONE_ID", &cloudflareZoneID, 8)
+	getOptionalEnv("CLOUDFLARE_API_TOKEN", &cloudflareAPIToken, 8)
+	getOptionalEnv("TORRENT_TRACKERS", &torrentTrackers, 8)
+	getOptionalEnv("CRASH_STATS_TOKEN", &crashStatsToken, 8)
+	getOptionalEnv("GOOGLE_TRANSLATE_KEY", &googleTranslateKey, 8)
+	getOptionalEnv("TRANS_SERVICE", &transServiceName, 4)
+	getOptionalEnv("WEBLATE_URL", &weblateURL, 8)
+	getOptionalEnv("WEBLATE_TOKEN", &weblateToken, 8)
+	getOptionalEnv("WEBLATE_WEBHOOK_SECRET", &weblateWebhookSecret, 8)
+	getOptionalEnv("CRASH_SERVICE", &crashServiceName, 4)
+	getOptionalEnv("SENTRY_URL", &sentryURL, 8)
+	getOptionalEnv("SENTRY_ORG", &sentryOrg, 2)
+	getOptionalEnv("SENTRY_PROJECT", &sentryProject, 2)
+	getOptionalEnv("SENTRY_AUTH_TOKEN", &sentryAuthToken, 8)
+	getOptionalEnv("SENTRY_DSN", &sentryDSN, 8)
+	getOptionalEnv("BACKTRACE_UNIVERSE", &backtraceUniverse, 2)
+	getOptionalEnv("BACKTRACE_SYMBOL_TOKEN", &backtraceSymbolToken, 8)
+	getOptionalEnv("BACKTRACE_SUBMIT_TOKEN", &backtraceSubmitToken, 8)
 	return true
 }
 
@@ -70,6 +123,51 @@ func getSecrets() {
 	b2Secret = os.Getenv("BB_SECRET")
 	transUploadSecret = os.Getenv("TRANS_UPLOAD_SECRET")
 	certPwd = os.Getenv("CERT_PWD")
+	buildNotifyWebhook = os.Getenv("BUILD_NOTIFY_WEBHOOK")
+	signBackend = os.Getenv("SIGN_BACKEND")
+	signTimestampServers = os.Getenv("SIGN_TIMESTAMP_SERVERS")
+	if v := os.Getenv("SIGN_EXPECTED_SIGNER"); v != "" {
+		expectedSignerName = v
+	}
+	azureSignEndpoint = os.Getenv("AZURE_SIGN_ENDPOINT")
+	azureSignAccount = os.Getenv("AZURE_SIGN_ACCOUNT")
+	azureSignProfile = os.Getenv("AZURE_SIGN_PROFILE")
+	azureSignDlib = os.Getenv("AZURE_SIGN_DLIB")
+	azureTenantID = os.Getenv("AZURE_TENANT_ID")
+	azureClientID = os.Getenv("AZURE_CLIENT_ID")
+	azureClientSecret = os.Getenv("AZURE_CLIENT_SECRET")
+	if v := os.Getenv("MSIX_IDENTITY_NAME"); v != "" {
+		msixIdentityName = v
+	}
+	if v := os.Getenv("MSIX_PUBLISHER"); v != "" {
+		msixPublisher = v
+	}
+	githubPublishToken = os.Getenv("GITHUB_PUBLISH_TOKEN")
+	chocolateyAPIKey = os.Getenv("CHOCOLATEY_API_KEY")
+	updateSigningKey = os.Getenv("UPDATE_SIGNING_KEY")
+	minisignSecretKey = os.Getenv("MINISIGN_SECRET_KEY")
+	gpgKeyID = os.Getenv("GPG_KEY_ID")
+	virusTotalAPIKey = os.Getenv("VIRUSTOTAL_API_KEY")
+	uploadMaxKbps = os.Getenv("UPLOAD_MAX_KBPS")
+	uploadWindow = os.Getenv("UPLOAD_WINDOW")
+	cloudflareZoneID = os.Getenv("CLOUDFLARE_ZONE_ID")
+	cloudflareAPIToken = os.Getenv("CLOUDFLARE_API_TOKEN")
+	torrentTrackers = os.Getenv("TORRENT_TRACKERS")
+	crashStatsToken = os.Getenv("CRASH_STATS_TOKEN")
+	googleTranslateKey = os.Getenv("GOOGLE_TRANSLATE_KEY")
+	transServiceName = os.Getenv("TRANS_SERVICE")
+	weblateURL = os.Getenv("WEBLATE_URL")
+	weblateToken = os.Getenv("WEBLATE_TOKEN")
+	weblateWebhookSecret = os.Getenv("WEBLATE_WEBHOOK_SECRET")
+	crashServiceName = os.Getenv("CRASH_SERVICE")
+	sentryURL = os.Getenv("SENTRY_URL")
+	sentryOrg = os.Getenv("SENTRY_ORG")
+	sentryProject = os.Getenv("SENTRY_PROJECT")
+	sentryAuthToken = os.Getenv("SENTRY_AUTH_TOKEN")
+	sentryDSN = os.Getenv("SENTRY_DSN")
+	backtraceUniverse = os.Getenv("BACKTRACE_UNIVERSE")
+	backtraceSymbolToken = os.Getenv("BACKTRACE_SYMBOL_TOKEN")
+	backtraceSubmitToken = os.Getenv("BACKTRACE_SUBMIT_TOKEN")
 }
 
 func regenPremake() {
@@ -192,7 +290,8 @@ func ensureBuildOptionsPreRequesites(opts *BuildOptions) {
 	}
 
 	if opts.sign {
-		panicIf(!hasCertPwd(), "CERT_PWD env variable is not set")
+		signer := getSigner()
+		panicIf(!canSign(), "can't sign with '%s' backend: %s", signer.Name(), signer.Missing())
 	}
 	if opts.verifyTranslationUpToDate {
 		verifyTranslationsMust()
@@ -202,7 +301,8 @@ func ensureBuildOptionsPreRequesites(opts *BuildOptions) {
 	}
 	if opts.releaseBuild {
 		verifyOnReleaseBranchMust()
-		os.RemoveAll("out")
+		checkStringFreezeMust(getCurrentBranchMust("."))
+		checkTransGateMust(nil)
 	}
 
 	if !opts.sign {
@@ -230,34 +330,115 @@ func main() {
 	)
 
 	var (
-		flgRegenPremake    bool
-		flgUpload          bool
-		flgCIBuild         bool
-		flgCIDailyBuild    bool
-		flgUploadCiBuild   bool
-		flgBuildPreRelease bool
-		flgBuildRelease    bool
-		flgWc              bool
-		flgTransDownload   bool
-		flgClean           bool
-		flgCheckAccessKeys bool
-		flgTriggerCodeQL   bool
-		flgClangFormat     bool
-		flgDiff            bool
-		flgGenSettings     bool
-		flgUpdateVer       string
-		flgDrMem           bool
-		flgLogView         bool
-		flgRunTests        bool
-		flgSmoke           bool
-		flgFileUpload      string
-		flgFilesList       bool
-		flgExtractUtils    bool
-		flgBuildLogview    bool
-		flgBuildNo         int
-		flgUpdateGoDeps    bool
-		flgGenDocs         bool
-		flgGenWebsiteDocs  bool
+		flgRegenPremake       bool
+		flgUpload             bool
+		flgCIBuild            bool
+		flgCIDailyBuild       bool
+		flgUploadCiBuild      bool
+		flgBuildPreRelease    bool
+		flgBuildRelease       bool
+		flgWc                 bool
+		flgTransDownload      bool
+		flgClean              bool
+		flgCheckAccessKeys    bool
+		flgTriggerCodeQL      bool
+		flgClangFormat        bool
+		flgDiff               bool
+		flgGenSettings        bool
+		flgUpdateVer          string
+		flgDrMem              bool
+		flgLogView            bool
+		flgRunTests           bool
+		flgSmoke              bool
+		flgFileUpload         string
+		flgFilesList          bool
+		flgExtractUtils       bool
+		flgBuildLogview       bool
+		flgBuildNo            int
+		flgUpdateGoDeps       bool
+		flgGenDocs            bool
+		flgGenWebsiteDocs     bool
+		flgCheckMinOs         bool
+		flgSymbols            bool
+		flgPdbSizes           bool
+		flgUpdateMupdf        string
+		flgExtCheck           bool
+		flgExtUpdate          string
+		flgGen                bool
+		flgGenCheck           bool
+		flgVerifySigs         bool
+		flgPackageMsi         bool
+		flgPackageMsix        bool
+		flgWinget             bool
+		flgChocolatey         bool
+		flgScoop              bool
+		flgGenDeltaUpdates    bool
+		flgUploadUpdateMan    bool
+		flgGenUpdateKey       bool
+		flgGenReleaseNotes    bool
+		flgUpdateChangelog    bool
+		flgBumpVersion        bool
+		flgGithubRelease      bool
+		flgSyncMirrors        bool
+		flgWebsiteSha256      bool
+		flgGenMinisignKey     bool
+		flgGenSbom            bool
+		flgVirusTotal         bool
+		flgPromotePreRel      bool
+		flgRollbackRelease    bool
+		flgDownloadStats      bool
+		flgVerifyUploaded     bool
+		flgPurgeCdn           bool
+		flgGenProvenance      bool
+		flgVerifyProvenance   bool
+		flgInstallerTests     bool
+		flgReleaseState       bool
+		flgPrunePreRel        bool
+		flgGenDownloadPage    bool
+		flgGenWebsiteFeeds    bool
+		flgGenTorrents        bool
+		flgServeUpdateCheck   bool
+		flgTagRelease         bool
+		flgCrashRate          bool
+		flgTransUpload        bool
+		flgTransUnused        bool
+		flgTransMtFill        bool
+		flgTransPoExport      bool
+		flgTransPoImport      bool
+		flgTransWebhook       bool
+		flgTransPacks         bool
+		flgTransStringFreeze  bool
+		flgTransDups          bool
+		flgTransDiff          bool
+		flgTransAddLanguage   bool
+		flgGenTransReference  bool
+		flgTransPluralMigrate bool
+		flgTransGate          bool
+		flgSymbolicate        bool
+		flgCrashAggregate     bool
+		flgCrashDashboard     bool
+		flgAnalyzeDumps       bool
+		flgCrashTrend         bool
+		flgCrashFileIssues    bool
+		flgLogServer          bool
+		flgLogReplay          bool
+		flgTelemetry          bool
+		flgPerfTrace          bool
+		flgStartupBench       bool
+		flgMemBench           bool
+		flgRenderBench        bool
+		flgRenderBenchCompare bool
+		flgCorpusFetch        bool
+		flgCorpusVerify       bool
+		flgCorpusAdd          bool
+		flgRenderDiff         bool
+		flgFuzzTriage         bool
+		flgFuzzRegress        bool
+		flgCrashReplay        bool
+		flgStress             bool
+		flgGenWebsite         bool
+		flgDeployWebsite      bool
+		flgCrawlSite          bool
 	)
 
 	{
@@ -274,10 +455,11 @@ func main() {
 		flag.BoolVar(&flgUpload, "upload", false, "upload the build to s3 and do spaces")
 		flag.BoolVar(&flgClangFormat, "format", false, "format source files with clang-format")
 		flag.BoolVar(&flgWc, "wc", false, "show loc stats (like wc -l)")
-		flag.BoolVar(&flgTransDownload, "trans-dl", false, "download latest translations to translations/translations.txt")
+		flag.BoolVar(&flgTransDownload, "trans-download", false, "download latest translations, check them, re-generate translations-good.txt and show what changed")
+		flag.BoolVar(&flgTransDownload, "trans-dl", false, "same as -trans-download")
 		//flag.BoolVar(&flgGenTranslationsInfoCpp, "trans-gen-info", false, "generate src/TranslationLangs.cpp")
-		flag.BoolVar(&flgClean, "clean", false, "clean the build (remove out/ files except for settings)")
-		flag.BoolVar(&flgCheckAccessKeys, "check-access-keys", false, "check access keys for menu items")
+		flag.BoolVar(&flgClean, "clean", false, "clean generated files: -clean [out|docs|translations|all]. Default is out (remove out/ files except for settings)")
+		flag.BoolVar(&flgCheckAccessKeys, "check-access-keys", false, "show menu accelerator conflicts in translations (-check-access-keys [${lang}])")
 		//flag.BoolVar(&flgPrintBuildNo, "build-no", false, "print build number")
 		flag.BoolVar(&flgTriggerCodeQL, "trigger-codeql", false, "trigger codeql build")
 		flag.BoolVar(&flgCppCheck, "cppcheck", false, "run cppcheck (must be installed)")
@@ -287,7 +469,7 @@ func main() {
 		flag.BoolVar(&flgDiff, "diff", false, "preview diff using winmerge")
 		flag.BoolVar(&flgGenSettings, "gen-settings", false, "re-generate src/Settings.h")
 		flag.StringVar(&flgUpdateVer, "update-auto-update-ver", "", "update version used for auto-update checks")
-		flag.BoolVar(&flgDrMem, "drmem", false, "run drmemory of rel 64")
+		flag.BoolVar(&flgDrMem, "drmem", false, "check rel 64 for memory leaks with Dr.Memory (-drmem [${file}...])")
 		flag.BoolVar(&flgLogView, "logview", false, "run logview")
 		flag.BoolVar(&flgRunTests, "run-tests", false, "run test_util executable")
 		flag.BoolVar(&flgExtractUtils, "extract-utils", false, "extract utils")
@@ -295,9 +477,106 @@ func main() {
 		flag.IntVar(&flgBuildNo, "build-no-info", 0, "print build number info for given build number")
 		flag.BoolVar(&flgUpdateGoDeps, "update-go-deps", false, "update go dependencies")
 		flag.BoolVar(&flgGenDocs, "gen-docs", false, "generate html docs in docs/www from markdown in docs/md")
-		flag.BoolVar(&flgGenWebsiteDocs, "gen-website-docs", false, "generate html docs in ../sumatra-website repo and check them in")
+		flag.BoolVar(&flgGenWebsiteDocs, "gen-website-docs", false, "same as -gen-website")
+		flag.BoolVar(&flgCheckMinOs, "check-min-os", false, "check that binaries in out/ run on minimum supported Windows version")
+		flag.BoolVar(&flgSymbols, "symbols", false, "create symbols package from pre-release build in out/. Use -upload to also upload to symbol server")
+		flag.BoolVar(&flgStripPdbs, "strip-pdbs", false, "ship public-only (stripped) .pdb files in SumatraPDF.pdb.zip and .lzsa")
+		flag.BoolVar(&flgPdbSizes, "pdb-sizes", false, "create stripped .pdb files for builds in out/ and show size comparison")
+		flag.StringVar(&flgUpdateMupdf, "update-mupdf", "", "update vendored mupdf to a given upstream tag, re-apply patches from ext/_patches and run regression tests")
+		flag.BoolVar(&flgExtCheck, "ext-check", false, "check for newer upstream versions of libraries in ext/")
+		flag.StringVar(&flgExtUpdate, "ext-update", "", "update library in ext/ to latest (or given with lib@tag) upstream version, re-apply patches from ext/_patches and show size / warnings delta")
+		flag.BoolVar(&flgGen, "gen", false, "run code generators with changed inputs: -gen [name...|all]")
+		flag.BoolVar(&flgGenCheck, "gen-check", false, "re-run all code generators and check that generated files in git are up to date")
+		flag.BoolVar(&flgVerifySigs, "verify-signatures", false, "verify signatures, timestamps and versions of binaries in pre-release build in out/")
+		flag.BoolVar(&flgPackageMsi, "package-msi", false, "create .msi installers from pre-release build in out/ (-package-msi rel for release build)")
+		flag.BoolVar(&flgPackageMsix, "package-msix", false, "create .msix packages for Microsoft Store from pre-release build in out/ (-package-msix rel for release build)")
+		flag.BoolVar(&flgWinget, "winget", false, "generate and validate winget manifests for release build in out/ (-winget submit to also open a PR in microsoft/winget-pkgs)")
+		flag.BoolVar(&flgChocolatey, "chocolatey", false, "create chocolatey package for release build in out/ (-chocolatey push to also push to community feed)")
+		flag.BoolVar(&flgScoop, "scoop", false, "update scoop manifest for release build in out/ (-scoop submit to also open a PR in ScoopInstaller/Extras)")
+		flag.BoolVar(&flgGenDeltaUpdates, "gen-delta-updates", false, "create binary patches from previous pre-release (or -gen-delta-updates rel) builds to the build in out/")
+		flag.BoolVar(&flgUploadUpdateMan, "upload-update-manifest", false, "update and re-sign pre-release (or -upload-update-manifest rel) section of update-check.json in storage")
+		flag.BoolVar(&flgGenUpdateKey, "gen-update-signing-key", false, "generate a new key for signing update-check.json")
+		flag.BoolVar(&flgGenReleaseNotes, "gen-release-notes", false, "create draft of release notes from git history e.g. -gen-release-notes 3.6")
+		flag.BoolVar(&flgUpdateChangelog, "update-changelog", false, "put finalized release notes in Version-history.md, releasenotes.txt and GitHub release body e.g. -update-changelog 3.6")
+		flag.BoolVar(&flgBumpVersion, "bump-version", false, "change version of the program e.g. -bump-version 3.7")
+		flag.BoolVar(&flgGithubRelease, "github-release", false, "create GitHub release and upload files of release build in out/")
+		flag.BoolVar(&flgCrawlSite, "crawl-site", false, "check that links, images and assets on deployed website return 200 and there's no mixed content (-crawl-site ${url})")
+		flag.BoolVar(&flgDeployWebsite, "deploy-website", false, "generate website and deploy it by checking it in to ../sumatra-website repo")
+		flag.BoolVar(&flgGenWebsite, "gen-website", false, "generate website (home, download page, docs, news, feeds) in out/website from website/ and docs/md")
+		flag.BoolVar(&flgStress, "stress", false, "run stress test in many instances and check for handle, GDI and memory leaks (-stress [${dir}|corpus[:${category}]] [instances=${n}] [cycles=${n}])")
+		flag.BoolVar(&flgCrashReplay, "crash-replay", false, "replay documents that crashed in the past under debugger and save dumps of crashes (-crash-replay [${dir}|corpus[:${category}]])")
+		flag.BoolVar(&flgFuzzRegress, "fuzz-regress", false, "check that known-bad documents of the corpus no longer crash or hang")
+		flag.BoolVar(&flgFuzzTriage, "fuzz-triage", false, "reproduce, minimize and bucket crashing inputs by signature (-fuzz-triage ${dir})")
+		flag.BoolVar(&flgRenderDiff, "render-diff", false, "render pages with two builds of enginedump and show differences (-render-diff ${before} ${after} [${dir}|corpus[:${category}]])")
+		flag.BoolVar(&flgCorpusAdd, "corpus-add", false, "add a document to test corpus (-corpus-add ${file} [${category}] [bad] [issue=${n}])")
+		flag.BoolVar(&flgCorpusVerify, "corpus-verify", false, "verify hashes of cached test corpus documents")
+		flag.BoolVar(&flgCorpusFetch, "corpus-fetch", false, "download missing documents of test corpus (-corpus-fetch [${category}|bad])")
+		flag.BoolVar(&flgRenderBenchCompare, "render-bench-compare", false, "compare render times of two builds (-render-bench-compare ${before.json} ${after.json})")
+		flag.BoolVar(&flgRenderBench, "render-bench", false, "record load and per-page render times of documents with enginedump (-render-bench ${dir}|corpus[:${category}])")
+		flag.BoolVar(&flgMemBench, "mem-bench", false, "record peak memory of opening documents in a corpus and compare with previous build (-mem-bench ${dir}|corpus[:${category}])")
+		flag.BoolVar(&flgStartupBench, "startup-bench", false, "measure time to first paint of cold and warm launches and compare with baseline (-startup-bench [baseline] [${file}])")
+		flag.BoolVar(&flgPerfTrace, "perf-trace", false, "record ETW trace of a scenario and report CPU and IO hotspots (-perf-trace ${scenario} ${file} [${search term}])")
+		flag.BoolVar(&flgTelemetry, "telemetry", false, "aggregate opt-in usage pings into daily rollups and a report (-telemetry [${pings-dir}])")
+		flag.BoolVar(&flgLogReplay, "log-replay", false, "print logs recorded by -log-server (-log-replay ${file} [${instance}])")
+		flag.BoolVar(&flgLogServer, "log-server", false, "collect logs from many SumatraPDF instances and record them to out/log-sessions (-log-server [tcp=${addr}])")
+		flag.BoolVar(&flgCrashFileIssues, "crash-file-issues", false, "file GitHub issues for frequent crash signatures in crash reports in a directory (-crash-file-issues ${dir})")
+		flag.BoolVar(&flgCrashTrend, "crash-trend", false, "compare crash signatures of two versions (-crash-trend ${base} ${candidate} [${dir}])")
+		flag.BoolVar(&flgAnalyzeDumps, "analyze-dumps", false, "run cdb !analyze -v on minidumps in a directory and aggregate them with crash reports (-analyze-dumps ${dir})")
+		flag.BoolVar(&flgCrashDashboard, "crash-dashboard", false, "write crash dashboard (crashes.html) from crash reports in a directory to ../sumatra-website (-crash-dashboard ${dir})")
+		flag.BoolVar(&flgCrashAggregate, "crash-aggregate", false, "group crash reports in a directory by signature and show counts per build (-crash-aggregate ${dir})")
+		flag.BoolVar(&flgSymbolicate, "symbolicate", false, "resolve addresses in crash reports / minidumps with symbols of the build (-symbolicate ${file.dmp | crash.txt | dir})")
+		flag.BoolVar(&flgTransGate, "trans-gate", false, "fail if any of top languages in translations/trans-gate.txt has too few strings translated (-trans-gate [top=${n}] [min=${percent}])")
+		flag.BoolVar(&flgTransPluralMigrate, "trans-plural-migrate", false, "move translations of a string changed to _TRP() to the new plural string (-trans-plural-migrate \"${old plural}\" [\"${old singular}\"])")
+		flag.BoolVar(&flgGenTransReference, "gen-trans-reference", false, "write reference of strings for translators (translators-reference.html) in ../sumatra-website")
+		flag.BoolVar(&flgTransAddLanguage, "trans-add-language", false, "add a new language to translate to (-trans-add-language ${code} \"${name}\" ${langid} [rtl] [iso=${bcp47}])")
+		flag.BoolVar(&flgTransDiff, "trans-diff", false, "compare embedded translations of 2 releases (-trans-diff ${ref1} ${ref2} [${lang}])")
+		flag.BoolVar(&flgTransDups, "trans-dups", false, "show strings to translate that only differ by case, trailing punctuation or whitespace")
+		flag.BoolVar(&flgTransStringFreeze, "trans-string-freeze", false, "fail if strings to translate were added after string freeze of release branch (-trans-string-freeze [${branch}])")
+		flag.BoolVar(&flgTransPacks, "trans-packs", false, "split translations into per-language packs in out/langpacks (-trans-packs [upload])")
+		flag.BoolVar(&flgTransWebhook, "trans-webhook", false, "run server that pulls translations when weblate calls its webhook")
+		flag.BoolVar(&flgTransPoImport, "trans-po-import", false, "import translations from gettext .po files (-trans-po-import ${file.po} ...)")
+		flag.BoolVar(&flgTransPoExport, "trans-po-export", false, "export translations as gettext .pot and .po files to out/translations-po")
+		flag.BoolVar(&flgTransMtFill, "trans-mt-fill", false, "fill untranslated strings of languages with machine translations (-trans-mt-fill ${lang1},${lang2})")
+		flag.BoolVar(&flgTransUnused, "trans-unused", false, "show translated strings that are no longer used in src/")
+		flag.BoolVar(&flgTransUpload, "trans-upload", false, "upload new and remove obsolete strings to translate on apptranslator.org")
+		flag.BoolVar(&flgCrashRate, "crash-rate", false, "compare crash rate of pre-release build with latest release (-crash-rate ${build})")
+		flag.BoolVar(&flgTagRelease, "tag-release", false, "create, verify and push signed tag ${ver}rel with release notes for release build in out/final-rel")
+		flag.BoolVar(&flgServeUpdateCheck, "serve-update-check", false, "serve update info and files of build in out/ locally to test auto-update (-serve-update-check [rel] [ver])")
+		flag.BoolVar(&flgGenTorrents, "gen-torrents", false, "create .torrent files with web seeds for build in out/ (-gen-torrents [rel])")
+		flag.BoolVar(&flgGenWebsiteFeeds, "gen-website-feeds", false, "write releases feed (releases.xml) and versions.json in ../sumatra-website")
+		flag.BoolVar(&flgGenDownloadPage, "gen-download-page", false, "write download page in ../sumatra-website from artifacts.json of release build in out/")
+		flag.BoolVar(&flgPrunePreRel, "prune-prerel", false, "delete old pre-release builds from storage, keeping the last 5 and one per week (add -dry-run to only show what would be deleted)")
+		flag.BoolVar(&flgReleaseState, "release-state", false, "print steps of the release saved in out/release-state.json")
+		flag.BoolVar(&flgInstallerTests, "installer-tests", false, "install, upgrade and uninstall uploaded build in throwaway VM (-installer-tests [rel] [ver] [prev-ver])")
+		flag.BoolVar(&flgVerifyProvenance, "verify-provenance", false, "verify signature of provenance and hashes of files (-verify-provenance ${file} [dir])")
+		flag.BoolVar(&flgGenProvenance, "gen-provenance", false, "write signed SLSA provenance of the build in out/ (-gen-provenance [rel])")
+		flag.BoolVar(&flgPurgeCdn, "purge-cdn", false, "purge CDN cache of website pages and latest files (-purge-cdn [rel])")
+		flag.BoolVar(&flgVerifyUploaded, "verify-uploaded", false, "download uploaded build, verify hashes and signatures, install and launch it (-verify-uploaded [rel] [ver])")
+		flag.BoolVar(&flgDownloadStats, "download-stats", false, "generate dashboard of downloads from GitHub and access logs (-download-stats ${logs-dir})")
+		flag.BoolVar(&flgRollbackRelease, "rollback-release", false, "make previous release (or -rollback-release ${ver}) the latest again")
+		flag.BoolVar(&flgPromotePreRel, "promote-prerel", false, "build and upload release from the commit of already uploaded and tested pre-release build (-promote-prerel ${build})")
+		flag.BoolVar(&flgVirusTotal, "virustotal", false, "scan installers of pre-release build in out/ with VirusTotal (-virustotal rel for release build)")
+		flag.BoolVar(&flgGenSbom, "gen-sbom", false, "generate SBOM (CycloneDX) for pre-release build in out/ (-gen-sbom rel for release build)")
+		flag.BoolVar(&flgGenMinisignKey, "gen-minisign-key", false, "generate minisign key for detached signatures of release files")
+		flag.BoolVar(&flgWebsiteSha256, "update-website-sha256sums", false, "write download page and sha256 of release build files in ../sumatra-website")
+		flag.BoolVar(&flgSyncMirrors, "sync-mirrors", false, "make sure all storages have the same files of a build ([rel|prerel] [ver])")
+		flag.BoolVar(&ignoreCrashRate, "ignore-crash-rate", false, "with -promote-prerel: promote even if crash rate regressed or can't be checked")
+		flag.BoolVar(&dryRun, "dry-run", false, "log what would be run, written, uploaded or sent instead of doing it (can be added to any command)")
 		flag.Parse()
 	}
+	if dryRun {
+		logf("dry-run: commands, file writes, uploads and API calls are only logged\n")
+	}
+
+	if flgGen {
+		runGenerators(flag.Args())
+		return
+	}
+
+	if flgGenCheck {
+		checkGeneratedFiles()
+		return
+	}
 
 	if flgGenDocs {
 		genHTMLDocsForApp()
@@ -305,7 +584,7 @@ func main() {
 	}
 
 	if flgGenWebsiteDocs {
-		genHTMLDocsForWebsite()
+		genWebsiteMust()
 		return
 	}
 
@@ -421,7 +700,7 @@ func main() {
 	}
 
 	if flgClean {
-		cleanPreserveSettings()
+		cleanScope(flag.Arg(0))
 		return
 	}
 
@@ -445,8 +724,461 @@ func main() {
 		return
 	}
 
+	if flgUpdateMupdf != "" {
+		updateMupdf(flgUpdateMupdf)
+		return
+	}
+
+	if flgExtCheck {
+		extCheck()
+		return
+	}
+
+	if flgExtUpdate != "" {
+		extUpdate(flgExtUpdate)
+		return
+	}
+
+	if flgPdbSizes {
+		pdbSizesReport()
+		return
+	}
+
+	if flgSymbols {
+		buildAndUploadSymbols(buildTypePreRel, flgUpload)
+		return
+	}
+
+	if flgPackageMsi {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		packageMsiMust(buildType)
+		return
+	}
+
+	if flgPackageMsix {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		packageMsixMust(buildType)
+		return
+	}
+
+	if flgWinget {
+		wingetMust(flag.Arg(0) == "submit")
+		return
+	}
+
+	if flgChocolatey {
+		chocolateyMust(flag.Arg(0) == "push")
+		return
+	}
+
+	if flgScoop {
+		scoopMust(flag.Arg(0) == "submit")
+		return
+	}
+
+	if flgGenDeltaUpdates {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		genDeltaUpdatesMust(buildType)
+		return
+	}
+
+	if flgUploadUpdateMan {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		uploadUpdateManifestAllMust(buildType)
+		return
+	}
+
+	if flgGenUpdateKey {
+		genUpdateSigningKey()
+		return
+	}
+
+	if flgGenReleaseNotes {
+		genReleaseNotesMust(flag.Arg(0))
+		return
+	}
+
+	if flgUpdateChangelog {
+		updateChangelogMust(flag.Arg(0), flag.Arg(1))
+		return
+	}
+
+	if flgBumpVersion {
+		bumpVersionMust(flag.Arg(0))
+		return
+	}
+
+	if flgGithubRelease {
+		githubReleaseMust()
+		return
+	}
+
+	if flgSyncMirrors {
+		buildType := buildTypeRel
+		if flag.Arg(0) == "prerel" {
+			buildType = buildTypePreRel
+		}
+		syncMirrorsMust(buildType, flag.Arg(1))
+		return
+	}
+
+	if flgWebsiteSha256 {
+		updateWebsiteSha256SumsMust()
+		return
+	}
+
+	if flgCrawlSite {
+		crawlSiteMust(flag.Arg(0))
+		return
+	}
+
+	if flgDeployWebsite {
+		deployWebsiteMust()
+		return
+	}
+
+	if flgGenWebsite {
+		genWebsiteMust()
+		return
+	}
+
+	if flgStress {
+		stressMust(flag.Args())
+		return
+	}
+
+	if flgCrashReplay {
+		crashReplayMust(flag.Arg(0))
+		return
+	}
+
+	if flgFuzzRegress {
+		fuzzRegressMust()
+		return
+	}
+
+	if flgFuzzTriage {
+		fuzzTriageMust(flag.Arg(0))
+		return
+	}
+
+	if flgRenderDiff {
+		renderDiffMust(flag.Args())
+		return
+	}
+
+	if flgCorpusAdd {
+		corpusAddMust(flag.Args())
+		return
+	}
+
+	if flgCorpusVerify {
+		corpusVerifyMust()
+		return
+	}
+
+	if flgCorpusFetch {
+		corpusFetchMust(flag.Arg(0))
+		return
+	}
+
+	if flgRenderBenchCompare {
+		renderBenchCompareMust(flag.Args())
+		return
+	}
+
+	if flgRenderBench {
+		renderBenchMust(flag.Arg(0))
+		return
+	}
+
+	if flgMemBench {
+		memBenchMust(flag.Arg(0))
+		return
+	}
+
+	if flgStartupBench {
+		startupBenchMust(flag.Args())
+		return
+	}
+
+	if flgPerfTrace {
+		perfTraceMust(flag.Args())
+		return
+	}
+
+	if flgTelemetry {
+		telemetryMust(flag.Arg(0))
+		return
+	}
+
+	if flgLogReplay {
+		logReplayMust(flag.Args())
+		return
+	}
+
+	if flgLogServer {
+		logServerMust(flag.Args())
+		return
+	}
+
+	if flgCrashFileIssues {
+		fileCrashIssuesMust(flag.Arg(0))
+		return
+	}
+
+	if flgCrashTrend {
+		crashTrendMust(flag.Args())
+		return
+	}
+
+	if flgAnalyzeDumps {
+		analyzeDumpsMust(flag.Arg(0))
+		return
+	}
+
+	if flgCrashDashboard {
+		genCrashDashboardMust(flag.Arg(0))
+		return
+	}
+
+	if flgCrashAggregate {
+		crashAggregateMust(flag.Arg(0))
+		return
+	}
+
+	if flgSymbolicate {
+		symbolicateMust(flag.Arg(0))
+		return
+	}
+
+	if flgTransGate {
+		checkTransGateMust(flag.Args())
+		return
+	}
+
+	if flgTransPluralMigrate {
+		transPluralMigrateMust(flag.Args())
+		return
+	}
+
+	if flgGenTransReference {
+		genTransReferenceMust()
+		return
+	}
+
+	if flgTransAddLanguage {
+		transAddLanguageMust(flag.Args())
+		return
+	}
+
+	if flgTransDiff {
+		transDiffMust(flag.Args())
+		return
+	}
+
+	if flgTransDups {
+		printTransDups()
+		return
+	}
+
+	if flgTransStringFreeze {
+		transStringFreezeMust(flag.Arg(0))
+		return
+	}
+
+	if flgTransPacks {
+		transPacksMust(flag.Arg(0) == "upload")
+		return
+	}
+
+	if flgTransWebhook {
+		transWebhookServerMust()
+		return
+	}
+
+	if flgTransPoImport {
+		transPoImportMust(flag.Args())
+		return
+	}
+
+	if flgTransPoExport {
+		transPoExportMust()
+		return
+	}
+
+	if flgTransMtFill {
+		transMtFillMust(flag.Arg(0))
+		return
+	}
+
+	if flgTransUnused {
+		printUnusedTranslations()
+		return
+	}
+
+	if flgTransUpload {
+		uploadTranslationsMust()
+		return
+	}
+
+	if flgCrashRate {
+		crashRateMust(flag.Arg(0))
+		return
+	}
+
+	if flgTagRelease {
+		tagReleaseBuildMust()
+		return
+	}
+
+	if flgServeUpdateCheck {
+		buildType := buildTypePreRel
+		args := flag.Args()
+		if len(args) > 0 && args[0] == "rel" {
+			buildType = buildTypeRel
+			args = args[1:]
+		}
+		ver := ""
+		if len(args) > 0 {
+			ver = args[0]
+		}
+		serveUpdateCheckMust(buildType, ver)
+		return
+	}
+
+	if flgGenTorrents {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		genTorrentsMust(buildType)
+		return
+	}
+
+	if flgGenWebsiteFeeds {
+		genWebsiteFeedsMust()
+		return
+	}
+
+	if flgGenDownloadPage {
+		genDownloadPageMust()
+		return
+	}
+
+	if flgPrunePreRel {
+		prunePreRelBuildsInAllStoragesMust()
+		return
+	}
+
+	if flgReleaseState {
+		printReleaseState()
+		return
+	}
+
+	if flgInstallerTests {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		installerTestsMust(buildType, flag.Arg(1), flag.Arg(2))
+		return
+	}
+
+	if flgVerifyProvenance {
+		verifyProvenanceMust(flag.Arg(0), flag.Arg(1))
+		return
+	}
+
+	if flgGenProvenance {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		genProvenanceMust(buildType)
+		return
+	}
+
+	if flgPurgeCdn {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		purgeCdnCache(buildType)
+		return
+	}
+
+	if flgVerifyUploaded {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		verifyUploadedMust(buildType, flag.Arg(1))
+		return
+	}
+
+	if flgDownloadStats {
+		downloadStatsMust(flag.Arg(0))
+		return
+	}
+
+	if flgRollbackRelease {
+		rollbackReleaseMust(flag.Arg(0))
+		return
+	}
+
+	if flgPromotePreRel {
+		promotePreRelMust(flag.Arg(0))
+		return
+	}
+
+	if flgVirusTotal {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		scanWithVirusTotalMust(buildType)
+		return
+	}
+
+	if flgGenSbom {
+		buildType := buildTypePreRel
+		if flag.Arg(0) == "rel" {
+			buildType = buildTypeRel
+		}
+		genSbomMust(buildType)
+		return
+	}
+
+	if flgGenMinisignKey {
+		genMinisignKey()
+		return
+	}
+
+	if flgVerifySigs {
+		verifySignaturesMust(buildTypePreRel)
+		return
+	}
+
+	if flgCheckMinOs {
+		checkMinOsAll()
+		return
+	}
+
 	if flgCheckAccessKeys {
-		checkAccessKeys()
+		checkAccessKeys(flag.Arg(0))
 		return
 	}
 
@@ -475,12 +1207,14 @@ func main() {
 	}
 
 	if flgCIDailyBuild {
-		buildCiDaily(opts)
-		if opts.upload {
-			uploadToStorage(buildTypePreRel)
-		} else {
-			logf("uploadToStorage: skipping because opts.upload = false\n")
-		}
+		runWithBuildNotifications("daily pre-release build", buildTypePreRel, func() {
+			buildCiDaily(opts)
+			if opts.upload {
+				uploadToStorage(buildTypePreRel)
+			} else {
+				logf("uploadToStorage: skipping because opts.upload = false\n")
+			}
+		})
 		return
 	}
 
@@ -503,26 +1237,25 @@ func main() {
 	}
 
 	if flgBuildRelease {
-		genHTMLDocsForApp()
-		buildRelease()
-		if opts.upload {
-			uploadToStorage(buildTypeRel)
-		} else {
-			logf("uploadToStorage: skipping because opts.upload = false\n")
-		}
+		runWithBuildNotifications("release build", buildTypeRel, func() {
+			runReleaseStepsMust(getReleaseSteps(opts.upload))
+		})
 		return
 	}
 
 	// this one is typically for me to build locally, so build all projects
 	if flgBuildPreRelease {
-		cleanReleaseBuilds()
-		genHTMLDocsForApp()
-		buildPreRelease(kPlatformIntel64, true)
-		if opts.upload {
-			uploadToStorage(buildTypePreRel)
-		} else {
-			logf("uploadToStorage: skipping because opts.upload = false\n")
-		}
+		runWithBuildNotifications("pre-release build", buildTypePreRel, func() {
+			cleanReleaseBuilds()
+			genHTMLDocsForApp()
+			buildPreRelease(kPlatformIntel64, true)
+			scanWithVirusTotalMust(buildTypePreRel)
+			if opts.upload {
+				uploadToStorage(buildTypePreRel)
+			} else {
+				logf("uploadToStorage: skipping because opts.upload = false\n")
+			}
+		})
 		return
 	}
 
@@ -533,10 +1266,7 @@ func main() {
 	}
 
 	if flgDrMem {
-		buildJustPortableExe(rel64Dir, "Release", kPlatformIntel64)
-		//cmd := exec.Command("drmemory.exe", "-light", "-check_leaks", "-possible_leaks", "-count_leaks", "-suppress", "drmem-sup.txt", "--", ".\\out\\rel64\\SumatraPDF.exe")
-		cmd := exec.Command("drmemory.exe", "-leaks_only", "-suppress", "drmem-sup.txt", "--", ".\\out\\rel64\\SumatraPDF.exe")
-		runCmdLoggedMust(cmd)
+		drMemMust(flag.Args())
 		return
 	}
 
@@ -548,9 +1278,7 @@ func main() {
 	if flgRunTests {
 		buildTestUtil()
 		dir := filepath.Join("out", "rel64")
-		cmd := exec.Command(".\\test_util.exe")
-		cmd.Dir = dir
-		runCmdLoggedMust(cmd)
+		runTestUtilMust(dir)
 		return
 	}
 
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -mem-bench ${dir} opens every document in ${dir} with release 64-bit
+// SumatraPDF.exe -bench (which loads it, renders all pages and exits) and
+// records peak working set and peak commit of the process.
+//
+// Results are saved in out/mem-bench/${time}-${sha}.json and compared with
+// the previous results, to catch memory regressions in format parsers
+// before release. We fail if peak memory of a document grew by more than
+// memBenchMaxRegression and memBenchMinRegression.
+
+const (
+	memBenchMaxRegression = 1.2
+	memBenchMinRegression = 8 * 1024 * 1024
+	memBenchTimeout       = 3 * time.Minute
+)
+
+var memBenchDir = filepath.Join("out", "mem-bench")
+
+var memBenchExts = []string{".pdf", ".xps", ".oxps", ".djvu", ".epub", ".mobi", ".fb2", ".cbz", ".cbr", ".cb7", ".cbt", ".chm", ".png", ".jpg", ".tif"}
+
+// MemBenchDoc is peak memory of SumatraPDF.exe that opened a document
+type MemBenchDoc struct {
+	// relative to corpus directory
+	Path           string `json:"path"`
+	PeakWorkingSet int64  `json:"peakWorkingSet"`
+	PeakCommit     int64  `json:"peakCommit"`
+	Error          string `json:"error,omitempty"`
+}
+
+// MemBenchResults is the result of -mem-bench
+type MemBenchResults struct {
+	Time   time.Time      `json:"time"`
+	GitSha string         `json:"gitSha"`
+	Docs   []*MemBenchDoc `json:"docs"`
+}
+
+func collectMemBenchDocsMust(dir string) []string {
+	var res []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if stringInSlice(memBenchExts, strings.ToLower(filepath.Ext(path))) {
+			res = append(res, path)
+		}
+		return nil
+	})
+	must(err)
+	sort.Strings(res)
+	return res
+}
+
+// returns results saved before, nil if there are none
+func readPrevMemBenchResults() *MemBenchResults {
+	paths, _ := filepath.Glob(filepath.Join(memBenchDir, "*.json"))
+	if len(paths) == 0 {
+		return nil
+	}
+	// names start with time so the last is the most recent
+	sort.Strings(paths)
+	var res MemBenchResults
+	must(json.Unmarshal(readFileMust(paths[len(paths)-1]), &res))
+	return &res
+}
+
+func isMemBenchRegression(prev, curr int64) bool {
+	return float64(curr) > float64(prev)*memBenchMaxRegression && curr-prev >= memBenchMinRegression
+}
+
+// returns descriptions of documents whose peak memory regressed
+func checkMemBenchRegressions(prev, res *MemBenchResults) []string {
+	prevDocs := map[string]*MemBenchDoc{}
+	for _, d := range prev.Docs {
+		prevDocs[d.Path] = d
+	}
+	var problems []string
+	for _, d := range res.Docs {
+		p := prevDocs[d.Path]
+		if p == nil || p.Error != "" || d.Error != "" {
+			continue
+		}
+		if isMemBenchRegression(p.PeakWorkingSet, d.PeakWorkingSet) {
+			problems = append(problems, fmt.Sprintf("%s: peak working set %s, was %s", d.Path, formatSize(d.PeakWorkingSet), formatSize(p.PeakWorkingSet)))
+		}
+		if isMemBenchRegression(p.PeakCommit, d.PeakCommit) {
+			problems = append(problems, fmt.Sprintf("%s: peak commit %s, was %s", d.Path, formatSize(d.PeakCommit), formatSize(p.PeakCommit)))
+		}
+	}
+	return problems
+}
+
+// -mem-bench ${dir}|corpus[:${category}]
+func memBenchMust(dir string) {
+	panicIf(runtime.GOOS != "windows", "-mem-bench only works on Windows")
+	dir = getCorpusDirMust(dir)
+	panicIf(dir == "" || !dirExists(dir), "usage: -mem-bench ${dir with documents}|corpus[:${category}]")
+	paths := collectMemBenchDocsMust(dir)
+	panicIf(len(paths) == 0, "no documents in '%s'", dir)
+	exe := getRel64ExeMust()
+	prev := readPrevMemBenchResults()
+
+	res := &MemBenchResults{
+		Time:   time.Now(),
+		GitSha: getGitSha1(),
+	}
+	appDataDir := filepath.Join(memBenchDir, "appdata")
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		must(err)
+		must(os.RemoveAll(appDataDir))
+		must(os.MkdirAll(appDataDir, 0755))
+		doc := &MemBenchDoc{Path: filepath.ToSlash(rel)}
+		doc.PeakWorkingSet, doc.PeakCommit, err = runAndGetPeakMemory(memBenchTimeout, exe, "-appdata", appDataDir, "-bench", path)
+		if err != nil {
+			doc.Error = err.Error()
+			logf("%s: %s\n", doc.Path, err)
+		} else {
+			logf("%s: peak working set: %s, peak commit: %s\n", doc.Path, formatSize(doc.PeakWorkingSet), formatSize(doc.PeakCommit))
+		}
+		push(&res.Docs, doc)
+	}
+	must(os.RemoveAll(appDataDir))
+
+	d, err := json.MarshalIndent(res, "", "  ")
+	must(err)
+	path := filepath.Join(memBenchDir, res.Time.Format("2006-01-02_15-04-05")+"-"+res.GitSha+".json")
+	writeFileMust(path, d)
+	logf("wrote '%s'\n", path)
+
+	if prev == nil {
+		logf("no previous results to compare with\n")
+		return
+	}
+	problems := checkMemBenchRegressions(prev, res)
+	panicIf(len(problems) > 0, "memory usage regressed compared to %s:\n%s", prev.GitSha, strings.Join(problems, "\n"))
+	logf("no memory regressions compared to %s\n", prev.GitSha)
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// peak memory of a process is only available on Windows
+func runAndGetPeakMemory(timeout time.Duration, exe string, args ...string) (int64, int64, error) {
+	return 0, 0, errors.New("peak memory is only available on Windows")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var procGetProcessMemoryInfo = modKernel32.NewProc("K32GetProcessMemoryInfo")
+
+const processQueryLimitedInformation = 0x1000
+
+// PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// runs exe and returns its peak working set and peak commit
+// Peak values are kept by the OS as long as someone has a handle to the
+// process, so we query them after it exits
+func runAndGetPeakMemory(timeout time.Duration, exe string, args ...string) (int64, int64, error) {
+	c, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(c, exe, args...)
+	if err := cmd.Start(); err != nil {
+		return 0, 0, err
+	}
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, 0, err
+	}
+	defer syscall.CloseHandle(h)
+	err = cmd.Wait()
+	if c.Err() != nil {
+		return 0, 0, fmt.Errorf("'%s %s' didn't finish in %s", exe, strings.Join(args, " "), timeout)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("'%s %s' failed with '%s'", exe, strings.Join(args, " "), err)
+	}
+	var pmc processMemoryCounters
+	pmc.Cb = uint32(unsafe.Sizeof(pmc))
+	r, _, e := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&pmc)), uintptr(pmc.Cb))
+	if r == 0 {
+		return 0, 0, e
+	}
+	return int64(pmc.PeakWorkingSetSize), int64(pmc.PeakPagefileUsage), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Posts build start / success / failure to a Discord or Slack webhook
+// configured via BUILD_NOTIFY_WEBHOOK env variable (or in secrets file).
+// Failing to notify is logged but never fails the build.
+
+var buildNotifyWebhook string
+
+// msbuild writes warnings to out/build-stats/msbuild-warnings-${platform}.log
+// and we remember the total in warnings-count.txt to report the delta
+// between builds. cleanPreserveSettings() doesn't delete warnings-count.txt
+var buildStatsDir = filepath.Join("out", "build-stats")
+
+const warningsCountFileName = "warnings-count.txt"
+
+func getMsbuildWarningsLogPath(platform string) string {
+	return filepath.Join(buildStatsDir, "msbuild-warnings-"+getSuffixForPlatform(platform)+".log")
+}
+
+// returns msbuild argument that logs warnings to a file
+func getMsbuildWarningsLogArg(platform string) string {
+	path := getMsbuildWarningsLogPath(platform)
+	must(createDirForFile(path))
+	os.Remove(path)
+	return fmt.Sprintf(`/flp:warningsonly;append;logfile=%s`, path)
+}
+
+func countBuildWarnings() int {
+	n := 0
+	// msbuild logs the same warning multiple times (once per project that
+	// includes the header) so count unique lines
+	seen := map[string]bool{}
+	for _, platform := range []string{kPlatformIntel32, kPlatformIntel64, kPlatformArm64} {
+		path := getMsbuildWarningsLogPath(platform)
+		lines, err := readLinesFromFile(path)
+		if err != nil {
+			continue
+		}
+		for _, l := range lines {
+			if !strings.Contains(l, ": warning ") || seen[l] {
+				continue
+			}
+			seen[l] = true
+			n++
+		}
+	}
+	return n
+}
+
+// returns -1 if we don't know the previous count
+func readPrevWarningsCount() int {
+	path := filepath.Join(buildStatsDir, warningsCountFileName)
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return -1
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(d)))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func writeWarningsCount(n int) {
+	path := filepath.Join(buildStatsDir, warningsCountFileName)
+	must(createDirForFile(path))
+	writeFileMust(path, []byte(strconv.Itoa(n)))
+}
+
+func fmtWarningsDelta() string {
+	n := countBuildWarnings()
+	prev := readPrevWarningsCount()
+	writeWarningsCount(n)
+	if prev < 0 {
+		return fmt.Sprintf("%d warnings", n)
+	}
+	return fmt.Sprintf("%d warnings (%+d)", n, n-prev)
+}
+
+func isDiscordWebhook(uri string) bool {
+	return strings.Contains(uri, "discord.com/") || strings.Contains(uri, "discordapp.com/")
+}
+
+func postWebhookMessage(uri string, msg string) error {
+	if dryRunSkip("post to webhook:\n%s", msg) {
+		return nil
+	}
+	// Discord expects "content", Slack expects "text"
+	key := "text"
+	if isDiscordWebhook(uri) {
+		key = "content"
+	}
+	d, err := json.Marshal(map[string]string{key: msg})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	rsp, err := client.Post(uri, "application/json", bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 400 {
+		return fmt.Errorf("webhook returned status code %d", rsp.StatusCode)
+	}
+	return nil
+}
+
+func notifyBuild(msg string) {
+	if buildNotifyWebhook == "" {
+		return
+	}
+	msg = "SumatraPDF: " + msg
+	if err := postWebhookMessage(buildNotifyWebhook, msg); err != nil {
+		logf("notifyBuild: failed to post '%s', error: %s\n", msg, err)
+	}
+}
+
+func getArtifactLinksForNotification(buildType BuildType) []string {
+	am := readArtifactsManifest(getFinalDirForBuildType(buildType))
+	if am == nil {
+		return nil
+	}
+	prefix := getDownloadPrefixViaWebsite(buildType, am.Version)
+	var res []string
+	for _, a := range am.Artifacts {
+		if a.Kind != kArtifactInstaller {
+			continue
+		}
+		res = append(res, prefix+a.Name)
+	}
+	return res
+}
+
+// runs fn and posts start, success (with duration, links to installers
+// and warnings delta) or failure message
+func runWithBuildNotifications(name string, buildType BuildType, fn func()) {
+	ver := getVerForBuildType(buildType)
+	what := fmt.Sprintf("%s %s (%s)", name, ver, getGitSha1()[:8])
+	notifyBuild("started " + what)
+	timeStart := time.Now()
+	defer func() {
+		dur := formatDuration(time.Since(timeStart))
+		if r := recover(); r != nil {
+			notifyBuild(fmt.Sprintf("FAILED %s after %s: %v", what, dur, r))
+			panic(r)
+		}
+		lines := []string{
+			fmt.Sprintf("finished %s in %s, %s", what, dur, fmtWarningsDelta()),
+		}
+		lines = append(lines, getArtifactLinksForNotification(buildType)...)
+		notifyBuild(strings.Join(lines, "\n"))
+	}()
+	fn()
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// -package-msi [rel] creates .msi installers from pre-release (or release)
+// build in out/, using WiX toolset (dotnet tool install --global wix).
+// Enterprises deploy software via MSI (Group Policy, Intune, SCCM).
+//
+// The .msi installs the same files as our installer (SumatraPDF-dll.exe,
+// as SumatraPDF.exe, with dlls) and registers file associations the same way
+// RegisterForOpenWith() does i.e. as "Open With" candidates, not as default.
+//
+// The package is dual-purpose (per-machine or per-user):
+//   - msiexec /i SumatraPDF-64.msi ALLUSERS=1 : per-machine, in Program Files
+//   - msiexec /i SumatraPDF-64.msi MSIINSTALLPERUSER=1 : per-user, in %LOCALAPPDATA%\Programs
+// Default is per-user, which doesn't require admin rights.
+//
+// Note: search filter (PdfFilter.dll) and previewer (PdfPreview.dll) are
+// installed but not registered with the shell.
+
+// upgrade codes must never change, that's how Windows knows that a new .msi
+// is an upgrade of an installed one
+var msiUpgradeCodes = map[string]string{
+	"32":    "FEE47FE2-0542-4243-A5AC-2D3D0305BF6B",
+	"64":    "7F2886BE-761A-40EC-A043-0B9D292BCA3A",
+	"arm64": "3C448C0F-7204-4E93-B5F7-359A79E7EEDB",
+}
+
+// arch as understood by wix build -arch
+var wixArchs = map[string]string{
+	"32":    "x86",
+	"64":    "x64",
+	"arm64": "arm64",
+}
+
+func detectWixMust() string {
+	path, err := exec.LookPath("wix")
+	panicIf(err != nil, "didn't find wix.exe. Install with: dotnet tool install --global wix")
+	return path
+}
+
+// parses gSupportedExts in src/RegistryInstaller.cpp so that the list of
+// extensions we register for is in one place
+func getSupportedFileExtsMust() []string {
+	path := filepath.Join("src", "RegistryInstaller.cpp")
+	s := string(readFileMust(path))
+	start := strings.Index(s, "gSupportedExts =")
+	panicIf(start < 0, "didn't find gSupportedExts in '%s'", path)
+	s = s[start:]
+	s = s[:strings.Index(s, ";")]
+	rx := regexp.MustCompile(`\.[a-z0-9]+`)
+	var res []string
+	for _, m := range regexp.MustCompile(`"(.*?)"`).FindAllStringSubmatch(s, -1) {
+		res = append(res, rx.FindAllString(m[1], -1)...)
+	}
+	panicIf(len(res) < 10, "found only %d extensions in '%s'", len(res), path)
+	return res
+}
+
+// mirrors icon selection in RegisterForOpenWith()
+func getIconIndexForExt(ext string) int {
+	switch ext {
+	case ".epub":
+		return 2
+	case ".cbr", ".cbz", ".cbt", ".cb7":
+		return 3
+	}
+	return 1
+}
+
+// MSI ProductVersion is major.minor.build with build < 65536
+// pre-release 3.6.16443 => 3.6.16443, release 3.5.2 => 3.5.2
+func getMsiVersion(buildType BuildType) string {
+	ver := extractSumatraVersionCommaMust()
+	if buildType == buildTypePreRel {
+		parts := strings.Split(ver, ".")
+		return parts[0] + "." + parts[1] + "." + getPreReleaseVer()
+	}
+	return ver
+}
+
+func xmlEscape(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, `"`, "&quot;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	s = strings.ReplaceAll(s, ">", "&gt;")
+	return s
+}
+
+const wixTmpl = `<?xml version="1.0" encoding="utf-8"?>
+<Wix xmlns="http://wixtoolset.org/schemas/v4/wxs">
+  <Package Name="SumatraPDF" Manufacturer="Krzysztof Kowalczyk" Version="{{.Version}}"
+           UpgradeCode="{{.UpgradeCode}}" Scope="perUserOrMachine" Compressed="yes">
+    <!-- pre-release versions (3.6.16443) are higher than the following release (3.6.0) -->
+    <MajorUpgrade AllowDowngrades="yes" />
+    <MediaTemplate EmbedCab="yes" />
+
+    <Icon Id="SumatraPDF.ico" SourceFile="{{.ExePath}}" />
+    <Property Id="ARPPRODUCTICON" Value="SumatraPDF.ico" />
+    <Property Id="ARPURLINFOABOUT" Value="https://www.sumatrapdfreader.org" />
+
+    <StandardDirectory Id="ProgramFiles6432Folder">
+      <Directory Id="INSTALLFOLDER" Name="SumatraPDF" />
+    </StandardDirectory>
+
+    <ComponentGroup Id="AppFiles" Directory="INSTALLFOLDER">
+      <Component>
+        <File Id="SumatraPDFExe" Source="{{.ExePath}}" Name="SumatraPDF.exe" KeyPath="yes" />
+      </Component>
+{{- range .Dlls}}
+      <Component>
+        <File Source="{{.}}" KeyPath="yes" />
+      </Component>
+{{- end}}
+    </ComponentGroup>
+
+    <StandardDirectory Id="ProgramMenuFolder">
+      <Component Id="StartMenuShortcut">
+        <Shortcut Id="SumatraPDFShortcut" Name="SumatraPDF" Target="[#SumatraPDFExe]" WorkingDirectory="INSTALLFOLDER" />
+        <RegistryValue Root="HKMU" Key="Software\SumatraPDF" Name="MsiShortcut" Type="integer" Value="1" KeyPath="yes" />
+      </Component>
+    </StandardDirectory>
+
+    <Component Id="FileAssociations" Directory="INSTALLFOLDER">
+      <RegistryValue Root="HKMU" Key="Software\SumatraPDF" Name="MsiInstalled" Type="integer" Value="1" KeyPath="yes" />
+      <RegistryValue Root="HKMU" Key="Software\SumatraPDF\Capabilities" Name="ApplicationName" Type="string" Value="SumatraPDF" />
+      <RegistryValue Root="HKMU" Key="Software\SumatraPDF\Capabilities" Name="ApplicationDescription" Type="string" Value="SumatraPDF is a PDF, eBook, XPS, DjVu, CHM, Comic Book reader" />
+      <RegistryValue Root="HKMU" Key="Software\RegisteredApplications" Name="SumatraPDF" Type="string" Value="Software\SumatraPDF\Capabilities" />
+{{- range .Exts}}
+      <RegistryValue Root="HKMU" Key="Software\SumatraPDF\Capabilities\FileAssociations" Name="{{.Ext}}" Type="string" Value="{{.ProgID}}" />
+      <RegistryValue Root="HKMU" Key="Software\Classes\{{.Ext}}\OpenWithProgids" Name="{{.ProgID}}" Type="string" Value="" />
+      <RegistryValue Root="HKMU" Key="Software\Classes\{{.ProgID}}" Type="string" Value="{{.Desc}}" />
+      <RegistryValue Root="HKMU" Key="Software\Classes\{{.ProgID}}\DefaultIcon" Type="string" Value="&quot;[#SumatraPDFExe]&quot;,{{.IconIdx}}" />
+      <RegistryValue Root="HKMU" Key="Software\Classes\{{.ProgID}}\shell\open\command" Type="string" Value="&quot;[#SumatraPDFExe]&quot; &quot;%1&quot;" />
+{{- if eq .Ext ".pdf"}}
+      <RegistryValue Root="HKMU" Key="Software\Classes\{{.ProgID}}\shell\Print\command" Type="string" Value="&quot;[#SumatraPDFExe]&quot; -print-to-default &quot;%1&quot;" />
+      <RegistryValue Root="HKMU" Key="Software\Classes\{{.ProgID}}\shell\PrintTo\command" Type="string" Value="&quot;[#SumatraPDFExe]&quot; -print-to &quot;%2&quot; &quot;%1&quot;" />
+{{- end}}
+{{- end}}
+    </Component>
+
+    <Feature Id="Main" Title="SumatraPDF">
+      <ComponentGroupRef Id="AppFiles" />
+      <ComponentRef Id="StartMenuShortcut" />
+      <ComponentRef Id="FileAssociations" />
+    </Feature>
+  </Package>
+</Wix>
+`
+
+// MsiExt describes file association for a single extension
+type MsiExt struct {
+	Ext     string
+	ProgID  string
+	Desc    string
+	IconIdx int
+}
+
+func genWxs(buildType BuildType, arch string, outDir string) string {
+	var exts []*MsiExt
+	for _, ext := range getSupportedFileExtsMust() {
+		e := &MsiExt{
+			Ext:     ext,
+			ProgID:  "SumatraPDF" + ext,
+			Desc:    strings.ToUpper(ext[1:]) + " File",
+			IconIdx: getIconIndexForExt(ext),
+		}
+		exts = append(exts, e)
+	}
+	var dlls []string
+	for _, name := range []string{"libmupdf.dll", "PdfFilter.dll", "PdfPreview.dll"} {
+		dlls = append(dlls, xmlEscape(absPathMust(filepath.Join(outDir, name))))
+	}
+	v := struct {
+		Version     string
+		UpgradeCode string
+		ExePath     string
+		Dlls        []string
+		Exts        []*MsiExt
+	}{
+		Version:     getMsiVersion(buildType),
+		UpgradeCode: msiUpgradeCodes[arch],
+		ExePath:     xmlEscape(absPathMust(filepath.Join(outDir, "SumatraPDF-dll.exe"))),
+		Dlls:        dlls,
+		Exts:        exts,
+	}
+	return execTextTemplate(wixTmpl, v)
+}
+
+func getPlatformForArch(arch string) string {
+	switch arch {
+	case "32":
+		return kPlatformIntel32
+	case "64":
+		return kPlatformIntel64
+	case "arm64":
+		return kPlatformArm64
+	}
+	panicIf(true, "unknown arch '%s'", arch)
+	return ""
+}
+
+// SumatraPDF-prerel-64-install.exe => SumatraPDF-prerel-64.msi
+func getMsiNameForInstaller(installerName string) string {
+	return strings.TrimSuffix(installerName, "-install.exe") + ".msi"
+}
+
+func packageMsiMust(buildType BuildType) {
+	wix := detectWixMust()
+	dir := getFinalDirForBuildType(buildType)
+	am := readArtifactsManifestMust(dir)
+	wxsDir := filepath.Join("out", "msi")
+	must(os.MkdirAll(wxsDir, 0755))
+
+	n := 0
+	for _, arch := range []string{"32", "64", "arm64"} {
+		inst := am.find(kArtifactInstaller, arch)
+		if inst == nil {
+			continue
+		}
+		outDir := getOutDirForArch(arch)
+		wxsPath := filepath.Join(wxsDir, fmt.Sprintf("SumatraPDF-%s.wxs", arch))
+		writeFileMust(wxsPath, []byte(genWxs(buildType, arch, outDir)))
+
+		name := getMsiNameForInstaller(inst.Name)
+		msiPath := filepath.Join(dir, name)
+		os.Remove(msiPath)
+		cmd := exec.Command(wix, "build", "-arch", wixArchs[arch], "-o", msiPath, wxsPath)
+		runCmdLoggedMust(cmd)
+		// wix creates .wixpdb next to .msi, we don't ship it
+		os.Remove(strings.TrimSuffix(msiPath, ".msi") + ".wixpdb")
+		signMust(msiPath)
+		addArtifactsToManifestMust(buildType, dir, getPlatformForArch(arch), []string{name})
+		logf("created '%s' of size %s\n", msiPath, formatSize(fileSizeMust(msiPath)))
+		n++
+	}
+	panicIf(n == 0, "packageMsi: no installers in '%s'", dir)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// -package-msix [rel] creates .msix packages from pre-release (or release)
+// build in out/. The packages can be submitted to Microsoft Store (one per
+// architecture) or side-loaded.
+//
+// For each architecture we:
+//   - create a layout in out/msix/${arch} with signed portable SumatraPDF.exe,
+//     generated AppxManifest.xml and assets scaled from gfx/SumatraPDF-256x256x32.png
+//   - generate resources.pri (so that Windows picks the right asset scale)
+//   - pack with makeappx.exe and sign
+//   - validate with Windows App Certification Kit (appcert.exe, needs admin)
+//
+// AppxManifest.xml next to SumatraPDF.exe makes it run as a store build
+// (see CheckIsStoreBuild()).
+//
+// Identity must match the values reserved for the app in Partner Center
+// (over-ride with MSIX_IDENTITY_NAME and MSIX_PUBLISHER). Publisher must be
+// the subject of the signing certificate or signtool fails so by default we
+// take it from signature of SumatraPDF.exe we package, which is signed with
+// the same certificate.
+
+var (
+	msixIdentityName = "7b5e7c03-beb1-4a1c-a6bf-52c7d7333bd6"
+	// "" means: subject of certificate that signed the build
+	msixPublisher = ""
+)
+
+var msixSrcIconPath = filepath.Join("gfx", "SumatraPDF-256x256x32.png")
+
+// arch as understood by ProcessorArchitecture in AppxManifest.xml
+var msixArchs = map[string]string{
+	"32":    "x86",
+	"64":    "x64",
+	"arm64": "arm64",
+}
+
+func detectMakePriPath() string {
+	return detectPathInSDK(`x64\makepri.exe`)
+}
+
+func detectAppCertPath() string {
+	path := `C:\Program Files (x86)\Windows Kits\10\App Certification Kit\appcert.exe`
+	panicIf(!fileExists(path), "didn't find '%s'. Install Windows App Certification Kit from Windows SDK installer", path)
+	return path
+}
+
+// MSIX version must be major.minor.build.0 (Store requires revision to be 0)
+func getMsixVersion(buildType BuildType) string {
+	return getMsiVersion(buildType) + ".0"
+}
+
+const appxManifestTmpl = `<?xml version="1.0" encoding="utf-8"?>
+<Package xmlns="http://schemas.microsoft.com/appx/manifest/foundation/windows10"
+         xmlns:uap="http://schemas.microsoft.com/appx/manifest/uap/windows10"
+         xmlns:rescap="http://schemas.microsoft.com/appx/manifest/foundation/windows10/restrictedcapabilities"
+         IgnorableNamespaces="uap rescap">
+  <Identity Name="{{.IdentityName}}" Publisher="{{.Publisher}}" Version="{{.Version}}" ProcessorArchitecture="{{.Arch}}" />
+  <Properties>
+    <DisplayName>SumatraPDF</DisplayName>
+    <PublisherDisplayName>Krzysztof Kowalczyk</PublisherDisplayName>
+    <Logo>Assets\StoreLogo.png</Logo>
+  </Properties>
+  <Dependencies>
+    <TargetDeviceFamily Name="Windows.Desktop" MinVersion="10.0.17763.0" MaxVersionTested="10.0.22621.0" />
+  </Dependencies>
+  <Resources>
+    <Resource Language="en-us" />
+  </Resources>
+  <Applications>
+    <Application Id="SumatraPDF" Executable="SumatraPDF.exe" EntryPoint="Windows.FullTrustApplication">
+      <uap:VisualElements DisplayName="SumatraPDF" Description="PDF, eBook, XPS, DjVu, CHM, Comic Book reader"
+                          BackgroundColor="transparent" Square150x150Logo="Assets\Square150x150Logo.png" Square44x44Logo="Assets\Square44x44Logo.png">
+        <uap:DefaultTile Wide310x150Logo="Assets\Wide310x150Logo.png" />
+      </uap:VisualElements>
+      <Extensions>
+{{- range .Exts}}
+        <uap:Extension Category="windows.fileTypeAssociation">
+          <uap:FileTypeAssociation Name="{{.Name}}">
+            <uap:DisplayName>{{.Desc}}</uap:DisplayName>
+            <uap:SupportedFileTypes>
+              <uap:FileType>{{.Ext}}</uap:FileType>
+            </uap:SupportedFileTypes>
+          </uap:FileTypeAssociation>
+        </uap:Extension>
+{{- end}}
+      </Extensions>
+    </Application>
+  </Applications>
+  <Capabilities>
+    <rescap:Capability Name="runFullTrust" />
+  </Capabilities>
+</Package>
+`
+
+// MsixExt describes file type association for a single extension
+type MsixExt struct {
+	// must be lower case, unique within the package
+	Name string
+	Ext  string
+	Desc string
+}
+
+// returns subject of certificate that signed path e.g.
+// "CN=Krzysztof Kowalczyk, O=Krzysztof Kowalczyk, L=Warszawa, C=PL"
+func getSignerSubject(path string) (string, error) {
+	script := "(Get-AuthenticodeSignature -LiteralPath $env:SIGNED_FILE_PATH).SignerCertificate.Subject"
+	cmd := exec.Command("powershell", "-NoProfile", "-Command", script)
+	cmd.Env = append(os.Environ(), "SIGNED_FILE_PATH="+path)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func getMsixPublisherMust(exePath string) string {
+	if msixPublisher != "" {
+		return msixPublisher
+	}
+	subject, err := getSignerSubject(exePath)
+	panicIf(err != nil || subject == "", "couldn't get MSIX publisher from signature of '%s' (is it signed?), set MSIX_PUBLISHER env variable to subject of signing certificate", exePath)
+	logf("MSIX publisher from signature of '%s': %s\n", exePath, subject)
+	return subject
+}
+
+func genAppxManifest(buildType BuildType, arch string, publisher string) string {
+	var exts []*MsixExt
+	for _, ext := range getSupportedFileExtsMust() {
+		e := &MsixExt{
+			Name: ext[1:],
+			Ext:  ext,
+			Desc: strings.ToUpper(ext[1:]) + " File",
+		}
+		exts = append(exts, e)
+	}
+	v := struct {
+		IdentityName string
+		Publisher    string
+		Version      string
+		Arch         string
+		Exts         []*MsixExt
+	}{
+		IdentityName: xmlEscape(msixIdentityName),
+		Publisher:    xmlEscape(publisher),
+		Version:      getMsixVersion(buildType),
+		Arch:         msixArchs[arch],
+		Exts:         exts,
+	}
+	return execTextTemplate(appxManifestTmpl, v)
+}
+
+// MsixAsset is a single image in Assets/ directory
+type MsixAsset struct {
+	// e.g. "Square44x44Logo.scale-200.png"
+	Name string
+	Dx   int
+	Dy   int
+	// size of the icon, centered in Dx x Dy image
+	IconSize int
+}
+
+// logos are drawn with padding, as recommended by
+// https://learn.microsoft.com/en-us/windows/apps/design/style/iconography/app-icon-construction
+func getMsixAssets() []*MsixAsset {
+	var res []*MsixAsset
+	add := func(name string, dx, dy, iconSize int) {
+		res = append(res, &MsixAsset{Name: name, Dx: dx, Dy: dy, IconSize: iconSize})
+	}
+	for _, scale := range []int{100, 200} {
+		s := func(n int) int { return n * scale / 100 }
+		add(fmt.Sprintf("StoreLogo.scale-%d.png", scale), s(50), s(50), s(50))
+		add(fmt.Sprintf("Square44x44Logo.scale-%d.png", scale), s(44), s(44), s(44))
+		add(fmt.Sprintf("Square150x150Logo.scale-%d.png", scale), s(150), s(150), s(100))
+		add(fmt.Sprintf("Wide310x150Logo.scale-%d.png", scale), s(310), s(150), s(100))
+	}
+	// used in taskbar, start menu list and explorer
+	for _, size := range []int{16, 24, 32, 48, 256} {
+		add(fmt.Sprintf("Square44x44Logo.targetsize-%d.png", size), size, size, size)
+		add(fmt.Sprintf("Square44x44Logo.targetsize-%d_altform-unplated.png", size), size, size, size)
+	}
+	return res
+}
+
+// scales src to dx x dy using area averaging, which gives good quality
+// when downscaling. Colors are premultiplied so that transparent pixels
+// don't bleed into edges
+func scaleImage(src image.Image, dx, dy int) *image.RGBA {
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	sdx, sdy := b.Dx(), b.Dy()
+	res := image.NewRGBA(image.Rect(0, 0, dx, dy))
+	fx := float64(sdx) / float64(dx)
+	fy := float64(sdy) / float64(dy)
+	for y := 0; y < dy; y++ {
+		y0, y1 := float64(y)*fy, float64(y+1)*fy
+		for x := 0; x < dx; x++ {
+			x0, x1 := float64(x)*fx, float64(x+1)*fx
+			var acc [4]float64
+			var total float64
+			for sy := int(y0); sy < sdy && float64(sy) < y1; sy++ {
+				wy := min(float64(sy+1), y1) - max(float64(sy), y0)
+				for sx := int(x0); sx < sdx && float64(sx) < x1; sx++ {
+					wx := min(float64(sx+1), x1) - max(float64(sx), x0)
+					w := wx * wy
+					off := rgba.PixOffset(sx, sy)
+					for i := 0; i < 4; i++ {
+						acc[i] += float64(rgba.Pix[off+i]) * w
+					}
+					total += w
+				}
+			}
+			off := res.PixOffset(x, y)
+			for i := 0; i < 4; i++ {
+				res.Pix[off+i] = uint8(acc[i]/total + 0.5)
+			}
+		}
+	}
+	return res
+}
+
+func writePngMust(path string, img image.Image) {
+	f, err := os.Create(path)
+	must(err)
+	err = png.Encode(f, img)
+	must(f.Close())
+	must(err)
+}
+
+func genMsixAssetsMust(dir string) {
+	f, err := os.Open(msixSrcIconPath)
+	must(err)
+	icon, err := png.Decode(f)
+	f.Close()
+	must(err)
+	b := icon.Bounds()
+
+	must(os.MkdirAll(dir, 0755))
+	for _, a := range getMsixAssets() {
+		panicIf(a.IconSize > b.Dx(), "'%s' is %dx%d, too small for %s", msixSrcIconPath, b.Dx(), b.Dy(), a.Name)
+		var scaled image.Image = icon
+		if a.IconSize != b.Dx() {
+			scaled = scaleImage(icon, a.IconSize, a.IconSize)
+		}
+		img := image.NewRGBA(image.Rect(0, 0, a.Dx, a.Dy))
+		pt := image.Pt((a.Dx-a.IconSize)/2, (a.Dy-a.IconSize)/2)
+		r := image.Rectangle{pt, pt.Add(image.Pt(a.IconSize, a.IconSize))}
+		draw.Draw(img, r, scaled, scaled.Bounds().Min, draw.Over)
+		writePngMust(filepath.Join(dir, a.Name), img)
+	}
+	logf("generated %d assets in '%s'\n", len(getMsixAssets()), dir)
+}
+
+// generates resources.pri index of assets in layoutDir
+func genResourcesPriMust(layoutDir string) {
+	makepri := detectMakePriPath()
+	cfgPath := filepath.Join(layoutDir, "..", "priconfig.xml")
+	os.Remove(cfgPath)
+	cmd := exec.Command(makepri, "createconfig", "/cf", cfgPath, "/dq", "en-US", "/pv", "10.0.0", "/o")
+	runCmdLoggedMust(cmd)
+	priPath := filepath.Join(layoutDir, "resources.pri")
+	cmd = exec.Command(makepri, "new", "/pr", layoutDir, "/cf", cfgPath, "/of", priPath, "/o")
+	runCmdLoggedMust(cmd)
+}
+
+// WackReport is a subset of report written by appcert.exe
+type WackReport struct {
+	OverallResult string `xml:"OVERALL_RESULT,attr"`
+	Requirements  []struct {
+		Title string `xml:"TITLE,attr"`
+		Tests []struct {
+			Name     string `xml:"NAME,attr"`
+			Result   string `xml:"RESULT"`
+			Messages []struct {
+				Text string `xml:"TEXT,attr"`
+			} `xml:"MESSAGES>MESSAGE"`
+		} `xml:"TEST"`
+	} `xml:"REQUIREMENTS>REQUIREMENT"`
+}
+
+// returns descriptions of failed tests
+func parseWackReportMust(path string) (string, []string) {
+	var report WackReport
+	err := xml.Unmarshal(readFileMust(path), &report)
+	must(err)
+	var failed []string
+	for _, req := range report.Requirements {
+		for _, t := range req.Tests {
+			if strings.TrimSpace(t.Result) != "FAIL" {
+				continue
+			}
+			s := fmt.Sprintf("%s / %s", req.Title, t.Name)
+			for _, m := range t.Messages {
+				s += "\n    " + m.Text
+			}
+			failed = append(failed, s)
+		}
+	}
+	return report.OverallResult, failed
+}
+
+// runs Windows App Certification Kit tests, same as done by Store on submission
+func validateMsixMust(msixPath string) {
+	appcert := detectAppCertPath()
+	reportPath := absPathMust(strings.TrimSuffix(msixPath, ".msix") + "-wack.xml")
+	os.Remove(reportPath)
+	runCmdLoggedMust(exec.Command(appcert, "reset"))
+	cmd := exec.Command(appcert, "test", "-appxpackagepath", absPathMust(msixPath), "-reportoutputpath", reportPath)
+	runCmdLoggedMust(cmd)
+	result, failed := parseWackReportMust(reportPath)
+	if len(failed) > 0 || result == "FAIL" {
+		panicIf(true, "'%s' failed certification, result: %s, report: '%s'\n  %s", msixPath, result, reportPath, strings.Join(failed, "\n  "))
+	}
+	logf("'%s' passed certification, result: %s\n", msixPath, result)
+}
+
+// SumatraPDF-prerel-64.exe => SumatraPDF-prerel-64.msix
+func getMsixNameForPortableExe(exeName string) string {
+	return strings.TrimSuffix(exeName, ".exe") + ".msix"
+}
+
+func packageMsixMust(buildType BuildType) {
+	makeappx := detectMakeAppxPath()
+	dir := getFinalDirForBuildType(buildType)
+	am := readArtifactsManifestMust(dir)
+
+	n := 0
+	publisher := ""
+	for _, arch := range []string{"32", "64", "arm64"} {
+		exe := am.find(kArtifactPortableExe, arch)
+		if exe == nil {
+			continue
+		}
+		if publisher == "" {
+			publisher = getMsixPublisherMust(filepath.FromSlash(exe.Path))
+		}
+		layoutDir := filepath.Join("out", "msix", arch)
+		must(os.RemoveAll(layoutDir))
+		must(os.MkdirAll(layoutDir, 0755))
+		copyFileMust(filepath.Join(layoutDir, "SumatraPDF.exe"), filepath.FromSlash(exe.Path))
+		manifest := genAppxManifest(buildType, arch, publisher)
+		writeFileMust(filepath.Join(layoutDir, "AppxManifest.xml"), []byte(manifest))
+		genMsixAssetsMust(filepath.Join(layoutDir, "Assets"))
+		genResourcesPriMust(layoutDir)
+
+		name := getMsixNameForPortableExe(exe.Name)
+		msixPath := filepath.Join(dir, name)
+		os.Remove(msixPath)
+		cmd := exec.Command(makeappx, "pack", "/d", layoutDir, "/p", msixPath, "/o")
+		runCmdLoggedMust(cmd)
+		signMust(msixPath)
+		validateMsixMust(msixPath)
+		addArtifactsToManifestMust(buildType, dir, getPlatformForArch(arch), []string{name})
+		logf("created '%s' of size %s\n", msixPath, formatSize(fileSizeMust(msixPath)))
+		n++
+	}
+	panicIf(n == 0, "packageMsix: no portable executables in '%s'", dir)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Portable .zip contains SumatraPDF.exe (under versioned name) and
+// portable-manifest.json describing it. The manifest is read by tools that
+// work on portable builds (delta updates, signature verification) so that
+// they don't have to guess version / arch from file names.
+//
+// The .zip is reproducible: for the same SumatraPDF.exe we create
+// byte-identical .zip. Files are added in fixed order with fixed attributes
+// and timestamp of HEAD commit.
+//
+// If 7-Zip is installed we also create .7z with the same content. It's
+// about 30% smaller than .zip, which matters for users on slow connections
+// and for mirrors. It's reproducible as long as the same version of 7-Zip
+// is used.
+
+const portableManifestName = "portable-manifest.json"
+
+// PortableFileInfo describes a single file in portable .zip
+type PortableFileInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// PortableManifest is content of portable-manifest.json
+type PortableManifest struct {
+	BuildType BuildType           `json:"buildType"`
+	Version   string              `json:"version"`
+	Arch      string              `json:"arch"` // "32", "64" or "arm64"
+	GitSha1   string              `json:"gitSha1"`
+	Files     []*PortableFileInfo `json:"files"`
+}
+
+func (pm *PortableManifest) findFile(name string) *PortableFileInfo {
+	for _, f := range pm.Files {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// adds data to zip with attributes that don't depend on the machine
+// or time of the build
+func addZipDataDeterministicMust(w *zip.Writer, d []byte, nameInZip string, modTime time.Time) {
+	fih := &zip.FileHeader{
+		Name:     nameInZip,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	fih.SetMode(0644)
+	fw, err := w.CreateHeader(fih)
+	must(err)
+	_, err = fw.Write(d)
+	must(err)
+}
+
+func genPortableManifestMust(exePath string, nameInArchive string, buildType BuildType, platform string) []byte {
+	pm := &PortableManifest{
+		BuildType: buildType,
+		Version:   getVerForBuildType(buildType),
+		Arch:      getSuffixForPlatform(platform),
+		GitSha1:   getGitSha1(),
+		Files: []*PortableFileInfo{
+			{
+				Name:   nameInArchive,
+				Size:   fileSizeMust(exePath),
+				Sha256: fileSha256HexMust(exePath),
+			},
+		},
+	}
+	d, err := json.MarshalIndent(pm, "", "  ")
+	must(err)
+	return d
+}
+
+// creates SumatraPDF.zip and, if 7-Zip is installed, SumatraPDF.7z in dir
+// with SumatraPDF.exe as nameInArchive
+func createPortableArchivesMust(dir string, nameInArchive string, buildType BuildType, platform string) {
+	exePath := filepath.Join(dir, "SumatraPDF.exe")
+	manifestData := genPortableManifestMust(exePath, nameInArchive, buildType, platform)
+	createPortableZipMust(dir, exePath, nameInArchive, manifestData)
+	path7z := detect7zPath()
+	if path7z == "" {
+		logf("didn't find 7z.exe, skipping creating SumatraPDF.7z\n")
+		return
+	}
+	createPortable7zMust(path7z, dir, exePath, nameInArchive, manifestData)
+}
+
+func createPortableZipMust(dir string, exePath string, nameInZip string, manifestData []byte) {
+	exeData := readFileMust(exePath)
+	modTime := getGitCommitTimeMust()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	addZipDataDeterministicMust(zw, exeData, nameInZip, modTime)
+	addZipDataDeterministicMust(zw, manifestData, portableManifestName, modTime)
+	must(zw.Close())
+
+	zipPath := filepath.Join(dir, "SumatraPDF.zip")
+	os.Remove(zipPath) // called multiple times during upload
+	writeFileMust(zipPath, buf.Bytes())
+	logf("created '%s' of size %s\n", zipPath, formatSize(int64(buf.Len())))
+}
+
+func readZipFileMust(zr *zip.Reader, name string) []byte {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		must(err)
+		defer r.Close()
+		d, err := io.ReadAll(r)
+		must(err)
+		return d
+	}
+	panicIf(true, "didn't find '%s' in zip", name)
+	return nil
+}
+
+// returns "" if 7-Zip is not installed
+func detect7zPath() string {
+	path, err := exec.LookPath("7z")
+	if err == nil {
+		return path
+	}
+	path = `C:\Program Files\7-Zip\7z.exe`
+	if fileExists(path) {
+		return path
+	}
+	return ""
+}
+
+func createPortable7zMust(path7z string, dir string, exePath string, nameIn7z string, manifestData []byte) {
+	// 7z.exe archives files under their names so we stage them in a directory
+	stageDir := filepath.Join(dir, "portable-7z")
+	must(os.RemoveAll(stageDir))
+	must(os.MkdirAll(stageDir, 0755))
+	defer os.RemoveAll(stageDir)
+	copyFileMust(filepath.Join(stageDir, nameIn7z), exePath)
+	writeFileMust(filepath.Join(stageDir, portableManifestName), manifestData)
+
+	archivePath := absPathMust(filepath.Join(dir, "SumatraPDF.7z"))
+	os.Remove(archivePath)
+	// for reproducibility: no timestamps, single-threaded compression
+	// (multi-threaded splits data into blocks depending on number of cores)
+	// and files in fixed order
+	args := []string{"a", "-t7z", "-mx=9", "-m0=lzma2", "-ms=on", "-mmt=1",
+		"-mtm=off", "-mtc=off", "-mta=off", "-bd", archivePath,
+		nameIn7z, portableManifestName}
+	cmd := exec.Command(path7z, args...)
+	cmd.Dir = stageDir
+	runCmdLoggedMust(cmd)
+	logf("created '%s' of size %s\n", archivePath, formatSize(fileSizeMust(archivePath)))
+}
+
+// extracts all files from .7z to dstDir
+func extract7zMust(archivePath string, dstDir string) {
+	path7z := detect7zPath()
+	panicIf(path7z == "", "didn't find 7z.exe, needed to extract '%s'", archivePath)
+	cmd := exec.Command(path7z, "x", "-y", "-bd", "-o"+dstDir, archivePath)
+	runCmdLoggedMust(cmd)
+}
+
+func readPortableManifestFromZipMust(zipPath string) *PortableManifest {
+	zr, err := zip.OpenReader(zipPath)
+	must(err)
+	defer zr.Close()
+	d := readZipFileMust(&zr.Reader, portableManifestName)
+	var res PortableManifest
+	err = json.Unmarshal(d, &res)
+	must(err)
+	return &res
+}
+
+func checkPortableManifest(pm *PortableManifest, am *ArtifactsManifest, arch string) string {
+	if pm.Version != am.Version || pm.BuildType != am.BuildType || pm.Arch != arch {
+		return fmt.Sprintf("%s is for %s build %s, arch %s, expected %s build %s, arch %s", portableManifestName, pm.BuildType, pm.Version, pm.Arch, am.BuildType, am.Version, arch)
+	}
+	return ""
+}
+
+// returns description of the problem or "" if files extracted from .7z to
+// dir match portable-manifest.json and the build in artifacts.json
+func verifyPortableFilesInDir(dir string, am *ArtifactsManifest, arch string) string {
+	var pm PortableManifest
+	err := json.Unmarshal(readFileMust(filepath.Join(dir, portableManifestName)), &pm)
+	must(err)
+	if problem := checkPortableManifest(&pm, am, arch); problem != "" {
+		return problem
+	}
+	entries, err := os.ReadDir(dir)
+	must(err)
+	if len(entries) != len(pm.Files)+1 {
+		return fmt.Sprintf("archive has %d files, %s lists %d", len(entries)-1, portableManifestName, len(pm.Files))
+	}
+	for _, fi := range pm.Files {
+		path := filepath.Join(dir, fi.Name)
+		if !fileExists(path) {
+			return fmt.Sprintf("'%s' from %s is missing", fi.Name, portableManifestName)
+		}
+		if fileSizeMust(path) != fi.Size || fileSha256HexMust(path) != fi.Sha256 {
+			return fmt.Sprintf("'%s' doesn't match size / sha256 in %s", fi.Name, portableManifestName)
+		}
+	}
+	return ""
+}
+
+// returns description of the problem or "" if files in the .zip match
+// portable-manifest.json and the build in artifacts.json
+func verifyPortableZip(zipPath string, am *ArtifactsManifest, arch string) string {
+	pm := readPortableManifestFromZipMust(zipPath)
+	if problem := checkPortableManifest(pm, am, arch); problem != "" {
+		return problem
+	}
+	zr, err := zip.OpenReader(zipPath)
+	must(err)
+	defer zr.Close()
+	nFiles := 0
+	for _, f := range zr.File {
+		if f.Name == portableManifestName {
+			continue
+		}
+		nFiles++
+		fi := pm.findFile(f.Name)
+		if fi == nil {
+			return fmt.Sprintf("'%s' is not in %s", f.Name, portableManifestName)
+		}
+		d := readZipFileMust(&zr.Reader, f.Name)
+		if int64(len(d)) != fi.Size || sha256HexOfData(d) != fi.Sha256 {
+			return fmt.Sprintf("'%s' doesn't match size / sha256 in %s", f.Name, portableManifestName)
+		}
+	}
+	if nFiles != len(pm.Files) {
+		return fmt.Sprintf("zip has %d files, %s lists %d", nFiles, portableManifestName, len(pm.Files))
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// We have 2 variants of .pdb files:
+//   - full, private .pdb files created by the linker. They have types, locals,
+//     line info etc. and are published to symbol server (see symbols.go)
+//   - stripped, public .pdb files (only public symbols and FPO data) created
+//     with pdbcopy.exe -p. They're several times smaller and good enough for
+//     getting call stacks, which is what most power users want
+//
+// SumatraPDF.pdb.zip and SumatraPDF.pdb.lzsa that we ship with builds have
+// full .pdb files unless -strip-pdbs is given
+
+const strippedPdbDirName = "pdb-stripped"
+
+func detectPdbcopyMust() string {
+	path := filepath.Join(debuggersDir, "pdbcopy.exe")
+	panicIf(!fileExists(path), "didn't find '%s'. Install Debugging Tools for Windows", path)
+	return path
+}
+
+func getStrippedPdbDir(dir string) string {
+	return filepath.Join(dir, strippedPdbDirName)
+}
+
+// creates public-only version of .pdb files in ${dir}/pdb-stripped
+func createStrippedPdbsMust(dir string) {
+	pdbcopy := detectPdbcopyMust()
+	dstDir := getStrippedPdbDir(dir)
+	must(os.RemoveAll(dstDir))
+	createDirMust(dstDir)
+	for _, name := range pdbFiles {
+		src := filepath.Join(dir, name)
+		dst := filepath.Join(dstDir, name)
+		// -p : remove private debug information
+		cmd := exec.Command(pdbcopy, src, dst, "-p")
+		runCmdLoggedMust(cmd)
+		panicIf(!fileExists(dst), "pdbcopy didn't create '%s'", dst)
+	}
+}
+
+// returns directory with .pdb files that go into SumatraPDF.pdb.zip / .lzsa
+func getPdbDirForDistribution(dir string) string {
+	if flgStripPdbs {
+		createStrippedPdbsMust(dir)
+		return getStrippedPdbDir(dir)
+	}
+	return dir
+}
+
+func zippedSizeMust(path string) int64 {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	addZipFileMust(zw, path)
+	must(zw.Close())
+	return int64(buf.Len())
+}
+
+func printPdbSizes(dir string) {
+	strippedDir := getStrippedPdbDir(dir)
+	var totalFull, totalStripped, totalFullZipped, totalStrippedZipped int64
+	logf("\n.pdb sizes in '%s':\n", dir)
+	logf("%-20s %12s %12s %12s %12s\n", "name", "full", "full zip", "stripped", "stripped zip")
+	fmtSize := func(n int64) string {
+		if n < 0 {
+			return "-"
+		}
+		return formatSize(n)
+	}
+	for _, name := range pdbFiles {
+		full := filepath.Join(dir, name)
+		stripped := filepath.Join(strippedDir, name)
+		if !fileExists(full) {
+			continue
+		}
+		fullSize := fileSizeMust(full)
+		fullZipped := zippedSizeMust(full)
+		totalFull += fullSize
+		totalFullZipped += fullZipped
+		strippedSize, strippedZipped := int64(-1), int64(-1)
+		if fileExists(stripped) {
+			strippedSize = fileSizeMust(stripped)
+			strippedZipped = zippedSizeMust(stripped)
+			totalStripped += strippedSize
+			totalStrippedZipped += strippedZipped
+		}
+		logf("%-20s %12s %12s %12s %12s\n", name, fmtSize(fullSize), fmtSize(fullZipped), fmtSize(strippedSize), fmtSize(strippedZipped))
+	}
+	logf("%-20s %12s %12s %12s %12s\n", "total", fmtSize(totalFull), fmtSize(totalFullZipped), fmtSize(totalStripped), fmtSize(totalStrippedZipped))
+	if totalStripped > 0 {
+		pct := float64(totalStrippedZipped) * 100 / float64(totalFullZipped)
+		logf("stripped zip is %.1f%% of full zip\n", pct)
+	}
+	for _, name := range []string{"SumatraPDF.pdb.zip", "SumatraPDF.pdb.lzsa"} {
+		path := filepath.Join(dir, name)
+		if fileExists(path) {
+			logf("%s: %s\n", path, formatSize(fileSizeMust(path)))
+		}
+	}
+}
+
+// creates stripped .pdb files for all builds in out/ and shows size comparison
+func pdbSizesReport() {
+	n := 0
+	for _, dir := range []string{rel32Dir, rel64Dir, relArm64Dir} {
+		if !fileExists(filepath.Join(dir, pdbFiles[0])) {
+			continue
+		}
+		createStrippedPdbsMust(dir)
+		printPdbSizes(dir)
+		n++
+	}
+	panicIf(n == 0, "didn't find any .pdb files in out/")
+	fmt.Println()
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -perf-trace ${scenario} ${file} [${search term}] records an ETW trace of
+// release 64-bit SumatraPDF.exe running a scenario and summarizes where it
+// spends CPU and does IO, by module.
+//
+// Scenarios:
+//   - open   : -bench ${file}, loads and renders all pages, then exits
+//   - search : opens ${file} and searches for ${search term}, traced for
+//     perfTraceSearchDuration
+//   - print  : opens print dialog for ${file}, print or cancel it to end
+//
+// Needs Windows Performance Toolkit (wpr.exe and xperf.exe) and an elevated
+// prompt. Trace, its dump and report are in out/perf-trace/${scenario}-${time}.
+// Open trace.etl in WPA for details the report doesn't show.
+
+const (
+	perfTraceSearchDuration = 30 * time.Second
+	perfTraceTimeout        = 5 * time.Minute
+	perfTraceTopN           = 25
+)
+
+var perfTraceDir = filepath.Join("out", "perf-trace")
+
+var perfTraceScenarios = []string{"open", "search", "print"}
+
+func getPerfTraceScenarioArgs(scenario string, args []string) ([]string, time.Duration) {
+	file := args[0]
+	switch scenario {
+	case "open":
+		return []string{"-bench", file}, 0
+	case "search":
+		panicIf(len(args) < 2, "usage: -perf-trace search ${file} ${search term}")
+		return []string{file, "-search", args[1]}, perfTraceSearchDuration
+	case "print":
+		return []string{file, "-print-dialog", "-exit-when-done"}, 0
+	}
+	panicIf(true, "unknown scenario '%s', known: %s", scenario, strings.Join(perfTraceScenarios, ", "))
+	return nil, 0
+}
+
+// runs exe until it exits or, if d > 0, for d
+func runPerfTraceScenario(exe string, args []string, d time.Duration) error {
+	timeout := perfTraceTimeout
+	if d > 0 {
+		timeout = d
+	}
+	c, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(c, exe, args...)
+	logf("> %s\n", fmtCmdShort(*cmd))
+	timeStart := time.Now()
+	err := cmd.Run()
+	if c.Err() != nil {
+		if d > 0 {
+			logf("stopped after %s\n", d)
+			return nil
+		}
+		return fmt.Errorf("'%s' didn't finish in %s", fmtCmdShort(*cmd), timeout)
+	}
+	logf("finished in %s\n", time.Since(timeStart))
+	return err
+}
+
+// PerfTraceStats is a summary of SumatraPDF.exe events in the trace
+type PerfTraceStats struct {
+	Samples int
+	// module => number of cpu samples
+	SamplesByModule map[string]int
+	// module!function => number of cpu samples
+	SamplesByFunc map[string]int
+	// file => bytes read and written
+	IOBytesByFile map[string]int64
+	IOOpsByFile   map[string]int
+	IOBytes       int64
+	IOOps         int
+}
+
+// splits a line of xperf dump, which is comma separated and padded
+func splitXperfDumpLine(s string) []string {
+	parts := strings.Split(s, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return parts
+}
+
+// "SumatraPDF.exe (1234)" => "SumatraPDF.exe"
+func xperfProcessName(s string) string {
+	if idx := strings.LastIndex(s, " ("); idx >= 0 {
+		return s[:idx]
+	}
+	return s
+}
+
+// parses dump of trace made with xperf -i ${etl} -o ${dump} -symbols
+// It starts with a header that lists fields of every event type:
+//
+//	BeginHeader
+//	SampledProfile, TimeStamp, Process Name ( PID), ThreadID, ..., Image!Function, ...
+//	EndHeader
+//
+// followed by events, one per line, whose first field is event type
+func parseXperfDumpMust(path string, process string) *PerfTraceStats {
+	res := &PerfTraceStats{
+		SamplesByModule: map[string]int{},
+		SamplesByFunc:   map[string]int{},
+		IOBytesByFile:   map[string]int64{},
+		IOOpsByFile:     map[string]int{},
+	}
+	f, err := os.Open(path)
+	must(err)
+	defer f.Close()
+
+	// event type => field name => index
+	fields := map[string]map[string]int{}
+	inHeader := false
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		switch strings.TrimSpace(line) {
+		case "BeginHeader":
+			inHeader = true
+			continue
+		case "EndHeader":
+			inHeader = false
+			continue
+		}
+		parts := splitXperfDumpLine(line)
+		if len(parts) < 2 {
+			continue
+		}
+		if inHeader {
+			m := map[string]int{}
+			for i, name := range parts {
+				m[name] = i
+			}
+			fields[parts[0]] = m
+			continue
+		}
+		m := fields[parts[0]]
+		get := func(name string) string {
+			if i, ok := m[name]; ok && i < len(parts) {
+				return parts[i]
+			}
+			return ""
+		}
+		if !strings.EqualFold(xperfProcessName(get("Process Name ( PID)")), process) {
+			continue
+		}
+		switch {
+		case parts[0] == "SampledProfile":
+			fn := get("Image!Function")
+			if fn == "" {
+				continue
+			}
+			n, err := strconv.Atoi(get("Count"))
+			if err != nil || n < 1 {
+				n = 1
+			}
+			module, _, _ := strings.Cut(fn, "!")
+			res.Samples += n
+			res.SamplesByModule[module] += n
+			res.SamplesByFunc[fn] += n
+		case parts[0] == "FileIoRead" || parts[0] == "FileIoWrite":
+			size, err := strconv.ParseInt(get("Size"), 0, 64)
+			if err != nil {
+				continue
+			}
+			name := strings.Trim(get("FileName"), `"`)
+			res.IOBytes += size
+			res.IOOps++
+			res.IOBytesByFile[name] += size
+			res.IOOpsByFile[name]++
+		}
+	}
+	must(sc.Err())
+	return res
+}
+
+// returns keys of m sorted by value, largest first, at most n
+func topKeysByValue[V int | int64](m map[string]V, n int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func genPerfTraceReport(title string, s *PerfTraceStats) string {
+	var b strings.Builder
+	pct := func(n, total int64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(n) * 100 / float64(total)
+	}
+	fmt.Fprintf(&b, "%s\n\n", title)
+	fmt.Fprintf(&b, "CPU: %d samples (~1 ms each)\n\n", s.Samples)
+	fmt.Fprintf(&b, "by module:\n")
+	for _, k := range topKeysByValue(s.SamplesByModule, perfTraceTopN) {
+		n := s.SamplesByModule[k]
+		fmt.Fprintf(&b, "  %7d %5.1f%%  %s\n", n, pct(int64(n), int64(s.Samples)), k)
+	}
+	fmt.Fprintf(&b, "\nby function:\n")
+	for _, k := range topKeysByValue(s.SamplesByFunc, perfTraceTopN) {
+		n := s.SamplesByFunc[k]
+		fmt.Fprintf(&b, "  %7d %5.1f%%  %s\n", n, pct(int64(n), int64(s.Samples)), k)
+	}
+	fmt.Fprintf(&b, "\nIO: %d reads and writes, %s\n\n", s.IOOps, formatSize(s.IOBytes))
+	fmt.Fprintf(&b, "by file:\n")
+	for _, k := range topKeysByValue(s.IOBytesByFile, perfTraceTopN) {
+		n := s.IOBytesByFile[k]
+		fmt.Fprintf(&b, "  %10s %5.1f%% %6d ops  %s\n", formatSize(n), pct(n, s.IOBytes), s.IOOpsByFile[k], k)
+	}
+	return b.String()
+}
+
+// returns absolute path of release 64-bit SumatraPDF.exe, builds it if needed
+func getRel64ExeMust() string {
+	exePath := filepath.Join(rel64Dir, "SumatraPDF.exe")
+	if !fileExists(exePath) {
+		logf("'%s' doesn't exist, rebuilding\n", exePath)
+		buildJustPortableExe(rel64Dir, "Release", kPlatformIntel64)
+	}
+	return absPathMust(exePath)
+}
+
+// -perf-trace ${scenario} ${file} [${search term}]
+func perfTraceMust(args []string) {
+	panicIf(runtime.GOOS != "windows", "-perf-trace only works on Windows")
+	panicIf(len(args) < 2, "usage: -perf-trace ${scenario} ${file} [${search term}], scenarios: %s", strings.Join(perfTraceScenarios, ", "))
+	scenario := args[0]
+	exeArgs, d := getPerfTraceScenarioArgs(scenario, args[1:])
+	panicIf(!fileExists(args[1]), "'%s' doesn't exist", args[1])
+	for _, exe := range []string{"wpr.exe", "xperf.exe"} {
+		_, err := exec.LookPath(exe)
+		panicIf(err != nil, "%s not found, install Windows Performance Toolkit", exe)
+	}
+
+	exePath := getRel64ExeMust()
+
+	dir := filepath.Join(perfTraceDir, scenario+"-"+time.Now().Format("2006-01-02_15-04-05"))
+	must(os.MkdirAll(dir, 0755))
+	// separate settings so that we start from defaults and don't mess with
+	// settings of installed SumatraPDF
+	appDataDir := filepath.Join(dir, "appdata")
+	must(os.MkdirAll(appDataDir, 0755))
+	exeArgs = append([]string{"-appdata", appDataDir}, exeArgs...)
+
+	etlPath := filepath.Join(dir, "trace.etl")
+	// a trace left from a failed run prevents starting a new one
+	_ = exec.Command("wpr.exe", "-cancel").Run()
+	runExeLoggedMust("wpr.exe", "-start", "CPU", "-start", "FileIO", "-filemode")
+	errScenario := runPerfTraceScenario(exePath, exeArgs, d)
+	runExeLoggedMust("wpr.exe", "-stop", etlPath)
+	must(errScenario)
+
+	// so that xperf finds SumatraPDF.pdb next to exe
+	symPath := filepath.Dir(exePath) + ";srv*" + filepath.Join(os.TempDir(), "symbols") + "*https://msdl.microsoft.com/download/symbols"
+	dumpPath := filepath.Join(dir, "dump.csv")
+	cmd := exec.Command("xperf.exe", "-i", etlPath, "-o", dumpPath, "-symbols")
+	cmd.Env = append(os.Environ(), "_NT_SYMBOL_PATH="+symPath)
+	runCmdLoggedMust(cmd)
+
+	stats := parseXperfDumpMust(dumpPath, "SumatraPDF.exe")
+	panicIf(stats.Samples == 0, "no cpu samples of SumatraPDF.exe in '%s'", dumpPath)
+	title := fmt.Sprintf("%s: %s", scenario, strings.Join(exeArgs[2:], " "))
+	report := genPerfTraceReport(title, stats)
+	reportPath := filepath.Join(dir, "report.txt")
+	writeFileMust(reportPath, []byte(report))
+	logf("%s\nwrote '%s', trace is '%s'\n", report, reportPath, etlPath)
+}
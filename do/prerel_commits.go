@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Daily pre-release build runs even when there were no commits since the last
+// one, which wastes build machine time and storage on a copy of the previous
+// build. Before building we find the commit the latest pre-release in storage
+// was built from and skip the build if it's HEAD. -ci-upload then also skips
+// because the version (number of commits) is the same.
+//
+// When we do build, artifacts.json records the previous pre-release and
+// the commits since then so we know what's in each pre-release.
+
+// returns nil if there's no pre-release in storage or we can't check
+func findLastUploadedPreRel() *ArtifactsManifest {
+	if b2Access == "" || b2Secret == "" {
+		logf("Not checking last uploaded pre-release because BB_ACCESS or BB_SECRET env variable not set\n")
+		return nil
+	}
+	storage := newBackblazeStorage()
+	for _, ver := range listRemoteVersionsMust(storage, buildTypePreRel) {
+		// older builds don't have artifacts.json
+		am := downloadArtifactsManifest(storage, buildTypePreRel, ver)
+		if am != nil && am.GitSha1 != "" {
+			logf("last uploaded pre-release is %s built from %s\n", am.Version, am.GitSha1)
+			return am
+		}
+	}
+	logf("didn't find uploaded pre-release with %s\n", artifactsManifestName)
+	return nil
+}
+
+// one line per commit in revRange, newest first
+func getGitCommitLinesMust(revRange string) []string {
+	var res []string
+	for _, c := range getGitCommitsMust(revRange) {
+		res = append(res, fmt.Sprintf("%s %s", c.Sha1[:10], c.Subject))
+	}
+	return res
+}
+
+// records in artifacts.json of pre-release build in out/ commits since prev
+func addPreRelCommitsMust(prev *ArtifactsManifest) {
+	if prev == nil {
+		return
+	}
+	if !isGitAncestorOfHead(prev.GitSha1) {
+		logf("Not recording commits since pre-release %s because %s is not in history of HEAD\n", prev.Version, prev.GitSha1)
+		return
+	}
+	dir := getFinalDirForBuildType(buildTypePreRel)
+	am := readArtifactsManifestMust(dir)
+	am.PrevVersion = prev.Version
+	am.PrevGitSha1 = prev.GitSha1
+	am.Commits = getGitCommitLinesMust(prev.GitSha1 + "..HEAD")
+	writeArtifactsManifestMust(dir, am)
+	logf("pre-release %s has %d commits since %s:\n%s\n", am.Version, len(am.Commits), prev.Version, strings.Join(am.Commits, "\n"))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path"
+	"regexp"
+)
+
+// -promote-prerel ${build} releases the commit of pre-release build ${build}
+// that was already uploaded and tested:
+//   - runs the same checks as -build-release (release branch, string freeze,
+//     translations) and checks that we can sign, before the long build
+//   - checks that the pre-release doesn't crash more than stable release
+//     (see crash_rate.go) and that we can tag the commit
+//   - builds the commit with release configuration. We can't release
+//     pre-release binaries as they are because they are built with
+//     PRE_RELEASE_VER (see setBuildConfigPreRelease()) so they report
+//     themselves as pre-release and use pre-release update checks and crash
+//     reporting. Note: this is a rebuild of the tested commit, not of the
+//     tested binaries. Shipping the binaries without a rebuild needs
+//     PRE_RELEASE_VER to not be compiled in, which is not decided yet
+//   - does the rest of release steps (see getReleaseSteps()), which verify
+//     signatures and that version resources are of release ${ver} before
+//     uploading
+//   - updates stable channel in update-check.json and hashes for the website
+//
+// The commit must be checked out. ${ver} is CURR_VERSION in src/Version.h
+// at the commit. Like -build-release it can be re-run to resume after
+// a failure. After that do -github-release as for regular release.
+
+// version in src/Version.h at the commit
+func getSumatraVersionAtCommitMust(sha1 string) string {
+	out := runExeMust("git", "show", sha1+":src/Version.h")
+	m := regexp.MustCompile(`(?m)^#define CURR_VERSION (\S+)`).FindSubmatch(out)
+	panicIf(m == nil, "couldn't extract CURR_VERSION from src/Version.h at %s", sha1)
+	ver := string(m[1])
+	verifyCorrectVersionMust(ver)
+	return ver
+}
+
+func promotePreRelMust(build string) {
+	defer makePrintDuration("promotePreRelMust")()
+	panicIf(build == "", "usage: -promote-prerel ${build} e.g. -promote-prerel 16234")
+	ensureAllUploadCreds()
+	backends := getStorageBackends()
+
+	am, amPath := getMirrorArtifactsManifestMust(backends, buildTypePreRel, build)
+	panicIf(am.BuildType != buildTypePreRel, "'%s' is not for a pre-release build", amPath)
+	panicIf(getGitSha1() != am.GitSha1, "pre-release %s was built from %s, check it out in rel${ver}working branch", build, am.GitSha1)
+	panicIf(!isGitClean("."), "git has unsaved changes, the build must be of commit %s", am.GitSha1)
+	ver := getSumatraVersionAtCommitMust(am.GitSha1)
+
+	// same as -build-release, see ensureBuildOptionsPreRequesites()
+	signer := getSigner()
+	panicIf(!canSign(), "can't sign with '%s' backend: %s", signer.Name(), signer.Missing())
+	verifyOnReleaseBranchMust()
+	checkStringFreezeMust(getCurrentBranchMust("."))
+	checkTransGateMust(nil)
+	verifyTranslationsMust()
+	// when resuming we already did the checks and might have uploaded
+	if s := readReleaseState(); s != nil && s.Version == ver && s.GitSha1 == am.GitSha1 {
+		logf("resuming promotion of pre-release %s to release %s\n", build, ver)
+	} else {
+		relRemoteDir := path.Join("software/sumatrapdf", string(buildTypeRel), ver) + "/"
+		for _, storage := range backends {
+			remotePath := relRemoteDir + artifactsManifestName
+			panicIf(storage.Exists(remotePath), "release %s already exists: '%s'", ver, storage.URLForPath(remotePath))
+		}
+		// fail before building
+		verifyCanTagReleaseMust(ver, am.GitSha1)
+		verifyCrashRateMust(build, getLatestReleaseVersionMust(backends[0]))
+		logf("promoting pre-release %s (%s) to release %s\n", build, am.GitSha1, ver)
+	}
+
+	steps := getReleaseSteps(true)
+	// unlike regular release, promoted pre-release was already tested so
+	// we make it stable release right away
+	promoteSteps := []*ReleaseStep{
+		{"update-manifest", func() {
+			for _, storage := range backends {
+				uploadUpdateManifestMust(storage, buildTypeRel)
+			}
+		}},
+		{"website-hashes", updateWebsiteSha256SumsMust},
+	}
+	runReleaseStepsMust(append(steps, promoteSteps...))
+	logf("promoted pre-release %s to release %s\nnext: -github-release\n", build, ver)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Every build gets SLSA provenance (https://slsa.dev/spec/v1.0/provenance):
+// who built it (builder), from what (git commit) and what came out (sha256
+// of every artifact). It's an in-toto statement in a DSSE envelope signed
+// with our minisign ed25519 key, saved as ${prefix}.intoto.jsonl and added to
+// artifacts.json so it's uploaded to storage and GitHub release.
+//
+// -gen-provenance [rel] re-generates it for the build in out/
+// -verify-provenance ${file} [dir] checks the signature and, if dir is given,
+// hashes of the files in dir
+
+const (
+	provenanceSuffix      = ".intoto.jsonl"
+	inTotoStatementType   = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType    = "https://slsa.dev/provenance/v1"
+	inTotoPayloadType     = "application/vnd.in-toto+json"
+	sumatraBuildTypeURI   = "https://github.com/sumatrapdfreader/sumatrapdf/tree/master/do"
+	sumatraRepoGitURI     = "git+https://github.com/sumatrapdfreader/sumatrapdf"
+	provenanceLocalPrefix = "https://www.sumatrapdfreader.org/builders/local/"
+)
+
+// InTotoStatement is https://github.com/in-toto/attestation/blob/main/spec/v1/statement.md
+type InTotoStatement struct {
+	Type          string                   `json:"_type"`
+	Subject       []*InTotoSubject         `json:"subject"`
+	PredicateType string                   `json:"predicateType"`
+	Predicate     *SlsaProvenancePredicate `json:"predicate"`
+}
+
+// InTotoSubject is a file the statement is about
+type InTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// SlsaProvenancePredicate is SLSA provenance v1
+type SlsaProvenancePredicate struct {
+	BuildDefinition struct {
+		BuildType            string                    `json:"buildType"`
+		ExternalParameters   map[string]string         `json:"externalParameters"`
+		InternalParameters   map[string]string         `json:"internalParameters,omitempty"`
+		ResolvedDependencies []*SlsaResourceDescriptor `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string `json:"invocationId,omitempty"`
+			FinishedOn   string `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// SlsaResourceDescriptor is an input of the build
+type SlsaResourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// DSSEEnvelope is https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type DSSEEnvelope struct {
+	PayloadType string           `json:"payloadType"`
+	Payload     string           `json:"payload"`
+	Signatures  []*DSSESignature `json:"signatures"`
+}
+
+// DSSESignature is a signature of DSSE envelope
+type DSSESignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// pre-authentication encoding, what is actually signed
+func dssePAE(payloadType string, payload []byte) []byte {
+	s := fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	return append([]byte(s), payload...)
+}
+
+// on GitHub Actions it's the workflow, otherwise the machine
+func getProvenanceBuilderID() (string, string) {
+	if ref := os.Getenv("GITHUB_WORKFLOW_REF"); ref != "" {
+		id := "https://github.com/" + ref
+		invocation := fmt.Sprintf("https://github.com/%s/actions/runs/%s/attempts/%s", os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"), os.Getenv("GITHUB_RUN_ATTEMPT"))
+		return id, invocation
+	}
+	host, _ := os.Hostname()
+	return provenanceLocalPrefix + strings.ToLower(host), ""
+}
+
+func isProvenanceSubject(kind string) bool {
+	switch kind {
+	case kArtifactProvenance, kArtifactMinisig, kArtifactGpgSig:
+		return false
+	}
+	return true
+}
+
+func genProvenanceStatement(am *ArtifactsManifest) *InTotoStatement {
+	st := &InTotoStatement{
+		Type:          inTotoStatementType,
+		PredicateType: slsaProvenanceType,
+		Predicate:     &SlsaProvenancePredicate{},
+	}
+	for _, a := range am.Artifacts {
+		if !isProvenanceSubject(a.Kind) {
+			continue
+		}
+		st.Subject = append(st.Subject, &InTotoSubject{
+			Name:   a.Name,
+			Digest: map[string]string{"sha256": a.Sha256},
+		})
+	}
+	bd := &st.Predicate.BuildDefinition
+	bd.BuildType = sumatraBuildTypeURI
+	bd.ExternalParameters = map[string]string{
+		"buildType": string(am.BuildType),
+		"version":   am.Version,
+	}
+	bd.InternalParameters = map[string]string{
+		"goVersion": runtime.Version(),
+		"builtOn":   am.BuiltOn,
+	}
+	bd.ResolvedDependencies = []*SlsaResourceDescriptor{
+		{
+			URI:    sumatraRepoGitURI + "@" + am.GitSha1,
+			Digest: map[string]string{"gitCommit": am.GitSha1},
+		},
+	}
+	rd := &st.Predicate.RunDetails
+	rd.Builder.ID, rd.Metadata.InvocationID = getProvenanceBuilderID()
+	rd.Metadata.FinishedOn = time.Now().UTC().Format(time.RFC3339)
+	return st
+}
+
+// returns unsigned envelope if there's no minisign key
+func signProvenance(st *InTotoStatement, key *MinisignKey) *DSSEEnvelope {
+	payload, err := json.Marshal(st)
+	must(err)
+	env := &DSSEEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []*DSSESignature{},
+	}
+	if key == nil {
+		return env
+	}
+	sig := ed25519.Sign(key.PrivKey, dssePAE(inTotoPayloadType, payload))
+	env.Signatures = append(env.Signatures, &DSSESignature{
+		KeyID: key.keyIDHex(),
+		Sig:   base64.StdEncoding.EncodeToString(sig),
+	})
+	return env
+}
+
+func getProvenanceName(am *ArtifactsManifest) string {
+	if am.BuildType == buildTypePreRel {
+		return "SumatraPDF-prerel" + provenanceSuffix
+	}
+	return "SumatraPDF-" + am.Version + provenanceSuffix
+}
+
+// writes provenance for artifacts in am to dir and returns its name.
+// Release builds must be signed
+func writeProvenanceMust(dir string, am *ArtifactsManifest) string {
+	key := getMinisignKeyMust()
+	if key == nil {
+		panicIf(am.BuildType == buildTypeRel, "need MINISIGN_SECRET_KEY to sign provenance of release build")
+		logf("WARNING: provenance is not signed because MINISIGN_SECRET_KEY env variable not set\n")
+	}
+	st := genProvenanceStatement(am)
+	env := signProvenance(st, key)
+	d, err := json.Marshal(env)
+	must(err)
+	name := getProvenanceName(am)
+	// .jsonl is one envelope per line
+	writeFileMust(filepath.Join(dir, name), append(d, '\n'))
+	logf("wrote provenance '%s' for %d files\n", filepath.Join(dir, name), len(st.Subject))
+	return name
+}
+
+func genProvenanceMust(buildType BuildType) {
+	dir := getFinalDirForBuildType(buildType)
+	am := readArtifactsManifestMust(dir)
+	name := writeProvenanceMust(dir, am)
+	addArtifactsToManifestMust(buildType, dir, "", []string{name})
+}
+
+// checks signature with docs/minisign.pub and, if dir is not "", that files
+// in dir match the hashes
+func verifyProvenanceMust(path string, dir string) {
+	var env DSSEEnvelope
+	must(json.Unmarshal(readFileMust(path), &env))
+	panicIf(env.PayloadType != inTotoPayloadType, "unexpected payload type '%s'", env.PayloadType)
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	must(err)
+	pubKey := readMinisignPublicKey()
+	panicIf(pubKey == nil, "'%s' doesn't exist", minisignPublicKeyPath)
+	verified := false
+	for _, s := range env.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err == nil && ed25519.Verify(pubKey, dssePAE(env.PayloadType, payload), sig) {
+			verified = true
+		}
+	}
+	panicIf(!verified, "'%s' is not signed with key in '%s'", path, minisignPublicKeyPath)
+
+	var st InTotoStatement
+	must(json.Unmarshal(payload, &st))
+	panicIf(st.Type != inTotoStatementType || st.PredicateType != slsaProvenanceType, "'%s' is not SLSA provenance", path)
+	bd := st.Predicate.BuildDefinition
+	logf("'%s' has valid signature\n  %s %s built by %s\n", path, bd.ExternalParameters["buildType"], bd.ExternalParameters["version"], st.Predicate.RunDetails.Builder.ID)
+	for _, dep := range bd.ResolvedDependencies {
+		logf("  from %s\n", dep.URI)
+	}
+	if dir == "" {
+		return
+	}
+	nChecked := 0
+	for _, sub := range st.Subject {
+		p := filepath.Join(dir, sub.Name)
+		if !fileExists(p) {
+			continue
+		}
+		got := fileSha256HexMust(p)
+		panicIf(got != sub.Digest["sha256"], "sha256 of '%s' is %s, provenance says %s", p, got, sub.Digest["sha256"])
+		nChecked++
+	}
+	panicIf(nChecked == 0, "no files from '%s' in '%s'", path, dir)
+	logf("%d files in '%s' match provenance\n", nChecked, dir)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every pre-release build is ~100 MB in every storage. We keep:
+//   - the last nPreRelBuildsToKeep builds
+//   - all builds uploaded in the last preRelKeepAllDays days
+//   - the most recent build of every week for builds older than that, so
+//     that there's something to bisect regressions with (and to promote
+//     with -promote-prerel)
+//
+// Pruning happens after every pre-release upload. -prune-prerel does it by hand
+// and, with -dry-run, shows what would be deleted.
+
+const (
+	nPreRelBuildsToKeep = 5
+	preRelKeepAllDays   = 30
+	preRelRemoteDir     = "software/sumatrapdf/prerel/"
+)
+
+// PreRelBuild is a pre-release build in storage
+type PreRelBuild struct {
+	Ver   int
+	Files []*StorageObject
+	// time of the most recently uploaded file
+	UploadedOn time.Time
+	Size       int64
+	Keep       bool
+	Reason     string
+}
+
+// "software/sumatrapdf/prerel/14028/SumatraPDF-prerel-64.pdb.zip" => 14028
+// returns -1 for files not in a build directory (e.g. prerel/sumatralatest.js)
+func extractPreRelVerFromKey(key string) int {
+	rest := strings.TrimPrefix(key, preRelRemoteDir)
+	verStr, _, ok := strings.Cut(rest, "/")
+	if !ok {
+		return -1
+	}
+	ver, err := strconv.Atoi(verStr)
+	if err != nil {
+		return -1
+	}
+	return ver
+}
+
+// sorted by version, newest first
+func groupPreRelFilesByVersion(objects []*StorageObject) []*PreRelBuild {
+	m := map[int]*PreRelBuild{}
+	for _, o := range objects {
+		ver := extractPreRelVerFromKey(o.Key)
+		if ver < 0 {
+			continue
+		}
+		b := m[ver]
+		if b == nil {
+			b = &PreRelBuild{Ver: ver}
+			m[ver] = b
+		}
+		b.Files = append(b.Files, o)
+		b.Size += o.Size
+		if o.LastModified.After(b.UploadedOn) {
+			b.UploadedOn = o.LastModified
+		}
+	}
+	var res []*PreRelBuild
+	for _, b := range m {
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Ver > res[j].Ver
+	})
+	return res
+}
+
+// sets Keep and Reason. builds must be sorted newest first
+func applyPreRelRetention(builds []*PreRelBuild) {
+	keepAllAfter := time.Now().AddDate(0, 0, -preRelKeepAllDays)
+	seenWeeks := map[string]bool{}
+	for i, b := range builds {
+		year, week := b.UploadedOn.ISOWeek()
+		weekKey := fmt.Sprintf("%d-W%02d", year, week)
+		switch {
+		case i < nPreRelBuildsToKeep:
+			b.Keep, b.Reason = true, "recent"
+		case b.UploadedOn.After(keepAllAfter):
+			b.Keep, b.Reason = true, fmt.Sprintf("younger than %d days", preRelKeepAllDays)
+		case !seenWeeks[weekKey]:
+			b.Keep, b.Reason = true, "last of week "+weekKey
+		default:
+			b.Keep, b.Reason = false, "not last of week "+weekKey
+		}
+		// recent builds take the slot of their week
+		seenWeeks[weekKey] = true
+	}
+}
+
+func prunePreRelBuildsMust(storage StorageBackend) {
+	objects, err := storage.List(preRelRemoteDir)
+	must(err)
+	builds := groupPreRelFilesByVersion(objects)
+	applyPreRelRetention(builds)
+
+	logf("%d pre-release builds in '%s'\n", len(builds), storage.URLForPath(preRelRemoteDir))
+	var nDeleted int
+	var sizeDeleted int64
+	for _, b := range builds {
+		what := "keep  "
+		if !b.Keep {
+			what = "delete"
+		}
+		logf("  %s %d uploaded %s, %d files, %s (%s)\n", what, b.Ver, b.UploadedOn.Format("2006-01-02"), len(b.Files), formatSize(b.Size), b.Reason)
+	}
+	for _, b := range builds {
+		if b.Keep {
+			continue
+		}
+		for _, f := range b.Files {
+			must(storage.Remove(f.Key))
+		}
+		nDeleted++
+		sizeDeleted += b.Size
+	}
+	logf("deleted %d builds (%s) from %s\n", nDeleted, formatSize(sizeDeleted), storage.Name())
+}
+
+func prunePreRelBuildsInAllStoragesMust() {
+	ensureAllUploadCreds()
+	// one by one so that the output is readable
+	for _, storage := range getStorageBackends() {
+		prunePreRelBuildsMust(storage)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+// after uploading a release, creates GitHub release and submits it to
+// package managers. Failures are logged but don't fail the release: the
+// files are already uploaded and submission can be re-done with
+// e.g. -github-release or -winget submit
+func publishToPackageManagers() {
+	publish := func(name string, missing string, fn func()) {
+		if missing != "" {
+			logf("publishToPackageManagers: skipping %s because %s is not set\n", name, missing)
+			return
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				logf("publishToPackageManagers: %s failed with '%v'\n", name, r)
+			}
+		}()
+		fn()
+	}
+	missingEnv := func(val string, name string) string {
+		if val == "" {
+			return name
+		}
+		return ""
+	}
+	publish("github release", missingEnv(githubPublishToken, "GITHUB_PUBLISH_TOKEN"), githubReleaseMust)
+	publish("winget", missingEnv(githubPublishToken, "GITHUB_PUBLISH_TOKEN"), func() { wingetMust(true) })
+	publish("scoop", missingEnv(githubPublishToken, "GITHUB_PUBLISH_TOKEN"), func() { scoopMust(true) })
+	publish("chocolatey", missingEnv(chocolateyAPIKey, "CHOCOLATEY_API_KEY"), func() { chocolateyMust(true) })
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// -gen-release-notes ${ver} creates a draft of release notes from commits
+// since the previous release tag. Commits are grouped by what they change
+// and issue / pull request references are turned into links. Translation
+// commits are replaced by a summary of languages whose translations changed
+// (see trans_diff.go).
+//
+// The draft is written to out/release-notes-${ver}.md. It's meant to be
+// edited by hand and then used for Version-history.md, the website and
+// GitHub release.
+
+const githubRepoURL = "https://github.com/sumatrapdfreader/sumatrapdf"
+
+// order in which groups are shown
+const (
+	kNotesFeatures     = "Features"
+	kNotesFormats      = "Document formats"
+	kNotesFixes        = "Bug fixes"
+	kNotesTranslations = "Translations"
+	kNotesOther        = "Other changes"
+)
+
+var releaseNotesGroups = []string{kNotesFeatures, kNotesFormats, kNotesFixes, kNotesTranslations, kNotesOther}
+
+// GitCommit is a commit from git log
+type GitCommit struct {
+	Sha1    string
+	Subject string
+	Body    string
+	Files   []string
+}
+
+// release tags are "3.5.2rel" (older are "3.1rel") or "v3.6"
+var rxReleaseTag = regexp.MustCompile(`^v?(\d+(?:\.\d+)*)(?:rel)?$`)
+
+// returns the most recent release tag for version lower than ver
+func getPrevReleaseTagMust(ver string) string {
+	out := runExeMust("git", "tag", "--list")
+	tag, tagVer := "", ""
+	for _, t := range toTrimmedLines(out) {
+		m := rxReleaseTag.FindStringSubmatch(t)
+		if m == nil || compareVersions(m[1], ver) >= 0 {
+			continue
+		}
+		if tag == "" || compareVersions(m[1], tagVer) > 0 {
+			tag, tagVer = t, m[1]
+		}
+	}
+	panicIf(tag == "", "didn't find release tag for version before '%s'", ver)
+	return tag
+}
+
+// returns commits in revRange (e.g. "3.5.2rel..HEAD"), newest first
+func getGitCommitsMust(revRange string) []*GitCommit {
+	// \x1e separates commits, \x1f separates fields
+	out := runExeMust("git", "log", "--no-merges", "--name-only", "--format=%x1e%H%x1f%s%x1f%b%x1f", revRange)
+	var res []*GitCommit
+	for _, s := range strings.Split(string(out), "\x1e") {
+		parts := strings.Split(s, "\x1f")
+		if len(parts) != 4 {
+			continue
+		}
+		c := &GitCommit{
+			Sha1:    parts[0],
+			Subject: strings.TrimSpace(parts[1]),
+			Body:    strings.TrimSpace(parts[2]),
+			Files:   toTrimmedLines([]byte(parts[3])),
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+func allFilesHavePrefix(files []string, prefixes ...string) bool {
+	for _, f := range files {
+		ok := false
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(f, prefix) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return len(files) > 0
+}
+
+var (
+	rxNotesFix    = regexp.MustCompile(`(?i)\b(fix|fixes|fixed|crash|bug|regression)\b`)
+	rxNotesFormat = regexp.MustCompile(`(?i)\b(pdf|epub|mobi|fb2|djvu|xps|chm|cbz|cbr|cb7|cbt|webp|avif|heic|jxl|tiff?|psd|svg|mupdf)\b`)
+)
+
+// returns "" for commits that are not interesting to users (build, ci, docs)
+func getReleaseNotesGroup(c *GitCommit) string {
+	if allFilesHavePrefix(c.Files, "do/", ".github/", "docs/", "tools/", "premake") {
+		return ""
+	}
+	if allFilesHavePrefix(c.Files, "translations/") {
+		return kNotesTranslations
+	}
+	switch {
+	case rxNotesFix.MatchString(c.Subject):
+		return kNotesFixes
+	case allFilesHavePrefix(c.Files, "mupdf/", "ext/") || rxNotesFormat.MatchString(c.Subject):
+		return kNotesFormats
+	case strings.HasPrefix(strings.ToLower(c.Subject), "add") || strings.Contains(strings.ToLower(c.Subject), "support"):
+		return kNotesFeatures
+	}
+	return kNotesOther
+}
+
+var rxIssueRef = regexp.MustCompile(`(^|[^\w/\[])#(\d+)\b`)
+
+// "fix crash (#123)" => "fix crash ([#123](https://github.com/.../issues/123))"
+// GitHub redirects /issues/N to /pull/N for pull requests
+func linkIssues(s string) string {
+	return rxIssueRef.ReplaceAllString(s, "${1}[#${2}]("+githubRepoURL+"/issues/${2})")
+}
+
+// returns issues mentioned in the body with "fixes #123" etc. that are
+// not in the subject
+func getFixedIssues(c *GitCommit) []string {
+	rx := regexp.MustCompile(`(?i)\b(?:fix|fixes|fixed|close|closes|closed|resolve|resolves|resolved)\s+#(\d+)`)
+	var res []string
+	for _, m := range rx.FindAllStringSubmatch(c.Body, -1) {
+		if !strings.Contains(c.Subject, "#"+m[1]) {
+			res = append(res, "#"+m[1])
+		}
+	}
+	return res
+}
+
+// transSummary replaces translation commits if not empty
+func genReleaseNotes(ver string, prevTag string, commits []*GitCommit, transSummary string) string {
+	groups := map[string][]string{}
+	seen := map[string]bool{}
+	for _, c := range commits {
+		group := getReleaseNotesGroup(c)
+		if group == "" || seen[c.Subject] {
+			continue
+		}
+		seen[c.Subject] = true
+		s := c.Subject
+		if issues := getFixedIssues(c); len(issues) > 0 {
+			s += " (" + strings.Join(issues, ", ") + ")"
+		}
+		groups[group] = append(groups[group], linkIssues(s))
+	}
+	if transSummary != "" {
+		groups[kNotesTranslations] = []string{transSummary}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "### %s\n\n", ver)
+	fmt.Fprintf(&sb, "<!-- draft generated from %d commits since %s, edit before publishing -->\n", len(commits), prevTag)
+	for _, group := range releaseNotesGroups {
+		lines := groups[group]
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n#### %s\n\n", group)
+		for _, l := range lines {
+			fmt.Fprintf(&sb, "- %s\n", l)
+		}
+	}
+	fmt.Fprintf(&sb, "\n[Full list of changes](%s/compare/%s...%s)\n", githubRepoURL, prevTag, getGitSha1Must())
+	return sb.String()
+}
+
+func getReleaseNotesDraftPath(ver string) string {
+	return filepath.Join("out", "release-notes-"+ver+".md")
+}
+
+func genReleaseNotesMust(ver string) {
+	ver = strings.TrimPrefix(ver, "v")
+	panicIf(ver == "", "usage: -gen-release-notes ${ver} e.g. -gen-release-notes 3.6")
+	prevTag := getPrevReleaseTagMust(ver)
+	commits := getGitCommitsMust(prevTag + "..HEAD")
+	deltas := getTranslationsDelta(getEmbeddedTranslationsAtRefMust(prevTag), getEmbeddedTranslationsAtRefMust("HEAD"))
+	s := genReleaseNotes(ver, prevTag, commits, getTransDiffSummary(deltas))
+	path := getReleaseNotesDraftPath(ver)
+	must(os.MkdirAll(filepath.Dir(path), 0755))
+	writeFileMust(path, []byte(s))
+	logf("%s\nwrote release notes draft for %s (%d commits since %s) to '%s'\n", s, ver, len(commits), prevTag, path)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Release build takes hours and used to be all-or-nothing: if e.g. upload
+// failed, we had to re-build everything. Now it's a sequence of steps, each
+// of which can be re-run. After every step we save out/release-state.json.
+// Running -build-release again for the same version and git commit skips
+// steps that are done and resumes from the one that failed.
+//
+// Running without -upload and later with -upload only does the upload steps.
+// To start from scratch, delete out/release-state.json.
+// -release-state prints the state.
+
+const releaseStatePath = "out/release-state.json"
+
+const (
+	kStepRunning = "running"
+	kStepDone    = "done"
+	kStepFailed  = "failed"
+)
+
+// ReleaseStep is a step of release pipeline. fn must be safe to re-run
+// after it failed half-way
+type ReleaseStep struct {
+	Name string
+	fn   func()
+}
+
+// ReleaseStepState is persisted state of a step
+type ReleaseStepState struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ReleaseState is content of release-state.json
+type ReleaseState struct {
+	Version string              `json:"version"`
+	GitSha1 string              `json:"gitSha1"`
+	Steps   []*ReleaseStepState `json:"steps"`
+}
+
+func (s *ReleaseState) findStep(name string) *ReleaseStepState {
+	for _, st := range s.Steps {
+		if st.Name == name {
+			return st
+		}
+	}
+	return nil
+}
+
+func (s *ReleaseState) isDone(name string) bool {
+	st := s.findStep(name)
+	return st != nil && st.Status == kStepDone
+}
+
+// returns nil if doesn't exist
+func readReleaseState() *ReleaseState {
+	d, err := os.ReadFile(releaseStatePath)
+	if err != nil {
+		return nil
+	}
+	var res ReleaseState
+	if err = json.Unmarshal(d, &res); err != nil {
+		logf("ignoring '%s' because failed to parse: '%s'\n", releaseStatePath, err)
+		return nil
+	}
+	return &res
+}
+
+func writeReleaseStateMust(s *ReleaseState) {
+	d, err := json.MarshalIndent(s, "", "  ")
+	must(err)
+	must(createDirForFile(releaseStatePath))
+	writeFileMust(releaseStatePath, d)
+}
+
+// files in out/final-rel might have been changed or deleted since
+// the build step, in which case we can't resume
+func verifyArtifactsUnchangedMust(dir string) {
+	am := readArtifactsManifestMust(dir)
+	for _, a := range am.Artifacts {
+		path := filepath.Join(dir, a.Name)
+		panicIf(!fileExists(path), "can't resume: '%s' doesn't exist. Delete '%s' to start from scratch", path, releaseStatePath)
+		got := fileSha256HexMust(path)
+		panicIf(got != a.Sha256, "can't resume: '%s' changed since it was built. Delete '%s' to start from scratch", path, releaseStatePath)
+	}
+	logf("verified %d files in '%s'\n", len(am.Artifacts), dir)
+}
+
+// state of previous run for the same version and commit or a new state
+func loadReleaseStateForResume(ver string, sha1 string) *ReleaseState {
+	s := readReleaseState()
+	if s == nil {
+		return &ReleaseState{Version: ver, GitSha1: sha1}
+	}
+	if s.Version != ver || s.GitSha1 != sha1 {
+		logf("not resuming: '%s' is for %s (%s), we're building %s (%s)\n", releaseStatePath, s.Version, s.GitSha1, ver, sha1)
+		return &ReleaseState{Version: ver, GitSha1: sha1}
+	}
+	return s
+}
+
+func getReleaseSteps(upload bool) []*ReleaseStep {
+	steps := []*ReleaseStep{
+		{"build", func() {
+			// not resuming so start from scratch. We keep the rest of out/,
+			// including release-state.json
+			dir := getFinalDirForBuildType(buildTypeRel)
+			if dirExists(dir) && !dryRunSkip("delete '%s'", dir) {
+				must(os.RemoveAll(dir))
+			}
+			genHTMLDocsForApp()
+			buildRelease()
+		}},
+		{"sbom", func() { genSbomMust(buildTypeRel) }},
+		{"torrents", func() { genTorrentsMust(buildTypeRel) }},
+		{"provenance", func() { genProvenanceMust(buildTypeRel) }},
+		{"virustotal", func() { scanWithVirusTotalMust(buildTypeRel) }},
+	}
+	if !upload {
+		logf("uploadToStorage: skipping because opts.upload = false\n")
+		return steps
+	}
+	uploadSteps := []*ReleaseStep{
+		{"verify-signatures", func() {
+			verifySignaturesMust(buildTypeRel)
+			verifyCanTagReleaseMust(getVerForBuildType(buildTypeRel), getGitSha1())
+		}},
+		{"upload", func() {
+			waitForUploadWindow()
+			uploadBuildToStoragesMust(buildTypeRel)
+		}},
+		{"purge-cdn", func() { purgeCdnCache(buildTypeRel) }},
+		{"verify-uploaded", func() { verifyUploadedMust(buildTypeRel, "") }},
+		{"symbols", func() { buildAndUploadSymbols(buildTypeRel, true) }},
+		{"tag", tagReleaseBuildMust},
+		{"publish", publishToPackageManagers},
+	}
+	return append(steps, uploadSteps...)
+}
+
+func runReleaseStepsMust(steps []*ReleaseStep) {
+	ver := getVerForBuildType(buildTypeRel)
+	state := loadReleaseStateForResume(ver, getGitSha1())
+	if state.isDone("build") {
+		verifyArtifactsUnchangedMust(getFinalDirForBuildType(buildTypeRel))
+	}
+	for _, step := range steps {
+		if state.isDone(step.Name) {
+			logf("release step '%s': already done, skipping\n", step.Name)
+			continue
+		}
+		st := state.findStep(step.Name)
+		if st == nil {
+			st = &ReleaseStepState{Name: step.Name}
+			state.Steps = append(state.Steps, st)
+		}
+		st.Status = kStepRunning
+		st.StartedAt = time.Now()
+		st.FinishedAt = time.Time{}
+		st.Error = ""
+		writeReleaseStateMust(state)
+		runReleaseStepMust(state, st, step)
+	}
+	logf("release %s: all steps done\n", ver)
+}
+
+func runReleaseStepMust(state *ReleaseState, st *ReleaseStepState, step *ReleaseStep) {
+	defer func() {
+		st.FinishedAt = time.Now()
+		if r := recover(); r != nil {
+			st.Status = kStepFailed
+			st.Error = fmt.Sprintf("%v", r)
+			writeReleaseStateMust(state)
+			logf("release step '%s' failed. Fix the problem and re-run to resume from this step\n", step.Name)
+			panic(r)
+		}
+		st.Status = kStepDone
+		writeReleaseStateMust(state)
+	}()
+	defer makePrintDuration("release step '" + step.Name + "'")()
+	step.fn()
+}
+
+func printReleaseState() {
+	s := readReleaseState()
+	if s == nil {
+		logf("'%s' doesn't exist\n", releaseStatePath)
+		return
+	}
+	logf("release %s (%s)\n", s.Version, s.GitSha1)
+	for _, st := range s.Steps {
+		dur := ""
+		if !st.FinishedAt.IsZero() {
+			dur = " in " + formatDuration(st.FinishedAt.Sub(st.StartedAt))
+		}
+		logf("  %-18s %s%s\n", st.Name, st.Status, dur)
+		if st.Error != "" {
+			logf("    %s\n", st.Error)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Release tags (${ver}rel) are annotated tags signed with GPG_KEY_ID, with
+// release notes as the message. Users can check that the source matches
+// what we released with: git tag -v ${ver}rel
+//
+// -promote-prerel creates, verifies and pushes the tag for the commit of
+// the promoted build. It checks up front (before uploading anything) that
+// the tag can be created so it never gets forgotten. For a regular release
+// it's the "tag" step of -build-release -upload. -tag-release tags the commit
+// of the release build in out/final-rel by hand.
+//
+// The tag must be for a commit that's already pushed, and if it exists it
+// must be signed and point to the commit of the build.
+
+// release notes for the tag message, see getGithubReleaseBody()
+func getReleaseTagMessage(ver string) string {
+	var notes string
+	if path := getGithubReleaseNotesPath(ver); fileExists(path) {
+		notes = string(readFileMust(path))
+	} else {
+		notes, _ = getReleaseNotesFromVersionHistory(ver)
+	}
+	lines := releaseNotesToTxt(&ReleaseNotes{Version: ver, Body: strings.TrimSpace(notes)})
+	// first line is "${ver} (${date})", we want a subject followed by empty line
+	lines = append([]string{"SumatraPDF " + ver, ""}, lines[1:]...)
+	return strings.Join(lines, "\n")
+}
+
+// "tag" for annotated tags, "commit" for lightweight tags
+func getGitObjectTypeMust(ref string) string {
+	return strings.TrimSpace(string(runExeMust("git", "cat-file", "-t", ref)))
+}
+
+func isGitTagExistsMust(tag string) bool {
+	out := runExeMust("git", "tag", "--list", tag)
+	return len(toTrimmedLines(out)) > 0
+}
+
+func isGitCommitPushedMust(sha1 string) bool {
+	out := runExeMust("git", "for-each-ref", "--contains", sha1, "--format=%(refname)", "refs/remotes/origin")
+	return len(toTrimmedLines(out)) > 0
+}
+
+// checks that we can create the tag for sha1, panics otherwise
+func verifyCanTagReleaseMust(ver string, sha1 string) {
+	tag := getGithubReleaseTag(ver)
+	if isGitTagExistsMust(tag) {
+		verifyReleaseTagMust(tag, sha1)
+		return
+	}
+	panicIf(!canGpgSign(), "can't create signed tag %s because GPG_KEY_ID env variable not set or gpg not installed", tag)
+	cmd := exec.Command("git", "cat-file", "-e", sha1+"^{commit}")
+	panicIf(cmd.Run() != nil, "can't create tag %s: commit %s doesn't exist locally, do git fetch", tag, sha1)
+	panicIf(!isGitCommitPushedMust(sha1), "can't create tag %s: commit %s is not in any branch in origin", tag, sha1)
+	// we need release notes for the message
+	getReleaseTagMessage(ver)
+}
+
+// checks that existing tag is signed and points to sha1
+func verifyReleaseTagMust(tag string, sha1 string) {
+	tagSha1 := strings.TrimSpace(string(runExeMust("git", "rev-list", "-n", "1", tag)))
+	panicIf(tagSha1 != sha1, "tag %s already exists and points to %s, not %s", tag, tagSha1, sha1)
+	panicIf(getGitObjectTypeMust(tag) != "tag", "tag %s is not annotated, delete it with:\ngit tag -d %s\nand re-run", tag, tag)
+	runExeMust("git", "tag", "-v", tag)
+	logf("verified signature of tag %s\n", tag)
+}
+
+// creates signed tag ${ver}rel for sha1 (if it doesn't exist), verifies
+// and pushes it
+func tagReleaseMust(ver string, sha1 string) {
+	verifyCanTagReleaseMust(ver, sha1)
+	tag := getGithubReleaseTag(ver)
+	if !isGitTagExistsMust(tag) {
+		if dryRunSkip("create signed tag %s for %s and push it", tag, sha1) {
+			return
+		}
+		msgPath := filepath.Join("out", "tag-message-"+tag+".txt")
+		must(createDirForFile(msgPath))
+		writeFileMust(msgPath, []byte(getReleaseTagMessage(ver)))
+		defer os.Remove(msgPath)
+		runExeMust("git", "tag", "--sign", "--local-user", gpgKeyID, "--file", msgPath, tag, sha1)
+		logf("created signed tag %s for %s\n", tag, sha1)
+		verifyReleaseTagMust(tag, sha1)
+	}
+	runExeMust("git", "push", "origin", "refs/tags/"+tag)
+	logf("pushed tag %s\n", tag)
+}
+
+// -tag-release: tags the commit of release build in out/final-rel
+func tagReleaseBuildMust() {
+	am := readArtifactsManifestMust(getFinalDirForBuildType(buildTypeRel))
+	panicIf(am.BuildType != buildTypeRel, "expected release build, got '%s'", am.BuildType)
+	tagReleaseMust(am.Version, am.GitSha1)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gomarkdown/markdown"
+	mdhtml "github.com/gomarkdown/markdown/html"
+)
+
+// Machine-readable info about releases for users, package maintainers and
+// scripts, written to www directory of website repo when we update the
+// website (-gen-website, download page) or with -gen-website-feeds:
+//   - releases.xml : Atom feed of releases, from docs/md/Version-history.md
+//   - versions.json : latest stable and pre-release with urls and hashes
+//     of files, from update-check.json in storage (needs R2_ACCESS, R2_SECRET)
+//
+// Unlike update-check.json the format of versions.json is meant to be stable
+// so we only add fields.
+
+const (
+	releasesFeedName    = "releases.xml"
+	versionsJSONName    = "versions.json"
+	websiteURL          = "https://www.sumatrapdfreader.org/"
+	versionHistoryURL   = websiteURL + "docs/Version-history"
+	githubReleasesURL   = "https://github.com/sumatrapdfreader/sumatrapdf/releases"
+	nReleasesInFeed     = 20
+	versionsJSONVersion = 1
+)
+
+// all released versions in Version-history.md, newest first
+func readVersionHistoryMust() []*ReleaseNotes {
+	f := readTextFileMust(versionHistoryPath)
+	rxHdr := regexp.MustCompile(`^### (\d+(?:\.\d+)*)(?:\s+\((\d{4}-\d{2}-\d{2})\))?\s*$`)
+	var res []*ReleaseNotes
+	var curr *ReleaseNotes
+	var body []string
+	flush := func() {
+		if curr != nil {
+			curr.Body = strings.TrimSpace(strings.Join(body, "\n"))
+			res = append(res, curr)
+		}
+		curr, body = nil, nil
+	}
+	for _, l := range f.Lines {
+		if isVersionHistorySection(l) {
+			flush()
+			if m := rxHdr.FindStringSubmatch(l); m != nil {
+				curr = &ReleaseNotes{Version: m[1], Date: m[2]}
+			}
+			continue
+		}
+		if curr != nil {
+			body = append(body, l)
+		}
+	}
+	flush()
+	return res
+}
+
+func markdownToHTML(md string) string {
+	r := mdhtml.NewRenderer(mdhtml.RendererOptions{Flags: mdhtml.CommonFlags})
+	return string(markdown.ToHTML([]byte(md), newMarkdownParser(), r))
+}
+
+// AtomFeed is https://www.rfc-editor.org/rfc/rfc4287
+type AtomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Links   []*AtomLink  `xml:"link"`
+	Author  *AtomAuthor  `xml:"author"`
+	Entries []*AtomEntry `xml:"entry"`
+}
+
+// AtomLink is <link> in Atom feed
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// AtomAuthor is <author> in Atom feed
+type AtomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// AtomContent is <content> in Atom entry
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// AtomEntry is a single release
+type AtomEntry struct {
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Link    *AtomLink    `xml:"link"`
+	Content *AtomContent `xml:"content"`
+}
+
+// "2023-10-25" => "2023-10-25T00:00:00Z"
+func atomDate(date string) string {
+	t, err := time.Parse("2006-01-02", date)
+	must(err)
+	return t.UTC().Format(time.RFC3339)
+}
+
+func genReleasesFeed(releases []*ReleaseNotes) string {
+	feed := &AtomFeed{
+		Title: "SumatraPDF releases",
+		ID:    websiteURL + releasesFeedName,
+		Links: []*AtomLink{
+			{Href: websiteURL + releasesFeedName, Rel: "self", Type: "application/atom+xml"},
+			{Href: versionHistoryURL, Rel: "alternate", Type: "text/html"},
+		},
+		Author: &AtomAuthor{Name: "SumatraPDF"},
+	}
+	for _, r := range releases {
+		// very old releases don't have a date
+		if r.Date == "" {
+			continue
+		}
+		if len(feed.Entries) == nReleasesInFeed {
+			break
+		}
+		e := &AtomEntry{
+			Title:   "SumatraPDF " + r.Version,
+			ID:      githubReleasesURL + "/tag/" + getGithubReleaseTag(r.Version),
+			Updated: atomDate(r.Date),
+			Link:    &AtomLink{Href: versionHistoryURL, Rel: "alternate", Type: "text/html"},
+			Content: &AtomContent{Type: "html", Body: markdownToHTML(r.Body)},
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	panicIf(len(feed.Entries) == 0, "no releases in '%s'", versionHistoryPath)
+	feed.Updated = feed.Entries[0].Updated
+	d, err := xml.MarshalIndent(feed, "", "  ")
+	must(err)
+	return xml.Header + string(d) + "\n"
+}
+
+// VersionsJSONChannel is stable or pre-release in versions.json
+type VersionsJSONChannel struct {
+	Version         string        `json:"version"`
+	GitSha1         string        `json:"gitSha1"`
+	BuiltOn         string        `json:"builtOn"`
+	ReleaseNotesURL string        `json:"releaseNotesUrl"`
+	Sha256SumsURL   string        `json:"sha256SumsUrl"`
+	Files           []*UpdateFile `json:"files"`
+}
+
+// VersionsJSON is content of versions.json
+type VersionsJSON struct {
+	FormatVersion int                  `json:"formatVersion"`
+	UpdatedAt     string               `json:"updatedAt"`
+	Stable        *VersionsJSONChannel `json:"stable,omitempty"`
+	PreRelease    *VersionsJSONChannel `json:"prerelease,omitempty"`
+}
+
+func genVersionsJSONChannel(buildType BuildType, ch *UpdateChannel) *VersionsJSONChannel {
+	if ch == nil {
+		return nil
+	}
+	res := &VersionsJSONChannel{
+		Version:         ch.Version,
+		GitSha1:         ch.GitSha1,
+		BuiltOn:         ch.BuiltOn,
+		ReleaseNotesURL: versionHistoryURL,
+		Sha256SumsURL:   getDownloadPrefixViaWebsite(buildType, ch.Version) + sha256SumsName,
+		Files:           ch.Files,
+	}
+	if buildType == buildTypeRel {
+		res.ReleaseNotesURL = githubReleasesURL + "/tag/" + getGithubReleaseTag(ch.Version)
+	}
+	return res
+}
+
+func genVersionsJSON(m *UpdateManifest) []byte {
+	v := &VersionsJSON{
+		FormatVersion: versionsJSONVersion,
+		UpdatedAt:     m.UpdatedAt,
+		Stable:        genVersionsJSONChannel(buildTypeRel, m.Stable),
+		PreRelease:    genVersionsJSONChannel(buildTypePreRel, m.PreRelease),
+	}
+	d, err := json.MarshalIndent(v, "", "  ")
+	must(err)
+	return d
+}
+
+// dir is www directory of website repo
+func writeWebsiteFeedsMust(dir string) {
+	path := filepath.Join(dir, releasesFeedName)
+	writeFileMust(path, []byte(genReleasesFeed(readVersionHistoryMust())))
+	logf("wrote '%s'\n", path)
+
+	if r2Access == "" || r2Secret == "" {
+		logf("Not writing %s because R2_ACCESS or R2_SECRET env variable not set\n", versionsJSONName)
+		return
+	}
+	m := downloadUpdateManifest(newR2Storage())
+	panicIf(m == nil, "%s doesn't exist in storage", updateManifestName)
+	path = filepath.Join(dir, versionsJSONName)
+	writeFileMust(path, genVersionsJSON(m))
+	logf("wrote '%s'\n", path)
+}
+
+func genWebsiteFeedsMust() {
+	writeWebsiteFeedsMust(updateSumatraWebsite())
+	logf("Don't forget to checkin the files and deploy website\n")
+}
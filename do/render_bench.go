@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -render-bench ${dir} renders fixed pages of every document in ${dir} with
+// release 64-bit enginedump.exe (built from current sources) and records
+// load time and render time of every page. Pages are renderBenchPages unless
+// ${dir}/render-bench.txt has "${file} ${pages}" line for a document, e.g.
+// "big.pdf 1-3,50". Every document is rendered renderBenchRuns times and we
+// keep the fastest time, to reduce noise.
+//
+// Results are saved in out/render-bench/${time}-${sha}.json and compared
+// with the previous results.
+//
+// -render-bench-compare ${before.json} ${after.json} compares results of
+// any two builds.
+
+const (
+	renderBenchPages = "1-10"
+	renderBenchRuns  = 3
+	// slower by that much is a regression, as long as it's slower by at
+	// least renderBenchMinRegressionMs
+	renderBenchMaxRegression    = 1.15
+	renderBenchMinRegressionMs  = 5
+	renderBenchDocTimeout       = 5 * time.Minute
+	renderBenchPageSetsFileName = "render-bench.txt"
+)
+
+var renderBenchDir = filepath.Join("out", "render-bench")
+
+// RenderBenchPage is render time of a page
+type RenderBenchPage struct {
+	Page int     `json:"page"`
+	Ms   float64 `json:"ms"`
+}
+
+// RenderBenchDoc are load and render times of a document
+type RenderBenchDoc struct {
+	// relative to corpus directory
+	Path   string             `json:"path"`
+	Pages  string             `json:"pages"`
+	LoadMs float64            `json:"loadMs"`
+	Render []*RenderBenchPage `json:"render"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// RenderBenchResults is the result of -render-bench
+type RenderBenchResults struct {
+	Time   time.Time         `json:"time"`
+	GitSha string            `json:"gitSha"`
+	Docs   []*RenderBenchDoc `json:"docs"`
+}
+
+func (d *RenderBenchDoc) totalRenderMs() float64 {
+	res := 0.0
+	for _, p := range d.Render {
+		res += p.Ms
+	}
+	return res
+}
+
+// parses ${dir}/render-bench.txt, returns file => pages
+func readRenderBenchPageSets(dir string) map[string]string {
+	res := map[string]string{}
+	path := filepath.Join(dir, renderBenchPageSetsFileName)
+	if !fileExists(path) {
+		return res
+	}
+	for _, line := range strings.Split(normalizeNewlines(string(readFileMust(path))), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		panicIf(idx < 0, "invalid line '%s' in '%s', expected '${file} ${pages}'", line, path)
+		res[filepath.ToSlash(strings.TrimSpace(line[:idx]))] = line[idx+1:]
+	}
+	return res
+}
+
+// parses output of enginedump -times:
+// LoadTime ms=12.34
+// RenderTime page=1 ms=56.78
+func parseEngineDumpTimes(out []byte, doc *RenderBenchDoc) {
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		var page int
+		var ms float64
+		if _, err := fmt.Sscanf(line, "LoadTime ms=%f", &ms); err == nil {
+			doc.LoadMs = ms
+		} else if _, err := fmt.Sscanf(line, "RenderTime page=%d ms=%f", &page, &ms); err == nil {
+			doc.Render = append(doc.Render, &RenderBenchPage{Page: page, Ms: ms})
+		}
+	}
+}
+
+func renderBenchDocOnce(engineDump string, path string, doc *RenderBenchDoc) error {
+	tmpDir := filepath.Join(renderBenchDir, "pages")
+	must(os.RemoveAll(tmpDir))
+	must(os.MkdirAll(tmpDir, 0755))
+	defer os.RemoveAll(tmpDir)
+	renderPath := filepath.Join(tmpDir, "page-%d.tga")
+	args := []string{"-loadonly", "-times", "-pages", doc.Pages, "-render", renderPath, path}
+	cmd := exec.Command(engineDump, args...)
+	timer := time.AfterFunc(renderBenchDocTimeout, func() { cmd.Process.Kill() })
+	out, err := cmd.Output()
+	timer.Stop()
+	if err != nil {
+		return fmt.Errorf("'%s' failed with '%s'", fmtCmdShort(*cmd), err)
+	}
+	parseEngineDumpTimes(out, doc)
+	if len(doc.Render) == 0 {
+		return fmt.Errorf("'%s' didn't render any pages", fmtCmdShort(*cmd))
+	}
+	return nil
+}
+
+// renders renderBenchRuns times, keeps the fastest times
+func renderBenchDoc(engineDump string, path string, rel string, pages string) *RenderBenchDoc {
+	var res *RenderBenchDoc
+	for i := 0; i < renderBenchRuns; i++ {
+		doc := &RenderBenchDoc{Path: rel, Pages: pages}
+		if err := renderBenchDocOnce(engineDump, path, doc); err != nil {
+			doc.Error = err.Error()
+			return doc
+		}
+		if res == nil {
+			res = doc
+			continue
+		}
+		res.LoadMs = min(res.LoadMs, doc.LoadMs)
+		for j, p := range doc.Render {
+			if j < len(res.Render) && res.Render[j].Page == p.Page {
+				res.Render[j].Ms = min(res.Render[j].Ms, p.Ms)
+			}
+		}
+	}
+	return res
+}
+
+// returns results saved before, nil if there are none
+func readPrevRenderBenchResults() *RenderBenchResults {
+	paths, _ := filepath.Glob(filepath.Join(renderBenchDir, "*.json"))
+	if len(paths) == 0 {
+		return nil
+	}
+	// names start with time so the last is the most recent
+	sort.Strings(paths)
+	return readRenderBenchResultsMust(paths[len(paths)-1])
+}
+
+func readRenderBenchResultsMust(path string) *RenderBenchResults {
+	var res RenderBenchResults
+	must(json.Unmarshal(readFileMust(path), &res))
+	return &res
+}
+
+func isRenderBenchRegression(before, after float64) bool {
+	return after > before*renderBenchMaxRegression && after-before >= renderBenchMinRegressionMs
+}
+
+func fmtRenderBenchChange(before, after float64) string {
+	if before == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%+.0f%%", (after-before)*100/before)
+}
+
+// returns comparison report and number of regressions
+func compareRenderBenchResults(before, after *RenderBenchResults) (string, int) {
+	var b strings.Builder
+	nRegressions := 0
+	fmt.Fprintf(&b, "render times of %s (%s) => %s (%s)\n\n", before.GitSha, before.Time.Format("2006-01-02"), after.GitSha, after.Time.Format("2006-01-02"))
+	beforeDocs := map[string]*RenderBenchDoc{}
+	for _, d := range before.Docs {
+		beforeDocs[d.Path] = d
+	}
+	var totalBefore, totalAfter float64
+	for _, a := range after.Docs {
+		p := beforeDocs[a.Path]
+		if p == nil || p.Error != "" || a.Error != "" || p.Pages != a.Pages {
+			fmt.Fprintf(&b, "%s: not comparable\n", a.Path)
+			continue
+		}
+		ta, tb := a.totalRenderMs(), p.totalRenderMs()
+		totalBefore += tb + p.LoadMs
+		totalAfter += ta + a.LoadMs
+		fmt.Fprintf(&b, "%s (pages %s): load %.1f => %.1f ms %s, render %.1f => %.1f ms %s\n", a.Path, a.Pages, p.LoadMs, a.LoadMs, fmtRenderBenchChange(p.LoadMs, a.LoadMs), tb, ta, fmtRenderBenchChange(tb, ta))
+		if isRenderBenchRegression(p.LoadMs, a.LoadMs) {
+			fmt.Fprintf(&b, "  REGRESSION: load\n")
+			nRegressions++
+		}
+		beforePages := map[int]float64{}
+		for _, pg := range p.Render {
+			beforePages[pg.Page] = pg.Ms
+		}
+		for _, pg := range a.Render {
+			ms, ok := beforePages[pg.Page]
+			if ok && isRenderBenchRegression(ms, pg.Ms) {
+				fmt.Fprintf(&b, "  REGRESSION: page %d %.1f => %.1f ms %s\n", pg.Page, ms, pg.Ms, fmtRenderBenchChange(ms, pg.Ms))
+				nRegressions++
+			}
+		}
+	}
+	fmt.Fprintf(&b, "\ntotal: %.1f => %.1f ms %s, %d regressions\n", totalBefore, totalAfter, fmtRenderBenchChange(totalBefore, totalAfter), nRegressions)
+	return b.String(), nRegressions
+}
+
+func writeRenderBenchReportMust(before, after *RenderBenchResults) int {
+	report, nRegressions := compareRenderBenchResults(before, after)
+	path := filepath.Join(renderBenchDir, fmt.Sprintf("compare-%s-%s.txt", before.GitSha, after.GitSha))
+	must(createDirForFile(path))
+	writeFileMust(path, []byte(report))
+	logf("%s\nwrote '%s'\n", report, path)
+	return nRegressions
+}
+
+// -render-bench ${dir}|corpus[:${category}]
+func renderBenchMust(dir string) {
+	panicIf(runtime.GOOS != "windows", "-render-bench only works on Windows")
+	dir = getCorpusDirMust(dir)
+	panicIf(dir == "" || !dirExists(dir), "usage: -render-bench ${dir with documents}|corpus[:${category}]")
+	paths := collectMemBenchDocsMust(dir)
+	panicIf(len(paths) == 0, "no documents in '%s'", dir)
+	pageSets := readRenderBenchPageSets(dir)
+
+	buildEngineDump()
+	engineDump := absPathMust(filepath.Join(rel64Dir, "enginedump.exe"))
+	prev := readPrevRenderBenchResults()
+
+	res := &RenderBenchResults{
+		Time:   time.Now(),
+		GitSha: getGitSha1(),
+	}
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		must(err)
+		rel = filepath.ToSlash(rel)
+		pages := pageSets[rel]
+		if pages == "" {
+			pages = renderBenchPages
+		}
+		doc := renderBenchDoc(engineDump, path, rel, pages)
+		if doc.Error != "" {
+			logf("%s: %s\n", rel, doc.Error)
+		} else {
+			logf("%s: load %.1f ms, render %d pages %.1f ms\n", rel, doc.LoadMs, len(doc.Render), doc.totalRenderMs())
+		}
+		push(&res.Docs, doc)
+	}
+
+	d, err := json.MarshalIndent(res, "", "  ")
+	must(err)
+	path := filepath.Join(renderBenchDir, res.Time.Format("2006-01-02_15-04-05")+"-"+res.GitSha+".json")
+	must(createDirForFile(path))
+	writeFileMust(path, d)
+	logf("wrote '%s'\n", path)
+	if prev == nil {
+		logf("no previous results to compare with\n")
+		return
+	}
+	writeRenderBenchReportMust(prev, res)
+}
+
+// -render-bench-compare ${before.json} ${after.json}
+func renderBenchCompareMust(args []string) {
+	panicIf(len(args) != 2, "usage: -render-bench-compare ${before.json} ${after.json}")
+	nRegressions := writeRenderBenchReportMust(readRenderBenchResultsMust(args[0]), readRenderBenchResultsMust(args[1]))
+	panicIf(nRegressions > 0, "%d render time regressions", nRegressions)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -render-diff ${before} ${after} [${dir}|corpus[:${category}]] renders
+// pages of documents with two builds of enginedump.exe and compares them
+// pixel by pixel, so that MuPDF upgrades and rendering changes can be
+// reviewed visually. ${before} and ${after} are paths of enginedump.exe or
+// "current" for one built from current sources. E.g. to check MuPDF update:
+//
+//	copy out\rel64\enginedump.exe out\enginedump-before.exe
+//	(update mupdf)
+//	do -render-diff out\enginedump-before.exe current corpus:pdf
+//
+// Pages are the same as for -render-bench. Documents are from corpus by
+// default.
+//
+// Pixels are compared with YIQ color distance (like pixelmatch), which is
+// closer to how we perceive differences than comparing RGB values, so
+// anti-aliasing noise doesn't count. Pages that differ are shown in
+// out/render-diff/index.html with before, after and diff images.
+
+const (
+	// 0..1, YIQ distance above which pixels differ
+	renderDiffThreshold = 0.1
+	// page differs if more than that many pixels (in %) differ
+	renderDiffMaxPixelsPct = 0.01
+)
+
+var renderDiffDir = filepath.Join("out", "render-diff")
+
+// RenderDiffPage is a page that renders differently
+type RenderDiffPage struct {
+	Doc    string
+	Page   int
+	Before string
+	After  string
+	Diff   string
+	// % of pixels that differ, -1 if sizes differ
+	DiffPct float64
+	Note    string
+}
+
+// max YIQ distance squared, between black and white
+const yiqMaxDelta = 35215.0
+
+func rgbToYIQ(c color.Color) (float64, float64, float64) {
+	r16, g16, b16, a16 := c.RGBA()
+	// blend with white background
+	a := float64(a16) / 0xffff
+	r := 255 + (float64(r16>>8)-255)*a
+	g := 255 + (float64(g16>>8)-255)*a
+	b := 255 + (float64(b16>>8)-255)*a
+	y := r*0.29889531 + g*0.58662247 + b*0.11448223
+	i := r*0.59597799 - g*0.27417610 - b*0.32180189
+	q := r*0.21147017 - g*0.52261711 + b*0.31114694
+	return y, i, q
+}
+
+func yiqDelta(c1, c2 color.Color) float64 {
+	y1, i1, q1 := rgbToYIQ(c1)
+	y2, i2, q2 := rgbToYIQ(c2)
+	dy, di, dq := y1-y2, i1-i2, q1-q2
+	return 0.5053*dy*dy + 0.299*di*di + 0.1957*dq*dq
+}
+
+// returns % of pixels that differ and diff image (different pixels in red
+// over faded after image)
+func diffImages(before, after image.Image) (float64, *image.RGBA) {
+	bounds := after.Bounds()
+	diff := image.NewRGBA(bounds)
+	maxDelta := yiqMaxDelta * renderDiffThreshold * renderDiffThreshold
+	nDiff := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := after.At(x, y)
+			if yiqDelta(before.At(x, y), c) > maxDelta {
+				nDiff++
+				diff.Set(x, y, color.RGBA{255, 0, 0, 255})
+				continue
+			}
+			yv, _, _ := rgbToYIQ(c)
+			v := uint8(255 - (255-yv)*0.1)
+			diff.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	n := bounds.Dx() * bounds.Dy()
+	if n == 0 {
+		return 0, diff
+	}
+	return float64(nDiff) * 100 / float64(n), diff
+}
+
+func readPngMust(path string) image.Image {
+	f, err := os.Open(path)
+	must(err)
+	defer f.Close()
+	img, err := png.Decode(f)
+	must(err)
+	return img
+}
+
+// renders pages of a document as ${dir}/${prefix}-${page}.png
+func renderDiffRender(engineDump string, path string, pages string, dir string, prefix string) error {
+	renderPath := filepath.Join(dir, prefix+"-%d.png")
+	cmd := exec.Command(engineDump, "-loadonly", "-pages", pages, "-render", renderPath, path)
+	timer := time.AfterFunc(renderBenchDocTimeout, func() { cmd.Process.Kill() })
+	out, err := cmd.CombinedOutput()
+	timer.Stop()
+	if err != nil {
+		return fmt.Errorf("'%s' failed with '%s', output:\n%s", fmtCmdShort(*cmd), err, string(out))
+	}
+	return nil
+}
+
+// returns sorted numbers of pages rendered by any build in dir
+func getRenderedPages(dir string) []int {
+	seen := map[int]bool{}
+	var res []int
+	paths, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	must(err)
+	for _, path := range paths {
+		var prefix string
+		var page int
+		name := strings.Replace(filepath.Base(path), "-", " ", 1)
+		if _, err := fmt.Sscanf(name, "%s %d.png", &prefix, &page); err != nil || seen[page] {
+			continue
+		}
+		seen[page] = true
+		res = append(res, page)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// compares pages rendered by both builds, removes images of pages that
+// are the same
+func renderDiffDoc(before, after string, path string, rel string, pages string, dir string) []*RenderDiffPage {
+	must(os.MkdirAll(dir, 0755))
+	errBefore := renderDiffRender(before, path, pages, dir, "before")
+	errAfter := renderDiffRender(after, path, pages, dir, "after")
+	if errBefore != nil || errAfter != nil {
+		// failing in only one build is a difference, failing in both isn't
+		if (errBefore == nil) != (errAfter == nil) {
+			return []*RenderDiffPage{{Doc: rel, DiffPct: -1, Note: fmt.Sprintf("before: %v, after: %v", errBefore, errAfter)}}
+		}
+		logf("%s: failed to render with both builds\n", rel)
+		return nil
+	}
+	var res []*RenderDiffPage
+	for _, page := range getRenderedPages(dir) {
+		p := &RenderDiffPage{
+			Doc:    rel,
+			Page:   page,
+			Before: filepath.Join(dir, fmt.Sprintf("before-%d.png", page)),
+			After:  filepath.Join(dir, fmt.Sprintf("after-%d.png", page)),
+			Diff:   filepath.Join(dir, fmt.Sprintf("diff-%d.png", page)),
+		}
+		hasBefore, hasAfter := fileExists(p.Before), fileExists(p.After)
+		if hasBefore != hasAfter {
+			p.DiffPct = -1
+			p.Note = "rendered by only one build"
+			push(&res, p)
+			continue
+		}
+		imgBefore, imgAfter := readPngMust(p.Before), readPngMust(p.After)
+		if imgBefore.Bounds() != imgAfter.Bounds() {
+			p.DiffPct = -1
+			p.Note = fmt.Sprintf("size changed from %dx%d to %dx%d", imgBefore.Bounds().Dx(), imgBefore.Bounds().Dy(), imgAfter.Bounds().Dx(), imgAfter.Bounds().Dy())
+			push(&res, p)
+			continue
+		}
+		pct, diff := diffImages(imgBefore, imgAfter)
+		if pct <= renderDiffMaxPixelsPct {
+			os.Remove(p.Before)
+			os.Remove(p.After)
+			continue
+		}
+		p.DiffPct = pct
+		writePngMust(p.Diff, diff)
+		push(&res, p)
+	}
+	return res
+}
+
+const renderDiffTmpl = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Render diff</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+.page { margin-bottom: 2em; }
+.imgs { display: flex; gap: 8px; }
+.imgs div { text-align: center; }
+.imgs img { max-width: 32vw; border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+<h2>Render diff</h2>
+<p>{{.Before}} => {{.After}}, {{.NumDocs}} documents, {{len .Pages}} pages differ. Generated on {{.Generated}}.</p>
+{{range .Pages}}
+<div class="page">
+<h3>{{.Doc}}{{if .Page}} page {{.Page}}{{end}}: {{if lt .DiffPct 0.0}}{{.Note}}{{else}}{{printf "%.2f" .DiffPct}}% pixels differ{{end}}</h3>
+{{if .Page}}<div class="imgs">
+<div>before<br><a href="{{rel .Before}}"><img src="{{rel .Before}}"></a></div>
+<div>after<br><a href="{{rel .After}}"><img src="{{rel .After}}"></a></div>
+{{if ge .DiffPct 0.0}}<div>diff<br><a href="{{rel .Diff}}"><img src="{{rel .Diff}}"></a></div>{{end}}
+</div>{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`
+
+func genRenderDiffHTML(before, after string, nDocs int, pages []*RenderDiffPage) string {
+	funcs := template.FuncMap{
+		// paths are relative to index.html
+		"rel": func(path string) string {
+			rel, err := filepath.Rel(renderDiffDir, path)
+			must(err)
+			return filepath.ToSlash(rel)
+		},
+	}
+	tmpl := template.Must(template.New("").Funcs(funcs).Parse(renderDiffTmpl))
+	var buf bytes.Buffer
+	v := map[string]interface{}{
+		"Before":    before,
+		"After":     after,
+		"NumDocs":   nDocs,
+		"Pages":     pages,
+		"Generated": time.Now().Format("2006-01-02 15:04"),
+	}
+	must(tmpl.Execute(&buf, v))
+	return buf.String()
+}
+
+func getRenderDiffEngineDumpMust(arg string) string {
+	if arg == "current" {
+		buildEngineDump()
+		arg = filepath.Join(rel64Dir, "enginedump.exe")
+	}
+	panicIf(!fileExists(arg), "'%s' doesn't exist", arg)
+	return absPathMust(arg)
+}
+
+// -render-diff ${before} ${after} [${dir}|corpus[:${category}]]
+func renderDiffMust(args []string) {
+	panicIf(runtime.GOOS != "windows", "-render-diff only works on Windows")
+	panicIf(len(args) < 2 || len(args) > 3, "usage: -render-diff ${before enginedump.exe}|current ${after enginedump.exe}|current [${dir}|corpus[:${category}]]")
+	docsArg := "corpus"
+	if len(args) == 3 {
+		docsArg = args[2]
+	}
+	dir := getCorpusDirMust(docsArg)
+	paths := collectMemBenchDocsMust(dir)
+	panicIf(len(paths) == 0, "no documents in '%s'", dir)
+	pageSets := readRenderBenchPageSets(dir)
+	before := getRenderDiffEngineDumpMust(args[0])
+	after := getRenderDiffEngineDumpMust(args[1])
+
+	must(os.RemoveAll(renderDiffDir))
+	var diffs []*RenderDiffPage
+	for i, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		must(err)
+		rel = filepath.ToSlash(rel)
+		pages := pageSets[rel]
+		if pages == "" {
+			pages = renderBenchPages
+		}
+		docDir := filepath.Join(renderDiffDir, fmt.Sprintf("%04d", i))
+		res := renderDiffDoc(before, after, path, rel, pages, docDir)
+		logf("%s: %d pages differ\n", rel, len(res))
+		diffs = append(diffs, res...)
+	}
+	path := filepath.Join(renderDiffDir, "index.html")
+	writeFileMust(path, []byte(genRenderDiffHTML(args[0], args[1], len(paths), diffs)))
+	logf("%d pages differ, see '%s'\n", len(diffs), path)
+}
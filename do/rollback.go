@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// -rollback-release [ver] pulls a bad release by making release ${ver}
+// (by default the one before the current stable release) the latest again:
+//   - makes sure all storages have all its files (see -sync-mirrors)
+//   - stable channel in update-check.json
+//   - release version files in storage: those from getRemotePaths() (.js
+//     for the website, release-latest.txt, release-update.txt) and older
+//     sumpdf-update.txt, sumpdf-latest.txt
+//   - download page and its hashes in website repo
+//   - "latest" GitHub release
+//   - purges CDN cache of changed files
+//
+// Files of the bad release are not deleted so links to it keep working.
+
+// returns version in stable channel of update-check.json or "" if there's none
+func getCurrentStableVersion(storage StorageBackend) string {
+	m := downloadUpdateManifest(storage)
+	if m == nil || m.Stable == nil {
+		return ""
+	}
+	return m.Stable.Version
+}
+
+// returns the release just before ver
+func getPrevReleaseVersionMust(storage StorageBackend, ver string) string {
+	for _, v := range listRemoteVersionsMust(storage, buildTypeRel) {
+		if compareVersions(v, ver) < 0 {
+			return v
+		}
+	}
+	panicIf(true, "no release before %s in '%s'", ver, storage.URLBase())
+	return ""
+}
+
+func uploadReleaseLatestInfoMust(storage StorageBackend, am *ArtifactsManifest) {
+	remotePaths := getRemotePaths(buildTypeRel)
+	urls := getDownloadUrlsFromManifest(am, getDownloadPrefixViaWebsite(buildTypeRel, am.Version))
+	files := [][]string{
+		{remotePaths[0], createSumatraLatestJsForBuild(buildTypeRel, am.Version, am.GitSha1, am)},
+		{remotePaths[1], am.Version},
+		{remotePaths[2], genUpdateTxt(urls, am.Version)},
+	}
+	for _, f := range files {
+		err := storage.UploadData(f[0], []byte(f[1]))
+		must(err)
+		logf("Uploaded `%s'\n", storage.URLForPath(f[0]))
+	}
+	uploadAutoUpdateVerMust(storage, am.Version)
+}
+
+func githubMakeLatestReleaseMust(ver string) {
+	rel := githubGetReleaseByTagMust(githubReleaseRepo, getGithubReleaseTag(ver))
+	if rel == nil {
+		logf("no GitHub release for %s\n", ver)
+		return
+	}
+	body := map[string]interface{}{
+		"make_latest": "true",
+	}
+	githubAPIMust(http.MethodPatch, fmt.Sprintf("/repos/%s/releases/%d", githubReleaseRepo, rel.ID), body, rel)
+	logf("GitHub release %s is now latest\n", rel.HTMLURL)
+}
+
+func rollbackReleaseMust(ver string) {
+	defer makePrintDuration("rollbackReleaseMust")()
+	ensureAllUploadCreds()
+	getUpdateSigningKeyMust()
+	backends := getStorageBackends()
+
+	badVer := getCurrentStableVersion(backends[0])
+	if ver == "" {
+		panicIf(badVer == "", "no stable version in %s, must provide version to roll back to", updateManifestName)
+		ver = getPrevReleaseVersionMust(backends[0], badVer)
+	}
+	panicIf(ver == badVer, "%s is already the current release", ver)
+	logf("rolling back release %s to %s\n", badVer, ver)
+
+	// fix missing or different files so that links we point to work
+	syncMirrorsMust(buildTypeRel, ver)
+	am, _ := getMirrorArtifactsManifestMust(backends, buildTypeRel, ver)
+	panicIf(am.BuildType != buildTypeRel, "%s %s is not a release build", artifactsManifestName, ver)
+
+	forEachStorageParallelMust(backends, func(storage StorageBackend) {
+		uploadUpdateChannelMust(storage, buildTypeRel, am)
+		uploadReleaseLatestInfoMust(storage, am)
+	})
+	purgeCdnCache(buildTypeRel)
+	writeWebsiteDownloadFilesMust(am)
+	if githubPublishToken != "" {
+		githubMakeLatestReleaseMust(ver)
+	} else {
+		logf("Not changing latest GitHub release because GITHUB_PUBLISH_TOKEN env variable not set\n")
+	}
+	logf("rolled back release %s to %s\n", badVer, ver)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// -gen-sbom [rel] writes Software Bill of Materials of a build in CycloneDX
+// format (https://cyclonedx.org/specification/overview/) as
+// ${prefix}-sbom.cdx.json in final build directory and adds it to artifacts.json,
+// which uploads it to storage and GitHub release.
+//
+// It lists libraries vendored in ext/ (versions from ext/versions.txt), mupdf
+// (version from fitz/version.h) and Go modules used by the build tool (from
+// do/go.mod).
+
+const sbomSuffix = "-sbom.cdx.json"
+
+// CycloneDXBom is a CycloneDX 1.5 document
+type CycloneDXBom struct {
+	BomFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	SerialNumber string                `json:"serialNumber"`
+	Version      int                   `json:"version"`
+	Metadata     *CycloneDXMetadata    `json:"metadata"`
+	Components   []*CycloneDXComponent `json:"components"`
+}
+
+// CycloneDXMetadata describes the product the SBOM is for
+type CycloneDXMetadata struct {
+	Timestamp string              `json:"timestamp"`
+	Component *CycloneDXComponent `json:"component"`
+}
+
+// CycloneDXComponent is a component in SBOM
+type CycloneDXComponent struct {
+	Type    string `json:"type"`
+	BomRef  string `json:"bom-ref"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// e.g. "pkg:github/madler/zlib@1.2.12"
+	Purl string `json:"purl,omitempty"`
+	// "required" for what we ship, "excluded" for build tools
+	Scope              string                        `json:"scope,omitempty"`
+	Description        string                        `json:"description,omitempty"`
+	Licenses           []*CycloneDXLicense           `json:"licenses,omitempty"`
+	ExternalReferences []*CycloneDXExternalReference `json:"externalReferences,omitempty"`
+}
+
+// CycloneDXLicense is a license of a component
+type CycloneDXLicense struct {
+	License struct {
+		ID string `json:"id"`
+	} `json:"license"`
+}
+
+// CycloneDXExternalReference is e.g. url of a project
+type CycloneDXExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// "https://github.com/madler/zlib/tree/v1.2.12" => "pkg:github/madler/zlib@1.2.12"
+func getPurlForURL(name string, ver string, urls []string) string {
+	for _, s := range urls {
+		uri, err := url.Parse(s)
+		if err != nil || uri.Host != "github.com" {
+			continue
+		}
+		parts := strings.Split(strings.Trim(uri.Path, "/"), "/")
+		if len(parts) >= 2 {
+			return fmt.Sprintf("pkg:github/%s/%s@%s", strings.ToLower(parts[0]), strings.ToLower(strings.TrimSuffix(parts[1], ".git")), url.PathEscape(ver))
+		}
+	}
+	return fmt.Sprintf("pkg:generic/%s@%s", strings.ToLower(name), url.PathEscape(ver))
+}
+
+func getExtSbomComponents() []*CycloneDXComponent {
+	var res []*CycloneDXComponent
+	seen := map[string]bool{}
+	for _, v := range parseExtVersionsMust() {
+		name := strings.TrimPrefix(v.Name, "../")
+		ver := v.Version
+		// versions.txt says "trunk" for mupdf
+		if name == "mupdf" {
+			ver = getMupdfVersion()
+		}
+		c := &CycloneDXComponent{
+			Type:    "library",
+			BomRef:  "ext/" + strings.ToLower(name),
+			Name:    name,
+			Version: ver,
+			Purl:    getPurlForURL(name, ver, v.URLs),
+			Scope:   "required",
+		}
+		if v.Date != "" {
+			c.Description = "vendored on " + v.Date
+		}
+		for _, uri := range v.URLs {
+			c.ExternalReferences = append(c.ExternalReferences, &CycloneDXExternalReference{Type: "website", URL: uri})
+		}
+		seen[strings.ToLower(name)] = true
+		res = append(res, c)
+	}
+	// libraries added to ext/ without updating versions.txt
+	entries, err := os.ReadDir("ext")
+	must(err)
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || strings.HasPrefix(name, "_") || seen[strings.ToLower(name)] {
+			continue
+		}
+		if lib := findExtLibForDir(name); lib != nil && seen[strings.ToLower(lib.Name)] {
+			continue
+		}
+		logf("ext/%s is not in '%s', version unknown\n", name, extVersionsPath)
+		res = append(res, &CycloneDXComponent{
+			Type:    "library",
+			BomRef:  "ext/" + strings.ToLower(name),
+			Name:    name,
+			Version: "unknown",
+			Purl:    getPurlForURL(name, "unknown", nil),
+			Scope:   "required",
+		})
+	}
+	return res
+}
+
+// returns nil if dir is not a library we know how to update
+func findExtLibForDir(dir string) *ExtLib {
+	for _, lib := range extLibs {
+		if strings.EqualFold(lib.Dir, dir) {
+			return lib
+		}
+	}
+	return nil
+}
+
+// returns module => version of all modules in require blocks of go.mod
+func parseGoModRequiresMust(path string) [][]string {
+	var res [][]string
+	inRequire := false
+	for _, l := range strings.Split(normalizeNewlines(string(readFileMust(path))), "\n") {
+		l = strings.TrimSpace(l)
+		switch {
+		case l == "require (":
+			inRequire = true
+			continue
+		case l == ")":
+			inRequire = false
+			continue
+		case strings.HasPrefix(l, "require "):
+			l = strings.TrimPrefix(l, "require ")
+		case !inRequire:
+			continue
+		}
+		parts := strings.Fields(l)
+		if len(parts) >= 2 {
+			res = append(res, []string{parts[0], parts[1]})
+		}
+	}
+	return res
+}
+
+func getGoSbomComponents() []*CycloneDXComponent {
+	var res []*CycloneDXComponent
+	for _, mod := range parseGoModRequiresMust(filepath.Join("do", "go.mod")) {
+		res = append(res, &CycloneDXComponent{
+			Type:    "library",
+			BomRef:  "go/" + mod[0],
+			Name:    mod[0],
+			Version: mod[1],
+			Purl:    fmt.Sprintf("pkg:golang/%s@%s", mod[0], mod[1]),
+			// only used to build and release, not shipped
+			Scope: "excluded",
+		})
+	}
+	return res
+}
+
+func genSbom(buildType BuildType) *CycloneDXBom {
+	ver := getVerForBuildType(buildType)
+	app := &CycloneDXComponent{
+		Type:    "application",
+		BomRef:  "sumatrapdf",
+		Name:    "SumatraPDF",
+		Version: ver,
+		Purl:    fmt.Sprintf("pkg:github/sumatrapdfreader/sumatrapdf@%s", getGitSha1()),
+	}
+	lic := &CycloneDXLicense{}
+	lic.License.ID = "GPL-3.0-only"
+	app.Licenses = []*CycloneDXLicense{lic}
+	app.ExternalReferences = []*CycloneDXExternalReference{
+		{Type: "website", URL: "https://www.sumatrapdfreader.org"},
+		{Type: "vcs", URL: githubRepoURL},
+	}
+
+	components := getExtSbomComponents()
+	components = append(components, getGoSbomComponents()...)
+	sort.SliceStable(components, func(i, j int) bool {
+		return components[i].BomRef < components[j].BomRef
+	})
+	return &CycloneDXBom{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid.NewString(),
+		Version:      1,
+		Metadata: &CycloneDXMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Component: app,
+		},
+		Components: components,
+	}
+}
+
+func getSbomName(buildType BuildType) string {
+	if buildType == buildTypePreRel {
+		return "SumatraPDF-prerel" + sbomSuffix
+	}
+	return "SumatraPDF-" + getVerForBuildType(buildType) + sbomSuffix
+}
+
+func genSbomMust(buildType BuildType) {
+	dir := getFinalDirForBuildType(buildType)
+	readArtifactsManifestMust(dir)
+	bom := genSbom(buildType)
+	d, err := json.MarshalIndent(bom, "", "  ")
+	must(err)
+	name := getSbomName(buildType)
+	writeFileMust(filepath.Join(dir, name), d)
+	addArtifactsToManifestMust(buildType, dir, "", []string{name})
+	logf("wrote '%s' with %d components\n", filepath.Join(dir, name), len(bom.Components))
+}
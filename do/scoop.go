@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// -scoop [submit] updates Scoop manifest (bucket/sumatrapdf.json in
+// ScoopInstaller/Extras) with version, urls and hashes of portable .zip files
+// of release build in out/. With submit it opens a pull request with the
+// change, otherwise just writes it to out/scoop/sumatrapdf.json.
+//
+// The manifest is maintained in the bucket repository so we only update
+// fields that change with a release and preserve the rest, including order
+// of fields.
+
+const (
+	scoopBucketRepo     = "ScoopInstaller/Extras"
+	scoopBucketBranch   = "master"
+	scoopManifestPath   = "bucket/sumatrapdf.json"
+	scoopManifestIndent = "    "
+)
+
+// arch as understood by scoop => our arch
+var scoopArchs = map[string]string{
+	"32bit": "32",
+	"64bit": "64",
+	"arm64": "arm64",
+}
+
+type jsonKV struct {
+	Key string
+	Val json.RawMessage
+}
+
+// JSONOrderedObject is JSON object that remembers order of keys
+type JSONOrderedObject []*jsonKV
+
+func (o *JSONOrderedObject) UnmarshalJSON(d []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(d))
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected JSON object")
+	}
+	*o = nil
+	for dec.More() {
+		t, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		kv := &jsonKV{Key: t.(string)}
+		if err = dec.Decode(&kv.Val); err != nil {
+			return err
+		}
+		*o = append(*o, kv)
+	}
+	return nil
+}
+
+func (o JSONOrderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, kv := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(kv.Val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (o JSONOrderedObject) get(key string) json.RawMessage {
+	for _, kv := range o {
+		if kv.Key == key {
+			return kv.Val
+		}
+	}
+	return nil
+}
+
+// unlike json.Marshal doesn't escape <, > and & so that we don't change
+// fields we didn't touch
+func jsonMarshalNoEscapeMust(v interface{}) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	must(enc.Encode(v))
+	return bytes.TrimSpace(buf.Bytes())
+}
+
+// sets value of existing key or appends a new one
+func (o *JSONOrderedObject) setMust(key string, val interface{}) {
+	d := jsonMarshalNoEscapeMust(val)
+	for _, kv := range *o {
+		if kv.Key == key {
+			kv.Val = d
+			return
+		}
+	}
+	*o = append(*o, &jsonKV{Key: key, Val: d})
+}
+
+func updateScoopManifestMust(d []byte, am *ArtifactsManifest) []byte {
+	panicIf(am.BuildType != buildTypeRel, "scoop manifest can only be updated for release builds, not '%s'", am.BuildType)
+	var m JSONOrderedObject
+	must(json.Unmarshal(d, &m))
+	m.setMust("version", am.Version)
+
+	var archs JSONOrderedObject
+	must(json.Unmarshal(m.get("architecture"), &archs))
+	prefix := getDownloadPrefixViaWebsite(buildTypeRel, am.Version)
+	for _, kv := range archs {
+		arch, ok := scoopArchs[kv.Key]
+		panicIf(!ok, "unknown architecture '%s' in scoop manifest", kv.Key)
+		a := am.find(kArtifactPortableZip, arch)
+		panicIf(a == nil, "no portable .zip for arch '%s' in %s", arch, artifactsManifestName)
+		var entry JSONOrderedObject
+		must(json.Unmarshal(kv.Val, &entry))
+		entry.setMust("url", prefix+a.Name)
+		entry.setMust("hash", a.Sha256)
+		kv.Val = jsonMarshalNoEscapeMust(entry)
+	}
+	m.setMust("architecture", archs)
+
+	v := jsonMarshalNoEscapeMust(m)
+	var buf bytes.Buffer
+	must(json.Indent(&buf, v, "", scoopManifestIndent))
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func scoopMust(submit bool) {
+	panicIf(githubPublishToken == "", "need GITHUB_PUBLISH_TOKEN env variable to read the scoop manifest")
+	am := readArtifactsManifestMust(getFinalDirForBuildType(buildTypeRel))
+	curr := githubGetFileContentMust(scoopBucketRepo, scoopManifestPath, scoopBucketBranch)
+	updated := updateScoopManifestMust(curr, am)
+
+	path := filepath.Join("out", "scoop", filepath.Base(scoopManifestPath))
+	must(createDirForFile(path))
+	writeFileMust(path, updated)
+	logf("wrote updated scoop manifest to '%s'\n", path)
+	if bytes.Equal(curr, updated) {
+		logf("scoop manifest is already up to date\n")
+		return
+	}
+	if !submit {
+		return
+	}
+	pr := &GithubPR{
+		Upstream: scoopBucketRepo,
+		Base:     scoopBucketBranch,
+		Branch:   "sumatrapdf-" + am.Version,
+		Title:    "sumatrapdf: Update to version " + am.Version,
+		Body:     "Submitted by SumatraPDF release tooling.",
+		Files: map[string][]byte{
+			scoopManifestPath: updated,
+		},
+	}
+	githubCreatePRMust(pr)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// -serve-update-check [rel] [ver] serves update info and files of the build
+// in out/ on http://localhost:8123 so that changes to auto-update in the app
+// and to the format of update info can be tested without touching production:
+//   - /update-check.txt : what the app currently reads
+//   - /update-check.json (and .sig if UPDATE_SIGNING_KEY is set)
+//   - /dl/${name} : files of the build
+//
+// ${ver} is the version we advertise (by default version of the build), use
+// a high number to make the app think there's an update.
+// Update info is written to out/update-check-server and read from there on
+// every request so it can be edited while the server runs e.g. to test how
+// the app handles bad data.
+//
+// Run the app with: SumatraPDF.exe -update-check-url http://localhost:8123/update-check.txt
+// and use Help / Check for updates. -update-check-url only works in debug
+// and pre-release builds.
+
+const updateCheckServerAddr = "localhost:8123"
+
+var updateCheckServerDir = filepath.Join("out", "update-check-server")
+
+func writeLocalUpdateInfoMust(am *ArtifactsManifest, ver string, prefix string) {
+	must(os.MkdirAll(updateCheckServerDir, 0755))
+	urls := getDownloadUrlsFromManifest(am, prefix)
+	path := filepath.Join(updateCheckServerDir, "update-check.txt")
+	writeFileMust(path, []byte(genUpdateTxt(urls, ver)))
+	logf("wrote '%s'\n", path)
+
+	ch := genUpdateChannel(am)
+	ch.Version = ver
+	prodPrefix := getDownloadPrefixViaWebsite(am.BuildType, am.Version)
+	for _, f := range ch.Files {
+		f.URL = prefix + strings.TrimPrefix(f.URL, prodPrefix)
+	}
+	if ch.DeltaUpdatesURL != "" {
+		ch.DeltaUpdatesURL = prefix + strings.TrimPrefix(ch.DeltaUpdatesURL, prodPrefix)
+	}
+	m := &UpdateManifest{UpdatedAt: time.Now().UTC().Format(time.RFC3339)}
+	if am.BuildType == buildTypeRel {
+		m.Stable = ch
+	} else {
+		m.PreRelease = ch
+	}
+	d, err := json.MarshalIndent(m, "", "  ")
+	must(err)
+	path = filepath.Join(updateCheckServerDir, updateManifestName)
+	writeFileMust(path, d)
+	logf("wrote '%s'\n", path)
+	if updateSigningKey == "" {
+		logf("Not signing %s because UPDATE_SIGNING_KEY env variable not set\n", updateManifestName)
+		return
+	}
+	path = filepath.Join(updateCheckServerDir, updateManifestSigName)
+	writeFileMust(path, []byte(signUpdateManifestMust(d)))
+	logf("wrote '%s'\n", path)
+}
+
+func serveUpdateCheckMust(buildType BuildType, ver string) {
+	dir := getFinalDirForBuildType(buildType)
+	am := readArtifactsManifestMust(dir)
+	if ver == "" {
+		ver = am.Version
+	}
+	baseURL := "http://" + updateCheckServerAddr + "/"
+	writeLocalUpdateInfoMust(am, ver, baseURL+"dl/")
+
+	mux := http.NewServeMux()
+	mux.Handle("/dl/", http.StripPrefix("/dl/", http.FileServer(http.Dir(dir))))
+	mux.Handle("/", http.FileServer(http.Dir(updateCheckServerDir)))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logf("%s %s\n", r.Method, r.URL)
+		mux.ServeHTTP(w, r)
+	})
+	logf("serving %s %s as version %s on %s\n", buildType, am.Version, ver, baseURL)
+	logf("run: SumatraPDF.exe -update-check-url %supdate-check.txt\nand use Help / Check for updates\n", baseURL)
+	must(http.ListenAndServe(updateCheckServerAddr, handler))
+}
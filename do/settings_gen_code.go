@@ -467,16 +467,11 @@ func updateSumatraWebsite() string {
 	return dir
 }
 
-func genAndSaveSettingsStructs() {
-	websiteDir := updateSumatraWebsite()
-	websiteSettingsDir := filepath.Join(websiteDir, "settings")
+// generates src/Settings.h
+func genAndSaveSettingsHeader() {
 	ver := extractSumatraVersionMust()
 	// this we do to work-around a bug in Cloudflare Pages that doesn't support '.' in file name
 	verUrlized := strings.Replace(ver, ".", "-", -1)
-
-	settingsFileName := fmt.Sprintf("settings%s.html", verUrlized)
-	langsFileName := fmt.Sprintf("langs%s.html", verUrlized)
-
 	helpURI := fmt.Sprintf("For documentation, see https://www.sumatrapdfreader.org/settings/settings%s.html", verUrlized)
 
 	globalPrefs[0].Comment = helpURI
@@ -503,6 +498,19 @@ func genAndSaveSettingsStructs() {
 	detectClangFormat()
 	clangFormatFile(path)
 	fmt.Printf("Wrote '%s'\n", path)
+}
+
+func genAndSaveSettingsStructs() {
+	websiteDir := updateSumatraWebsite()
+	websiteSettingsDir := filepath.Join(websiteDir, "settings")
+	ver := extractSumatraVersionMust()
+	// this we do to work-around a bug in Cloudflare Pages that doesn't support '.' in file name
+	verUrlized := strings.Replace(ver, ".", "-", -1)
+
+	settingsFileName := fmt.Sprintf("settings%s.html", verUrlized)
+	langsFileName := fmt.Sprintf("langs%s.html", verUrlized)
+
+	genAndSaveSettingsHeader()
 
 	genLangsHTML := func() {
 		var langs []*Lang
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SHA256SUMS.txt has sha256 of all files of a build in the format
+// of sha256sum so that users and packagers can verify downloads with:
+// sha256sum --ignore-missing -c SHA256SUMS.txt
+//
+// It's re-generated every time we write artifacts.json so they're always
+// in sync and uploaded next to the files. The hashes are also published
+// on the website: in sumatralatest.js for pre-release builds and, for
+// release builds, in sha256sums-rel.js in website repo (-update-website-sha256sums)
+
+const sha256SumsName = "SHA256SUMS.txt"
+
+func getSha256SumsPath(dir string) string {
+	return filepath.Join(dir, sha256SumsName)
+}
+
+func genSha256Sums(am *ArtifactsManifest) string {
+	artifacts := append([]*ArtifactInfo{}, am.Artifacts...)
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].Name < artifacts[j].Name
+	})
+	var sb strings.Builder
+	for _, a := range artifacts {
+		fmt.Fprintf(&sb, "%s  %s\n", a.Sha256, a.Name)
+	}
+	return sb.String()
+}
+
+func writeSha256SumsMust(dir string, am *ArtifactsManifest) {
+	path := getSha256SumsPath(dir)
+	writeFileMust(path, []byte(genSha256Sums(am)))
+	logf("wrote '%s'\n", path)
+}
+
+// SHA256SUMS.txt as ArtifactInfo so that it can be uploaded and verified
+// like other files. It's not in artifacts.json because it's derived from it
+func getSha256SumsArtifactMust(dir string) *ArtifactInfo {
+	path := getSha256SumsPath(dir)
+	return &ArtifactInfo{
+		Name:   sha256SumsName,
+		Path:   filepath.ToSlash(path),
+		Size:   fileSizeMust(path),
+		Sha256: fileSha256HexMust(path),
+	}
+}
+
+// javascript for download page:
+// var ${prefix}Sha256Sums = "${host}/SHA256SUMS.txt";
+// var ${prefix}Sha256 = { "SumatraPDF-3.5-64-install.exe": "..." };
+func genSha256Js(am *ArtifactsManifest, prefix string, host string) string {
+	m := map[string]string{}
+	for _, a := range am.Artifacts {
+		if a.Kind == kArtifactDeltaUpdate || a.Kind == kArtifactDeltaIndex || a.Kind == kArtifactPdbLzsa {
+			continue
+		}
+		m[a.Name] = a.Sha256
+	}
+	d, err := json.MarshalIndent(m, "", "  ")
+	must(err)
+	s := fmt.Sprintf("var %sSha256Sums = \"%s/%s\";\n", prefix, host, sha256SumsName)
+	s += fmt.Sprintf("var %sSha256 = %s;\n", prefix, string(d))
+	return s
+}
+
+func updateWebsiteSha256SumsMust() {
+	am := readArtifactsManifestMust(getFinalDirForBuildType(buildTypeRel))
+	panicIf(am.BuildType != buildTypeRel, "expected release build in '%s', got '%s'", getFinalDirForBuildType(buildTypeRel), am.BuildType)
+	writeWebsiteDownloadFilesMust(am)
+}
+
+// writes sha256sums-rel.js, download page, releases feed and versions.json
+func writeWebsiteDownloadFilesMust(am *ArtifactsManifest) {
+	dir := updateSumatraWebsite()
+	host := strings.TrimSuffix(getDownloadPrefixViaWebsite(buildTypeRel, am.Version), "/")
+	s := fmt.Sprintf("// generated by: .\\doit.bat -update-website-sha256sums\nvar sumRelVer = \"%s\";\n", am.Version)
+	s += genSha256Js(am, "sumRel", host)
+	path := filepath.Join(dir, "sha256sums-rel.js")
+	writeFileMust(path, []byte(s))
+	logf("wrote '%s'\n", path)
+	writeDownloadPageMust(dir, am)
+	writeWebsiteFeedsMust(dir)
+	logf("Don't forget to checkin the files and deploy website\n")
+}
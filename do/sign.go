@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,10 +20,101 @@ func runCmdLoggedRedacted(cmd *exec.Cmd, redact string) error {
 	return cmd.Run()
 }
 
+// Signing backends, selected with SIGN_BACKEND (in secrets file or env variable):
+//   - "cert" (default) : do/scripts/cert.pfx, password in CERT_PWD
+//   - "azure" : Azure Trusted Signing, which doesn't require a local .pfx so
+//     can be used in CI. Needs Trusted Signing Client Tools
+//     (Azure.CodeSigning.Dlib.dll), AZURE_SIGN_ENDPOINT, AZURE_SIGN_ACCOUNT,
+//     AZURE_SIGN_PROFILE and credentials of service principal in
+//     AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET
+//     https://learn.microsoft.com/en-us/azure/trusted-signing/how-to-signing-integrations
+const (
+	signBackendCert  = "cert"
+	signBackendAzure = "azure"
+)
+
+var (
+	signBackend string
+	// comma-separated list of rfc 3161 timestamp servers, over-rides defaults
+	signTimestampServers string
+
+	azureSignEndpoint string
+	azureSignAccount  string
+	azureSignProfile  string
+	azureSignDlib     string
+	azureTenantID     string
+	azureClientID     string
+	azureClientSecret string
+)
+
+// Signer signs executables with Authenticode signature
+type Signer interface {
+	Name() string
+	// returns description of missing configuration or "" if can sign
+	Missing() string
+	// a single attempt at signing a file, without timestamping
+	Sign(path string) error
+	// number of signatures added by Sign(), each needs a timestamp
+	SignaturesCount(path string) int
+	// rfc 3161 timestamp server to try first
+	PreferredTimestampServer() string
+}
+
+func getSigner() Signer {
+	switch strings.ToLower(signBackend) {
+	case "", signBackendCert:
+		return &CertFileSigner{}
+	case signBackendAzure:
+		return &AzureTrustedSigner{}
+	}
+	panicIf(true, "unknown SIGN_BACKEND '%s', must be '%s' or '%s'", signBackend, signBackendCert, signBackendAzure)
+	return nil
+}
+
+// true if we have what's needed to sign with configured backend
+func canSign() bool {
+	return getSigner().Missing() == ""
+}
+
 func hasCertPwd() bool {
 	return strings.TrimSpace(certPwd) != ""
 }
 
+// CertFileSigner signs with certificate in do/scripts/cert.pfx
+type CertFileSigner struct{}
+
+func (s *CertFileSigner) Name() string {
+	return signBackendCert
+}
+
+func (s *CertFileSigner) Missing() string {
+	if !hasCertPwd() {
+		return "CERT_PWD env variable not set"
+	}
+	return ""
+}
+
+func isPeFileName(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".exe" || ext == ".dll"
+}
+
+// .msi and .msix files can only have a single signature
+func supportsDualSignature(path string) bool {
+	return isPeFileName(path)
+}
+
+func (s *CertFileSigner) SignaturesCount(path string) int {
+	if supportsDualSignature(path) {
+		return 2
+	}
+	return 1
+}
+
+func (s *CertFileSigner) PreferredTimestampServer() string {
+	return "http://timestamp.sectigo.com"
+}
+
 // https://zabkat.com/blog/code-signing-sha1-armageddon.htm
 // signtool sign /n "subject name" /t http://timestamp.comodoca.com/authenticode myInstaller.exe
 // signtool sign /n "subject name" /fd sha256 /tr http://timestamp.comodoca.com/rfc3161 /td sha256 /as myInstaller.exe
@@ -34,52 +127,238 @@ func hasCertPwd() bool {
 //	/td ${alg}   : for /tr, must be after /tr
 //	/du ${url}   : URL for expanded description of the signed content.
 //	/debug       : show debugging info
-func signMust(path string) {
+func (s *CertFileSigner) Sign(path string) error {
 	// the sign tool is finicky, so copy the cert to the same dir as
 	// the exe we're signing
+	signtoolPath := detectSigntoolPath()
+	fileDir := filepath.Dir(path)
+	fileName := filepath.Base(path)
+	certSrc := filepath.Join("do", "scripts", "cert.pfx")
+	certDest := filepath.Join(fileDir, "cert.pfx")
+	must(copyFile(certDest, certSrc))
+	desc := "https://www.sumatrapdfreader.org"
+	if !supportsDualSignature(path) {
+		cmd := exec.Command(signtoolPath, "sign", "/fd", "sha256",
+			"/du", desc, "/f", "cert.pfx",
+			"/p", certPwd, fileName)
+		cmd.Dir = fileDir
+		return runCmdLoggedRedacted(cmd, certPwd)
+	}
+	{
+		// sign with sha1 for pre-win-7
+		// TODO: remove it? We no longer support pre-win7
+		cmd := exec.Command(signtoolPath, "sign",
+			"/du", desc, "/f", "cert.pfx", "/fd", "sha1",
+			"/p", certPwd, fileName)
+		cmd.Dir = fileDir
+		if err := runCmdLoggedRedacted(cmd, certPwd); err != nil {
+			return err
+		}
+	}
 
-	if certPwd == "" {
-		if flgSkipSign {
-			return
+	// double-sign with sha2 for win7+ ater Jan 2016
+	cmd := exec.Command(signtoolPath, "sign", "/fd", "sha256",
+		"/du", desc, "/f", "cert.pfx",
+		"/p", certPwd, "/as", fileName)
+	cmd.Dir = fileDir
+	return runCmdLoggedRedacted(cmd, certPwd)
+}
+
+// AzureTrustedSigner signs with Azure Trusted Signing via signtool /dlib
+type AzureTrustedSigner struct{}
+
+func (s *AzureTrustedSigner) Name() string {
+	return signBackendAzure
+}
+
+func getAzureSignDlibPath() string {
+	if azureSignDlib != "" {
+		return azureSignDlib
+	}
+	// default install location of Trusted Signing Client Tools
+	dir := os.Getenv("LOCALAPPDATA")
+	return filepath.Join(dir, "Microsoft", "MicrosoftTrustedSigningClientTools", "Azure.CodeSigning.Dlib.dll")
+}
+
+func (s *AzureTrustedSigner) Missing() string {
+	var missing []string
+	vars := []struct {
+		name string
+		val  string
+	}{
+		{"AZURE_SIGN_ENDPOINT", azureSignEndpoint},
+		{"AZURE_SIGN_ACCOUNT", azureSignAccount},
+		{"AZURE_SIGN_PROFILE", azureSignProfile},
+		{"AZURE_TENANT_ID", azureTenantID},
+		{"AZURE_CLIENT_ID", azureClientID},
+		{"AZURE_CLIENT_SECRET", azureClientSecret},
+	}
+	for _, v := range vars {
+		if strings.TrimSpace(v.val) == "" {
+			missing = append(missing, v.name)
 		}
 	}
-	panicIf(certPwd == "", "CERT_PWD env variable not set")
+	if len(missing) > 0 {
+		return strings.Join(missing, ", ") + " env variables not set"
+	}
+	if path := getAzureSignDlibPath(); !fileExists(path) {
+		return fmt.Sprintf("'%s' doesn't exist. Install Trusted Signing Client Tools or set AZURE_SIGN_DLIB", path)
+	}
+	return ""
+}
 
-	// retry 3 times because signing might fail due to temorary error
-	// ("The specified timestamp server either could not be reached or")
-	var err error
-	for i := 0; i < 3; i++ {
-		signtoolPath := detectSigntoolPath()
-		fileDir := filepath.Dir(path)
-		fileName := filepath.Base(path)
-		certSrc := filepath.Join("do", "scripts", "cert.pfx")
-		certDest := filepath.Join(fileDir, "cert.pfx")
-		must(copyFile(certDest, certSrc))
-		//signServer := "http://timestamp.verisign.com/scripts/timstamp.dll"
-		signServer := "http://timestamp.sectigo.com"
-		desc := "https://www.sumatrapdfreader.org"
-		{
-			// sign with sha1 for pre-win-7
-			// TODO: remove it? We no longer support pre-win7
-			cmd := exec.Command(signtoolPath, "sign", "/t", signServer,
-				"/du", desc, "/f", "cert.pfx", "/fd", "sha1",
-				"/p", certPwd, fileName)
-			cmd.Dir = fileDir
-			err = runCmdLoggedRedacted(cmd, certPwd)
+// metadata.json tells the dlib which account and certificate profile to use
+func writeAzureSignMetadataMust() string {
+	v := map[string]string{
+		"Endpoint":               azureSignEndpoint,
+		"CodeSigningAccountName": azureSignAccount,
+		"CertificateProfileName": azureSignProfile,
+	}
+	d, err := json.MarshalIndent(v, "", "  ")
+	must(err)
+	path := filepath.Join("out", "azure-sign-metadata.json")
+	must(createDirForFile(path))
+	writeFileMust(path, d)
+	return absPathMust(path)
+}
+
+// Azure Trusted Signing only supports sha256
+func (s *AzureTrustedSigner) SignaturesCount(path string) int {
+	return 1
+}
+
+func (s *AzureTrustedSigner) PreferredTimestampServer() string {
+	return "http://timestamp.acs.microsoft.com"
+}
+
+func (s *AzureTrustedSigner) Sign(path string) error {
+	signtoolPath := detectSigntoolPath()
+	metadataPath := writeAzureSignMetadataMust()
+	desc := "https://www.sumatrapdfreader.org"
+	cmd := exec.Command(signtoolPath, "sign", "/fd", "sha256",
+		"/du", desc, "/dlib", getAzureSignDlibPath(),
+		"/dmdf", metadataPath, path)
+	// the dlib authenticates with DefaultAzureCredential, which reads
+	// credentials from env variables. They might come from secrets file
+	// so we have to set them explicitly
+	cmd.Env = append(os.Environ(),
+		"AZURE_TENANT_ID="+azureTenantID,
+		"AZURE_CLIENT_ID="+azureClientID,
+		"AZURE_CLIENT_SECRET="+azureClientSecret,
+	)
+	return runCmdLoggedRedacted(cmd, azureClientSecret)
+}
+
+// rfc 3161 timestamp servers we try, in order, after the signer's preferred one
+var defaultTimestampServers = []string{
+	"http://timestamp.sectigo.com",
+	"http://timestamp.digicert.com",
+	"http://timestamp.globalsign.com/tsa/r6advanced1",
+	"http://ts.ssl.com",
+	"http://timestamp.acs.microsoft.com",
+}
+
+func getTimestampServers(signer Signer) []string {
+	servers := defaultTimestampServers
+	if signTimestampServers != "" {
+		servers = nil
+		for _, s := range strings.Split(signTimestampServers, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				servers = append(servers, s)
+			}
+		}
+	}
+	res := []string{signer.PreferredTimestampServer()}
+	for _, s := range servers {
+		if !stringInSlice(res, s) {
+			res = append(res, s)
 		}
+	}
+	return res
+}
 
+// calls fn up to maxAttempts times, sleeping 5s, 10s, 20s... between attempts
+func retryWithBackoff(what string, maxAttempts int, fn func() error) error {
+	delay := 5 * time.Second
+	var err error
+	for i := 0; i < maxAttempts; i++ {
+		err = fn()
 		if err == nil {
-			// double-sign with sha2 for win7+ ater Jan 2016
-			cmd := exec.Command(signtoolPath, "sign", "/fd", "sha256", "/tr", signServer,
-				"/td", "sha256", "/du", desc, "/f", "cert.pfx",
-				"/p", certPwd, "/as", fileName)
-			cmd.Dir = fileDir
-			err = runCmdLoggedRedacted(cmd, certPwd)
+			return nil
+		}
+		if i < maxAttempts-1 {
+			logf("%s failed with '%s', attempt %d of %d, retrying in %s\n", what, err, i+1, maxAttempts, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+// timestamps signature at index sigIdx, trying timestamp servers in order
+func timestampSignature(path string, sigIdx int, servers []string) error {
+	signtoolPath := detectSigntoolPath()
+	var errs []string
+	for _, server := range servers {
+		// /tp : index of signature to timestamp, for dual-signed files
+		cmd := exec.Command(signtoolPath, "timestamp", "/tr", server, "/td", "sha256", "/tp", strconv.Itoa(sigIdx), path)
+		if dryRunSkipCmd(cmd) {
+			return nil
 		}
+		out, err := cmd.CombinedOutput()
 		if err == nil {
+			logf("timestamped signature %d of '%s' with '%s'\n", sigIdx, path, server)
+			return nil
+		}
+		logf("timestamp server '%s' failed with '%s'. Output:\n%s\n", server, err, string(out))
+		errs = append(errs, server+": "+err.Error())
+	}
+	return fmt.Errorf("all timestamp servers failed: %s", strings.Join(errs, ", "))
+}
+
+// returns number of timestamped signatures in a file
+func countTimestampedSignatures(path string) (int, error) {
+	signtoolPath := detectSigntoolPath()
+	// /pa : use default authenticode verification policy
+	// /all : verify all signatures
+	// /v : prints "The signature is timestamped: ${date}" for each signature
+	cmd := exec.Command(signtoolPath, "verify", "/pa", "/all", "/v", path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("signtool verify '%s' failed with '%s'. Output:\n%s", path, err, string(out))
+	}
+	return strings.Count(string(out), "The signature is timestamped:"), nil
+}
+
+func timestampAndVerifyMust(signer Signer, path string) {
+	servers := getTimestampServers(signer)
+	nSigs := signer.SignaturesCount(path)
+	for i := 0; i < nSigs; i++ {
+		err := retryWithBackoff("timestamp", 4, func() error {
+			return timestampSignature(path, i, servers)
+		})
+		must(err)
+	}
+	n, err := countTimestampedSignatures(path)
+	must(err)
+	panicIf(n < nSigs, "'%s' has %d timestamped signatures, expected %d", path, n, nSigs)
+}
+
+func signMust(path string) {
+	signer := getSigner()
+	if missing := signer.Missing(); missing != "" {
+		if flgSkipSign {
 			return
 		}
-		time.Sleep(time.Second * 15)
+		panicIf(true, "can't sign '%s' with '%s' backend: %s", path, signer.Name(), missing)
 	}
+
+	// signing and timestamping are separate steps so that temporary failure
+	// of a timestamp server doesn't require re-signing and we can try
+	// other servers
+	err := retryWithBackoff("sign", 3, func() error {
+		return signer.Sign(path)
+	})
 	must(err)
+	timestampAndVerifyMust(signer, path)
 }
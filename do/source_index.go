@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Source indexing adds srcsrv stream to .pdb files. It maps source files
+// compiled into the binary to a url on GitHub at the exact commit we built from.
+// With source-indexed .pdb debuggers (WinDbg, Visual Studio) download
+// the right source files automatically, no need to have a checkout.
+// https://learn.microsoft.com/en-us/windows-hardware/drivers/debugger/source-server-and-source-indexing
+
+const githubRawURLBase = "https://raw.githubusercontent.com/sumatrapdfreader/sumatrapdf"
+
+var debuggersDir = `C:\Program Files (x86)\Windows Kits\10\Debuggers\x64`
+
+func detectSrcsrvToolMust(name string) string {
+	path := filepath.Join(debuggersDir, "srcsrv", name)
+	panicIf(!fileExists(path), "didn't find '%s'. Install Debugging Tools for Windows", path)
+	return path
+}
+
+// if HEAD is tagged, we prefer tag because it's more readable
+// and survives re-writing history
+func getSourceIndexGitRef() string {
+	cmd := exec.Command("git", "describe", "--exact-match", "--tags", "HEAD")
+	out, err := cmd.Output()
+	if err == nil {
+		tag := strings.TrimSpace(string(out))
+		if tag != "" {
+			return tag
+		}
+	}
+	return getGitSha1()
+}
+
+// returns set of files (relative to top of repo, with / as separator) in git
+func getGitTrackedFilesMust() map[string]bool {
+	out := runExeMust("git", "ls-files")
+	res := map[string]bool{}
+	for _, l := range toTrimmedLines(out) {
+		res[l] = true
+	}
+	return res
+}
+
+// returns source files compiled into .pdb, as absolute paths
+func listPdbSourceFilesMust(pdbPath string) []string {
+	srctool := detectSrcsrvToolMust("srctool.exe")
+	// -r : dump raw source data
+	cmd := exec.Command(srctool, "-r", pdbPath)
+	// srctool returns number of files as exit code so we can't rely on it
+	out, _ := cmd.Output()
+	var res []string
+	for _, l := range toTrimmedLines(out) {
+		if filepath.IsAbs(l) {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+func genSrcsrvStream(files []string, topDir string, gitRef string, tracked map[string]bool) (string, int) {
+	lines := []string{
+		"SRCSRV: ini ------------------------------------------------",
+		"VERSION=2",
+		"VERCTRL=http",
+		"SRCSRV: variables ------------------------------------------",
+		"SRCSRVVERCTRL=https",
+		fmt.Sprintf("SRCSRVTRG=%s/%%var2%%/%%var3%%", githubRawURLBase),
+		"SRCSRV: source files ---------------------------------------",
+	}
+	nIndexed := 0
+	topDirLower := strings.ToLower(topDir) + `\`
+	for _, path := range files {
+		if !strings.HasPrefix(strings.ToLower(path), topDirLower) {
+			// system headers, CRT etc.
+			continue
+		}
+		rel := filepath.ToSlash(path[len(topDirLower):])
+		if !tracked[rel] {
+			// generated files
+			continue
+		}
+		// ${localPath}*${var2}*${var3}
+		s := fmt.Sprintf("%s*%s*%s", path, gitRef, rel)
+		lines = append(lines, s)
+		nIndexed++
+	}
+	lines = append(lines, "SRCSRV: end ------------------------------------------------")
+	return strings.Join(lines, "\r\n") + "\r\n", nIndexed
+}
+
+func sourceIndexPdbMust(pdbPath string, gitRef string, tracked map[string]bool) {
+	topDir := currDirAbsMust()
+	files := listPdbSourceFilesMust(pdbPath)
+	stream, nIndexed := genSrcsrvStream(files, topDir, gitRef, tracked)
+	panicIf(nIndexed == 0, "sourceIndexPdb: no source files from '%s' to index in '%s'", topDir, pdbPath)
+
+	streamPath := pdbPath + ".srcsrv.txt"
+	writeFileMust(streamPath, []byte(stream))
+	defer os.Remove(streamPath)
+
+	pdbstr := detectSrcsrvToolMust("pdbstr.exe")
+	cmd := exec.Command(pdbstr, "-w", "-p:"+pdbPath, "-s:srcsrv", "-i:"+streamPath)
+	runCmdLoggedMust(cmd)
+	logf("source indexed '%s': %d out of %d files, ref: '%s'\n", pdbPath, nIndexed, len(files), gitRef)
+}
+
+func sourceIndexPdbsInDirMust(dir string) {
+	if !fileExists(filepath.Join(debuggersDir, "srcsrv", "pdbstr.exe")) {
+		logf("sourceIndexPdbs: skipping because Debugging Tools for Windows are not installed in '%s'\n", debuggersDir)
+		return
+	}
+	gitRef := getSourceIndexGitRef()
+	tracked := getGitTrackedFilesMust()
+	for _, name := range pdbFiles {
+		path := filepath.Join(dir, name)
+		if !fileExists(path) {
+			continue
+		}
+		sourceIndexPdbMust(path, gitRef, tracked)
+	}
+}
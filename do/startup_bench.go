@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -startup-bench [baseline] [${file}] measures how long it takes release
+// 64-bit SumatraPDF.exe to first paint its window, with start page and,
+// if given, with ${file}.
+//
+// SumatraPDF is launched with -first-paint-marker ${path}, which makes it
+// write milliseconds since the process started to ${path} on first paint
+// (of the document, if it opens one) and exit.
+//
+// Cold runs use fresh settings every time and, if RAMMap64.exe is in PATH
+// (and we're elevated), empty file cache before launching. Warm runs re-use
+// settings after an unmeasured launch.
+//
+// Baselines are per-machine, in out/startup-bench/baseline.json. With
+// "baseline" results become the new baseline, otherwise we compare with
+// it and fail if median of a series regressed significantly.
+
+const (
+	startupBenchRuns = 10
+	// median must be that much slower than baseline and by at least
+	// startupBenchMinRegressionMs to be a regression, to not fail on noise
+	startupBenchMaxRegression   = 1.15
+	startupBenchMinRegressionMs = 20
+)
+
+var startupBenchDir = filepath.Join("out", "startup-bench")
+
+// StartupBenchSeries are times to first paint of runs of one kind
+type StartupBenchSeries struct {
+	Name string `json:"name"`
+	Runs []int  `json:"runs"`
+	// in milliseconds
+	Median int `json:"median"`
+	Min    int `json:"min"`
+	Max    int `json:"max"`
+}
+
+// StartupBenchResults is the result of -startup-bench
+type StartupBenchResults struct {
+	Time   time.Time             `json:"time"`
+	GitSha string                `json:"gitSha"`
+	Series []*StartupBenchSeries `json:"series"`
+}
+
+func newStartupBenchSeries(name string, runs []int) *StartupBenchSeries {
+	sorted := append([]int{}, runs...)
+	sort.Ints(sorted)
+	return &StartupBenchSeries{
+		Name:   name,
+		Runs:   runs,
+		Median: sorted[len(sorted)/2],
+		Min:    sorted[0],
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// empties standby list i.e. file cache, if we can
+func emptyFileCache() bool {
+	if _, err := exec.LookPath("RAMMap64.exe"); err != nil {
+		return false
+	}
+	return exec.Command("RAMMap64.exe", "-Ec").Run() == nil
+}
+
+// launches exe once, returns time to first paint in milliseconds
+func runStartupBenchOnce(exe string, appDataDir string, file string) (int, error) {
+	must(os.MkdirAll(appDataDir, 0755))
+	markerPath := filepath.Join(startupBenchDir, "first-paint.txt")
+	os.Remove(markerPath)
+	args := []string{"-appdata", appDataDir, "-first-paint-marker", absPathMust(markerPath)}
+	if file != "" {
+		args = append(args, file)
+	}
+	if err := runWithTimeout(exe, args...); err != nil {
+		return 0, err
+	}
+	d, err := os.ReadFile(markerPath)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' didn't write first paint marker", exe)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(d)))
+}
+
+func runStartupBenchSeries(exe string, name string, file string, cold bool) *StartupBenchSeries {
+	appDataDir := filepath.Join(startupBenchDir, "appdata")
+	must(os.RemoveAll(appDataDir))
+	if !cold {
+		_, err := runStartupBenchOnce(exe, appDataDir, file)
+		must(err)
+	}
+	var runs []int
+	for i := 0; i < startupBenchRuns; i++ {
+		if cold {
+			must(os.RemoveAll(appDataDir))
+			emptyFileCache()
+		}
+		ms, err := runStartupBenchOnce(exe, appDataDir, file)
+		must(err)
+		runs = append(runs, ms)
+	}
+	must(os.RemoveAll(appDataDir))
+	s := newStartupBenchSeries(name, runs)
+	logf("%-24s median: %4d ms, min: %4d ms, max: %4d ms\n", s.Name, s.Median, s.Min, s.Max)
+	return s
+}
+
+// returns descriptions of series that regressed compared to baseline
+func checkStartupBenchRegressions(baseline, res *StartupBenchResults) []string {
+	var problems []string
+	for _, s := range res.Series {
+		for _, b := range baseline.Series {
+			if b.Name != s.Name {
+				continue
+			}
+			limit := float64(b.Median) * startupBenchMaxRegression
+			if float64(s.Median) > limit && s.Median-b.Median >= startupBenchMinRegressionMs {
+				problems = append(problems, fmt.Sprintf("%s: median %d ms, baseline %d ms", s.Name, s.Median, b.Median))
+			}
+		}
+	}
+	return problems
+}
+
+// -startup-bench [baseline] [${file}]
+func startupBenchMust(args []string) {
+	panicIf(runtime.GOOS != "windows", "-startup-bench only works on Windows")
+	saveBaseline := false
+	file := ""
+	for _, arg := range args {
+		if arg == "baseline" {
+			saveBaseline = true
+			continue
+		}
+		panicIf(file != "", "usage: -startup-bench [baseline] [${file}]")
+		panicIf(!fileExists(arg), "'%s' doesn't exist", arg)
+		file = absPathMust(arg)
+	}
+	exe := getRel64ExeMust()
+	if !emptyFileCache() {
+		logf("RAMMap64.exe not in PATH or failed (needs elevation), cold runs only start with fresh settings\n")
+	}
+
+	res := &StartupBenchResults{
+		Time:   time.Now(),
+		GitSha: getGitSha1(),
+	}
+	push(&res.Series, runStartupBenchSeries(exe, "start page cold", "", true))
+	push(&res.Series, runStartupBenchSeries(exe, "start page warm", "", false))
+	if file != "" {
+		name := filepath.Base(file)
+		push(&res.Series, runStartupBenchSeries(exe, name+" cold", file, true))
+		push(&res.Series, runStartupBenchSeries(exe, name+" warm", file, false))
+	}
+
+	d, err := json.MarshalIndent(res, "", "  ")
+	must(err)
+	path := filepath.Join(startupBenchDir, res.Time.Format("2006-01-02_15-04-05")+".json")
+	writeFileMust(path, d)
+	logf("wrote '%s'\n", path)
+
+	baselinePath := filepath.Join(startupBenchDir, "baseline.json")
+	if saveBaseline {
+		writeFileMust(baselinePath, d)
+		logf("wrote '%s'\n", baselinePath)
+		return
+	}
+	if !fileExists(baselinePath) {
+		logf("no baseline, save one with: -startup-bench baseline [${file}]\n")
+		return
+	}
+	var baseline StartupBenchResults
+	must(json.Unmarshal(readFileMust(baselinePath), &baseline))
+	problems := checkStartupBenchRegressions(&baseline, res)
+	panicIf(len(problems) > 0, "startup time regressed compared to baseline from %s (%s):\n%s", baseline.Time.Format("2006-01-02"), baseline.GitSha, strings.Join(problems, "\n"))
+	logf("no regressions compared to baseline from %s (%s)\n", baseline.Time.Format("2006-01-02"), baseline.GitSha)
+}
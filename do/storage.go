@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kjk/minioutil"
+	"github.com/minio/minio-go/v7"
+)
+
+// StorageBackend is a place we upload builds to. All uploaded files are public.
+//
+// Uploads of big files are multipart, with parts uploaded in parallel. State
+// of unfinished uploads is saved in out/upload-state so that re-running
+// after a network error only uploads missing parts. Every part is verified
+// by the server (Content-MD5) and after upload we compare size and sha256
+// of the remote file with the local file.
+type StorageBackend interface {
+	Name() string
+	URLBase() string
+	URLForPath(remotePath string) string
+	Exists(remotePath string) bool
+	// returns nil if the file doesn't exist
+	Stat(remotePath string) (*StorageObject, error)
+	// all files under prefix, recursively
+	List(prefix string) ([]*StorageObject, error)
+	UploadFile(remotePath string, localPath string) error
+	UploadData(remotePath string, d []byte) error
+	DownloadFileAtomically(dstPath string, remotePath string) error
+	Remove(remotePath string) error
+}
+
+// StorageObject is a file in storage
+type StorageObject struct {
+	Key  string
+	Size int64
+	// from metadata, "" for files uploaded before we started recording it
+	Sha256       string
+	LastModified time.Time
+}
+
+const (
+	// files bigger than that are uploaded in parts
+	storagePartSize = 8 * 1024 * 1024
+	// how many parts are uploaded at the same time
+	storageUploadParallelism = 4
+	// x-amz-meta-sha256
+	storageSha256Meta = "Sha256"
+)
+
+// S3Storage is S3-compatible storage (Cloudflare R2, Backblaze B2)
+type S3Storage struct {
+	name string
+	mc   *minioutil.Client
+	core *minio.Core
+}
+
+func newS3Storage(name string, mc *minioutil.Client) *S3Storage {
+	return &S3Storage{
+		name: name,
+		mc:   mc,
+		core: &minio.Core{Client: mc.Client},
+	}
+}
+
+func newR2Storage() *S3Storage {
+	return newS3Storage("r2", newMinioR2Client())
+}
+
+func newBackblazeStorage() *S3Storage {
+	return newS3Storage("backblaze", newMinioBackblazeClient())
+}
+
+// all places we upload builds to
+func getStorageBackends() []StorageBackend {
+	return []StorageBackend{maybeDryRunStorage(newR2Storage()), maybeDryRunStorage(newBackblazeStorage())}
+}
+
+// runs fn for every storage in parallel, panics if any fn panics
+func forEachStorageParallelMust(backends []StorageBackend, fn func(StorageBackend)) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []string
+	for _, b := range backends {
+		wg.Add(1)
+		go func(b StorageBackend) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					mu.Lock()
+					failed = append(failed, fmt.Sprintf("%s: %v", b.Name(), r))
+					mu.Unlock()
+				}
+			}()
+			fn(b)
+		}(b)
+	}
+	wg.Wait()
+	sort.Strings(failed)
+	panicIf(len(failed) > 0, "failed for some storages:\n%v", failed)
+}
+
+func storageCtx() context.Context {
+	return context.Background()
+}
+
+func (s *S3Storage) Name() string {
+	return s.name
+}
+
+func (s *S3Storage) URLBase() string {
+	return s.mc.URLBase()
+}
+
+func (s *S3Storage) URLForPath(remotePath string) string {
+	return s.mc.URLForPath(remotePath)
+}
+
+func (s *S3Storage) Exists(remotePath string) bool {
+	return s.mc.Exists(remotePath)
+}
+
+func (s *S3Storage) Stat(remotePath string) (*StorageObject, error) {
+	info, err := s.mc.Client.StatObject(storageCtx(), s.mc.Bucket, remotePath, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &StorageObject{
+		Key:          info.Key,
+		Size:         info.Size,
+		Sha256:       info.UserMetadata[storageSha256Meta],
+		LastModified: info.LastModified,
+	}, nil
+}
+
+// sha256 is not included, it needs a request per file
+func (s *S3Storage) List(prefix string) ([]*StorageObject, error) {
+	var res []*StorageObject
+	for obj := range s.mc.ListObjects(prefix) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		res = append(res, &StorageObject{Key: obj.Key, Size: obj.Size, LastModified: obj.LastModified})
+	}
+	return res, nil
+}
+
+func (s *S3Storage) DownloadFileAtomically(dstPath string, remotePath string) error {
+	return s.mc.DownloadFileAtomically(dstPath, remotePath)
+}
+
+func (s *S3Storage) Remove(remotePath string) error {
+	return s.mc.Remove(remotePath)
+}
+
+func (s *S3Storage) putOptions(remotePath string, sha256 string) minio.PutObjectOptions {
+	return minio.PutObjectOptions{
+		ContentType: mime.TypeByExtension(filepath.Ext(remotePath)),
+		UserMetadata: map[string]string{
+			"x-amz-acl":       "public-read",
+			storageSha256Meta: sha256,
+		},
+		SendContentMd5: true,
+	}
+}
+
+func (s *S3Storage) UploadData(remotePath string, d []byte) error {
+	return s.uploadData(remotePath, d, nil)
+}
+
+func (s *S3Storage) uploadData(remotePath string, d []byte, progress *UploadProgress) error {
+	sha256 := sha256HexOfData(d)
+	opts := s.putOptions(remotePath, sha256)
+	r := newThrottledReader(bytes.NewReader(d), progress)
+	_, err := s.mc.Client.PutObject(storageCtx(), s.mc.Bucket, remotePath, r, int64(len(d)), opts)
+	if err != nil {
+		return err
+	}
+	return s.verifyUpload(remotePath, int64(len(d)), sha256)
+}
+
+func (s *S3Storage) verifyUpload(remotePath string, size int64, sha256 string) error {
+	obj, err := s.Stat(remotePath)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return fmt.Errorf("'%s' doesn't exist after upload", s.URLForPath(remotePath))
+	}
+	if obj.Size != size || obj.Sha256 != sha256 {
+		return fmt.Errorf("'%s' is %d bytes, sha256: %s after upload, expected %d bytes, sha256: %s", s.URLForPath(remotePath), obj.Size, obj.Sha256, size, sha256)
+	}
+	return nil
+}
+
+// skips the upload if the same file is already there
+func (s *S3Storage) UploadFile(remotePath string, localPath string) error {
+	size := fileSizeMust(localPath)
+	sha256 := fileSha256HexMust(localPath)
+	if obj, err := s.Stat(remotePath); err == nil && obj != nil && obj.Size == size && obj.Sha256 == sha256 {
+		logf("'%s' already uploaded\n", s.URLForPath(remotePath))
+		return nil
+	}
+	progress := newUploadProgress(s.URLForPath(remotePath), size)
+	if size <= storagePartSize {
+		d, err := os.ReadFile(localPath)
+		if err != nil {
+			return err
+		}
+		return s.uploadData(remotePath, d, progress)
+	}
+	if err := s.uploadMultipart(remotePath, localPath, size, sha256, progress); err != nil {
+		return err
+	}
+	return s.verifyUpload(remotePath, size, sha256)
+}
+
+// MultipartUploadState is saved so that we can resume interrupted upload
+type MultipartUploadState struct {
+	UploadID string `json:"uploadId"`
+	Sha256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"partSize"`
+}
+
+func (s *S3Storage) getUploadStatePath(remotePath string) string {
+	name := sha256HexOfData([]byte(s.mc.Bucket + "/" + remotePath))[:16] + ".json"
+	return filepath.Join("out", "upload-state", s.name, name)
+}
+
+// returns state of upload of the same file we can resume or nil
+func (s *S3Storage) loadUploadState(remotePath string, size int64, sha256 string) *MultipartUploadState {
+	d, err := os.ReadFile(s.getUploadStatePath(remotePath))
+	if err != nil {
+		return nil
+	}
+	var st MultipartUploadState
+	if json.Unmarshal(d, &st) != nil || st.Sha256 != sha256 || st.Size != size || st.PartSize != storagePartSize {
+		return nil
+	}
+	return &st
+}
+
+// returns parts already uploaded, by part number
+func (s *S3Storage) listUploadedParts(remotePath string, uploadID string) (map[int]minio.ObjectPart, error) {
+	res := map[int]minio.ObjectPart{}
+	marker := 0
+	for {
+		rsp, err := s.core.ListObjectParts(storageCtx(), s.mc.Bucket, remotePath, uploadID, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range rsp.ObjectParts {
+			res[p.PartNumber] = p
+		}
+		if !rsp.IsTruncated {
+			return res, nil
+		}
+		marker = rsp.NextPartNumberMarker
+	}
+}
+
+func (s *S3Storage) uploadMultipart(remotePath string, localPath string, size int64, sha256 string, progress *UploadProgress) error {
+	timeStart := time.Now()
+	statePath := s.getUploadStatePath(remotePath)
+	st := s.loadUploadState(remotePath, size, sha256)
+	uploaded := map[int]minio.ObjectPart{}
+	if st != nil {
+		var err error
+		uploaded, err = s.listUploadedParts(remotePath, st.UploadID)
+		if err != nil {
+			// most likely upload expired or was aborted
+			logf("can't resume upload of '%s': %s\n", remotePath, err)
+			st = nil
+			uploaded = map[int]minio.ObjectPart{}
+		} else {
+			logf("resuming upload of '%s', %d parts already uploaded\n", remotePath, len(uploaded))
+		}
+	}
+	if st == nil {
+		uploadID, err := s.core.NewMultipartUpload(storageCtx(), s.mc.Bucket, remotePath, s.putOptions(remotePath, sha256))
+		if err != nil {
+			return err
+		}
+		st = &MultipartUploadState{
+			UploadID: uploadID,
+			Sha256:   sha256,
+			Size:     size,
+			PartSize: storagePartSize,
+		}
+		d, err := json.Marshal(st)
+		must(err)
+		must(createDirForFile(statePath))
+		writeFileMust(statePath, d)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nParts := int((size + storagePartSize - 1) / storagePartSize)
+	parts := make([]minio.CompletePart, nParts)
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan bool, storageUploadParallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < nParts; i++ {
+		partNo := i + 1
+		off := int64(i) * storagePartSize
+		partSize := min(storagePartSize, size-off)
+		d := make([]byte, partSize)
+		if _, err = f.ReadAt(d, off); err != nil {
+			return err
+		}
+		sum := md5.Sum(d)
+		etag := fmt.Sprintf("%x", sum)
+		// part uploaded before the interruption, ETag of a part is its md5
+		if p, ok := uploaded[partNo]; ok && p.Size == partSize && trimETag(p.ETag) == etag {
+			parts[i] = minio.CompletePart{PartNumber: partNo, ETag: p.ETag}
+			progress.add(partSize)
+			continue
+		}
+		sem <- true
+		wg.Add(1)
+		go func(i int, partNo int, d []byte, md5 []byte) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			opts := minio.PutObjectPartOptions{Md5Base64: base64.StdEncoding.EncodeToString(md5)}
+			p, err := s.core.PutObjectPart(storageCtx(), s.mc.Bucket, remotePath, st.UploadID, partNo, newThrottledReader(bytes.NewReader(d), progress), int64(len(d)), opts)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("upload of part %d of '%s' failed with '%s'", partNo, remotePath, err)
+				}
+				return
+			}
+			parts[i] = minio.CompletePart{PartNumber: partNo, ETag: p.ETag}
+		}(i, partNo, d, sum[:])
+	}
+	wg.Wait()
+	if firstErr != nil {
+		// keep the state so that we can resume
+		return firstErr
+	}
+	_, err = s.core.CompleteMultipartUpload(storageCtx(), s.mc.Bucket, remotePath, st.UploadID, parts, s.putOptions(remotePath, sha256))
+	if err != nil {
+		return err
+	}
+	os.Remove(statePath)
+	logf("uploaded '%s' (%s) in %d parts in %s\n", s.URLForPath(remotePath), formatSize(size), nParts, time.Since(timeStart))
+	return nil
+}
+
+func trimETag(etag string) string {
+	if len(etag) >= 2 && etag[0] == '"' && etag[len(etag)-1] == '"' {
+		return etag[1 : len(etag)-1]
+	}
+	return etag
+}
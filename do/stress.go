@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -stress [${dir}|corpus[:${category}]] [instances=${n}] [cycles=${n}] runs
+// release 64-bit SumatraPDF.exe -stress-test on documents in ${dir} (default
+// is the whole corpus) in ${n} instances at once, each going ${n} times over
+// all documents.
+//
+// For every document, stress test opens it (closing the previous one),
+// renders random pages while changing zoom and view mode, searches the
+// whole document and gets toc and properties.
+//
+// While instances run we sample their handle count, GDI and USER objects
+// and private bytes every stressSampleInterval. Resources that keep growing
+// after warmup are reported as leaks. We also fail if an instance crashes or
+// doesn't finish in stressTimeout. Report is in out/stress/${time}/report.txt.
+
+const (
+	stressDefaultCycles    = 10
+	stressSampleInterval   = 5 * time.Second
+	stressWarmup           = 2 * time.Minute
+	stressTimeout          = 12 * time.Hour
+	stressMinSamples       = 8
+	stressMaxGrowth        = 1.5
+	stressGDIObjectsLimit  = 8000
+	stressUSERObjectsLimit = 8000
+)
+
+var stressDir = filepath.Join("out", "stress")
+
+// StressSample is resource usage of a process at a point in time
+type StressSample struct {
+	Time         time.Time
+	Handles      int64
+	GDIObjects   int64
+	USERObjects  int64
+	PrivateBytes int64
+}
+
+// StressResource describes how to check one kind of resource for leaks
+type StressResource struct {
+	Name string
+	Get  func(s *StressSample) int64
+	// growth smaller than this is not a leak, even if relatively big
+	MinGrowth int64
+	// fail if we reach this, 0 if there's no limit
+	Limit  int64
+	Format func(n int64) string
+}
+
+var stressResources = []*StressResource{
+	{"handles", func(s *StressSample) int64 { return s.Handles }, 500, 0, formatStressCount},
+	{"GDI objects", func(s *StressSample) int64 { return s.GDIObjects }, 200, stressGDIObjectsLimit, formatStressCount},
+	{"USER objects", func(s *StressSample) int64 { return s.USERObjects }, 100, stressUSERObjectsLimit, formatStressCount},
+	{"private bytes", func(s *StressSample) int64 { return s.PrivateBytes }, 128 * 1024 * 1024, 0, formatSize},
+}
+
+func formatStressCount(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// StressInstance is one SumatraPDF.exe running a stress test
+type StressInstance struct {
+	N       int
+	Pid     int
+	Samples []*StressSample
+	// stdout of stress test, which prints every file it opens
+	LogPath  string
+	Err      error
+	Duration time.Duration
+}
+
+func medianInt64(a []int64) int64 {
+	sorted := append([]int64{}, a...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// returns description of a leak of r, "" if there's no leak
+// We compare median of first and last quarter of samples taken after warmup
+// because usage goes up and down as documents are opened and closed
+func checkStressLeak(r *StressResource, samples []*StressSample) string {
+	var vals []int64
+	var peak int64
+	for _, s := range samples {
+		v := r.Get(s)
+		peak = max(peak, v)
+		if s.Time.Sub(samples[0].Time) >= stressWarmup {
+			vals = append(vals, v)
+		}
+	}
+	if r.Limit > 0 && peak >= r.Limit {
+		return fmt.Sprintf("%s: reached %s, limit is %s", r.Name, r.Format(peak), r.Format(r.Limit))
+	}
+	if len(vals) < stressMinSamples {
+		return ""
+	}
+	n := len(vals) / 4
+	first := medianInt64(vals[:n])
+	last := medianInt64(vals[len(vals)-n:])
+	if float64(last) > float64(first)*stressMaxGrowth && last-first >= r.MinGrowth {
+		return fmt.Sprintf("%s: grew from %s to %s", r.Name, r.Format(first), r.Format(last))
+	}
+	return ""
+}
+
+func runStressInstance(inst *StressInstance, exe string, exeArgs []string) {
+	logFile, err := os.Create(inst.LogPath)
+	must(err)
+	defer logFile.Close()
+
+	c, cancel := context.WithTimeout(context.Background(), stressTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(c, exe, exeArgs...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	logf("> %s\n", fmtCmdShort(*cmd))
+	timeStart := time.Now()
+	if inst.Err = cmd.Start(); inst.Err != nil {
+		return
+	}
+	inst.Pid = cmd.Process.Pid
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	tick := time.NewTicker(stressSampleInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case err := <-done:
+			inst.Duration = time.Since(timeStart)
+			if c.Err() != nil {
+				inst.Err = fmt.Errorf("didn't finish in %s", stressTimeout)
+			} else if err != nil {
+				inst.Err = fmt.Errorf("failed with '%s'", err)
+			}
+			return
+		case <-tick.C:
+			s, err := getStressSample(inst.Pid)
+			if err != nil {
+				// process might have just exited
+				continue
+			}
+			push(&inst.Samples, s)
+		}
+	}
+}
+
+func genStressReport(title string, instances []*StressInstance) (string, bool) {
+	var b strings.Builder
+	ok := true
+	fmt.Fprintf(&b, "%s\n\n", title)
+	for _, inst := range instances {
+		var problems []string
+		if inst.Err != nil {
+			push(&problems, inst.Err.Error())
+		}
+		if len(inst.Samples) > 0 {
+			for _, r := range stressResources {
+				if s := checkStressLeak(r, inst.Samples); s != "" {
+					push(&problems, s)
+				}
+			}
+		}
+		status := "PASS"
+		if len(problems) > 0 {
+			status = "FAIL"
+			ok = false
+		}
+		fmt.Fprintf(&b, "instance %d (pid %d): %s in %s, %d samples, log: %s\n", inst.N, inst.Pid, status, inst.Duration.Round(time.Second), len(inst.Samples), inst.LogPath)
+		if n := len(inst.Samples); n > 0 {
+			first, last := inst.Samples[0], inst.Samples[n-1]
+			for _, r := range stressResources {
+				var peak int64
+				for _, s := range inst.Samples {
+					peak = max(peak, r.Get(s))
+				}
+				fmt.Fprintf(&b, "  %-14s start: %10s, end: %10s, peak: %10s\n", r.Name, r.Format(r.Get(first)), r.Format(r.Get(last)), r.Format(peak))
+			}
+		}
+		for _, s := range problems {
+			fmt.Fprintf(&b, "  %s\n", s)
+		}
+	}
+	if ok {
+		fmt.Fprintf(&b, "\nPASS\n")
+	} else {
+		fmt.Fprintf(&b, "\nFAIL\n")
+	}
+	return b.String(), ok
+}
+
+// -stress [${dir}|corpus[:${category}]] [instances=${n}] [cycles=${n}]
+func stressMust(args []string) {
+	panicIf(runtime.GOOS != "windows", "-stress only works on Windows")
+	usage := "usage: -stress [${dir}|corpus[:${category}]] [instances=${n}] [cycles=${n}]"
+	nInstances := 1
+	cycles := stressDefaultCycles
+	dirArg := "corpus"
+	for _, arg := range args {
+		if v, ok := strings.CutPrefix(arg, "instances="); ok {
+			n, err := strconv.Atoi(v)
+			panicIf(err != nil || n < 1, "%s", usage)
+			nInstances = n
+			continue
+		}
+		if v, ok := strings.CutPrefix(arg, "cycles="); ok {
+			n, err := strconv.Atoi(v)
+			panicIf(err != nil || n < 1, "%s", usage)
+			cycles = n
+			continue
+		}
+		dirArg = arg
+	}
+	dir := getCorpusDirMust(dirArg)
+	panicIf(!dirExists(dir), "%s", usage)
+	nDocs := len(collectMemBenchDocsMust(dir))
+	panicIf(nDocs == 0, "no documents in '%s'", dir)
+	exe := getRel64ExeMust()
+
+	outDir := filepath.Join(stressDir, time.Now().Format("2006-01-02_15-04-05"))
+	must(os.MkdirAll(outDir, 0755))
+	logf("stress testing %d documents in '%s', %d cycles, %d instances\n", nDocs, dir, cycles, nInstances)
+
+	var instances []*StressInstance
+	var wg sync.WaitGroup
+	for i := 1; i <= nInstances; i++ {
+		inst := &StressInstance{
+			N:       i,
+			LogPath: filepath.Join(outDir, fmt.Sprintf("instance-%d.txt", i)),
+		}
+		push(&instances, inst)
+		// every instance has its own settings so that they don't fight over
+		// them and we start from defaults
+		appDataDir := filepath.Join(outDir, fmt.Sprintf("appdata-%d", i))
+		must(os.MkdirAll(appDataDir, 0755))
+		exeArgs := []string{"-appdata", appDataDir, "-stress-test", dir, fmt.Sprintf("%dx", cycles), "-exit-when-done"}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runStressInstance(inst, exe, exeArgs)
+		}()
+	}
+	wg.Wait()
+
+	title := fmt.Sprintf("stress test of %d documents in '%s', %d cycles, %d instances, %s", nDocs, dir, cycles, nInstances, getGitSha1())
+	report, ok := genStressReport(title, instances)
+	reportPath := filepath.Join(outDir, "report.txt")
+	writeFileMust(reportPath, []byte(report))
+	logf("%s\nwrote '%s'\n", report, reportPath)
+	panicIf(!ok, "stress test failed, see '%s'", reportPath)
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// resource usage of a process is only available on Windows
+func getStressSample(pid int) (*StressSample, error) {
+	return nil, errors.New("resource usage of a process is only available on Windows")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	modUser32                 = syscall.NewLazyDLL("user32.dll")
+	procGetGuiResources       = modUser32.NewProc("GetGuiResources")
+	procGetProcessHandleCount = modKernel32.NewProc("GetProcessHandleCount")
+)
+
+const (
+	grGDIObjects  = 0
+	grUSERObjects = 1
+)
+
+// returns current resource usage of process pid
+func getStressSample(pid int) (*StressSample, error) {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(h)
+
+	res := &StressSample{
+		Time: time.Now(),
+	}
+	var nHandles uint32
+	r, _, e := procGetProcessHandleCount.Call(uintptr(h), uintptr(unsafe.Pointer(&nHandles)))
+	if r == 0 {
+		return nil, e
+	}
+	res.Handles = int64(nHandles)
+	// GetGuiResources returns 0 on failure, which is fine
+	r, _, _ = procGetGuiResources.Call(uintptr(h), grGDIObjects)
+	res.GDIObjects = int64(r)
+	r, _, _ = procGetGuiResources.Call(uintptr(h), grUSERObjects)
+	res.USERObjects = int64(r)
+
+	var pmc processMemoryCounters
+	pmc.Cb = uint32(unsafe.Sizeof(pmc))
+	r, _, e = procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&pmc)), uintptr(pmc.Cb))
+	if r == 0 {
+		return nil, e
+	}
+	// for a process PagefileUsage is its private committed memory
+	res.PrivateBytes = int64(pmc.PagefileUsage)
+	return res, nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// -symbolicate ${path} makes user-submitted crash reports readable. ${path}
+// is a minidump (.dmp), crash report text (.txt, as written by
+// src/CrashHandler.cpp) or a directory with them. Result is written to
+// ${path}.symbolicated.txt next to each file.
+//
+// Minidumps are opened with cdb.exe (Debugging Tools for Windows), with our
+// symbol server (see symbols.go) and Microsoft's in symbol path.
+//
+// Crash reports only have addresses and loaded modules. We download symbols
+// package of the version from "Ver:" line, find the build by size of the
+// module (different for every arch) and resolve addresses in our modules
+// with llvm-symbolizer.exe (comes with Visual Studio).
+
+const (
+	symbolServerURL    = "https://www.sumatrapdfreader.org/symbols"
+	msSymbolServerURL  = "https://msdl.microsoft.com/download/symbols"
+	symbolicatedSuffix = ".symbolicated.txt"
+)
+
+var symbolsCacheDir = filepath.Join("out", "symbols-cache")
+
+var (
+	// "Ver: 3.6.16123 pre-release 64-bit"
+	rxCrashVer = regexp.MustCompile(`(?m)^Ver: (\S+)( pre-release)?`)
+	// "Module: 00007FF6A1B20000 9B1000 SumatraPDF.exe   C:\..."
+	rxCrashModule = regexp.MustCompile(`(?m)^Module: ([0-9A-Fa-f]+) ([0-9A-Fa-f]+) +(\S+)`)
+	// "00007FF6A1B2C3D4 01:0000000000012345 sumatrapdf.exe!Foo+0x12 ..."
+	rxCrashFrame = regexp.MustCompile(`^([0-9A-Fa-f]{8,16}) ([0-9A-Fa-f]{2}:[0-9A-Fa-f]+) ([^!+\s]+)`)
+)
+
+// CrashModule is a module loaded in crashed process
+type CrashModule struct {
+	Name string
+	Base uint64
+	Size uint32
+}
+
+// CrashReportInfo is what we need from crash report to symbolicate it
+type CrashReportInfo struct {
+	BuildType BuildType
+	// as in symbols package name i.e. "16123" for pre-release
+	Ver string
+	// by lower-case name
+	Modules map[string]*CrashModule
+}
+
+func parseCrashReportInfo(s string) *CrashReportInfo {
+	res := &CrashReportInfo{Modules: map[string]*CrashModule{}}
+	if m := rxCrashVer.FindStringSubmatch(s); m != nil {
+		res.BuildType, res.Ver = buildTypeRel, m[1]
+		if m[2] != "" {
+			// "3.6.16123" => "16123"
+			res.BuildType = buildTypePreRel
+			res.Ver = m[1][strings.LastIndex(m[1], ".")+1:]
+		}
+	}
+	for _, m := range rxCrashModule.FindAllStringSubmatch(s, -1) {
+		base, err1 := strconv.ParseUint(m[1], 16, 64)
+		size, err2 := strconv.ParseUint(m[2], 16, 32)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		mod := &CrashModule{Name: m[3], Base: base, Size: uint32(size)}
+		res.Modules[strings.ToLower(mod.Name)] = mod
+	}
+	return res
+}
+
+func getSymbolsPackageName(buildType BuildType, ver string) string {
+	return fmt.Sprintf("SumatraPDF-%s-%s-symbols.zip", buildType, ver)
+}
+
+// downloads and unpacks symbols package of a build, returns directory
+// with symbol store layout or "" if there's no package for the build
+func getSymbolsPackageDirMust(buildType BuildType, ver string) string {
+	name := getSymbolsPackageName(buildType, ver)
+	dir := filepath.Join(symbolsCacheDir, "packages", strings.TrimSuffix(name, ".zip"))
+	if dirExists(dir) {
+		return dir
+	}
+	zipPath := filepath.Join(symbolsCacheDir, "packages", name)
+	if !fileExists(zipPath) {
+		uri := symbolServerURL + "/packages/" + name
+		logf("downloading '%s'\n", uri)
+		if err := httpDownloadToFile(uri, zipPath); err != nil {
+			os.Remove(zipPath)
+			logf("failed to download symbols: %s\n", err)
+			return ""
+		}
+	}
+	zr, err := zip.OpenReader(zipPath)
+	must(err)
+	defer zr.Close()
+	tmpDir := dir + ".tmp"
+	must(os.RemoveAll(tmpDir))
+	for _, f := range zr.File {
+		path := filepath.Join(tmpDir, filepath.FromSlash(f.Name))
+		must(createDirForFile(path))
+		writeFileMust(path, readZipFileMust(&zr.Reader, f.Name))
+	}
+	must(os.Rename(tmpDir, dir))
+	return dir
+}
+
+// returns path of binary of pdbName in symbol store dir with the given size
+// of image, with .pdb copied next to it so that llvm-symbolizer finds it.
+// "" if not found
+func findBinaryInSymbolStoreMust(dir string, pdbName string, sizeOfImage uint32) string {
+	exeName := pdbToBinary[pdbName]
+	// ${exeName}/${TimeDateStamp}${SizeOfImage}/${exeName}
+	matches, err := filepath.Glob(filepath.Join(dir, exeName, "*", exeName))
+	must(err)
+	for _, exePath := range matches {
+		key := filepath.Base(filepath.Dir(exePath))
+		if len(key) <= 8 || key[8:] != fmt.Sprintf("%x", sizeOfImage) {
+			continue
+		}
+		info, err := readPeDebugInfo(exePath)
+		must(err)
+		pdbPath := filepath.Join(dir, pdbName, info.PdbKey, pdbName)
+		dst := filepath.Join(filepath.Dir(exePath), pdbName)
+		if fileExists(pdbPath) && !fileExists(dst) {
+			must(copyFile(dst, pdbPath))
+		}
+		return exePath
+	}
+	return ""
+}
+
+func detectLlvmSymbolizerMust() string {
+	path := detectPath(vsBasePaths, `VC\Tools\Llvm\x64\bin\llvm-symbolizer.exe`)
+	if path == "" {
+		path = detectPath(vsBasePaths, `VC\Tools\Llvm\bin\llvm-symbolizer.exe`)
+	}
+	panicIf(path == "", "didn't find llvm-symbolizer.exe. Install C++ Clang tools in Visual Studio")
+	return path
+}
+
+// "C:\Users\builder\sumatrapdf\src\Foo.cpp" => "src\Foo.cpp"
+func shortenSourcePath(path string) string {
+	for _, dir := range []string{`\src\`, `\mupdf\`, `\ext\`} {
+		if idx := strings.LastIndex(path, dir); idx >= 0 {
+			return path[idx+1:]
+		}
+	}
+	return path
+}
+
+// returns "function file:line" for every rva, with inlined functions
+// separated by " / "
+func runLlvmSymbolizerMust(exePath string, rvas []uint64) []string {
+	var in []string
+	for _, rva := range rvas {
+		in = append(in, fmt.Sprintf("0x%x", rva))
+	}
+	cmd := exec.Command(detectLlvmSymbolizerMust(), "--obj="+exePath, "--relative-address", "--inlines", "--demangle")
+	cmd.Stdin = strings.NewReader(strings.Join(in, "\n") + "\n")
+	out, err := cmd.Output()
+	must(err)
+	// for every address: pairs of function and file:line:column lines
+	// followed by an empty line
+	var res []string
+	var frames []string
+	lines := strings.Split(normalizeNewlines(string(out)), "\n")
+	for i := 0; i < len(lines); i++ {
+		if lines[i] == "" {
+			if len(frames) > 0 {
+				res = append(res, strings.Join(frames, " / "))
+				frames = nil
+			}
+			continue
+		}
+		fn, loc := lines[i], ""
+		if i+1 < len(lines) {
+			loc = lines[i+1]
+			i++
+		}
+		// "file:line:column" => "file:line"
+		if idx := strings.LastIndex(loc, ":"); idx > 0 && strings.Count(loc[:idx], ":") > 1 {
+			loc = loc[:idx]
+		}
+		frames = append(frames, fn+" "+shortenSourcePath(loc))
+	}
+	panicIf(len(res) != len(rvas), "llvm-symbolizer returned %d results for %d addresses", len(res), len(rvas))
+	return res
+}
+
+func symbolicateCrashTextMust(s string) string {
+	info := parseCrashReportInfo(s)
+	if info.Ver == "" {
+		logf("no 'Ver:' line, can't symbolicate\n")
+		return s
+	}
+	dir := getSymbolsPackageDirMust(info.BuildType, info.Ver)
+	if dir == "" {
+		return s
+	}
+
+	// frames in our modules by binary that has symbols for them
+	lines := strings.Split(normalizeNewlines(s), "\n")
+	lineIdxs := map[string][]int{}
+	rvas := map[string][]uint64{}
+	for i, line := range lines {
+		m := rxCrashFrame.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		mod := info.Modules[strings.ToLower(m[3])]
+		if mod == nil || pdbForBinary(mod.Name) == "" {
+			continue
+		}
+		exePath := findBinaryInSymbolStoreMust(dir, pdbForBinary(mod.Name), mod.Size)
+		if exePath == "" {
+			continue
+		}
+		addr, err := strconv.ParseUint(m[1], 16, 64)
+		if err != nil || addr < mod.Base {
+			continue
+		}
+		lineIdxs[exePath] = append(lineIdxs[exePath], i)
+		rvas[exePath] = append(rvas[exePath], addr-mod.Base)
+	}
+	if len(rvas) == 0 {
+		logf("didn't find frames in our modules from %s build %s\n", info.BuildType, info.Ver)
+		return s
+	}
+	for exePath, a := range rvas {
+		resolved := runLlvmSymbolizerMust(exePath, a)
+		for i, idx := range lineIdxs[exePath] {
+			m := rxCrashFrame.FindStringSubmatch(strings.TrimSpace(lines[idx]))
+			lines[idx] = fmt.Sprintf("%s %s %s!%s", m[1], m[2], m[3], resolved[i])
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// returns name of .pdb of binary exeName (case-insensitive), "" if it's
+// not ours
+func pdbForBinary(exeName string) string {
+	for pdbName, name := range pdbToBinary {
+		if strings.EqualFold(name, exeName) {
+			return pdbName
+		}
+	}
+	return ""
+}
+
+// our symbol server and Microsoft's, cached in symbolsCacheDir
+func getCdbSymPath() string {
+	cache := absPathMust(symbolsCacheDir)
+	return fmt.Sprintf("srv*%s*%s;srv*%s*%s", cache, symbolServerURL, cache, msSymbolServerURL)
+}
+
+// runs cdb.exe on a minidump with our and Microsoft's symbol server,
+// returns its output
+func runCdbOnDumpMust(path string, cmds string) string {
+	cdbPath := filepath.Join(debuggersDir, "cdb.exe")
+	panicIf(!fileExists(cdbPath), "didn't find '%s'. Install Debugging Tools for Windows", cdbPath)
+	out := runExeMust(cdbPath, "-z", path, "-y", getCdbSymPath(), "-lines", "-c", cmds)
+	return string(out)
+}
+
+func symbolicateDumpMust(path string) string {
+	// .ecxr switches to context of the exception
+	return runCdbOnDumpMust(path, ".ecxr;kpn 100;q")
+}
+
+func symbolicateFileMust(path string) string {
+	var res string
+	if strings.EqualFold(filepath.Ext(path), ".dmp") {
+		res = symbolicateDumpMust(path)
+	} else {
+		res = symbolicateCrashTextMust(string(readFileMust(path)))
+	}
+	dst := path + symbolicatedSuffix
+	writeFileMust(dst, []byte(res))
+	logf("wrote '%s'\n", dst)
+	return res
+}
+
+// -symbolicate ${dump-or-dir}
+func symbolicateMust(path string) {
+	panicIf(path == "", "usage: -symbolicate ${file.dmp | crash.txt | dir}")
+	if !dirExists(path) {
+		logf("%s\n", symbolicateFileMust(path))
+		return
+	}
+	entries, err := os.ReadDir(path)
+	must(err)
+	n := 0
+	for _, e := range entries {
+		name := e.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if e.IsDir() || strings.HasSuffix(name, symbolicatedSuffix) || (ext != ".dmp" && ext != ".txt") {
+			continue
+		}
+		symbolicateFileMust(filepath.Join(path, name))
+		n++
+	}
+	logf("symbolicated %d crash reports in '%s'\n", n, path)
+}
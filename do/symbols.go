@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// We publish .pdb files (and binaries they belong to) in a layout compatible with
+// symstore.exe / Microsoft symbol server protocol:
+//
+//	${pdbName}/${GUID}${Age}/${pdbName}
+//	${exeName}/${TimeDateStamp}${SizeOfImage}/${exeName}
+//
+// This allows adding
+// https://www.sumatrapdfreader.org/symbols (or direct storage url) to symbol path
+// in WinDbg / Visual Studio and symbolicate crash dumps from any build we released.
+
+const symbolsRemoteDir = "software/sumatrapdf/symbols/"
+
+// binary whose .pdb is in pdbFiles
+var pdbToBinary = map[string]string{
+	"libmupdf.pdb":       "libmupdf.dll",
+	"SumatraPDF-dll.pdb": "SumatraPDF-dll.exe",
+	"SumatraPDF.pdb":     "SumatraPDF.exe",
+}
+
+// PeDebugInfo is information needed to build symbol server paths
+type PeDebugInfo struct {
+	PdbName     string // as recorded by the linker, can be full path
+	PdbKey      string // ${GUID}${Age}
+	BinaryKey   string // ${TimeDateStamp}${SizeOfImage}
+	TimeStamp   uint32
+	SizeOfImage uint32
+}
+
+// IMAGE_DEBUG_DIRECTORY
+type imageDebugDirectory struct {
+	Characteristics  uint32
+	TimeDateStamp    uint32
+	MajorVersion     uint16
+	MinorVersion     uint16
+	Type             uint32
+	SizeOfData       uint32
+	AddressOfRawData uint32
+	PointerToRawData uint32
+}
+
+const (
+	imageDebugTypeCodeView   = 2
+	imageDirectoryEntryDebug = 6
+)
+
+func formatPdbKey(guid []byte, age uint32) string {
+	// GUID is stored as: u32, u16, u16, 8 bytes
+	d1 := binary.LittleEndian.Uint32(guid[0:4])
+	d2 := binary.LittleEndian.Uint16(guid[4:6])
+	d3 := binary.LittleEndian.Uint16(guid[6:8])
+	s := fmt.Sprintf("%08X%04X%04X", d1, d2, d3)
+	for _, b := range guid[8:16] {
+		s += fmt.Sprintf("%02X", b)
+	}
+	return s + fmt.Sprintf("%X", age)
+}
+
+func readPeDebugInfo(path string) (*PeDebugInfo, error) {
+	f, err := pe.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dd pe.DataDirectory
+	res := &PeDebugInfo{
+		TimeStamp: f.FileHeader.TimeDateStamp,
+	}
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		res.SizeOfImage = oh.SizeOfImage
+		dd = oh.DataDirectory[imageDirectoryEntryDebug]
+	case *pe.OptionalHeader64:
+		res.SizeOfImage = oh.SizeOfImage
+		dd = oh.DataDirectory[imageDirectoryEntryDebug]
+	default:
+		return nil, fmt.Errorf("'%s' has no optional header", path)
+	}
+	res.BinaryKey = fmt.Sprintf("%08X%x", res.TimeStamp, res.SizeOfImage)
+	if dd.VirtualAddress == 0 || dd.Size == 0 {
+		return nil, fmt.Errorf("'%s' has no debug directory", path)
+	}
+
+	var sectData []byte
+	for _, s := range f.Sections {
+		if dd.VirtualAddress >= s.VirtualAddress && dd.VirtualAddress < s.VirtualAddress+s.VirtualSize {
+			sectData, err = s.Data()
+			if err != nil {
+				return nil, err
+			}
+			sectData = sectData[dd.VirtualAddress-s.VirtualAddress:]
+			break
+		}
+	}
+	if sectData == nil {
+		return nil, fmt.Errorf("'%s': didn't find section with debug directory", path)
+	}
+
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n := int(dd.Size) / binary.Size(imageDebugDirectory{})
+	r := bytes.NewReader(sectData)
+	for i := 0; i < n; i++ {
+		var idd imageDebugDirectory
+		err = binary.Read(r, binary.LittleEndian, &idd)
+		if err != nil {
+			return nil, err
+		}
+		if idd.Type != imageDebugTypeCodeView {
+			continue
+		}
+		start := int(idd.PointerToRawData)
+		end := start + int(idd.SizeOfData)
+		if end > len(fileData) || idd.SizeOfData < 24 {
+			return nil, fmt.Errorf("'%s': invalid CodeView record", path)
+		}
+		cv := fileData[start:end]
+		if string(cv[:4]) != "RSDS" {
+			return nil, fmt.Errorf("'%s': CodeView record is '%s', expected 'RSDS'", path, string(cv[:4]))
+		}
+		age := binary.LittleEndian.Uint32(cv[20:24])
+		res.PdbKey = formatPdbKey(cv[4:20], age)
+		name := cv[24:]
+		if idx := bytes.IndexByte(name, 0); idx >= 0 {
+			name = name[:idx]
+		}
+		res.PdbName = string(name)
+		return res, nil
+	}
+	return nil, fmt.Errorf("'%s' has no CodeView debug info", path)
+}
+
+// SymbolFile is a local file and its path in symbol store
+type SymbolFile struct {
+	LocalPath string
+	StorePath string
+	Arch      string // "32", "64", "arm64"
+}
+
+func collectSymbolFilesInDirMust(dir string, arch string) []*SymbolFile {
+	var res []*SymbolFile
+	for _, pdbName := range pdbFiles {
+		exeName := pdbToBinary[pdbName]
+		exePath := filepath.Join(dir, exeName)
+		pdbPath := filepath.Join(dir, pdbName)
+		if !fileExists(exePath) || !fileExists(pdbPath) {
+			logf("collectSymbolFiles: skipping '%s' because '%s' or '%s' doesn't exist\n", pdbName, exePath, pdbPath)
+			continue
+		}
+		info, err := readPeDebugInfo(exePath)
+		must(err)
+		// linker records full path to .pdb
+		recordedName := filepath.Base(strings.ReplaceAll(info.PdbName, `\`, "/"))
+		panicIf(!strings.EqualFold(recordedName, pdbName), "'%s' references '%s', expected '%s'", exePath, info.PdbName, pdbName)
+		push(&res, &SymbolFile{
+			LocalPath: pdbPath,
+			StorePath: path.Join(pdbName, info.PdbKey, pdbName),
+			Arch:      arch,
+		})
+		push(&res, &SymbolFile{
+			LocalPath: exePath,
+			StorePath: path.Join(exeName, info.BinaryKey, exeName),
+			Arch:      arch,
+		})
+	}
+	return res
+}
+
+// returns .pdb files (and their binaries) in out/rel32, out/rel64, out/arm64,
+// empty if there are none e.g. partial build
+func collectSymbolFiles() []*SymbolFile {
+	var res []*SymbolFile
+	dirs := []string{rel32Dir, rel64Dir, relArm64Dir}
+	platforms := []string{kPlatformIntel32, kPlatformIntel64, kPlatformArm64}
+	for i, dir := range dirs {
+		if !dirExists(dir) {
+			continue
+		}
+		arch := getSuffixForPlatform(platforms[i])
+		res = append(res, collectSymbolFilesInDirMust(dir, arch)...)
+	}
+	return res
+}
+
+func collectSymbolFilesMust() []*SymbolFile {
+	res := collectSymbolFiles()
+	panicIf(len(res) == 0, "didn't find any .pdb files in out/ directory")
+	return res
+}
+
+func getSymbolsPackagePath(buildType BuildType) string {
+	name := getSymbolsPackageName(buildType, getVerForBuildType(buildType))
+	return filepath.Join("out", "artifacts", name)
+}
+
+// symbols package is a .zip with symbol store layout. Can be unpacked and
+// used as local symbol store. build-ids.json maps the build to keys of
+// its files
+func createSymbolsPackageMust(buildType BuildType, files []*SymbolFile) string {
+	path := getSymbolsPackagePath(buildType)
+	must(createDirForFile(path))
+	f, err := os.Create(path)
+	must(err)
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, sf := range files {
+		addZipFileWithNameMust(zw, sf.LocalPath, sf.StorePath)
+	}
+	// symstore.exe creates this file to mark a directory as symbol store
+	w, err := zw.Create("pingme.txt")
+	must(err)
+	_, err = io.WriteString(w, "")
+	must(err)
+	w, err = zw.Create("build-ids.json")
+	must(err)
+	_, err = w.Write(genBuildIDs(buildType, files))
+	must(err)
+	must(zw.Close())
+	logf("created symbols package '%s' of size %s\n", path, formatSize(fileSizeMust(path)))
+	return path
+}
+
+func uploadSymbolFilesMust(storage StorageBackend, files []*SymbolFile) {
+	timeStart := time.Now()
+	nUploaded := 0
+	for _, sf := range files {
+		remotePath := symbolsRemoteDir + sf.StorePath
+		// files in symbol store are immutable
+		if storage.Exists(remotePath) {
+			logf("'%s' already exists, skipping\n", storage.URLForPath(remotePath))
+			continue
+		}
+		err := storage.UploadFile(remotePath, sf.LocalPath)
+		must(err)
+		nUploaded++
+		logf("Uploaded %s => %s\n", sf.LocalPath, storage.URLForPath(remotePath))
+	}
+	logf("uploaded %d symbol files in %s\n", nUploaded, time.Since(timeStart))
+}
+
+func uploadSymbolsPackageMust(storage StorageBackend, pkgPath string) {
+	remotePath := symbolsRemoteDir + "packages/" + filepath.Base(pkgPath)
+	err := storage.UploadFile(remotePath, pkgPath)
+	must(err)
+	logf("Uploaded %s => %s\n", pkgPath, storage.URLForPath(remotePath))
+}
+
+// collects .pdb files from out/rel32, out/rel64, out/arm64, creates a symbols
+// package and (if upload is true) publishes them to symbol store in r2 and
+// to crash reporting service (see crash_service.go)
+func buildAndUploadSymbols(buildType BuildType, upload bool) {
+	defer makePrintDuration("buildAndUploadSymbols")()
+	files := collectSymbolFilesMust()
+	pkgPath := createSymbolsPackageMust(buildType, files)
+	if !upload {
+		logf("buildAndUploadSymbols: skipping upload\n")
+		return
+	}
+	ensureAllUploadCreds()
+	storage := maybeDryRunStorage(newR2Storage())
+	uploadSymbolFilesMust(storage, files)
+	uploadSymbolsPackageMust(storage, pkgPath)
+	logf("symbol server: %s\n", storage.URLForPath(symbolsRemoteDir))
+	uploadSymbolsToCrashServiceMust(pkgPath)
+}
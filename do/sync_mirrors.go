@@ -0,0 +1,232 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// -sync-mirrors [rel|prerel] [ver] makes sure that all storage backends
+// (see getStorageBackends()) have all files of a build and that
+// the files are the same as the ones we built.
+//
+// Source of truth is artifacts.json from out/ if it's the same version
+// or the one in storage. Files that are missing or different are uploaded
+// from out/ or, if we don't have them locally, copied from a backend that
+// has the correct file.
+
+// MirrorFile is a file that every storage backend should have
+type MirrorFile struct {
+	Name   string
+	Size   int64
+	Sha256 string
+	// local copy, "" if we don't have it yet
+	LocalPath string
+	// backend that has correct copy of the file
+	GoodIn StorageBackend
+}
+
+// MirrorProblem is a file that is missing or different in a backend
+type MirrorProblem struct {
+	Storage StorageBackend
+	File    *MirrorFile
+	Reason  string
+}
+
+func getSyncMirrorsCacheDir(buildType BuildType, ver string) string {
+	return filepath.Join("out", "sync-mirrors", string(buildType), ver)
+}
+
+// returns artifacts.json for ver and its local path
+func getMirrorArtifactsManifestMust(backends []StorageBackend, buildType BuildType, ver string) (*ArtifactsManifest, string) {
+	dir := getFinalDirForBuildType(buildType)
+	if am := readArtifactsManifest(dir); am != nil && am.Version == ver {
+		logf("using '%s'\n", getArtifactsManifestPath(dir))
+		return am, getArtifactsManifestPath(dir)
+	}
+	dir = getSyncMirrorsCacheDir(buildType, ver)
+	remotePath := path.Join("software/sumatrapdf", string(buildType), ver, artifactsManifestName)
+	for _, storage := range backends {
+		if !storage.Exists(remotePath) {
+			continue
+		}
+		localPath := getArtifactsManifestPath(dir)
+		must(storage.DownloadFileAtomically(localPath, remotePath))
+		logf("using '%s'\n", storage.URLForPath(remotePath))
+		return readArtifactsManifestMust(dir), localPath
+	}
+	panicIf(true, "no storage has '%s' and there's no build of %s %s in out/", remotePath, buildType, ver)
+	return nil, ""
+}
+
+// ${prefix}-manifest.txt files are not in artifacts.json. We take
+// the ones we have locally and the ones any backend has
+func getMirrorManifestTxtFilesMust(backends []StorageBackend, buildType BuildType, ver string) []*MirrorFile {
+	remoteDir := path.Join("software/sumatrapdf", string(buildType), ver) + "/"
+	localDir := getFinalDirForBuildType(buildType)
+	cacheDir := getSyncMirrorsCacheDir(buildType, ver)
+	var res []*MirrorFile
+	seen := map[string]bool{}
+	add := func(name string, localPath string) {
+		seen[name] = true
+		res = append(res, &MirrorFile{
+			Name:      name,
+			Size:      fileSizeMust(localPath),
+			Sha256:    fileSha256HexMust(localPath),
+			LocalPath: localPath,
+		})
+	}
+	if am := readArtifactsManifest(localDir); am != nil && am.Version == ver {
+		entries, _ := os.ReadDir(localDir)
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), "-manifest.txt") {
+				add(e.Name(), filepath.Join(localDir, e.Name()))
+			}
+		}
+	}
+	for _, storage := range backends {
+		objects, err := storage.List(remoteDir)
+		must(err)
+		for _, obj := range objects {
+			name := path.Base(obj.Key)
+			if !strings.HasSuffix(name, "-manifest.txt") || seen[name] {
+				continue
+			}
+			localPath := filepath.Join(cacheDir, name)
+			must(storage.DownloadFileAtomically(localPath, obj.Key))
+			add(name, localPath)
+		}
+	}
+	return res
+}
+
+// returns "" if storage has the same file
+func checkMirrorFile(storage StorageBackend, remotePath string, f *MirrorFile, cacheDir string) string {
+	obj, err := storage.Stat(remotePath)
+	must(err)
+	if obj == nil {
+		return "missing"
+	}
+	if obj.Size != f.Size {
+		return "size is " + formatSize(obj.Size) + ", expected " + formatSize(f.Size)
+	}
+	if obj.Sha256 == "" {
+		// uploaded before we started recording sha256, have to download it
+		localPath := filepath.Join(cacheDir, storage.Name(), f.Name)
+		must(storage.DownloadFileAtomically(localPath, remotePath))
+		obj.Sha256 = fileSha256HexMust(localPath)
+		if obj.Sha256 == f.Sha256 && f.LocalPath == "" {
+			f.LocalPath = localPath
+		}
+	}
+	if obj.Sha256 != f.Sha256 {
+		return "sha256 is " + obj.Sha256 + ", expected " + f.Sha256
+	}
+	return ""
+}
+
+// returns local file with correct content, downloading it if necessary
+func getMirrorFileLocalMust(f *MirrorFile, remotePath string, cacheDir string) string {
+	if f.LocalPath != "" {
+		return f.LocalPath
+	}
+	panicIf(f.GoodIn == nil, "'%s' is not in out/ and no storage has the correct file", f.Name)
+	localPath := filepath.Join(cacheDir, f.Name)
+	must(f.GoodIn.DownloadFileAtomically(localPath, remotePath))
+	got := fileSha256HexMust(localPath)
+	panicIf(got != f.Sha256, "sha256 of '%s' downloaded from %s is %s, expected %s", f.Name, f.GoodIn.Name(), got, f.Sha256)
+	f.LocalPath = localPath
+	return localPath
+}
+
+func syncMirrorsMust(buildType BuildType, ver string) {
+	defer makePrintDuration("syncMirrorsMust")()
+	if ver == "" {
+		ver = getVerForBuildType(buildType)
+	}
+	backends := getStorageBackends()
+	remoteDir := path.Join("software/sumatrapdf", string(buildType), ver)
+	cacheDir := getSyncMirrorsCacheDir(buildType, ver)
+
+	am, amPath := getMirrorArtifactsManifestMust(backends, buildType, ver)
+	localDir := filepath.Dir(amPath)
+	var files []*MirrorFile
+	for _, a := range am.Artifacts {
+		f := &MirrorFile{
+			Name:   a.Name,
+			Size:   a.Size,
+			Sha256: a.Sha256,
+		}
+		localPath := filepath.Join(localDir, a.Name)
+		if fileExists(localPath) && fileSha256HexMust(localPath) == a.Sha256 {
+			f.LocalPath = localPath
+		}
+		files = append(files, f)
+	}
+	// same order as uploadArtifacts(): ${prefix}-manifest.txt must be last
+	// because isBuildAlreadyUploaded() checks for its presence
+	files = append(files, &MirrorFile{
+		Name:      artifactsManifestName,
+		Size:      fileSizeMust(amPath),
+		Sha256:    fileSha256HexMust(amPath),
+		LocalPath: amPath,
+	})
+	// not present for builds uploaded before we started generating it
+	if !fileExists(getSha256SumsPath(localDir)) {
+		writeSha256SumsMust(localDir, am)
+	}
+	sumsPath := getSha256SumsPath(localDir)
+	files = append(files, &MirrorFile{
+		Name:      sha256SumsName,
+		Size:      fileSizeMust(sumsPath),
+		Sha256:    fileSha256HexMust(sumsPath),
+		LocalPath: sumsPath,
+	})
+	files = append(files, getMirrorManifestTxtFilesMust(backends, buildType, ver)...)
+
+	var problems []*MirrorProblem
+	for _, storage := range backends {
+		expected := map[string]bool{}
+		for _, f := range files {
+			remotePath := path.Join(remoteDir, f.Name)
+			expected[remotePath] = true
+			reason := checkMirrorFile(storage, remotePath, f, cacheDir)
+			if reason == "" {
+				if f.GoodIn == nil {
+					f.GoodIn = storage
+				}
+				continue
+			}
+			problems = append(problems, &MirrorProblem{storage, f, reason})
+		}
+		objects, err := storage.List(remoteDir + "/")
+		must(err)
+		var extra []string
+		for _, obj := range objects {
+			if !expected[obj.Key] {
+				extra = append(extra, obj.Key)
+			}
+		}
+		sort.Strings(extra)
+		for _, key := range extra {
+			// we don't know where they came from so we don't delete them
+			logf("%s: '%s' is not part of the build\n", storage.Name(), storage.URLForPath(key))
+		}
+	}
+
+	if len(problems) == 0 {
+		logf("all %d storages have all %d files of %s %s\n", len(backends), len(files), buildType, ver)
+		return
+	}
+	for _, p := range problems {
+		logf("%s: '%s' %s\n", p.Storage.Name(), p.File.Name, p.Reason)
+	}
+	for _, p := range problems {
+		remotePath := path.Join(remoteDir, p.File.Name)
+		localPath := getMirrorFileLocalMust(p.File, remotePath, cacheDir)
+		must(uploadFileLogged(p.Storage, remotePath, localPath))
+	}
+	logf("fixed %d files in %s %s\n", len(problems), buildType, ver)
+}
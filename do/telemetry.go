@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -telemetry [${dir}] turns opt-in anonymous usage pings into daily rollups
+// and a report, so that decisions like dropping support for old Windows
+// versions or deprecating file formats are based on data.
+//
+// Telemetry endpoint stores pings as JSON lines (optionally .gz):
+//
+//	{"date":"2024-05-20","ver":"3.5.2","prerel":false,"os":"10.0.22631","arch":"64","opened":{"pdf":12,"epub":1}}
+//
+// An install that opted in sends at most one ping a day, with counts of files
+// opened that day by type. There's no install id, so a ping is one install
+// active on that day.
+//
+// Pings of every file in ${dir} are aggregated into
+// out/telemetry/daily/${date}/${file}.json. Re-ingesting a file replaces its
+// rollups, rollups of other files are kept so raw pings can be deleted after
+// ingestion. The report in out/telemetry/index.html is made from rollups of
+// the last telemetryReportDays days.
+
+const telemetryReportDays = 28
+
+var telemetryDir = filepath.Join("out", "telemetry")
+
+// file types we count, others are counted as "other"
+var telemetryFileTypes = []string{"pdf", "xps", "oxps", "djvu", "epub", "mobi", "fb2", "cbz", "cbr", "cb7", "cbt", "chm", "ps", "image", "other"}
+
+var (
+	rxTelemetryVer = regexp.MustCompile(`^\d+(\.\d+){0,3}$`)
+	rxTelemetryOS  = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?`)
+)
+
+// TelemetryPing is a ping sent by the app
+type TelemetryPing struct {
+	Date   string         `json:"date"`
+	Ver    string         `json:"ver"`
+	PreRel bool           `json:"prerel"`
+	OS     string         `json:"os"`
+	Arch   string         `json:"arch"`
+	Opened map[string]int `json:"opened"`
+}
+
+// TelemetryRollup is pings of a day aggregated
+type TelemetryRollup struct {
+	Date  string `json:"date"`
+	Pings int    `json:"pings"`
+	// "3.5.2", "prerel 16234" => number of pings
+	ByVersion map[string]int `json:"byVersion"`
+	// "Windows 10" => number of pings
+	ByOS   map[string]int `json:"byOS"`
+	ByArch map[string]int `json:"byArch"`
+	// file type => number of files opened
+	Opened map[string]int `json:"opened"`
+	// file type => number of installs that opened at least one
+	OpenedBy map[string]int `json:"openedBy"`
+}
+
+func newTelemetryRollup(date string) *TelemetryRollup {
+	return &TelemetryRollup{
+		Date:      date,
+		ByVersion: map[string]int{},
+		ByOS:      map[string]int{},
+		ByArch:    map[string]int{},
+		Opened:    map[string]int{},
+		OpenedBy:  map[string]int{},
+	}
+}
+
+// "10.0.22631" => "Windows 11", "6.1.7601" => "Windows 7"
+func getTelemetryOSName(ver string) string {
+	m := rxTelemetryOS.FindStringSubmatch(ver)
+	if m == nil {
+		return "unknown"
+	}
+	switch m[1] + "." + m[2] {
+	case "10.0":
+		// Windows 11 is 10.0 with build 22000 or later
+		if build, _ := strconv.Atoi(m[3]); build >= 22000 {
+			return "Windows 11"
+		}
+		return "Windows 10"
+	case "6.3":
+		return "Windows 8.1"
+	case "6.2":
+		return "Windows 8"
+	case "6.1":
+		return "Windows 7"
+	case "6.0":
+		return "Windows Vista"
+	case "5.1", "5.2":
+		return "Windows XP"
+	}
+	return "unknown"
+}
+
+func getTelemetryFileType(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if stringInSlice(telemetryFileTypes, ext) {
+		return ext
+	}
+	switch ext {
+	case "jpg", "jpeg", "png", "gif", "tif", "tiff", "bmp", "webp", "jxr", "heic", "avif", "tga":
+		return "image"
+	case "djv":
+		return "djvu"
+	case "azw", "azw3", "prc":
+		return "mobi"
+	case "fb2z", "zfb2":
+		return "fb2"
+	case "eps":
+		return "ps"
+	}
+	return "other"
+}
+
+// adds counts of o to r
+func (r *TelemetryRollup) merge(o *TelemetryRollup) {
+	r.Pings += o.Pings
+	for _, m := range [][2]map[string]int{{r.ByVersion, o.ByVersion}, {r.ByOS, o.ByOS}, {r.ByArch, o.ByArch}, {r.Opened, o.Opened}, {r.OpenedBy, o.OpenedBy}} {
+		for k, n := range m[1] {
+			m[0][k] += n
+		}
+	}
+}
+
+// adds ping to rollups, returns false if it's not valid
+func (r *TelemetryRollup) add(p *TelemetryPing) bool {
+	if !rxTelemetryVer.MatchString(p.Ver) {
+		return false
+	}
+	r.Pings++
+	ver := p.Ver
+	if p.PreRel {
+		// "3.6.16234" => "prerel 16234"
+		ver = "prerel " + ver[strings.LastIndex(ver, ".")+1:]
+	}
+	r.ByVersion[ver]++
+	r.ByOS[getTelemetryOSName(p.OS)]++
+	switch p.Arch {
+	case "32", "64", "arm64":
+		r.ByArch[p.Arch]++
+	default:
+		r.ByArch["unknown"]++
+	}
+	openedBy := map[string]bool{}
+	for ext, n := range p.Opened {
+		if n <= 0 {
+			continue
+		}
+		ft := getTelemetryFileType(ext)
+		r.Opened[ft] += n
+		openedBy[ft] = true
+	}
+	for ft := range openedBy {
+		r.OpenedBy[ft]++
+	}
+	return true
+}
+
+func parseTelemetryPings(r io.Reader, rollups map[string]*TelemetryRollup) (int, int) {
+	nOk, nBad := 0, 0
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var p TelemetryPing
+		if err := json.Unmarshal(line, &p); err != nil {
+			nBad++
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", p.Date); err != nil {
+			nBad++
+			continue
+		}
+		rollup := rollups[p.Date]
+		if rollup == nil {
+			rollup = newTelemetryRollup(p.Date)
+			rollups[p.Date] = rollup
+		}
+		if rollup.add(&p) {
+			nOk++
+		} else {
+			nBad++
+		}
+	}
+	must(sc.Err())
+	return nOk, nBad
+}
+
+// returns file name => rollups by date of pings in that file, for files in dir
+func ingestTelemetryMust(dir string) map[string]map[string]*TelemetryRollup {
+	res := map[string]map[string]*TelemetryRollup{}
+	nOk, nBad := 0, 0
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		name := fi.Name()
+		panicIf(res[name] != nil, "more than one file named '%s' in '%s'", name, dir)
+		var r io.Reader = bytes.NewReader(readFileMust(path))
+		if strings.HasSuffix(path, ".gz") {
+			gr, err := gzip.NewReader(r)
+			must(err)
+			defer gr.Close()
+			r = gr
+		}
+		rollups := map[string]*TelemetryRollup{}
+		ok, bad := parseTelemetryPings(r, rollups)
+		res[name] = rollups
+		nOk += ok
+		nBad += bad
+		return nil
+	})
+	must(err)
+	logf("read %d pings (%d invalid) from %d files in '%s'\n", nOk, nBad, len(res), dir)
+	return res
+}
+
+// replaces rollups of the files, keeps rollups of other files
+func writeTelemetryRollupsMust(byFile map[string]map[string]*TelemetryRollup) {
+	for name, rollups := range byFile {
+		// pings of the file that were for other days before
+		old, err := filepath.Glob(filepath.Join(telemetryDir, "daily", "*", name+".json"))
+		must(err)
+		for _, path := range old {
+			removeFileMust(path)
+		}
+		for date, r := range rollups {
+			d, err := json.MarshalIndent(r, "", "  ")
+			must(err)
+			path := filepath.Join(telemetryDir, "daily", date, name+".json")
+			must(createDirForFile(path))
+			writeFileMust(path, d)
+		}
+	}
+}
+
+// returns rollups of the last days, oldest first
+func readTelemetryRollupsMust(days int) []*TelemetryRollup {
+	dirs, err := filepath.Glob(filepath.Join(telemetryDir, "daily", "*"))
+	must(err)
+	sort.Strings(dirs)
+	if len(dirs) > days {
+		dirs = dirs[len(dirs)-days:]
+	}
+	var res []*TelemetryRollup
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		must(err)
+		if len(paths) == 0 {
+			continue
+		}
+		day := newTelemetryRollup(filepath.Base(dir))
+		for _, path := range paths {
+			r := newTelemetryRollup("")
+			must(json.Unmarshal(readFileMust(path), r))
+			day.merge(r)
+		}
+		res = append(res, day)
+	}
+	return res
+}
+
+// TelemetryShare is a row in the report
+type TelemetryShare struct {
+	Label   string
+	Count   int
+	Percent float64
+}
+
+// sums counts of rollups, returns rows by count, with percent of total
+func sumTelemetryShares(rollups []*TelemetryRollup, total int, getCounts func(*TelemetryRollup) map[string]int) []*TelemetryShare {
+	counts := map[string]int{}
+	for _, r := range rollups {
+		for k, n := range getCounts(r) {
+			counts[k] += n
+		}
+	}
+	var res []*TelemetryShare
+	for k, n := range counts {
+		s := &TelemetryShare{Label: k, Count: n}
+		if total > 0 {
+			s.Percent = float64(n) * 100 / float64(total)
+		}
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Label < res[j].Label
+	})
+	return res
+}
+
+// TelemetryTable is a table in the report
+type TelemetryTable struct {
+	Title string
+	// what Count means
+	CountName string
+	Rows      []*TelemetryShare
+}
+
+func genTelemetryTables(rollups []*TelemetryRollup) []*TelemetryTable {
+	pings, opened := 0, 0
+	for _, r := range rollups {
+		pings += r.Pings
+		for _, n := range r.Opened {
+			opened += n
+		}
+	}
+	return []*TelemetryTable{
+		{"Windows version", "active installs", sumTelemetryShares(rollups, pings, func(r *TelemetryRollup) map[string]int { return r.ByOS })},
+		{"SumatraPDF version", "active installs", sumTelemetryShares(rollups, pings, func(r *TelemetryRollup) map[string]int { return r.ByVersion })},
+		{"Architecture", "active installs", sumTelemetryShares(rollups, pings, func(r *TelemetryRollup) map[string]int { return r.ByArch })},
+		{"Installs that opened a file type", "active installs", sumTelemetryShares(rollups, pings, func(r *TelemetryRollup) map[string]int { return r.OpenedBy })},
+		{"Files opened by type", "files", sumTelemetryShares(rollups, opened, func(r *TelemetryRollup) map[string]int { return r.Opened })},
+	}
+}
+
+const telemetryTmpl = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>SumatraPDF usage</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { padding: 2px 8px; text-align: right; }
+th { background-color: #eee; }
+td:first-child { text-align: left; }
+.bar { background-color: #4a90d9; height: 10px; }
+</style>
+</head>
+<body>
+<h2>SumatraPDF usage</h2>
+<p>From opt-in anonymous usage pings, {{.From}} - {{.To}} ({{.Days}} days, {{.Pings}} pings). Generated on {{.Generated}}.</p>
+{{range .Tables}}
+<h3>{{.Title}}</h3>
+<table>
+<tr><th></th><th>{{.CountName}}</th><th>%</th><th></th></tr>
+{{range .Rows}}<tr><td>{{.Label}}</td><td>{{.Count}}</td><td>{{printf "%.1f" .Percent}}</td><td style="width:200px"><div class="bar" style="width:{{printf "%.0f" .Percent}}%"></div></td></tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`
+
+func genTelemetryHTML(rollups []*TelemetryRollup, tables []*TelemetryTable) string {
+	pings := 0
+	for _, r := range rollups {
+		pings += r.Pings
+	}
+	tmpl := template.Must(template.New("").Parse(telemetryTmpl))
+	var buf bytes.Buffer
+	v := map[string]interface{}{
+		"From":      rollups[0].Date,
+		"To":        rollups[len(rollups)-1].Date,
+		"Days":      len(rollups),
+		"Pings":     pings,
+		"Generated": time.Now().Format("2006-01-02 15:04"),
+		"Tables":    tables,
+	}
+	must(tmpl.Execute(&buf, v))
+	return buf.String()
+}
+
+// -telemetry [${dir}]
+func telemetryMust(dir string) {
+	if dir != "" {
+		writeTelemetryRollupsMust(ingestTelemetryMust(dir))
+	}
+	rollups := readTelemetryRollupsMust(telemetryReportDays)
+	panicIf(len(rollups) == 0, "no telemetry in '%s', usage: -telemetry ${dir with pings}", filepath.Join(telemetryDir, "daily"))
+	tables := genTelemetryTables(rollups)
+	// Windows version is what we most often need to know
+	logf("%s:\n", tables[0].Title)
+	for _, s := range tables[0].Rows {
+		logf("  %-16s %5.1f%%\n", s.Label, s.Percent)
+	}
+	path := filepath.Join(telemetryDir, "index.html")
+	writeFileMust(path, []byte(genTelemetryHTML(rollups, tables)))
+	logf("wrote '%s'\n", path)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// test_util.exe prints either:
+//
+//	Passed all 1234 tests
+//
+// or:
+//
+//	Failed 2 (of 1234) tests
+//	'str::Eq(s, "foo")' C:\...\src\utils\tests\StrUtil_ut.cpp@123
+//
+// We parse that into JUnit XML (understood by CI systems) and JSON summary
+// in out/test-results/ and fail the build with the list of failed asserts
+
+var testResultsDir = filepath.Join("out", "test-results")
+
+// FailedTest is a single failed utassert()
+type FailedTest struct {
+	Expr string `json:"expr"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// TestResults is a summary of running test_util.exe
+type TestResults struct {
+	Exe      string        `json:"exe"`
+	Platform string        `json:"platform"`
+	Total    int           `json:"total"`
+	Failed   int           `json:"failed"`
+	Duration string        `json:"duration"`
+	Failures []*FailedTest `json:"failures"`
+	// non-empty if we couldn't understand the output e.g. because the exe crashed
+	Error string `json:"error,omitempty"`
+}
+
+var (
+	rxTestsPassed = regexp.MustCompile(`^Passed all (\d+) tests`)
+	rxTestsFailed = regexp.MustCompile(`^Failed (\d+) \(of (\d+)\) tests`)
+	rxFailedTest  = regexp.MustCompile(`^'(.*)' (.+)@(\d+)$`)
+)
+
+func parseTestUtilOutput(out string) *TestResults {
+	res := &TestResults{}
+	seenSummary := false
+	for _, l := range strings.Split(out, "\n") {
+		l = strings.TrimSpace(l)
+		if m := rxTestsPassed.FindStringSubmatch(l); m != nil {
+			res.Total, _ = strconv.Atoi(m[1])
+			seenSummary = true
+			continue
+		}
+		if m := rxTestsFailed.FindStringSubmatch(l); m != nil {
+			res.Failed, _ = strconv.Atoi(m[1])
+			res.Total, _ = strconv.Atoi(m[2])
+			seenSummary = true
+			continue
+		}
+		if m := rxFailedTest.FindStringSubmatch(l); m != nil {
+			line, _ := strconv.Atoi(m[3])
+			ft := &FailedTest{
+				Expr: m[1],
+				File: m[2],
+				Line: line,
+			}
+			res.Failures = append(res.Failures, ft)
+		}
+	}
+	if !seenSummary {
+		res.Error = "didn't find test summary in the output of test_util.exe"
+	}
+	return res
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name         `xml:"testsuite"`
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Time      string           `xml:"time,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+}
+
+// test_util.exe doesn't name individual tests, only reports total number of
+// asserts so we report a single passing test case for all passed asserts
+// and a test case for each failed assert, named after the source file
+func genJUnitXML(res *TestResults, dur time.Duration) []byte {
+	suite := &junitTestSuite{
+		Name:     "test_util-" + res.Platform,
+		Tests:    res.Total,
+		Failures: res.Failed,
+		Time:     fmt.Sprintf("%.3f", dur.Seconds()),
+	}
+	if res.Error != "" {
+		suite.Errors = 1
+		tc := &junitTestCase{
+			Name:      "test_util",
+			ClassName: "test_util",
+			Failure: &junitFailure{
+				Message: res.Error,
+				Type:    "error",
+			},
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	nPassed := res.Total - res.Failed
+	if nPassed > 0 {
+		tc := &junitTestCase{
+			Name:      fmt.Sprintf("%d passed asserts", nPassed),
+			ClassName: "test_util",
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	for _, ft := range res.Failures {
+		file := filepath.Base(strings.ReplaceAll(ft.File, `\`, "/"))
+		tc := &junitTestCase{
+			Name:      fmt.Sprintf("%s@%d", file, ft.Line),
+			ClassName: strings.TrimSuffix(file, filepath.Ext(file)),
+			Failure: &junitFailure{
+				Message: ft.Expr,
+				Type:    "utassert",
+				Text:    fmt.Sprintf("%s:%d: utassert(%s) failed", ft.File, ft.Line, ft.Expr),
+			},
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	d, err := xml.MarshalIndent(suite, "", "  ")
+	must(err)
+	return append([]byte(xml.Header), d...)
+}
+
+func writeTestResultsMust(res *TestResults, dur time.Duration) {
+	createDirMust(testResultsDir)
+	name := "test_util-" + res.Platform
+	{
+		path := filepath.Join(testResultsDir, name+".xml")
+		writeFileMust(path, genJUnitXML(res, dur))
+		logf("wrote '%s'\n", path)
+	}
+	{
+		path := filepath.Join(testResultsDir, name+".json")
+		d, err := json.MarshalIndent(res, "", "  ")
+		must(err)
+		writeFileMust(path, d)
+		logf("wrote '%s'\n", path)
+	}
+}
+
+// runs test_util.exe in dir, saves results as JUnit XML and JSON
+// and panics with the list of failed asserts if any failed
+func runTestUtilWithResultsMust(dir string) {
+	platform := filepath.Base(dir)
+	cmd := exec.Command(`.\test_util.exe`)
+	cmd.Dir = dir
+	var out bytes.Buffer
+	cmd.Stdout = io.MultiWriter(os.Stdout, &out)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &out)
+	logf("> %s\n", fmdCmdShort(cmd))
+	timeStart := time.Now()
+	err := cmd.Run()
+	dur := time.Since(timeStart)
+
+	res := parseTestUtilOutput(out.String())
+	res.Exe = filepath.Join(dir, "test_util.exe")
+	res.Platform = platform
+	res.Duration = dur.String()
+	if err != nil && res.Failed == 0 && res.Error == "" {
+		// e.g. crashed after printing summary
+		res.Error = fmt.Sprintf("test_util.exe failed with '%s'", err)
+	}
+	writeTestResultsMust(res, dur)
+
+	if res.Error == "" && res.Failed == 0 {
+		logf("test_util: passed all %d tests in %s\n", res.Total, formatDuration(dur))
+		return
+	}
+	logf("\ntest_util: failed %d out of %d tests:\n", res.Failed, res.Total)
+	for _, ft := range res.Failures {
+		logf("  %s:%d: %s\n", ft.File, ft.Line, ft.Expr)
+	}
+	if res.Failed > len(res.Failures) {
+		logf("  ... and %d more (test_util.exe only reports first %d)\n", res.Failed-len(res.Failures), len(res.Failures))
+	}
+	if res.Error != "" {
+		logf("  error: %s\n", res.Error)
+	}
+	panicIf(true, "test_util failed, see '%s'", testResultsDir)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// When a release is announced, downloads spike. To offload some of it
+// we create .torrent files for installers and portable versions and put
+// magnet links on the download page. Every torrent has a web seed (BEP 19)
+// pointing at the official url so it works even when there are no peers.
+//
+// Optional, done when TORRENT_TRACKERS (comma-separated announce urls) is set.
+// .torrent files are artifacts so they're uploaded next to the files.
+// -gen-torrents [rel] creates them for the build in out/
+
+var torrentTrackers string
+
+const (
+	torrentSuffix      = ".torrent"
+	torrentPieceLength = 256 * 1024
+)
+
+func isTorrentEnabled() bool {
+	return torrentTrackers != ""
+}
+
+func getTorrentTrackers() []string {
+	var res []string
+	for _, s := range strings.Split(torrentTrackers, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// files that get a torrent
+func isTorrentKind(kind string) bool {
+	switch kind {
+	case kArtifactInstaller, kArtifactMsi, kArtifactPortableExe, kArtifactPortableZip, kArtifactPortable7z:
+		return true
+	}
+	return false
+}
+
+// bencodeMust encodes v (int, int64, string, []byte, []interface{},
+// map[string]interface{}) as https://www.bittorrent.org/beps/bep_0003.html#bencoding
+func bencodeMust(w *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case int:
+		fmt.Fprintf(w, "i%de", v)
+	case int64:
+		fmt.Fprintf(w, "i%de", v)
+	case string:
+		fmt.Fprintf(w, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(w, "%d:", len(v))
+		w.Write(v)
+	case []interface{}:
+		w.WriteByte('l')
+		for _, el := range v {
+			bencodeMust(w, el)
+		}
+		w.WriteByte('e')
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// keys must be sorted as raw strings
+		sort.Strings(keys)
+		w.WriteByte('d')
+		for _, k := range keys {
+			bencodeMust(w, k)
+			bencodeMust(w, v[k])
+		}
+		w.WriteByte('e')
+	default:
+		panicIf(true, "bencode: unsupported type %T", v)
+	}
+}
+
+func bencodeToBytesMust(v interface{}) []byte {
+	var buf bytes.Buffer
+	bencodeMust(&buf, v)
+	return buf.Bytes()
+}
+
+// sha1 of every piece of the file, concatenated
+func getTorrentPiecesMust(path string) []byte {
+	f, err := os.Open(path)
+	must(err)
+	defer f.Close()
+	var res []byte
+	buf := make([]byte, torrentPieceLength)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			h := sha1.Sum(buf[:n])
+			res = append(res, h[:]...)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		must(err)
+	}
+	return res
+}
+
+// writes ${name}.torrent for a file in dir, returns info hash (hex)
+func writeTorrentMust(dir string, name string, webSeedURL string) string {
+	path := filepath.Join(dir, name)
+	info := map[string]interface{}{
+		"name":         name,
+		"length":       fileSizeMust(path),
+		"piece length": torrentPieceLength,
+		"pieces":       getTorrentPiecesMust(path),
+	}
+	infoHash := sha1.Sum(bencodeToBytesMust(info))
+	t := map[string]interface{}{
+		"info":          info,
+		"url-list":      []interface{}{webSeedURL},
+		"created by":    "SumatraPDF build",
+		"creation date": time.Now().Unix(),
+	}
+	var trackers []interface{}
+	for _, tr := range getTorrentTrackers() {
+		trackers = append(trackers, []interface{}{tr})
+	}
+	if len(trackers) > 0 {
+		t["announce"] = getTorrentTrackers()[0]
+		t["announce-list"] = trackers
+	}
+	writeFileMust(path+torrentSuffix, bencodeToBytesMust(t))
+	return hex.EncodeToString(infoHash[:])
+}
+
+// creates .torrent files for files in am that are in dir. Returns names of
+// created files and their info hashes
+func writeTorrentsMust(dir string, am *ArtifactsManifest) ([]string, map[string]string) {
+	prefix := getDownloadPrefixViaWebsite(am.BuildType, am.Version)
+	var names []string
+	infoHashes := map[string]string{}
+	for _, a := range am.Artifacts {
+		if !isTorrentKind(a.Kind) {
+			continue
+		}
+		name := a.Name + torrentSuffix
+		infoHashes[name] = writeTorrentMust(dir, a.Name, prefix+a.Name)
+		names = append(names, name)
+		logf("wrote '%s'\n", filepath.Join(dir, name))
+	}
+	return names, infoHashes
+}
+
+func genTorrentsMust(buildType BuildType) {
+	if !isTorrentEnabled() {
+		logf("Not creating torrents because TORRENT_TRACKERS env variable not set\n")
+		return
+	}
+	dir := getFinalDirForBuildType(buildType)
+	names, infoHashes := writeTorrentsMust(dir, readArtifactsManifestMust(dir))
+	addArtifactsToManifestMust(buildType, dir, "", names)
+	am := readArtifactsManifestMust(dir)
+	for _, a := range am.Artifacts {
+		if h, ok := infoHashes[a.Name]; ok {
+			a.InfoHash = h
+		}
+	}
+	writeArtifactsManifestMust(dir, am)
+}
+
+// https://en.wikipedia.org/wiki/Magnet_URI_scheme
+func getMagnetURL(a *ArtifactInfo, torrent *ArtifactInfo, webSeedURL string) string {
+	v := url.Values{}
+	v.Set("dn", a.Name)
+	v.Set("xl", fmt.Sprintf("%d", a.Size))
+	v.Set("ws", webSeedURL)
+	for _, tr := range getTorrentTrackers() {
+		v.Add("tr", tr)
+	}
+	// xt must not be escaped
+	return "magnet:?xt=urn:btih:" + torrent.InfoHash + "&" + v.Encode()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// -trans-add-language ${code} "${name}" ${langid} [rtl] [iso=${bcp47}]
+// adds a new language to translate to:
+//   - adds it to gLangs (and gLangISOCodes if ${code} is not BCP 47 code)
+//     in do/trans_langs.go
+//   - re-generates src/TranslationLangs.cpp which has the list of languages
+//     for the language menu and the installer, their Windows LANGID (used
+//     to pick the language on first run) and if they're RTL
+//   - adds the language on translation service
+//
+// e.g. -trans-add-language ka "Georgian (ქართული)" "_LANGID(LANG_GEORGIAN)"
+//
+// Once it has translations, -trans-download adds it to translations.txt and
+// translations-good.txt.
+
+var transLangsGoPath = filepath.Join("do", "trans_langs.go")
+
+var rxMsLangID = regexp.MustCompile(`^(_LANGID\(LANG_\w+\)|MAKELANGID\(LANG_\w+, SUBLANG_\w+\)|\(LANGID\)-1)$`)
+
+// NewTransLang is a language added with -trans-add-language
+type NewTransLang struct {
+	Code     string
+	Name     string
+	MsLangID string
+	IsRtl    bool
+	// BCP 47 code if different from Code
+	ISOCode string
+}
+
+func parseNewTransLangMust(args []string) *NewTransLang {
+	panicIf(len(args) < 3, `usage: -trans-add-language ${code} "${name}" ${langid} [rtl] [iso=${bcp47}]`)
+	res := &NewTransLang{
+		Code:     args[0],
+		Name:     args[1],
+		MsLangID: args[2],
+	}
+	for _, arg := range args[3:] {
+		switch {
+		case strings.EqualFold(arg, "rtl"):
+			res.IsRtl = true
+		case strings.HasPrefix(arg, "iso="):
+			res.ISOCode = strings.TrimPrefix(arg, "iso=")
+		default:
+			panicIf(true, "unknown argument '%s'", arg)
+		}
+	}
+	panicIf(!rxLangCode.MatchString(res.Code), "invalid language code '%s', should be like 'ka' or 'sr-rs'", res.Code)
+	panicIf(isKnownLang(res.Code), "language '%s' already exists", res.Code)
+	panicIf(strings.TrimSpace(res.Name) == "" || strings.Contains(res.Name, `"`), "invalid language name '%s'", res.Name)
+	panicIf(!rxMsLangID.MatchString(res.MsLangID), "invalid langid '%s', should be like _LANGID(LANG_GEORGIAN) or MAKELANGID(LANG_SERBIAN, SUBLANG_SERBIAN_LATIN)", res.MsLangID)
+	return res
+}
+
+func (l *NewTransLang) gLangsEntry() []string {
+	res := []string{l.Code, l.Name, l.MsLangID}
+	if l.IsRtl {
+		res = append(res, "RTL")
+	}
+	return res
+}
+
+// adds lang to gLangs and gLangISOCodes in src, which is trans_langs.go
+func addLangToTransLangsGo(src string, l *NewTransLang) string {
+	var quoted []string
+	for _, s := range l.gLangsEntry() {
+		quoted = append(quoted, fmt.Sprintf("%q", s))
+	}
+	entry := "\t{" + strings.Join(quoted, ", ") + "},\n"
+	isoEntry := fmt.Sprintf("\t%q: %q,\n", l.Code, l.ISOCode)
+	// both are sorted by our code
+	isBefore := func(line string) bool {
+		code, ok := strings.CutPrefix(strings.TrimLeft(strings.TrimSpace(line), "{"), `"`)
+		code, _, _ = strings.Cut(code, `"`)
+		return ok && code > l.Code
+	}
+	lines := strings.SplitAfter(src, "\n")
+	inLangs, inISO := false, false
+	var res []string
+	added, addedISO := false, l.ISOCode == ""
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "var gLangs = "):
+			inLangs = true
+		case strings.HasPrefix(line, "var gLangISOCodes = "):
+			inISO = true
+		case inLangs && line == "}\n":
+			// after the last language
+			if !added {
+				res = append(res, entry)
+				added = true
+			}
+			inLangs = false
+		case inISO && line == "}\n":
+			if !addedISO {
+				res = append(res, isoEntry)
+				addedISO = true
+			}
+			inISO = false
+		case inLangs && !added && isBefore(line):
+			res = append(res, entry)
+			added = true
+		case inISO && !addedISO && isBefore(line):
+			res = append(res, isoEntry)
+			addedISO = true
+		}
+		res = append(res, line)
+	}
+	panicIf(!added || !addedISO, "didn't find gLangs or gLangISOCodes in '%s'", transLangsGoPath)
+	d, err := format.Source([]byte(strings.Join(res, "")))
+	must(err)
+	return string(d)
+}
+
+func (s *weblateService) AddLanguage(lang string) {
+	code := weblateLangCode(lang)
+	if dryRunSkip("add language %s to %s", code, s.url) {
+		return
+	}
+	uri := fmt.Sprintf("%s/api/components/%s/%s/translations/", s.url, weblateProject, weblateComponent)
+	body := fmt.Sprintf(`{"language_code": %q}`, code)
+	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(body))
+	must(err)
+	req.Header.Set("Content-Type", "application/json")
+	d := s.doMust(req)
+	panicIf(d == nil, "%s doesn't exist", uri)
+	logf("added language %s to %s\n", code, s.LangURL(lang))
+}
+
+func (s *apptranslatorService) AddLanguage(lang string) {
+	if dryRunSkip("add language %s to %s", lang, apptranslatoServer) {
+		return
+	}
+	uri := apptranslatorURL("/api/addlang") + "&lang=" + url.QueryEscape(lang) + "&name=" + url.QueryEscape(getLangName(lang))
+	req, err := http.NewRequest(http.MethodPost, uri, nil)
+	must(err)
+	apptranslatorDoMust(req)
+	logf("added language %s to %s\n", lang, s.LangURL(lang))
+}
+
+// -trans-add-language
+func transAddLanguageMust(args []string) {
+	l := parseNewTransLangMust(args)
+	// fail early if translation service is not configured
+	svc := getTransServiceMust()
+
+	src := string(readFileMust(transLangsGoPath))
+	writeFileMust(transLangsGoPath, []byte(addLangToTransLangsGo(src, l)))
+	logf("added %s to '%s'\n", l.Code, transLangsGoPath)
+
+	// generators use gLangs of this binary, which doesn't have the new language
+	gLangs = append(gLangs, l.gLangsEntry())
+	if l.ISOCode != "" {
+		gLangISOCodes[l.Code] = l.ISOCode
+	}
+	runGenerators([]string{"translation-langs"})
+
+	svc.AddLanguage(l.Code)
+	logf("added language %s (%s). Commit the changes and ask translators to translate at %s\n", l.Code, l.Name, svc.LangURL(l.Code))
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// checks of translations.txt downloaded from apptranslator.org, done by
+// -trans-download before we save it.
+//
+// Translation must have the same printf-style format specifiers in the same
+// order as English text, otherwise the app can crash or show garbage.
+// Such translations are removed so the app shows English text instead.
+// Number of & (menu accelerators) should also match.
+//
+// Translations must also fit the format of translations-good.txt, which the
+// app parses without much checking (see ParseTranslationsTxt() in
+// src/Translations.cpp): valid UTF-8, no control characters (including
+// literal tabs and newlines, which must be escaped), only \\, \n, \r and \t
+// escapes. We also reject text mangled by wrong encoding (U+FFFD, "Ã©")
+// and HTML, which is never in our strings and might be an attempt to inject
+// links. Rejected translations are saved in out/translations-quarantine.txt
+// so they can be looked at and fixed on the translation service.
+//
+// Translations to RTL languages (marked "RTL" in gLangs) are also checked for
+// bidi problems:
+//   - unbalanced directional embeddings / isolates, which also garble text
+//     that follows
+//   - punctuation that ends English text at the start of translation,
+//     usually moved there to look right in LTR editor
+//   - & not followed by a letter, because the accelerator is then invisible
+//   - format specifiers not isolated with directional marks, so that LTR
+//     text (file names, numbers) inserted there is shown in the right order
+
+const (
+	// listed
+	kTransWarning = iota
+	// only counted per language because there are many of them
+	kTransMinor
+	// translation is removed
+	kTransReject
+	// can't use translations.txt
+	kTransFatal
+)
+
+// TransProblem is a problem with a translation
+type TransProblem struct {
+	Lang string
+	Text string
+	// translated text, "" if the problem is with English text
+	Translation string
+	Msg         string
+	Severity    int
+}
+
+func isRtlLang(lang string) bool {
+	for _, l := range gLangs {
+		if l[0] == lang {
+			return len(l) > 3 && l[3] == "RTL"
+		}
+	}
+	return false
+}
+
+const (
+	bidiLRM = '\u200E'
+	bidiRLM = '\u200F'
+	bidiALM = '\u061C'
+	bidiLRE = '\u202A'
+	bidiRLE = '\u202B'
+	bidiPDF = '\u202C'
+	bidiLRO = '\u202D'
+	bidiRLO = '\u202E'
+	bidiLRI = '\u2066'
+	bidiRLI = '\u2067'
+	bidiFSI = '\u2068'
+	bidiPDI = '\u2069'
+)
+
+// returns "" if directional embeddings and isolates are balanced
+func checkBidiBalance(s string) string {
+	var stack []rune
+	for _, c := range s {
+		switch c {
+		case bidiLRE, bidiRLE, bidiLRO, bidiRLO, bidiLRI, bidiRLI, bidiFSI:
+			stack = append(stack, c)
+		case bidiPDF, bidiPDI:
+			n := len(stack)
+			isIsolate := func(r rune) bool {
+				return r == bidiLRI || r == bidiRLI || r == bidiFSI
+			}
+			if n == 0 || isIsolate(stack[n-1]) != (c == bidiPDI) {
+				return fmt.Sprintf("U+%04X without matching start", c)
+			}
+			stack = stack[:n-1]
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Sprintf("U+%04X not terminated", stack[len(stack)-1])
+	}
+	return ""
+}
+
+// every format specifier must be preceded or followed by a directional mark
+func areFormatSpecifiersIsolated(s string) bool {
+	isMarkBefore := func(r rune) bool {
+		return r == bidiLRM || r == bidiRLM || r == bidiALM || r == bidiLRI || r == bidiRLI || r == bidiFSI
+	}
+	isMarkAfter := func(r rune) bool {
+		return r == bidiLRM || r == bidiRLM || r == bidiALM || r == bidiPDI
+	}
+	for _, m := range rxFormatSpecifier.FindAllStringIndex(s, -1) {
+		if s[m[0]:m[1]] == "%%" {
+			continue
+		}
+		before, _ := utf8.DecodeLastRuneInString(s[:m[0]])
+		after, _ := utf8.DecodeRuneInString(s[m[1]:])
+		if !isMarkBefore(before) && !isMarkAfter(after) {
+			return false
+		}
+	}
+	return true
+}
+
+// returns true if every & (except &&) is followed by a letter or digit
+func areAcceleratorsOnLetters(s string) bool {
+	s = strings.ReplaceAll(s, "&&", "")
+	for {
+		idx := strings.IndexByte(s, '&')
+		if idx < 0 {
+			return true
+		}
+		s = s[idx+1:]
+		c, _ := utf8.DecodeRuneInString(s)
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return false
+		}
+	}
+}
+
+// returns a problem if trans to RTL language has bidi problems
+func checkRtlTranslation(text string, trans string) (string, int) {
+	if msg := checkBidiBalance(trans); msg != "" {
+		return "unbalanced directional formatting characters: " + msg, kTransWarning
+	}
+	const punct = ".:,;!?"
+	textEnd, _ := utf8.DecodeLastRuneInString(text)
+	transStart, _ := utf8.DecodeRuneInString(trans)
+	if strings.ContainsRune(punct, textEnd) && strings.ContainsRune(punct, transStart) {
+		return fmt.Sprintf("starts with '%c' which ends English text, it should be at the end", transStart), kTransWarning
+	}
+	if !areAcceleratorsOnLetters(trans) {
+		return "& (menu accelerator) must be followed by a letter", kTransWarning
+	}
+	if !areFormatSpecifiersIsolated(trans) {
+		return "format specifiers not isolated with directional marks (U+200F or U+2068/U+2069)", kTransMinor
+	}
+	return "", 0
+}
+
+var (
+	rxLangCode   = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]+)?$`)
+	rxHTMLTag    = regexp.MustCompile(`<\s*/?\s*[a-zA-Z][^>]*>`)
+	rxHTMLEntity = regexp.MustCompile(`&(?:[a-zA-Z]+|#[0-9]+|#x[0-9a-fA-F]+);`)
+	// UTF-8 decoded as Windows-1252 / Latin-1 e.g. "é" => "Ã©"
+	rxMojibake = regexp.MustCompile(`[ÃÂ][\x{80}-\x{BF}]`)
+)
+
+// max number of strings, the app uses 16-bit indexes
+const kMaxTransStrings = 64 * 1024
+
+// returns "" if s can be stored in translations-good.txt
+func checkTransEncoding(s string) string {
+	if !utf8.ValidString(s) {
+		return "invalid UTF-8"
+	}
+	for _, c := range s {
+		if unicode.IsControl(c) {
+			return fmt.Sprintf("control character U+%04X, use \\n or \\t for new line or tab", c)
+		}
+		if c == utf8.RuneError || c == '\uFEFF' {
+			return fmt.Sprintf("U+%04X, text was probably corrupted by wrong encoding", c)
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "\\ at the end, use \\\\ for literal \\"
+		}
+		// \| separates plural forms, see trans_plural.go
+		if !strings.ContainsRune(`\ntr|`, rune(s[i])) {
+			return fmt.Sprintf("invalid escape \\%c, use \\\\ for literal \\", s[i])
+		}
+	}
+	return ""
+}
+
+// returns "" if there's nothing suspicious in trans of text
+func checkTransContent(text string, trans string) string {
+	if m := rxMojibake.FindString(trans); m != "" && !strings.Contains(text, m) {
+		return fmt.Sprintf("'%s' looks like text in wrong encoding", m)
+	}
+	if m := rxHTMLTag.FindString(trans); m != "" && !rxHTMLTag.MatchString(text) {
+		return fmt.Sprintf("HTML tag '%s'", m)
+	}
+	if m := rxHTMLEntity.FindString(trans); m != "" && !rxHTMLEntity.MatchString(text) {
+		return fmt.Sprintf("HTML entity '%s', use the character", m)
+	}
+	if strings.Contains(strings.ToLower(trans), "javascript:") {
+		return "javascript: url"
+	}
+	return ""
+}
+
+func isKnownLang(lang string) bool {
+	for _, l := range gLangs {
+		if l[0] == lang {
+			return true
+		}
+	}
+	return false
+}
+
+var rxFormatSpecifier = regexp.MustCompile(`%(?:\d+\$)?[-+ #0]*(?:\d+|\*)?(?:\.(?:\d+|\*))?(?:hh|h|ll|l|L|z|j|t|I64|I32)?[diouxXeEfFgGaAcspSn%]`)
+
+// "Page %d of %s (100%%)" => ["%d", "%s"]
+func getFormatSpecifiers(s string) []string {
+	var res []string
+	for _, m := range rxFormatSpecifier.FindAllString(s, -1) {
+		if m != "%%" {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// "&&" is a literal &
+func countAccelerators(s string) int {
+	return strings.Count(strings.ReplaceAll(s, "&&", ""), "&")
+}
+
+// returns nil if translation is ok
+func checkTranslation(lang string, text string, trans string) *TransProblem {
+	p := &TransProblem{Lang: lang, Text: text, Translation: trans}
+	specsMsg := ""
+	if origSpecs, transSpecs := getFormatSpecifiers(text), getFormatSpecifiers(trans); !reflect.DeepEqual(origSpecs, transSpecs) {
+		specsMsg = fmt.Sprintf("format specifiers %v don't match %v", transSpecs, origSpecs)
+	}
+	nOrigAcc, nTransAcc := countAccelerators(text), countAccelerators(trans)
+	pluralMsg := checkPluralForms(lang, text, trans)
+	if isPluralTransString(text) {
+		specsMsg = checkPluralFormatSpecifiers(text, trans)
+		// plural forms can have different number of words with &
+		nOrigAcc, nTransAcc = 0, 0
+	}
+	rtlMsg, rtlSeverity := "", 0
+	if isRtlLang(lang) {
+		rtlMsg, rtlSeverity = checkRtlTranslation(text, trans)
+	}
+	encodingMsg := checkTransEncoding(trans)
+	contentMsg := ""
+	if encodingMsg == "" {
+		contentMsg = checkTransContent(text, trans)
+	}
+	switch {
+	case !rxLangCode.MatchString(lang):
+		p.Msg, p.Severity = "invalid language code", kTransReject
+	case encodingMsg != "":
+		p.Msg, p.Severity = encodingMsg, kTransReject
+	case contentMsg != "":
+		p.Msg, p.Severity = contentMsg, kTransReject
+	case !isKnownLang(lang):
+		p.Msg = "unknown language, add it to gLangs in do/trans_langs.go"
+	case strings.TrimSpace(trans) == "":
+		p.Msg, p.Severity = "empty translation", kTransReject
+	case pluralMsg != "":
+		p.Msg, p.Severity = pluralMsg, kTransReject
+	case specsMsg != "":
+		p.Msg, p.Severity = specsMsg, kTransReject
+	case rtlMsg != "" && rtlSeverity == kTransWarning:
+		p.Msg = rtlMsg
+	case nTransAcc > nOrigAcc:
+		p.Msg = fmt.Sprintf("has %d & (menu accelerators), expected %d. Use && for literal &", nTransAcc, nOrigAcc)
+	case nTransAcc < nOrigAcc:
+		p.Msg, p.Severity = "missing & (menu accelerator)", kTransMinor
+	case rtlMsg != "":
+		p.Msg, p.Severity = rtlMsg, rtlSeverity
+	default:
+		return nil
+	}
+	return p
+}
+
+// length of translation as shown in the ui: unescaped and without & (menu
+// accelerator)
+func getTransDisplayLen(trans string) int {
+	s := strings.ReplaceAll(trans, "&&", "\x00")
+	s = strings.ReplaceAll(s, "&", "")
+	s = transUnescaper.Replace(s)
+	return utf8.RuneCountInString(s)
+}
+
+var transUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r", `\t`, "\t")
+
+// returns "" if translation fits in length budget of the string
+func checkTransMaxLen(s *TransString, trans string) string {
+	if s == nil || s.MaxLen == 0 {
+		return ""
+	}
+	for _, form := range splitPluralForms(trans) {
+		if n := getTransDisplayLen(form); n > s.MaxLen {
+			return fmt.Sprintf("too long (%d characters), must be at most %d or it'll be clipped", n, s.MaxLen)
+		}
+	}
+	return ""
+}
+
+// returns content of translations.txt without rejected translations and
+// problems. known are strings in src/ by text
+func checkTranslations(d string, known map[string]*TransString) (string, []*TransProblem) {
+	var res []*TransProblem
+	lines := strings.Split(d, "\n")
+	if len(lines) < 2 || lines[0] != "AppTranslator: SumatraPDF" {
+		return d, []*TransProblem{{Msg: "invalid header, expected 'AppTranslator: SumatraPDF'", Severity: kTransFatal}}
+	}
+	fixed := lines[:2:2]
+	currStr := ""
+	seenLangs := map[string]bool{}
+	nStrings := 0
+	for _, l := range lines[2:] {
+		if l == "" {
+			fixed = append(fixed, l)
+			continue
+		}
+		if l[0] == ':' {
+			currStr = l[1:]
+			seenLangs = map[string]bool{}
+			nStrings++
+			if msg := checkTransEncoding(currStr); msg != "" {
+				res = append(res, &TransProblem{Text: currStr, Msg: "English text: " + msg, Severity: kTransFatal})
+			}
+			if known[currStr] == nil {
+				res = append(res, &TransProblem{Text: currStr, Msg: "not used in src/"})
+			}
+			fixed = append(fixed, l)
+			continue
+		}
+		lang, trans, ok := strings.Cut(l, ":")
+		if !ok || currStr == "" {
+			res = append(res, &TransProblem{Text: currStr, Msg: fmt.Sprintf("invalid line '%s'", l), Severity: kTransFatal})
+			continue
+		}
+		var p *TransProblem
+		if seenLangs[lang] {
+			p = &TransProblem{Lang: lang, Text: currStr, Translation: trans, Msg: "more than one translation", Severity: kTransReject}
+		} else {
+			p = checkTranslation(lang, currStr, trans)
+		}
+		seenLangs[lang] = true
+		if p != nil {
+			res = append(res, p)
+			if p.Severity == kTransReject {
+				continue
+			}
+		}
+		// clipped text is still better than English
+		if msg := checkTransMaxLen(known[currStr], trans); msg != "" {
+			res = append(res, &TransProblem{Lang: lang, Text: currStr, Translation: trans, Msg: msg})
+		}
+		fixed = append(fixed, l)
+	}
+	if nStrings > kMaxTransStrings {
+		res = append(res, &TransProblem{Msg: fmt.Sprintf("%d strings, the app supports at most %d", nStrings, kMaxTransStrings), Severity: kTransFatal})
+	}
+	return strings.Join(fixed, "\n"), res
+}
+
+var transQuarantinePath = filepath.Join("out", "translations-quarantine.txt")
+
+/*
+translations-quarantine.txt has rejected translations:
+
+# rejected translations downloaded from ${service} on ${time}
+:${english}
+# ${problem}
+${lang}:${translation}
+
+Translations with encoding problems are Go-quoted.
+*/
+func writeTransQuarantineMust(svc TransService, problems []*TransProblem) {
+	byText := map[string][]*TransProblem{}
+	var texts []string
+	for _, p := range problems {
+		if p.Severity != kTransReject {
+			continue
+		}
+		if byText[p.Text] == nil {
+			texts = append(texts, p.Text)
+		}
+		byText[p.Text] = append(byText[p.Text], p)
+	}
+	if len(texts) == 0 {
+		return
+	}
+	sort.Strings(texts)
+	lines := []string{fmt.Sprintf("# rejected translations downloaded from %s on %s", svc.Name(), time.Now().Format("2006-01-02 15:04"))}
+	n := 0
+	for _, text := range texts {
+		lines = append(lines, ":"+text)
+		for _, p := range byText[text] {
+			trans := p.Translation
+			if checkTransEncoding(trans) != "" {
+				trans = strconv.Quote(trans)
+			}
+			lines = append(lines, "# "+p.Msg, p.Lang+":"+trans)
+			n++
+		}
+	}
+	must(createDirForFile(transQuarantinePath))
+	writeFileMust(transQuarantinePath, []byte(strings.Join(lines, "\n")+"\n"))
+	logf("wrote %d rejected translations to '%s'\n", n, transQuarantinePath)
+}
+
+func printTransProblems(problems []*TransProblem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Lang < problems[j].Lang
+	})
+	// lang => msg => count
+	nMinor := map[string]map[string]int{}
+	for _, p := range problems {
+		kind := "warning"
+		switch p.Severity {
+		case kTransMinor:
+			if nMinor[p.Lang] == nil {
+				nMinor[p.Lang] = map[string]int{}
+			}
+			nMinor[p.Lang][p.Msg]++
+			continue
+		case kTransReject:
+			kind = "removed"
+		case kTransFatal:
+			kind = "error"
+		}
+		if p.Lang == "" {
+			logf("%s: '%s': %s\n", kind, p.Text, p.Msg)
+			continue
+		}
+		logf("%s: %s: '%s' => '%s': %s\n", kind, p.Lang, p.Text, p.Translation, p.Msg)
+	}
+	var langs []string
+	for lang := range nMinor {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		var msgs []string
+		for msg := range nMinor[lang] {
+			msgs = append(msgs, msg)
+		}
+		sort.Strings(msgs)
+		for _, msg := range msgs {
+			logf("%s: %d translations: %s\n", lang, nMinor[lang][msg], msg)
+		}
+	}
+}
+
+// returns translations.txt without rejected translations
+func validateTranslationsMust(svc TransService, d []byte) []byte {
+	known := map[string]*TransString{}
+	for _, s := range extractTransStringsMust() {
+		known[s.Text] = s
+	}
+	fixed, problems := checkTranslations(string(d), known)
+	printTransProblems(problems)
+	writeTransQuarantineMust(svc, problems)
+	nBySeverity := map[int]int{}
+	for _, p := range problems {
+		nBySeverity[p.Severity]++
+	}
+	panicIf(nBySeverity[kTransFatal] > 0, "%d errors in translations", nBySeverity[kTransFatal])
+	logf("checked translations: removed %d bad translations, %d warnings\n", nBySeverity[kTransReject], nBySeverity[kTransWarning]+nBySeverity[kTransMinor])
+	if nBySeverity[kTransReject] > 0 {
+		logf("fix removed translations on %s\n", svc.Name())
+	}
+	return []byte(fixed)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TRANSLATORS credits people who translated SumatraPDF and is linked from
+// About window. The part after translatorsMarker is re-generated by
+// -trans-download from contributors on translation service, the part
+// before it is edited by hand.
+//
+// translators.html on the website shows both (without emails). It's written
+// together with other docs (-gen-docs-website).
+
+const (
+	translatorsPath     = "TRANSLATORS"
+	translatorsPageName = "translators.html"
+	translatorsMarker   = `Translators on translation service (updated by .\doit.bat -trans-download, don't edit below this line):`
+)
+
+var rxTranslatorEmail = regexp.MustCompile(`,?\s*\(?[\w.+-]+@[\w-]+(\.[\w-]+)+\)?`)
+
+// "German (Deutsch)" => "German"
+func getLangEnglishName(lang string) string {
+	name, _, _ := strings.Cut(getLangName(lang), " (")
+	return name
+}
+
+// "${language}:" followed by "* ${name}" lines, sorted by language and name
+func genTranslatorsSection(contributors map[string][]string) string {
+	byLangName := map[string][]string{}
+	var langNames []string
+	for lang, names := range contributors {
+		if lang == "en" {
+			continue
+		}
+		langName := getLangEnglishName(lang)
+		seen := map[string]bool{}
+		var sorted []string
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			sorted = append(sorted, name)
+		}
+		if len(sorted) == 0 {
+			continue
+		}
+		sort.Strings(sorted)
+		byLangName[langName] = sorted
+		langNames = append(langNames, langName)
+	}
+	sort.Strings(langNames)
+	var a []string
+	for _, langName := range langNames {
+		s := langName + ":\n"
+		for _, name := range byLangName[langName] {
+			s += "* " + name + "\n"
+		}
+		a = append(a, s)
+	}
+	return strings.Join(a, "\n")
+}
+
+// replaces generated part of TRANSLATORS (src) with section, keeps BOM and
+// CRLF line endings of the file
+func updateTranslatorsFile(src string, section string) string {
+	s := strings.TrimPrefix(normalizeNewlines(src), "\ufeff")
+	before, _, _ := strings.Cut(s, translatorsMarker)
+	s = strings.TrimRight(before, "\n") + "\n\n" + translatorsMarker + "\n\n" + section
+	return "\ufeff" + strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+// called from -trans-download
+func updateTranslatorsFileMust(svc TransService) {
+	contributors := svc.GetContributors()
+	curr := string(readFileMust(translatorsPath))
+	d := updateTranslatorsFile(curr, genTranslatorsSection(contributors))
+	if d == curr {
+		logf("%s didn't change\n", translatorsPath)
+		return
+	}
+	writeFileMust(translatorsPath, []byte(d))
+	logf("Wrote %s with translators of %d languages\n", translatorsPath, len(contributors))
+}
+
+func (s *apptranslatorService) GetContributors() map[string][]string {
+	req, err := http.NewRequest(http.MethodGet, apptranslatorURL("/api/translators"), nil)
+	must(err)
+	d := apptranslatorDoMust(req)
+	// "${lang}:${name}", one per line
+	res := map[string][]string{}
+	for _, s := range strings.Split(normalizeNewlines(string(d)), "\n") {
+		lang, name, ok := strings.Cut(s, ":")
+		if ok && isKnownLang(lang) {
+			res[lang] = append(res[lang], name)
+		}
+	}
+	return res
+}
+
+func (s *weblateService) GetContributors() map[string][]string {
+	res := map[string][]string{}
+	end := time.Now().UTC().Format("2006-01-02")
+	for _, l := range gLangs {
+		lang := l[0]
+		if lang == "en" {
+			continue
+		}
+		v := url.Values{}
+		v.Set("lang", weblateLangCode(lang))
+		v.Set("start", "2000-01-01")
+		v.Set("end", end)
+		uri := fmt.Sprintf("%s/api/components/%s/%s/credits/?%s", s.url, weblateProject, weblateComponent, v.Encode())
+		// language name => contributors
+		var credits []map[string][]struct {
+			FullName string `json:"full_name"`
+		}
+		s.getJSONMust(uri, &credits)
+		for _, m := range credits {
+			for _, a := range m {
+				for _, c := range a {
+					res[lang] = append(res[lang], c.FullName)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// TranslatorsLang is a language with people who translated it
+type TranslatorsLang struct {
+	Name  string
+	Names []string
+}
+
+// parses TRANSLATORS into hand-written credits (without emails) and
+// generated credits grouped by language
+func parseTranslatorsFile(src string) ([]string, []*TranslatorsLang) {
+	s := strings.TrimPrefix(normalizeNewlines(src), "\ufeff")
+	before, after, _ := strings.Cut(s, translatorsMarker)
+	var earlier []string
+	for _, line := range strings.Split(before, "\n") {
+		if line, ok := strings.CutPrefix(line, "* "); ok {
+			earlier = append(earlier, strings.TrimSpace(rxTranslatorEmail.ReplaceAllString(line, "")))
+		}
+	}
+	var langs []*TranslatorsLang
+	for _, line := range strings.Split(after, "\n") {
+		if name, ok := strings.CutPrefix(line, "* "); ok && len(langs) > 0 {
+			l := langs[len(langs)-1]
+			l.Names = append(l.Names, name)
+		} else if langName, ok := strings.CutSuffix(line, ":"); ok && line != "" {
+			langs = append(langs, &TranslatorsLang{Name: langName})
+		}
+	}
+	return earlier, langs
+}
+
+const translatorsPageTmpl = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="generator" content="generated from TRANSLATORS by .\doit.bat -gen-docs-website, don't edit">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>SumatraPDF translators</title>
+<link rel="stylesheet" href="/sumatra.css">
+<style>
+.trans td { padding: 1px 8px 1px 0; vertical-align: top; }
+.trans td.lang { color: #666; white-space: nowrap; }
+</style>
+</head>
+<body>
+<h2>SumatraPDF translators</h2>
+<p>SumatraPDF is available in many languages thanks to people who translated it.
+Thank you! You can help too, see <a href="/docs/Contribute-translation">how to contribute a translation</a>.</p>
+
+{{if .Langs}}<h3>Translators on <a href="{{.ServiceURL}}">{{.ServiceURL}}</a></h3>
+<table class="trans">
+{{range .Langs}}<tr><td class="lang">{{.Name}}</td><td>{{range $i, $n := .Names}}{{if $i}}, {{end}}{{$n}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Earlier}}<h3>Also translated by</h3>
+<ul>
+{{range .Earlier}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}
+</body>
+</html>
+`
+
+func genTranslatorsPage(src string, serviceURL string) string {
+	earlier, langs := parseTranslatorsFile(src)
+	tmpl := template.Must(template.New("").Parse(translatorsPageTmpl))
+	var buf bytes.Buffer
+	v := map[string]interface{}{
+		"Earlier":    earlier,
+		"Langs":      langs,
+		"ServiceURL": serviceURL,
+	}
+	must(tmpl.Execute(&buf, v))
+	return buf.String()
+}
+
+// dir is www directory of website repo
+func writeTranslatorsPageMust(dir string) {
+	src := string(readFileMust(translatorsPath))
+	d := genTranslatorsPage(src, getTransServiceMust().LangURL(""))
+	path := filepath.Join(dir, translatorsPageName)
+	writeFileMust(path, []byte(d))
+	logf("wrote '%s'\n", path)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// -trans-diff ${ref1} ${ref2} [${lang}] compares translations embedded in
+// the exe (translations/translations-good.txt) in 2 releases and shows how
+// many translations were added, changed and removed in each language. Refs
+// are git refs or versions (3.5.2 means tag 3.5.2rel), ${ref2} can be HEAD.
+// With ${lang}, also shows the strings that changed in that language, to
+// audit unexpected changes.
+//
+// -gen-release-notes uses it to summarize translation commits as
+// "Updated translations for 14 languages".
+
+// git paths of embedded translations, older releases embedded translations.txt
+var transEmbeddedGitPaths = []string{"translations/translations-good.txt", "translations/translations.txt"}
+
+var rxPlainVersion = regexp.MustCompile(`^\d+(?:\.\d+)*$`)
+
+// "3.5.2" => "3.5.2rel"
+func resolveTransDiffRef(ref string) string {
+	if rxPlainVersion.MatchString(ref) && isGitTagExistsMust(ref+"rel") {
+		return ref + "rel"
+	}
+	return ref
+}
+
+// english => translations. Machine translations ("${lang}*:") are treated
+// as regular translations
+func getEmbeddedTranslationsAtRefMust(ref string) map[string][]*Translation {
+	for _, path := range transEmbeddedGitPaths {
+		gitPath := ref + ":" + path
+		if exec.Command("git", "cat-file", "-e", gitPath).Run() != nil {
+			continue
+		}
+		d := string(runExeMust("git", "show", gitPath))
+		res := parseTranslations(normalizeNewlines(d))
+		for _, a := range res {
+			for _, tr := range a {
+				tr.Lang = strings.TrimSuffix(tr.Lang, transMtMarker)
+			}
+		}
+		return res
+	}
+	panicIf(true, "none of %v exist in %s", transEmbeddedGitPaths, ref)
+	return nil
+}
+
+func getChangedTransDeltas(deltas []*TransLangDelta) []*TransLangDelta {
+	var res []*TransLangDelta
+	for _, d := range deltas {
+		if d.Added+d.Changed+d.Removed > 0 {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// "Updated translations for 3 languages: German, Polish, Turkish"
+func getTransDiffSummary(deltas []*TransLangDelta) string {
+	changed := getChangedTransDeltas(deltas)
+	if len(changed) == 0 {
+		return ""
+	}
+	var names []string
+	for _, d := range changed {
+		names = append(names, getLangEnglishName(d.Lang))
+	}
+	sort.Strings(names)
+	if len(changed) == 1 {
+		return "Updated translation for " + names[0]
+	}
+	return fmt.Sprintf("Updated translations for %d languages: %s", len(changed), strings.Join(names, ", "))
+}
+
+func printTransLangDiff(lang string, prev map[string]string, curr map[string]string) {
+	var strs []string
+	for s := range prev {
+		strs = append(strs, s)
+	}
+	for s := range curr {
+		if _, ok := prev[s]; !ok {
+			strs = append(strs, s)
+		}
+	}
+	sort.Strings(strs)
+	logf("\n%s:\n", getLangName(lang))
+	for _, s := range strs {
+		p, inPrev := prev[s]
+		c, inCurr := curr[s]
+		switch {
+		case !inPrev:
+			logf("+ '%s' => '%s'\n", s, c)
+		case !inCurr:
+			logf("- '%s' => '%s'\n", s, p)
+		case p != c:
+			logf("~ '%s' => '%s' (was '%s')\n", s, c, p)
+		}
+	}
+}
+
+// -trans-diff ${ref1} ${ref2} [${lang}]
+func transDiffMust(args []string) {
+	panicIf(len(args) < 2 || len(args) > 3, "usage: -trans-diff ${ref1} ${ref2} [${lang}] e.g. -trans-diff 3.5.2 HEAD de")
+	ref1, ref2 := resolveTransDiffRef(args[0]), resolveTransDiffRef(args[1])
+	prev := getEmbeddedTranslationsAtRefMust(ref1)
+	curr := getEmbeddedTranslationsAtRefMust(ref2)
+	deltas := getTranslationsDelta(prev, curr)
+	logf("translations in %s compared to %s:\n", ref2, ref1)
+	printTranslationsDelta(deltas)
+	if summary := getTransDiffSummary(deltas); summary != "" {
+		logf("%s\n", summary)
+	}
+	if len(args) == 3 {
+		lang := args[2]
+		panicIf(!isKnownLang(lang), "unknown language '%s'", lang)
+		printTransLangDiff(lang, translationsByLang(prev)[lang], translationsByLang(curr)[lang])
+	}
+}
@@ -41,7 +41,7 @@ type BadTranslation struct {
 
 var badTranslatins []BadTranslation
 
-func printBadTranslations() {
+func printBadTranslations(svc TransService) {
 	sort.Slice(badTranslatins, func(i, j int) bool {
 		return badTranslatins[i].orig < badTranslatins[j].orig
 	})
@@ -50,8 +50,7 @@ func printBadTranslations() {
 		lang := strings.Split(bt.orig, ":")[0]
 		if lang != currLang {
 			currLang = lang
-			uri := "https://www.apptranslator.org/app/SumatraPDF/" + lang
-			fmt.Printf("\n%s\n", uri)
+			fmt.Printf("\n%s\n", svc.LangURL(lang))
 		}
 		fmt.Printf("%s\n  '%s' => '%s'\n", bt.currString, bt.orig, bt.fixed)
 	}
@@ -82,12 +81,12 @@ func fixTranslations(d []byte) []byte {
 	return res[:len(res)-1] // remove last \n
 }
 
-func downloadTranslationsMust() []byte {
+// downloads translations of strs from apptranslator.org
+func downloadTranslationsMust(strs []string) []byte {
 	timeStart := time.Now()
 	defer func() {
 		fmt.Printf("downloadTranslations() finished in %s\n", time.Since(timeStart))
 	}()
-	strs := extractStringsFromCFilesNoPaths()
 	sort.Strings(strs)
 	fmt.Printf("uploading %d strings for translation\n", len(strs))
 	secret := getTransSecret()
@@ -152,6 +151,25 @@ func generateGoodSubset(d []byte) {
 		addLangTrans(lang, trans)
 	}
 
+	// machine translations (-trans-mt-fill) of strings people didn't
+	// translate yet
+	isString := map[string]bool{}
+	for _, s := range allStrings {
+		isString[s] = true
+	}
+	isMachine := map[string]map[string]bool{}
+	for lang, m := range readMachineTranslations() {
+		isMachine[lang] = map[string]bool{}
+		for s, trans := range m {
+			if !isString[s] || perLang[lang][s] != "" {
+				continue
+			}
+			currString = s
+			addLangTrans(lang, trans)
+			isMachine[lang][s] = true
+		}
+	}
+
 	nStrings := len(allStrings)
 	langsToSkip := map[string]bool{}
 	for lang, m := range perLang {
@@ -230,6 +248,10 @@ func generateGoodSubset(d []byte) {
 			if len(trans) == 0 {
 				continue
 			}
+			if isMachine[lang][s] {
+				a = append(a, lang+transMtMarker+":"+trans)
+				continue
+			}
 			a = append(a, lang+":"+trans)
 		}
 	}
@@ -242,27 +264,103 @@ func generateGoodSubset(d []byte) {
 	logf("fully translated langs: %v\n", fullyTranslated)
 }
 
+// TransLangDelta is what changed in translations of a language since
+// the last download
+type TransLangDelta struct {
+	Lang    string
+	Added   int
+	Changed int
+	Removed int
+	// strings without translation after download
+	Missing int
+}
+
+// lang => english => translation
+func translationsByLang(m map[string][]*Translation) map[string]map[string]string {
+	res := map[string]map[string]string{}
+	for _, a := range m {
+		for _, tr := range a {
+			if res[tr.Lang] == nil {
+				res[tr.Lang] = map[string]string{}
+			}
+			res[tr.Lang][tr.Text] = tr.Translation
+		}
+	}
+	return res
+}
+
+func getTranslationsDelta(prev map[string][]*Translation, curr map[string][]*Translation) []*TransLangDelta {
+	prevByLang := translationsByLang(prev)
+	currByLang := translationsByLang(curr)
+	var res []*TransLangDelta
+	for _, l := range gLangs {
+		lang := l[0]
+		if lang == "en" {
+			continue
+		}
+		p, c := prevByLang[lang], currByLang[lang]
+		d := &TransLangDelta{Lang: lang}
+		for s, tr := range c {
+			prevTr, ok := p[s]
+			if !ok {
+				d.Added++
+			} else if prevTr != tr {
+				d.Changed++
+			}
+		}
+		for s := range p {
+			if _, ok := c[s]; !ok {
+				d.Removed++
+			}
+		}
+		d.Missing = len(curr) - len(c)
+		res = append(res, d)
+	}
+	return res
+}
+
+func printTranslationsDelta(deltas []*TransLangDelta) {
+	nUnchanged := 0
+	logf("%-6s %6s %8s %8s %8s\n", "lang", "added", "changed", "removed", "missing")
+	for _, d := range deltas {
+		if d.Added+d.Changed+d.Removed == 0 {
+			nUnchanged++
+			continue
+		}
+		logf("%-6s %6d %8d %8d %8d\n", d.Lang, d.Added, d.Changed, d.Removed, d.Missing)
+	}
+	logf("%d languages didn't change\n", nUnchanged)
+}
+
+// -trans-download downloads translations, checks them, saves them in
+// translations/translations.txt, re-generates translations-good.txt (which
+// is embedded in the exe) and shows what changed in each language since
+// the last download. Running it again when nothing changed on the server
+// doesn't change any files.
 func downloadTranslations() bool {
-	d := downloadTranslationsMust()
+	runGenerators([]string{"trans-strings"})
+	svc := getTransServiceMust()
+	d := svc.DownloadTranslations(extractStringsFromCFilesNoPaths())
 	d = fixTranslations(d)
 
-	printBadTranslations()
+	printBadTranslations(svc)
+	d = validateTranslationsMust(svc, d)
+	printAccessKeyClashesSummary(parseTranslations(string(d)))
 
-	path := filepath.Join(translationsDir, "translations.txt")
+	path := translationsTxtPath
 	curr := readFileMust(path)
+	printTranslationsDelta(getTranslationsDelta(parseTranslations(string(curr)), parseTranslations(string(d))))
 	if bytes.Equal(d, curr) {
-		fmt.Printf("Translations didn't change\n")
-		//return false
+		logf("Translations didn't change\n")
+	} else {
+		// TODO: save ~400k in uncompressed binary by
+		// saving as gzipped and embedding that in the exe
+		//u.WriteFileGzipped(translationsTxtPath+".gz", d)
+		writeFileMust(path, d)
+		logf("Wrote %s of size %d\n", path, len(d))
 	}
-
-	generateGoodSubset(d)
-
-	// TODO: save ~400k in uncompressed binary by
-	// saving as gzipped and embedding that in the exe
-	//u.WriteFileGzipped(translationsTxtPath+".gz", d)
-	writeFileMust(path, d)
-	logf("Wrote %s of size %d\n", path, len(d))
-
+	runGenerators([]string{"translations-good"})
+	updateTranslatorsFileMust(svc)
 	return false
 }
 
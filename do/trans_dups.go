@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// -trans-dups shows strings in src/ that only differ by case, trailing
+// punctuation or whitespace e.g. "Save As" and "Save as...". Each of them is
+// translated separately and stored separately in the exe. Using one of them
+// everywhere makes less work for translators and a smaller exe.
+//
+// For each group we suggest keeping the variant with the most translations.
+// & (menu accelerator) is significant because menus need it and buttons or
+// labels usually don't.
+
+// TransDupGroup are strings that only differ in insignificant ways
+type TransDupGroup struct {
+	// "case", "punctuation", "whitespace"
+	Differences []string
+	// sorted by number of translations, most translated first
+	Variants []*TransDupVariant
+}
+
+// TransDupVariant is one of the strings in TransDupGroup
+type TransDupVariant struct {
+	*TransString
+	NTranslations int
+	// size of the string and its translations in translations-good.txt
+	NBytes int
+}
+
+// escaped whitespace at the end e.g. "foo\n"
+var transTrailingEscapes = []string{`\n`, `\r`, `\t`}
+
+func trimTransWhitespace(s string) string {
+	for {
+		prev := s
+		s = strings.TrimSpace(s)
+		for _, esc := range transTrailingEscapes {
+			s = strings.TrimSuffix(s, esc)
+			s = strings.TrimPrefix(s, esc)
+		}
+		if s == prev {
+			return s
+		}
+	}
+}
+
+const transTrailingPunct = ".:,;!?…"
+
+func trimTransTrailingPunct(s string) string {
+	return strings.TrimRight(s, transTrailingPunct+" ")
+}
+
+// strings with the same key are duplicates
+func transDupKey(s string) string {
+	s = trimTransWhitespace(s)
+	s = trimTransTrailingPunct(s)
+	return strings.ToLower(s)
+}
+
+var transWhitespaceRemover = strings.NewReplacer(" ", "", `\n`, "", `\r`, "", `\t`, "")
+
+func getTransDupDifferences(strs []string) []string {
+	// true if strings are still different after norm removes
+	// the other 2 kinds of differences
+	differ := func(norm func(string) string) bool {
+		for _, s := range strs[1:] {
+			if norm(s) != norm(strs[0]) {
+				return true
+			}
+		}
+		return false
+	}
+	var res []string
+	if differ(func(s string) string { return trimTransTrailingPunct(trimTransWhitespace(s)) }) {
+		res = append(res, "case")
+	}
+	if differ(func(s string) string { return strings.ToLower(transWhitespaceRemover.Replace(s)) }) {
+		res = append(res, "punctuation")
+	}
+	if differ(func(s string) string { return strings.ToLower(strings.TrimRight(s, transTrailingPunct)) }) {
+		res = append(res, "whitespace")
+	}
+	return res
+}
+
+func findTransDupGroups() []*TransDupGroup {
+	trans := parseTranslations(string(readFileMust(translationsTxtPath)))
+	byKey := map[string][]*TransString{}
+	for _, s := range extractTransStringsMust() {
+		k := transDupKey(s.Text)
+		byKey[k] = append(byKey[k], s)
+	}
+	var res []*TransDupGroup
+	for _, strs := range byKey {
+		if len(strs) < 2 {
+			continue
+		}
+		g := &TransDupGroup{}
+		var texts []string
+		for _, s := range strs {
+			v := &TransDupVariant{TransString: s, NBytes: len(s.Text) + 2}
+			for _, tr := range trans[s.Text] {
+				v.NTranslations++
+				v.NBytes += len(tr.Lang) + len(tr.Translation) + 2
+			}
+			g.Variants = append(g.Variants, v)
+			texts = append(texts, s.Text)
+		}
+		g.Differences = getTransDupDifferences(texts)
+		sort.SliceStable(g.Variants, func(i, j int) bool {
+			return g.Variants[i].NTranslations > g.Variants[j].NTranslations
+		})
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Variants[0].Text < res[j].Variants[0].Text
+	})
+	return res
+}
+
+// -trans-dups
+func printTransDups() {
+	groups := findTransDupGroups()
+	if len(groups) == 0 {
+		logf("no strings that differ only by case, trailing punctuation or whitespace\n")
+		return
+	}
+	nStrings, nBytes := 0, 0
+	for _, g := range groups {
+		logf("differ by %s:\n", strings.Join(g.Differences, ", "))
+		for i, v := range g.Variants {
+			keep := ""
+			if i == 0 {
+				keep = " (keep)"
+			} else {
+				nStrings++
+				nBytes += v.NBytes
+			}
+			logf("  '%s'%s: %d translations, used in %s\n", v.Text, keep, v.NTranslations, strings.Join(v.Locations, " "))
+		}
+	}
+	logf("\n%d groups of similar strings, unifying them removes %d strings and %s of translations\n", len(groups), nStrings, formatSize(int64(nBytes)))
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// translations/strings.txt is the canonical list of English strings to
+// translate, extracted from _TRA("...") / _TRN("...") in src/ together with
+// files that use them. Re-generate with: .\doit.bat -gen trans-strings
+// (-gen-check fails if it's out of date). We don't store line numbers so
+// that unrelated changes in src/ don't make it out of date.
+//
+// Plural strings in _TRP("%d file", "%d files", n) are stored as
+// "%d file\|%d files", see trans_plural.go.
+//
+// Unlike a regexp, the extractor understands enough of C++ to:
+//   - ignore strings in comments and in #if 0 blocks (#else of #if 1)
+//   - join adjacent string literals: _TRA("foo" "bar")
+//   - handle _TRA( "..." ) split across lines
+//
+// Code in other #if / #ifdef blocks is included because it's compiled in
+// some configuration.
+//
+// Strings are kept escaped as in C source (e.g. \n is 2 characters) because
+// that's how they're stored in translations.txt.
+//
+// Short strings are hard to translate without knowing where they're shown.
+// A comment starting with TRANSLATORS: that ends on the line before the
+// string (or on the same line) is extracted as context for translators.
+// A line "screenshot: ${file}" in the comment refers to an image in
+// translations/screenshots/ that shows the string:
+//
+//	// TRANSLATORS: "Fit Width" zoom, shown in toolbar and View menu
+//	// screenshot: toolbar-zoom.png
+//	AppendMenu(m, _TRA("Fit &Width"));
+//
+// Strings shown in fixed-width controls (toolbar labels, dialog buttons)
+// can have a length budget. Translations longer than "maxlen: ${n}"
+// characters (not counting &) would be clipped and are flagged when
+// downloading translations:
+//
+//	// TRANSLATORS: label before page number box in toolbar
+//	// maxlen: 16
+//	_TRA("Page:")
+//
+// -trans-upload sends the context to translation service.
+
+var (
+	transStringsPath    = filepath.Join(translationsDir, "strings.txt")
+	transScreenshotsDir = filepath.Join(translationsDir, "screenshots")
+)
+
+const (
+	transCommentPrefix   = "TRANSLATORS:"
+	transScreenshotLabel = "screenshot:"
+	transMaxLenLabel     = "maxlen:"
+)
+
+// macros that mark strings for translation, see src/Translations.h
+var transMacros = []string{"_TR", "_TRA", "_TRN", "_TRP"}
+
+// TransString is a string marked for translation in the source
+type TransString struct {
+	Text string
+	// "src/Menu.cpp:123"
+	Locations []string
+	// from TRANSLATORS: comments
+	Comments []string
+	// file in transScreenshotsDir
+	Screenshot string
+	// max length of translation in characters, 0 if no limit
+	MaxLen int
+}
+
+func (s *TransString) hasContext() bool {
+	return len(s.Comments) > 0 || s.Screenshot != "" || s.MaxLen > 0
+}
+
+// TRANSLATORS: comment waiting for the next translatable string
+type transComment struct {
+	text       string
+	screenshot string
+	maxLen     int
+	endLine    int
+}
+
+type cppScanner struct {
+	path string
+	d    []byte
+	pos  int
+	line int
+	// for each nested #if, true if the code in the current branch is compiled
+	ifStack []*cppIf
+	res     map[string]*TransString
+	comment *transComment
+}
+
+type cppIf struct {
+	// 1 for #if 1, -1 for #if 0, 0 for conditions we can't evaluate
+	cond   int
+	inElse bool
+	// false if code is excluded by outer #if
+	parentActive bool
+}
+
+func (c *cppIf) isActive() bool {
+	if !c.parentActive {
+		return false
+	}
+	if c.inElse {
+		return c.cond != 1
+	}
+	return c.cond != -1
+}
+
+func (s *cppScanner) isActive() bool {
+	n := len(s.ifStack)
+	return n == 0 || s.ifStack[n-1].isActive()
+}
+
+func (s *cppScanner) loc() string {
+	return fmt.Sprintf("%s:%d", filepath.ToSlash(s.path), s.line)
+}
+
+func (s *cppScanner) peek(off int) byte {
+	if s.pos+off >= len(s.d) {
+		return 0
+	}
+	return s.d[s.pos+off]
+}
+
+func (s *cppScanner) advance() {
+	if s.d[s.pos] == '\n' {
+		s.line++
+	}
+	s.pos++
+}
+
+func evalCppCond(cond string) int {
+	if idx := strings.Index(cond, "//"); idx >= 0 {
+		cond = cond[:idx]
+	}
+	if idx := strings.Index(cond, "/*"); idx >= 0 {
+		cond = cond[:idx]
+	}
+	switch strings.TrimSpace(cond) {
+	case "0":
+		return -1
+	case "1":
+		return 1
+	}
+	return 0
+}
+
+// s.pos is at '#' that starts a line
+func (s *cppScanner) parseDirective() {
+	startLoc := s.loc()
+	var sb strings.Builder
+	for s.pos < len(s.d) && s.d[s.pos] != '\n' {
+		if s.d[s.pos] == '\\' && s.peek(1) == '\n' {
+			s.advance()
+			s.advance()
+			continue
+		}
+		if s.d[s.pos] != '\r' {
+			sb.WriteByte(s.d[s.pos])
+		}
+		s.advance()
+	}
+	line := strings.TrimSpace(strings.TrimPrefix(sb.String(), "#"))
+	name, rest, _ := strings.Cut(line, " ")
+	name = strings.TrimSpace(name)
+	n := len(s.ifStack)
+	switch name {
+	case "if", "ifdef", "ifndef":
+		cond := 0
+		if name == "if" {
+			cond = evalCppCond(rest)
+		}
+		s.ifStack = append(s.ifStack, &cppIf{cond: cond, parentActive: s.isActive()})
+	case "elif":
+		panicIf(n == 0, "%s: #elif without #if", startLoc)
+		top := s.ifStack[n-1]
+		if top.cond == 1 {
+			// an earlier branch was taken
+			top.inElse = true
+		} else {
+			top.cond = evalCppCond(rest)
+		}
+	case "else":
+		panicIf(n == 0, "%s: #else without #if", startLoc)
+		s.ifStack[n-1].inElse = true
+	case "endif":
+		panicIf(n == 0, "%s: #endif without #if", startLoc)
+		s.ifStack = s.ifStack[:n-1]
+	}
+}
+
+func (s *cppScanner) skipLineComment() {
+	for s.pos < len(s.d) && s.d[s.pos] != '\n' {
+		s.advance()
+	}
+}
+
+func (s *cppScanner) skipBlockComment() {
+	s.pos += 2
+	for s.pos < len(s.d) {
+		if s.d[s.pos] == '*' && s.peek(1) == '/' {
+			s.pos += 2
+			return
+		}
+		s.advance()
+	}
+}
+
+// s.pos is at opening quote (" or '), returns content as in source.
+// Unterminated literal ends at the end of line, it can happen in #if 0
+func (s *cppScanner) parseQuoted() string {
+	q := s.d[s.pos]
+	s.pos++
+	start := s.pos
+	for s.pos < len(s.d) {
+		c := s.d[s.pos]
+		if c == '\\' && s.peek(1) != '\n' {
+			s.pos += 2
+			continue
+		}
+		if c == '\n' {
+			break
+		}
+		if c == q {
+			res := string(s.d[start:s.pos])
+			s.pos++
+			return res
+		}
+		s.pos++
+	}
+	return string(s.d[start:s.pos])
+}
+
+// s.pos is at opening quote of R"delim(...)delim"
+func (s *cppScanner) skipRawString() {
+	startLoc := s.loc()
+	s.pos++
+	idx := bytes.IndexByte(s.d[s.pos:], '(')
+	panicIf(idx < 0, "%s: invalid raw string literal", startLoc)
+	end := ")" + string(s.d[s.pos:s.pos+idx]) + `"`
+	for s.pos < len(s.d) && !bytes.HasPrefix(s.d[s.pos:], []byte(end)) {
+		s.advance()
+	}
+	panicIf(s.pos >= len(s.d), "%s: unterminated raw string literal", startLoc)
+	s.pos += len(end)
+}
+
+func (s *cppScanner) skipSpaceAndComments() {
+	for s.pos < len(s.d) {
+		c := s.d[s.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			s.advance()
+		case c == '/' && s.peek(1) == '/':
+			s.skipLineComment()
+		case c == '/' && s.peek(1) == '*':
+			s.skipBlockComment()
+		default:
+			return
+		}
+	}
+}
+
+// c is content of // or /* */ comment that ended on the current line
+func (s *cppScanner) onComment(c string) {
+	var lines []string
+	for _, l := range strings.Split(c, "\n") {
+		l = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(l), "*"))
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	tc := s.comment
+	startLine := s.line - strings.Count(c, "\n")
+	if strings.HasPrefix(lines[0], transCommentPrefix) {
+		lines[0] = strings.TrimSpace(strings.TrimPrefix(lines[0], transCommentPrefix))
+		tc = &transComment{}
+	} else if tc == nil || tc.endLine != startLine-1 {
+		// not a continuation of TRANSLATORS: comment in // comments
+		return
+	}
+	tc.endLine = s.line
+	var text []string
+	if tc.text != "" {
+		text = append(text, tc.text)
+	}
+	for _, l := range lines {
+		if strings.HasPrefix(l, transScreenshotLabel) {
+			tc.screenshot = strings.TrimSpace(strings.TrimPrefix(l, transScreenshotLabel))
+			continue
+		}
+		if strings.HasPrefix(l, transMaxLenLabel) {
+			v := strings.TrimSpace(strings.TrimPrefix(l, transMaxLenLabel))
+			n, err := strconv.Atoi(v)
+			panicIf(err != nil || n <= 0, "%s:%d: invalid '%s', should be a positive number", s.path, s.line, l)
+			tc.maxLen = n
+			continue
+		}
+		if l != "" {
+			text = append(text, l)
+		}
+	}
+	tc.text = strings.Join(text, " ")
+	s.comment = tc
+}
+
+func isCppIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// s.pos is after name of translation macro
+// adjacent string literals e.g. "foo" "bar" => "foobar"
+func (s *cppScanner) parseStringLiterals() string {
+	var parts []string
+	for s.peek(0) == '"' {
+		parts = append(parts, s.parseQuoted())
+		s.skipSpaceAndComments()
+	}
+	return strings.Join(parts, "")
+}
+
+func (s *cppScanner) parseTransMacro(name string) {
+	loc := s.loc()
+	comment := s.comment
+	s.comment = nil
+	if comment != nil && comment.endLine < s.line-1 {
+		// not directly before the string
+		comment = nil
+	}
+	s.skipSpaceAndComments()
+	if s.peek(0) != '(' {
+		return
+	}
+	s.pos++
+	s.skipSpaceAndComments()
+	if s.peek(0) != '"' {
+		// e.g. #define _TRN(x) (x)
+		return
+	}
+	text := s.parseStringLiterals()
+	if name == "_TRP" {
+		// _TRP("%d file", "%d files", n), see trans_plural.go
+		panicIf(s.peek(0) != ',', "%s: %s() must have singular and plural string literals as first 2 arguments", loc, name)
+		s.pos++
+		s.skipSpaceAndComments()
+		plural := s.parseStringLiterals()
+		panicIf(s.peek(0) != ',' || text == "" || plural == "", "%s: %s() must have singular and plural string literals as first 2 arguments", loc, name)
+		panicIf(isPluralTransString(text) || isPluralTransString(plural), "%s: %s can't be in %s()", loc, transPluralSep, name)
+		text = joinPluralForms([]string{text, plural})
+	} else {
+		panicIf(s.peek(0) != ')', "%s: %s() must only have string literals as argument", loc, name)
+		panicIf(isPluralTransString(text), "%s: %s is only for plural forms in _TRP()", loc, transPluralSep)
+	}
+	s.pos++
+	if s.isActive() {
+		panicIf(text == "", "%s: empty string in %s()", loc, name)
+		ts := s.res[text]
+		if ts == nil {
+			ts = &TransString{Text: text}
+			s.res[text] = ts
+		}
+		ts.Locations = append(ts.Locations, loc)
+		if comment == nil {
+			return
+		}
+		if comment.text != "" && !stringInSlice(ts.Comments, comment.text) {
+			ts.Comments = append(ts.Comments, comment.text)
+		}
+		// if used in many places, the first screenshot wins
+		if ts.Screenshot == "" {
+			ts.Screenshot = comment.screenshot
+		}
+		// the most restrictive budget wins
+		if comment.maxLen > 0 && (ts.MaxLen == 0 || comment.maxLen < ts.MaxLen) {
+			ts.MaxLen = comment.maxLen
+		}
+	}
+}
+
+func (s *cppScanner) scan() {
+	atLineStart := true
+	for s.pos < len(s.d) {
+		c := s.d[s.pos]
+		switch {
+		case c == '\n':
+			atLineStart = true
+			s.advance()
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			s.pos++
+			continue
+		case c == '#' && atLineStart:
+			s.parseDirective()
+		case c == '/' && s.peek(1) == '/':
+			start := s.pos
+			s.skipLineComment()
+			s.onComment(string(s.d[start+2 : s.pos]))
+		case c == '/' && s.peek(1) == '*':
+			start := s.pos
+			s.skipBlockComment()
+			s.onComment(strings.TrimSuffix(string(s.d[start+2:s.pos]), "*/"))
+			// a comment doesn't change being at the start of a line
+			continue
+		case c == '"' || c == '\'':
+			s.parseQuoted()
+		case isCppIdentChar(c):
+			start := s.pos
+			for s.pos < len(s.d) && isCppIdentChar(s.d[s.pos]) {
+				s.pos++
+			}
+			ident := string(s.d[start:s.pos])
+			if stringInSlice(transMacros, ident) {
+				s.parseTransMacro(ident)
+			} else if strings.HasSuffix(ident, "R") && s.peek(0) == '"' {
+				s.skipRawString()
+			}
+		default:
+			s.pos++
+		}
+		atLineStart = false
+	}
+	panicIf(len(s.ifStack) != 0, "%s: missing #endif", s.path)
+}
+
+// extracts strings from a single file, adds them to res
+func extractTransStringsFromFileMust(path string, res map[string]*TransString) {
+	s := &cppScanner{
+		path: path,
+		d:    readFileMust(path),
+		line: 1,
+		res:  res,
+	}
+	s.scan()
+}
+
+// .cpp and .h files in src/, sorted
+func getTransSourceFilesMust() []string {
+	var res []string
+	err := filepath.WalkDir("src", func(path string, d fs.DirEntry, err error) error {
+		must(err)
+		ext := strings.ToLower(filepath.Ext(path))
+		if d.Type().IsRegular() && (ext == ".cpp" || ext == ".h") {
+			res = append(res, path)
+		}
+		return nil
+	})
+	must(err)
+	sort.Strings(res)
+	return res
+}
+
+// all strings marked for translation, sorted by text
+func extractTransStringsMust() []*TransString {
+	m := map[string]*TransString{}
+	for _, path := range getTransSourceFilesMust() {
+		extractTransStringsFromFileMust(path, m)
+	}
+	var res []*TransString
+	for _, s := range m {
+		if s.Screenshot != "" {
+			path := filepath.Join(transScreenshotsDir, s.Screenshot)
+			panicIf(!fileExists(path), "%s: screenshot '%s' doesn't exist", s.Locations[0], path)
+		}
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Text < res[j].Text
+	})
+	return res
+}
+
+/*
+strings.txt looks like:
+
+# English strings marked for translation in src/
+# generated with .\doit.bat -gen trans-strings, don't edit
+#: src/Menu.cpp src/Toolbar.cpp
+:&About
+#: src/Toolbar.cpp
+#. "Fit Width" zoom, shown in toolbar and View menu
+#screenshot: toolbar-zoom.png
+:Fit &Width
+#: src/Toolbar.cpp
+#. label before page number box in toolbar
+#maxlen: 16
+:Page:
+*/
+// "src/Menu.cpp:123", "src/Menu.cpp:140" => "src/Menu.cpp"
+func getTransLocationFiles(locs []string) []string {
+	var res []string
+	for _, loc := range locs {
+		path, _, _ := strings.Cut(loc, ":")
+		if !stringInSlice(res, path) {
+			res = append(res, path)
+		}
+	}
+	return res
+}
+
+func serializeTransStrings(strs []*TransString) string {
+	lines := []string{
+		"# English strings marked for translation in src/",
+		`# generated with .\doit.bat -gen trans-strings, don't edit`,
+	}
+	for _, s := range strs {
+		lines = append(lines, "#: "+strings.Join(getTransLocationFiles(s.Locations), " "))
+		for _, c := range s.Comments {
+			lines = append(lines, "#. "+c)
+		}
+		if s.Screenshot != "" {
+			lines = append(lines, "#"+transScreenshotLabel+" "+s.Screenshot)
+		}
+		if s.MaxLen > 0 {
+			lines = append(lines, fmt.Sprintf("#%s %d", transMaxLenLabel, s.MaxLen))
+		}
+		lines = append(lines, ":"+s.Text)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func parseTransStringsMust(d string) []*TransString {
+	var res []*TransString
+	curr := &TransString{}
+	for i, l := range strings.Split(d, "\n") {
+		switch {
+		case strings.HasPrefix(l, "#: "):
+			curr.Locations = strings.Fields(l[3:])
+		case strings.HasPrefix(l, "#. "):
+			curr.Comments = append(curr.Comments, l[3:])
+		case strings.HasPrefix(l, "#"+transScreenshotLabel+" "):
+			curr.Screenshot = l[len(transScreenshotLabel)+2:]
+		case strings.HasPrefix(l, "#"+transMaxLenLabel+" "):
+			n, err := strconv.Atoi(l[len(transMaxLenLabel)+2:])
+			panicIf(err != nil, "%s:%d: invalid line '%s'", transStringsPath, i+1, l)
+			curr.MaxLen = n
+		case strings.HasPrefix(l, "#"), l == "":
+			// comment
+		case strings.HasPrefix(l, ":"):
+			curr.Text = l[1:]
+			res = append(res, curr)
+			curr = &TransString{}
+		default:
+			panicIf(true, "%s:%d: invalid line '%s'", transStringsPath, i+1, l)
+		}
+	}
+	return res
+}
+
+func readTransStringsMust() []*TransString {
+	return parseTransStringsMust(string(readFileMust(transStringsPath)))
+}
+
+func genTransStringsMust() {
+	strs := extractTransStringsMust()
+	nLocs := 0
+	for _, s := range strs {
+		nLocs += len(s.Locations)
+	}
+	writeFileMust(transStringsPath, []byte(serializeTransStrings(strs)))
+	logf("wrote '%s' with %d strings used in %d places\n", transStringsPath, len(strs), nLocs)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Before a release we declare a string freeze so that translators have
+// a stable set of strings to translate. translations/string-freeze.txt
+// has a line per release branch:
+//
+//	rel3.6working 2026-09-01
+//
+// -trans-string-freeze [${branch}] fails if translations/strings.txt in
+// HEAD has strings that weren't there at the start of freeze day and lists
+// the commits that added them. Release builds run the same check.
+// Removing or re-wording strings is allowed (re-worded strings are new).
+
+var stringFreezePath = filepath.Join(translationsDir, "string-freeze.txt")
+
+// git always uses / in paths
+const transStringsGitPath = "translations/strings.txt"
+
+// StringFreezeViolation is a string added after string freeze
+type StringFreezeViolation struct {
+	Text string
+	// "${sha1} ${subject}" of commit that added it, "" if not known
+	Commit string
+}
+
+// returns branch => freeze date
+func readStringFreezesMust() map[string]time.Time {
+	res := map[string]time.Time{}
+	if !fileExists(stringFreezePath) {
+		return res
+	}
+	d := readFileMust(stringFreezePath)
+	for i, l := range strings.Split(normalizeNewlines(string(d)), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		parts := strings.Fields(l)
+		panicIf(len(parts) != 2, "%s:%d: invalid line '%s', should be '${branch} ${yyyy-mm-dd}'", stringFreezePath, i+1, l)
+		day, err := time.ParseInLocation("2006-01-02", parts[1], time.UTC)
+		panicIf(err != nil, "%s:%d: invalid date '%s', should be yyyy-mm-dd", stringFreezePath, i+1, parts[1])
+		res[parts[0]] = day
+	}
+	return res
+}
+
+// returns nil if strings.txt doesn't exist in commit
+func getTransStringsAtCommit(sha1 string) map[string]bool {
+	ref := sha1 + ":" + transStringsGitPath
+	if exec.Command("git", "cat-file", "-e", ref).Run() != nil {
+		return nil
+	}
+	res := map[string]bool{}
+	for _, s := range parseTransStringsMust(string(runExeMust("git", "show", ref))) {
+		res[s.Text] = true
+	}
+	return res
+}
+
+// returns "" if there are no commits before t
+func getLastGitCommitBeforeMust(t time.Time) string {
+	out := runExeMust("git", "rev-list", "-1", "--before="+t.Format(time.RFC3339), "HEAD")
+	return strings.TrimSpace(string(out))
+}
+
+func findStringFreezeViolationsMust(freeze time.Time) []*StringFreezeViolation {
+	base := getLastGitCommitBeforeMust(freeze)
+	panicIf(base == "", "no commits before string freeze on %s", freeze.Format("2006-01-02"))
+	frozen := getTransStringsAtCommit(base)
+	panicIf(frozen == nil, "%s doesn't exist in %s, the last commit before string freeze", transStringsGitPath, base[:10])
+	added := map[string]*StringFreezeViolation{}
+	for _, s := range readTransStringsMust() {
+		if !frozen[s.Text] {
+			added[s.Text] = &StringFreezeViolation{Text: s.Text}
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	// find commits that added them, oldest first so that a string added,
+	// removed and added again is blamed on the last commit
+	out := runExeMust("git", "log", "--reverse", "--format=%H %s", base+"..HEAD", "--", transStringsGitPath)
+	for _, l := range toTrimmedLines(out) {
+		sha1, _, _ := strings.Cut(l, " ")
+		strs := getTransStringsAtCommit(sha1)
+		prev := getTransStringsAtCommit(sha1 + "^")
+		for s := range strs {
+			if v := added[s]; v != nil && !prev[s] {
+				v.Commit = l
+			}
+		}
+	}
+	var res []*StringFreezeViolation
+	for _, v := range added {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Text < res[j].Text
+	})
+	return res
+}
+
+// branch is release branch like rel3.6working, no check if it doesn't have
+// declared string freeze or it's in the future
+func checkStringFreezeMust(branch string) {
+	freeze, ok := readStringFreezesMust()[branch]
+	if !ok {
+		logf("no string freeze declared for branch '%s' in '%s'\n", branch, stringFreezePath)
+		return
+	}
+	day := freeze.Format("2006-01-02")
+	if time.Now().Before(freeze) {
+		logf("string freeze for branch '%s' starts on %s\n", branch, day)
+		return
+	}
+	violations := findStringFreezeViolationsMust(freeze)
+	if len(violations) == 0 {
+		logf("no strings added since string freeze for branch '%s' on %s\n", branch, day)
+		return
+	}
+	byCommit := map[string][]string{}
+	var commits []string
+	for _, v := range violations {
+		c := v.Commit
+		if c == "" {
+			c = "(unknown commit)"
+		}
+		if byCommit[c] == nil {
+			commits = append(commits, c)
+		}
+		byCommit[c] = append(byCommit[c], v.Text)
+	}
+	sort.Strings(commits)
+	var lines []string
+	for _, c := range commits {
+		lines = append(lines, c)
+		for _, s := range byCommit[c] {
+			lines = append(lines, "  :"+s)
+		}
+	}
+	logf("%s\n", strings.Join(lines, "\n"))
+	panicIf(true, "%d strings added after string freeze for branch '%s' on %s in %d commits. Revert them or move the string freeze in '%s'", len(violations), branch, day, len(commits), stringFreezePath)
+}
+
+// -trans-string-freeze [${branch}]
+func transStringFreezeMust(branch string) {
+	if branch == "" {
+		branch = getCurrentBranchMust(".")
+	}
+	checkStringFreezeMust(branch)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Release builds fail if one of the most used languages doesn't have enough
+// strings translated. translations/trans-gate.txt has:
+//
+//	top: 10
+//	min-translated: 90
+//	cn
+//	de
+//	...
+//
+// i.e. top-N languages (from the list of languages by share of users, most
+// used first) must have at least min-translated percent of strings in
+// translations/strings.txt translated by people (machine translations
+// don't count).
+//
+// -trans-gate [top=${n}] [min=${percent}] runs the same check and, like
+// release builds, lists strings missing in languages that fail it.
+
+var transGatePath = filepath.Join(translationsDir, "trans-gate.txt")
+
+// TransGate is configuration of the check
+type TransGate struct {
+	Top           int
+	MinTranslated int
+	// by share of users, most used first
+	Langs []string
+}
+
+// TransGateResult is translation status of one of top languages
+type TransGateResult struct {
+	Lang        string
+	NTranslated int
+	Percent     int
+	Missing     []string
+}
+
+func parseTransGate(s string) *TransGate {
+	res := &TransGate{}
+	for i, l := range strings.Split(normalizeNewlines(s), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		loc := transGatePath + ":" + strconv.Itoa(i+1)
+		if name, v, ok := strings.Cut(l, ":"); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			panicIf(err != nil || n < 0, "%s: invalid value in '%s'", loc, l)
+			switch name {
+			case "top":
+				res.Top = n
+			case "min-translated":
+				panicIf(n > 100, "%s: min-translated should be percent, is %d", loc, n)
+				res.MinTranslated = n
+			default:
+				panicIf(true, "%s: unknown setting '%s'", loc, name)
+			}
+			continue
+		}
+		panicIf(!isKnownLang(l), "%s: unknown language '%s'", loc, l)
+		panicIf(stringInSlice(res.Langs, l), "%s: duplicate language '%s'", loc, l)
+		res.Langs = append(res.Langs, l)
+	}
+	return res
+}
+
+func readTransGateMust() *TransGate {
+	return parseTransGate(string(readFileMust(transGatePath)))
+}
+
+// applies "top=${n}" and "min=${percent}" arguments
+func (g *TransGate) applyArgsMust(args []string) {
+	for _, arg := range args {
+		name, v, _ := strings.Cut(arg, "=")
+		n, err := strconv.Atoi(v)
+		panicIf(err != nil || n < 0, "invalid argument '%s'", arg)
+		switch name {
+		case "top":
+			g.Top = n
+		case "min":
+			panicIf(n > 100, "min should be percent, is %d", n)
+			g.MinTranslated = n
+		default:
+			panicIf(true, "unknown argument '%s', should be top=${n} or min=${percent}", arg)
+		}
+	}
+}
+
+func (g *TransGate) topLangs() []string {
+	if g.Top < len(g.Langs) {
+		return g.Langs[:g.Top]
+	}
+	return g.Langs
+}
+
+// strs are from strings.txt, trans from translations.txt
+func checkTransGate(g *TransGate, strs []*TransString, trans map[string][]*Translation) []*TransGateResult {
+	byLang := translationsByLang(trans)
+	var res []*TransGateResult
+	for _, lang := range g.topLangs() {
+		r := &TransGateResult{Lang: lang}
+		for _, s := range strs {
+			if byLang[lang][s.Text] != "" {
+				r.NTranslated++
+			} else {
+				r.Missing = append(r.Missing, s.Text)
+			}
+		}
+		if len(strs) > 0 {
+			r.Percent = r.NTranslated * 100 / len(strs)
+		}
+		sort.Strings(r.Missing)
+		res = append(res, r)
+	}
+	return res
+}
+
+func checkTransGateMust(args []string) {
+	g := readTransGateMust()
+	g.applyArgsMust(args)
+	strs := readTransStringsMust()
+	trans := parseTranslations(string(readFileMust(translationsTxtPath)))
+	results := checkTransGate(g, strs, trans)
+
+	logf("top %d languages must have at least %d%% of %d strings translated:\n", len(results), g.MinTranslated, len(strs))
+	var failed []*TransGateResult
+	for _, r := range results {
+		status := "ok"
+		if r.Percent < g.MinTranslated {
+			status = "FAIL"
+			failed = append(failed, r)
+		}
+		logf("%-6s %4d%% %5d missing  %s\n", r.Lang, r.Percent, len(r.Missing), status)
+	}
+	if len(failed) == 0 {
+		return
+	}
+	var names []string
+	for _, r := range failed {
+		logf("\nmissing in %s:\n", getLangName(r.Lang))
+		for _, s := range r.Missing {
+			logf(":%s\n", s)
+		}
+		names = append(names, r.Lang)
+	}
+	panicIf(true, "%d of top %d languages have less than %d%% strings translated: %s. Ask translators to translate them, run -trans-download or change '%s'", len(failed), len(results), g.MinTranslated, strings.Join(names, ", "), transGatePath)
+}
@@ -54,6 +54,23 @@ bool IsLangRtl(int idx)
   {{.Islangrtl}}
 }
 
+// index in pluralRules in do/trans_plural.go
+const unsigned char gLangPluralRules[kLangsCount] = {
+  {{.PluralRules}}
+};
+
+// index of plural form of n in translations of language idx
+int GetPluralForm(int idx, int n)
+{
+  if (n < 0) {
+    n = -n;
+  }
+  switch (gLangPluralRules[idx]) {
+{{.PluralCases}}
+  }
+  return 0;
+}
+
 int gLangsCount = kLangsCount;
 
 const LANGID *GetLangIds() { return &gLangIds[0]; }
@@ -183,19 +200,24 @@ func genTranslationInfoCpp() {
 	//logf("islangrtl:\n%s\n", islangrtl)
 
 	langsCount := len(langs)
+	pluralRules, pluralCases := genPluralRulesCpp(langs)
 
 	v2 := struct {
-		LangsCount int
-		Langcodes  string
-		Langnames  string
-		Langids    string
-		Islangrtl  string
+		LangsCount  int
+		Langcodes   string
+		Langnames   string
+		Langids     string
+		Islangrtl   string
+		PluralRules string
+		PluralCases string
 	}{
-		LangsCount: langsCount,
-		Langcodes:  langcodes,
-		Langnames:  langnames,
-		Langids:    langids,
-		Islangrtl:  islangrtl,
+		LangsCount:  langsCount,
+		Langcodes:   langcodes,
+		Langnames:   langnames,
+		Langids:     langids,
+		Islangrtl:   islangrtl,
+		PluralRules: pluralRules,
+		PluralCases: pluralCases,
 	}
 	path := filepath.Join("src", "TranslationLangs.cpp")
 	fileContent := evalTmpl(compactCTmpl, v2)
@@ -77,3 +77,32 @@ var gLangs = [][]string{
 	{"uz", "Uzbek (O'zbek)", "_LANGID(LANG_UZBEK)"},
 	{"vn", "Vietnamese (Việt Nam)", "_LANGID(LANG_VIETNAMESE)"},
 }
+
+// our language codes that are not BCP 47 codes (for historical reasons)
+// => BCP 47 code
+var gLangISOCodes = map[string]string{
+	"am":    "hy",
+	"br":    "pt-BR",
+	"by":    "be",
+	"ca-xv": "ca",
+	"cn":    "zh-CN",
+	"cz":    "cs",
+	"dk":    "da",
+	"fy-nl": "fy",
+	"kr":    "ko",
+	"mm":    "my",
+	"my":    "ms",
+	"pt":    "pt-PT",
+	"sp-rs": "sr-Latn",
+	"sr-rs": "sr",
+	"tw":    "zh-TW",
+	"vn":    "vi",
+}
+
+// e.g. "cz" => "cs", "br" => "pt-BR"
+func getLangISOCode(lang string) string {
+	if code, ok := gLangISOCodes[lang]; ok {
+		return code
+	}
+	return lang
+}
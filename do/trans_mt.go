@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -trans-mt-fill ${lang1},${lang2} fills strings that translators haven't
+// translated yet with machine translations (Google Cloud Translation).
+// It's opt-in per language and meant for languages that don't have active
+// translators.
+//
+// Machine translations are kept in translations/translations-mt.txt (same
+// format as translations.txt), never in translations.txt, so that
+// -trans-download doesn't overwrite them and translations done by people
+// always win. translations-good.txt marks them with * after the language
+// code (e.g. "af*:...") so translators can find them.
+//
+// Needs GOOGLE_TRANSLATE_KEY.
+
+var googleTranslateKey string
+
+var transMtPath = filepath.Join(translationsDir, "translations-mt.txt")
+
+const (
+	googleTranslateAPIURL = "https://translation.googleapis.com/language/translate/v2"
+	// max number of strings in one request
+	googleTranslateBatchSize = 100
+	// marks machine translation in translations-good.txt
+	transMtMarker = "*"
+)
+
+// format specifiers and escapes that must not be translated
+var rxMtKeep = regexp.MustCompile(rxFormatSpecifier.String() + `|\\.`)
+
+// "&Open %s\n" => "Open <span translate="no">%s</span><span translate="no">\n</span>"
+// & (menu accelerators) are removed because they'd confuse the translation.
+func mtEncode(s string) string {
+	s = strings.ReplaceAll(s, "&&", "\x00")
+	s = strings.ReplaceAll(s, "&", "")
+	s = strings.ReplaceAll(s, "\x00", "&")
+	var sb strings.Builder
+	prev := 0
+	for _, m := range rxMtKeep.FindAllStringIndex(s, -1) {
+		sb.WriteString(html.EscapeString(s[prev:m[0]]))
+		sb.WriteString(`<span translate="no">` + s[m[0]:m[1]] + `</span>`)
+		prev = m[1]
+	}
+	sb.WriteString(html.EscapeString(s[prev:]))
+	return sb.String()
+}
+
+var rxMtSpan = regexp.MustCompile(`<span translate="no">(.*?)</span>`)
+
+func mtDecode(s string) string {
+	s = rxMtSpan.ReplaceAllString(s, "$1")
+	s = html.UnescapeString(s)
+	// we store translations escaped, one per line
+	s = strings.ReplaceAll(s, "&", "&&")
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return strings.TrimSpace(s)
+}
+
+func googleTranslateMust(lang string, strs []string) []string {
+	target := getLangISOCode(lang)
+	v := url.Values{}
+	v.Set("key", googleTranslateKey)
+	v.Set("source", "en")
+	v.Set("target", target)
+	v.Set("format", "html")
+	for _, s := range strs {
+		v.Add("q", mtEncode(s))
+	}
+	client := &http.Client{Timeout: 2 * time.Minute}
+	rsp, err := client.PostForm(googleTranslateAPIURL, v)
+	must(err)
+	defer rsp.Body.Close()
+	d, err := io.ReadAll(rsp.Body)
+	must(err)
+	panicIf(rsp.StatusCode != http.StatusOK, "translating to %s (%s) failed with status code %d, body: '%s'", lang, target, rsp.StatusCode, string(d))
+	var res struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText string `json:"translatedText"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	must(json.Unmarshal(d, &res))
+	panicIf(len(res.Data.Translations) != len(strs), "sent %d strings to translate, got %d translations", len(strs), len(res.Data.Translations))
+	var a []string
+	for _, tr := range res.Data.Translations {
+		a = append(a, mtDecode(tr.TranslatedText))
+	}
+	return a
+}
+
+// lang => english => machine translation
+func readMachineTranslations() map[string]map[string]string {
+	res := map[string]map[string]string{}
+	if !fileExists(transMtPath) {
+		return res
+	}
+	return translationsByLang(parseTranslations(string(readFileMust(transMtPath))))
+}
+
+func writeMachineTranslationsMust(mt map[string]map[string]string) {
+	byText := map[string][]string{}
+	for lang, m := range mt {
+		for s, tr := range m {
+			byText[s] = append(byText[s], lang+":"+tr)
+		}
+	}
+	var strs []string
+	for s := range byText {
+		strs = append(strs, s)
+	}
+	sort.Strings(strs)
+	lines := []string{
+		"# machine translations of strings not yet translated by translators",
+		"# generated with .\\doit.bat -trans-mt-fill, don't edit",
+	}
+	for _, s := range strs {
+		sort.Strings(byText[s])
+		lines = append(lines, ":"+s)
+		lines = append(lines, byText[s]...)
+	}
+	writeFileMust(transMtPath, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// -trans-mt-fill ${langs}
+func transMtFillMust(langsArg string) {
+	var langs []string
+	for _, lang := range strings.Split(langsArg, ",") {
+		lang = strings.TrimSpace(lang)
+		if lang == "" {
+			continue
+		}
+		panicIf(lang == "en" || !isKnownLang(lang), "'%s' is not a language we translate to, see gLangs in do/trans_langs.go", lang)
+		langs = append(langs, lang)
+	}
+	panicIf(len(langs) == 0, "usage: -trans-mt-fill ${lang1},${lang2} e.g. -trans-mt-fill af,sn")
+	panicIf(googleTranslateKey == "", "need GOOGLE_TRANSLATE_KEY env variable")
+
+	human := translationsByLang(parseTranslations(string(readFileMust(translationsTxtPath))))
+	used := extractTransStringsMust()
+	mt := readMachineTranslations()
+	// drop machine translations that are no longer needed
+	nRemoved := 0
+	for lang, m := range mt {
+		for s := range m {
+			if _, ok := human[lang][s]; ok {
+				delete(m, s)
+				nRemoved++
+			}
+		}
+	}
+	for _, lang := range langs {
+		var missing []string
+		for _, s := range used {
+			if _, ok := human[lang][s.Text]; ok {
+				continue
+			}
+			// machine translation doesn't know plural forms of the language
+			if isPluralTransString(s.Text) {
+				continue
+			}
+			if _, ok := mt[lang][s.Text]; ok {
+				continue
+			}
+			missing = append(missing, s.Text)
+		}
+		logf("%s: %d strings without translation\n", lang, len(missing))
+		if len(missing) == 0 {
+			continue
+		}
+		if dryRunSkip("machine-translate %d strings to %s", len(missing), lang) {
+			continue
+		}
+		if mt[lang] == nil {
+			mt[lang] = map[string]string{}
+		}
+		nAdded := 0
+		for len(missing) > 0 {
+			n := min(len(missing), googleTranslateBatchSize)
+			batch := missing[:n]
+			missing = missing[n:]
+			for i, tr := range googleTranslateMust(lang, batch) {
+				// we check translations.txt the same way
+				p := checkTranslation(lang, batch[i], tr)
+				if p != nil && p.Severity == kTransReject {
+					logf("%s: skipping '%s' => '%s': %s\n", lang, batch[i], tr, p.Msg)
+					continue
+				}
+				mt[lang][batch[i]] = tr
+				nAdded++
+			}
+		}
+		logf("%s: added %d machine translations\n", lang, nAdded)
+	}
+	if nRemoved > 0 {
+		logf("removed %d machine translations of strings that translators translated\n", nRemoved)
+	}
+	writeMachineTranslationsMust(mt)
+	logf("wrote '%s'\n", transMtPath)
+	runGenerators([]string{"translations-good"})
+	logf("\nreview %s before committing\n", transMtPath)
+}
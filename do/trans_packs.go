@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// -trans-packs [upload] splits translations-good.txt into per-language packs
+// in out/langpacks: ${lang}.txt (same format as translations-good.txt, only
+// translated strings of one language) and langpacks.json which describes
+// them.
+//
+// The app uses a pack in ${appdata}/langpacks/${lang}.txt instead of
+// translations embedded in the exe (see src/Translations.cpp) which allows
+// updating translations between releases and, eventually, not embedding
+// all languages in the installer. The second line of a pack is
+// "Version: ${ver}" (CURR_VERSION from src/Version.h) and the app ignores
+// packs made for a different version because strings might have changed.
+//
+// With upload, packs are uploaded to langPacksRemoteDir/${ver}/. Names of
+// packs include sha256 so langpacks.json, uploaded last, always refers to
+// complete files. The app downloads langpacks.json for its version from
+// https://www.sumatrapdfreader.org/dl/langpacks/${ver}/langpacks.json
+// during update check and installs the pack for the current language if
+// it changed (see UpdateLangPack() in src/UpdateCheck.cpp).
+
+const (
+	langPacksRemoteDir    = "software/sumatrapdf/langpacks/"
+	langPacksManifestName = "langpacks.json"
+	// bump when format of packs changes in a way older apps can't read
+	langPacksFormat = 1
+)
+
+var langPacksDir = filepath.Join("out", "langpacks")
+
+// LangPack describes a translation pack for a single language
+type LangPack struct {
+	Lang string `json:"lang"`
+	Name string `json:"name"`
+	// in langPacksRemoteDir
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// number of translated strings, including machine translations
+	Translated int `json:"translated"`
+	Total      int `json:"total"`
+}
+
+// LangPacksManifest is langpacks.json
+type LangPacksManifest struct {
+	Format int `json:"format"`
+	// CURR_VERSION, packs are only for this version of the app
+	AppVersion string `json:"appVersion"`
+	// sha256 of translations-good.txt, changes when any of the packs changes
+	Version string      `json:"version"`
+	Langs   []*LangPack `json:"langs"`
+}
+
+// returns lang => lines of pack (without header)
+func splitTranslationsGood(d string) (map[string][]string, int) {
+	res := map[string][]string{}
+	lines := strings.Split(normalizeNewlines(d), "\n")
+	currStr := ""
+	nStrings := 0
+	for _, l := range lines[2:] {
+		if l == "" {
+			continue
+		}
+		if l[0] == ':' {
+			currStr = l
+			nStrings++
+			continue
+		}
+		lang, _, ok := strings.Cut(l, ":")
+		panicIf(!ok || currStr == "", "invalid line '%s' in translations-good.txt", l)
+		// "af*" is machine translation
+		lang = strings.TrimSuffix(lang, transMtMarker)
+		res[lang] = append(res[lang], currStr, l)
+	}
+	return res, nStrings
+}
+
+func genLangPacksMust() *LangPacksManifest {
+	d := readFileMust(filepath.Join(translationsDir, "translations-good.txt"))
+	byLang, nStrings := splitTranslationsGood(string(d))
+	ver := extractSumatraVersionMust()
+	must(os.RemoveAll(langPacksDir))
+	must(os.MkdirAll(langPacksDir, 0755))
+	var langs []string
+	for lang := range byLang {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	m := &LangPacksManifest{
+		Format:     langPacksFormat,
+		AppVersion: ver,
+		Version:    sha256HexOfData(d)[:12],
+	}
+	for _, lang := range langs {
+		lines := append([]string{"AppTranslator: SumatraPDF", "Version: " + ver}, byLang[lang]...)
+		pack := []byte(strings.Join(lines, "\n") + "\n")
+		sha := sha256HexOfData(pack)
+		lp := &LangPack{
+			Lang:       lang,
+			Name:       getLangName(lang),
+			File:       fmt.Sprintf("%s-%s.txt", lang, sha[:10]),
+			Size:       int64(len(pack)),
+			Sha256:     sha,
+			Translated: len(byLang[lang]) / 2,
+			Total:      nStrings,
+		}
+		writeFileMust(filepath.Join(langPacksDir, lang+".txt"), pack)
+		m.Langs = append(m.Langs, lp)
+	}
+	js, err := json.MarshalIndent(m, "", "  ")
+	must(err)
+	writeFileMust(filepath.Join(langPacksDir, langPacksManifestName), js)
+	logf("wrote %d language packs for %s to '%s', version %s\n", len(m.Langs), ver, langPacksDir, m.Version)
+	return m
+}
+
+func uploadLangPacksMust(m *LangPacksManifest) {
+	ensureAllUploadCreds()
+	remoteDir := path.Join(langPacksRemoteDir, m.AppVersion)
+	forEachStorageParallelMust(getStorageBackends(), func(storage StorageBackend) {
+		for _, lp := range m.Langs {
+			remotePath := path.Join(remoteDir, lp.File)
+			if storage.Exists(remotePath) {
+				continue
+			}
+			must(uploadFileLogged(storage, remotePath, filepath.Join(langPacksDir, lp.Lang+".txt")))
+		}
+		// last so that it only refers to uploaded files
+		remotePath := path.Join(remoteDir, langPacksManifestName)
+		must(uploadFileLogged(storage, remotePath, filepath.Join(langPacksDir, langPacksManifestName)))
+	})
+}
+
+// -trans-packs [upload]
+func transPacksMust(upload bool) {
+	m := genLangPacksMust()
+	if upload {
+		uploadLangPacksMust(m)
+	}
+}
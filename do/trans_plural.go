@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Strings with a number use _TRP() which picks the right plural form:
+//
+//	str::FormatTemp(_TRP("%d file", "%d files", n), n)
+//
+// English has 2 forms (one, other) but e.g. Polish has 3 (1 plik, 2 pliki,
+// 5 plików), Arabic has 6 and Japanese has 1. Rules of each language are
+// from CLDR (https://www.unicode.org/cldr/charts/latest/supplemental/language_plural_rules.html),
+// for integers only.
+//
+// Everywhere else (strings.txt, translations.txt, translation services)
+// forms are joined with \| so that plural strings are just strings:
+//
+//	:%d file\|%d files
+//	pl:%d plik\|%d pliki\|%d plików
+//
+// A translation has all forms of its language, in CLDR order (zero, one,
+// two, few, many, other). .po files use msgid_plural / msgstr[N] with
+// Plural-Forms header of the language.
+//
+// src/TranslationLangs.cpp has the rule of each language, generated from
+// gettext plural expressions in pluralRules (they're valid C).
+//
+// -trans-plural-migrate "${old plural}" ["${old singular}"] moves existing
+// translations of a string that was changed to _TRP() to the new plural
+// string. For languages where we'd have to guess, every form is the old
+// plural translation and it's uploaded as fuzzy for translators to fix.
+
+// separates plural forms in escaped strings. It's an invalid C escape so it
+// can't be in regular strings
+const transPluralSep = `\|`
+
+// PluralRule is how a group of languages picks a plural form
+type PluralRule struct {
+	// CLDR categories, in order of form index
+	Forms []string
+	// gettext plural expression, n is the number
+	Expr string
+	// BCP 47 codes (see getLangISOCode), without region if rule doesn't
+	// depend on it
+	Langs []string
+}
+
+// index is the rule id in TranslationLangs.cpp
+var pluralRules = []*PluralRule{
+	{
+		Forms: []string{"other"},
+		Expr:  "0",
+		Langs: []string{"id", "ja", "jv", "ko", "ms", "my", "th", "vi", "zh"},
+	},
+	{
+		// default for languages not listed anywhere
+		Forms: []string{"one", "other"},
+		Expr:  "(n != 1)",
+		Langs: []string{"en"},
+	},
+	{
+		// 0 is singular
+		Forms: []string{"one", "other"},
+		Expr:  "(n > 1)",
+		Langs: []string{"bn", "fa", "fr", "hi", "hy", "pa", "pt-BR", "si"},
+	},
+	{
+		// bs, hr and sr call the last form "other" but for integers it's
+		// the same as "many" in be, ru and uk
+		Forms: []string{"one", "few", "many"},
+		Expr:  "(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2)",
+		Langs: []string{"be", "bs", "hr", "ru", "sr", "uk"},
+	},
+	{
+		Forms: []string{"one", "few", "other"},
+		Expr:  "(n==1 ? 0 : n>=2 && n<=4 ? 1 : 2)",
+		Langs: []string{"cs", "sk"},
+	},
+	{
+		Forms: []string{"one", "few", "many"},
+		Expr:  "(n==1 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2)",
+		Langs: []string{"pl"},
+	},
+	{
+		Forms: []string{"one", "few", "other"},
+		Expr:  "(n%10==1 && (n%100<11 || n%100>19) ? 0 : n%10>=2 && (n%100<11 || n%100>19) ? 1 : 2)",
+		Langs: []string{"lt"},
+	},
+	{
+		Forms: []string{"zero", "one", "other"},
+		Expr:  "(n%10==0 || (n%100>=11 && n%100<=19) ? 0 : n%10==1 && n%100!=11 ? 1 : 2)",
+		Langs: []string{"lv"},
+	},
+	{
+		Forms: []string{"one", "few", "other"},
+		Expr:  "(n==1 ? 0 : n==0 || (n%100>=2 && n%100<=19) ? 1 : 2)",
+		Langs: []string{"ro"},
+	},
+	{
+		Forms: []string{"one", "two", "few", "other"},
+		Expr:  "(n%100==1 ? 0 : n%100==2 ? 1 : n%100==3 || n%100==4 ? 2 : 3)",
+		Langs: []string{"sl"},
+	},
+	{
+		Forms: []string{"zero", "one", "two", "few", "many", "other"},
+		Expr:  "(n==0 ? 0 : n==1 ? 1 : n==2 ? 2 : n%100>=3 && n%100<=10 ? 3 : n%100>=11 ? 4 : 5)",
+		Langs: []string{"ar"},
+	},
+	{
+		Forms: []string{"one", "two", "few", "many", "other"},
+		Expr:  "(n==1 ? 0 : n==2 ? 1 : n>=3 && n<=6 ? 2 : n>=7 && n<=10 ? 3 : 4)",
+		Langs: []string{"ga"},
+	},
+	{
+		Forms: []string{"zero", "one", "two", "few", "many", "other"},
+		Expr:  "(n==0 ? 0 : n==1 ? 1 : n==2 ? 2 : n==3 ? 3 : n==6 ? 4 : 5)",
+		Langs: []string{"cy"},
+	},
+	{
+		Forms: []string{"one", "two", "other"},
+		Expr:  "(n==1 ? 0 : n==2 ? 1 : 2)",
+		Langs: []string{"he"},
+	},
+	{
+		Forms: []string{"one", "other"},
+		Expr:  "(n%10==1 && n%100!=11 ? 0 : 1)",
+		Langs: []string{"mk"},
+	},
+	{
+		Forms: []string{"one", "other"},
+		Expr:  "(n%10!=4 && n%10!=6 && n%10!=9 ? 0 : 1)",
+		Langs: []string{"fil"},
+	},
+}
+
+// languages whose BCP 47 code doesn't match CLDR
+var pluralLangAliases = map[string]string{
+	// we use LANG_FILIPINO for Tagalog
+	"tl": "fil",
+}
+
+func getPluralRuleIdx(lang string) int {
+	iso := getLangISOCode(lang)
+	if alias, ok := pluralLangAliases[lang]; ok {
+		iso = alias
+	}
+	base, _, _ := strings.Cut(iso, "-")
+	// exact match (e.g. pt-BR) wins over language
+	for _, code := range []string{iso, base} {
+		for i, r := range pluralRules {
+			if stringInSlice(r.Langs, code) {
+				return i
+			}
+		}
+	}
+	return 1
+}
+
+func getPluralRule(lang string) *PluralRule {
+	return pluralRules[getPluralRuleIdx(lang)]
+}
+
+// "nplurals=3; plural=(...);"
+func getPluralFormsHeader(lang string) string {
+	r := getPluralRule(lang)
+	return fmt.Sprintf("nplurals=%d; plural=%s;", len(r.Forms), r.Expr)
+}
+
+func isPluralTransString(s string) bool {
+	return len(splitPluralForms(s)) > 1
+}
+
+// escaped string => plural forms. \\| is literal \ followed by |
+func splitPluralForms(s string) []string {
+	var res []string
+	start := 0
+	for i := 0; i < len(s)-1; i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		if s[i+1] == '|' {
+			res = append(res, s[start:i])
+			start = i + 2
+		}
+		i++
+	}
+	return append(res, s[start:])
+}
+
+func joinPluralForms(forms []string) string {
+	return strings.Join(forms, transPluralSep)
+}
+
+// returns "" if plural forms of translation match the language
+func checkPluralForms(lang string, text string, trans string) string {
+	isPlural := isPluralTransString(text)
+	if !isPluralTransString(trans) {
+		if isPlural && len(getPluralRule(lang).Forms) > 1 {
+			return fmt.Sprintf("needs %s", describePluralForms(lang))
+		}
+		return ""
+	}
+	if !isPlural {
+		return fmt.Sprintf("has %s but the string is not plural", transPluralSep)
+	}
+	forms := splitPluralForms(trans)
+	if len(forms) != len(getPluralRule(lang).Forms) {
+		return fmt.Sprintf("has %d plural forms, needs %s", len(forms), describePluralForms(lang))
+	}
+	for _, f := range forms {
+		if strings.TrimSpace(f) == "" {
+			return "empty plural form"
+		}
+	}
+	return ""
+}
+
+// "3 plural forms (one, few, many) separated by \|"
+func describePluralForms(lang string) string {
+	forms := getPluralRule(lang).Forms
+	if len(forms) == 1 {
+		return fmt.Sprintf("1 form (other), without %s", transPluralSep)
+	}
+	return fmt.Sprintf("%d plural forms (%s) separated by %s", len(forms), strings.Join(forms, ", "), transPluralSep)
+}
+
+// every form must have format specifiers of English plural form, except
+// that a form can have no number e.g. "one file"
+func checkPluralFormatSpecifiers(text string, trans string) string {
+	engForms := splitPluralForms(text)
+	want := getFormatSpecifiers(engForms[len(engForms)-1])
+	for _, f := range splitPluralForms(trans) {
+		got := getFormatSpecifiers(f)
+		if len(got) == 0 || fmt.Sprint(got) == fmt.Sprint(want) {
+			continue
+		}
+		return fmt.Sprintf("format specifiers %v in '%s' don't match %v", got, f, want)
+	}
+	return ""
+}
+
+// for TranslationLangs.cpp
+func genPluralRulesCpp(langs []*Lang) (string, string) {
+	var ids []string
+	for _, lang := range langs {
+		ids = append(ids, fmt.Sprintf("%d", getPluralRuleIdx(lang.code)))
+	}
+	var cases []string
+	for i, r := range pluralRules {
+		cases = append(cases, fmt.Sprintf("    case %d:\n      return %s;", i, r.Expr))
+	}
+	return strings.Join(ids, ", "), strings.Join(cases, "\n")
+}
+
+// translations of old strings as forms of the new plural string. Returns
+// exact translations for languages where we don't have to guess: with one
+// form (we use old plural) or with one/other forms (we use old singular and
+// old plural) and fuzzy translations, with old plural as every form, for
+// the rest
+func migrateToPluralTranslations(newText string, oldTrans map[string]map[string]string, oldPlural string, oldSingular string) ([]*Translation, []*Translation) {
+	var exact, fuzzy []*Translation
+	for _, l := range gLangs {
+		lang := l[0]
+		plural, ok := oldTrans[lang][oldPlural]
+		if lang == "en" || !ok {
+			continue
+		}
+		fs := getPluralRule(lang).Forms
+		singular, hasSingular := oldTrans[lang][oldSingular]
+		isExact := true
+		var forms []string
+		switch {
+		case len(fs) == 1:
+			forms = []string{plural}
+		case len(fs) == 2 && fs[0] == "one" && oldSingular != "" && hasSingular:
+			forms = []string{singular, plural}
+		default:
+			isExact = false
+			for range fs {
+				forms = append(forms, plural)
+			}
+		}
+		tr := &Translation{Text: newText, Lang: lang, Translation: joinPluralForms(forms)}
+		if p := checkTranslation(lang, newText, tr.Translation); p != nil && p.Severity == kTransReject {
+			logf("%s: not migrating '%s': %s\n", lang, tr.Translation, p.Msg)
+			continue
+		}
+		if isExact {
+			exact = append(exact, tr)
+		} else {
+			fuzzy = append(fuzzy, tr)
+		}
+	}
+	return exact, fuzzy
+}
+
+// -trans-plural-migrate "${old plural}" ["${old singular}"]
+func transPluralMigrateMust(args []string) {
+	panicIf(len(args) < 1 || len(args) > 2, `usage: -trans-plural-migrate "${old plural}" ["${old singular}"]`)
+	oldPlural, oldSingular := args[0], ""
+	if len(args) == 2 {
+		oldSingular = args[1]
+	}
+	// the new string is the one whose plural form is the old string
+	newText := ""
+	for _, s := range extractTransStringsMust() {
+		if forms := splitPluralForms(s.Text); len(forms) > 1 && forms[len(forms)-1] == oldPlural {
+			newText = s.Text
+		}
+	}
+	panicIf(newText == "", "no _TRP() string in src/ with plural form '%s'", oldPlural)
+
+	d := string(readFileMust(translationsTxtPath))
+	curr := parseTranslations(d)
+	panicIf(curr[oldPlural] == nil, "no translations of '%s' in '%s'", oldPlural, translationsTxtPath)
+	exact, fuzzy := migrateToPluralTranslations(newText, translationsByLang(curr), oldPlural, oldSingular)
+	trs := append(append([]*Translation{}, exact...), fuzzy...)
+	if len(trs) == 0 {
+		logf("no translations to migrate\n")
+		return
+	}
+	var langs []string
+	for _, tr := range trs {
+		langs = append(langs, tr.Lang)
+	}
+	sort.Strings(langs)
+	logf("migrating translations of '%s' for %d languages (%d fuzzy): %s\n", newText, len(langs), len(fuzzy), strings.Join(langs, " "))
+	// the service must have the new string so -trans-upload must run first
+	svc := getTransServiceMust()
+	if len(exact) > 0 {
+		svc.UploadTranslations(exact, false)
+	}
+	if len(fuzzy) > 0 {
+		svc.UploadTranslations(fuzzy, true)
+	}
+
+	// the next -trans-download will have them but we want them now
+	header := strings.SplitN(d, "\n", 3)[:2]
+	for _, tr := range curr[newText] {
+		if !stringInSlice(langs, tr.Lang) {
+			trs = append(trs, tr)
+		}
+	}
+	curr[newText] = trs
+	if dryRunSkip("write %s", translationsTxtPath) {
+		return
+	}
+	writeFileMust(translationsTxtPath, []byte(serializeTranslations(header, curr)))
+	runGenerators([]string{"translations-good"})
+	logf("fuzzy and missing translations need to be fixed on %s\n", svc.Name())
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Converts translations to and from gettext .po / .pot files so that
+// translators can use Poedit, Weblate etc.
+//
+// -trans-po-export writes out/translations-po/SumatraPDF.pot (strings from
+// translations/strings.txt) and ${lang}.po for every language, with current
+// translations. Machine translations (translations-mt.txt) are exported as
+// fuzzy so that translators review them.
+//
+// -trans-po-import ${file.po} ... takes translations from .po files, checks
+// them like -trans-download does, uploads new and changed translations to
+// translation service and saves them in translations.txt and
+// translations-good.txt. Fuzzy and empty translations are skipped.
+//
+// Strings are C-escaped in both formats so they're copied as is. Plural
+// strings (see trans_plural.go) use msgid_plural and msgstr[N].
+
+var transPoDir = filepath.Join("out", "translations-po")
+
+// PoEntry is a single msgid / msgstr from .po file. Plural forms are
+// joined with transPluralSep
+type PoEntry struct {
+	MsgID  string
+	MsgStr string
+	Fuzzy  bool
+	// msgstr[N]
+	pluralForms []string
+}
+
+// PoFile is a parsed .po file
+type PoFile struct {
+	// from header e.g. "Language" => "de"
+	Headers map[string]string
+	Entries []*PoEntry
+}
+
+// "foo" => `"foo"`, strings are already escaped
+func poQuote(s string) string {
+	return `"` + s + `"`
+}
+
+func poHeader(lang string) string {
+	headers := []string{
+		"Project-Id-Version: SumatraPDF",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	if lang != "" {
+		headers = append(headers, "Language: "+strings.ReplaceAll(getLangISOCode(lang), "-", "_"))
+		// our code because it's not always ISO code
+		headers = append(headers, "X-SumatraPDF-Lang: "+lang)
+		headers = append(headers, "Plural-Forms: "+getPluralFormsHeader(lang))
+	}
+	lines := []string{`msgid ""`, `msgstr ""`}
+	for _, h := range headers {
+		lines = append(lines, poQuote(h+`\n`))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// lang is "" for .pot file. trans and mt are english => translation
+func genPo(lang string, strs []*TransString, trans map[string]string, mt map[string]string) string {
+	var sb strings.Builder
+	if lang == "" {
+		sb.WriteString("# SumatraPDF strings to translate\n")
+	} else {
+		fmt.Fprintf(&sb, "# SumatraPDF translation to %s\n", getLangName(lang))
+	}
+	sb.WriteString("# generated with .\\doit.bat -trans-po-export, import with -trans-po-import\n")
+	sb.WriteString(poHeader(lang))
+	for _, s := range strs {
+		sb.WriteString("\n")
+		for _, c := range s.Comments {
+			sb.WriteString("#. " + c + "\n")
+		}
+		for _, loc := range s.Locations {
+			sb.WriteString("#: " + loc + "\n")
+		}
+		msgStr := trans[s.Text]
+		var flags []string
+		if msgStr == "" && mt[s.Text] != "" {
+			msgStr = mt[s.Text]
+			sb.WriteString("#. machine translation\n")
+			flags = append(flags, "fuzzy")
+		}
+		if len(getFormatSpecifiers(s.Text)) > 0 {
+			// tell Poedit to check format specifiers
+			flags = append(flags, "c-format")
+		}
+		if s.MaxLen > 0 {
+			// Weblate checks it while translating
+			flags = append(flags, fmt.Sprintf("max-length:%d", s.MaxLen))
+		}
+		if len(flags) > 0 {
+			sb.WriteString("#, " + strings.Join(flags, ", ") + "\n")
+		}
+		if !isPluralTransString(s.Text) {
+			sb.WriteString("msgid " + poQuote(s.Text) + "\n")
+			sb.WriteString("msgstr " + poQuote(msgStr) + "\n")
+			continue
+		}
+		forms := splitPluralForms(s.Text)
+		sb.WriteString("msgid " + poQuote(forms[0]) + "\n")
+		sb.WriteString("msgid_plural " + poQuote(forms[1]) + "\n")
+		nForms := 2
+		if lang != "" {
+			nForms = len(getPluralRule(lang).Forms)
+		}
+		trForms := splitPluralForms(msgStr)
+		for i := 0; i < nForms; i++ {
+			tr := ""
+			if len(trForms) == nForms {
+				tr = trForms[i]
+			}
+			fmt.Fprintf(&sb, "msgstr[%d] %s\n", i, poQuote(tr))
+		}
+	}
+	return sb.String()
+}
+
+func getLangName(lang string) string {
+	for _, l := range gLangs {
+		if l[0] == lang {
+			return l[1]
+		}
+	}
+	return lang
+}
+
+// -trans-po-export
+func transPoExportMust() {
+	strs := readTransStringsMust()
+	human := translationsByLang(parseTranslations(string(readFileMust(translationsTxtPath))))
+	mt := readMachineTranslations()
+	must(os.MkdirAll(transPoDir, 0755))
+	path := filepath.Join(transPoDir, "SumatraPDF.pot")
+	writeFileMust(path, []byte(genPo("", strs, nil, nil)))
+	logf("wrote '%s'\n", path)
+	for _, l := range gLangs {
+		lang := l[0]
+		if lang == "en" {
+			continue
+		}
+		path := filepath.Join(transPoDir, lang+".po")
+		writeFileMust(path, []byte(genPo(lang, strs, human[lang], mt[lang])))
+	}
+	logf("wrote %d .po files to '%s'\n", len(gLangs)-1, transPoDir)
+}
+
+// parses the subset of .po format we need: no msgctxt
+func parsePoMust(path string, d string) *PoFile {
+	res := &PoFile{Headers: map[string]string{}}
+	var curr *PoEntry
+	// points to MsgID or MsgStr of curr, for continuation lines
+	var currStr *string
+	fuzzy := false
+	lines := strings.Split(normalizeNewlines(d), "\n")
+	unquote := func(s string, lineNo int) string {
+		s = strings.TrimSpace(s)
+		panicIf(len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"', "%s:%d: expected quoted string, got '%s'", path, lineNo, s)
+		return s[1 : len(s)-1]
+	}
+	for i, l := range lines {
+		lineNo := i + 1
+		l = strings.TrimSpace(l)
+		switch {
+		case l == "":
+			currStr = nil
+		case strings.HasPrefix(l, "#~"):
+			// obsolete entry
+		case strings.HasPrefix(l, "#,"):
+			fuzzy = fuzzy || strings.Contains(l, "fuzzy")
+		case strings.HasPrefix(l, "#"):
+			// comment or location
+		case strings.HasPrefix(l, "msgid "):
+			curr = &PoEntry{MsgID: unquote(l[len("msgid "):], lineNo), Fuzzy: fuzzy}
+			fuzzy = false
+			res.Entries = append(res.Entries, curr)
+			currStr = &curr.MsgID
+		case strings.HasPrefix(l, "msgstr "):
+			panicIf(curr == nil, "%s:%d: msgstr without msgid", path, lineNo)
+			curr.MsgStr = unquote(l[len("msgstr "):], lineNo)
+			currStr = &curr.MsgStr
+		case strings.HasPrefix(l, "msgid_plural "):
+			panicIf(curr == nil, "%s:%d: msgid_plural without msgid", path, lineNo)
+			// continuation lines are added to the plural form
+			curr.MsgID += transPluralSep + unquote(l[len("msgid_plural "):], lineNo)
+			currStr = &curr.MsgID
+		case strings.HasPrefix(l, "msgstr["):
+			panicIf(curr == nil, "%s:%d: msgstr without msgid", path, lineNo)
+			_, s, ok := strings.Cut(l, "] ")
+			panicIf(!ok, "%s:%d: invalid line '%s'", path, lineNo, l)
+			curr.pluralForms = append(curr.pluralForms, unquote(s, lineNo))
+			currStr = &curr.pluralForms[len(curr.pluralForms)-1]
+		case strings.HasPrefix(l, `"`):
+			panicIf(currStr == nil, "%s:%d: unexpected string", path, lineNo)
+			*currStr += unquote(l, lineNo)
+		case strings.HasPrefix(l, "msgctxt"):
+			panicIf(true, "%s:%d: '%s' is not supported", path, lineNo, l)
+		default:
+			panicIf(true, "%s:%d: invalid line '%s'", path, lineNo, l)
+		}
+	}
+	for _, e := range res.Entries {
+		if len(e.pluralForms) == 0 {
+			continue
+		}
+		// all forms or nothing
+		if !stringInSlice(e.pluralForms, "") {
+			e.MsgStr = joinPluralForms(e.pluralForms)
+		}
+	}
+	// first entry with empty msgid is the header
+	if len(res.Entries) > 0 && res.Entries[0].MsgID == "" {
+		for _, h := range strings.Split(res.Entries[0].MsgStr, `\n`) {
+			name, val, ok := strings.Cut(h, ":")
+			if ok {
+				res.Headers[strings.TrimSpace(name)] = strings.TrimSpace(val)
+			}
+		}
+		res.Entries = res.Entries[1:]
+	}
+	return res
+}
+
+// our language code of .po file
+func getPoLangMust(path string, po *PoFile) string {
+	lang := po.Headers["X-SumatraPDF-Lang"]
+	if lang == "" {
+		// e.g. "pt_BR" => "br"
+		iso := strings.ReplaceAll(po.Headers["Language"], "_", "-")
+		for l, code := range gLangISOCodes {
+			if strings.EqualFold(code, iso) {
+				lang = l
+			}
+		}
+		if lang == "" {
+			lang = iso
+		}
+	}
+	if lang == "" {
+		lang = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	panicIf(!isKnownLang(lang) || lang == "en", "%s: unknown language '%s', set X-SumatraPDF-Lang header to one of codes in do/trans_langs.go", path, lang)
+	return lang
+}
+
+/*
+body of /api/uploadtranslations looks like:
+
+AppTranslator translations
+:english
+de:translation
+
+with &fuzzy=1 translations are shown to translators for review and not
+returned by /api/dltransfor until then
+*/
+func uploadTranslationsToServerMust(trs []*Translation, fuzzy bool) {
+	lines := []string{"AppTranslator translations"}
+	currStr := ""
+	for _, tr := range trs {
+		if tr.Text != currStr {
+			currStr = tr.Text
+			lines = append(lines, ":"+currStr)
+		}
+		lines = append(lines, tr.Lang+":"+tr.Translation)
+	}
+	if dryRunSkip("upload %d translations to %s", len(trs), apptranslatoServer) {
+		return
+	}
+	uri := apptranslatorURL("/api/uploadtranslations")
+	if fuzzy {
+		uri += "&fuzzy=1"
+	}
+	body := strings.NewReader(strings.Join(lines, "\n") + "\n")
+	req, err := http.NewRequest(http.MethodPost, uri, body)
+	must(err)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	apptranslatorDoMust(req)
+}
+
+// inverse of parseTranslations(), header is first 2 lines of translations.txt
+func serializeTranslations(header []string, m map[string][]*Translation) string {
+	var strs []string
+	for s := range m {
+		strs = append(strs, s)
+	}
+	sort.Strings(strs)
+	lines := append([]string{}, header...)
+	for _, s := range strs {
+		lines = append(lines, ":"+s)
+		a := m[s]
+		// same order as apptranslator.org, "ca-xv:" before "ca:"
+		sort.SliceStable(a, func(i, j int) bool {
+			return a[i].Lang+":" < a[j].Lang+":"
+		})
+		for _, tr := range a {
+			lines = append(lines, tr.Lang+":"+tr.Translation)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// -trans-po-import ${file.po} ...
+func transPoImportMust(paths []string) {
+	panicIf(len(paths) == 0, "usage: -trans-po-import ${file.po} ...")
+	d := string(readFileMust(translationsTxtPath))
+	header := strings.SplitN(d, "\n", 3)[:2]
+	curr := parseTranslations(d)
+	var toUpload []*Translation
+	for _, path := range paths {
+		po := parsePoMust(path, string(readFileMust(path)))
+		lang := getPoLangMust(path, po)
+		nAdded, nChanged, nSkipped := 0, 0, 0
+		for _, e := range po.Entries {
+			trans := fixTranslation(e.MsgStr)
+			if e.Fuzzy || trans == "" {
+				continue
+			}
+			a, ok := curr[e.MsgID]
+			if !ok {
+				logf("%s: skipping '%s', not a string we translate\n", path, e.MsgID)
+				nSkipped++
+				continue
+			}
+			if p := checkTranslation(lang, e.MsgID, trans); p != nil && p.Severity == kTransReject {
+				logf("%s: skipping '%s' => '%s': %s\n", path, e.MsgID, trans, p.Msg)
+				nSkipped++
+				continue
+			}
+			var existing *Translation
+			for _, tr := range a {
+				if tr.Lang == lang {
+					existing = tr
+				}
+			}
+			switch {
+			case existing == nil:
+				existing = &Translation{Text: e.MsgID, Lang: lang}
+				curr[e.MsgID] = append(a, existing)
+				nAdded++
+			case existing.Translation != trans:
+				nChanged++
+			default:
+				continue
+			}
+			existing.Translation = trans
+			toUpload = append(toUpload, existing)
+		}
+		logf("%s: %s: %d new, %d changed, %d skipped translations\n", path, lang, nAdded, nChanged, nSkipped)
+	}
+	if len(toUpload) == 0 {
+		logf("no new translations\n")
+		return
+	}
+	sort.SliceStable(toUpload, func(i, j int) bool {
+		return toUpload[i].Text < toUpload[j].Text
+	})
+	// upload first so that the next -trans-download doesn't undo the import
+	getTransServiceMust().UploadTranslations(toUpload, false)
+	if dryRunSkip("write %s", translationsTxtPath) {
+		return
+	}
+	writeFileMust(translationsTxtPath, []byte(serializeTranslations(header, curr)))
+	logf("wrote '%s'\n", translationsTxtPath)
+	runGenerators([]string{"translations-good"})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// translations/translations-pseudo.txt has a pseudo-localized translation
+// of every string in translations/strings.txt:
+//
+//	&Open... => [&Oƥéñ...·ŀő]
+//
+// Letters are replaced with accented versions and text is ~35% longer
+// (translations are usually longer than English) and in [ ].
+// Text cut off in dialogs or menus, strings that are not marked for
+// translation and non-ASCII handling bugs are easy to spot without waiting
+// for translators.
+// Format specifiers, escapes and & (menu accelerators) are kept.
+//
+// It's embedded in debug and pre-release builds (see SumatraPDF.rc) and
+// selected with: SumatraPDF.exe -lang qps-ploc
+// Re-generate with: .\doit.bat -gen trans-pseudo
+
+var transPseudoPath = filepath.Join(translationsDir, "translations-pseudo.txt")
+
+// same as pseudo-locale in Windows
+const pseudoLangCode = "qps-ploc"
+
+// how much longer pseudo-localized text is, in percent
+const pseudoExpandPercent = 35
+
+var pseudoLetters = map[rune]rune{
+	'a': 'å', 'b': 'ƀ', 'c': 'ç', 'd': 'ð', 'e': 'é', 'f': 'ƒ', 'g': 'ĝ',
+	'h': 'ĥ', 'i': 'î', 'j': 'ĵ', 'k': 'ķ', 'l': 'ļ', 'm': 'ɱ', 'n': 'ñ',
+	'o': 'ö', 'p': 'ƥ', 'q': 'ʠ', 'r': 'ŕ', 's': 'š', 't': 'ţ', 'u': 'û',
+	'v': 'ṽ', 'w': 'ŵ', 'x': 'ẋ', 'y': 'ý', 'z': 'ž',
+	'A': 'Å', 'B': 'Ɓ', 'C': 'Ç', 'D': 'Ð', 'E': 'É', 'F': 'Ƒ', 'G': 'Ĝ',
+	'H': 'Ĥ', 'I': 'Î', 'J': 'Ĵ', 'K': 'Ķ', 'L': 'Ļ', 'M': 'Ṁ', 'N': 'Ñ',
+	'O': 'Ö', 'P': 'Þ', 'Q': 'Ǫ', 'R': 'Ŕ', 'S': 'Š', 'T': 'Ţ', 'U': 'Û',
+	'V': 'Ṽ', 'W': 'Ŵ', 'X': 'Ẋ', 'Y': 'Ý', 'Z': 'Ž',
+}
+
+// added to make text longer
+var pseudoPadding = []rune("·ŀőřéɱ ïƥšûɱ ðőļőŕ šïţ åɱéţ")
+
+// s is escaped as in C source
+func pseudoLocalize(s string) string {
+	// don't change format specifiers
+	keep := make([]bool, len(s))
+	for _, m := range rxFormatSpecifier.FindAllStringIndex(s, -1) {
+		for i := m[0]; i < m[1]; i++ {
+			keep[i] = true
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString("[")
+	nChars := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case keep[i]:
+			sb.WriteByte(c)
+			i++
+			continue
+		case c == '\\' && i+1 < len(s):
+			// \n etc.
+			sb.WriteString(s[i : i+2])
+			i += 2
+			continue
+		case c == '&' && i+1 < len(s):
+			// keep accelerator key, && is a literal &
+			_, n := utf8.DecodeRuneInString(s[i+1:])
+			sb.WriteString(s[i : i+1+n])
+			i += 1 + n
+			nChars++
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if pr, ok := pseudoLetters[r]; ok {
+			r = pr
+		}
+		sb.WriteRune(r)
+		i += n
+		nChars++
+	}
+	nPad := (nChars*pseudoExpandPercent + 99) / 100
+	for i := 0; i < nPad; i++ {
+		sb.WriteRune(pseudoPadding[i%len(pseudoPadding)])
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+func genPseudoTranslationsMust() {
+	strs := readTransStringsMust()
+	// same format as translations.txt, first 2 lines are skipped
+	lines := []string{
+		"AppTranslator: SumatraPDF",
+		"AppTranslator: SumatraPDF",
+	}
+	for _, s := range strs {
+		var forms []string
+		for _, form := range splitPluralForms(s.Text) {
+			forms = append(forms, pseudoLocalize(form))
+		}
+		trans := joinPluralForms(forms)
+		panicIf(!reflect.DeepEqual(getFormatSpecifiers(s.Text), getFormatSpecifiers(trans)), "format specifiers changed in '%s' => '%s'", s.Text, trans)
+		panicIf(countAccelerators(s.Text) != countAccelerators(trans), "& changed in '%s' => '%s'", s.Text, trans)
+		lines = append(lines, ":"+s.Text, pseudoLangCode+":"+trans)
+	}
+	writeFileMust(transPseudoPath, []byte(strings.Join(lines, "\n")+"\n"))
+	logf("wrote '%s' with %d strings\n", transPseudoPath, len(strs))
+}
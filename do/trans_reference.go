@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// translators-reference.html on the website lists all strings to translate
+// with what translators need to know about them: context from TRANSLATORS:
+// comments, screenshot, length budget, where they're used in the source and
+// in the ui (menus and dialogs from ACCESSKEY_GROUP markers, see
+// check_accesskyes.go) and current translations in all languages.
+//
+// Written together with other docs (-gen-docs-website) or with
+// -gen-trans-reference.
+
+const transReferenceName = "translators-reference.html"
+
+const githubSourceURLBase = "https://github.com/sumatrapdfreader/sumatrapdf/blob/master/"
+
+// TransRefLocation is where a string is used in the source
+type TransRefLocation struct {
+	// "src/Menu.cpp:123"
+	Loc string
+	URL string
+}
+
+// TransRefTranslation is a translation of a string in translators reference
+type TransRefTranslation struct {
+	Lang  string
+	Name  string
+	Text  string
+	IsRtl bool
+}
+
+// TransRefString is a string in translators reference
+type TransRefString struct {
+	ID            string
+	Text          string
+	Comments      []string
+	ScreenshotURL string
+	MaxLen        int
+	IsPlural      bool
+	Locations     []*TransRefLocation
+	// names of menus and dialogs e.g. "File Menu"
+	UIPlaces     []string
+	Translations []*TransRefTranslation
+}
+
+// TransRefLang is translation progress of a language in translators reference
+type TransRefLang struct {
+	Lang        string
+	Name        string
+	NTranslated int
+	Percent     int
+	// "one, few, many"
+	PluralForms string
+}
+
+// "src/Menu.cpp:123" => https://github.com/.../src/Menu.cpp#L123
+func getTransLocationURL(loc string) string {
+	path, line, ok := strings.Cut(loc, ":")
+	if !ok {
+		return githubSourceURLBase + path
+	}
+	return githubSourceURLBase + path + "#L" + line
+}
+
+// english => names of menus and dialogs that show it
+func getTransUIPlaces(groups map[string]*accessGroup) map[string][]string {
+	res := map[string][]string{}
+	for name, g := range groups {
+		for _, it := range g.items {
+			res[it.text] = append(res[it.text], name)
+		}
+	}
+	for _, a := range res {
+		sort.Strings(a)
+	}
+	return res
+}
+
+func getTransRefStrings(strs []*TransString, trans map[string][]*Translation, uiPlaces map[string][]string) []*TransRefString {
+	var res []*TransRefString
+	for i, s := range strs {
+		rs := &TransRefString{
+			ID:       fmt.Sprintf("s%d", i+1),
+			Text:     s.Text,
+			Comments: s.Comments,
+			MaxLen:   s.MaxLen,
+			IsPlural: isPluralTransString(s.Text),
+			UIPlaces: uiPlaces[s.Text],
+		}
+		if s.Screenshot != "" {
+			rs.ScreenshotURL = getTransScreenshotURL(s.Screenshot)
+		}
+		for _, loc := range s.Locations {
+			rs.Locations = append(rs.Locations, &TransRefLocation{Loc: loc, URL: getTransLocationURL(loc)})
+		}
+		for _, tr := range trans[s.Text] {
+			rt := &TransRefTranslation{
+				Lang:  tr.Lang,
+				Name:  getLangName(tr.Lang),
+				Text:  tr.Translation,
+				IsRtl: isRtlLang(tr.Lang),
+			}
+			rs.Translations = append(rs.Translations, rt)
+		}
+		sort.Slice(rs.Translations, func(i, j int) bool {
+			return rs.Translations[i].Lang < rs.Translations[j].Lang
+		})
+		res = append(res, rs)
+	}
+	return res
+}
+
+// sorted by name
+func getTransRefLangs(strs []*TransRefString) []*TransRefLang {
+	nTranslated := map[string]int{}
+	for _, s := range strs {
+		for _, tr := range s.Translations {
+			nTranslated[tr.Lang]++
+		}
+	}
+	var res []*TransRefLang
+	for _, l := range gLangs {
+		lang := l[0]
+		if lang == "en" {
+			continue
+		}
+		rl := &TransRefLang{
+			Lang:        lang,
+			Name:        l[1],
+			NTranslated: nTranslated[lang],
+			PluralForms: strings.Join(getPluralRule(lang).Forms, ", "),
+		}
+		if len(strs) > 0 {
+			rl.Percent = rl.NTranslated * 100 / len(strs)
+		}
+		res = append(res, rl)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+const transReferenceTmpl = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="generator" content="generated from translations/strings.txt and translations.txt by .\doit.bat -gen-trans-reference, don't edit">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>SumatraPDF strings reference for translators</title>
+<link rel="stylesheet" href="/sumatra.css">
+<style>
+.str { border-top: 1px solid #ddd; padding: 0.5em 0; }
+.text { font-family: monospace; font-size: 1.1em; font-weight: bold; }
+.comment { color: #555; font-style: italic; }
+.meta { font-size: 0.9em; color: #666; }
+.screenshot { max-width: 100%; border: 1px solid #ddd; }
+.trans td { padding: 1px 8px 1px 0; vertical-align: top; }
+.trans td.lang { color: #666; white-space: nowrap; }
+.missing { color: #b00; }
+</style>
+</head>
+<body>
+<h2>SumatraPDF strings reference for translators</h2>
+<p>{{len .Strings}} strings to translate. Translate them at <a href="{{.ServiceURL}}">{{.ServiceURL}}</a>,
+see <a href="/docs/Contribute-translation">how to contribute a translation</a>.</p>
+<p>Strings are shown as in the source code: <code>\n</code> is a new line and <code>&amp;</code> marks menu accelerator.
+Translations must keep format specifiers like <code>%s</code> and <code>%d</code>.</p>
+
+<p>Show translations in:
+<select id="lang">
+<option value="">all languages</option>
+{{range .Langs}}<option value="{{.Lang}}">{{.Name}}: {{.NTranslated}} translated ({{.Percent}}%)</option>
+{{end}}</select>
+<label><input type="checkbox" id="untranslated"> only untranslated strings</label>
+</p>
+
+{{range .Strings}}
+<div class="str" id="{{.ID}}" data-langs="{{range .Translations}}{{.Lang}} {{end}}">
+<div class="text"><a href="#{{.ID}}">#</a> {{.Text}}</div>
+{{range .Comments}}<div class="comment">{{.}}</div>
+{{end}}{{if .MaxLen}}<div class="meta">At most {{.MaxLen}} characters (not counting &amp;), longer text is clipped.</div>
+{{end}}{{if .IsPlural}}<div class="meta">Plural: translate all <a href="#plurals">plural forms of your language</a> separated by <code>\|</code>.</div>
+{{end}}{{if .UIPlaces}}<div class="meta">Shown in: {{range $i, $p := .UIPlaces}}{{if $i}}, {{end}}{{$p}}{{end}}</div>
+{{end}}<div class="meta">Used in: {{range .Locations}}<a href="{{.URL}}">{{.Loc}}</a> {{end}}</div>
+{{if .ScreenshotURL}}<div><img class="screenshot" src="{{.ScreenshotURL}}" alt="screenshot" loading="lazy"></div>
+{{end}}<table class="trans">
+{{range .Translations}}<tr data-lang="{{.Lang}}"><td class="lang">{{.Name}}</td><td{{if .IsRtl}} dir="rtl"{{end}}>{{.Text}}</td></tr>
+{{end}}<tr class="missing" style="display:none"><td colspan="2">not translated</td></tr>
+</table>
+</div>
+{{end}}
+
+<h3 id="plurals">Plural forms</h3>
+<p>Plural strings have English singular and plural separated by <code>\|</code> e.g. <code>%d file\|%d files</code>.
+Translate them as all plural forms of your language (as defined by <a href="https://www.unicode.org/cldr/charts/latest/supplemental/language_plural_rules.html">CLDR</a>), in this order, separated by <code>\|</code>.</p>
+<table class="trans">
+{{range .Langs}}<tr><td class="lang">{{.Name}}</td><td>{{.PluralForms}}</td></tr>
+{{end}}</table>
+
+<script>
+function filter() {
+	var lang = document.getElementById("lang").value;
+	var untranslated = document.getElementById("untranslated").checked;
+	document.querySelectorAll(".str").forEach(function(el) {
+		var has = lang !== "" && el.dataset.langs.split(" ").indexOf(lang) >= 0;
+		el.style.display = (untranslated && (lang === "" || has)) ? "none" : "";
+		el.querySelectorAll("tr[data-lang]").forEach(function(tr) {
+			tr.style.display = (lang === "" || tr.dataset.lang === lang) ? "" : "none";
+		});
+		el.querySelector("tr.missing").style.display = (lang !== "" && !has) ? "" : "none";
+	});
+}
+document.getElementById("lang").addEventListener("change", filter);
+document.getElementById("untranslated").addEventListener("change", filter);
+</script>
+</body>
+</html>
+`
+
+func genTransReference(strs []*TransString, trans map[string][]*Translation, groups map[string]*accessGroup, serviceURL string) string {
+	refStrs := getTransRefStrings(strs, trans, getTransUIPlaces(groups))
+	tmpl := template.Must(template.New("").Parse(transReferenceTmpl))
+	var buf bytes.Buffer
+	v := map[string]interface{}{
+		"Strings":    refStrs,
+		"Langs":      getTransRefLangs(refStrs),
+		"ServiceURL": serviceURL,
+	}
+	must(tmpl.Execute(&buf, v))
+	return buf.String()
+}
+
+// dir is www directory of website repo
+func writeTransReferenceMust(dir string) {
+	// not from strings.txt, which doesn't have line numbers
+	strs := extractTransStringsMust()
+	trans := parseTranslations(string(readFileMust(translationsTxtPath)))
+	serviceURL := getTransServiceMust().LangURL("")
+	d := genTransReference(strs, trans, getAccessKeyGroupsMust(), serviceURL)
+	path := filepath.Join(dir, transReferenceName)
+	writeFileMust(path, []byte(d))
+	logf("wrote '%s', %d strings\n", path, len(strs))
+}
+
+func genTransReferenceMust() {
+	dir := filepath.Join(updateSumatraWebsite(), "server", "www")
+	writeTransReferenceMust(dir)
+	logf("Don't forget to checkin the file and deploy website\n")
+}
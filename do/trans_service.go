@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+)
+
+// Translations are done on a translation service. apptranslator.org is the
+// default, Weblate can be used instead by setting TRANS_SERVICE=weblate
+// (see trans_weblate.go).
+//
+// -trans-upload, -trans-download, -trans-po-import etc. only talk to the
+// service through TransService so they work the same with all of them.
+
+var transServiceName string
+
+const (
+	transServiceApptranslator = "apptranslator"
+	transServiceWeblate       = "weblate"
+)
+
+// TransService is a service where translators translate our strings
+type TransService interface {
+	Name() string
+	// url of the page where lang is translated
+	LangURL(lang string) string
+	// english strings the service has
+	GetStrings() []string
+	// makes strings on the service the same as strs
+	UpdateStrings(strs []string, diff *TransStringsDiff)
+	// sends comments and screenshots of strings for translators
+	UpdateContext(strs []*TransString)
+	// returns translations of strs in translations.txt format
+	DownloadTranslations(strs []string) []byte
+	// adds or changes translations. fuzzy translations need to be reviewed
+	// by translators before they're used
+	UploadTranslations(trs []*Translation, fuzzy bool)
+	// starts translation to a new language, see trans_add_lang.go
+	AddLanguage(lang string)
+	// lang => names of people who translated it, see trans_credits.go
+	GetContributors() map[string][]string
+}
+
+type apptranslatorService struct{}
+
+func (s *apptranslatorService) Name() string {
+	return apptranslatoServer
+}
+
+func (s *apptranslatorService) LangURL(lang string) string {
+	return apptranslatoServer + "/app/SumatraPDF/" + lang
+}
+
+func (s *apptranslatorService) GetStrings() []string {
+	return getServerTransStringsMust()
+}
+
+func (s *apptranslatorService) UpdateStrings(strs []string, diff *TransStringsDiff) {
+	uploadTransStringsDiffMust(diff)
+}
+
+func (s *apptranslatorService) UpdateContext(strs []*TransString) {
+	uploadTransContextMust(strs)
+}
+
+func (s *apptranslatorService) DownloadTranslations(strs []string) []byte {
+	return downloadTranslationsMust(strs)
+}
+
+func (s *apptranslatorService) UploadTranslations(trs []*Translation, fuzzy bool) {
+	uploadTranslationsToServerMust(trs, fuzzy)
+}
+
+func getTransServiceMust() TransService {
+	switch strings.ToLower(strings.TrimSpace(transServiceName)) {
+	case "", transServiceApptranslator:
+		return &apptranslatorService{}
+	case transServiceWeblate:
+		return newWeblateServiceMust()
+	}
+	panicIf(true, "unknown TRANS_SERVICE '%s', must be %s or %s", transServiceName, transServiceApptranslator, transServiceWeblate)
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sort"
+)
+
+// When English text changes a bit (typo fix, different punctuation etc.)
+// -trans-upload removes the old string and adds a new one, which would lose
+// all translations of the old string.
+// To avoid that we find an obsoleted string most similar to each new string
+// and, if similar enough, upload translations of the old string as fuzzy
+// translations of the new string. Translators only need to review them and
+// until they do the app shows English text.
+
+// how similar (0..1) strings must be for their translations to be re-used
+const transFuzzyMinSimilarity = 0.8
+
+// TransFuzzyMatch is an obsoleted string similar to a new string
+type TransFuzzyMatch struct {
+	Old        string
+	New        string
+	Similarity float64
+}
+
+// edit distance between a and b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// 1 if strings are the same, 0 if completely different
+func stringSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	n := max(len(ra), len(rb))
+	if n == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(n)
+}
+
+func findTransFuzzyMatches(diff *TransStringsDiff) []*TransFuzzyMatch {
+	var res []*TransFuzzyMatch
+	for _, s := range diff.Added {
+		var best *TransFuzzyMatch
+		for _, old := range diff.Removed {
+			sim := stringSimilarity(old, s)
+			if sim >= transFuzzyMinSimilarity && (best == nil || sim > best.Similarity) {
+				best = &TransFuzzyMatch{Old: old, New: s, Similarity: sim}
+			}
+		}
+		if best != nil {
+			res = append(res, best)
+		}
+	}
+	return res
+}
+
+// translations of old strings as translations of new strings, skips those
+// that wouldn't be valid e.g. because format specifiers changed
+func getTransFuzzyTranslations(matches []*TransFuzzyMatch, curr map[string][]*Translation) []*Translation {
+	var res []*Translation
+	for _, m := range matches {
+		n := 0
+		for _, tr := range curr[m.Old] {
+			if p := checkTranslation(tr.Lang, m.New, tr.Translation); p != nil && p.Severity == kTransReject {
+				continue
+			}
+			res = append(res, &Translation{Text: m.New, Lang: tr.Lang, Translation: tr.Translation})
+			n++
+		}
+		logf("  '%s' => '%s' (%.0f%% similar): %d translations\n", m.Old, m.New, m.Similarity*100, n)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Text < res[j].Text
+	})
+	return res
+}
+
+// after new strings were uploaded
+func uploadTransFuzzyTranslationsMust(svc TransService, diff *TransStringsDiff) {
+	matches := findTransFuzzyMatches(diff)
+	if len(matches) == 0 {
+		return
+	}
+	logf("\nre-using translations of similar obsoleted strings:\n")
+	curr := parseTranslations(string(readFileMust(translationsTxtPath)))
+	trs := getTransFuzzyTranslations(matches, curr)
+	if len(trs) == 0 {
+		return
+	}
+	svc.UploadTranslations(trs, true)
+	logf("uploaded %d fuzzy translations for translators to review\n", len(trs))
+}
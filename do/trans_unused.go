@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// -trans-unused shows strings that are in translations/translations.txt
+// (i.e. on apptranslator.org and, via translations-good.txt, in the exe) but
+// are no longer used in src/. For each we show how many translations it has,
+// how many bytes it takes in translations-good.txt and if it still appears
+// in commented out or #if 0 code (which the extractor ignores).
+//
+// To retire them: -trans-upload removes them from the server and the next
+// -trans-download removes them from translations.txt and the exe.
+
+// UnusedTransString is a translated string not used in src/
+type UnusedTransString struct {
+	Text          string
+	NTranslations int
+	NBytes        int
+	// where it appears in code that's not compiled
+	DeadLocations []string
+}
+
+// locations of _TRA("text") etc. found with a regexp, which also finds them
+// in comments and #if 0 blocks
+func findDeadTransLocations() map[string][]string {
+	res := map[string][]string{}
+	for _, path := range getTransSourceFilesMust() {
+		lines, err := readLinesFromFile(path)
+		must(err)
+		for i, l := range lines {
+			for _, s := range extractTranslations(l) {
+				loc := fmt.Sprintf("%s:%d", filepath.ToSlash(path), i+1)
+				res[s] = append(res[s], loc)
+			}
+		}
+	}
+	return res
+}
+
+func findUnusedTransStrings() []*UnusedTransString {
+	used := map[string]bool{}
+	for _, s := range extractTransStringsMust() {
+		used[s.Text] = true
+	}
+	dead := findDeadTransLocations()
+	var res []*UnusedTransString
+	for text, a := range parseTranslations(string(readFileMust(translationsTxtPath))) {
+		if used[text] {
+			continue
+		}
+		u := &UnusedTransString{
+			Text:          text,
+			NTranslations: len(a),
+			NBytes:        len(text) + 2,
+			DeadLocations: dead[text],
+		}
+		for _, tr := range a {
+			// "${lang}:${translation}\n"
+			u.NBytes += len(tr.Lang) + len(tr.Translation) + 2
+		}
+		res = append(res, u)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Text < res[j].Text
+	})
+	return res
+}
+
+func printUnusedTranslations() {
+	unused := findUnusedTransStrings()
+	if len(unused) == 0 {
+		logf("all strings in '%s' are used\n", translationsTxtPath)
+		return
+	}
+	nTrans, nBytes := 0, 0
+	for _, u := range unused {
+		logf("%s\n  %d translations, %s\n", u.Text, u.NTranslations, formatSize(int64(u.NBytes)))
+		for _, loc := range u.DeadLocations {
+			logf("  in commented out or disabled code: %s\n", loc)
+		}
+		nTrans += u.NTranslations
+		nBytes += u.NBytes
+	}
+	logf("\n%d unused strings with %d translations, %s\n", len(unused), nTrans, formatSize(int64(nBytes)))
+	logf("to remove them run -trans-upload and then -trans-download\n")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -trans-upload sends changes in strings to translate to translation service
+// (apptranslator.org by default, see trans_service.go).
+// We get the strings the server has, compare with strings extracted from
+// src/ (see trans_extract.go) and upload only what was added and removed.
+// Removing a string obsoletes its translations so we show how many
+// translations each removed string had, to catch accidental removals
+// (e.g. a typo fix in English text). Translations of removed strings that
+// are similar to new strings are re-used as fuzzy (see trans_tm.go).
+// We also upload comments and screenshots for translators (see
+// TRANSLATORS: comments in trans_extract.go).
+
+// TransStringsDiff is a difference between strings in src/ and on the server
+type TransStringsDiff struct {
+	Added   []string
+	Removed []string
+}
+
+func (d *TransStringsDiff) isEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+func apptranslatorURL(path string) string {
+	v := url.Values{}
+	v.Set("app", "SumatraPDF")
+	v.Set("secret", getTransSecret())
+	return apptranslatoServer + path + "?" + v.Encode()
+}
+
+func apptranslatorDoMust(req *http.Request) []byte {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	rsp, err := client.Do(req)
+	must(err)
+	defer rsp.Body.Close()
+	d, err := io.ReadAll(rsp.Body)
+	must(err)
+	panicIf(rsp.StatusCode != http.StatusOK, "%s %s%s failed with status code %d, body: '%s'", req.Method, apptranslatoServer, req.URL.Path, rsp.StatusCode, string(d))
+	return d
+}
+
+// strings to translate that apptranslator.org has, one per line
+func getServerTransStringsMust() []string {
+	req, err := http.NewRequest(http.MethodGet, apptranslatorURL("/api/strings"), nil)
+	must(err)
+	d := apptranslatorDoMust(req)
+	var res []string
+	for _, s := range strings.Split(normalizeNewlines(string(d)), "\n") {
+		if s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func diffTransStrings(local []string, server []string) *TransStringsDiff {
+	inLocal := map[string]bool{}
+	for _, s := range local {
+		inLocal[s] = true
+	}
+	inServer := map[string]bool{}
+	for _, s := range server {
+		inServer[s] = true
+	}
+	res := &TransStringsDiff{}
+	for _, s := range local {
+		if !inServer[s] {
+			res.Added = append(res.Added, s)
+		}
+	}
+	for _, s := range server {
+		if !inLocal[s] {
+			res.Removed = append(res.Removed, s)
+		}
+	}
+	sort.Strings(res.Added)
+	sort.Strings(res.Removed)
+	return res
+}
+
+/*
+body of /api/updatestrings looks like:
+
+AppTranslator strings diff
++new string
+-removed string
+*/
+func uploadTransStringsDiffMust(diff *TransStringsDiff) {
+	lines := []string{"AppTranslator strings diff"}
+	for _, s := range diff.Added {
+		lines = append(lines, "+"+s)
+	}
+	for _, s := range diff.Removed {
+		lines = append(lines, "-"+s)
+	}
+	if dryRunSkip("upload %d added and %d removed strings to %s", len(diff.Added), len(diff.Removed), apptranslatoServer) {
+		return
+	}
+	body := strings.NewReader(strings.Join(lines, "\n") + "\n")
+	req, err := http.NewRequest(http.MethodPost, apptranslatorURL("/api/updatestrings"), body)
+	must(err)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	apptranslatorDoMust(req)
+}
+
+func getTransScreenshotURL(name string) string {
+	return githubRawURLBase + "/master/translations/screenshots/" + name
+}
+
+/*
+body of /api/updatecontext looks like:
+
+AppTranslator strings context
+:Fit &Width
+#. "Fit Width" zoom, shown in toolbar and View menu
+#screenshot: https://raw.githubusercontent.com/.../toolbar-zoom.png
+:Page:
+#. label before page number box in toolbar
+#maxlen: 16
+
+It has all strings with context, context of other strings is removed.
+*/
+func uploadTransContextMust(strs []*TransString) {
+	lines := []string{"AppTranslator strings context"}
+	n := 0
+	for _, s := range strs {
+		if !s.hasContext() {
+			continue
+		}
+		lines = append(lines, ":"+s.Text)
+		for _, c := range s.Comments {
+			lines = append(lines, "#. "+c)
+		}
+		if s.Screenshot != "" {
+			lines = append(lines, "#screenshot: "+getTransScreenshotURL(s.Screenshot))
+		}
+		if s.MaxLen > 0 {
+			lines = append(lines, fmt.Sprintf("#maxlen: %d", s.MaxLen))
+		}
+		n++
+	}
+	if dryRunSkip("upload context of %d strings to %s", n, apptranslatoServer) {
+		return
+	}
+	body := strings.NewReader(strings.Join(lines, "\n") + "\n")
+	req, err := http.NewRequest(http.MethodPost, apptranslatorURL("/api/updatecontext"), body)
+	must(err)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	apptranslatorDoMust(req)
+	logf("uploaded context of %d strings to %s\n", n, apptranslatoServer)
+}
+
+func printTransStringsDiff(diff *TransStringsDiff) {
+	// number of translations of each string from the last download
+	nTrans := map[string]int{}
+	for s, a := range parseTranslations(string(readFileMust(translationsTxtPath))) {
+		nTrans[s] = len(a)
+	}
+	if len(diff.Added) > 0 {
+		logf("\nnew strings (%d):\n", len(diff.Added))
+		for _, s := range diff.Added {
+			logf("  %s\n", s)
+		}
+	}
+	if len(diff.Removed) > 0 {
+		total := 0
+		logf("\nobsoleted strings (%d):\n", len(diff.Removed))
+		for _, s := range diff.Removed {
+			logf("  %s (%d translations)\n", s, nTrans[s])
+			total += nTrans[s]
+		}
+		logf("%d translations will no longer be used\n", total)
+	}
+}
+
+func uploadTranslationsMust() {
+	strs := extractTransStringsMust()
+	var local []string
+	for _, s := range strs {
+		local = append(local, s.Text)
+	}
+	svc := getTransServiceMust()
+	server := svc.GetStrings()
+	diff := diffTransStrings(local, server)
+	logf("%d strings in src/, %d on %s\n", len(local), len(server), svc.Name())
+	if diff.isEmpty() {
+		logf("strings on %s are up to date\n", svc.Name())
+	} else {
+		printTransStringsDiff(diff)
+		svc.UpdateStrings(local, diff)
+		logf("\nuploaded %d new and %d obsoleted strings\n", len(diff.Added), len(diff.Removed))
+		uploadTransFuzzyTranslationsMust(svc, diff)
+	}
+	// context can change without changing strings
+	svc.UpdateContext(strs)
+}
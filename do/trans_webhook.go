@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -trans-webhook runs a server that Weblate calls (Webhooks add-on) when
+// translations change. After a change we wait transWebhookDelay for more
+// changes (translators usually translate many strings in one session) and
+// then pull translations like -trans-download.
+//
+// Requests are signed (https://www.standardwebhooks.com/) with
+// WEBLATE_WEBHOOK_SECRET ("whsec_..." from Weblate's webhook settings).
+
+var weblateWebhookSecret string
+
+const (
+	transWebhookAddr  = ":8124"
+	transWebhookDelay = 5 * time.Minute
+	// reject requests signed longer ago to prevent replays
+	transWebhookMaxAge = 5 * time.Minute
+)
+
+// returns "" if the request is signed with secret
+func verifyWebhookSignature(secret string, h http.Header, body []byte, now time.Time) string {
+	id, ts, sigs := h.Get("webhook-id"), h.Get("webhook-timestamp"), h.Get("webhook-signature")
+	if id == "" || ts == "" || sigs == "" {
+		return "missing webhook-id, webhook-timestamp or webhook-signature header"
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "invalid webhook-timestamp " + ts
+	}
+	age := now.Sub(time.Unix(secs, 0))
+	if age > transWebhookMaxAge || age < -transWebhookMaxAge {
+		return "webhook-timestamp too old or in the future"
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return "invalid WEBLATE_WEBHOOK_SECRET"
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + ts + "."))
+	mac.Write(body)
+	expected := "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	// there can be many space-separated signatures
+	for _, sig := range strings.Fields(sigs) {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return ""
+		}
+	}
+	return "signature doesn't match"
+}
+
+// -trans-webhook
+func transWebhookServerMust() {
+	panicIf(weblateWebhookSecret == "", "need WEBLATE_WEBHOOK_SECRET env variable")
+	_, ok := getTransServiceMust().(*weblateService)
+	panicIf(!ok, "-trans-webhook needs TRANS_SERVICE=weblate")
+	var mu sync.Mutex
+	pullPending := false
+	// only one pull at a time
+	var pullMu sync.Mutex
+	pull := func() {
+		time.Sleep(transWebhookDelay)
+		mu.Lock()
+		pullPending = false
+		mu.Unlock()
+		pullMu.Lock()
+		defer pullMu.Unlock()
+		defer func() {
+			// keep serving if pull fails e.g. because of network error
+			if r := recover(); r != nil {
+				logf("pulling translations failed: %v\n", r)
+			}
+		}()
+		logf("pulling translations\n")
+		downloadTranslations()
+	}
+	http.HandleFunc("/weblate", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil || r.Method != http.MethodPost {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if reason := verifyWebhookSignature(weblateWebhookSecret, r.Header, body, time.Now()); reason != "" {
+			logf("rejected webhook from %s: %s\n", r.RemoteAddr, reason)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		start := !pullPending
+		pullPending = true
+		mu.Unlock()
+		if start {
+			logf("translations changed, pulling in %s\n", transWebhookDelay)
+			go pull()
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	logf("waiting for weblate webhooks on http://%s/weblate\n", transWebhookAddr)
+	must(http.ListenAndServe(transWebhookAddr, nil))
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Weblate backend of TransService, used when TRANS_SERVICE=weblate.
+// Strings and translations are exchanged as .po files (see trans_po.go)
+// with Weblate REST API (https://docs.weblate.org/en/latest/api.html).
+//
+// Needs WEBLATE_URL (e.g. https://hosted.weblate.org) and WEBLATE_TOKEN.
+// Translations are in project weblateProject, component weblateComponent,
+// which must use bilingual gettext PO files with English as source language.
+// Comments for translators are sent in .pot, screenshots are uploaded with
+// screenshots API and assigned to strings.
+// For webhook-triggered pulls see trans_webhook.go.
+
+var (
+	weblateURL   string
+	weblateToken string
+)
+
+const (
+	weblateProject   = "sumatrapdf"
+	weblateComponent = "sumatrapdf"
+)
+
+// our language code => Weblate's, if different from ISO code
+var weblateLangCodes = map[string]string{
+	"cn":    "zh_Hans",
+	"tw":    "zh_Hant",
+	"sp-rs": "sr_Latn",
+}
+
+type weblateService struct {
+	url   string
+	token string
+	// .pot with comments was uploaded by UpdateStrings
+	sourceUploaded bool
+}
+
+func newWeblateServiceMust() *weblateService {
+	panicIf(weblateURL == "" || weblateToken == "", "need WEBLATE_URL and WEBLATE_TOKEN env variables for TRANS_SERVICE=weblate")
+	return &weblateService{
+		url:   strings.TrimSuffix(weblateURL, "/"),
+		token: weblateToken,
+	}
+}
+
+func weblateLangCode(lang string) string {
+	if code, ok := weblateLangCodes[lang]; ok {
+		return code
+	}
+	return strings.ReplaceAll(getLangISOCode(lang), "-", "_")
+}
+
+func (s *weblateService) Name() string {
+	return s.url
+}
+
+func (s *weblateService) LangURL(lang string) string {
+	return fmt.Sprintf("%s/translate/%s/%s/%s/", s.url, weblateProject, weblateComponent, weblateLangCode(lang))
+}
+
+func (s *weblateService) fileURL(lang string) string {
+	return fmt.Sprintf("%s/api/translations/%s/%s/%s/file/", s.url, weblateProject, weblateComponent, weblateLangCode(lang))
+}
+
+// returns nil if it doesn't exist
+func (s *weblateService) doMust(req *http.Request) []byte {
+	req.Header.Set("Authorization", "Token "+s.token)
+	client := &http.Client{Timeout: 5 * time.Minute}
+	rsp, err := client.Do(req)
+	must(err)
+	defer rsp.Body.Close()
+	d, err := io.ReadAll(rsp.Body)
+	must(err)
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	// creating e.g. a screenshot returns 201 Created
+	panicIf(rsp.StatusCode < 200 || rsp.StatusCode > 299, "%s %s failed with status code %d, body: '%s'", req.Method, req.URL, rsp.StatusCode, string(d))
+	return d
+}
+
+// returns nil if there's no translation to lang
+func (s *weblateService) downloadPoMust(lang string) *PoFile {
+	uri := s.fileURL(lang)
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	must(err)
+	d := s.doMust(req)
+	if d == nil {
+		return nil
+	}
+	return parsePoMust(uri, string(d))
+}
+
+// method is one of Weblate's upload methods e.g. "translate", "fuzzy", "source"
+func (s *weblateService) uploadPoMust(lang string, po string, method string) {
+	if dryRunSkip("upload %s.po to %s with method %s", lang, s.url, method) {
+		return
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	must(w.WriteField("method", method))
+	// we only upload new or changed translations
+	must(w.WriteField("conflicts", "replace-translated"))
+	fw, err := w.CreateFormFile("file", lang+".po")
+	must(err)
+	_, err = fw.Write([]byte(po))
+	must(err)
+	must(w.Close())
+	req, err := http.NewRequest(http.MethodPost, s.fileURL(lang), &body)
+	must(err)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	d := s.doMust(req)
+	panicIf(d == nil, "%s doesn't exist on %s", s.fileURL(lang), s.url)
+}
+
+func (s *weblateService) GetStrings() []string {
+	po := s.downloadPoMust("en")
+	panicIf(po == nil, "component %s/%s doesn't exist on %s", weblateProject, weblateComponent, s.url)
+	var res []string
+	for _, e := range po.Entries {
+		res = append(res, e.MsgID)
+	}
+	return res
+}
+
+// with locations and comments from translations/strings.txt
+func toTransStrings(strs []string) []*TransString {
+	byText := map[string]*TransString{}
+	for _, s := range readTransStringsMust() {
+		byText[s.Text] = s
+	}
+	var res []*TransString
+	for _, s := range strs {
+		ts := byText[s]
+		if ts == nil {
+			ts = &TransString{Text: s}
+		}
+		res = append(res, ts)
+	}
+	return res
+}
+
+func (s *weblateService) UpdateStrings(strs []string, diff *TransStringsDiff) {
+	// weblate merges uploaded .pot like msgmerge
+	s.uploadPoMust("en", genPo("", toTransStrings(strs), nil, nil), "source")
+	s.sourceUploaded = true
+}
+
+func (s *weblateService) getJSONMust(uri string, v interface{}) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	must(err)
+	d := s.doMust(req)
+	panicIf(d == nil, "%s doesn't exist", uri)
+	must(json.Unmarshal(d, v))
+}
+
+// source string => id of its unit
+func (s *weblateService) getSourceUnitIDsMust() map[string]int {
+	res := map[string]int{}
+	uri := fmt.Sprintf("%s/api/translations/%s/%s/en/units/", s.url, weblateProject, weblateComponent)
+	for uri != "" {
+		var rsp struct {
+			Next    string `json:"next"`
+			Results []struct {
+				ID     int      `json:"id"`
+				Source []string `json:"source"`
+			} `json:"results"`
+		}
+		s.getJSONMust(uri, &rsp)
+		for _, u := range rsp.Results {
+			if len(u.Source) > 0 {
+				res[u.Source[0]] = u.ID
+			}
+		}
+		uri = rsp.Next
+	}
+	return res
+}
+
+type weblateScreenshot struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// urls of units e.g. https://hosted.weblate.org/api/units/123/
+	Units []string `json:"units"`
+}
+
+func (ws *weblateScreenshot) hasUnit(id int) bool {
+	suffix := fmt.Sprintf("/units/%d/", id)
+	for _, u := range ws.Units {
+		if strings.HasSuffix(u, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *weblateService) getScreenshotsMust() map[string]*weblateScreenshot {
+	res := map[string]*weblateScreenshot{}
+	uri := fmt.Sprintf("%s/api/components/%s/%s/screenshots/", s.url, weblateProject, weblateComponent)
+	for uri != "" {
+		var rsp struct {
+			Next    string               `json:"next"`
+			Results []*weblateScreenshot `json:"results"`
+		}
+		s.getJSONMust(uri, &rsp)
+		for _, ws := range rsp.Results {
+			res[ws.Name] = ws
+		}
+		uri = rsp.Next
+	}
+	return res
+}
+
+func (s *weblateService) postFormMust(uri string, fields map[string]string, filePath string) []byte {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range fields {
+		must(w.WriteField(k, v))
+	}
+	if filePath != "" {
+		fw, err := w.CreateFormFile("image", filepath.Base(filePath))
+		must(err)
+		_, err = fw.Write(readFileMust(filePath))
+		must(err)
+	}
+	must(w.Close())
+	req, err := http.NewRequest(http.MethodPost, uri, &body)
+	must(err)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	d := s.doMust(req)
+	panicIf(d == nil, "%s doesn't exist", uri)
+	return d
+}
+
+// comments are in .pot, screenshots are uploaded once (by name) and
+// assigned to units of strings that refer to them
+func (s *weblateService) UpdateContext(strs []*TransString) {
+	if !s.sourceUploaded {
+		s.uploadPoMust("en", genPo("", strs, nil, nil), "source")
+	}
+	var withScreenshot []*TransString
+	for _, ts := range strs {
+		if ts.Screenshot != "" {
+			withScreenshot = append(withScreenshot, ts)
+		}
+	}
+	if len(withScreenshot) == 0 {
+		return
+	}
+	unitIDs := s.getSourceUnitIDsMust()
+	screenshots := s.getScreenshotsMust()
+	nAssigned := 0
+	for _, ts := range withScreenshot {
+		unitID, ok := unitIDs[ts.Text]
+		if !ok {
+			logf("'%s' is not on %s, not assigning screenshot '%s'\n", ts.Text, s.url, ts.Screenshot)
+			continue
+		}
+		ws := screenshots[ts.Screenshot]
+		if ws == nil {
+			path := filepath.Join(transScreenshotsDir, ts.Screenshot)
+			if dryRunSkip("upload screenshot '%s' to %s", path, s.url) {
+				continue
+			}
+			fields := map[string]string{
+				"name":           ts.Screenshot,
+				"project_slug":   weblateProject,
+				"component_slug": weblateComponent,
+				"language_code":  "en",
+			}
+			d := s.postFormMust(s.url+"/api/screenshots/", fields, path)
+			ws = &weblateScreenshot{}
+			must(json.Unmarshal(d, ws))
+			screenshots[ts.Screenshot] = ws
+			logf("uploaded screenshot '%s'\n", path)
+		}
+		if ws.hasUnit(unitID) {
+			continue
+		}
+		if dryRunSkip("assign screenshot '%s' to '%s'", ts.Screenshot, ts.Text) {
+			continue
+		}
+		uri := fmt.Sprintf("%s/api/screenshots/%d/units/", s.url, ws.ID)
+		s.postFormMust(uri, map[string]string{"unit_id": strconv.Itoa(unitID)}, "")
+		ws.Units = append(ws.Units, fmt.Sprintf("%s/api/units/%d/", s.url, unitID))
+		nAssigned++
+	}
+	logf("assigned screenshots to %d strings on %s\n", nAssigned, s.url)
+}
+
+func (s *weblateService) DownloadTranslations(strs []string) []byte {
+	m := map[string][]*Translation{}
+	for _, str := range strs {
+		m[str] = nil
+	}
+	for _, l := range gLangs {
+		lang := l[0]
+		if lang == "en" {
+			continue
+		}
+		po := s.downloadPoMust(lang)
+		if po == nil {
+			continue
+		}
+		for _, e := range po.Entries {
+			a, ok := m[e.MsgID]
+			if !ok || e.Fuzzy || e.MsgStr == "" {
+				continue
+			}
+			m[e.MsgID] = append(a, &Translation{Text: e.MsgID, Lang: lang, Translation: e.MsgStr})
+		}
+	}
+	// apptranslator.org puts sha1 of translations in 2nd line
+	body := serializeTranslations(nil, m)
+	header := []string{"AppTranslator: SumatraPDF", fmt.Sprintf("%x", sha1.Sum([]byte(body)))}
+	return []byte(serializeTranslations(header, m))
+}
+
+func (s *weblateService) UploadTranslations(trs []*Translation, fuzzy bool) {
+	byLang := map[string]map[string]string{}
+	for _, tr := range trs {
+		if byLang[tr.Lang] == nil {
+			byLang[tr.Lang] = map[string]string{}
+		}
+		byLang[tr.Lang][tr.Text] = tr.Translation
+	}
+	var langs []string
+	for lang := range byLang {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		var strs []string
+		for s := range byLang[lang] {
+			strs = append(strs, s)
+		}
+		sort.Strings(strs)
+		po := genPo(lang, toTransStrings(strs), byLang[lang], nil)
+		method := "translate"
+		if fuzzy {
+			// "needs editing" in weblate
+			method = "fuzzy"
+		}
+		s.uploadPoMust(lang, po, method)
+		logf("uploaded %d translations to %s\n", len(strs), s.LangURL(lang))
+	}
+}
@@ -9,9 +9,9 @@ import (
 )
 
 func verifyTranslationsMust() {
-	d := downloadTranslationsMust()
+	d := getTransServiceMust().DownloadTranslations(extractStringsFromCFilesNoPaths())
 	curr := readFileMust(translationsTxtPath)
-	panicIf(!bytes.Equal(d, curr), "Translations did change!!!\nRun:\n.\\doit.bat -trans-dl\nto update translations\n")
+	panicIf(!bytes.Equal(d, curr), "Translations did change!!!\nRun:\n.\\doit.bat -trans-download\nto update translations\n")
 }
 
 // Translation describes a single translated text
@@ -50,7 +50,7 @@ func parseTranslations(s string) map[string][]*Translation {
 		// skip those lines as harmless
 		if l[0] == ':' {
 			if currStr != "" {
-				panicIf(len(currTranslations) == 0)
+				// can have no translations
 				res[currStr] = currTranslations
 			}
 			currStr = l[1:]
@@ -69,7 +69,6 @@ func parseTranslations(s string) map[string][]*Translation {
 	}
 
 	if currStr != "" {
-		panicIf(len(currTranslations) == 0)
 		res[currStr] = currTranslations
 	}
 
@@ -114,32 +113,11 @@ func extractTranslations(s string) []string {
 	return res
 }
 
-func extractStringsFromCFile(path string) []string {
-	d := readFileMust(path)
-	return extractTranslations(string(d))
-}
-
-func uniquifyStrings(a []string) []string {
-	m := map[string]bool{}
-	for _, s := range a {
-		m[s] = true
-	}
-	var res []string
-	for k := range m {
-		res = append(res, k)
-	}
-	return res
-}
-
 func extractStringsFromCFilesNoPaths() []string {
-	filesToProcess := getFilesToProcess()
-	logf("Files to process: %d\n", len(filesToProcess))
 	var res []string
-	for _, path := range filesToProcess {
-		a := extractStringsFromCFile(path)
-		res = append(res, a...)
+	for _, s := range extractTransStringsMust() {
+		res = append(res, s.Text)
 	}
-	res = uniquifyStrings(res)
 	logf("%d strings to translate\n", len(res))
 	return res
 }
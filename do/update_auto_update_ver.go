@@ -5,8 +5,6 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-
-	"github.com/kjk/minioutil"
 )
 
 // Format of auto-update file:
@@ -42,34 +40,32 @@ func validateVer(ver string) {
 	}
 }
 
+// sumatrapdf/sumpdf-update.txt and sumatrapdf/sumpdf-latest.txt
+func genAutoUpdateTxt(ver string) string {
+	return fmt.Sprintf(`[SumatraPDF]
+Latest %s
+`, ver)
+}
+
+func uploadAutoUpdateVerMust(storage StorageBackend, ver string) {
+	d := []byte(genAutoUpdateTxt(ver))
+	for _, remotePath := range []string{"sumatrapdf/sumpdf-update.txt", "sumatrapdf/sumpdf-latest.txt"} {
+		err := storage.UploadData(remotePath, d)
+		must(err)
+	}
+}
+
 func updateAutoUpdateVer(ver string) {
 	validateVer(ver)
 	// TODO: verify it's bigger than the current version
 	// TODO: add download links
-	s := fmt.Sprintf(`[SumatraPDF]
-Latest %s
-`, ver)
+	s := genAutoUpdateTxt(ver)
 	fmt.Printf("Content of update file:\n%s\n\n", s)
-	d := []byte(s)
-
-	uploadInfo := func(mc *minioutil.Client) {
-		{
-			remotePath := "sumatrapdf/sumpdf-update.txt"
-			_, err := mc.UploadData(remotePath, d, true)
-			must(err)
-		}
-		{
-			remotePath := "sumatrapdf/sumpdf-latest.txt"
-			_, err := mc.UploadData(remotePath, d, true)
-			must(err)
-		}
-	}
 
 	// TODO: anyone using those for update info?
-	//uploadInfo(newMinioSpacesClient())
-	//uploadInfo(newMinioS3Client())
-	uploadInfo(newMinioBackblazeClient())
-	uploadInfo(newMinioR2Client())
+	for _, storage := range getStorageBackends() {
+		uploadAutoUpdateVerMust(storage, ver)
+	}
 
 	path := filepath.Join("website", "update-check-rel.txt")
 	writeFileMust(path, []byte(s))
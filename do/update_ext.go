@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Helps upgrading libraries vendored in ext/:
+//   - -ext-check : shows current version (from ext/versions.txt) and latest
+//     upstream version (from git tags) of each library
+//   - -ext-update <lib>[@tag] : replaces ext/${lib} with upstream sources at
+//     the tag (latest if not given) with ext/_patches/${lib}*.patch applied,
+//     updates ext/versions.txt and reports size and warnings deltas of 64-bit
+//     release build. If a patch doesn't apply we stop before changing
+//     ext/${lib}. Doesn't commit, review with git diff -- ext
+//
+// Patch files are created with e.g.:
+//   git diff -- ext/zlib > ext/_patches/zlib.patch
+
+// ExtLib describes a library vendored in ext/
+type ExtLib struct {
+	// name in ext/versions.txt
+	Name string
+	// directory in ext/
+	Dir  string
+	Repo string
+	// matches release tags, sub-matches are joined with "." to form a version
+	TagRx *regexp.Regexp
+	// directories in upstream repo we don't copy
+	SkipDirs []string
+}
+
+var extLibs = []*ExtLib{
+	{Name: "bzip2", Dir: "bzip2", Repo: "https://gitlab.com/bzip2/bzip2.git", TagRx: regexp.MustCompile(`^bzip2-(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "freetype", Dir: "freetype", Repo: "https://gitlab.freedesktop.org/freetype/freetype.git", TagRx: regexp.MustCompile(`^VER-(\d+)-(\d+)-(\d+)$`), SkipDirs: []string{"subprojects"}},
+	{Name: "gumbo", Dir: "gumbo-parser", Repo: "https://github.com/google/gumbo-parser.git", TagRx: regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "harfbuzz", Dir: "harfbuzz", Repo: "https://github.com/harfbuzz/harfbuzz.git", TagRx: regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "jbig2dec", Dir: "jbig2dec", Repo: "https://github.com/ArtifexSoftware/jbig2dec.git", TagRx: regexp.MustCompile(`^(\d+)\.(\d+)$`)},
+	{Name: "libjpeg-turbo", Dir: "libjpeg-turbo", Repo: "https://github.com/libjpeg-turbo/libjpeg-turbo.git", TagRx: regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "libwebp", Dir: "libwebp", Repo: "https://github.com/webmproject/libwebp.git", TagRx: regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "lcms2", Dir: "lcms2", Repo: "https://github.com/mm2/Little-CMS.git", TagRx: regexp.MustCompile(`^lcms(\d+)\.(\d+)(?:\.(\d+))?$`)},
+	{Name: "mujs", Dir: "mujs", Repo: "https://github.com/ArtifexSoftware/mujs.git", TagRx: regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "openjpeg", Dir: "openjpeg", Repo: "https://github.com/uclouvain/openjpeg.git", TagRx: regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "unarr", Dir: "unarr", Repo: "https://github.com/selmf/unarr.git", TagRx: regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "zlib", Dir: "zlib", Repo: "https://github.com/madler/zlib.git", TagRx: regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)(?:\.(\d+))?$`)},
+	{Name: "zlib-ng", Dir: "zlib-ng", Repo: "https://github.com/zlib-ng/zlib-ng.git", TagRx: regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "dav1d", Dir: "dav1d", Repo: "https://code.videolan.org/videolan/dav1d.git", TagRx: regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)},
+	{Name: "libheif", Dir: "libheif", Repo: "https://github.com/strukturag/libheif.git", TagRx: regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)$`)},
+}
+
+var extVersionsPath = filepath.Join("ext", "versions.txt")
+
+func findExtLibMust(name string) *ExtLib {
+	for _, lib := range extLibs {
+		if strings.EqualFold(lib.Name, name) || strings.EqualFold(lib.Dir, name) {
+			return lib
+		}
+	}
+	var names []string
+	for _, lib := range extLibs {
+		names = append(names, lib.Name)
+	}
+	panicIf(true, "unknown library '%s', must be one of: %s", name, strings.Join(names, ", "))
+	return nil
+}
+
+// returns version from tag or "" if tag is not a release tag
+func (lib *ExtLib) versionFromTag(tag string) string {
+	m := lib.TagRx.FindStringSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	var parts []string
+	for _, s := range m[1:] {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// returns <0 if ver1 < ver2, 0 if equal, >0 if ver1 > ver2
+// non-numeric versions like "trunk" are smaller than any numeric version
+func compareVersions(ver1, ver2 string) int {
+	parts1 := strings.Split(ver1, ".")
+	parts2 := strings.Split(ver2, ".")
+	for i := 0; i < len(parts1) || i < len(parts2); i++ {
+		n1, n2 := -1, -1
+		if i < len(parts1) {
+			if n, err := strconv.Atoi(parts1[i]); err == nil {
+				n1 = n
+			}
+		}
+		if i < len(parts2) {
+			if n, err := strconv.Atoi(parts2[i]); err == nil {
+				n2 = n
+			}
+		}
+		if n1 != n2 {
+			return n1 - n2
+		}
+	}
+	return 0
+}
+
+// returns latest release tag and its version
+func getLatestUpstreamTag(lib *ExtLib) (string, string, error) {
+	out, err := exec.Command("git", "ls-remote", "--tags", "--refs", lib.Repo).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("git ls-remote '%s' failed with '%s'", lib.Repo, err)
+	}
+	var latestTag, latestVer string
+	for _, l := range toTrimmedLines(out) {
+		// ${sha1}\trefs/tags/${tag}
+		idx := strings.Index(l, "refs/tags/")
+		if idx < 0 {
+			continue
+		}
+		tag := l[idx+len("refs/tags/"):]
+		ver := lib.versionFromTag(tag)
+		if ver == "" {
+			continue
+		}
+		if latestVer == "" || compareVersions(ver, latestVer) > 0 {
+			latestTag, latestVer = tag, ver
+		}
+	}
+	if latestTag == "" {
+		return "", "", fmt.Errorf("didn't find release tags in '%s'", lib.Repo)
+	}
+	return latestTag, latestVer, nil
+}
+
+func getLatestUpstreamTagMust(lib *ExtLib) (string, string) {
+	tag, ver, err := getLatestUpstreamTag(lib)
+	must(err)
+	return tag, ver
+}
+
+// ExtVersion is an entry in ext/versions.txt
+type ExtVersion struct {
+	Name    string
+	Version string
+	// "" if not known
+	Date string
+	URLs []string
+}
+
+func parseExtVersionsMust() []*ExtVersion {
+	var res []*ExtVersion
+	lines, err := readLinesFromFile(extVersionsPath)
+	must(err)
+	var last *ExtVersion
+	for _, l := range lines[2:] {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		// lines with urls are indented
+		if l[0] == ' ' {
+			if last != nil && strings.HasPrefix(strings.TrimSpace(l), "http") {
+				last.URLs = append(last.URLs, strings.TrimSpace(l))
+			}
+			continue
+		}
+		parts := strings.Fields(l)
+		if len(parts) < 2 {
+			last = nil
+			continue
+		}
+		last = &ExtVersion{Name: parts[0], Version: parts[1]}
+		if len(parts) > 2 {
+			last.Date = strings.Join(parts[2:], " ")
+		}
+		res = append(res, last)
+	}
+	return res
+}
+
+// returns lower-cased name => version from ext/versions.txt
+func readExtVersionsMust() map[string]string {
+	res := map[string]string{}
+	for _, v := range parseExtVersionsMust() {
+		res[strings.ToLower(v.Name)] = v.Version
+	}
+	return res
+}
+
+func updateExtVersionMust(lib *ExtLib, ver string, tag string) {
+	d := readFileMust(extVersionsPath)
+	lines := strings.Split(string(d), "\n")
+	found := false
+	for i, l := range lines {
+		parts := strings.Fields(l)
+		if len(parts) == 0 || l[0] == ' ' || !strings.EqualFold(parts[0], lib.Name) {
+			continue
+		}
+		date := time.Now().Format("2006-01-02")
+		lines[i] = fmt.Sprintf("%-15s %-10s %s", parts[0], ver, date)
+		// replace the urls with the one for the tag
+		j := i + 1
+		for j < len(lines) && strings.HasPrefix(lines[j], " ") {
+			j++
+		}
+		url := "  " + strings.TrimSuffix(lib.Repo, ".git") + "/tree/" + tag
+		lines = append(lines[:i+1], append([]string{url}, lines[j:]...)...)
+		found = true
+		break
+	}
+	panicIf(!found, "didn't find '%s' in '%s'", lib.Name, extVersionsPath)
+	writeFileMust(extVersionsPath, []byte(strings.Join(lines, "\n")))
+}
+
+func extCheck() {
+	vers := readExtVersionsMust()
+	logf("%-15s %-12s %-12s\n", "library", "current", "latest")
+	nOutdated := 0
+	for _, lib := range extLibs {
+		curr := vers[strings.ToLower(lib.Name)]
+		if curr == "" {
+			curr = "?"
+		}
+		tag, latest, err := getLatestUpstreamTag(lib)
+		if err != nil {
+			logf("%-15s %-12s %-12s %s\n", lib.Name, curr, "?", err)
+			continue
+		}
+		status := ""
+		if compareVersions(curr, latest) < 0 {
+			status = fmt.Sprintf("update available: -ext-update %s@%s", lib.Name, tag)
+			nOutdated++
+		}
+		logf("%-15s %-12s %-12s %s\n", lib.Name, curr, latest, status)
+	}
+	logf("%d out of %d libraries have newer versions\n", nOutdated, len(extLibs))
+}
+
+func cloneAtTagMust(repo, tag, dir string) {
+	must(os.RemoveAll(dir))
+	createDirMust(filepath.Dir(dir))
+	// we want upstream line endings, also for patches to apply
+	cmd := exec.Command("git", "clone", "-c", "core.autocrlf=false", "--depth", "1", "--branch", tag, repo, dir)
+	runCmdLoggedMust(cmd)
+}
+
+// ExtBuildStats are sizes of binaries and number of warnings of 64-bit release build
+type ExtBuildStats struct {
+	Sizes    map[string]int64
+	Warnings int
+}
+
+var extStatsFiles = []string{"SumatraPDF.exe", "SumatraPDF-dll.exe", "libmupdf.dll"}
+
+func buildForExtStatsMust() *ExtBuildStats {
+	msbuildPath := detectMsbuildPath()
+	slnPath := filepath.Join("vs2022", "SumatraPDF.sln")
+	platform := kPlatformIntel64
+	p := fmt.Sprintf(`/p:Configuration=%s;Platform=%s`, "Release", platform)
+	warnLog := getMsbuildWarningsLogArg(platform)
+	runExeLoggedMust(msbuildPath, slnPath, `/t:SumatraPDF:Rebuild;SumatraPDF-dll:Rebuild`, p, `/m`, warnLog)
+
+	res := &ExtBuildStats{
+		Sizes: map[string]int64{},
+	}
+	for _, name := range extStatsFiles {
+		res.Sizes[name] = fileSizeMust(filepath.Join(rel64Dir, name))
+	}
+	lines, _ := readLinesFromFile(getMsbuildWarningsLogPath(platform))
+	seen := map[string]bool{}
+	for _, l := range lines {
+		if strings.Contains(l, ": warning ") && !seen[l] {
+			seen[l] = true
+			res.Warnings++
+		}
+	}
+	return res
+}
+
+func printExtBuildStatsDelta(before, after *ExtBuildStats) {
+	logf("%-20s %12s %12s %12s\n", "file", "before", "after", "delta")
+	for _, name := range extStatsFiles {
+		b, a := before.Sizes[name], after.Sizes[name]
+		logf("%-20s %12s %12s %+12d\n", name, formatSize(b), formatSize(a), a-b)
+	}
+	logf("%-20s %12d %12d %+12d\n", "warnings", before.Warnings, after.Warnings, after.Warnings-before.Warnings)
+}
+
+// arg is ${lib} or ${lib}@${tag}
+func extUpdate(arg string) {
+	defer makePrintDuration("extUpdate")()
+	name, tag, _ := strings.Cut(arg, "@")
+	lib := findExtLibMust(name)
+	panicIf(!isGitClean("."), "git has unsaved changes, commit or stash them before updating '%s'", lib.Name)
+
+	ver := ""
+	if tag == "" {
+		tag, ver = getLatestUpstreamTagMust(lib)
+	} else {
+		ver = lib.versionFromTag(tag)
+		if ver == "" {
+			ver = tag
+		}
+	}
+	currVer := readExtVersionsMust()[strings.ToLower(lib.Name)]
+	logf("updating '%s' from %s to %s (tag '%s')\n", lib.Name, currVer, ver, tag)
+
+	before := buildForExtStatsMust()
+
+	upstreamDir := filepath.Join("out", "ext-upstream", lib.Dir+"-"+tag)
+	cloneAtTagMust(lib.Repo, tag, upstreamDir)
+	patches, err := filepath.Glob(filepath.Join(patchesDir, lib.Dir+"*.patch"))
+	must(err)
+	sort.Strings(patches)
+	dir := filepath.Join("ext", lib.Dir)
+	failedPatches := applyPatches(upstreamDir, filepath.ToSlash(dir), patches)
+	panicIf(len(failedPatches) > 0, "patches %s don't apply to '%s' %s, update them. Upstream sources with the patches that did apply are in '%s'", strings.Join(failedPatches, ", "), lib.Name, tag, upstreamDir)
+	skipDirs := append([]string{".git", ".github"}, lib.SkipDirs...)
+	replaceDirContentMust(dir, upstreamDir, skipDirs)
+	must(os.RemoveAll(upstreamDir))
+	updateExtVersionMust(lib, ver, tag)
+
+	after := buildForExtStatsMust()
+
+	logf("\nupdated '%s' from %s to %s\n", lib.Name, currVer, ver)
+	printExtBuildStatsDelta(before, after)
+	if len(patches) > 0 {
+		logf("\napplied patches: %s\n", strings.Join(patches, ", "))
+	}
+	logf("\nnew or removed source files might need updating premake5.files.lua\n")
+	logf("review changes with: git diff --stat -- ext/%s\n", lib.Dir)
+}
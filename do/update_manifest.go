@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// update-check.json is a signed manifest with latest stable and pre-release
+// versions that the in-app updater downloads. It describes, for every
+// channel, the files (urls, sizes, sha256 hashes) and the minimum OS version
+// they run on.
+//
+// update-check.json.sig has base64-encoded ed25519 signature of the exact
+// bytes of update-check.json. It's made with a dedicated key (UPDATE_SIGNING_KEY,
+// not the code signing certificate) whose public key is embedded in the app,
+// so the updater doesn't have to trust the transport (or our storage).
+//
+// Pre-release section is updated when uploading pre-release builds. Release
+// builds are published manually so stable section is updated with
+// -upload-update-manifest after the release is announced.
+//
+// Create the key with -gen-update-signing-key.
+
+const (
+	updateManifestName       = "update-check.json"
+	updateManifestSigName    = updateManifestName + ".sig"
+	updateManifestRemotePath = "software/sumatrapdf/" + updateManifestName
+)
+
+// base64-encoded 32 byte ed25519 seed
+var updateSigningKey string
+
+// UpdateFile is a file the updater can download
+type UpdateFile struct {
+	Kind   string `json:"kind"`
+	Arch   string `json:"arch"`
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// e.g. "6.1" for Windows 7
+	MinOsVersion string `json:"minOsVersion"`
+}
+
+// UpdateChannel describes the latest build of a given type
+type UpdateChannel struct {
+	Version string `json:"version"`
+	GitSha1 string `json:"gitSha1"`
+	BuiltOn string `json:"builtOn"`
+	// url of delta-updates.json, if the build has delta updates
+	DeltaUpdatesURL string        `json:"deltaUpdatesUrl,omitempty"`
+	Files           []*UpdateFile `json:"files"`
+}
+
+// UpdateManifest is content of update-check.json
+type UpdateManifest struct {
+	UpdatedAt  string         `json:"updatedAt"`
+	Stable     *UpdateChannel `json:"stable,omitempty"`
+	PreRelease *UpdateChannel `json:"prerelease,omitempty"`
+}
+
+// arm64 builds require Windows 10
+func getMinOsVersionForArch(arch string) string {
+	if arch == "arm64" {
+		return "10.0"
+	}
+	return fmt.Sprintf("%d.%d", minOsMajorVersion, minOsMinorVersion)
+}
+
+func genUpdateChannel(am *ArtifactsManifest) *UpdateChannel {
+	prefix := getDownloadPrefixViaWebsite(am.BuildType, am.Version)
+	res := &UpdateChannel{
+		Version: am.Version,
+		GitSha1: am.GitSha1,
+		BuiltOn: am.BuiltOn,
+	}
+	for _, a := range am.Artifacts {
+		switch a.Kind {
+		case kArtifactInstaller, kArtifactPortableExe, kArtifactPortableZip:
+			f := &UpdateFile{
+				Kind:         a.Kind,
+				Arch:         a.Arch,
+				URL:          prefix + a.Name,
+				Size:         a.Size,
+				Sha256:       a.Sha256,
+				MinOsVersion: getMinOsVersionForArch(a.Arch),
+			}
+			res.Files = append(res.Files, f)
+		case kArtifactDeltaIndex:
+			res.DeltaUpdatesURL = prefix + a.Name
+		}
+	}
+	panicIf(len(res.Files) == 0, "no files for update manifest in %s", artifactsManifestName)
+	return res
+}
+
+func getUpdateSigningKeyMust() ed25519.PrivateKey {
+	panicIf(updateSigningKey == "", "need UPDATE_SIGNING_KEY env variable to sign %s", updateManifestName)
+	seed, err := base64.StdEncoding.DecodeString(updateSigningKey)
+	must(err)
+	panicIf(len(seed) != ed25519.SeedSize, "UPDATE_SIGNING_KEY must be %d bytes, is %d", ed25519.SeedSize, len(seed))
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// returns base64-encoded signature
+func signUpdateManifestMust(d []byte) string {
+	key := getUpdateSigningKeyMust()
+	sig := ed25519.Sign(key, d)
+	pubKey := key.Public().(ed25519.PublicKey)
+	panicIf(!ed25519.Verify(pubKey, d, sig), "failed to verify signature of %s", updateManifestName)
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// we upload to several storages in parallel so each needs its own directory
+func getUpdateManifestDir(storage StorageBackend) string {
+	return filepath.Join("out", "update-manifest", storage.Name())
+}
+
+// returns nil if there's no manifest in storage yet
+func downloadUpdateManifest(storage StorageBackend) *UpdateManifest {
+	if !storage.Exists(updateManifestRemotePath) {
+		return nil
+	}
+	path := filepath.Join(getUpdateManifestDir(storage), updateManifestName+".prev")
+	must(storage.DownloadFileAtomically(path, updateManifestRemotePath))
+	var res UpdateManifest
+	must(json.Unmarshal(readFileMust(path), &res))
+	return &res
+}
+
+// updates section for buildType in update-check.json in storage with
+// the build in out/ and re-signs it
+func uploadUpdateManifestMust(storage StorageBackend, buildType BuildType) {
+	if updateSigningKey == "" {
+		logf("Not uploading %s because UPDATE_SIGNING_KEY env variable not set\n", updateManifestName)
+		return
+	}
+	am := readArtifactsManifestMust(getFinalDirForBuildType(buildType))
+	uploadUpdateChannelMust(storage, buildType, am)
+}
+
+// updates section for buildType in update-check.json in storage with
+// the build described by am and re-signs it
+func uploadUpdateChannelMust(storage StorageBackend, buildType BuildType, am *ArtifactsManifest) {
+	m := downloadUpdateManifest(storage)
+	if m == nil {
+		m = &UpdateManifest{}
+	}
+	ch := genUpdateChannel(am)
+	switch buildType {
+	case buildTypeRel:
+		m.Stable = ch
+	case buildTypePreRel:
+		m.PreRelease = ch
+	default:
+		panicIf(true, "invalid buildType '%s'", buildType)
+	}
+	m.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	d, err := json.MarshalIndent(m, "", "  ")
+	must(err)
+	sig := signUpdateManifestMust(d)
+	dir := getUpdateManifestDir(storage)
+	must(os.MkdirAll(dir, 0755))
+	writeFileMust(filepath.Join(dir, updateManifestName), d)
+	writeFileMust(filepath.Join(dir, updateManifestSigName), []byte(sig))
+
+	// signature is uploaded last, after the manifest it signs
+	must(storage.UploadData(updateManifestRemotePath, d))
+	must(storage.UploadData(updateManifestRemotePath+".sig", []byte(sig)))
+	logf("Uploaded `%s' (%s %s)\n", storage.URLForPath(updateManifestRemotePath), buildType, ch.Version)
+}
+
+// -upload-update-manifest [rel]
+func uploadUpdateManifestAllMust(buildType BuildType) {
+	ensureAllUploadCreds()
+	getUpdateSigningKeyMust()
+	for _, storage := range getStorageBackends() {
+		uploadUpdateManifestMust(storage, buildType)
+	}
+}
+
+// -gen-update-signing-key prints a new key. Private key goes to secrets
+// as UPDATE_SIGNING_KEY, public key is embedded in the app
+func genUpdateSigningKey() {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	must(err)
+	logf("UPDATE_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(privKey.Seed()))
+	logf("public key: %s\n", base64.StdEncoding.EncodeToString(pubKey))
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+// -update-mupdf <tag> updates vendored mupdf/ to a given upstream tag:
+//   - applies our local changes, tracked as ext/_patches/mupdf*.patch files,
+//     to upstream sources at the tag and replaces mupdf/ with the result.
+//     If a patch doesn't apply we stop without changing mupdf/
+//   - rebuilds and runs the rendering regression suite (tools/regress)
+//   - writes out/mupdf-update-${tag}.md with fz_* / pdf_* functions that
+//     were removed or changed and are used in src/ (they need manual attention)
+//     and opens it in notepad
+//
+// Before updating we check that mupdf/ is upstream sources at its version
+// with those patches applied. If not, we write the differences to a new
+// ext/_patches/mupdf-local-${date}.patch and stop. Patch files can also be
+// created with e.g.:
+//   git diff -- mupdf > ext/_patches/mupdf-description.patch
+
+const mupdfUpstreamRepo = "https://github.com/ArtifexSoftware/mupdf.git"
+
+var (
+	mupdfDir   = "mupdf"
+	patchesDir = filepath.Join("ext", "_patches")
+)
+
+// upstream has thirdparty/ as git submodules but we use our copies in ext/
+var mupdfSkipDirs = []string{".git", "thirdparty"}
+
+// matches declarations like:
+// fz_buffer *fz_new_buffer(fz_context *ctx, size_t capacity);
+var rxMupdfFuncDecl = regexp.MustCompile(`\b((?:fz|pdf)_[a-zA-Z0-9_]+)\s*\(`)
+
+// returns map of function name => normalized declaration for public
+// functions in mupdf/include
+func collectMupdfAPIMust(dir string) map[string]string {
+	res := map[string]string{}
+	includeDir := filepath.Join(dir, "include")
+	err := filepath.WalkDir(includeDir, func(path string, d fs.DirEntry, err error) error {
+		must(err)
+		if d.IsDir() || filepath.Ext(path) != ".h" {
+			return nil
+		}
+		// declarations can span multiple lines so we accumulate until ';'
+		var decl string
+		for _, l := range strings.Split(string(readFileMust(path)), "\n") {
+			l = strings.TrimSpace(l)
+			if decl == "" {
+				isCandidate := strings.Contains(l, "(") && !strings.HasPrefix(l, "#") && !strings.HasPrefix(l, "//") && !strings.HasPrefix(l, "*") && !strings.HasPrefix(l, "/*")
+				if !isCandidate {
+					continue
+				}
+			}
+			decl += " " + l
+			if strings.Contains(l, "{") || strings.HasSuffix(l, "*/") {
+				// inline functions, comments
+				decl = ""
+				continue
+			}
+			if !strings.HasSuffix(l, ";") {
+				continue
+			}
+			decl = strings.Join(strings.Fields(decl), " ")
+			m := rxMupdfFuncDecl.FindStringSubmatch(decl)
+			// skip function pointer typedefs and struct members
+			if m != nil && !strings.HasPrefix(decl, "typedef") && !strings.Contains(decl, "(*") {
+				res[m[1]] = decl
+			}
+			decl = ""
+		}
+		return nil
+	})
+	must(err)
+	return res
+}
+
+// MupdfAPIChanges lists differences in public API between 2 versions of mupdf
+type MupdfAPIChanges struct {
+	Added   []string
+	Removed []string
+	// name => [old decl, new decl]
+	Changed map[string][2]string
+}
+
+func diffMupdfAPI(prev, curr map[string]string) *MupdfAPIChanges {
+	res := &MupdfAPIChanges{
+		Changed: map[string][2]string{},
+	}
+	for name, decl := range prev {
+		currDecl, ok := curr[name]
+		if !ok {
+			res.Removed = append(res.Removed, name)
+			continue
+		}
+		if currDecl != decl {
+			res.Changed[name] = [2]string{decl, currDecl}
+		}
+	}
+	for name := range curr {
+		if _, ok := prev[name]; !ok {
+			res.Added = append(res.Added, name)
+		}
+	}
+	sort.Strings(res.Added)
+	sort.Strings(res.Removed)
+	return res
+}
+
+// returns function name => files in src/ that use it
+func findMupdfFuncsUsedInSrcMust(names []string) map[string][]string {
+	want := map[string]bool{}
+	for _, name := range names {
+		want[name] = true
+	}
+	res := map[string][]string{}
+	err := filepath.WalkDir("src", func(path string, d fs.DirEntry, err error) error {
+		must(err)
+		ext := strings.ToLower(filepath.Ext(path))
+		if d.IsDir() || (ext != ".cpp" && ext != ".h" && ext != ".c") {
+			return nil
+		}
+		seen := map[string]bool{}
+		d2 := readFileMust(path)
+		for _, m := range rxMupdfFuncDecl.FindAllSubmatch(d2, -1) {
+			name := string(m[1])
+			if !want[name] || seen[name] {
+				continue
+			}
+			seen[name] = true
+			res[name] = append(res[name], filepath.ToSlash(path))
+		}
+		return nil
+	})
+	must(err)
+	return res
+}
+
+func cloneMupdfAtTagMust(tag string) string {
+	dir := filepath.Join("out", "mupdf-upstream-"+tag)
+	cloneAtTagMust(mupdfUpstreamRepo, tag, dir)
+	return dir
+}
+
+// replaces content of dstDir with files from srcDir, except for skipDirs
+func replaceDirContentMust(dstDir, srcDir string, skipDirs []string) {
+	entries, err := os.ReadDir(dstDir)
+	must(err)
+	for _, e := range entries {
+		must(os.RemoveAll(filepath.Join(dstDir, e.Name())))
+	}
+	nFiles := 0
+	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		must(err)
+		rel, err := filepath.Rel(srcDir, path)
+		must(err)
+		if d.IsDir() {
+			if stringInSlice(skipDirs, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		dst := filepath.Join(dstDir, rel)
+		must(createDirForFile(dst))
+		copyFileMust(dst, path)
+		nFiles++
+		return nil
+	})
+	must(err)
+	logf("copied %d files from '%s' to '%s'\n", nFiles, srcDir, dstDir)
+}
+
+func getMupdfPatchesMust() []string {
+	res, err := filepath.Glob(filepath.Join(patchesDir, "mupdf*.patch"))
+	must(err)
+	sort.Strings(res)
+	return res
+}
+
+// "a/ext/zlib/zlib.h", "ext/zlib" => "zlib.h"
+// "CHMLib.orig\src\lzx.c", "ext/CHMLib" => "src/lzx.c"
+func rewritePatchPath(s string, repoDir string) string {
+	s = strings.ReplaceAll(s, `\`, "/")
+	if s == "/dev/null" {
+		return s
+	}
+	for _, prefix := range []string{"a/" + repoDir + "/", "b/" + repoDir + "/", repoDir + "/"} {
+		if rel, ok := strings.CutPrefix(s, prefix); ok {
+			return rel
+		}
+	}
+	// old style: "CHMLib.orig/src/lzx.c" or "CHMLib/src/lzx.c"
+	_, rel, _ := strings.Cut(s, "/")
+	return rel
+}
+
+// patches in ext/_patches are either created with git diff (paths relative
+// to repo root, like "a/ext/zlib/zlib.h") or are old ones created with
+// "diff -rPu5" (paths relative to ext/, with backslashes and CRLF line
+// endings). We rewrite them to be relative to library directory so that we
+// can apply them to upstream sources before they replace our copy.
+// repoDir is directory of the library in our repo e.g. "ext/zlib"
+func rewritePatchPaths(d []byte, repoDir string) []byte {
+	lines := strings.Split(normalizeNewlines(string(d)), "\n")
+	rewrite := func(l string, prefix string) string {
+		// there might be a time stamp after tab
+		path, _, _ := strings.Cut(l[len(prefix):], "\t")
+		path = rewritePatchPath(strings.TrimSpace(path), repoDir)
+		if path == "/dev/null" {
+			return prefix + path
+		}
+		if prefix == "--- " {
+			return prefix + "a/" + path
+		}
+		return prefix + "b/" + path
+	}
+	var res []string
+	for i, l := range lines {
+		if strings.HasPrefix(l, "diff ") {
+			// "diff -rPu5 ..." or "diff --git ..."; git apply doesn't need them
+			// and checks that names in "diff --git" match those in ---/+++
+			continue
+		}
+		// a removed line can also start with "--- "
+		isHeader := strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ")
+		if isHeader {
+			l = rewrite(l, "--- ")
+			lines[i+1] = rewrite(lines[i+1], "+++ ")
+		}
+		res = append(res, l)
+	}
+	return []byte(strings.Join(res, "\n"))
+}
+
+// applies patches in order to library sources in dir. repoDir is where the
+// library is in our repo e.g. "ext/zlib" or "mupdf". Returns patches that
+// didn't apply
+func applyPatches(dir string, repoDir string, patches []string) []string {
+	var failed []string
+	for _, path := range patches {
+		cmd := exec.Command("git", "apply", "--whitespace=nowarn", "-")
+		cmd.Dir = dir
+		cmd.Stdin = bytes.NewReader(rewritePatchPaths(readFileMust(path), repoDir))
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			logf("failed to apply '%s':\n%s\n", path, string(out))
+			failed = append(failed, path)
+			continue
+		}
+		logf("applied '%s'\n", path)
+	}
+	return failed
+}
+
+// upstream sources at ver with our patches applied must be the same as
+// mupdf/, otherwise changes made directly in mupdf/ would be lost by the
+// update. If they are not, we write those changes as a new patch and stop.
+// The patch should be reviewed and checked in before updating
+func verifyMupdfMatchesPatchesMust(ver string, patches []string) {
+	panicIf(ver == "unknown", "couldn't get mupdf version from '%s'", mupdfDir)
+	upstreamDir := cloneMupdfAtTagMust(ver)
+	failedPatches := applyPatches(upstreamDir, mupdfDir, patches)
+	panicIf(len(failedPatches) > 0, "patches %s don't apply to mupdf %s, which we have in '%s'. Fix them before updating", strings.Join(failedPatches, ", "), ver, mupdfDir)
+	for _, dir := range mupdfSkipDirs {
+		must(os.RemoveAll(filepath.Join(upstreamDir, dir)))
+	}
+	cmd := exec.Command("git", "diff", "--no-index", "--binary", "--no-color", upstreamDir, mupdfDir)
+	out, err := cmd.Output()
+	// exits with 1 if there are differences
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	must(err)
+	must(os.RemoveAll(upstreamDir))
+	if len(out) == 0 {
+		logf("'%s' is mupdf %s with %d patches from '%s'\n", mupdfDir, ver, len(patches), patchesDir)
+		return
+	}
+	// "a/out/mupdf-upstream-1.24.0/source/fitz/x.c" => "a/mupdf/source/fitz/x.c"
+	d := strings.ReplaceAll(string(out), filepath.ToSlash(upstreamDir)+"/", mupdfDir+"/")
+	path := filepath.Join(patchesDir, "mupdf-local-"+time.Now().Format("2006-01-02")+".patch")
+	writeFileMust(path, []byte(d))
+	panicIf(true, "'%s' has changes that are not in '%s'. Wrote them to '%s', review and check it in before updating mupdf", mupdfDir, patchesDir, path)
+}
+
+// runs tools/regress, returns error if any test failed
+func runRegressTests() error {
+	cmd := exec.Command("go", "run", "./tools/regress")
+	logf("> %s\n", cmd.String())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func genMupdfUpdateSummary(prevVer, tag string, changes *MupdfAPIChanges, patches []string, buildErr, regressErr error) string {
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	add("# mupdf update %s => %s", prevVer, tag)
+	add("")
+
+	add("## Patches")
+	add("")
+	if len(patches) == 0 {
+		add("No patches in `%s`.", filepath.ToSlash(patchesDir))
+	} else {
+		add("Applied:")
+		add("")
+		for _, p := range patches {
+			add("- `%s`", filepath.ToSlash(p))
+		}
+	}
+	add("")
+
+	add("## Build and regression tests")
+	add("")
+	fmtErr := func(err error) string {
+		if err == nil {
+			return "ok"
+		}
+		return "**failed**: " + err.Error()
+	}
+	add("- build: %s", fmtErr(buildErr))
+	add("- tools/regress: %s", fmtErr(regressErr))
+	add("")
+
+	var changed []string
+	for name := range changes.Changed {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	used := findMupdfFuncsUsedInSrcMust(append(append([]string{}, changes.Removed...), changed...))
+
+	add("## API changes needing manual attention")
+	add("")
+	nAttention := 0
+	for _, name := range changes.Removed {
+		files := used[name]
+		if len(files) == 0 {
+			continue
+		}
+		add("- removed `%s`, used in: %s", name, strings.Join(files, ", "))
+		nAttention++
+	}
+	for _, name := range changed {
+		files := used[name]
+		if len(files) == 0 {
+			continue
+		}
+		decls := changes.Changed[name]
+		add("- changed `%s`, used in: %s", name, strings.Join(files, ", "))
+		add("  - old: `%s`", decls[0])
+		add("  - new: `%s`", decls[1])
+		nAttention++
+	}
+	if nAttention == 0 {
+		add("None.")
+	}
+	add("")
+
+	add("## Other API changes")
+	add("")
+	add("- added: %d functions", len(changes.Added))
+	add("- removed: %d functions (not used by us)", len(changes.Removed)-countUsed(changes.Removed, used))
+	add("- changed: %d functions (not used by us)", len(changed)-countUsed(changed, used))
+	add("")
+	for _, name := range changes.Added {
+		add("- added `%s`", name)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func countUsed(names []string, used map[string][]string) int {
+	n := 0
+	for _, name := range names {
+		if len(used[name]) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func getMupdfVersion() string {
+	path := filepath.Join(mupdfDir, "include", "mupdf", "fitz", "version.h")
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return "unknown"
+	}
+	rx := regexp.MustCompile(`#define FZ_VERSION "(.*?)"`)
+	m := rx.FindSubmatch(d)
+	if m == nil {
+		return "unknown"
+	}
+	return string(m[1])
+}
+
+func updateMupdf(tag string) {
+	defer makePrintDuration("updateMupdf")()
+	panicIf(!isGitClean("."), "git has unsaved changes, commit or stash them before updating mupdf")
+
+	prevVer := getMupdfVersion()
+	prevAPI := collectMupdfAPIMust(mupdfDir)
+	patches := getMupdfPatchesMust()
+	verifyMupdfMatchesPatchesMust(prevVer, patches)
+	logf("updating mupdf from %s to %s, %d local patches in '%s'\n", prevVer, tag, len(patches), patchesDir)
+
+	upstreamDir := cloneMupdfAtTagMust(tag)
+	failedPatches := applyPatches(upstreamDir, mupdfDir, patches)
+	panicIf(len(failedPatches) > 0, "patches %s don't apply to mupdf %s, update them. Upstream sources with the patches that did apply are in '%s'", strings.Join(failedPatches, ", "), tag, upstreamDir)
+	replaceDirContentMust(mupdfDir, upstreamDir, mupdfSkipDirs)
+	must(os.RemoveAll(upstreamDir))
+
+	changes := diffMupdfAPI(prevAPI, collectMupdfAPIMust(mupdfDir))
+
+	// don't abort on build / test failures, they're expected with
+	// non-trivial updates and we want the summary
+	var buildErr, regressErr error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				buildErr = fmt.Errorf("%v", r)
+			}
+		}()
+		buildJustPortableExe(rel64Dir, "Release", kPlatformIntel64)
+	}()
+	if buildErr == nil {
+		regressErr = runRegressTests()
+	} else {
+		regressErr = fmt.Errorf("skipped because build failed")
+	}
+
+	summary := genMupdfUpdateSummary(prevVer, tag, changes, patches, buildErr, regressErr)
+	path := filepath.Join("out", "mupdf-update-"+tag+".md")
+	writeFileMust(path, []byte(summary))
+	logf("\n%s\nwrote summary to '%s'\n", summary, path)
+	logf("review changes with: git diff --stat -- %s\n", mupdfDir)
+	u.OpenNotepadWithFileMust(path)
+}
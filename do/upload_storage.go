@@ -2,24 +2,16 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/kjk/minioutil"
 	"github.com/kjk/u"
 )
 
-// we delete old daily and pre-release builds. This defines how many most recent
-// builds to retain
-const nBuildsToRetainPreRel = 5
-
 type BuildType string
 
 const (
@@ -169,19 +161,30 @@ func testGenUpdateTxt() {
 	os.Exit(0)
 }
 
+func getDownloadPrefixViaWebsite(buildType BuildType, ver string) string {
+	return "https://www.sumatrapdfreader.org/dl/" + string(buildType) + "/" + ver + "/"
+}
+
 func getDownloadUrlsViaWebsite(buildType BuildType, ver string) *DownloadUrls {
-	prefix := "https://www.sumatrapdfreader.org/dl/" + string(buildType) + "/" + ver + "/"
+	prefix := getDownloadPrefixViaWebsite(buildType, ver)
 	return getDownloadUrlsForPrefix(prefix, buildType, ver)
 }
 
-func getDownloadUrlsDirectS3(mc *minioutil.Client, buildType BuildType, ver string) *DownloadUrls {
-	prefix := mc.URLBase()
+func getDownloadUrlsDirectS3(storage StorageBackend, buildType BuildType, ver string) *DownloadUrls {
+	prefix := storage.URLBase()
 	prefix += getRemoteDir(buildType)
 	return getDownloadUrlsForPrefix(prefix, buildType, ver)
 }
 
 // sumatrapdf/sumatralatest.js
-func createSumatraLatestJs(mc *minioutil.Client, buildType BuildType) string {
+func createSumatraLatestJs(buildType BuildType) string {
+	ver := getVerForBuildType(buildType)
+	am := readArtifactsManifest(getFinalDirForBuildType(buildType))
+	return createSumatraLatestJsForBuild(buildType, ver, getGitSha1(), am)
+}
+
+// am can be nil
+func createSumatraLatestJsForBuild(buildType BuildType, ver string, sha1 string, am *ArtifactsManifest) string {
 	var appName string
 	switch buildType {
 	case buildTypePreRel:
@@ -193,7 +196,6 @@ func createSumatraLatestJs(mc *minioutil.Client, buildType BuildType) string {
 	}
 
 	currDate := time.Now().Format("2006-01-02")
-	ver := getVerForBuildType(buildType)
 
 	// old version pointing directly to s3 storage
 	//host := strings.TrimSuffix(mc.URLBase(), "/")
@@ -237,7 +239,6 @@ var sumLatestPdbArm64       = "{{.Host}}/{{.Prefix}}-arm64.pdb.zip";
 var sumLatestInstallerArm64 = "{{.Host}}/{{.Prefix}}-arm64-install.exe";
 
 `
-	sha1 := getGitSha1()
 	d := map[string]interface{}{
 		"Host":     host,
 		"Ver":      ver,
@@ -249,17 +250,21 @@ var sumLatestInstallerArm64 = "{{.Host}}/{{.Prefix}}-arm64-install.exe";
 	if buildType == buildTypePreRel {
 		d["Prefix"] = appName
 	}
-	return execTextTemplate(tmplText, d)
+	s := execTextTemplate(tmplText, d)
+	if am != nil {
+		s += genSha256Js(am, "sumLatest", host)
+	}
+	return s
 }
 
-func getVersionFilesForLatestInfo(mc *minioutil.Client, buildType BuildType) [][]string {
+func getVersionFilesForLatestInfo(storage StorageBackend, buildType BuildType) [][]string {
 	panicIf(buildType == buildTypeRel)
 	remotePaths := getRemotePaths(buildType)
 	var res [][]string
 
 	{
 		// *latest.js : for the website
-		s := createSumatraLatestJs(mc, buildType)
+		s := createSumatraLatestJs(buildType)
 		res = append(res, []string{remotePaths[0], s})
 	}
 
@@ -271,9 +276,11 @@ func getVersionFilesForLatestInfo(mc *minioutil.Client, buildType BuildType) [][
 
 	{
 		// *-update.txt : for current builds
-		urls := getDownloadUrlsViaWebsite(buildType, ver)
+		am := readArtifactsManifestMust(getFinalDirForBuildType(buildType))
+		prefix := getDownloadPrefixViaWebsite(buildType, ver)
+		urls := getDownloadUrlsFromManifest(am, prefix)
 		if false {
-			urls = getDownloadUrlsDirectS3(mc, buildType, ver)
+			urls = getDownloadUrlsDirectS3(storage, buildType, ver)
 		}
 		s := genUpdateTxt(urls, ver)
 		res = append(res, []string{remotePaths[2], s})
@@ -284,7 +291,7 @@ func getVersionFilesForLatestInfo(mc *minioutil.Client, buildType BuildType) [][
 
 // we shouldn't re-upload files. We upload manifest-${ver}.txt last, so we
 // consider a pre-release build already present in s3 if manifest file exists
-func isBuildAlreadyUploaded(mc *minioutil.Client, buildType BuildType) bool {
+func isBuildAlreadyUploaded(storage StorageBackend, buildType BuildType) bool {
 	dirRemote := getRemoteDir(buildType)
 	ver := getVerForBuildType(buildType)
 	fname := "SumatraPDF-prerel-manifest.txt"
@@ -292,35 +299,67 @@ func isBuildAlreadyUploaded(mc *minioutil.Client, buildType BuildType) bool {
 		fname = fmt.Sprintf("SumatraPDF-%s-manifest.txt", ver)
 	}
 	remotePath := path.Join(dirRemote, fname)
-	exists := mc.Exists(remotePath)
+	exists := storage.Exists(remotePath)
 	if exists {
-		logf("build of type '%s' for ver '%s' already exists because '%s' exists\n", buildType, ver, mc.URLForPath(remotePath))
+		logf("build of type '%s' for ver '%s' already exists because '%s' exists\n", buildType, ver, storage.URLForPath(remotePath))
 
 	}
 	return exists
 }
 
-func verifyBuildNotInStorageMust(mc *minioutil.Client, buildType BuildType) {
-	exists := isBuildAlreadyUploaded(mc, buildType)
+func verifyBuildNotInStorageMust(storage StorageBackend, buildType BuildType) {
+	exists := isBuildAlreadyUploaded(storage, buildType)
 	panicIf(exists, "build already exists")
 }
 
-func UploadDir(c *minioutil.Client, dirRemote string, dirLocal string, public bool) error {
-	files, err := ioutil.ReadDir(dirLocal)
+func uploadFileLogged(storage StorageBackend, pathRemote string, pathLocal string) error {
+	timeStart := time.Now()
+	err := storage.UploadFile(pathRemote, pathLocal)
+	if err != nil {
+		return fmt.Errorf("upload of '%s' as '%s' failed with '%s'", pathLocal, pathRemote, err)
+	}
+	uri := storage.URLForPath(pathRemote)
+	logf("Uploaded %s => %s in %s\n", pathLocal, uri, time.Since(timeStart))
+	return nil
+}
+
+// uploads files listed in artifacts.json, then artifacts.json itself
+// and SHA256SUMS.txt and then ${prefix}-manifest.txt, which must be last because isBuildAlreadyUploaded()
+// checks for its presence
+func uploadArtifacts(storage StorageBackend, dirRemote string, dirLocal string, am *ArtifactsManifest) error {
+	for _, a := range am.Artifacts {
+		pathLocal := filepath.Join(dirLocal, a.Name)
+		size := fileSizeMust(pathLocal)
+		if size != a.Size {
+			return fmt.Errorf("size of '%s' is %d and %d in %s", pathLocal, size, a.Size, artifactsManifestName)
+		}
+		err := uploadFileLogged(storage, path.Join(dirRemote, a.Name), pathLocal)
+		if err != nil {
+			return err
+		}
+	}
+	pathLocal := getArtifactsManifestPath(dirLocal)
+	err := uploadFileLogged(storage, path.Join(dirRemote, artifactsManifestName), pathLocal)
+	if err != nil {
+		return err
+	}
+	err = uploadFileLogged(storage, path.Join(dirRemote, sha256SumsName), getSha256SumsPath(dirLocal))
+	if err != nil {
+		return err
+	}
+	files, err := os.ReadDir(dirLocal)
 	if err != nil {
 		return err
 	}
 	for _, f := range files {
-		fname := f.Name()
-		pathLocal := filepath.Join(dirLocal, fname)
-		pathRemote := path.Join(dirRemote, fname)
-		timeStart := time.Now()
-		_, err := c.UploadFile(pathRemote, pathLocal, public)
+		name := f.Name()
+		if !strings.HasSuffix(name, "-manifest.txt") {
+			continue
+		}
+		err = uploadFileLogged(storage, path.Join(dirRemote, name), filepath.Join(dirLocal, name))
 		if err != nil {
-			return fmt.Errorf("upload of '%s' as '%s' failed with '%s'", pathLocal, pathRemote, err)
+			return err
 		}
-		uri := c.URLForPath(pathRemote)
-		logf("Uploaded %s => %s in %s\n", pathLocal, uri, time.Since(timeStart))
 	}
 	return nil
 }
@@ -341,16 +380,17 @@ func getFinalDirForBuildType(buildType BuildType) string {
 }
 
 // https://kjkpubsf.sfo2.digitaloceanspaces.com/software/sumatrapdf/prerel/1024/SumatraPDF-prerelease-install.exe etc.
-func minioUploadBuildMust(mc *minioutil.Client, buildType BuildType) {
+func uploadBuildMust(storage StorageBackend, buildType BuildType) {
 	timeStart := time.Now()
 	defer func() {
-		logf("Uploaded build '%s' to %s in %s\n", buildType, mc.URLBase(), time.Since(timeStart))
+		logf("Uploaded build '%s' to %s in %s\n", buildType, storage.URLBase(), time.Since(timeStart))
 	}()
 
 	dirRemote := getRemoteDir(buildType)
 	dirLocal := getFinalDirForBuildType(buildType)
 
-	err := UploadDir(mc, dirRemote, dirLocal, true)
+	am := readArtifactsManifestMust(dirLocal)
+	err := uploadArtifacts(storage, dirRemote, dirLocal, am)
 	must(err)
 
 	// for release build we don't upload files with version info
@@ -360,95 +400,17 @@ func minioUploadBuildMust(mc *minioutil.Client, buildType BuildType) {
 	}
 
 	uploadBuildUpdateInfoMust := func(buildType BuildType) {
-		files := getVersionFilesForLatestInfo(mc, buildType)
+		files := getVersionFilesForLatestInfo(storage, buildType)
 		for _, f := range files {
 			remotePath := f[0]
-			_, err := mc.UploadData(remotePath, []byte(f[1]), true)
+			err := storage.UploadData(remotePath, []byte(f[1]))
 			must(err)
-			logf("Uploaded `%s'\n", mc.URLForPath(remotePath))
+			logf("Uploaded `%s'\n", storage.URLForPath(remotePath))
 		}
 	}
 
 	uploadBuildUpdateInfoMust(buildType)
-}
-
-type filesByVer struct {
-	ver   int
-	files []string
-}
-
-func groupFilesByVersion(files []string) []*filesByVer {
-	// "software/sumatrapdf/prerel/14028/SumatraPDF-prerel-64.pdb.zip"
-	// =>
-	// 14028
-	extractVersionFromName := func(s string) int {
-		parts := strings.Split(s, "/")
-		verStr := parts[3]
-		ver, err := strconv.Atoi(verStr)
-		panicIf(err != nil, "extractVersionFromName: '%s', err='%s'\n", s, err)
-		return ver
-	}
-
-	m := map[int]*filesByVer{}
-	for _, f := range files {
-		ver := extractVersionFromName(f)
-		i := m[ver]
-		if i == nil {
-			i = &filesByVer{
-				ver: ver,
-			}
-			m[ver] = i
-		}
-		i.files = append(i.files, f)
-	}
-	res := []*filesByVer{}
-	for _, v := range m {
-		res = append(res, v)
-	}
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].ver > res[j].ver
-	})
-	return res
-}
-
-func minioDeleteOldBuildsPrefix(mc *minioutil.Client, buildType BuildType) {
-	nBuildsToRetain := nBuildsToRetainPreRel
-	var remoteDir string
-	switch buildType {
-	case buildTypeRel:
-		panicIf(true, "can't delete release builds")
-	case buildTypePreRel:
-		remoteDir = "software/sumatrapdf/prerel/"
-	// case buildTypeDaily:
-	// 	remoteDir = "software/sumatrapdf/daily/"
-	default:
-		panicIf(true, "unsupported buildType: '%s'", buildType)
-	}
-	objectsCh := mc.ListObjects(remoteDir)
-	var keys []string
-	for f := range objectsCh {
-		keys = append(keys, f.Key)
-		//logf("  %s\n", f.Key)
-	}
-
-	uri := mc.URLForPath(remoteDir)
-	logf("%d files under '%s'\n", len(keys), uri)
-	byVer := groupFilesByVersion(keys)
-	for i, v := range byVer {
-		deleting := (i >= nBuildsToRetain)
-		if deleting {
-			logf("deleting %d\n", v.ver)
-			if true {
-				for _, key := range v.files {
-					err := mc.Remove(key)
-					must(err)
-					logf("  deleted %s\n", key)
-				}
-			}
-		} else {
-			logf("not deleting %d\n", v.ver)
-		}
-	}
+	uploadUpdateManifestMust(storage, buildType)
 }
 
 func newMinioBackblazeClient() *minioutil.Client {
@@ -476,27 +438,23 @@ func newMinioR2Client() *minioutil.Client {
 }
 
 func uploadToStorage(buildType BuildType) {
-	isUploaded := isBuildAlreadyUploaded(newMinioBackblazeClient(), buildType)
+	isUploaded := isBuildAlreadyUploaded(newBackblazeStorage(), buildType)
 	if isUploaded {
 		logf("uploadToStorage: skipping upload because already uploaded")
 		return
 	}
+	// local and CI builds without a certificate are not signed
+	if canSign() {
+		verifySignaturesMust(buildType)
+	} else {
+		logf("uploadToStorage: not verifying signatures because signing is not configured: %s\n", getSigner().Missing())
+	}
+	waitForUploadWindow()
 
 	timeStart := time.Now()
 	defer func() {
 		logf("uploadToStorage of '%s' finished in %s\n", buildType, time.Since(timeStart))
 	}()
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func() {
-		mc := newMinioR2Client()
-		minioUploadBuildMust(mc, buildType)
-		if buildType != buildTypeRel {
-			minioDeleteOldBuildsPrefix(mc, buildType)
-		}
-		wg.Done()
-	}()
 
 	// downloads of pre-release 64-bit installer often fail
 	// I suspect cloudflare backblaze proxy is caching 404 responses and 64-bit are hit
@@ -511,17 +469,22 @@ func uploadToStorage(buildType BuildType) {
 	// 	time.Sleep(time.Minute * 5)
 	// }
 
-	wg.Add(1)
-	go func() {
-		mc := newMinioBackblazeClient()
-		minioUploadBuildMust(mc, buildType)
-		if buildType != buildTypeRel {
-			minioDeleteOldBuildsPrefix(mc, buildType)
-		}
-		wg.Done()
-	}()
+	uploadBuildToStoragesMust(buildType)
+	purgeCdnCache(buildType)
+	if len(collectSymbolFiles()) == 0 {
+		logf("uploadToStorage: not uploading symbols because there are no .pdb files in out/\n")
+		return
+	}
+	buildAndUploadSymbols(buildType, true)
+}
 
-	wg.Wait()
+func uploadBuildToStoragesMust(buildType BuildType) {
+	forEachStorageParallelMust(getStorageBackends(), func(storage StorageBackend) {
+		uploadBuildMust(storage, buildType)
+		if buildType == buildTypePreRel {
+			prunePreRelBuildsMust(storage)
+		}
+	})
 }
 
 func uploadLogView() {
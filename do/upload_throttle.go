@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Daily pre-release uploads from home connection shouldn't make it unusable.
+// Set in sumatrapdf.env or env variables:
+//   - UPLOAD_MAX_KBPS: max total upload speed in kB/s, shared by all
+//     storages and parallel uploads of parts
+//   - UPLOAD_WINDOW: e.g. "01:00-07:00" (local time), uploads wait until
+//     the window starts. Can wrap midnight e.g. "23:00-06:00"
+//
+// Upload of every file reports progress every uploadProgressInterval.
+
+var (
+	uploadMaxKbps string
+	uploadWindow  string
+)
+
+const (
+	uploadProgressInterval = 15 * time.Second
+	// we read in small chunks so that throttled upload is smooth
+	uploadThrottleChunk = 32 * 1024
+)
+
+// UploadLimiter limits bandwidth of all uploads
+type UploadLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec int64
+	// when we can send the next byte
+	next time.Time
+}
+
+// called after reading n bytes, waits so that we don't go over the limit
+func (l *UploadLimiter) wait(n int) {
+	if l == nil || l.bytesPerSec <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSec))
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+var (
+	uploadLimiter     *UploadLimiter
+	uploadLimiterOnce sync.Once
+)
+
+// returns nil if upload is not limited
+func getUploadLimiter() *UploadLimiter {
+	uploadLimiterOnce.Do(func() {
+		if uploadMaxKbps == "" {
+			return
+		}
+		kbps, err := strconv.Atoi(strings.TrimSpace(uploadMaxKbps))
+		panicIf(err != nil || kbps <= 0, "invalid UPLOAD_MAX_KBPS '%s'", uploadMaxKbps)
+		uploadLimiter = &UploadLimiter{bytesPerSec: int64(kbps) * 1024}
+		logf("limiting uploads to %d kB/s\n", kbps)
+	})
+	return uploadLimiter
+}
+
+// UploadProgress logs progress of upload of a single file
+type UploadProgress struct {
+	mu        sync.Mutex
+	name      string
+	total     int64
+	done      int64
+	timeStart time.Time
+	lastLog   time.Time
+}
+
+func newUploadProgress(name string, total int64) *UploadProgress {
+	now := time.Now()
+	return &UploadProgress{name: name, total: total, timeStart: now, lastLog: now}
+}
+
+// n is negative when minio rewinds a reader to retry
+func (p *UploadProgress) add(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	if time.Since(p.lastLog) < uploadProgressInterval || p.total == 0 {
+		return
+	}
+	p.lastLog = time.Now()
+	elapsed := time.Since(p.timeStart).Seconds()
+	speed := int64(float64(p.done) / max(elapsed, 1))
+	logf("uploading '%s': %d%% (%s of %s), %s/s\n", p.name, p.done*100/p.total, formatSize(p.done), formatSize(p.total), formatSize(speed))
+}
+
+// ThrottledReader reads at most the speed allowed by UploadLimiter and
+// reports progress
+type ThrottledReader struct {
+	r        io.Reader
+	limiter  *UploadLimiter
+	progress *UploadProgress
+	pos      int64
+}
+
+func newThrottledReader(r io.Reader, progress *UploadProgress) *ThrottledReader {
+	return &ThrottledReader{r: r, limiter: getUploadLimiter(), progress: progress}
+}
+
+func (t *ThrottledReader) Read(d []byte) (int, error) {
+	if t.limiter != nil && len(d) > uploadThrottleChunk {
+		d = d[:uploadThrottleChunk]
+	}
+	n, err := t.r.Read(d)
+	t.limiter.wait(n)
+	t.pos += int64(n)
+	t.progress.add(int64(n))
+	return n, err
+}
+
+// minio retries requests only if it can rewind the reader
+func (t *ThrottledReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := t.r.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("reader is not seekable")
+	}
+	pos, err := s.Seek(offset, whence)
+	if err == nil {
+		t.progress.add(pos - t.pos)
+		t.pos = pos
+	}
+	return pos, err
+}
+
+// "01:30" => 1h30m
+func parseTimeOfDay(s string) (time.Duration, bool) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, true
+}
+
+// returns how long until UPLOAD_WINDOW starts, 0 if we're inside it
+func getTimeUntilUploadWindow(now time.Time) time.Duration {
+	if uploadWindow == "" {
+		return 0
+	}
+	parts := strings.Split(uploadWindow, "-")
+	panicIf(len(parts) != 2, "invalid UPLOAD_WINDOW '%s', should be e.g. '01:00-07:00'", uploadWindow)
+	start, ok1 := parseTimeOfDay(parts[0])
+	end, ok2 := parseTimeOfDay(parts[1])
+	panicIf(!ok1 || !ok2, "invalid UPLOAD_WINDOW '%s', should be e.g. '01:00-07:00'", uploadWindow)
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	sinceMidnight := now.Sub(midnight)
+	inWindow := sinceMidnight >= start && sinceMidnight < end
+	if start > end {
+		inWindow = sinceMidnight >= start || sinceMidnight < end
+	}
+	if inWindow {
+		return 0
+	}
+	if sinceMidnight < start {
+		return start - sinceMidnight
+	}
+	return 24*time.Hour - sinceMidnight + start
+}
+
+func waitForUploadWindow() {
+	d := getTimeUntilUploadWindow(time.Now())
+	if d == 0 {
+		return
+	}
+	logf("waiting %s for upload window %s\n", d.Round(time.Minute), uploadWindow)
+	time.Sleep(d)
+}
@@ -28,7 +28,6 @@ var (
 	pathExists        = u.PathExists
 	formatSize        = u.FormatSize
 	getFileSize       = u.FileSize
-	copyFile          = u.CopyFile
 	fileSha1Hex       = u.FileSha1Hex
 	formatDuration    = u.FormatDuration
 	readLinesFromFile = u.ReadLines
@@ -98,6 +97,9 @@ func absPathMust(path string) string {
 func runExeMust(c string, args ...string) []byte {
 	cmd := exec.Command(c, args...)
 	logf("> %s\n", cmd)
+	if dryRunSkipCmd(cmd) {
+		return nil
+	}
 	out, err := cmd.CombinedOutput()
 	must(err)
 	return []byte(out)
@@ -107,6 +109,9 @@ func runExeInDirMust(dir string, c string, args ...string) []byte {
 	cmd := exec.Command(c, args...)
 	logf("> %s\n", cmd)
 	cmd.Dir = dir
+	if dryRunSkipCmd(cmd) {
+		return nil
+	}
 	out, err := cmd.CombinedOutput()
 	must(err)
 	return []byte(out)
@@ -136,6 +141,9 @@ func removeFileMust(path string) {
 	if !fileExists(path) {
 		return
 	}
+	if dryRunSkip("delete '%s'", path) {
+		return
+	}
 	err := os.Remove(path)
 	must(err)
 }
@@ -162,6 +170,9 @@ func fileNewerThan(path1, path2 string) bool {
 
 func cmdRunLoggedMust(cmd *exec.Cmd) {
 	fmt.Printf("> %s\n", cmd.String())
+	if dryRunSkipCmd(cmd) {
+		return
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
@@ -176,6 +187,9 @@ func readFileMust(path string) []byte {
 }
 
 func writeFileMust(path string, data []byte) {
+	if dryRunSkip("write '%s' (%s)", path, formatSize(int64(len(data)))) {
+		return
+	}
 	err := ioutil.WriteFile(path, data, 0644)
 	must(err)
 }
@@ -234,6 +248,9 @@ func fmdCmdShort(cmd *exec.Cmd) string {
 
 func runCmdLoggedMust(cmd *exec.Cmd) {
 	logf("> %s\n", fmdCmdShort(cmd))
+	if dryRunSkipCmd(cmd) {
+		return
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
@@ -268,6 +285,9 @@ func fmtCmdShort(cmd exec.Cmd) string {
 
 func runCmdMust(cmd *exec.Cmd) string {
 	fmt.Printf("> %s\n", fmtCmdShort(*cmd))
+	if dryRunSkipCmd(cmd) {
+		return ""
+	}
 	canCapture := (cmd.Stdout == nil) && (cmd.Stderr == nil)
 	if canCapture {
 		out, err := cmd.CombinedOutput()
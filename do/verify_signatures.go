@@ -0,0 +1,344 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Before uploading we check that every binary we ship (installers, portable
+// executables, executables inside portable .zip and .7z files and dlls that go into
+// the installer) and .msi / .msix packages have:
+//   - valid Authenticode signature(s)
+//   - signed by expected signer
+//   - timestamped (otherwise signature becomes invalid when cert expires)
+//   - version resource matching the version we're uploading (.exe and .dll)
+// A mistake here is expensive: unsigned or wrongly versioned build gets
+// flagged by anti-virus programs and breaks auto-update
+
+// over-ride with SIGN_EXPECTED_SIGNER if certificate changes
+var expectedSignerName = "Krzysztof Kowalczyk"
+
+const imageDirectoryEntryResource = 2
+
+// VS_FIXEDFILEINFO signature
+const vsFixedFileInfoSignature = 0xFEEF04BD
+
+// https://learn.microsoft.com/en-us/windows/win32/debug/pe-format#the-rsrc-section
+// returns data of first resource of a given type
+func readPeResourceOfType(path string, resType uint32) ([]byte, error) {
+	f, err := pe.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dd pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dd = oh.DataDirectory[imageDirectoryEntryResource]
+	case *pe.OptionalHeader64:
+		dd = oh.DataDirectory[imageDirectoryEntryResource]
+	default:
+		return nil, fmt.Errorf("'%s' has no optional header", path)
+	}
+	if dd.VirtualAddress == 0 || dd.Size == 0 {
+		return nil, fmt.Errorf("'%s' has no resources", path)
+	}
+	var sect *pe.Section
+	for _, s := range f.Sections {
+		if dd.VirtualAddress >= s.VirtualAddress && dd.VirtualAddress < s.VirtualAddress+s.VirtualSize {
+			sect = s
+			break
+		}
+	}
+	if sect == nil {
+		return nil, fmt.Errorf("'%s': didn't find section with resources", path)
+	}
+	d, err := sect.Data()
+	if err != nil {
+		return nil, err
+	}
+	rsrc := d[dd.VirtualAddress-sect.VirtualAddress:]
+
+	// resource tree has 3 levels: type, name, language
+	// we pick resType at first level and first entry at other levels
+	off := uint32(0)
+	for level := 0; level < 3; level++ {
+		if int(off)+16 > len(rsrc) {
+			return nil, fmt.Errorf("'%s': invalid resource directory", path)
+		}
+		nNamed := binary.LittleEndian.Uint16(rsrc[off+12:])
+		nIds := binary.LittleEndian.Uint16(rsrc[off+14:])
+		entries := rsrc[off+16:]
+		found := false
+		for i := 0; i < int(nNamed)+int(nIds); i++ {
+			if (i+1)*8 > len(entries) {
+				break
+			}
+			id := binary.LittleEndian.Uint32(entries[i*8:])
+			dataOff := binary.LittleEndian.Uint32(entries[i*8+4:])
+			if level == 0 && id != resType {
+				continue
+			}
+			off = dataOff
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("'%s': no resource of type %d", path, resType)
+		}
+		isDir := off&0x80000000 != 0
+		off &= 0x7fffffff
+		if level < 2 && !isDir {
+			return nil, fmt.Errorf("'%s': invalid resource directory", path)
+		}
+	}
+	// IMAGE_RESOURCE_DATA_ENTRY: OffsetToData (rva), Size
+	if int(off)+8 > len(rsrc) {
+		return nil, fmt.Errorf("'%s': invalid resource data entry", path)
+	}
+	rva := binary.LittleEndian.Uint32(rsrc[off:])
+	size := binary.LittleEndian.Uint32(rsrc[off+4:])
+	start := rva - sect.VirtualAddress
+	if int(start+size) > len(d) {
+		return nil, fmt.Errorf("'%s': resource data outside of section", path)
+	}
+	return d[start : start+size], nil
+}
+
+// returns FILEVERSION from version resource e.g. "3.6.0.16443"
+func readPeFileVersion(path string) (string, error) {
+	const rtVersion = 16
+	d, err := readPeResourceOfType(path, rtVersion)
+	if err != nil {
+		return "", err
+	}
+	var sig [4]byte
+	binary.LittleEndian.PutUint32(sig[:], vsFixedFileInfoSignature)
+	idx := bytes.Index(d, sig[:])
+	if idx < 0 || idx+16 > len(d) {
+		return "", fmt.Errorf("'%s': no VS_FIXEDFILEINFO in version resource", path)
+	}
+	// dwSignature, dwStrucVersion, dwFileVersionMS, dwFileVersionLS
+	ms := binary.LittleEndian.Uint32(d[idx+8:])
+	ls := binary.LittleEndian.Uint32(d[idx+12:])
+	return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xffff, ls>>16, ls&0xffff), nil
+}
+
+// CURR_VERSION_COMMA from src/Version.h, as "3.6.0"
+func extractSumatraVersionCommaMust() string {
+	path := filepath.Join("src", "Version.h")
+	lines, err := readLinesFromFile(path)
+	must(err)
+	s := "#define CURR_VERSION_COMMA "
+	for _, l := range lines {
+		if strings.HasPrefix(l, s) {
+			return strings.ReplaceAll(strings.TrimSpace(l[len(s):]), ",", ".")
+		}
+	}
+	panic(fmt.Sprintf("couldn't extract CURR_VERSION_COMMA from %s\n", path))
+}
+
+// mirrors VER_RESOURCE in src/Version.h
+func getExpectedFileVersion(buildType BuildType) string {
+	ver := extractSumatraVersionCommaMust()
+	if buildType == buildTypePreRel {
+		return ver + "." + getPreReleaseVer()
+	}
+	return ver + ".0"
+}
+
+// SignatureInfo is what we extract from signtool verify /v output
+type SignatureInfo struct {
+	Signer      string
+	Timestamped bool
+}
+
+// parses output of signtool verify /pa /all /v which has a block for each
+// signature:
+//
+//	Signature Index: 0 (Primary Signature)
+//	Signing Certificate Chain:
+//	    Issued to: Sectigo Public Code Signing Root R46
+//	    ...
+//	        Issued to: Krzysztof Kowalczyk
+//	The signature is timestamped: Mon Jan 01 12:00:00 2024
+//	Timestamp Verified by:
+//	    Issued to: ...
+func parseSigntoolVerifyOutput(out string) []*SignatureInfo {
+	var res []*SignatureInfo
+	var curr *SignatureInfo
+	inTimestampChain := false
+	for _, l := range strings.Split(out, "\n") {
+		l = strings.TrimSpace(l)
+		switch {
+		case strings.HasPrefix(l, "Signature Index:"):
+			curr = &SignatureInfo{}
+			res = append(res, curr)
+			inTimestampChain = false
+		case curr == nil:
+			continue
+		case strings.HasPrefix(l, "Timestamp Verified by:"):
+			inTimestampChain = true
+		case strings.HasPrefix(l, "The signature is timestamped:"):
+			curr.Timestamped = true
+		case strings.HasPrefix(l, "Issued to:") && !inTimestampChain:
+			// the leaf certificate is the last in the chain
+			curr.Signer = strings.TrimSpace(strings.TrimPrefix(l, "Issued to:"))
+		}
+	}
+	return res
+}
+
+// returns description of the problem or "" if file is ok
+func verifyShippedBinary(path string, expectedVer string) string {
+	signtoolPath := detectSigntoolPath()
+	cmd := exec.Command(signtoolPath, "verify", "/pa", "/all", "/v", path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Sprintf("invalid or missing signature (signtool verify failed with '%s')", err)
+	}
+	sigs := parseSigntoolVerifyOutput(string(out))
+	if len(sigs) == 0 {
+		return "no signatures"
+	}
+	for i, sig := range sigs {
+		if sig.Signer != expectedSignerName {
+			return fmt.Sprintf("signature %d is signed by '%s', expected '%s'", i, sig.Signer, expectedSignerName)
+		}
+		if !sig.Timestamped {
+			return fmt.Sprintf("signature %d is not timestamped", i)
+		}
+	}
+	if !isPeFileName(path) {
+		// .msi etc. don't have version resource
+		return ""
+	}
+	ver, err := readPeFileVersion(path)
+	if err != nil {
+		return err.Error()
+	}
+	if ver != expectedVer {
+		return fmt.Sprintf("version resource is %s, expected %s", ver, expectedVer)
+	}
+	return ""
+}
+
+// extracts .exe files from .zip to dstDir, returns their paths
+func extractExesFromZipMust(zipPath string, dstDir string) []string {
+	zr, err := zip.OpenReader(zipPath)
+	must(err)
+	defer zr.Close()
+	var res []string
+	for _, f := range zr.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".exe") {
+			continue
+		}
+		dst := filepath.Join(dstDir, filepath.Base(f.Name))
+		r, err := f.Open()
+		must(err)
+		w, err := os.Create(dst)
+		must(err)
+		_, err = io.Copy(w, r)
+		r.Close()
+		must(w.Close())
+		must(err)
+		res = append(res, dst)
+	}
+	return res
+}
+
+func getOutDirForArch(arch string) string {
+	switch arch {
+	case "32":
+		return rel32Dir
+	case "64":
+		return rel64Dir
+	case "arm64":
+		return relArm64Dir
+	}
+	panicIf(true, "unknown arch '%s'", arch)
+	return ""
+}
+
+// returns list of binaries to verify for a build described in artifacts.json
+func collectShippedBinariesMust(am *ArtifactsManifest, tmpDir string) []string {
+	var res []string
+	arches := map[string]bool{}
+	for _, a := range am.Artifacts {
+		if a.Arch != "" {
+			arches[a.Arch] = true
+		}
+		switch a.Kind {
+		case kArtifactInstaller, kArtifactPortableExe, kArtifactMsi, kArtifactMsix:
+			res = append(res, filepath.FromSlash(a.Path))
+		case kArtifactPortableZip:
+			zipPath := filepath.FromSlash(a.Path)
+			problem := verifyPortableZip(zipPath, am, a.Arch)
+			panicIf(problem != "", "verifySignatures: '%s': %s", zipPath, problem)
+			dir := filepath.Join(tmpDir, a.Arch)
+			createDirMust(dir)
+			res = append(res, extractExesFromZipMust(zipPath, dir)...)
+		case kArtifactPortable7z:
+			dir := filepath.Join(tmpDir, a.Arch+"-7z")
+			createDirMust(dir)
+			extract7zMust(filepath.FromSlash(a.Path), dir)
+			problem := verifyPortableFilesInDir(dir, am, a.Arch)
+			panicIf(problem != "", "verifySignatures: '%s': %s", a.Path, problem)
+			paths, err := filepath.Glob(filepath.Join(dir, "*.exe"))
+			must(err)
+			res = append(res, paths...)
+		}
+	}
+	// dlls are shipped inside the installer
+	for arch := range arches {
+		dir := getOutDirForArch(arch)
+		for _, name := range binariesToCheckMinOs {
+			if !strings.HasSuffix(name, ".dll") {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if fileExists(path) {
+				res = append(res, path)
+			}
+		}
+	}
+	return res
+}
+
+// panics with list of bad files if any shipped binary is not signed properly
+func verifySignaturesMust(buildType BuildType) {
+	dir := getFinalDirForBuildType(buildType)
+	am := readArtifactsManifestMust(dir)
+	expectedVer := getExpectedFileVersion(buildType)
+
+	tmpDir := filepath.Join("out", "verify-signatures")
+	must(os.RemoveAll(tmpDir))
+	defer os.RemoveAll(tmpDir)
+
+	files := collectShippedBinariesMust(am, tmpDir)
+	panicIf(len(files) == 0, "verifySignatures: no binaries to verify in '%s'", dir)
+	var bad []string
+	for _, path := range files {
+		problem := verifyShippedBinary(path, expectedVer)
+		if problem == "" {
+			logf("verifySignatures: ok '%s'\n", path)
+			continue
+		}
+		s := fmt.Sprintf("%s: %s", path, problem)
+		logf("verifySignatures: BAD %s\n", s)
+		bad = append(bad, s)
+	}
+	if len(bad) > 0 {
+		panicIf(true, "verifySignatures: %d out of %d binaries failed verification:\n  %s", len(bad), len(files), strings.Join(bad, "\n  "))
+	}
+	logf("verifySignatures: all %d binaries of %s build %s are signed, timestamped and have version %s\n", len(files), buildType, am.Version, expectedVer)
+}
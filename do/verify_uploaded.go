@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// After upload we check what users get, not what we have locally. For each
+// installer and portable file of a build we:
+//   - download it from its public url (via the website, like users do)
+//   - verify sha256 against artifacts.json (also downloaded)
+//   - verify .minisig (and .asc, if we can sign with gpg) signatures
+//   - on Windows in a throwaway VM (see isThrowawayVM()): silently install
+//     the installer into a temporary directory (per-user, so no admin
+//     needed), launch installed SumatraPDF.exe with -exit-on-startup and
+//     uninstall it; launch portable .exe the same way. Installing changes
+//     the machine (uninstall entry in registry, file associations) so we
+//     don't do it elsewhere
+//
+// This catches uploads corrupted on the way and installers that are broken
+// in ways signature checks before upload don't see.
+// Part of release steps. Run by hand with: -verify-uploaded [rel|prerel] [ver]
+
+// how long we wait for installer, uninstaller or the app
+const verifyUploadedRunTimeout = 3 * time.Minute
+
+func getVerifyUploadedDir(buildType BuildType, ver string) string {
+	return filepath.Join("out", "verify-uploaded", string(buildType), ver)
+}
+
+// returns public key from docs/minisign.pub, nil if there's none
+func readMinisignPublicKey() ed25519.PublicKey {
+	d, err := os.ReadFile(minisignPublicKeyPath)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(normalizeNewlines(string(d))), "\n")
+	panicIf(len(lines) != 2, "invalid '%s'", minisignPublicKeyPath)
+	key, err := base64.StdEncoding.DecodeString(lines[1])
+	must(err)
+	panicIf(len(key) != 2+8+ed25519.PublicKeySize || string(key[:2]) != "Ed", "invalid '%s'", minisignPublicKeyPath)
+	return ed25519.PublicKey(key[10:])
+}
+
+func httpDownloadToFile(uri string, dstPath string) error {
+	client := &http.Client{Timeout: 10 * time.Minute}
+	rsp, err := client.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s failed with status code %d", uri, rsp.StatusCode)
+	}
+	must(os.MkdirAll(filepath.Dir(dstPath), 0755))
+	f, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, rsp.Body)
+	err2 := f.Close()
+	if err != nil {
+		return err
+	}
+	return err2
+}
+
+// runs exe and waits for it to exit with exit code 0
+func runWithTimeout(exe string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyUploadedRunTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe, args...)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("'%s %s' didn't finish in %s", exe, strings.Join(args, " "), verifyUploadedRunTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("'%s %s' failed with '%s', output:\n%s", exe, strings.Join(args, " "), err, string(out))
+	}
+	return nil
+}
+
+// arm64 Windows emulates x86 and x64, x64 Windows runs x86
+func canRunArch(arch string) bool {
+	switch runtime.GOARCH {
+	case "arm64":
+		return true
+	case "amd64":
+		return arch == "32" || arch == "64"
+	}
+	return arch == "32"
+}
+
+// launches exe with separate settings so that we don't mess with settings
+// of SumatraPDF installed on this machine
+func launchExitOnStartup(exePath string, appDataDir string) error {
+	must(os.MkdirAll(appDataDir, 0755))
+	return runWithTimeout(exePath, "-appdata", appDataDir, "-exit-on-startup")
+}
+
+func installAndLaunch(installerPath string, tmpDir string) error {
+	installDir := filepath.Join(tmpDir, "install")
+	must(os.RemoveAll(installDir))
+	if err := runWithTimeout(installerPath, "-install", "-s", "-d", installDir); err != nil {
+		return err
+	}
+	exePath := filepath.Join(installDir, "SumatraPDF.exe")
+	if !fileExists(exePath) {
+		return fmt.Errorf("installer didn't create '%s'", exePath)
+	}
+	err := launchExitOnStartup(exePath, filepath.Join(tmpDir, "appdata"))
+	if errUninstall := runWithTimeout(exePath, "-uninstall", "-s"); err == nil {
+		err = errUninstall
+	}
+	return err
+}
+
+func verifyUploadedArtifact(a *ArtifactInfo, prefix string, dir string, pubKey ed25519.PublicKey, names map[string]bool) []string {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		s := fmt.Sprintf("%s: ", a.Name) + fmt.Sprintf(format, args...)
+		logf("%s\n", s)
+		problems = append(problems, s)
+	}
+	path := filepath.Join(dir, a.Name)
+	if err := httpDownloadToFile(prefix+a.Name, path); err != nil {
+		addProblem("%s", err)
+		return problems
+	}
+	if got := fileSha256HexMust(path); got != a.Sha256 {
+		addProblem("sha256 is %s, expected %s", got, a.Sha256)
+		return problems
+	}
+
+	if pubKey != nil && names[a.Name+minisignSigExt] {
+		sigPath := path + minisignSigExt
+		if err := httpDownloadToFile(prefix+a.Name+minisignSigExt, sigPath); err != nil {
+			addProblem("%s", err)
+		} else if err = minisignVerify(pubKey, readFileMust(path), string(readFileMust(sigPath))); err != nil {
+			addProblem("%s: %s", minisignSigExt, err)
+		}
+	}
+	if canGpgSign() && names[a.Name+gpgSigExt] {
+		sigPath := path + gpgSigExt
+		if err := httpDownloadToFile(prefix+a.Name+gpgSigExt, sigPath); err != nil {
+			addProblem("%s", err)
+		} else if err = runWithTimeout("gpg", "--batch", "--verify", sigPath, path); err != nil {
+			addProblem("%s", err)
+		}
+	}
+
+	if runtime.GOOS != "windows" || !isThrowawayVM() || !canRunArch(a.Arch) {
+		return problems
+	}
+	tmpDir := filepath.Join(dir, "run-"+strings.TrimSuffix(a.Name, filepath.Ext(a.Name)))
+	must(os.RemoveAll(tmpDir))
+	defer os.RemoveAll(tmpDir)
+	switch a.Kind {
+	case kArtifactInstaller:
+		if err := installAndLaunch(path, tmpDir); err != nil {
+			addProblem("%s", err)
+		}
+	case kArtifactPortableExe:
+		if err := launchExitOnStartup(path, filepath.Join(tmpDir, "appdata")); err != nil {
+			addProblem("%s", err)
+		}
+	}
+	return problems
+}
+
+func verifyUploadedMust(buildType BuildType, ver string) {
+	defer makePrintDuration("verifyUploadedMust")()
+	if ver == "" {
+		ver = getVerForBuildType(buildType)
+	}
+	prefix := getDownloadPrefixViaWebsite(buildType, ver)
+	dir := getVerifyUploadedDir(buildType, ver)
+	must(os.RemoveAll(dir))
+
+	amPath := getArtifactsManifestPath(dir)
+	must(httpDownloadToFile(prefix+artifactsManifestName, amPath))
+	am := readArtifactsManifestMust(dir)
+	panicIf(am.Version != ver, "'%s' is for version %s, expected %s", prefix+artifactsManifestName, am.Version, ver)
+	names := map[string]bool{}
+	for _, a := range am.Artifacts {
+		names[a.Name] = true
+	}
+	pubKey := readMinisignPublicKey()
+	if pubKey == nil {
+		logf("Not verifying .minisig signatures because '%s' doesn't exist\n", minisignPublicKeyPath)
+	}
+	if runtime.GOOS != "windows" {
+		logf("Not installing and launching because not on Windows\n")
+	} else if !isThrowawayVM() {
+		logf("Not installing and launching because it changes the machine. Set INSTALLER_TESTS_THROWAWAY_VM=1 in a throwaway VM\n")
+	}
+
+	var problems []string
+	n := 0
+	for _, a := range am.Artifacts {
+		if !artifactIsSignable(a.Kind) {
+			continue
+		}
+		problems = append(problems, verifyUploadedArtifact(a, prefix, dir, pubKey, names)...)
+		n++
+	}
+	if len(problems) > 0 {
+		panicIf(true, "verification of uploaded %s %s failed:\n%s", buildType, ver, strings.Join(problems, "\n"))
+	}
+	logf("verified %d uploaded files of %s %s from '%s'\n", n, buildType, ver, prefix)
+}
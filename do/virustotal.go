@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// After the build we submit installers to VirusTotal and wait for results
+// so that we find out about false positives before users do.
+// Release build fails if a file is flagged by more than
+// virusTotalMaxDetections engines, pre-release build only warns.
+//
+// Results are saved in virustotal.json in final build directory and links
+// to the scans are added to GitHub release notes (see getGithubReleaseBody()).
+//
+// Needs VIRUSTOTAL_API_KEY. Public API allows 4 requests per minute so
+// we're slow on purpose.
+
+var virusTotalAPIKey string
+
+const (
+	virusTotalAPIURL        = "https://www.virustotal.com/api/v3"
+	virusTotalResultsName   = "virustotal.json"
+	virusTotalMaxDetections = 3
+	// max size for POST /files, bigger files need an upload url
+	virusTotalMaxDirectUpload = 32 * 1024 * 1024
+	virusTotalRequestDelay    = 15 * time.Second
+	virusTotalPollTimeout     = 30 * time.Minute
+)
+
+// VirusTotalStats is number of engines by verdict
+type VirusTotalStats struct {
+	Malicious  int `json:"malicious"`
+	Suspicious int `json:"suspicious"`
+	Undetected int `json:"undetected"`
+	Harmless   int `json:"harmless"`
+}
+
+// VirusTotalResult is a result of scanning one file
+type VirusTotalResult struct {
+	Name      string           `json:"name"`
+	Sha256    string           `json:"sha256"`
+	Permalink string           `json:"permalink"`
+	Stats     *VirusTotalStats `json:"stats"`
+	// engine => name of detection
+	Detections map[string]string `json:"detections,omitempty"`
+}
+
+func (r *VirusTotalResult) nDetections() int {
+	return r.Stats.Malicious + r.Stats.Suspicious
+}
+
+func getVirusTotalPermalink(sha256 string) string {
+	return "https://www.virustotal.com/gui/file/" + sha256
+}
+
+// sends request with api key, returns nil body for 404
+func virusTotalDo(req *http.Request) ([]byte, error) {
+	req.Header.Set("x-apikey", virusTotalAPIKey)
+	req.Header.Set("Accept", "application/json")
+	client := &http.Client{Timeout: 10 * time.Minute}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	d, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if rsp.StatusCode >= 400 {
+		return nil, fmt.Errorf("virustotal: %s %s failed with status code %d, body: '%s'", req.Method, req.URL, rsp.StatusCode, string(d))
+	}
+	return d, nil
+}
+
+func virusTotalGet(path string, res interface{}) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, virusTotalAPIURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+	d, err := virusTotalDo(req)
+	if err != nil || d == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(d, res)
+}
+
+type virusTotalFileReport struct {
+	Data struct {
+		Attributes struct {
+			LastAnalysisDate    int64            `json:"last_analysis_date"`
+			LastAnalysisStats   *VirusTotalStats `json:"last_analysis_stats"`
+			LastAnalysisResults map[string]struct {
+				Category string `json:"category"`
+				Result   string `json:"result"`
+			} `json:"last_analysis_results"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+type virusTotalAnalysis struct {
+	Data struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Status string `json:"status"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// returns nil if VirusTotal didn't analyze the file yet
+func virusTotalGetReport(name string, sha256 string) (*VirusTotalResult, error) {
+	var rep virusTotalFileReport
+	ok, err := virusTotalGet("/files/"+sha256, &rep)
+	if err != nil || !ok {
+		return nil, err
+	}
+	attrs := rep.Data.Attributes
+	if attrs.LastAnalysisDate == 0 || attrs.LastAnalysisStats == nil {
+		return nil, nil
+	}
+	res := &VirusTotalResult{
+		Name:      name,
+		Sha256:    sha256,
+		Permalink: getVirusTotalPermalink(sha256),
+		Stats:     attrs.LastAnalysisStats,
+	}
+	for engine, r := range attrs.LastAnalysisResults {
+		if r.Category == "malicious" || r.Category == "suspicious" {
+			if res.Detections == nil {
+				res.Detections = map[string]string{}
+			}
+			res.Detections[engine] = r.Result
+		}
+	}
+	return res, nil
+}
+
+// returns id of the analysis
+func virusTotalUpload(path string) (string, error) {
+	uri := virusTotalAPIURL + "/files"
+	if fileSizeMust(path) > virusTotalMaxDirectUpload {
+		var rsp struct {
+			Data string `json:"data"`
+		}
+		ok, err := virusTotalGet("/files/upload_url", &rsp)
+		if err != nil {
+			return "", err
+		}
+		if !ok || rsp.Data == "" {
+			return "", fmt.Errorf("virustotal: didn't get upload url")
+		}
+		uri = rsp.Data
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile("file", filepath.Base(path))
+	must(err)
+	_, err = fw.Write(readFileMust(path))
+	must(err)
+	must(w.Close())
+	req, err := http.NewRequest(http.MethodPost, uri, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	d, err := virusTotalDo(req)
+	if err != nil {
+		return "", err
+	}
+	var rsp virusTotalAnalysis
+	if err = json.Unmarshal(d, &rsp); err != nil {
+		return "", err
+	}
+	if rsp.Data.ID == "" {
+		return "", fmt.Errorf("virustotal: no analysis id in response: '%s'", string(d))
+	}
+	return rsp.Data.ID, nil
+}
+
+func virusTotalScanFile(a *ArtifactInfo) (*VirusTotalResult, error) {
+	res, err := virusTotalGetReport(a.Name, a.Sha256)
+	if err != nil {
+		return nil, err
+	}
+	if res != nil {
+		logf("virustotal: '%s' already scanned\n", a.Name)
+		return res, nil
+	}
+	timeStart := time.Now()
+	id, err := virusTotalUpload(filepath.FromSlash(a.Path))
+	if err != nil {
+		return nil, err
+	}
+	logf("virustotal: uploaded '%s', waiting for analysis\n", a.Name)
+	for time.Since(timeStart) < virusTotalPollTimeout {
+		time.Sleep(virusTotalRequestDelay * 2)
+		var an virusTotalAnalysis
+		if _, err = virusTotalGet("/analyses/"+id, &an); err != nil {
+			// might be temporary, keep polling
+			logf("virustotal: %s\n", err)
+			continue
+		}
+		if an.Data.Attributes.Status != "completed" {
+			continue
+		}
+		time.Sleep(virusTotalRequestDelay)
+		res, err = virusTotalGetReport(a.Name, a.Sha256)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			return nil, fmt.Errorf("virustotal: analysis of '%s' completed but there's no report", a.Name)
+		}
+		logf("virustotal: scanned '%s' in %s\n", a.Name, time.Since(timeStart))
+		return res, nil
+	}
+	return nil, fmt.Errorf("virustotal: analysis of '%s' didn't finish in %s", a.Name, virusTotalPollTimeout)
+}
+
+func readVirusTotalResults(dir string) []*VirusTotalResult {
+	d, err := os.ReadFile(filepath.Join(dir, virusTotalResultsName))
+	if err != nil {
+		return nil
+	}
+	var res []*VirusTotalResult
+	must(json.Unmarshal(d, &res))
+	return res
+}
+
+// markdown for release notes
+func genVirusTotalNotes(results []*VirusTotalResult) string {
+	if len(results) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\n#### VirusTotal scans\n\n")
+	for _, r := range results {
+		total := r.Stats.Malicious + r.Stats.Suspicious + r.Stats.Undetected + r.Stats.Harmless
+		fmt.Fprintf(&sb, "- [%s](%s): %d / %d\n", r.Name, r.Permalink, r.nDetections(), total)
+	}
+	return sb.String()
+}
+
+// returns error if scanning failed or a file was flagged
+func scanWithVirusTotal(buildType BuildType) error {
+	defer makePrintDuration("scanWithVirusTotal")()
+	dir := getFinalDirForBuildType(buildType)
+	am := readArtifactsManifestMust(dir)
+	var results []*VirusTotalResult
+	var flagged []string
+	var scanErr error
+	for _, a := range am.Artifacts {
+		if a.Kind != kArtifactInstaller {
+			continue
+		}
+		r, err := virusTotalScanFile(a)
+		if err != nil {
+			scanErr = err
+			break
+		}
+		results = append(results, r)
+		logf("virustotal: '%s' flagged by %d engines %s\n", a.Name, r.nDetections(), r.Permalink)
+		for engine, s := range r.Detections {
+			logf("  %s: %s\n", engine, s)
+		}
+		if r.nDetections() > virusTotalMaxDetections {
+			flagged = append(flagged, fmt.Sprintf("%s: %d %s", a.Name, r.nDetections(), r.Permalink))
+		}
+		time.Sleep(virusTotalRequestDelay)
+	}
+	// save what we have even if scanning failed
+	d, err := json.MarshalIndent(results, "", "  ")
+	must(err)
+	writeFileMust(filepath.Join(dir, virusTotalResultsName), d)
+
+	if scanErr != nil {
+		return scanErr
+	}
+	if len(flagged) > 0 {
+		return fmt.Errorf("virustotal: files flagged by more than %d engines:\n%s", virusTotalMaxDetections, strings.Join(flagged, "\n"))
+	}
+	return nil
+}
+
+// scans installers in final build directory. Should be called once, after
+// all platforms are built. Release build fails on errors, pre-release only warns
+func scanWithVirusTotalMust(buildType BuildType) {
+	if virusTotalAPIKey == "" {
+		logf("Not scanning with VirusTotal because VIRUSTOTAL_API_KEY env variable not set\n")
+		return
+	}
+	if dryRunSkip("scan %s build with VirusTotal", buildType) {
+		return
+	}
+	err := scanWithVirusTotal(buildType)
+	if err == nil {
+		return
+	}
+	panicIf(buildType == buildTypeRel, "%s", err)
+	logf("WARNING: %s\n", err)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// www.sumatrapdfreader.org is generated from this repo:
+//   - website/pages/${name}.md : pages, like home page (free-pdf-reader.md,
+//     also index.html), rendered to ${name}.html
+//   - website/pages/${name}.${lang}.md : translation of a page, ${lang} is
+//     our language code (see trans_langs.go), rendered to ${iso}/${name}.html
+//     where ${iso} is ISO code of the language e.g. "pt-br" for "br"
+//   - docs/md : manual, rendered to docs/${name}.html
+//   - website/posts : news, see website_posts.go
+//   - download page, from artifacts.json of the latest release
+//   - releases feed, versions.json and translators pages
+//   - css, favicon and public keys for verifying downloads
+//
+// All html pages share website/tmpl/layout.tmpl.html. Translated pages link
+// to each other with hreflang and have a language switcher.
+//
+// -gen-website (or -gen-website-docs) writes the site to out/website, to
+// preview locally.
+// -deploy-website also copies it to www directory of ../sumatra-website repo,
+// checks it in, pushes (which deploys it) and purges changed pages from CDN.
+
+const (
+	websiteLayoutPath = "website/tmpl/layout.tmpl.html"
+	websitePagesDir   = "website/pages"
+	websiteHomePage   = "free-pdf-reader"
+)
+
+var websiteOutDir = filepath.Join("out", "website")
+
+// WebsiteNavItem is a link in navigation bar of every page
+type WebsiteNavItem struct {
+	ID       string
+	Title    string
+	URL      string
+	Selected bool
+}
+
+var websiteNav = []*WebsiteNavItem{
+	{ID: "home", Title: "Home", URL: "/" + websiteHomePage},
+	{ID: "download", Title: "Download", URL: "/download-free-pdf-viewer"},
+	{ID: "docs", Title: "Manual", URL: "/docs/SumatraPDF-documentation"},
+	{ID: "news", Title: "News", URL: "/news"},
+	{ID: "forum", Title: "Forum", URL: "https://github.com/sumatrapdfreader/sumatrapdf/discussions"},
+}
+
+// WebsitePageVariant is a page in one of the languages it's translated to
+type WebsitePageVariant struct {
+	// ISO code e.g. "pt-BR"
+	Lang string
+	// name of the language in that language e.g. "Deutsch"
+	Name     string
+	URL      string
+	Selected bool
+}
+
+// WebsitePage is a page rendered with the layout
+type WebsitePage struct {
+	Title string
+	// ISO code of page language, "" means "en"
+	Lang  string
+	IsRtl bool
+	// translations of the page, including this one, empty if not translated
+	Variants []*WebsitePageVariant
+	// id of selected item in navigation bar, "" if none
+	Nav string
+	// extra elements in <head>
+	Head    template.HTML
+	Content template.HTML
+}
+
+func renderWebsitePageMust(p *WebsitePage) []byte {
+	tmpl := template.Must(template.ParseFiles(websiteLayoutPath))
+	var nav []*WebsiteNavItem
+	for _, item := range websiteNav {
+		v := *item
+		v.Selected = v.ID == p.Nav
+		nav = append(nav, &v)
+	}
+	lang := p.Lang
+	if lang == "" {
+		lang = "en"
+	}
+	v := map[string]interface{}{
+		"Lang":     lang,
+		"IsRtl":    p.IsRtl,
+		"Variants": p.Variants,
+		"Title":    p.Title,
+		"Head":     p.Head,
+		"Content":  p.Content,
+		"Nav":      nav,
+	}
+	var buf bytes.Buffer
+	must(tmpl.Execute(&buf, v))
+	return buf.Bytes()
+}
+
+// title is the first "# " heading
+func getMarkdownTitle(md string) string {
+	for _, l := range strings.Split(normalizeNewlines(md), "\n") {
+		if s, ok := strings.CutPrefix(l, "# "); ok {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+// "German (Deutsch)" => "Deutsch", "English" => "English"
+func getLangNativeName(lang string) string {
+	name := getLangName(lang)
+	if _, native, ok := strings.Cut(name, " ("); ok {
+		return strings.TrimSuffix(native, ")")
+	}
+	return name
+}
+
+// "free-pdf-reader.de.md" => "free-pdf-reader", "de"
+// "free-pdf-reader.md" => "free-pdf-reader", "en"
+func parseWebsitePageFileName(path string) (string, string) {
+	name := strings.TrimSuffix(filepath.Base(path), ".md")
+	if base, lang, ok := strings.Cut(name, "."); ok {
+		panicIf(!isKnownLang(lang), "'%s': unknown language '%s', see gLangs in trans_langs.go", path, lang)
+		return base, lang
+	}
+	return name, "en"
+}
+
+// url path of page name in lang, without .html
+func getWebsitePageURLPath(name string, lang string) string {
+	if lang == "en" {
+		return name
+	}
+	return strings.ToLower(getLangISOCode(lang)) + "/" + name
+}
+
+func genWebsitePagesMust(outDir string) {
+	paths, err := filepath.Glob(filepath.Join(websitePagesDir, "*.md"))
+	must(err)
+	sort.Strings(paths)
+	// page name => lang => path
+	pages := map[string]map[string]string{}
+	var names []string
+	for _, path := range paths {
+		name, lang := parseWebsitePageFileName(path)
+		if pages[name] == nil {
+			pages[name] = map[string]string{}
+			names = append(names, name)
+		}
+		pages[name][lang] = path
+	}
+	for _, name := range names {
+		byLang := pages[name]
+		panicIf(byLang["en"] == "", "'%s' is translated but there's no '%s.md'", name, name)
+		var langs []string
+		for lang := range byLang {
+			langs = append(langs, lang)
+		}
+		// English first, then by ISO code
+		sort.Slice(langs, func(i, j int) bool {
+			if langs[i] == "en" || langs[j] == "en" {
+				return langs[i] == "en"
+			}
+			return getLangISOCode(langs[i]) < getLangISOCode(langs[j])
+		})
+		for _, lang := range langs {
+			genWebsitePageMust(outDir, byLang[lang], name, lang, langs)
+		}
+	}
+}
+
+// langs are all languages the page is translated to
+func genWebsitePageMust(outDir string, path string, name string, lang string, langs []string) {
+	md := string(readFileMust(path))
+	title := getMarkdownTitle(md)
+	panicIf(title == "", "'%s' must start with '# ${title}'", path)
+	p := &WebsitePage{
+		Title:   title,
+		Lang:    getLangISOCode(lang),
+		IsRtl:   isRtlLang(lang),
+		Content: template.HTML(`<div class="notion-page">` + markdownToHTML(md) + `</div>`),
+	}
+	if len(langs) > 1 {
+		for _, l := range langs {
+			v := &WebsitePageVariant{
+				Lang:     getLangISOCode(l),
+				Name:     getLangNativeName(l),
+				URL:      websiteURL + getWebsitePageURLPath(name, l),
+				Selected: l == lang,
+			}
+			p.Variants = append(p.Variants, v)
+		}
+	}
+	urlPaths := []string{getWebsitePageURLPath(name, lang)}
+	if name == websiteHomePage {
+		p.Nav = "home"
+		urlPaths = append(urlPaths, getWebsitePageURLPath("index", lang))
+	}
+	d := renderWebsitePageMust(p)
+	for _, urlPath := range urlPaths {
+		dst := filepath.Join(outDir, filepath.FromSlash(urlPath)+".html")
+		must(os.MkdirAll(filepath.Dir(dst), 0755))
+		writeFileMust(dst, d)
+		logf("wrote '%s'\n", dst)
+	}
+}
+
+// manifest of the latest release from local release build or from storage,
+// nil if we can't get it
+func getWebsiteArtifactsManifest() *ArtifactsManifest {
+	if am := readArtifactsManifest(getFinalDirForBuildType(buildTypeRel)); am != nil && am.BuildType == buildTypeRel {
+		return am
+	}
+	if r2Access == "" || r2Secret == "" {
+		return nil
+	}
+	storage := newR2Storage()
+	m := downloadUpdateManifest(storage)
+	if m == nil || m.Stable == nil {
+		return nil
+	}
+	return downloadArtifactsManifest(storage, buildTypeRel, m.Stable.Version)
+}
+
+func copyWebsiteStaticFilesMust(outDir string) {
+	copyFileMustOverwrite = true
+	for _, name := range []string{"notion.css", "sumatra.css", "favicon.ico"} {
+		copyFileMust(filepath.Join(outDir, name), filepath.Join("docs", "www", name))
+	}
+	// public keys for verifying detached signatures of downloads
+	for _, srcPath := range []string{minisignPublicKeyPath, gpgPublicKeyPath} {
+		if fileExists(srcPath) {
+			copyFileMust(filepath.Join(outDir, filepath.Base(srcPath)), srcPath)
+		}
+	}
+}
+
+// generates the whole website in websiteOutDir
+func genWebsiteMust() {
+	logf("generating website in '%s'\n", websiteOutDir)
+	must(os.RemoveAll(websiteOutDir))
+	must(os.MkdirAll(websiteOutDir, 0755))
+
+	copyWebsiteStaticFilesMust(websiteOutDir)
+	genWebsitePagesMust(websiteOutDir)
+	genWebsitePostsMust(websiteOutDir)
+
+	// don't use .html extension in links to generated .html files
+	// for docs in the app we need them because they are shown from file system
+	// for website we prefer "clean" links because they are served via web server
+	docsForWebsite = true
+	mdHTMLExt = false
+	genHTMLDocsFromMarkdown()
+	docsForWebsite = false
+	mdHTMLExt = true
+
+	if am := getWebsiteArtifactsManifest(); am != nil {
+		writeDownloadPageMust(websiteOutDir, am)
+	} else {
+		logf("Not writing %s because there's no release build in out/ and R2_ACCESS or R2_SECRET env variable not set\n", downloadPageName)
+	}
+	writeWebsiteFeedsMust(websiteOutDir)
+	writeTransReferenceMust(websiteOutDir)
+	writeTranslatorsPageMust(websiteOutDir)
+
+	path, err := filepath.Abs(filepath.Join(websiteOutDir, "index.html"))
+	must(err)
+	logf("To preview, open:\nfile://%s\n", path)
+}
+
+// "docs/Commands.html" => "docs/Commands", "docs/Commands.html"
+// "news/index.html" => "news", "news/", "news/index.html"
+func getWebsiteURLPaths(path string) []string {
+	path = filepath.ToSlash(path)
+	if path == "index.html" {
+		return []string{"", path}
+	}
+	if dir, ok := strings.CutSuffix(path, "/index.html"); ok {
+		return []string{dir, dir + "/", path}
+	}
+	if s, ok := strings.CutSuffix(path, ".html"); ok {
+		return []string{s, path}
+	}
+	return []string{path}
+}
+
+// generates the website, copies it to website repo, checks in and pushes
+func deployWebsiteMust() {
+	genWebsiteMust()
+	wwwDir := updateSumatraWebsite()
+	websiteDir := filepath.Dir(filepath.Dir(wwwDir))
+
+	copyFileMustOverwrite = true
+	copyFilesRecurMust(wwwDir, websiteOutDir)
+
+	// "server/www/docs/Commands.html" is relative to website repo
+	out := runExeInDirMust(wwwDir, "git", "status", "--porcelain", "--untracked-files=all", ".")
+	var urls []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if len(l) < 4 {
+			continue
+		}
+		path, ok := strings.CutPrefix(strings.TrimSpace(l[3:]), "server/www/")
+		if !ok {
+			continue
+		}
+		for _, p := range getWebsiteURLPaths(path) {
+			urls = append(urls, cdnWebsiteURL+p)
+		}
+	}
+	if len(urls) == 0 {
+		logf("website didn't change\n")
+		return
+	}
+	msg := fmt.Sprintf("update website from sumatrapdf %s", getGitSha1())
+	runExeInDirMust(websiteDir, "git", "add", "-A", ".")
+	runExeInDirMust(websiteDir, "git", "commit", "-m", msg)
+	runExeInDirMust(websiteDir, "git", "push", "origin", "master")
+	logf("deployed website, %d urls changed\n", len(urls))
+
+	if cloudflareZoneID == "" || cloudflareAPIToken == "" {
+		logf("Not purging CDN cache because CLOUDFLARE_ZONE_ID or CLOUDFLARE_API_TOKEN env variable not set\n")
+		return
+	}
+	purgeCdnURLsMust(urls)
+}
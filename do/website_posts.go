@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// News on the website, written as markdown files in website/posts, with
+// front matter:
+//
+//	---
+//	title: SumatraPDF 3.5.2 released
+//	date: 2023-10-25
+//	---
+//
+// Optional front matter fields:
+//   - slug  : last part of url, defaults to file name without .md
+//   - draft : if "true", post is not published
+//
+// A post is rendered to news/${year}/${month}/${slug}.html, the list of
+// posts to news/index.html and Atom feed of the latest posts to news.xml.
+// Generated as part of the website (-gen-website).
+
+const (
+	websitePostsDir = "website/posts"
+	newsFeedName    = "news.xml"
+	nPostsInFeed    = 20
+)
+
+// WebsitePost is a news post
+type WebsitePost struct {
+	Title string
+	// "2023-10-25"
+	Date  string
+	Slug  string
+	Draft bool
+	// markdown
+	Body string
+}
+
+// "news/2023/10/sumatrapdf-3-5-2"
+func (p *WebsitePost) URLPath() string {
+	return fmt.Sprintf("news/%s/%s/%s", p.Date[:4], p.Date[5:7], p.Slug)
+}
+
+func (p *WebsitePost) URL() string {
+	return websiteURL + p.URLPath()
+}
+
+// splits "---\n${front matter}\n---\n${body}" into front matter fields
+// and body
+func parseFrontMatter(s string) (map[string]string, string, bool) {
+	s = normalizeNewlines(s)
+	rest, ok := strings.CutPrefix(s, "---\n")
+	if !ok {
+		return nil, s, false
+	}
+	fm, body, ok := strings.Cut(rest, "\n---\n")
+	if !ok {
+		return nil, s, false
+	}
+	res := map[string]string{}
+	for _, l := range strings.Split(fm, "\n") {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok {
+			continue
+		}
+		res[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return res, body, true
+}
+
+func readWebsitePostMust(path string) *WebsitePost {
+	fm, body, ok := parseFrontMatter(string(readFileMust(path)))
+	panicIf(!ok, "'%s' doesn't start with front matter", path)
+	p := &WebsitePost{
+		Title: fm["title"],
+		Date:  fm["date"],
+		Slug:  fm["slug"],
+		Draft: fm["draft"] == "true",
+		Body:  strings.TrimSpace(body),
+	}
+	panicIf(p.Title == "", "'%s' has no title in front matter", path)
+	_, err := time.Parse("2006-01-02", p.Date)
+	panicIf(err != nil, "'%s' has invalid date '%s' in front matter, must be YYYY-MM-DD", path, p.Date)
+	if p.Slug == "" {
+		p.Slug = strings.TrimSuffix(filepath.Base(path), ".md")
+	}
+	return p
+}
+
+// published posts, newest first
+func readWebsitePostsMust() []*WebsitePost {
+	paths, err := filepath.Glob(filepath.Join(websitePostsDir, "*.md"))
+	must(err)
+	var res []*WebsitePost
+	seen := map[string]string{}
+	for _, path := range paths {
+		p := readWebsitePostMust(path)
+		if p.Draft {
+			logf("skipping draft '%s'\n", path)
+			continue
+		}
+		prev := seen[p.URLPath()]
+		panicIf(prev != "", "'%s' and '%s' have the same url", prev, path)
+		seen[p.URLPath()] = path
+		res = append(res, p)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Date != res[j].Date {
+			return res[i].Date > res[j].Date
+		}
+		return res[i].Slug < res[j].Slug
+	})
+	return res
+}
+
+const newsFeedHead = `<link rel="alternate" type="application/atom+xml" title="SumatraPDF news" href="/` + newsFeedName + `" />`
+
+func genWebsitePostPage(p *WebsitePost) []byte {
+	var b strings.Builder
+	b.WriteString(`<div class="notion-page">`)
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(p.Title))
+	fmt.Fprintf(&b, "<p class=\"post-date\">%s</p>\n", p.Date)
+	b.WriteString(markdownToHTML(p.Body))
+	b.WriteString(`<p><a href="/news">All news</a></p>`)
+	b.WriteString(`</div>`)
+	return renderWebsitePageMust(&WebsitePage{
+		Title:   p.Title,
+		Nav:     "news",
+		Head:    template.HTML(newsFeedHead),
+		Content: template.HTML(b.String()),
+	})
+}
+
+func genWebsiteNewsIndex(posts []*WebsitePost) []byte {
+	var b strings.Builder
+	b.WriteString(`<div class="notion-page">`)
+	b.WriteString("<h1>SumatraPDF news</h1>\n")
+	fmt.Fprintf(&b, "<p>Subscribe with <a href=\"/%s\">Atom feed</a>.</p>\n", newsFeedName)
+	b.WriteString("<ul>\n")
+	for _, p := range posts {
+		fmt.Fprintf(&b, "<li>%s: <a href=\"/%s\">%s</a></li>\n", p.Date, p.URLPath(), html.EscapeString(p.Title))
+	}
+	b.WriteString("</ul>\n")
+	b.WriteString(`</div>`)
+	return renderWebsitePageMust(&WebsitePage{
+		Title:   "SumatraPDF news",
+		Nav:     "news",
+		Head:    template.HTML(newsFeedHead),
+		Content: template.HTML(b.String()),
+	})
+}
+
+func genNewsFeed(posts []*WebsitePost) string {
+	feed := &AtomFeed{
+		Title: "SumatraPDF news",
+		ID:    websiteURL + newsFeedName,
+		Links: []*AtomLink{
+			{Href: websiteURL + newsFeedName, Rel: "self", Type: "application/atom+xml"},
+			{Href: websiteURL + "news", Rel: "alternate", Type: "text/html"},
+		},
+		Author: &AtomAuthor{Name: "SumatraPDF"},
+	}
+	for _, p := range posts {
+		if len(feed.Entries) == nPostsInFeed {
+			break
+		}
+		e := &AtomEntry{
+			Title:   p.Title,
+			ID:      p.URL(),
+			Updated: atomDate(p.Date),
+			Link:    &AtomLink{Href: p.URL(), Rel: "alternate", Type: "text/html"},
+			Content: &AtomContent{Type: "html", Body: markdownToHTML(p.Body)},
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	if len(feed.Entries) > 0 {
+		feed.Updated = feed.Entries[0].Updated
+	} else {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+	d, err := xml.MarshalIndent(feed, "", "  ")
+	must(err)
+	return xml.Header + string(d) + "\n"
+}
+
+// outDir is root of the website
+func genWebsitePostsMust(outDir string) {
+	posts := readWebsitePostsMust()
+	for _, p := range posts {
+		path := filepath.Join(outDir, filepath.FromSlash(p.URLPath())+".html")
+		must(os.MkdirAll(filepath.Dir(path), 0755))
+		writeFileMust(path, genWebsitePostPage(p))
+		logf("wrote '%s'\n", path)
+	}
+	path := filepath.Join(outDir, "news", "index.html")
+	must(os.MkdirAll(filepath.Dir(path), 0755))
+	writeFileMust(path, genWebsiteNewsIndex(posts))
+	logf("wrote '%s', %d posts\n", path, len(posts))
+
+	path = filepath.Join(outDir, newsFeedName)
+	writeFileMust(path, []byte(genNewsFeed(posts)))
+	logf("wrote '%s'\n", path)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// -winget [submit] generates winget manifests for release build in out/
+// and validates them with winget validate. With submit it also opens a pull
+// request against microsoft/winget-pkgs.
+//
+// Manifests are in multi-file format:
+// https://github.com/microsoft/winget-pkgs/tree/master/doc/manifest/schema/1.6.0
+// and written to out/winget/${ver}
+
+const (
+	wingetPackageID       = "SumatraPDF.SumatraPDF"
+	wingetManifestVersion = "1.6.0"
+	wingetPkgsRepo        = "microsoft/winget-pkgs"
+)
+
+// arch as understood by winget
+var wingetArchs = map[string]string{
+	"32":    "x86",
+	"64":    "x64",
+	"arm64": "arm64",
+}
+
+// returns text of section in docs/md/Version-history.md for a given
+// version and its date e.g. "2023-10-25" (or "" if section doesn't have it)
+func getReleaseNotesFromVersionHistory(ver string) (string, string) {
+	path := filepath.Join("docs", "md", "Version-history.md")
+	lines, err := readLinesFromFile(path)
+	must(err)
+	rxHdr := regexp.MustCompile(`^### ` + regexp.QuoteMeta(ver) + `(?:\s+\((\d{4}-\d{2}-\d{2})\))?\s*$`)
+	var notes []string
+	date := ""
+	inSection := false
+	for _, l := range lines {
+		if inSection {
+			if strings.HasPrefix(l, "### ") {
+				break
+			}
+			notes = append(notes, l)
+			continue
+		}
+		if m := rxHdr.FindStringSubmatch(l); m != nil {
+			inSection = true
+			date = m[1]
+		}
+	}
+	panicIf(!inSection, "didn't find section for version '%s' in '%s'", ver, path)
+	return strings.TrimSpace(strings.Join(notes, "\n")), date
+}
+
+// formats multi-line text as YAML literal block, indented by indent spaces
+func yamlBlock(s string, indent int) string {
+	pad := strings.Repeat(" ", indent)
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = pad + l
+		}
+	}
+	return "|-\n" + strings.Join(lines, "\n")
+}
+
+// WingetInstaller is a single entry in Installers in installer manifest
+type WingetInstaller struct {
+	Arch   string
+	Scope  string
+	URL    string
+	Sha256 string
+	// extra switches for this installer
+	Custom string
+}
+
+const wingetVersionTmpl = `# yaml-language-server: $schema=https://aka.ms/winget-manifest.version.{{.ManifestVersion}}.schema.json
+
+PackageIdentifier: {{.ID}}
+PackageVersion: {{.Version}}
+DefaultLocale: en-US
+ManifestType: version
+ManifestVersion: {{.ManifestVersion}}
+`
+
+const wingetInstallerTmpl = `# yaml-language-server: $schema=https://aka.ms/winget-manifest.installer.{{.ManifestVersion}}.schema.json
+
+PackageIdentifier: {{.ID}}
+PackageVersion: {{.Version}}
+InstallerType: exe
+InstallModes:
+- interactive
+- silent
+- silentWithProgress
+InstallerSwitches:
+  Silent: -install -s
+  SilentWithProgress: -install -s
+UpgradeBehavior: install
+ProductCode: SumatraPDF
+{{- if .ReleaseDate}}
+ReleaseDate: {{.ReleaseDate}}
+{{- end}}
+FileExtensions:
+{{- range .Exts}}
+- {{.}}
+{{- end}}
+Installers:
+{{- range .Installers}}
+- Architecture: {{.Arch}}
+  Scope: {{.Scope}}
+  InstallerUrl: {{.URL}}
+  InstallerSha256: {{.Sha256}}
+{{- if .Custom}}
+  InstallerSwitches:
+    Custom: {{.Custom}}
+{{- end}}
+{{- end}}
+ManifestType: installer
+ManifestVersion: {{.ManifestVersion}}
+`
+
+const wingetLocaleTmpl = `# yaml-language-server: $schema=https://aka.ms/winget-manifest.defaultLocale.{{.ManifestVersion}}.schema.json
+
+PackageIdentifier: {{.ID}}
+PackageVersion: {{.Version}}
+PackageLocale: en-US
+Publisher: Krzysztof Kowalczyk
+PublisherUrl: https://www.sumatrapdfreader.org
+PublisherSupportUrl: https://github.com/sumatrapdfreader/sumatrapdf/issues
+Author: Krzysztof Kowalczyk
+PackageName: SumatraPDF
+PackageUrl: https://www.sumatrapdfreader.org
+License: GPL-3.0
+LicenseUrl: https://github.com/sumatrapdfreader/sumatrapdf/blob/master/COPYING
+ShortDescription: PDF, eBook (epub, mobi), comic book (cbz/cbr), DjVu, XPS, CHM, image viewer for Windows
+Moniker: sumatrapdf
+Tags:
+- pdf
+- epub
+- mobi
+- djvu
+- comics
+- ebook-reader
+- pdf-viewer
+ReleaseNotes: {{.ReleaseNotes}}
+ReleaseNotesUrl: https://www.sumatrapdfreader.org/docs/Version-history
+ManifestType: defaultLocale
+ManifestVersion: {{.ManifestVersion}}
+`
+
+// returns path in winget-pkgs repo => content
+func genWingetManifestsMust(am *ArtifactsManifest) map[string][]byte {
+	panicIf(am.BuildType != buildTypeRel, "winget manifests can only be generated for release builds, not '%s'", am.BuildType)
+	ver := am.Version
+	prefix := getDownloadPrefixViaWebsite(buildTypeRel, ver)
+	var installers []*WingetInstaller
+	for _, arch := range []string{"32", "64", "arm64"} {
+		a := am.find(kArtifactInstaller, arch)
+		if a == nil {
+			continue
+		}
+		for _, scope := range []string{"user", "machine"} {
+			inst := &WingetInstaller{
+				Arch:   wingetArchs[arch],
+				Scope:  scope,
+				URL:    prefix + a.Name,
+				Sha256: strings.ToUpper(a.Sha256),
+			}
+			if scope == "machine" {
+				inst.Custom = "-all-users"
+			}
+			installers = append(installers, inst)
+		}
+	}
+	panicIf(len(installers) == 0, "no installers in %s", artifactsManifestName)
+
+	var exts []string
+	for _, ext := range getSupportedFileExtsMust() {
+		exts = append(exts, ext[1:])
+	}
+	notes, date := getReleaseNotesFromVersionHistory(ver)
+	v := struct {
+		ID              string
+		Version         string
+		ManifestVersion string
+		ReleaseDate     string
+		ReleaseNotes    string
+		Exts            []string
+		Installers      []*WingetInstaller
+	}{
+		ID:              wingetPackageID,
+		Version:         ver,
+		ManifestVersion: wingetManifestVersion,
+		ReleaseDate:     date,
+		ReleaseNotes:    yamlBlock(notes, 2),
+		Exts:            exts,
+		Installers:      installers,
+	}
+	// manifests/s/SumatraPDF/SumatraPDF/3.5.2/
+	dir := path.Join("manifests", strings.ToLower(wingetPackageID[:1]), strings.ReplaceAll(wingetPackageID, ".", "/"), ver)
+	return map[string][]byte{
+		path.Join(dir, wingetPackageID+".yaml"):              []byte(execTextTemplate(wingetVersionTmpl, v)),
+		path.Join(dir, wingetPackageID+".installer.yaml"):    []byte(execTextTemplate(wingetInstallerTmpl, v)),
+		path.Join(dir, wingetPackageID+".locale.en-US.yaml"): []byte(execTextTemplate(wingetLocaleTmpl, v)),
+	}
+}
+
+func validateWingetManifestsMust(dir string) {
+	wingetPath, err := exec.LookPath("winget")
+	panicIf(err != nil, "didn't find winget.exe, needed to validate manifests")
+	cmd := exec.Command(wingetPath, "validate", "--manifest", dir)
+	runCmdLoggedMust(cmd)
+}
+
+func wingetMust(submit bool) {
+	dir := getFinalDirForBuildType(buildTypeRel)
+	am := readArtifactsManifestMust(dir)
+	files := genWingetManifestsMust(am)
+
+	outDir := filepath.Join("out", "winget", am.Version)
+	must(os.RemoveAll(outDir))
+	must(os.MkdirAll(outDir, 0755))
+	for p, d := range files {
+		writeFileMust(filepath.Join(outDir, path.Base(p)), d)
+	}
+	logf("wrote winget manifests to '%s'\n", outDir)
+	validateWingetManifestsMust(outDir)
+	if !submit {
+		return
+	}
+
+	pr := &GithubPR{
+		Upstream: wingetPkgsRepo,
+		Base:     "master",
+		Branch:   fmt.Sprintf("%s-%s", wingetPackageID, am.Version),
+		Title:    fmt.Sprintf("New version: %s version %s", wingetPackageID, am.Version),
+		Body:     "Submitted by SumatraPDF release tooling.",
+		Files:    files,
+	}
+	githubCreatePRMust(pr)
+}
@@ -1041,6 +1041,39 @@ static void DrawDocument(MainWindow* win, HDC hdc, RECT* rcArea) {
     }
 }
 
+// set with -first-paint-marker, for measuring startup time (do -startup-bench)
+static char* gFirstPaintMarkerPath = nullptr;
+static bool gFirstPaintMarkerWaitForDocument = false;
+
+void SetFirstPaintMarker(const char* path, bool waitForDocument) {
+    str::ReplaceWithCopy(&gFirstPaintMarkerPath, path);
+    gFirstPaintMarkerWaitForDocument = waitForDocument;
+}
+
+static i64 FileTimeToInt64(const FILETIME& ft) {
+    ULARGE_INTEGER n;
+    n.LowPart = ft.dwLowDateTime;
+    n.HighPart = ft.dwHighDateTime;
+    return (i64)n.QuadPart;
+}
+
+// on first paint writes milliseconds since the process started to marker file
+// and exits
+void FirstPaintMarker(bool isDocument) {
+    if (!gFirstPaintMarkerPath || (gFirstPaintMarkerWaitForDocument && !isDocument)) {
+        return;
+    }
+    FILETIME creation, exitTime, kernel, user, now;
+    GetProcessTimes(GetCurrentProcess(), &creation, &exitTime, &kernel, &user);
+    GetSystemTimeAsFileTime(&now);
+    // FILETIME is in 100 ns units
+    i64 ms = (FileTimeToInt64(now) - FileTimeToInt64(creation)) / 10000;
+    TempStr s = str::FormatTemp("%d\n", (int)ms);
+    file::WriteFile(gFirstPaintMarkerPath, ToByteSlice(s));
+    str::FreePtr(&gFirstPaintMarkerPath);
+    PostQuitMessage(0);
+}
+
 static void OnPaintDocument(MainWindow* win) {
     auto t = TimeGet();
     PAINTSTRUCT ps;
@@ -1062,6 +1095,7 @@ static void OnPaintDocument(MainWindow* win) {
     if (gShowFrameRate) {
         win->frameRateWnd->ShowFrameRateDur(TimeSinceInMs(t));
     }
+    FirstPaintMarker(true);
 }
 
 static void SetTextOrArrorCursor(DisplayModel* dm, Point pt) {
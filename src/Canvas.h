@@ -7,5 +7,7 @@ LRESULT CALLBACK WndProcCanvas(HWND, UINT, WPARAM, LPARAM);
 LRESULT WndProcCanvasAbout(MainWindow*, HWND, UINT, WPARAM, LPARAM);
 bool IsDragDistance(int x1, int x2, int y1, int y2);
 void CancelDrag(MainWindow*);
+void SetFirstPaintMarker(const char* path, bool waitForDocument);
+void FirstPaintMarker(bool isDocument);
 
 extern Kind kNotifGroupAnnotation;
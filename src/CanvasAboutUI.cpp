@@ -45,6 +45,7 @@ static void OnPaintAbout(MainWindow* win) {
     if (gShowFrameRate) {
         win->frameRateWnd->ShowFrameRateDur(TimeSinceInMs(t));
     }
+    FirstPaintMarker(false);
 }
 
 static void OnMouseLeftButtonDownAbout(MainWindow* win, int x, int y, WPARAM) {
@@ -181,6 +181,43 @@ static void UploadCrashReport(const ByteSlice& d) {
     HttpPost(kCrashHandlerServer, kCrashHandlerServerPort, kCrashHandlerServerSubmitURL, &headers, &data);
 }
 
+// crash reporting service (Sentry, Backtrace) that accepts minidumps is
+// configured by build script in BuildConfig.h, see do/crash_service.go
+#if defined(CRASH_SUBMIT_SERVER)
+static void UploadMiniDump() {
+    log("UploadMiniDump()\n");
+    if (!gIsPreReleaseBuild || gIsDebugBuild) {
+        // minidumps have contents of memory, only send them from pre-release
+        // builds whose users expect crashes to be reported
+        log("UploadMiniDump(): skipping because not a pre-release build\n");
+        return;
+    }
+    ByteSlice dump = file::ReadFileWithAllocator(gCrashDumpPath, gCrashHandlerAllocator);
+    if (dump.empty()) {
+        log("UploadMiniDump(): skipping because failed to read minidump\n");
+        return;
+    }
+
+    const char* boundary = "SumatraPDFMiniDumpBoundary";
+    str::Str headers(256, gCrashHandlerAllocator);
+    headers.AppendFmt("Content-Type: multipart/form-data; boundary=%s", boundary);
+
+    str::Str data(dump.size() + 1024, gCrashHandlerAllocator);
+    data.AppendFmt("--%s\r\n", boundary);
+    data.Append("Content-Disposition: form-data; name=\"version\"\r\n\r\n");
+    data.Append(currentVersion);
+    data.Append(" pre-release");
+    data.AppendFmt("\r\n--%s\r\n", boundary);
+    data.Append("Content-Disposition: form-data; name=\"upload_file_minidump\"; filename=\"SumatraPDF.dmp\"\r\n");
+    data.Append("Content-Type: application/octet-stream\r\n\r\n");
+    data.AppendSlice(dump);
+    data.AppendFmt("\r\n--%s--\r\n", boundary);
+    Allocator::Free(gCrashHandlerAllocator, (void*)dump.data());
+
+    HttpPost(CRASH_SUBMIT_SERVER, CRASH_SUBMIT_PORT, CRASH_SUBMIT_PATH, &headers, &data);
+}
+#endif
+
 static bool ExtractSymbols(const u8* archiveData, size_t dataSize, const char* dstDir, Allocator* allocator) {
     logf("ExtractSymbols: dir '%s', size: %d\n", dstDir, (int)dataSize);
     lzma::SimpleArchive archive;
@@ -392,6 +429,11 @@ static DWORD WINAPI CrashDumpThread(LPVOID) {
     bool fullDump = (0 != n);
     TempWStr ws = ToWStrTemp(gCrashDumpPath);
     dbghelp::WriteMiniDump(ws, &gMei, fullDump);
+#if defined(CRASH_SUBMIT_SERVER)
+    if (CrashHandlerCanUseNet()) {
+        UploadMiniDump();
+    }
+#endif
     return 0;
 }
 
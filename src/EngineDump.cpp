@@ -8,6 +8,7 @@
 #include "utils/GdiPlusUtil.h"
 #include "mui/Mui.h"
 #include "utils/TgaReader.h"
+#include "utils/Timer.h"
 #include "utils/WinUtil.h"
 
 #include "wingui/UIModels.h"
@@ -436,7 +437,32 @@ static bool CheckRenderPath(const char* path) {
     return true;
 }
 
-static bool RenderDocument(EngineBase* engine, const char* renderPath, float zoom = 1.f, bool silent = false) {
+// ranges is a comma separated list of pages ("3"), closed intervals ("2-4")
+// and intervals unlimited to the right ("5-"), nullptr means all pages
+static bool IsPageInRanges(const char* ranges, int pageNo) {
+    if (!ranges) {
+        return true;
+    }
+    StrVec rangeList;
+    Split(rangeList, ranges, ",", true);
+    for (char* rangeStr : rangeList) {
+        int start, end;
+        if (str::Parse(rangeStr, "%d-%d%$", &start, &end) && start <= pageNo && pageNo <= end) {
+            return true;
+        }
+        if (str::Parse(rangeStr, "%d-%$", &start) && start <= pageNo) {
+            return true;
+        }
+        if (str::Parse(rangeStr, "%d%$", &start) && start == pageNo) {
+            return true;
+        }
+    }
+    return false;
+}
+
+// with printTimes, prints how long rendering of every page took, for do -render-bench
+static bool RenderDocument(EngineBase* engine, const char* renderPath, float zoom = 1.f, bool silent = false,
+                           const char* pageRanges = nullptr, bool printTimes = false) {
     if (!CheckRenderPath(renderPath)) {
         return false;
     }
@@ -462,8 +488,15 @@ static bool RenderDocument(EngineBase* engine, const char* renderPath, float zoo
 
     bool success = true;
     for (int pageNo = 1; pageNo <= engine->PageCount(); pageNo++) {
+        if (!IsPageInRanges(pageRanges, pageNo)) {
+            continue;
+        }
         RenderPageArgs args(pageNo, zoom, 0);
+        auto t = TimeGet();
         RenderedBitmap* bmp = engine->RenderPage(args);
+        if (printTimes) {
+            Out("RenderTime page=%d ms=%.2f\n", pageNo, TimeSinceInMs(t));
+        }
         success &= bmp != nullptr;
         if (!bmp && !silent) {
             ErrOut("Error: Failed to render page %d for %s!", pageNo, engine->FilePath());
@@ -517,7 +550,7 @@ int main(int, char**) {
 
     if (nArgs < 2) {
     Usage:
-        ErrOut("%s [-pwd <password>][-quick][-render <path-%%d.tga>] <filename>",
+        ErrOut("%s [-pwd <password>][-quick][-render <path-%%d.tga>][-pages <ranges>][-times] <filename>",
                path::GetBaseNameTemp(argList.args[0]));
         return 2;
     }
@@ -528,6 +561,8 @@ int main(int, char**) {
     char* renderPath = nullptr;
     float renderZoom = 1.f;
     bool loadOnly = false, silent = false;
+    char* pageRanges = nullptr;
+    bool printTimes = false;
 
     for (int i = 1; i < nArgs; i++) {
         if (str::Eq(argList.at(i), "-pwd") && i + 1 < nArgs && !password) {
@@ -547,6 +582,12 @@ int main(int, char**) {
             loadOnly = true;
         } else if (str::Eq(argList.at(i), "-silent")) {
             silent = true;
+        } else if (str::Eq(argList.at(i), "-pages") && i + 1 < nArgs && !pageRanges) {
+            // only render these pages e.g. "1-3,7,10-"
+            pageRanges = argList.at(++i);
+        } else if (str::Eq(argList.at(i), "-times")) {
+            // print load and render times, for benchmarking
+            printTimes = true;
         } else if (str::Eq(argList.at(i), "-full")) {
             // -full is for backward compatibility
             fullDump = true;
@@ -582,16 +623,20 @@ int main(int, char**) {
     }
 
     PasswordHolder pwdUI(password);
+    auto t = TimeGet();
     EngineBase* engine = CreateEngineFromFile(filePath, &pwdUI, false);
     if (!engine) {
         ErrOut("Error: Couldn't create an engine for %s!", path::GetBaseNameTemp(filePath));
         return 1;
     }
+    if (printTimes) {
+        Out("LoadTime ms=%.2f\n", TimeSinceInMs(t));
+    }
     if (!loadOnly) {
         DumpData(engine, fullDump);
     }
     if (renderPath) {
-        RenderDocument(engine, renderPath, renderZoom, silent);
+        RenderDocument(engine, renderPath, renderZoom, silent, pageRanges, printTimes);
     }
     engine->Release();
 
@@ -26,6 +26,7 @@
     V(Help3, "help")                             \
     V(ExitWhenDone, "exit-when-done")            \
     V(ExitOnPrint, "exit-on-print")              \
+    V(ExitOnStartup, "exit-on-startup")          \
     V(Restrict, "restrict")                      \
     V(Presentation, "presentation")              \
     V(FullScreen, "fullscreen")                  \
@@ -72,6 +73,8 @@
     V(InstallDir, "install-dir")                 \
     V(Lang, "lang")                              \
     V(UpdateSelfTo, "update-self-to")            \
+    V(UpdateCheckURL, "update-check-url")        \
+    V(FirstPaintMarker, "first-paint-marker")    \
     V(ArgDeleteFile, "delete-file")              \
     V(BgCol, "bgcolor")                          \
     V(BgCol2, "bg-color")                        \
@@ -404,6 +407,11 @@ void ParseFlags(const WCHAR* cmdLine, Flags& i) {
             i.exitWhenDone = true;
             continue;
         }
+        if (arg == Arg::ExitOnStartup) {
+            // to test that the app starts e.g. after installation
+            i.exitOnStartup = true;
+            continue;
+        }
         if (arg == Arg::Restrict) {
             i.restrictedUse = true;
             continue;
@@ -665,6 +673,18 @@ void ParseFlags(const WCHAR* cmdLine, Flags& i) {
             i.updateSelfTo = str::Dup(param);
             continue;
         }
+#if defined(DEBUG) || defined(PRE_RELEASE_VER)
+        if (arg == Arg::UpdateCheckURL) {
+            // to test auto-update with a local server (do -serve-update-check)
+            i.updateCheckURL = str::Dup(param);
+            continue;
+        }
+#endif
+        if (arg == Arg::FirstPaintMarker) {
+            // to measure startup time (do -startup-bench)
+            i.firstPaintMarker = str::Dup(param);
+            continue;
+        }
         if (arg == Arg::ArgDeleteFile) {
             i.deleteFile = str::Dup(param);
             continue;
@@ -731,6 +751,8 @@ Flags::~Flags() {
     str::Free(stressTestRanges);
     str::Free(lang);
     str::Free(updateSelfTo);
+    str::Free(updateCheckURL);
+    str::Free(firstPaintMarker);
     str::Free(deleteFile);
     str::Free(search);
     str::Free(dde);
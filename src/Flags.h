@@ -88,6 +88,8 @@ struct Flags {
     char* dde = nullptr;
 
     bool crashOnOpen = false;
+    // exit after creating the main window, before running message loop
+    bool exitOnStartup = false;
 
     // deprecated flags
     char* lang = nullptr;
@@ -107,6 +109,10 @@ struct Flags {
 
     // for internal use
     char* updateSelfTo = nullptr;
+    // over-rides url of update info, for testing auto-update
+    char* updateCheckURL = nullptr;
+    // write time to first paint to this file and exit
+    char* firstPaintMarker = nullptr;
     char* deleteFile = nullptr;
 
     // for some commands, will sleep for sleepMs milliseconds
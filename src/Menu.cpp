@@ -440,7 +440,7 @@ MenuDef menuDefThemes[] = {
         0,
     },
 };
-//] ACCESSKEY_GROUP Favorites Menu
+//] ACCESSKEY_GROUP Themes Menu
 
 //[ ACCESSKEY_GROUP Settings Menu
 static MenuDef menuDefSettings[] = {
@@ -691,6 +691,7 @@ static MenuDef menuDefCreateAnnotFromSelection[] = {
 //[ ACCESSKEY_GROUP Context Menu (Create annot under cursor)
 static MenuDef menuDefCreateAnnotUnderCursor[] = {
     {
+        // TRANSLATORS: text (sticky note) annotation, in context menu under "Create Annotation"
         _TRN("&Text"),
         CmdCreateAnnotText,
     },
@@ -699,10 +700,12 @@ static MenuDef menuDefCreateAnnotUnderCursor[] = {
         CmdCreateAnnotFreeText,
     },
     {
+        // TRANSLATORS: rubber stamp annotation (e.g. "Approved")
         _TRN("&Stamp"),
         CmdCreateAnnotStamp,
     },
     {
+        // TRANSLATORS: caret (^) annotation that marks where text should be inserted
         _TRN("&Caret"),
         CmdCreateAnnotCaret,
     },
@@ -268,6 +268,7 @@ static INT_PTR CALLBACK Dialog_GoToPage_Proc(HWND hDlg, UINT msg, WPARAM wp, LPA
         }
         CrashIf(!data->currPageLabel);
         HwndSetDlgItemText(hDlg, IDC_GOTO_PAGE_EDIT, data->currPageLabel);
+        // TRANSLATORS: in Go To Page dialog, after page number edit box. %d is number of pages
         TempStr totalCount = str::FormatTemp(_TRA("(of %d)"), data->pageCount);
         HwndSetDlgItemText(hDlg, IDC_GOTO_PAGE_LABEL_OF, totalCount);
 
@@ -1146,6 +1146,16 @@ int APIENTRY WinMain(HINSTANCE hInstance, HINSTANCE, LPSTR, int) {
         SetAppDataPath(flags.appdataDir);
     }
 
+#if defined(DEBUG) || defined(PRE_RELEASE_VER)
+    if (flags.updateCheckURL) {
+        SetUpdateCheckURL(flags.updateCheckURL);
+    }
+#endif
+
+    if (flags.firstPaintMarker) {
+        SetFirstPaintMarker(flags.firstPaintMarker, flags.fileNames.Size() > 0);
+    }
+
 #if defined(DEBUG)
     if (flags.testApp) {
         // in TestApp.cpp
@@ -1393,6 +1403,11 @@ ContinueOpenWindow:
         FlagsEnterFullscreen(flags, win);
     }
 
+    if (flags.exitOnStartup) {
+        exitCode = 0;
+        goto Exit;
+    }
+
     if (flags.stressTestPath) {
         // don't save file history and preference changes
         RestrictPolicies(Perm::SavePreferences);
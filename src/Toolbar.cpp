@@ -467,6 +467,8 @@ void UpdateToolbarFindText(MainWindow* win) {
         return;
     }
 
+    // TRANSLATORS: label before search box in toolbar
+    // maxlen: 16
     const char* text = _TRA("Find:");
     HwndSetText(win->hwndFindLabel, text);
 
@@ -623,6 +625,8 @@ static LRESULT CALLBACK WndProcPageBox(HWND hwnd, UINT msg, WPARAM wp, LPARAM lp
 }
 
 void UpdateToolbarPageText(MainWindow* win, int pageCount, bool updateOnly) {
+    // TRANSLATORS: label before page number box in toolbar
+    // maxlen: 16
     const char* text = _TRA("Page:");
     if (!updateOnly) {
         HwndSetText(win->hwndPageLabel, text);
@@ -248,6 +248,54 @@ bool IsLangRtl(int idx)
   return (3 == idx) || (31 == idx) || (40 == idx) || (49 == idx);
 }
 
+// index in pluralRules in do/trans_plural.go
+const unsigned char gLangPluralRules[kLangsCount] = {
+  1, 1, 1, 10, 2, 1, 1, 3, 2, 3, 1, 0, 1, 1, 0, 0, 1, 1, 3, 4, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 13, 2, 1, 0, 11, 1, 0, 0, 0, 1, 7, 6, 14, 1, 0, 1, 1, 1, 2, 5, 2, 1, 2, 8, 3, 3, 3, 1, 2, 4, 9, 1, 1, 15, 1, 0, 1, 3, 1, 0, 12
+};
+
+// index of plural form of n in translations of language idx
+int GetPluralForm(int idx, int n)
+{
+  if (n < 0) {
+    n = -n;
+  }
+  switch (gLangPluralRules[idx]) {
+    case 0:
+      return 0;
+    case 1:
+      return (n != 1);
+    case 2:
+      return (n > 1);
+    case 3:
+      return (n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);
+    case 4:
+      return (n==1 ? 0 : n>=2 && n<=4 ? 1 : 2);
+    case 5:
+      return (n==1 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);
+    case 6:
+      return (n%10==1 && (n%100<11 || n%100>19) ? 0 : n%10>=2 && (n%100<11 || n%100>19) ? 1 : 2);
+    case 7:
+      return (n%10==0 || (n%100>=11 && n%100<=19) ? 0 : n%10==1 && n%100!=11 ? 1 : 2);
+    case 8:
+      return (n==1 ? 0 : n==0 || (n%100>=2 && n%100<=19) ? 1 : 2);
+    case 9:
+      return (n%100==1 ? 0 : n%100==2 ? 1 : n%100==3 || n%100==4 ? 2 : 3);
+    case 10:
+      return (n==0 ? 0 : n==1 ? 1 : n==2 ? 2 : n%100>=3 && n%100<=10 ? 3 : n%100>=11 ? 4 : 5);
+    case 11:
+      return (n==1 ? 0 : n==2 ? 1 : n>=3 && n<=6 ? 2 : n>=7 && n<=10 ? 3 : 4);
+    case 12:
+      return (n==0 ? 0 : n==1 ? 1 : n==2 ? 2 : n==3 ? 3 : n==6 ? 4 : 5);
+    case 13:
+      return (n==1 ? 0 : n==2 ? 1 : 2);
+    case 14:
+      return (n%10==1 && n%100!=11 ? 0 : 1);
+    case 15:
+      return (n%10!=4 && n%10!=6 && n%10!=9 ? 0 : 1);
+  }
+  return 0;
+}
+
 int gLangsCount = kLangsCount;
 
 const LANGID *GetLangIds() { return &gLangIds[0]; }
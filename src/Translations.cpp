@@ -2,8 +2,11 @@
    License: Simplified BSD (see COPYING.BSD) */
 
 #include "utils/BaseUtil.h"
+#include "utils/FileUtil.h"
 #include "utils/WinUtil.h"
+#include "AppTools.h"
 #include "Translations.h"
+#include "Version.h"
 
 #include "utils/Log.h"
 
@@ -15,10 +18,14 @@ extern const char* gLangNames;
 extern const char* gLangCodes;
 extern const LANGID* GetLangIds();
 extern bool IsLangRtl(int langIdx);
+extern int GetPluralForm(int langIdx, int n);
 } // namespace trans
 
 constexpr u16 kIdxMissing = 0xffff;
 
+// \| in translations.txt, separates plural forms (see do/trans_plural.go)
+constexpr char kPluralSep = '\x1f';
+
 namespace trans {
 
 // translation info about a single string
@@ -30,6 +37,8 @@ struct Translation {
     // translation of str/origStr in gCurrLangCode
     // index in allTranslations
     u16 idxTrans = 0;
+    // for plural strings, forms are at idxTrans, idxTrans + 1 etc.
+    u8 nPluralForms = 0;
 };
 
 struct TranslationCache {
@@ -40,11 +49,20 @@ struct TranslationCache {
     Translation* translations = nullptr;
     int nTranslations = 0;
     int nUntranslated = 0;
+    int nMachineTranslated = 0;
 };
 
 // used locally, gCurrLangCode points into gLangCodes
 static const char* gCurrLangCode = nullptr;
 static int gCurrLangIdx = 0;
+
+// pseudo-localized translations (accented, longer, in [ ]) for finding UI bugs.
+// Generated with .\doit.bat -gen trans-pseudo, only in debug and pre-release builds
+#if defined(DEBUG) || defined(PRE_RELEASE_VER)
+#define ENABLE_PSEUDO_LANG 1
+static const char* kPseudoLangCode = "qps-ploc";
+#endif
+static bool gIsPseudoLang = false;
 static TranslationCache* gTranslationCache = nullptr;
 
 static TempStr UnescapeTemp(char* sOrig) {
@@ -72,6 +90,9 @@ static TempStr UnescapeTemp(char* sOrig) {
             case 'r':
                 *dst++ = '\r';
                 break;
+            case '|':
+                *dst++ = kPluralSep;
+                break;
             default:
                 *dst++ = c;
                 break;
@@ -91,6 +112,9 @@ static void FreeTranslations() {
 }
 
 static void ParseTranslationsTxt(const ByteSlice& d, const char* langCode) {
+    // machine translation
+    const char* langCodeMt = str::JoinTemp(langCode, "*:");
+    int nLangCodeMt = str::Len(langCodeMt);
     langCode = str::JoinTemp(langCode, ":");
     int nLangCode = str::Len(langCode);
 
@@ -125,11 +149,15 @@ static void ParseTranslationsTxt(const ByteSlice& d, const char* langCode) {
         orig += 1; // skip the ':' at the beginning
         i++;
         trans = nullptr;
+        bool isMachine = false;
         while (i < nLines && lines[i][0] != ':') {
             if (!trans) {
                 line = lines[i];
                 if (str::StartsWith(line, langCode)) {
                     trans = line + nLangCode;
+                } else if (str::StartsWith(line, langCodeMt)) {
+                    trans = line + nLangCodeMt;
+                    isMachine = true;
                 }
             }
             i++;
@@ -151,13 +179,29 @@ static void ParseTranslationsTxt(const ByteSlice& d, const char* langCode) {
         int idxTrans = c->allTranslations.Size();
         CrashIf(idxTrans > 64 * 1024);
         translation.idxTrans = (u16)idxTrans;
+        if (isMachine) {
+            c->nMachineTranslated++;
+        }
         unescaped = UnescapeTemp(trans);
-        c->allTranslations.Append(unescaped);
+        if (!str::FindChar(unescaped, kPluralSep)) {
+            c->allTranslations.Append(unescaped);
+            continue;
+        }
+        StrVec forms;
+        char sep[2] = {kPluralSep, 0};
+        Split(forms, unescaped, sep);
+        for (char* form : forms) {
+            c->allTranslations.Append(form);
+        }
+        translation.nPluralForms = (u8)forms.Size();
     }
     CrashIf(nTrans != c->nTranslations);
     if (c->nUntranslated > 0 && !str::Eq(langCode, "en:")) {
         logf("Untranslated strings: %d for lang '%s'\n", c->nUntranslated, langCode);
     }
+    if (c->nMachineTranslated > 0) {
+        logf("Machine translated strings: %d for lang '%s'\n", c->nMachineTranslated, langCode);
+    }
 }
 
 static Translation* FindTranslation(const char* s) {
@@ -177,7 +221,7 @@ static Translation* FindTranslation(const char* s) {
 
 // don't free
 const char* GetTranslation(const char* s) {
-    if (gCurrLangIdx == 0) {
+    if (gCurrLangIdx == 0 && !gIsPseudoLang) {
         // 0 is english, no translation needed
         return s;
     }
@@ -191,6 +235,65 @@ const char* GetTranslation(const char* s) {
     return gTranslationCache->allTranslations.At((int)idx);
 }
 
+// singular and plural are English forms e.g. "%d file", "%d files"
+// returns the form for n in current language. don't free
+const char* GetPluralTranslation(const char* singular, const char* plural, int n) {
+    const char* english = (n == 1) ? singular : plural;
+    if (gCurrLangIdx == 0 && !gIsPseudoLang) {
+        return english;
+    }
+    char sep[2] = {kPluralSep, 0};
+    TempStr s = str::JoinTemp(singular, sep, plural);
+    Translation* trans = FindTranslation(s);
+    u32 idx = trans ? trans->idxTrans : kIdxMissing;
+    if (idx == kIdxMissing) {
+        logf("Didn't find translation for '%s'\n", plural);
+        return english;
+    }
+    int form = GetPluralForm(gCurrLangIdx, n);
+    // a translation without all forms of the language uses the last one
+    int nForms = std::max((int)trans->nPluralForms, 1);
+    form = std::min(form, nForms - 1);
+    return gTranslationCache->allTranslations.At((int)idx + form);
+}
+
+// where a pack downloaded during update check is installed (see UpdateLangPack()
+// in UpdateCheck.cpp). Returns nullptr if there's no app data directory
+TempStr GetLangPackPathTemp(const char* langCode) {
+    TempStr dir = AppGenDataFilenameTemp("langpacks");
+    if (!dir) {
+        return nullptr;
+    }
+    return path::JoinTemp(dir, str::JoinTemp(langCode, ".txt"));
+}
+
+// translations of a single language downloaded separately from the exe,
+// with the same format as embedded translations (see do/trans_packs.go)
+// Strings change between versions so we only use packs made for this version
+static ByteSlice LoadLangPack(const char* langCode) {
+    TempStr path = GetLangPackPathTemp(langCode);
+    if (!path) {
+        return {};
+    }
+    ByteSlice d = file::ReadFile(path);
+    if (d.empty()) {
+        return {};
+    }
+    if (!str::StartsWith((const char*)d.data(), "AppTranslator: SumatraPDF")) {
+        logf("LoadLangPack: invalid language pack '%s'\n", path);
+        d.Free();
+        return {};
+    }
+    const char* header = "AppTranslator: SumatraPDF\nVersion: " CURR_VERSION_MAJOR_STRA "\n";
+    if (!str::StartsWith((const char*)d.data(), header)) {
+        logf("LoadLangPack: ignoring '%s', not for version %s\n", path, CURR_VERSION_MAJOR_STRA);
+        d.Free();
+        return {};
+    }
+    logf("LoadLangPack: using '%s'\n", path);
+    return d;
+}
+
 int GetLangsCount() {
     return gLangsCount;
 }
@@ -204,6 +307,22 @@ void SetCurrentLangByCode(const char* langCode) {
         return;
     }
 
+#if defined(ENABLE_PSEUDO_LANG)
+    if (str::Eq(langCode, kPseudoLangCode)) {
+        // IDR_TRANSLATIONS_PSEUDO
+        ByteSlice d = LoadDataResource(4);
+        if (!d.empty()) {
+            gIsPseudoLang = true;
+            gCurrLangIdx = 0;
+            gCurrLangCode = kPseudoLangCode;
+            ParseTranslationsTxt(d, langCode);
+            free(d.data());
+            return;
+        }
+    }
+#endif
+    gIsPseudoLang = false;
+
     int idx = seqstrings::StrToIdx(gLangCodes, langCode);
     if (idx < 0) {
         logf("SetCurrentLangByCode: unknown lang code: '%s'\n", langCode);
@@ -214,13 +333,22 @@ void SetCurrentLangByCode(const char* langCode) {
     gCurrLangIdx = idx;
     gCurrLangCode = GetLangCodeByIdx(idx);
 
-    ByteSlice d = LoadDataResource(2);
+    // gCurrLangCode because langCode is not validated
+    ByteSlice d = LoadLangPack(gCurrLangCode);
+    if (d.empty()) {
+        d = LoadDataResource(2);
+    }
     CrashIf(d.empty());
     ParseTranslationsTxt(d, langCode);
     free(d.data());
 }
 
 const char* ValidateLangCode(const char* langCode) {
+#if defined(ENABLE_PSEUDO_LANG)
+    if (str::Eq(langCode, kPseudoLangCode)) {
+        return kPseudoLangCode;
+    }
+#endif
     int idx = seqstrings::StrToIdx(gLangCodes, langCode);
     if (idx < 0) {
         return nullptr;
@@ -271,3 +399,7 @@ void Destroy() {
 const char* _TRA(const char* s) {
     return trans::GetTranslation(s);
 }
+
+const char* _TRP(const char* singular, const char* plural, int n) {
+    return trans::GetPluralTranslation(singular, plural, n);
+}
@@ -11,16 +11,21 @@ void SetCurrentLangByCode(const char* langCode);
 const char* ValidateLangCode(const char* langCode);
 
 const char* GetTranslation(const char* s);
+const char* GetPluralTranslation(const char* singular, const char* plural, int n);
 const char* GetLangCodeByIdx(int idx);
 const char* GetLangNameByIdx(int idx);
 bool IsCurrLangRtl();
 const char* DetectUserLang();
+TempStr GetLangPackPathTemp(const char* langCode);
 void Destroy();
 
 } // namespace trans
 
 // _TRA() is like _TR() but returns Utf8 version
 const char* _TRA(const char* s);
+// _TRP() returns translation of singular or plural form for number n e.g.
+// str::FormatTemp(_TRP("%d file", "%d files", n), n)
+const char* _TRP(const char* singular, const char* plural, int n);
 #define _TR_TODO(quote) L##quote
 #define _TR_TODON(quote) quote
 
@@ -8,6 +8,8 @@
 #include "utils/HttpUtil.h"
 #include "utils/WinUtil.h"
 #include "utils/FileUtil.h"
+#include "utils/JsonParser.h"
+#include "utils/CryptoUtil.h"
 
 #include "wingui/Layout.h"
 #include "wingui/UIModels.h"
@@ -57,6 +59,19 @@ constexpr const char* kUpdateInfoURL2 = "https://sumatra-website.onrender.com/up
 #endif
 // clang-format on
 
+// set with -update-check-url to test auto-update with a local server
+// release builds only accept update info from our servers
+#if defined(DEBUG) || defined(PRE_RELEASE_VER)
+static const char* gUpdateCheckURL = nullptr;
+
+void SetUpdateCheckURL(const char* url) {
+    str::ReplaceWithCopy(&gUpdateCheckURL, url);
+    slogf(LogLevel::Info, "update", "SetUpdateCheckURL: '%s'\n", url);
+}
+#else
+constexpr const char* gUpdateCheckURL = nullptr;
+#endif
+
 // prevent multiple update tasks from happening simultaneously
 // (this might e.g. happen if a user checks manually very quickly after startup)
 bool gUpdateCheckInProgress = false;
@@ -149,7 +164,7 @@ static UpdateInfo* ParseUpdateInfo(const char* d) {
 
 static bool ShouldCheckForUpdate(UpdateCheck updateCheckType) {
     if (gUpdateCheckInProgress) {
-        logf("CheckForUpdate: skipping because gUpdateCheckInProgress\n");
+        slogf(LogLevel::Info, "update", "CheckForUpdate: skipping because gUpdateCheckInProgress\n");
         return false;
     }
 
@@ -158,7 +173,7 @@ static bool ShouldCheckForUpdate(UpdateCheck updateCheckType) {
     }
 
     if (!HasPermission(Perm::InternetAccess)) {
-        logf("CheckForUpdate: skipping because no internet access\n");
+        slogf(LogLevel::Info, "update", "CheckForUpdate: skipping because no internet access\n");
         return false;
     }
 
@@ -169,7 +184,7 @@ static bool ShouldCheckForUpdate(UpdateCheck updateCheckType) {
     // don't check if the timestamp or version to skip can't be updated
     // (mainly in plugin mode, stress testing and restricted settings)
     if (!HasPermission(Perm::SavePreferences)) {
-        logf("CheckForUpdate: skipping auto check because no prefs access\n");
+        slogf(LogLevel::Info, "update", "CheckForUpdate: skipping auto check because no prefs access\n");
         return false;
     }
 
@@ -196,8 +211,9 @@ static bool ShouldCheckForUpdate(UpdateCheck updateCheckType) {
     int secsBetweenChecks = gIsPreReleaseBuild ? kSecondsInWeek : kSecondsInDay;
     bool checkUpdate = secsSinceLastUpdate > secsBetweenChecks;
 #if 0
-    logf("CheckForUpdate: secsBetweenChecks: %d, secsSinceLastUpdate: %d, checkUpdate: %d\n", secsBetweenChecks,
-         secsSinceLastUpdate, (int)checkUpdate);
+    slogf(LogLevel::Info, "update",
+          "CheckForUpdate: secsBetweenChecks: %d, secsSinceLastUpdate: %d, checkUpdate: %d\n", secsBetweenChecks,
+          secsSinceLastUpdate, (int)checkUpdate);
 #endif
     return checkUpdate;
 }
@@ -282,7 +298,7 @@ static void NotifyUserOfUpdate(UpdateInfo* updateInfo) {
         // our process to exit
         cmd.AppendFmt(R"( -sleep-ms 500 -exit-when-done -update-self-to "%s")", GetExePathTemp());
     }
-    logf("NotifyUserOfUpdate: installer cmd: '%s'\n", cmd.Get());
+    slogf(LogLevel::Info, "update", "NotifyUserOfUpdate: installer cmd: '%s'\n", cmd.Get());
     CreateProcessHelper(installerPath, cmd.Get());
     PostQuitMessage(0);
 }
@@ -298,28 +314,36 @@ static DWORD ShowAutoUpdateDialog(HWND hwndParent, HttpRsp* rsp, UpdateCheck upd
     const char* url = rsp->url.Get();
 
     if (rsp->error != 0) {
-        logf("ShowAutoUpdateDialog: http get of '%s' failed with %d\n", url, (int)rsp->error);
+        slogf(LogLevel::Error, "update", "ShowAutoUpdateDialog: http get of '%s' failed with %d\n", url,
+              (int)rsp->error);
         return rsp->error;
     }
     if (rsp->httpStatusCode != 200) {
-        logf("ShowAutoUpdateDialog: http get of '%s' failed with code %d\n", url, (int)rsp->httpStatusCode);
+        slogf(LogLevel::Error, "update", "ShowAutoUpdateDialog: http get of '%s' failed with code %d\n", url,
+              (int)rsp->httpStatusCode);
         return ERROR_INTERNET_INVALID_URL;
     }
 
     bool isValidURL = str::StartsWith(url, kUpdateInfoURL) || str::StartsWith(url, kUpdateInfoURL2);
+#if defined(DEBUG) || defined(PRE_RELEASE_VER)
+    if (gUpdateCheckURL) {
+        isValidURL = str::StartsWith(url, gUpdateCheckURL);
+    }
+#endif
     if (!isValidURL) {
-        logf("ShowAutoUpdateDialog: '%s' is not a valid url\n", url);
+        slogf(LogLevel::Error, "update", "ShowAutoUpdateDialog: '%s' is not a valid url\n", url);
         return ERROR_INTERNET_INVALID_URL;
     }
     str::Str* data = &rsp->data;
     if (0 == data->size()) {
-        logf("ShowAutoUpdateDialog: empty response from url '%s'\n", url);
+        slogf(LogLevel::Error, "update", "ShowAutoUpdateDialog: empty response from url '%s'\n", url);
         return ERROR_INTERNET_CONNECTION_ABORTED;
     }
 
     UpdateInfo* updateInfo = ParseUpdateInfo(data->Get());
     if (!updateInfo) {
-        logf("ShowAutoUpdateDialog: ParseUpdateInfo() failed. URL: '%s'\nAuto update data:\n%s\n", url, data->Get());
+        slogf(LogLevel::Error, "update",
+              "ShowAutoUpdateDialog: ParseUpdateInfo() failed. URL: '%s'\nAuto update data:\n%s\n", url, data->Get());
         return ERROR_INTERNET_INCORRECT_FORMAT;
     }
     updateInfo->hwndParent = hwndParent;
@@ -336,7 +360,8 @@ static DWORD ShowAutoUpdateDialog(HWND hwndParent, HttpRsp* rsp, UpdateCheck upd
         // myVer = L"3.1"; // for ad-hoc debugging of auto-update code
         bool hasUpdate = CompareVersion(latestVer, myVer) > 0;
         if (!hasUpdate) {
-            logf("ShowAutoUpdateDialog: myVer >= latestVer ('%s' >= '%s')\n", myVer, latestVer);
+            slogf(LogLevel::Info, "update", "ShowAutoUpdateDialog: myVer >= latestVer ('%s' >= '%s')\n", myVer,
+                  latestVer);
             /* if automated => don't notify that there is no new version */
             if (updateCheckType == UpdateCheck::UserInitiated) {
                 RemoveNotificationsForGroup(hwndForNotif, kindNotifUpdateCheckInProgress);
@@ -349,8 +374,9 @@ static DWORD ShowAutoUpdateDialog(HWND hwndParent, HttpRsp* rsp, UpdateCheck upd
         if (updateCheckType == UpdateCheck::Automatic) {
             // if user wanted to skip this version, we skip it in automated check
             if (str::EqI(gGlobalPrefs->versionToSkip, latestVer)) {
-                logf("ShowAutoUpdateDialog: skipping auto-update of ver '%s' because of gGlobalPrefs->versionToSkip\n",
-                     latestVer);
+                slogf(LogLevel::Info, "update",
+                      "ShowAutoUpdateDialog: skipping auto-update of ver '%s' because of gGlobalPrefs->versionToSkip\n",
+                      latestVer);
                 return 0;
             }
         }
@@ -358,14 +384,15 @@ static DWORD ShowAutoUpdateDialog(HWND hwndParent, HttpRsp* rsp, UpdateCheck upd
 
     if (!updateInfo->dlURL) {
         // shouldn't happen but it's fine, we just tell the user
-        logf("ShowAutoUpdateDialog: didn't find download url. Auto update data:\n%s\n", data->Get());
+        slogf(LogLevel::Error, "update", "ShowAutoUpdateDialog: didn't find download url. Auto update data:\n%s\n",
+              data->Get());
         RemoveNotificationsForGroup(win->hwndCanvas, kindNotifUpdateCheckInProgress);
         NotifyUserOfUpdate(updateInfo);
         return 0;
     }
 
     // download the installer to make update feel instant to the user
-    logf("ShowAutoUpdateDialog: starting to download '%s'\n", updateInfo->dlURL);
+    slogf(LogLevel::Info, "update", "ShowAutoUpdateDialog: starting to download '%s'\n", updateInfo->dlURL);
     gUpdateCheckInProgress = true;
     RunAsync([hwndForNotif, updateInfo] { // NOLINT
         TempStr installerPath = path::GetTempFilePathTemp("sumatra-installer");
@@ -373,7 +400,8 @@ static DWORD ShowAutoUpdateDialog(HWND hwndParent, HttpRsp* rsp, UpdateCheck upd
         // with "runas"
         installerPath = str::JoinTemp(installerPath, ".exe");
         bool ok = HttpGetToFile(updateInfo->dlURL, installerPath);
-        logf("ShowAutoUpdateDialog: HttpGetToFile(): ok=%d, downloaded to '%s'\n", (int)ok, installerPath);
+        slogf(LogLevel::Info, "update", "ShowAutoUpdateDialog: HttpGetToFile(): ok=%d, downloaded to '%s'\n", (int)ok,
+              installerPath);
         if (ok) {
             updateInfo->installerPath = str::Dup(installerPath);
         } else {
@@ -391,6 +419,112 @@ static DWORD ShowAutoUpdateDialog(HWND hwndParent, HttpRsp* rsp, UpdateCheck upd
     return 0;
 }
 
+// translation packs for this version, see do/trans_packs.go
+constexpr const char* kLangPacksURL = "https://www.sumatrapdfreader.org/dl/langpacks/" CURR_VERSION_MAJOR_STRA "/";
+// must match langPacksFormat in do/trans_packs.go
+constexpr int kLangPacksFormat = 1;
+
+// extracts info about the pack for lang from langpacks.json
+struct LangPacksParser : json::ValueVisitor {
+    const char* lang = nullptr;
+    int format = 0;
+    int langIdx = -1;
+    AutoFreeStr file;
+    AutoFreeStr sha256;
+
+    // json::ValueVisitor
+    bool Visit(const char* path, const char* value, json::Type type) override;
+};
+
+bool LangPacksParser::Visit(const char* path, const char* value, json::Type type) {
+    if (json::Type::Number == type && str::Eq(path, "/format")) {
+        format = atoi(value);
+        return true;
+    }
+    int idx = -1;
+    const char* prop = str::Parse(path, "/langs[%d]/", &idx);
+    if (!prop || json::Type::String != type) {
+        return true;
+    }
+    if (str::Eq(prop, "lang") && str::Eq(value, lang)) {
+        langIdx = idx;
+    } else if (idx == langIdx && str::Eq(prop, "file")) {
+        file.Set(str::Dup(value));
+    } else if (idx == langIdx && str::Eq(prop, "sha256")) {
+        sha256.Set(str::Dup(value));
+    }
+    // stop parsing once we have all desired information
+    return !file || !sha256;
+}
+
+static bool IsSha256Of(const ByteSlice& d, const char* sha256Hex) {
+    u8 digest[32];
+    CalcSHA2Digest(d.data(), (int)d.size(), digest);
+    AutoFreeStr hex = str::MemToHex(digest, dimof(digest));
+    return str::EqI(hex, sha256Hex);
+}
+
+// downloads translations of the current language if they changed since
+// we last downloaded them. They are used after restart, see LoadLangPack()
+// in Translations.cpp. Called on a background thread during update check
+static void UpdateLangPack() {
+    const char* lang = trans::GetCurrentLangCode();
+    if (str::Eq(lang, "en")) {
+        // english strings are in the source code
+        return;
+    }
+    TempStr path = trans::GetLangPackPathTemp(lang);
+    if (!path) {
+        return;
+    }
+    TempStr url = str::JoinTemp(kLangPacksURL, "langpacks.json");
+    HttpRsp rsp;
+    if (!HttpGet(url, &rsp)) {
+        slogf(LogLevel::Error, "update", "UpdateLangPack: http get of '%s' failed\n", url);
+        return;
+    }
+    LangPacksParser parser;
+    parser.lang = lang;
+    json::Parse(rsp.data.Get(), &parser);
+    if (parser.format != kLangPacksFormat || !parser.file || !parser.sha256) {
+        slogf(LogLevel::Info, "update", "UpdateLangPack: no pack for '%s' in '%s'\n", lang, url);
+        return;
+    }
+    ByteSlice curr = file::ReadFile(path);
+    bool upToDate = !curr.empty() && IsSha256Of(curr, parser.sha256);
+    curr.Free();
+    if (upToDate) {
+        return;
+    }
+    // file is "${lang}-${sha256}.txt", relative to kLangPacksURL
+    if (str::FindChar(parser.file, '/') || str::FindChar(parser.file, '\\')) {
+        slogf(LogLevel::Error, "update", "UpdateLangPack: invalid file '%s'\n", parser.file.Get());
+        return;
+    }
+    url = str::JoinTemp(kLangPacksURL, parser.file);
+    HttpRsp packRsp;
+    if (!HttpGet(url, &packRsp)) {
+        slogf(LogLevel::Error, "update", "UpdateLangPack: http get of '%s' failed\n", url);
+        return;
+    }
+    ByteSlice pack = packRsp.data.AsByteSlice();
+    if (!IsSha256Of(pack, parser.sha256)) {
+        slogf(LogLevel::Error, "update", "UpdateLangPack: '%s' doesn't match sha256 '%s'\n", url, parser.sha256.Get());
+        return;
+    }
+    // write to a temporary file first so that we never use a partially written pack
+    TempStr tmpPath = str::JoinTemp(path, ".tmp");
+    bool ok = dir::CreateForFile(path) && file::WriteFile(tmpPath, pack);
+    DWORD flags = MOVEFILE_REPLACE_EXISTING;
+    ok = ok && MoveFileExW(ToWStrTemp(tmpPath), ToWStrTemp(path), flags);
+    if (!ok) {
+        LogLastError();
+        file::Delete(tmpPath);
+        return;
+    }
+    slogf(LogLevel::Info, "update", "UpdateLangPack: installed '%s' as '%s'\n", url, path);
+}
+
 static void BuildUpdateURL(str::Str& url, const char* baseURL, UpdateCheck updateCheckType) {
     url = baseURL;
     url.Append("?v=");
@@ -444,12 +578,13 @@ void CheckForUpdateAsync(MainWindow* win, UpdateCheck updateCheckType) {
     HWND hwnd = win->hwndFrame;
     RunAsync([=] {
         str::Str url;
-        BuildUpdateURL(url, kUpdateInfoURL2, updateCheckType);
+        const char* baseURL = gUpdateCheckURL ? gUpdateCheckURL : kUpdateInfoURL2;
+        BuildUpdateURL(url, baseURL, updateCheckType);
         char* uri = url.Get();
         HttpRsp* rsp = new HttpRsp;
         rsp->url.SetCopy(uri);
         bool ok = HttpGet(uri, rsp);
-        if (!ok) {
+        if (!ok && !gUpdateCheckURL) {
             delete rsp;
             BuildUpdateURL(url, kUpdateInfoURL, updateCheckType);
             uri = url.Get();
@@ -457,6 +592,7 @@ void CheckForUpdateAsync(MainWindow* win, UpdateCheck updateCheckType) {
             rsp->url.SetCopy(uri);
             HttpGet(uri, rsp);
         }
+        UpdateLangPack();
         uitask::Post(TaskCheckForUpdateAsync, [=] {
             DWORD err = ShowAutoUpdateDialog(hwnd, rsp, updateCheckType);
             if ((err != 0) && (updateCheckType == UpdateCheck::UserInitiated)) {
@@ -475,12 +611,12 @@ void CheckForUpdateAsync(MainWindow* win, UpdateCheck updateCheckType) {
 void UpdateSelfTo(const char* path) {
     CrashIf(!path);
     if (!file::Exists(path)) {
-        logf("UpdateSelfTo: failed because destination doesn't exist\n");
+        slogf(LogLevel::Error, "update", "UpdateSelfTo: failed because destination doesn't exist\n");
         return;
     }
 
     auto sleepMs = gCli->sleepMs;
-    logf("UpdateSelfTo: '%s', sleep for %d ms\n", path, sleepMs);
+    slogf(LogLevel::Info, "update", "UpdateSelfTo: '%s', sleep for %d ms\n", path, sleepMs);
     // sleeping for a bit to make sure that the program that launched us
     // had time to exit so that we can overwrite it
     ::Sleep(gCli->sleepMs);
@@ -490,10 +626,10 @@ void UpdateSelfTo(const char* path) {
     // TODO: maybe retry if copy fails under the theory that the file
     // might be temporarily locked
     if (!ok) {
-        logf("UpdateSelfTo: failed to copy self to file\n");
+        slogf(LogLevel::Error, "update", "UpdateSelfTo: failed to copy self to file\n");
         return;
     }
-    logf("UpdateSelfTo: copied self to file\n");
+    slogf(LogLevel::Info, "update", "UpdateSelfTo: copied self to file\n");
 
     TempStr args = str::FormatTemp(R"(-sleep-ms 500 -delete-file "%s")", srcPath);
     CreateProcessHelper(path, args);
@@ -8,3 +8,6 @@ enum class UpdateCheck {
 
 void CheckForUpdateAsync(MainWindow* win, UpdateCheck updateCheckType);
 void UpdateSelfTo(const char* path);
+#if defined(DEBUG) || defined(PRE_RELEASE_VER)
+void SetUpdateCheckURL(const char* url);
+#endif
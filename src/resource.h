@@ -67,6 +67,7 @@
 #define IDR_DLL_PAK                     1
 #define IDR_TRANSLATIONS                2
 #define IDR_MANUAL_PAK                  3
+#define IDR_TRANSLATIONS_PSEUDO         4
 
 // Next default values for new objects
 //
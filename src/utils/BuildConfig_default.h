@@ -17,6 +17,9 @@ Defines we recognize:
 #define PRE_RELEASE_VER 10175
 #define VER_QUALIFIER x64
 #define GIT_COMMIT_ID 70cdc024f79167b607f59b77ea0b29dd155925cc
+#define CRASH_SUBMIT_SERVER "o123.ingest.sentry.io"
+#define CRASH_SUBMIT_PORT 443
+#define CRASH_SUBMIT_PATH "/api/456/minidump/?sentry_key=abc"
 
 Defines that can be over-written, but shouldn't:
 
@@ -61,7 +61,25 @@ static const char* getWinError(DWORD errCode) {
 }
 #endif
 
-static void logToPipe(const char* s, size_t n = 0) {
+static const char* gLogLevelNames[] = {"debug", "info", "warn", "error"};
+
+// milliseconds since unix epoch
+static i64 LogTimestampMs() {
+    FILETIME ft;
+    GetSystemTimeAsFileTime(&ft);
+    ULARGE_INTEGER t;
+    t.LowPart = ft.dwLowDateTime;
+    t.HighPart = ft.dwHighDateTime;
+    // FILETIME is 100 ns intervals since 1601-01-01
+    return (i64)((t.QuadPart - 116444736000000000ULL) / 10000);
+}
+
+// level and subsystem are sent as a structured record:
+// "L\t${level}\t${timestamp ms}\t${subsystem}\t${text}"
+// if rec is nullptr, s is sent as is (e.g. during crash handling
+// where we don't want to allocate)
+static void logToPipe(const char* s, size_t n = 0, str::Str* rec = nullptr, LogLevel level = LogLevel::Info,
+                      const char* subsystem = nullptr) {
     if (!gLogToPipe) {
         return;
     }
@@ -96,10 +114,17 @@ static void logToPipe(const char* s, size_t n = 0) {
 
     if (didConnect) {
         // logview accepts logging from anyone, so announce ourselves
-        TempStr initialMsg = str::FormatTemp("app: %s\n", gLogAppName);
+        TempStr initialMsg = str::FormatTemp("app: %s\npid: %d\n", gLogAppName, (int)GetCurrentProcessId());
         WriteFile(hLogPipe, initialMsg, (DWORD)str::Len(initialMsg), &cbWritten, nullptr);
     }
 
+    if (rec) {
+        rec->AppendFmt("L\t%s\t%lld\t%s\t", gLogLevelNames[(int)level], LogTimestampMs(), subsystem ? subsystem : "");
+        rec->Append(s, n);
+        s = rec->Get();
+        n = rec->size();
+    }
+
     DWORD cb = (DWORD)n;
     // TODO: what happens when we write more than the server can read?
     // should I loop if cbWritten < cb?
@@ -117,7 +142,7 @@ static void logToPipe(const char* s, size_t n = 0) {
     }
 }
 
-void log(const char* s, bool always) {
+static void logWithLevel(LogLevel level, const char* subsystem, const char* s, bool always) {
     bool skipLog = !always && gSkipDuplicateLines && gLogBuf && gLogBuf->Contains(s);
 
     if (!skipLog) {
@@ -176,10 +201,17 @@ void log(const char* s, bool always) {
             fclose(f);
         }
     }
-    logToPipe(s, n);
+    if (gLogToPipe) {
+        str::Str rec(n + 64, gLogAllocator);
+        logToPipe(s, n, &rec, level, subsystem);
+    }
     gLogMutex.Unlock();
 }
 
+void log(const char* s, bool always) {
+    logWithLevel(LogLevel::Info, nullptr, s, always);
+}
+
 void logf(const char* fmt, ...) {
     if (gReducedLogging || gStopLogging) {
         return;
@@ -192,6 +224,18 @@ void logf(const char* fmt, ...) {
     va_end(args);
 }
 
+void slogf(LogLevel level, const char* subsystem, const char* fmt, ...) {
+    if (gReducedLogging || gStopLogging) {
+        return;
+    }
+
+    va_list args;
+    va_start(args, fmt);
+    AutoFreeStr s = str::FmtV(fmt, args);
+    logWithLevel(level, subsystem, s.Get(), false);
+    va_end(args);
+}
+
 void logfa(const char* fmt, ...) {
     if (gStopLogging) {
         return;
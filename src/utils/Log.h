@@ -13,6 +13,16 @@ extern char* gLogFilePath;
 void StartLogToFile(const char* path, bool removeIfExists);
 bool WriteCurrentLogToFile(const char* path);
 
+// log() and logf() log at Info level without a subsystem.
+// Level and subsystem are only used by logview to filter logs,
+// see docs/md/Logview.md
+enum class LogLevel {
+    Debug = 0,
+    Info,
+    Warn,
+    Error,
+};
+
 /*
 If you do:
 
@@ -36,9 +46,14 @@ static inline void log(const WCHAR*) {
 static inline void logf(const WCHAR*, ...) {
     // do nothing
 }
+static inline void slogf(LogLevel, const char*, const char*, ...) {
+    // do nothing
+}
 #else
 void log(const char* s, bool always = false);
 void logf(const char* fmt, ...);
+// subsystem is e.g. "update", "print"
+void slogf(LogLevel level, const char* subsystem, const char* fmt, ...);
 #endif
 
 // always log, even if NO_LOG is defined
@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // App struct
@@ -26,6 +29,23 @@ func (a *App) Greet(name string) string {
 	return fmt.Sprintf("Hello %s, It's show time!", name)
 }
 
+// ExportLogs asks for a file name and saves s to it. Returns the path
+// or "" if cancelled
+func (a *App) ExportLogs(s string) (string, error) {
+	opts := runtime.SaveDialogOptions{
+		Title:           "Export logs",
+		DefaultFilename: "logview.txt",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "Text files (*.txt)", Pattern: "*.txt"},
+		},
+	}
+	path, err := runtime.SaveFileDialog(a.ctx, opts)
+	if err != nil || path == "" {
+		return "", err
+	}
+	return path, os.WriteFile(path, []byte(s), 0644)
+}
+
 func ctx() context.Context {
 	return context.Background()
 }
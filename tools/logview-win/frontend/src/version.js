@@ -1 +1 @@
-export const version = "0.1";
+export const version = "0.2";
@@ -1,4 +1,6 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function ExportLogs(arg1:string):Promise<string>;
+
 export function Greet(arg1:string):Promise<string>;
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/kjk/u"
 	"github.com/rodrigocfd/windigo/win"
@@ -24,20 +27,65 @@ func IsValidHandle(h win.HANDLE) bool {
 	return !invalid
 }
 
+// LogRecord is a log message, sent to the frontend
+type LogRecord struct {
+	Conn      int    `json:"conn"`
+	App       string `json:"app"`
+	Level     string `json:"level"`
+	Subsystem string `json:"subsystem"`
+	// milliseconds since unix epoch
+	Time int64  `json:"time"`
+	Text string `json:"text"`
+}
+
+// a message is either a structured record:
+// "L\t${level}\t${timestamp ms}\t${subsystem}\t${text}"
+// or raw text (older apps, crash handling), which is logged at info level
+func parseLogMessage(s string) *LogRecord {
+	res := &LogRecord{
+		Level: "info",
+		Time:  time.Now().UnixMilli(),
+		Text:  s,
+	}
+	if !strings.HasPrefix(s, "L\t") {
+		return res
+	}
+	parts := strings.SplitN(s, "\t", 5)
+	if len(parts) != 5 {
+		return res
+	}
+	ms, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return res
+	}
+	res.Level = parts[1]
+	res.Time = ms
+	res.Subsystem = parts[3]
+	res.Text = parts[4]
+	return res
+}
+
 func handlePipe(hPipe win.HPIPE, no int) {
 	ctx := gApp.ctx
 	var buf [kBufSize]byte
-	var cbBytesRead = 0
+	app := ""
 	for {
 		n, err := hPipe.ReadFile(buf[:], nil)
 		if err != nil {
-			s := fmt.Sprintf("ReadFile: returned %s\n", err)
-			runtime.EventsEmit(ctx, "plog", s, no)
+			r := parseLogMessage(fmt.Sprintf("ReadFile: returned %s\n", err))
+			r.Conn, r.App, r.Level = no, app, "error"
+			runtime.EventsEmit(ctx, "plog", r)
 			break
 		}
-		cbBytesRead += int(n)
-		d := buf[:int(n)]
-		runtime.EventsEmit(ctx, "plog", string(d), no)
+		s := string(buf[:int(n)])
+		// apps announce themselves with "app: ${name}\npid: ${pid}\n"
+		if name, ok := strings.CutPrefix(s, "app: "); ok && app == "" {
+			name, _, _ = strings.Cut(name, "\n")
+			app = strings.TrimSpace(name)
+		}
+		r := parseLogMessage(s)
+		r.Conn, r.App = no, app
+		runtime.EventsEmit(ctx, "plog", r)
 	}
 	hPipe.DisconnectNamedPipe()
 	hPipe.CloseHandle()
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/kjk/common/u"
 	"github.com/rodrigocfd/windigo/win"
@@ -16,19 +22,75 @@ const INVALID_HANDLE_VALUE = -1
 
 var wc sync.WaitGroup
 
+var levels = []string{"debug", "info", "warn", "error"}
+
+var (
+	flgLevel     string
+	flgSubsystem string
+	flgSearch    string
+	flgOut       string
+)
+
+var (
+	out   io.Writer = os.Stdout
+	outMu sync.Mutex
+)
+
+func levelIdx(level string) int {
+	for i, l := range levels {
+		if l == level {
+			return i
+		}
+	}
+	return 1
+}
+
+// a message is either a structured record:
+// "L\t${level}\t${timestamp ms}\t${subsystem}\t${text}"
+// or raw text, which is logged at info level
+func parseLogMessage(s string) (level string, t time.Time, subsystem string, text string) {
+	level, t, text = "info", time.Now(), s
+	if !strings.HasPrefix(s, "L\t") {
+		return
+	}
+	parts := strings.SplitN(s, "\t", 5)
+	if len(parts) != 5 {
+		return
+	}
+	ms, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+	return parts[1], time.UnixMilli(ms), parts[3], parts[4]
+}
+
+func logMessage(s string) {
+	level, t, subsystem, text := parseLogMessage(s)
+	if levelIdx(level) < levelIdx(flgLevel) {
+		return
+	}
+	if flgSubsystem != "" && subsystem != flgSubsystem {
+		return
+	}
+	if flgSearch != "" && !strings.Contains(strings.ToLower(text), strings.ToLower(flgSearch)) {
+		return
+	}
+	text = strings.TrimSuffix(text, "\n")
+	outMu.Lock()
+	fmt.Fprintf(out, "%s %-5s %-10s %s\n", t.Format("15:04:05.000"), level, subsystem, text)
+	outMu.Unlock()
+}
+
 func handlePipe(hPipe win.HPIPE) {
 	fmt.Printf("handlePipe\n")
 	var buf [kBufSize]byte
-	var cbBytesRead = 0
 	for {
 		n, err := hPipe.ReadFile(buf[:], nil)
 		if err != nil {
 			fmt.Printf("ReadFile: returned %s\n", err)
 			break
 		}
-		cbBytesRead += int(n)
-		d := buf[:int(n)]
-		fmt.Printf("%s", string(d))
+		logMessage(string(buf[:int(n)]))
 	}
 	hPipe.DisconnectNamedPipe()
 	hPipe.CloseHandle()
@@ -49,7 +111,18 @@ func IsValidHandle(h win.HANDLE) bool {
 }
 
 func main() {
+	flag.StringVar(&flgLevel, "level", "debug", "only show logs at this level or higher (debug, info, warn, error)")
+	flag.StringVar(&flgSubsystem, "subsystem", "", "only show logs of this subsystem")
+	flag.StringVar(&flgSearch, "search", "", "only show logs containing this text")
+	flag.StringVar(&flgOut, "out", "", "also write logs to this file")
+	flag.Parse()
 	fmt.Printf("Logview for SumatraPDF\n")
+	if flgOut != "" {
+		f, err := os.Create(flgOut)
+		u.Must(err)
+		defer f.Close()
+		out = io.MultiWriter(os.Stdout, f)
+	}
 	var hPipe win.HPIPE
 	var err error
 	for {